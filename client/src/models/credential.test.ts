@@ -0,0 +1,14 @@
+import {Credential} from './credential';
+
+describe('Credential', () => {
+  it('is active until revoked', async () => {
+    expect(new Credential('a1', 'Laptop', 0).isActive()).toBe(true);
+    expect(new Credential('a1', 'Laptop', 0, 0, 1640995200).isActive()).toBe(false);
+  });
+
+  it('has never been used by default', async () => {
+    const credential = new Credential('a1', 'Laptop', 0);
+    expect(credential.lastUsedAt).toBeNull();
+    expect(credential.password).toBeNull();
+  });
+});
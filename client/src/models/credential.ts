@@ -0,0 +1,25 @@
+export class Credential {
+  id: string;
+  name: string;
+  createdAt: Date;
+  lastUsedAt: Date|null;
+  revokedAt: Date|null;
+  password: string|null;
+
+  public constructor(id: string, name: string, unixCreatedAt: number, unixLastUsedAt: number = 0, unixRevokedAt: number = 0, password: string|null = null) {
+    this.id = id;
+    this.name = name;
+    this.createdAt = new Date(unixCreatedAt * 1000);
+    this.lastUsedAt = unixLastUsedAt > 0 ? new Date(unixLastUsedAt * 1000) : null;
+    this.revokedAt = unixRevokedAt > 0 ? new Date(unixRevokedAt * 1000) : null;
+    if (password != null && password.length > 0) {
+      this.password = password;
+    } else {
+      this.password = null;
+    }
+  }
+
+  isActive() : boolean {
+    return this.revokedAt == null;
+  }
+}
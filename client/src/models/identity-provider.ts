@@ -0,0 +1,11 @@
+export class IdentityProvider {
+  id: string;
+  name: string;
+  loginURL: string;
+
+  public constructor(id: string, name: string, loginURL: string) {
+    this.id = id;
+    this.name = name;
+    this.loginURL = loginURL;
+  }
+}
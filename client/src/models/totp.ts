@@ -0,0 +1,23 @@
+export class TOTPStatus {
+  enabled: boolean;
+  pending: boolean;
+  enabledAt: Date|null;
+
+  public constructor(enabled: boolean, pending: boolean, unixEnabledAt: number = 0) {
+    this.enabled = enabled;
+    this.pending = pending;
+    this.enabledAt = unixEnabledAt > 0 ? new Date(unixEnabledAt * 1000) : null;
+  }
+}
+
+export class TOTPEnrollment {
+  secret: string;
+  url: string;
+  qrCode: string;
+
+  public constructor(secret: string, url: string, qrCode: string) {
+    this.secret = secret;
+    this.url = url;
+    this.qrCode = qrCode;
+  }
+}
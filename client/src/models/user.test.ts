@@ -10,4 +10,14 @@ describe('User', () => {
     const user = new User('email@email.com', 'name', 'https://picture.url/somewhere', 0, 0, '');
     expect(user.password).toBeNull();
   });
+
+  it('has no expiry by default', async () => {
+    const user = new User('email@email.com', 'name', 'https://picture.url/somewhere', 0, 0, null);
+    expect(user.expiresAt).toBeNull();
+  });
+
+  it('converts unix expiry to date', async () => {
+    const user = new User('email@email.com', 'name', 'https://picture.url/somewhere', 0, 0, null, 1640995200);
+    expect(user.expiresAt).toEqual(new Date('2022-01-01T00:00:00Z'));
+  });
 });
@@ -5,8 +5,9 @@ export class User {
   lastSeenAt: Date;
   passwordUpdatedAt: Date;
   password: string|null;
+  expiresAt: Date|null;
 
-  public constructor(email: string, name: string, picture: string, unixLastSeenAt: number, unixPasswordUpdatedAt: number, password: string|null = null) {
+  public constructor(email: string, name: string, picture: string, unixLastSeenAt: number, unixPasswordUpdatedAt: number, password: string|null = null, unixExpiresAt: number = 0) {
     this.email = email;
     this.name = name;
     this.picture = picture;
@@ -17,5 +18,6 @@ export class User {
     } else {
       this.password = null;
     }
+    this.expiresAt = unixExpiresAt > 0 ? new Date(unixExpiresAt * 1000) : null;
   }
 }
@@ -11,6 +11,8 @@ describe('AuthService', () => {
     localStorage.removeItem('token_type');
     localStorage.removeItem('token_expires_at');
     localStorage.removeItem('token_role');
+    localStorage.removeItem('refresh_token');
+    localStorage.removeItem('cookie_session');
   });
 
   afterEach(() => {
@@ -25,7 +27,7 @@ describe('AuthService', () => {
       'duration': 300,
     });
 
-    auth.login('123', 'Bearer', (success, userAuth) =>{
+    auth.login('an_id_token', (success, userAuth) =>{
       expect(success).toBe(true);
       expect(userAuth).not.toBeNull();
       expect(userAuth?.token).toBe('a_token');
@@ -34,11 +36,40 @@ describe('AuthService', () => {
     });
   });
 
+  it('sends only the id token', async () => {
+    const auth = new AuthService();
+    mock.onPost(auth.loginApiURL(), {id_token: 'an_id_token'}).reply(200, {
+      'token': 'a_token',
+      'token_type': 'Bearer',
+      'duration': 300,
+    });
+
+    auth.login('an_id_token', (success) =>{
+      expect(success).toBe(true);
+    });
+  });
+
+  it('completes login from the callback fragment', () => {
+    const auth = new AuthService();
+
+    expect(auth.completeLogin('#token=a_token&token_type=Bearer&duration=300&role=admin')).toBe(true);
+    expect(auth.currentUserAuth?.token).toBe('a_token');
+    expect(auth.currentUserAuth?.tokenType).toBe('Bearer');
+    expect(auth.currentUserAuth?.expires).toBeGreaterThan(Date.now());
+    expect(auth.loginError).toBe('');
+
+    expect(auth.completeLogin('#login_error=domain')).toBe(true);
+    expect(auth.currentUserAuth).toBeNull();
+    expect(auth.loginError).toBe('domain');
+
+    expect(auth.completeLogin('#other')).toBe(false);
+  });
+
   it('calls back with failure on auth failure', async () => {
     const auth = new AuthService();
     mock.onPost(auth.loginApiURL()).reply(400, {});
 
-    auth.login('123', 'Bearer', (success, userAuth) =>{
+    auth.login('an_id_token', (success, userAuth) =>{
       expect(success).toBe(false);
       expect(userAuth).toBeNull();
     });
@@ -48,7 +79,7 @@ describe('AuthService', () => {
     const auth = new AuthService();
     mock.onPost(auth.loginApiURL()).reply(200, {'token_type': 'Bearer'});
 
-    auth.login('123', 'Bearer', (success, userAuth) =>{
+    auth.login('an_id_token', (success, userAuth) =>{
       expect(success).toBe(false);
       expect(userAuth).toBeNull();
     });
@@ -58,7 +89,7 @@ describe('AuthService', () => {
     const auth = new AuthService();
     mock.onPost(auth.loginApiURL()).reply(500);
 
-    auth.login('123', 'Bearer', (success, userAuth) =>{
+    auth.login('an_id_token', (success, userAuth) =>{
       expect(success).toBe(false);
       expect(userAuth).toBeNull();
     });
@@ -76,6 +107,100 @@ describe('AuthService', () => {
     expect(mock.history.delete.length).toBe(0);
   });
 
+  it('ends the session on the server on logout', async () => {
+    const auth = new AuthService();
+    mock.onPost(auth.logoutApiURL(), {refresh_token: 'a_refresh_token'}).reply(204);
+    auth.refreshToken = 'a_refresh_token';
+
+    await new Promise<void>((resolve) => auth.logout(resolve));
+
+    expect(mock.history.post.length).toBe(1);
+    expect(auth.refreshToken).toBe('');
+    expect(localStorage.getItem('refresh_token')).toBeNull();
+  });
+
+  it('refreshes the session with the refresh token', async () => {
+    const auth = new AuthService();
+    mock.onPost(auth.refreshApiURL(), {refresh_token: 'a_refresh_token'}).reply(200, {
+      'token': 'a_new_token',
+      'token_type': 'Bearer',
+      'duration': 300,
+      'refresh_token': 'a_new_refresh_token',
+    });
+    auth.refreshToken = 'a_refresh_token';
+
+    const [first, second] = await Promise.all([auth.refresh(), auth.refresh()]);
+
+    expect(mock.history.post.length).toBe(1);
+    expect(first?.token).toBe('a_new_token');
+    expect(second).toBe(first);
+    expect(auth.refreshToken).toBe('a_new_refresh_token');
+    expect(auth.needsRefresh()).toBe(false);
+  });
+
+  it('clears the session when refresh fails', async () => {
+    const auth = new AuthService();
+    mock.onPost(auth.refreshApiURL()).reply(401);
+    auth.refreshToken = 'a_refresh_token';
+
+    expect(await auth.refresh()).toBeNull();
+    expect(auth.currentUserAuth).toBeNull();
+    expect(auth.refreshToken).toBe('');
+  });
+
+  it('refreshes and ends cookie sessions without tokens', async () => {
+    const auth = new AuthService();
+    mock.onPost(auth.loginApiURL()).reply(200, {
+      'token': '',
+      'token_type': 'Cookie',
+      'duration': 300,
+    });
+    mock.onPost(auth.refreshApiURL()).reply(200, {
+      'token': '',
+      'token_type': 'Cookie',
+      'duration': 300,
+    });
+    mock.onPost(auth.logoutApiURL()).reply(204);
+
+    const success = await new Promise<boolean>((resolve) => auth.login('an_id_token', resolve));
+    expect(success).toBe(true);
+    expect(auth.cookieSession).toBe(true);
+    expect(auth.refreshToken).toBe('');
+
+    auth.currentUserAuth = null;
+    expect(auth.needsRefresh()).toBe(true);
+    expect((await auth.refresh())?.tokenType).toBe('Cookie');
+
+    await new Promise<void>((resolve) => auth.logout(resolve));
+    expect(mock.history.post.length).toBe(3);
+    expect(mock.history.post[2].headers?.['Authorization']).toBeUndefined();
+    expect(auth.cookieSession).toBe(false);
+    expect(localStorage.getItem('cookie_session')).toBeNull();
+  });
+
+  it('sends the CSRF cookie in a header on state-changing calls to the API', async () => {
+    const auth = useAuthService();
+    const targetURL = auth.apiRootURL + 'csrf/';
+    mock.onPost(targetURL).reply((config) => [200, {requestHeaders: config.headers}]);
+    mock.onGet(targetURL).reply((config) => [200, {requestHeaders: config.headers}]);
+    document.cookie = 'fringe_csrf=a_csrf_token';
+
+    const posted = await axios.post(targetURL, {});
+    expect(posted.data.requestHeaders['X-CSRF-Token']).toBe('a_csrf_token');
+
+    const fetched = await axios.get(targetURL);
+    expect(fetched.data.requestHeaders['X-CSRF-Token']).toBeUndefined();
+
+    document.cookie = 'fringe_csrf=; expires=Thu, 01 Jan 1970 00:00:00 GMT';
+  });
+
+  it('completes login with the refresh token from the callback fragment', () => {
+    const auth = new AuthService();
+
+    expect(auth.completeLogin('#token=a_token&token_type=Bearer&duration=300&role=user&refresh_token=a_refresh_token')).toBe(true);
+    expect(auth.refreshToken).toBe('a_refresh_token');
+  });
+
   it('always return the same instance of AuthService', async () => {
     const oneAuth = useAuthService();
     const twoAuth = useAuthService();
@@ -103,7 +228,7 @@ describe('AuthService', () => {
       'duration': 300,
     });
 
-    auth.login('123', 'Bearer', (success, userAuth) =>{
+    auth.login('an_id_token', (success, userAuth) =>{
       expect(success).toBe(true);
       expect(userAuth).not.toBeNull();
       expect(userAuth?.token).toBe('a_token');
@@ -131,7 +256,7 @@ describe('AuthService', () => {
       'duration': -200,
     });
 
-    auth.login('123', 'Bearer', (success, userAuth) =>{
+    auth.login('an_id_token', (success, userAuth) =>{
       expect(success).toBe(true);
       expect(userAuth).not.toBeNull();
       expect(userAuth?.token).toBe('a_token');
@@ -178,7 +303,7 @@ describe('AuthService', () => {
       return [200, {requestHeaders: config.headers}];
     });
 
-    auth.login('googleToken', 'googleTokenType', (success, userAuth) => {
+    auth.login('an_id_token', (success, userAuth) => {
       expect(success).toBeTruthy();
       expect(userAuth).not.toBeNull();
 
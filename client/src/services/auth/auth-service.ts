@@ -1,13 +1,28 @@
 import axios from 'axios';
 import {UserAuth} from '../../models/user-auth';
 
+// Access tokens expiring sooner than this are refreshed before sending the request
+const refreshMarginInMilliseconds = 30*1000;
+// Token type returned when the server keeps the session in HttpOnly cookies
+const cookieTokenType = 'Cookie';
+const csrfCookieName = 'fringe_csrf';
+const csrfHeaderName = 'X-CSRF-Token';
+
 class AuthService {
   apiRootURL: string;
   private _userAuth: UserAuth|null;
+  private _loginError: string;
+  private _refreshToken: string;
+  private _refreshing: Promise<UserAuth|null>|null;
+  private _cookieSession: boolean;
 
   public constructor() {
     this.apiRootURL = `https://${window.location.host}/api/`;
     this._userAuth = null;
+    this._loginError = '';
+    this._refreshToken = localStorage.getItem('refresh_token') ?? '';
+    this._refreshing = null;
+    this._cookieSession = localStorage.getItem('cookie_session') == 'true';
 
     const localToken = localStorage.getItem('token');
     const localTokenType = localStorage.getItem('token_type');
@@ -16,13 +31,52 @@ class AuthService {
     if (localTokenRole == null) {
       localTokenRole = 'unknown';
     }
-    if ( localToken && localTokenType && localTokenExpiresString) {
+    if ( localToken != null && localTokenType && localTokenExpiresString) {
       const localTokenExpires = Number(localTokenExpiresString);
       const auth = new UserAuth(localTokenType, localToken, localTokenExpires, localTokenRole);
       if (!auth.isExpired()) {
         this._userAuth = auth;
       }
     }
+
+    if (this.completeLogin(window.location.hash)) {
+      // Keep the session token out of the history and bookmarks
+      window.history.replaceState(null, '', window.location.pathname + window.location.search);
+    }
+  }
+
+  public get loginError() : string {
+    return this._loginError;
+  }
+
+  // completeLogin reads the session the server returns in the URL fragment after the identity provider callback.
+  // Returns true when the fragment was a login result.
+  public completeLogin(hash: string) : boolean {
+    const fragment = new URLSearchParams(hash.replace(/^#/, ''));
+    const loginError = fragment.get('login_error');
+    if (loginError != null) {
+      console.warn(`🛂 Login refused by server: ${loginError}`);
+      this._loginError = loginError;
+      this.currentUserAuth = null;
+      this.refreshToken = '';
+      this.cookieSession = false;
+
+      return true;
+    }
+
+    const token = fragment.get('token');
+    const tokenType = fragment.get('token_type');
+    if (token == null || tokenType == null) {
+      return false;
+    }
+
+    const expiry = Date.now()+Number(fragment.get('duration'))*1000;
+    this._loginError = '';
+    this.currentUserAuth = new UserAuth(tokenType, token, expiry, fragment.get('role') ?? 'unknown');
+    this.refreshToken = fragment.get('refresh_token') ?? '';
+    this.cookieSession = tokenType == cookieTokenType;
+
+    return true;
   }
 
   public get currentUserAuth() : UserAuth|null {
@@ -48,12 +102,99 @@ class AuthService {
     this._userAuth = auth;
   }
 
+  // refreshToken is kept when the access token expires, it renews the session until the server ends it.
+  public get refreshToken() : string {
+    return this._refreshToken;
+  }
+
+  public set refreshToken(refreshToken: string) {
+    this._refreshToken = refreshToken;
+    if (refreshToken.length == 0) {
+      localStorage.removeItem('refresh_token');
+
+      return;
+    }
+
+    localStorage.setItem('refresh_token', refreshToken);
+  }
+
+  // cookieSession is true when the server keeps the tokens in HttpOnly cookies, they are sent by the browser
+  // and state-changing requests carry the CSRF token instead of the Authorization header.
+  public get cookieSession() : boolean {
+    return this._cookieSession;
+  }
+
+  public set cookieSession(cookieSession: boolean) {
+    this._cookieSession = cookieSession;
+    if (!cookieSession) {
+      localStorage.removeItem('cookie_session');
+
+      return;
+    }
+
+    localStorage.setItem('cookie_session', 'true');
+  }
+
+  // csrfToken returns the token the server set in the CSRF cookie, empty without cookie session.
+  public csrfToken() : string {
+    const cookie = document.cookie.split('; ').find((entry) => entry.startsWith(`${csrfCookieName}=`));
+
+    return cookie != null ? decodeURIComponent(cookie.substring(csrfCookieName.length+1)) : '';
+  }
+
   public loginApiURL() :string {
     return this.apiRootURL + (this.apiRootURL.slice(-1) == '/' ? '' : '/') + 'auth/';
   }
 
-  public login(googleToken: string, googleTokenType: string, callback: (success: boolean, auth: UserAuth | null) => void) : void {
-    axios.post(this.loginApiURL(), {access_token: googleToken, token_type: googleTokenType}).then( (response) => {
+  public refreshApiURL() :string {
+    return this.loginApiURL() + 'refresh/';
+  }
+
+  public logoutApiURL() :string {
+    return this.loginApiURL() + 'logout/';
+  }
+
+  // needsRefresh is true when the access token is missing or about to expire and a refresh token can renew it.
+  public needsRefresh() : boolean {
+    if (this._refreshToken.length == 0 && !this._cookieSession) {
+      return false;
+    }
+
+    return this._userAuth == null || this._userAuth.expires - Date.now() < refreshMarginInMilliseconds;
+  }
+
+  // refresh exchanges the refresh token for a new access token. Concurrent calls share the same request
+  // since the server ends the session when a refresh token is used twice.
+  public refresh() : Promise<UserAuth|null> {
+    if (this._refreshing != null) {
+      return this._refreshing;
+    }
+
+    this._refreshing = axios.post(this.refreshApiURL(), {refresh_token: this._refreshToken}).then((response) => {
+      const expiry = Date.now()+Number(response.data['duration'])*1000;
+      const auth = new UserAuth(response.data['token_type'], response.data['token'], expiry, response.data['role']);
+      this.currentUserAuth = auth;
+      this.refreshToken = response.data['refresh_token'] ?? '';
+      this.cookieSession = response.data['token_type'] == cookieTokenType;
+
+      return this.currentUserAuth;
+    }).catch((error) => {
+      console.warn(`🛂 Unable to refresh session: ${error}`);
+      this.currentUserAuth = null;
+      this.refreshToken = '';
+      this.cookieSession = false;
+
+      return null;
+    }).finally(() => {
+      this._refreshing = null;
+    });
+
+    return this._refreshing;
+  }
+
+  // login exchanges the Google ID token, verified by the server to be issued for fringe, for a session.
+  public login(googleIDToken: string, callback: (success: boolean, auth: UserAuth | null) => void) : void {
+    axios.post(this.loginApiURL(), {id_token: googleIDToken}).then( (response) => {
       console.debug(`🛂 Auth service returned code:${response.status}`);
       if (!('token_type' in response.data && 'token' in response.data)) {
         console.warn(`Invalid response from authentication API; missing token or token_type (code ${response.status})`);
@@ -67,6 +208,8 @@ class AuthService {
 
       const auth = new UserAuth(response.data['token_type'], response.data['token'], expiry, response.data['role']);
       this.currentUserAuth = auth;
+      this.refreshToken = response.data['refresh_token'] ?? '';
+      this.cookieSession = response.data['token_type'] == cookieTokenType;
 
       callback(true, auth);
     }).catch((error) => {
@@ -75,9 +218,24 @@ class AuthService {
     });
   }
 
+  // logout ends the session on the server when there is one, local state is cleared even if the server is unreachable.
   public logout(callback: VoidFunction) : void {
+    const refreshToken = this._refreshToken;
+    const cookieSession = this._cookieSession;
+    const auth = this._userAuth;
     this.currentUserAuth = null;
-    callback();
+    this.refreshToken = '';
+    this.cookieSession = false;
+
+    if (refreshToken.length == 0 && !cookieSession) {
+      callback();
+      return;
+    }
+
+    const headers = auth != null && !cookieSession ? {Authorization: auth.authorizationString()} : {};
+    axios.post(this.logoutApiURL(), {refresh_token: refreshToken}, {headers: headers}).catch((error) => {
+      console.warn(`🛂 Unable to end session on server: ${error}`);
+    }).finally(callback);
   }
 }
 
@@ -86,13 +244,25 @@ function useAuthService() : AuthService {
   return authService;
 }
 
-axios.interceptors.request.use((config) => {
+axios.interceptors.request.use(async (config) => {
   if (config.url != null && config.headers != null) {
     if (config.url.startsWith(authService.apiRootURL)) {
-      if (authService.currentUserAuth != null) {
+      // Login, refresh and logout requests must not wait on a refresh
+      if (!config.url.startsWith(authService.loginApiURL()) && authService.needsRefresh()) {
+        console.debug(`🛂 Refreshing auth token before request: ${config.url}`);
+        await authService.refresh();
+      }
+
+      if (authService.currentUserAuth != null && authService.currentUserAuth.tokenType != cookieTokenType) {
         console.debug(`🛂 Adding Authorization headers to request: ${config.url}`);
         config.headers['Authorization'] = authService.currentUserAuth.authorizationString();
       }
+
+      // Cookies are sent by the browser, the CSRF token proves the request comes from this page
+      const csrfToken = authService.csrfToken();
+      if (config.method != null && config.method.toLowerCase() != 'get' && csrfToken.length > 0) {
+        config.headers[csrfHeaderName] = csrfToken;
+      }
     }
   }
   return config;
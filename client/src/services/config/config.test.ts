@@ -17,12 +17,15 @@ describe('Config', () => {
     const config = new Config();
     mock.onGet(config.configApiURL()).reply(200, {
       'google_client_id': '123',
+      'providers': [{'id': 'google', 'name': 'Google', 'login_url': '/auth/google/login'}],
     });
     config.waitForConfigFromAPI( (success, config) => {
       expect(success).toBe(true);
       expect(config.state.loaded).toBe(true);
       expect(config.state.error).toBeNull();
       expect(config.googleClientID).toBe('123');
+      expect(config.providers.length).toBe(1);
+      expect(config.providers[0].loginURL).toBe('/auth/google/login');
     });
   });
 
@@ -1,4 +1,5 @@
 import axios from 'axios';
+import {IdentityProvider} from '../../models/identity-provider';
 
 class Config {
   state: {
@@ -8,6 +9,7 @@ class Config {
 
   apiRootURL: string;
   googleClientID: string;
+  providers: IdentityProvider[];
 
   public constructor() {
     this.state = {
@@ -17,6 +19,7 @@ class Config {
 
     this.apiRootURL = `https://${window.location.host}/api/`;
     this.googleClientID = '';
+    this.providers = [];
   }
 
   configApiURL() : string {
@@ -27,7 +30,7 @@ class Config {
     console.debug('⚙️ Getting client configuration from: ' + this.configApiURL());
     axios.get(this.configApiURL()).then((r) => {
       // Minimal keys required for config to be deemed valid
-      if (!r.data['google_client_id']) {
+      if (!Array.isArray(r.data['providers']) || r.data['providers'].length == 0) {
         this.state.loaded = false;
         this.state.error = new Error('Missing required configuration from API');
         loaded(false, this);
@@ -37,7 +40,8 @@ class Config {
 
       this.state.loaded = true;
       this.state.error = null;
-      this.googleClientID = r.data['google_client_id'];
+      this.googleClientID = r.data['google_client_id'] ?? '';
+      this.providers = r.data['providers'].map((p: any) => new IdentityProvider(p['id'], p['name'], p['login_url']));
 
       console.debug('⚙️ Loaded config from:' + this.configApiURL());
       loaded(true, this);
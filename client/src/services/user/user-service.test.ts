@@ -173,20 +173,26 @@ describe('UserService', () => {
 
   it('returns a list of user model', async () => {
     const userService = new UserService();
-    mock.onGet(userService.userApiURL()).reply(200, [{
-      'email': 'some@email.com',
-      'name': 'some user',
-      'picture': 'https://picture.url',
-      'password_updated_at': 0,
-      'last_seen_at': 0,
-      'password': 'super_random_password',
-    }], null);
+    mock.onGet(userService.userApiURL()).reply(200, {
+      'items': [{
+        'email': 'some@email.com',
+        'name': 'some user',
+        'picture': 'https://picture.url',
+        'password_updated_at': 0,
+        'last_seen_at': 0,
+        'password': 'super_random_password',
+      }],
+      'total': 3,
+      'next_cursor': 'next',
+    }, null);
 
-    userService.findAllUsers('', 0, 20, (users, success) => {
+    userService.findAllUsers('', '', 20, (users, success, nextCursor, total) => {
       expect(success).toBeTruthy();
       expect(users).not.toBeNull();
       expect(users.length).toBe(1);
       expect(users[0].email).toEqual('some@email.com');
+      expect(nextCursor).toEqual('next');
+      expect(total).toBe(3);
     });
   });
 
@@ -194,7 +200,7 @@ describe('UserService', () => {
     const userService = new UserService();
     mock.onGet(userService.userApiURL()).reply(500, null, null); // missing email field
 
-    userService.findAllUsers('', 0, 20, (users, success) => {
+    userService.findAllUsers('', '', 20, (users, success) => {
       expect(success).not.toBeTruthy();
       expect(users).not.toBeNull();
       expect(users.length).toBe(0);
@@ -293,4 +299,90 @@ describe('UserService', () => {
       expect(resultText).toEqual('failed');
     });
   });
+
+  it('returns the user with its new expiry', async () => {
+    const userService = new UserService();
+    mock.onPut(userService.userApiURL()+'some%40email.com/expiry/', {'expires_at': 1640995200}).reply(200, {
+      'email': 'some@email.com',
+      'name': 'some user',
+      'picture': '',
+      'last_seen_at': 0,
+      'password_updated_at': 0,
+      'expires_at': 1640995200,
+    }, null);
+
+    userService.setExpiry('some@email.com', new Date('2022-01-01T00:00:00Z'), (user) => {
+      expect(user).not.toBeNull();
+      expect(user?.expiresAt).toEqual(new Date('2022-01-01T00:00:00Z'));
+    });
+  });
+
+  it('returns null when expiry cannot be set', async () => {
+    const userService = new UserService();
+    mock.onPut(userService.userApiURL()+'some%40email.com/expiry/').reply(401, null, null);
+
+    userService.setExpiry('some@email.com', null, (user) => {
+      expect(user).toBeNull();
+    });
+  });
+
+  it('returns the credentials of the current user', async () => {
+    const userService = new UserService();
+    mock.onGet(userService.credentialApiURL()).reply(200, [
+      {'id': 'a1', 'name': 'Laptop', 'created_at': 0, 'last_used_at': 1640995200, 'revoked_at': 0},
+      {'name': 'missing id'},
+    ], null);
+
+    userService.myCredentials((credentials, success) => {
+      expect(success).toBeTruthy();
+      expect(credentials.length).toBe(1);
+      expect(credentials[0].lastUsedAt).toEqual(new Date('2022-01-01T00:00:00Z'));
+    });
+  });
+
+  it('returns null when a created credential has no password', async () => {
+    const userService = new UserService();
+    mock.onPost(userService.credentialApiURL()).reply(200, {'id': 'a1', 'name': 'Laptop', 'created_at': 0}, null);
+
+    userService.createMyCredential('Laptop', (credential) => {
+      expect(credential).toBeNull();
+    });
+  });
+
+  it('returns the revocation result', async () => {
+    const userService = new UserService();
+    mock.onDelete(userService.credentialApiURL()+'a1/').reply(200, {'result': 'success'}, null);
+
+    userService.revokeMyCredential('a1', (resultText) => {
+      expect(resultText).toEqual('success');
+    });
+  });
+
+  it('returns the one-time password status of the current user', async () => {
+    const userService = new UserService();
+    mock.onGet(userService.totpApiURL()).reply(200, {'enabled': true, 'pending': false, 'enabled_at': 1640995200}, null);
+
+    userService.myTOTPStatus((status) => {
+      expect(status?.enabled).toBeTruthy();
+      expect(status?.enabledAt).toEqual(new Date('2022-01-01T00:00:00Z'));
+    });
+  });
+
+  it('returns null when enrollment has no qr code', async () => {
+    const userService = new UserService();
+    mock.onPost(userService.totpApiURL()).reply(200, {'secret': 'ABCDEF'}, null);
+
+    userService.enrollMyTOTP((enrollment) => {
+      expect(enrollment).toBeNull();
+    });
+  });
+
+  it('returns the confirmation result', async () => {
+    const userService = new UserService();
+    mock.onPut(userService.totpApiURL(), {'code': '123456'}).reply(200, {'result': 'success'}, null);
+
+    userService.confirmMyTOTP('123456', (resultText) => {
+      expect(resultText).toEqual('success');
+    });
+  });
 });
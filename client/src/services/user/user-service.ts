@@ -1,4 +1,6 @@
 import axios from 'axios';
+import {Credential} from '../../models/credential';
+import {TOTPEnrollment, TOTPStatus} from '../../models/totp';
 import {User} from '../../models/user';
 
 class UserService {
@@ -20,7 +22,7 @@ class UserService {
       }
     }
 
-    return new User(data['email'], data['name'], data['picture'], data['last_seen_at'], data['password_updated_at'], data['password']);
+    return new User(data['email'], data['name'], data['picture'], data['last_seen_at'], data['password_updated_at'], data['password'], data['expires_at'] ?? 0);
   }
 
   me(callback: (user: User|null) => void) : void {
@@ -60,34 +62,45 @@ class UserService {
     });
   }
 
-  findAllUsers(searchQuery : string, page : number, perPage : number, callback : (users: User[], success: boolean) => void) : void {
-    const params = {
+  findAllUsers(searchQuery : string, cursor : string, perPage : number, callback : (users: User[], success: boolean, nextCursor: string, total: number) => void, expiringBefore : Date|null = null) : void {
+    const params : Record<string, string|number> = {
       'per_page': perPage,
-      'page': page,
+      'cursor': cursor,
       'search': searchQuery,
     };
+    if (expiringBefore != null) {
+      // Accounts expiring first are the most urgent
+      params['expiring_before'] = Math.floor(expiringBefore.getTime() / 1000);
+      params['sort'] = 'expires_at';
+      params['order'] = 'asc';
+    }
     axios.get(this.userApiURL(), {params: params} ).then((response) => {
       console.debug(response);
 
       const users : User[] = [];
-      if (response.data instanceof Array) {
-        for (let i = 0; i < response.data.length; i++) {
-          const userData = response.data[i];
+      let nextCursor = '';
+      let total = 0;
+      if (response.data && response.data.items instanceof Array) {
+        for (let i = 0; i < response.data.items.length; i++) {
+          const userData = response.data.items[i];
           const user = UserService.createUserFromResponseData(userData);
           if (user != null) {
             users.push(user);
           }
         }
+        nextCursor = response.data.next_cursor ?? '';
+        total = response.data.total ?? users.length;
       }
-      callback(users, true);
+      callback(users, true, nextCursor, total);
     }).catch((error) => {
       console.warn(`Unable to retrieve user list from ${this.userApiURL()}: ${error}`);
-      callback([], false);
+      callback([], false, '', 0);
     });
   }
 
-  create(email: string, name:string|null, callback: (resultText: string, user: User|null, )=>void) {
-    axios.post(this.userApiURL(), {'email': email, 'name': name}).then((response) => {
+  create(email: string, name:string|null, callback: (resultText: string, user: User|null, )=>void, expiresAt: Date|null = null) {
+    const expiry = expiresAt == null ? 0 : Math.floor(expiresAt.getTime() / 1000);
+    axios.post(this.userApiURL(), {'email': email, 'name': name, 'expires_at': expiry}).then((response) => {
       let user : User|null = null;
       let result : string = 'failed';
 
@@ -110,6 +123,138 @@ class UserService {
     });
   }
 
+  setExpiry(email: string, expiresAt: Date|null, callback: (user: User|null)=>void) {
+    const expiryURL = `${this.userApiURL()}${encodeURIComponent(email)}/expiry/`;
+    const expiry = expiresAt == null ? 0 : Math.floor(expiresAt.getTime() / 1000);
+    axios.put(expiryURL, {'expires_at': expiry}).then((response) => {
+      const user = UserService.createUserFromResponseData(response.data);
+      if (user == null) {
+        console.warn(`Invalid user response from user API at ${expiryURL}`);
+      }
+      callback(user);
+    }).catch((error) => {
+      console.warn(`Failed to set user expiry at ${expiryURL}: ${error}`);
+      callback(null);
+    });
+  }
+
+  credentialApiURL() :string {
+    return this.userApiURL() + 'me/credentials/';
+  }
+
+  private static createCredentialFromResponseData(data : any) : Credential|null {
+    if (data == null || !('id' in data) || !('name' in data)) {
+      return null;
+    }
+
+    return new Credential(data['id'], data['name'], data['created_at'] ?? 0, data['last_used_at'] ?? 0, data['revoked_at'] ?? 0, data['password']);
+  }
+
+  myCredentials(callback: (credentials: Credential[], success: boolean) => void) : void {
+    axios.get(this.credentialApiURL()).then((response) => {
+      const credentials : Credential[] = [];
+      if (response.data instanceof Array) {
+        for (const credentialData of response.data) {
+          const credential = UserService.createCredentialFromResponseData(credentialData);
+          if (credential != null) {
+            credentials.push(credential);
+          }
+        }
+      }
+      callback(credentials, true);
+    }).catch((error) => {
+      console.warn(`Unable to retrieve credentials from ${this.credentialApiURL()}: ${error}`);
+      callback([], false);
+    });
+  }
+
+  createMyCredential(name: string, callback: (credential: Credential|null) => void) : void {
+    axios.post(this.credentialApiURL(), {'name': name}).then((response) => {
+      const credential = UserService.createCredentialFromResponseData(response.data);
+      if (credential == null || credential.password == null) {
+        console.warn(`Invalid credential response from ${this.credentialApiURL()}`);
+        callback(null);
+      } else {
+        callback(credential);
+      }
+    }).catch((error) => {
+      console.warn(`Failed to create credential at ${this.credentialApiURL()}: ${error}`);
+      callback(null);
+    });
+  }
+
+  revokeMyCredential(id: string, callback: (resultText: string) => void) : void {
+    const revokeURL = `${this.credentialApiURL()}${encodeURIComponent(id)}/`;
+    axios.delete(revokeURL).then((response) => {
+      let result = 'failed';
+      if (response.data && 'result' in response.data) {
+        result = response.data['result'];
+      }
+      callback(result);
+    }).catch((error) => {
+      console.warn(`Failed to revoke credential at ${revokeURL}: ${error}`);
+      callback('failed');
+    });
+  }
+
+  totpApiURL() :string {
+    return this.userApiURL() + 'me/totp/';
+  }
+
+  myTOTPStatus(callback: (status: TOTPStatus|null) => void) : void {
+    axios.get(this.totpApiURL()).then((response) => {
+      if (response.data == null || !('enabled' in response.data)) {
+        console.warn(`Invalid one-time password status from ${this.totpApiURL()}`);
+        callback(null);
+        return;
+      }
+      callback(new TOTPStatus(response.data['enabled'], response.data['pending'] ?? false, response.data['enabled_at'] ?? 0));
+    }).catch((error) => {
+      console.warn(`Unable to retrieve one-time password status from ${this.totpApiURL()}: ${error}`);
+      callback(null);
+    });
+  }
+
+  enrollMyTOTP(callback: (enrollment: TOTPEnrollment|null) => void) : void {
+    axios.post(this.totpApiURL()).then((response) => {
+      if (response.data == null || !('secret' in response.data) || !('qr_code' in response.data)) {
+        console.warn(`Invalid one-time password enrollment from ${this.totpApiURL()}`);
+        callback(null);
+        return;
+      }
+      callback(new TOTPEnrollment(response.data['secret'], response.data['url'] ?? '', response.data['qr_code']));
+    }).catch((error) => {
+      console.warn(`Failed to enroll one-time password at ${this.totpApiURL()}: ${error}`);
+      callback(null);
+    });
+  }
+
+  confirmMyTOTP(code: string, callback: (resultText: string) => void) : void {
+    axios.put(this.totpApiURL(), {'code': code}).then((response) => {
+      let result = 'failed';
+      if (response.data && 'result' in response.data) {
+        result = response.data['result'];
+      }
+      callback(result);
+    }).catch((error) => {
+      console.warn(`Failed to confirm one-time password at ${this.totpApiURL()}: ${error}`);
+      callback('failed');
+    });
+  }
+
+  disableMyTOTP(callback: (resultText: string) => void) : void {
+    axios.delete(this.totpApiURL()).then((response) => {
+      let result = 'failed';
+      if (response.data && 'result' in response.data) {
+        result = response.data['result'];
+      }
+      callback(result);
+    }).catch((error) => {
+      console.warn(`Failed to disable one-time password at ${this.totpApiURL()}: ${error}`);
+      callback('failed');
+    });
+  }
+
   delete(email: string, callback: (resultText: string)=>void) {
     const deleteURL = `${this.userApiURL()}${encodeURIComponent(email)}/`;
     axios.delete(deleteURL).then((response) => {
@@ -9,9 +9,18 @@ function AuthProvider({children}: { children: JSX.Element[] }) {
   const [authenticated, setAuthenticated] = React.useState<boolean>(currentUserAuth != null);
   const [adminRole, setAdminRole] = React.useState<boolean>(currentUserAuth?.role == UserAuthRole.admin);
 
-
-  const login = (token: string, tokenType: string, callback: (authenticated: boolean) => void) => {
-    return authService.login(token, tokenType, (success: boolean, auth: UserAuth|null) => {
+  // Resume the session with the refresh token when the stored access token expired
+  React.useEffect(() => {
+    if (currentUserAuth == null && authService.needsRefresh()) {
+      authService.refresh().then((auth: UserAuth|null) => {
+        setAuthenticated(auth != null);
+        setAdminRole(auth?.role == UserAuthRole.admin);
+      });
+    }
+  }, []);
+
+  const login = (idToken: string, callback: (authenticated: boolean) => void) => {
+    return authService.login(idToken, (success: boolean, auth: UserAuth|null) => {
       setAuthenticated(success);
       if (auth != null && auth.role == UserAuthRole.admin) {
         setAdminRole(true);
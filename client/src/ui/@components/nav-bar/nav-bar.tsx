@@ -1,18 +1,16 @@
 import {GroupRounded, LogoutRounded} from '@mui/icons-material';
 import {AppBar, Box, Button, Stack, Toolbar} from '@mui/material';
 import React from 'react';
-import {GoogleLogout} from 'react-google-login';
 import {useNavigate} from 'react-router-dom';
 
-import Config from '../../../services/config';
 import {useAuth} from '../../@contexts/auth';
 
 
-function NavBar({config}: {config: Config}) {
+function NavBar() {
   const auth = useAuth();
   const navigate = useNavigate();
 
-  const googleLogoutSuccessHandler = () => {
+  const logoutHandler = () => {
     auth.logout(()=>{
       navigate('/login', {replace: true});
     });
@@ -42,14 +40,9 @@ function NavBar({config}: {config: Config}) {
                 <GroupRounded />
               </Button>
             )}
-            <GoogleLogout
-              clientId={config.googleClientID}
-              onLogoutSuccess={googleLogoutSuccessHandler}
-              render={(props: {onClick: () => void, disabled?: boolean | undefined}) => (
-                <Button color="inherit" onClick={props.onClick} disabled={props.disabled}>
-                  <LogoutRounded />
-                </Button>
-              )}/>
+            <Button color="inherit" onClick={logoutHandler}>
+              <LogoutRounded />
+            </Button>
           </Stack>
         </Toolbar>
       </AppBar>
@@ -3,7 +3,7 @@ import React from 'react';
 interface AuthContextType {
   authenticated: boolean;
   adminRole: boolean;
-  login: (tokenType: string, token: string, callback: (authenticated: boolean) => void) => void;
+  login: (idToken: string, callback: (authenticated: boolean) => void) => void;
   logout: (callback: VoidFunction) => void;
 }
 
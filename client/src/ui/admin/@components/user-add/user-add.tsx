@@ -11,6 +11,7 @@ function UserAdd(props: {onCreation:(user: User) => void, sx?: SxProps<Theme>|un
   const [creationDialogOpened, setCreationDialogOpened] = React.useState<boolean>(false);
   const [email, setEmail] = React.useState<string>('');
   const [name, setName] = React.useState<string>('');
+  const [expiresAt, setExpiresAt] = React.useState<Date|null>(null);
   const [error, setError] = React.useState<string|null>(null);
   const [isEmailValid, setIsEmailValid] = React.useState<boolean>(false);
   const [creating, setCreating] = React.useState<boolean>(false);
@@ -24,6 +25,7 @@ function UserAdd(props: {onCreation:(user: User) => void, sx?: SxProps<Theme>|un
   const resetAddDialog = () => {
     setEmail('');
     setName('');
+    setExpiresAt(null);
     setIsEmailValid(false);
     setCreating(false);
     setError(null);
@@ -62,7 +64,7 @@ function UserAdd(props: {onCreation:(user: User) => void, sx?: SxProps<Theme>|un
       } else {
         setError(t('userAdd.failure', {resultCode: resultText}));
       }
-    });
+    }, expiresAt);
   };
 
   return (
@@ -100,6 +102,19 @@ function UserAdd(props: {onCreation:(user: User) => void, sx?: SxProps<Theme>|un
               setName(input);
             }}
           />
+          <TextField
+            fullWidth
+            margin="dense"
+            id="expires-at"
+            type="date"
+            label={t('userAdd.expiryLabel')}
+            InputLabelProps={{shrink: true}}
+            onChange={(event) => {
+              // The account expires at the end of the chosen day, in the admin's time zone
+              const input = event.target.value;
+              setExpiresAt(input.length > 0 ? new Date(`${input}T23:59:59`) : null);
+            }}
+          />
         </DialogContent>
         <DialogActions>
           <Button onClick={closeCreationDialog}><Trans i18nKey='actions.cancel' /></Button>
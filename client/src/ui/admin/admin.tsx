@@ -1,8 +1,8 @@
 import React from 'react';
-import {MoreRounded} from '@mui/icons-material';
-import {Avatar, Box, Button, CircularProgress, Container, Stack, Table, TableContainer, TableRow, TableCell, TableBody, TableHead} from '@mui/material';
-import {Trans} from 'react-i18next';
-import {differenceInDays} from 'date-fns';
+import {EventBusyRounded, MoreRounded} from '@mui/icons-material';
+import {Avatar, Box, Button, CircularProgress, Container, Stack, Table, TableContainer, TableRow, TableCell, TableBody, TableHead, ToggleButton, Tooltip} from '@mui/material';
+import {Trans, useTranslation} from 'react-i18next';
+import {addDays, differenceInDays} from 'date-fns';
 
 import {User} from '../../models/user';
 import {useUserService} from '../../services/user/user-service';
@@ -12,45 +12,61 @@ import UserDelete from './@components/user-delete';
 import UserRenew from './@components/user-renew';
 import UserSearch from './@components/user-search';
 
+// Accounts expiring within this many days are listed by the expiring filter and highlighted
+const expiringSoonDays = 30;
+
 function Admin() {
   const [users, setUsers] = React.useState<User[]>([]);
   const [loading, setLoading] = React.useState<boolean>(true);
   const [query, setQuery] = React.useState<string>('');
-  const [page, setPage] = React.useState<number>(1);
+  const [cursor, setCursor] = React.useState<string>('');
   const [hasMore, setHasMore] = React.useState<boolean>(true);
+  const [expiringOnly, setExpiringOnly] = React.useState<boolean>(false);
   const userService = useUserService();
+  const {t} = useTranslation();
 
   useMountEffect(()=> {
-    getUsers('', 1);
+    getUsers('', '', false);
   });
 
-  const getUsers = (query:string, page:number) => {
+  const isExpiringSoon = (user: User) : boolean => {
+    return user.expiresAt != null && differenceInDays(user.expiresAt, Date.now()) < expiringSoonDays;
+  };
+
+  const getUsers = (query:string, pageCursor:string, expiring:boolean) => {
     setQuery(query);
-    setPage(page);
     setLoading(true);
     const perPage = 20;
-    userService.findAllUsers(query, page, perPage, (foundUsers, success) => {
+    const expiringBefore = expiring ? addDays(Date.now(), expiringSoonDays) : null;
+    userService.findAllUsers(query, pageCursor, perPage, (foundUsers, success, nextCursor) => {
       setLoading(false);
       if (success) {
-        if (page <= 1) {
+        if (pageCursor.length == 0) {
           setUsers(foundUsers);
         } else {
           setUsers(users.concat(foundUsers));
         }
-        setHasMore(foundUsers.length == perPage);
+        setCursor(nextCursor);
+        setHasMore(nextCursor.length > 0);
       } else {
         console.warn('Failed to retrieve user list');
       }
-    });
+    }, expiringBefore);
   };
 
   const loadMoreUsers = () => {
-    getUsers(query, page+1);
+    getUsers(query, cursor, expiringOnly);
   };
 
   const newSearch = (newQuery:string = '') => {
     setUsers([]);
-    getUsers(newQuery, 1);
+    getUsers(newQuery, '', expiringOnly);
+  };
+
+  const toggleExpiring = () => {
+    setExpiringOnly(!expiringOnly);
+    setUsers([]);
+    getUsers(query, '', !expiringOnly);
   };
 
   return (
@@ -58,6 +74,11 @@ function Admin() {
       {/* Search and Add */}
       <Box sx={{marginTop: 2, display: 'flex', alignItems: 'center'}}>
         <UserSearch onSearch={newSearch} onClear={newSearch} delay={750} sx={{width: '100%'}} />
+        <Tooltip title={t('admin.expiringFilter', {count: expiringSoonDays})}>
+          <ToggleButton value="expiring" size="small" selected={expiringOnly} onChange={toggleExpiring} aria-label={t('admin.expiringFilter', {count: expiringSoonDays})} sx={{marginLeft: 1}}>
+            <EventBusyRounded />
+          </ToggleButton>
+        </Tooltip>
         <UserAdd onCreation={(newUser) => {
           const updatedUsers = [newUser].concat(users);
           setUsers(updatedUsers);
@@ -73,6 +94,7 @@ function Admin() {
               <TableCell align="left" sx={{display: {xs: 'none', sm: 'table-cell', md: 'table-cell', lg: 'table-cell', xl: 'table-cell'}, paddingLeft: 0}}><Trans i18nKey='admin.headerName' /></TableCell>
               <TableCell align="left" sx={{display: {xs: 'none', sm: 'none', md: 'table-cell', lg: 'table-cell', xl: 'table-cell'}, paddingLeft: 0}}><Trans i18nKey='admin.headerLastSeen' /></TableCell>
               <TableCell align="left" sx={{display: {xs: 'none', sm: 'none', md: 'table-cell', lg: 'table-cell', xl: 'table-cell'}, paddingLeft: 0}}><Trans i18nKey='admin.headerPasswordAge' /></TableCell>
+              <TableCell align="left" sx={{display: {xs: 'none', sm: 'none', md: 'table-cell', lg: 'table-cell', xl: 'table-cell'}, paddingLeft: 0}}><Trans i18nKey='admin.headerExpires' /></TableCell>
               <TableCell sx={{paddingRight: 0, maxWidth: 20}} align="right">&nbsp;</TableCell>
             </TableRow>
           </TableHead>
@@ -104,6 +126,10 @@ function Admin() {
                 <TableCell align="left" sx={{display: {xs: 'none', sm: 'none', md: 'table-cell', lg: 'table-cell', xl: 'table-cell'}, paddingLeft: 0}}>
                   <Trans i18nKey='admin.passwordAge' values={{count: differenceInDays(user.passwordUpdatedAt, Date.now()), passwordAge: differenceInDays(user.passwordUpdatedAt, Date.now())}} />
                 </TableCell>
+                {/* Expiry */}
+                <TableCell align="left" sx={{display: {xs: 'none', sm: 'none', md: 'table-cell', lg: 'table-cell', xl: 'table-cell'}, paddingLeft: 0, color: isExpiringSoon(user) ? 'warning.main' : 'inherit'}}>
+                  { user.expiresAt == null ? <Trans i18nKey='admin.neverExpires' /> : <Trans i18nKey='admin.expiresAt' values={{expiryDate: user.expiresAt, formatParams: {expiryDate: {year: 'numeric', month: 'short', day: 'numeric'}}}} /> }
+                </TableCell>
                 {/* Actions */}
                 <TableCell sx={{paddingRight: 0}} align="right">
                   <Stack direction="row" spacing={0} justifyContent="flex-end">
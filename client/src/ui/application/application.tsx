@@ -36,11 +36,11 @@ function Application(props: ApplicationProps) {
           </Container>
         }>
           <AuthProvider>
-            <NavBar config={props.config}/>
+            <NavBar />
             <Routes>
               <Route path="/" element={<RequireAuth><Me /></RequireAuth>} />
               <Route path="/admin" element={<RequireAuth><Admin /></RequireAuth>} />
-              <Route path="/login" element={<Login googleClientID={props.config.googleClientID} />} />
+              <Route path="/login" element={<Login providers={props.config.providers} />} />
             </Routes>
           </AuthProvider>
         </Suspense>
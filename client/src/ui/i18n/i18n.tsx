@@ -23,27 +23,53 @@ i18n
               understood: 'Understood',
             },
             admin: {
+              expiresAt: '{{expiryDate, datetime}}',
+              expiringFilter_one: 'Accounts expiring within {{count}} day',
+              expiringFilter_other: 'Accounts expiring within {{count}} days',
               headerEmail: 'Email',
+              headerExpires: 'Expires',
               headerName: 'Name',
               headerLastSeen: 'Last Seen',
               headerPasswordAge: 'Password Change',
               lastSeen: '{{lastSeenDate, datetime}}',
               loadMoreUsers: 'More Users',
+              neverExpires: 'Never',
               noMoreUsers: 'No More User',
               passwordAge_zero: 'Today',
               passwordAge_other: '{{passwordAge, relativetime(day)}}',
             },
+            credentials: {
+              createdInstructions: 'Use this password on the "{{name}}" device only. Copy it now, it cannot be retrieved again.',
+              errorFailedToCreate: 'Could not create the device password',
+              errorFailedToList: 'Could not retrieve device passwords',
+              errorFailedToRevoke: 'Could not revoke the device password',
+              instructions: 'Give each device its own password, a lost device can then be revoked without changing the others.',
+              lastUsed: 'Last used {{lastUsedDate, datetime}}',
+              nameLabel: 'Device name',
+              neverUsed: 'Never used',
+              revoke: 'Revoke device password',
+              title: 'Device Passwords',
+            },
             errorBoundary: {
               userMessage: 'The application could not be loaded properly.',
             },
             login: {
+              error: {
+                domain: 'Your account is not part of the allowed domain',
+                group: 'Your account is not a member of a group allowed to use Fringe',
+                disabled: 'Your account is disabled, ask an administrator to enable it again',
+                provider: 'The identity provider refused authentication or provided an invalid response',
+                state: 'The sign-in expired or was started in another browser, please try again',
+              },
               errorFringeRejected: 'Authentication rejected by Fringe server.',
               errorGoogleFailed: 'Google refused authentication or provided an invalid response',
-              signInButton: 'Sign-in With Google',
+              signInButton: 'Sign-in With {{provider}}',
               welcome: 'Welcome to Fringe',
             },
             me: {
               errorFailedToGetPassword: 'Could not retrieve new password from server',
+              expiry: 'Account Expiry',
+              expiryDate: '{{expiryDate, datetime}}',
               lastSeen: 'Last Authentication',
               lastSeenDate: '{{lastSeenDate, datetime}}',
               newPassword: 'New Password',
@@ -63,11 +89,29 @@ i18n
               copied: 'Password was copied to clipboard',
               copy: 'copy password to clipboard',
             },
+            totp: {
+              codeLabel: 'Verification code',
+              confirm: 'Confirm',
+              disable: 'Remove authenticator app',
+              disabled: 'No authenticator app',
+              enabled: 'Authenticator app enabled',
+              enabledDate: 'Since {{enabledDate, datetime}}',
+              enroll: 'Add authenticator app',
+              errorFailedToDisable: 'Could not remove the authenticator app',
+              errorFailedToEnroll: 'Could not add an authenticator app',
+              errorFailedToGetStatus: 'Could not retrieve the authenticator app status',
+              errorInvalidCode: 'Invalid verification code',
+              instructions: 'Once enabled, the VPN asks for the code of your authenticator app, either after your password or appended to it.',
+              qrCodeAlt: 'QR code to scan with the authenticator app',
+              scanInstructions: 'Scan the QR code, or enter the key below, in your authenticator app then enter the code it shows.',
+              title: 'Two-Factor Authentication',
+            },
             userAdd: {
               ariaLabel: 'Add user',
               dialogTitle: 'Add a new user',
               dialogInstruction: 'Create a new user',
               emailLabel: 'Email',
+              expiryLabel: 'Expires on (optional)',
               failure: 'Failed to create user ({{resultCode}})',
               nameLabel: 'Full Name',
             },
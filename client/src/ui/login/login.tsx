@@ -1,42 +1,22 @@
 import React from 'react';
 import {Alert, Box, Button, Container, Paper, Snackbar, Typography} from '@mui/material';
 import GoogleIcon from '@mui/icons-material/Google';
-import {useNavigate} from 'react-router-dom';
-import GoogleLogin, {GoogleLoginResponse, GoogleLoginResponseOffline} from 'react-google-login';
+import LoginIcon from '@mui/icons-material/Login';
 import {Trans, useTranslation} from 'react-i18next';
 
-import {useAuth} from '../@contexts/auth';
+import {IdentityProvider} from '../../models/identity-provider';
+import {useAuthService} from '../../services/auth';
 
-// const Login: React.FC<{googleClientID: string}> = ({children, googleClientID}) => {
-function Login({googleClientID} : {googleClientID: string}) {
-  const [errorMessage, setErrorMessage] = React.useState<string>('');
-  const navigate = useNavigate();
-  const auth = useAuth();
+function Login({providers} : {providers: IdentityProvider[]}) {
+  const authService = useAuthService();
   const {t} = useTranslation();
+  const [errorMessage, setErrorMessage] = React.useState<string>(
+    authService.loginError.length > 0 ? t(`login.error.${authService.loginError}`, t('login.errorFringeRejected')) : '');
 
-  const googleSuccessHandler = (response: (GoogleLoginResponse | GoogleLoginResponseOffline)) => {
+  // The server runs the authorization code flow and returns to / with the session in the URL fragment
+  const loginHandler = (provider: IdentityProvider) => {
     setErrorMessage('');
-    console.log(response);
-    if ('accessToken' in response) {
-      const token = response.accessToken;
-      const tokenType = 'Bearer';
-
-      auth.login(token, tokenType, (authenticate)=>{
-        if (authenticate) {
-          console.debug('Authentication was a success. Navigating to /');
-          navigate('/', {replace: true});
-        } else {
-          setErrorMessage(t('login.errorFringeRejected'));
-        }
-      });
-    } else {
-      googleFailureHandler(Error('Missing accessToken in Google\'s response'));
-    }
-  };
-
-  const googleFailureHandler = (error: any) => {
-    console.debug(error);
-    setErrorMessage(t('admin.errorGoogleFailed'));
+    window.location.assign(`https://${window.location.host}${provider.loginURL}`);
   };
 
   return (
@@ -50,17 +30,13 @@ function Login({googleClientID} : {googleClientID: string}) {
             <Trans i18nKey='login.welcome' />
           </Typography>
         </Box>
-        <Box sx={{m: 2}}>
-          <GoogleLogin
-            clientId={googleClientID}
-            onSuccess={googleSuccessHandler}
-            onFailure={googleFailureHandler}
-            render={(props: {onClick: () => void, disabled?: boolean | undefined}) => (
-              <Button size="large" variant="contained" startIcon={<GoogleIcon />} onClick={props.onClick} disabled={props.disabled}>
-                <Typography><Trans i18nKey='login.signInButton' /></Typography>
-              </Button>
-            )} />
-        </Box>
+        {providers.map((provider) => (
+          <Box key={provider.id} sx={{m: 1}}>
+            <Button size="large" variant="contained" startIcon={provider.id == 'google' ? <GoogleIcon /> : <LoginIcon />} onClick={() => loginHandler(provider)}>
+              <Typography><Trans i18nKey='login.signInButton' values={{provider: provider.name}} /></Typography>
+            </Button>
+          </Box>
+        ))}
         <Snackbar anchorOrigin={{vertical: 'top', horizontal: 'center'}} autoHideDuration={4000} open={errorMessage.length > 0} onClose={()=> setErrorMessage('')}>
           <Alert variant='filled' severity="error" sx={{width: '100%'}}>{errorMessage}</Alert>
         </Snackbar>
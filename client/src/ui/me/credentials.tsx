@@ -0,0 +1,118 @@
+import React from 'react';
+import {DeleteRounded, DevicesRounded} from '@mui/icons-material';
+import {Box, Button, IconButton, List, ListItem, ListItemIcon, ListItemText, Paper, TextField, Typography} from '@mui/material';
+import {Trans, useTranslation} from 'react-i18next';
+
+import PasswordField from '../@components/password-field';
+import useMountEffect from '../@hooks/use-mount';
+import {Credential} from '../../models/credential';
+import {useUserService} from '../../services/user/user-service';
+
+type CredentialsProps = {
+  onError: (message: string) => void,
+}
+
+// Credentials lists the per-device passwords of the current user, new passwords are only shown once.
+function Credentials({onError}: CredentialsProps) {
+  const userService = useUserService();
+  const [credentials, setCredentials] = React.useState<Credential[]>([]);
+  const [newName, setNewName] = React.useState('');
+  const [created, setCreated] = React.useState<Credential|null>(null);
+  const [busy, setBusy] = React.useState(false);
+  const {t} = useTranslation();
+
+  const refresh = () => {
+    userService.myCredentials((list, success) => {
+      if (success) {
+        setCredentials(list);
+      } else {
+        onError(t('credentials.errorFailedToList'));
+      }
+    });
+  };
+
+  const createCredential = () => {
+    setBusy(true);
+    userService.createMyCredential(newName.trim(), (credential) => {
+      setBusy(false);
+      if (credential == null) {
+        onError(t('credentials.errorFailedToCreate'));
+        return;
+      }
+      setCreated(credential);
+      setNewName('');
+      refresh();
+    });
+  };
+
+  const revokeCredential = (credential: Credential) => {
+    userService.revokeMyCredential(credential.id, (result) => {
+      if (result != 'success') {
+        onError(t('credentials.errorFailedToRevoke'));
+      }
+      if (created?.id == credential.id) {
+        setCreated(null);
+      }
+      refresh();
+    });
+  };
+
+  useMountEffect(refresh);
+
+  const dateFormat = {year: 'numeric', month: 'long', day: 'numeric', hour: 'numeric', minute: 'numeric', hour12: false};
+
+  return (
+    <Paper variant="outlined" sx={{marginTop: 4}}>
+      <Typography component="h2" variant="h6" sx={{px: 2, pt: 2}}>
+        <Trans i18nKey='credentials.title' />
+      </Typography>
+      <Typography variant="body2" color="text.secondary" sx={{px: 2}}>
+        <Trans i18nKey='credentials.instructions' />
+      </Typography>
+      <List>
+        { credentials.filter((credential) => credential.isActive()).map((credential) => (
+          <ListItem
+            key={credential.id}
+            secondaryAction={
+              <IconButton edge="end" aria-label={t('credentials.revoke')} onClick={() => revokeCredential(credential)}>
+                <DeleteRounded />
+              </IconButton>
+            }>
+            <ListItemIcon>
+              <DevicesRounded />
+            </ListItemIcon>
+            <ListItemText
+              primary={credential.name}
+              secondary={credential.lastUsedAt == null ?
+                t('credentials.neverUsed') :
+                t('credentials.lastUsed', {lastUsedDate: credential.lastUsedAt, formatParams: {lastUsedDate: dateFormat}})}
+            />
+          </ListItem>
+        ))}
+      </List>
+      <Box sx={{p: 2, display: 'flex', flexDirection: 'column', gap: 2}}>
+        { created != null && (
+          <>
+            <Typography variant="body2"><Trans i18nKey='credentials.createdInstructions' values={{name: created.name}} /></Typography>
+            <PasswordField loading={false} password={created.password} sx={{width: 1}}/>
+          </>
+        )}
+        <Box sx={{display: 'flex', gap: 1}}>
+          <TextField
+            size="small"
+            label={t('credentials.nameLabel')}
+            value={newName}
+            onChange={(event) => setNewName(event.target.value)}
+            inputProps={{maxLength: 64}}
+            sx={{flexGrow: 1}}
+          />
+          <Button variant="contained" onClick={createCredential} disabled={busy || newName.trim().length == 0}>
+            <Trans i18nKey='actions.add' />
+          </Button>
+        </Box>
+      </Box>
+    </Paper>
+  );
+}
+
+export default Credentials;
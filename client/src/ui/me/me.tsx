@@ -1,6 +1,6 @@
 import {differenceInDays} from 'date-fns';
 import React from 'react';
-import {AccessTimeFilled, LockRounded, PasswordRounded} from '@mui/icons-material';
+import {AccessTimeFilled, EventBusyRounded, LockRounded, PasswordRounded} from '@mui/icons-material';
 import {Alert, Avatar, Box, Button, CircularProgress, Container, Dialog, DialogActions, DialogContent, DialogTitle, List, ListItem, ListItemIcon, ListItemText, Paper, Snackbar, Typography} from '@mui/material';
 import {Trans, useTranslation} from 'react-i18next';
 
@@ -9,6 +9,8 @@ import useMountEffect from '../@hooks/use-mount';
 import {User} from '../../models/user';
 import {useUserService} from '../../services/user/user-service';
 import theme from '../theme';
+import Credentials from './credentials';
+import TOTP from './totp';
 
 // eslint-disable-next-line no-unused-vars
 enum PasswordState {NoPassword, FetchingPassword, HavePassword}
@@ -98,6 +100,18 @@ function Me() {
               secondary={currentUser == null ? `...` : t('me.passwordAgeRelative', {count: differenceInDays(currentUser.passwordUpdatedAt, Date.now()), passwordAge: differenceInDays(currentUser.passwordUpdatedAt, Date.now())})}
             />
           </ListItem>
+          {/* Account Expiry */}
+          { currentUser?.expiresAt != null && (
+            <ListItem>
+              <ListItemIcon>
+                <EventBusyRounded />
+              </ListItemIcon>
+              <ListItemText
+                primary={t('me.expiry')}
+                secondary={t('me.expiryDate', {expiryDate: currentUser.expiresAt, formatParams: {expiryDate: {weekday: 'long', year: 'numeric', month: 'long', day: 'numeric', hour: 'numeric', minute: 'numeric', hour12: false, timeZoneName: 'short'}}})}
+              />
+            </ListItem>
+          )}
         </List>
         {/* Password */}
         <Box sx={{p: 2, display: 'flex', flexDirection: 'column', alignItems: 'right'}}>
@@ -115,6 +129,10 @@ function Me() {
           )}
         </Box>
       </Paper>
+      {/* Per-device credentials */}
+      <Credentials onError={setErrorMessage} />
+      {/* Authenticator app for the VPN */}
+      <TOTP onError={setErrorMessage} />
     </Container>
   );
 }
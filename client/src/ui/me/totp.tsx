@@ -0,0 +1,123 @@
+import React from 'react';
+import {PhonelinkLockRounded} from '@mui/icons-material';
+import {Box, Button, ListItem, ListItemIcon, ListItemText, Paper, TextField, Typography} from '@mui/material';
+import {Trans, useTranslation} from 'react-i18next';
+
+import useMountEffect from '../@hooks/use-mount';
+import {TOTPEnrollment, TOTPStatus} from '../../models/totp';
+import {useUserService} from '../../services/user/user-service';
+
+type TOTPProps = {
+  onError: (message: string) => void,
+}
+
+// TOTP enrolls an authenticator app, the VPN then asks for its code along with the password.
+function TOTP({onError}: TOTPProps) {
+  const userService = useUserService();
+  const [status, setStatus] = React.useState<TOTPStatus|null>(null);
+  const [enrollment, setEnrollment] = React.useState<TOTPEnrollment|null>(null);
+  const [code, setCode] = React.useState('');
+  const [busy, setBusy] = React.useState(false);
+  const {t} = useTranslation();
+
+  const refresh = () => {
+    userService.myTOTPStatus((totpStatus) => {
+      if (totpStatus == null) {
+        onError(t('totp.errorFailedToGetStatus'));
+      }
+      setStatus(totpStatus);
+    });
+  };
+
+  const enroll = () => {
+    setBusy(true);
+    userService.enrollMyTOTP((newEnrollment) => {
+      setBusy(false);
+      if (newEnrollment == null) {
+        onError(t('totp.errorFailedToEnroll'));
+      }
+      setEnrollment(newEnrollment);
+      setCode('');
+    });
+  };
+
+  const confirm = () => {
+    setBusy(true);
+    userService.confirmMyTOTP(code.trim(), (result) => {
+      setBusy(false);
+      if (result != 'success') {
+        onError(t('totp.errorInvalidCode'));
+        return;
+      }
+      setEnrollment(null);
+      refresh();
+    });
+  };
+
+  const disable = () => {
+    userService.disableMyTOTP((result) => {
+      if (result != 'success') {
+        onError(t('totp.errorFailedToDisable'));
+      }
+      setEnrollment(null);
+      refresh();
+    });
+  };
+
+  useMountEffect(refresh);
+
+  const dateFormat = {year: 'numeric', month: 'long', day: 'numeric'};
+
+  return (
+    <Paper variant="outlined" sx={{marginTop: 4}}>
+      <Typography component="h2" variant="h6" sx={{px: 2, pt: 2}}>
+        <Trans i18nKey='totp.title' />
+      </Typography>
+      <Typography variant="body2" color="text.secondary" sx={{px: 2}}>
+        <Trans i18nKey='totp.instructions' />
+      </Typography>
+      <ListItem>
+        <ListItemIcon>
+          <PhonelinkLockRounded />
+        </ListItemIcon>
+        <ListItemText
+          primary={status?.enabled ? t('totp.enabled') : t('totp.disabled')}
+          secondary={status?.enabledAt != null ? t('totp.enabledDate', {enabledDate: status.enabledAt, formatParams: {enabledDate: dateFormat}}) : null}
+        />
+      </ListItem>
+      <Box sx={{p: 2, display: 'flex', flexDirection: 'column', gap: 2}}>
+        { enrollment != null && (
+          <>
+            <Typography variant="body2"><Trans i18nKey='totp.scanInstructions' /></Typography>
+            <Box component="img" src={enrollment.qrCode} alt={t('totp.qrCodeAlt')} sx={{width: 200, height: 200, alignSelf: 'center'}} />
+            <Typography variant="body2" sx={{fontFamily: 'monospace', wordBreak: 'break-all', alignSelf: 'center'}}>{enrollment.secret}</Typography>
+            <Box sx={{display: 'flex', gap: 1}}>
+              <TextField
+                size="small"
+                label={t('totp.codeLabel')}
+                value={code}
+                onChange={(event) => setCode(event.target.value)}
+                inputProps={{maxLength: 6, inputMode: 'numeric', autoComplete: 'one-time-code'}}
+                sx={{flexGrow: 1}}
+              />
+              <Button variant="contained" onClick={confirm} disabled={busy || code.trim().length != 6}>
+                <Trans i18nKey='totp.confirm' />
+              </Button>
+            </Box>
+          </>
+        )}
+        { status?.enabled ? (
+          <Button variant="outlined" color="error" onClick={disable}>
+            <Trans i18nKey='totp.disable' />
+          </Button>
+        ) : enrollment == null && (
+          <Button variant="contained" onClick={enroll} disabled={busy || status == null}>
+            <Trans i18nKey='totp.enroll' />
+          </Button>
+        )}
+      </Box>
+    </Paper>
+  );
+}
+
+export default TOTP;
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/p-l/fringe/internal/system"
+)
+
+const commandsUsage = `usage: fringe [command]
+
+Without a command fringe starts its web and radius servers.
+
+commands:
+  users export [-format json|csv] [-output file]
+        write every user, including password hashes, to a file or stdout
+  users import -input file [-format json|csv] [-dry-run] [-conflict skip|overwrite|fail]
+        create users from a file, new users without password get a generated one
+  bench-hash [-target duration] [-max-memory KiB] [-parallelism n]
+        suggest [security.password-hash] values hashing a password in about target
+  rotate-keys
+        re-encrypt every user with a new data key, fringe must be stopped
+  restore -input file
+        verify a snapshot then replace the user database with it, fringe must be stopped
+`
+
+const (
+	benchHashTarget      = 500 * time.Millisecond
+	benchHashMaxMemory   = 1024 * 1024
+	benchHashParallelism = 2
+)
+
+var errUnknownCommand = errors.New("unknown command")
+
+// runCommand executes an administration command and returns the process exit code.
+func runCommand(args []string) int {
+	var err error
+
+	switch {
+	case len(args) >= 2 && args[0] == "users" && args[1] == "export":
+		err = runUsersExport(args[2:])
+	case len(args) >= 2 && args[0] == "users" && args[1] == "import":
+		err = runUsersImport(args[2:])
+	case args[0] == "bench-hash":
+		err = runBenchHash(args[1:])
+	case args[0] == "rotate-keys":
+		err = runRotateKeys()
+	case args[0] == "restore":
+		err = runRestore(args[1:])
+	case args[0] == "help" || args[0] == "-h" || args[0] == "--help":
+		fmt.Print(commandsUsage)
+
+		return 0
+	default:
+		err = fmt.Errorf("%w: %s", errUnknownCommand, strings.Join(args, " "))
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fringe: %v\n", err)
+
+		if errors.Is(err, errUnknownCommand) {
+			fmt.Fprint(os.Stderr, commandsUsage)
+		}
+
+		return 1
+	}
+
+	return 0
+}
+
+// formatFromFilename returns the format matching the file extension or json when it cannot be guessed.
+func formatFromFilename(format string, filename string) string {
+	if len(format) > 0 {
+		return strings.ToLower(format)
+	}
+
+	if strings.EqualFold(filepath.Ext(filename), "."+repos.TransferFormatCSV) {
+		return repos.TransferFormatCSV
+	}
+
+	return repos.TransferFormatJSON
+}
+
+func runUsersExport(args []string) error {
+	flags := flag.NewFlagSet("users export", flag.ContinueOnError)
+	format := flags.String("format", "", "output format: json or csv (defaults to output file extension)")
+	output := flags.String("output", "", "output file (defaults to stdout)")
+
+	if err := flags.Parse(args); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	var writer io.Writer = os.Stdout
+
+	if len(*output) > 0 {
+		file, err := os.OpenFile(filepath.Clean(*output), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, exportFilePermission)
+		if err != nil {
+			return fmt.Errorf("could not create %s: %w", *output, err)
+		}
+		defer func() { _ = file.Close() }() //nolint:wsl
+
+		writer = file
+	}
+
+	config := loadConfig()
+	db := openDB(config.Storage.UserDatabaseFile)
+	defer func() { _ = db.Close() }() //nolint:wsl
+
+	return openTransferRepo(db, config).ExportUsers(context.Background(), writer, formatFromFilename(*format, *output)) //nolint:wrapcheck
+}
+
+// openTransferRepo returns a repository without timeouts, bulk transfers take as long as the file size requires.
+func openTransferRepo(db *sqlx.DB, config system.Config) *repos.UserRepository {
+	secrets := system.LoadSecretsFromFile(config.Storage.SecretsFile)
+	userRepo := openUserRepo(db, config, secrets)
+	userRepo.SetTimeouts(repos.Timeouts{})
+
+	return userRepo
+}
+
+func runUsersImport(args []string) error {
+	flags := flag.NewFlagSet("users import", flag.ContinueOnError)
+	format := flags.String("format", "", "input format: json or csv (defaults to input file extension)")
+	input := flags.String("input", "", "file to import")
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without changing the database")
+	conflictFlag := flags.String("conflict", string(repos.ConflictSkip), "existing users are: skip, overwrite or fail")
+
+	if err := flags.Parse(args); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if len(*input) == 0 {
+		flags.Usage()
+
+		return fmt.Errorf("%w: users import requires -input", errUnknownCommand)
+	}
+
+	conflict, err := repos.ParseConflictPolicy(*conflictFlag)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	file, err := os.Open(filepath.Clean(*input))
+	if err != nil {
+		return fmt.Errorf("could not open %s: %w", *input, err)
+	}
+	defer func() { _ = file.Close() }() //nolint:wsl
+
+	records, err := repos.DecodeUserImport(file, formatFromFilename(*format, *input))
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	config := loadConfig()
+	db := openDB(config.Storage.UserDatabaseFile)
+	defer func() { _ = db.Close() }() //nolint:wsl
+
+	report, importErr := openTransferRepo(db, config).ImportUsers(context.Background(), records, repos.ImportOptions{
+		DryRun:        *dryRun,
+		Conflict:      conflict,
+		AllowedDomain: config.Security.AllowedDomain,
+	})
+	if report != nil {
+		// The report holds the generated passwords, it is the only place where they are available
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", " ")
+		_ = encoder.Encode(report)
+	}
+
+	return importErr //nolint:wrapcheck
+}
+
+// runRotateKeys re-encrypts users with a new data key, running servers would keep using the previous one.
+func runRotateKeys() error {
+	config := loadConfig()
+	if !config.Storage.Encryption {
+		return repos.ErrEncryptionDisabled
+	}
+
+	db := openDB(config.Storage.UserDatabaseFile)
+	defer func() { _ = db.Close() }() //nolint:wsl
+
+	if err := openTransferRepo(db, config).RotateEncryptionKey(context.Background()); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	fmt.Println("users re-encrypted with a new data key")
+
+	return nil
+}
+
+// runRestore replaces the database with a verified snapshot, the replaced database is kept next to it.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	input := flags.String("input", "", "snapshot file to restore")
+
+	if err := flags.Parse(args); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if len(*input) == 0 {
+		flags.Usage()
+
+		return fmt.Errorf("%w: restore requires -input", errUnknownCommand)
+	}
+
+	config := loadConfig()
+	secrets := system.LoadSecretsFromFile(config.Storage.SecretsFile)
+
+	snapshot, previous, err := repos.RestoreSnapshot(context.Background(), *input, config.Storage.UserDatabaseFile, secrets.Audit)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	fmt.Printf("restored %s (sha256 %s)\n", snapshot.File, snapshot.SHA256)
+
+	tables := make([]string, 0, len(snapshot.Tables))
+	for table := range snapshot.Tables {
+		tables = append(tables, table)
+	}
+
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		fmt.Printf("  %s: %d row(s)\n", table, snapshot.Tables[table])
+	}
+
+	if len(previous) > 0 {
+		fmt.Printf("previous database moved to %s\n", previous)
+	}
+
+	return nil
+}
+
+func runBenchHash(args []string) error {
+	flags := flag.NewFlagSet("bench-hash", flag.ContinueOnError)
+	target := flags.Duration("target", benchHashTarget, "time a single password hash should take")
+	maxMemory := flags.Uint("max-memory", benchHashMaxMemory, "maximum memory used by a single hash in KiB")
+	parallelism := flags.Uint("parallelism", benchHashParallelism, "number of threads used by a single hash")
+
+	if err := flags.Parse(args); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if *maxMemory > math.MaxUint32 || *parallelism > math.MaxUint8 {
+		return fmt.Errorf("%w: max-memory or parallelism too large", repos.ErrInvalidHashParams)
+	}
+
+	params, duration, err := repos.BenchmarkHashParams(*target, uint32(*maxMemory), uint8(*parallelism))
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	fmt.Printf("# hashing took %v\n", duration.Round(time.Millisecond))
+	fmt.Println("[security.password-hash]")
+	fmt.Printf("memory = %d\n", params.Memory)
+	fmt.Printf("iterations = %d\n", params.Iterations)
+	fmt.Printf("parallelism = %d\n", params.Parallelism)
+
+	return nil
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mrz1836/go-sanitize"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// APITokenHandler lets users manage their personal API tokens and admins manage service accounts and their tokens.
+// Tokens are managed with access tokens only, an API token cannot create or revoke tokens.
+type APITokenHandler struct {
+	tokens     *repos.APITokenRepository
+	auditRepo  *repos.AuditRepository
+	authHelper *helpers.AuthHelper
+}
+
+type APITokenCreateRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expires_at"`
+}
+
+type APITokenResponse struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Owner      string   `json:"owner"`
+	Scopes     []string `json:"scopes"`
+	CreatedBy  string   `json:"created_by"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	LastUsedAt int64    `json:"last_used_at"`
+	RevokedAt  int64    `json:"revoked_at"`
+	Token      string   `json:"token,omitempty"`
+}
+
+type ServiceAccountCreateRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var (
+	errScopeNotGranted = errors.New("scope is not granted")
+	errScopeNotKept    = errors.New("scope is only granted by the identity provider or groups, api tokens cannot keep it")
+)
+
+func NewAPITokenHandler(tokens *repos.APITokenRepository, auditRepo *repos.AuditRepository, authHelper *helpers.AuthHelper) *APITokenHandler {
+	return &APITokenHandler{
+		tokens:     tokens,
+		auditRepo:  auditRepo,
+		authHelper: authHelper,
+	}
+}
+
+func newAPITokenResponse(apiToken *repos.APIToken, token string) APITokenResponse {
+	return APITokenResponse{
+		ID:         apiToken.ID,
+		Name:       apiToken.Name,
+		Owner:      apiToken.Owner,
+		Scopes:     apiToken.ScopeList(),
+		CreatedBy:  apiToken.CreatedBy,
+		CreatedAt:  apiToken.CreatedAt,
+		ExpiresAt:  apiToken.ExpiresAt,
+		LastUsedAt: apiToken.LastUsedAt,
+		RevokedAt:  apiToken.RevokedAt,
+		Token:      token,
+	}
+}
+
+// grantedScopes returns an error unless every scope is a permission of the claims, tokens never exceed their creator.
+func grantedScopes(claims *helpers.AuthClaims, scopes []string) ([]string, error) {
+	granted := make([]string, 0, len(scopes))
+
+	for _, scope := range scopes {
+		permission := helpers.Permission(sanitize.SingleLine(scope))
+		if !helpers.IsKnownPermission(permission) || !claims.Can(permission) {
+			return nil, fmt.Errorf("%w: %s", errScopeNotGranted, permission)
+		}
+
+		granted = append(granted, string(permission))
+	}
+
+	return granted, nil
+}
+
+// keptScopes returns an error unless every scope is a permission of role. Personal tokens authenticate with the
+// configured role of their owner, roles granted at login by the identity provider or groups are not known to them.
+func keptScopes(role string, scopes []string) error {
+	kept := map[string]bool{}
+	for _, permission := range helpers.PermissionsForRole(role) {
+		kept[string(permission)] = true
+	}
+
+	for _, scope := range scopes {
+		if !kept[scope] {
+			return fmt.Errorf("%w: %s", errScopeNotKept, scope)
+		}
+	}
+
+	return nil
+}
+
+func (h *APITokenHandler) list(httpResponse http.ResponseWriter, httpRequest *http.Request, ownerType string, owner string) {
+	tokens, err := h.tokens.Tokens(httpRequest.Context(), ownerType, owner)
+	if err != nil {
+		log.Printf("APIToken/List [%v]: could not list %s tokens: %v", httpRequest.RemoteAddr, owner, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := make([]APITokenResponse, 0, len(tokens))
+	for index := range tokens {
+		response = append(response, newAPITokenResponse(&tokens[index], ""))
+	}
+
+	jsonResponse, jsonErr := json.Marshal(response)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+func (h *APITokenHandler) create(httpResponse http.ResponseWriter, httpRequest *http.Request, claims *helpers.AuthClaims, ownerType string, owner string) {
+	var request APITokenCreateRequest
+
+	if err := json.NewDecoder(httpRequest.Body).Decode(&request); err != nil {
+		log.Printf("APIToken/Create [src:%v] invalid post data %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable decode request", http.StatusBadRequest)
+
+		return
+	}
+
+	scopes, err := grantedScopes(claims, request.Scopes)
+	if err == nil && ownerType == repos.APITokenOwnerUser {
+		err = keptScopes(h.authHelper.RoleForEmail(owner), scopes)
+	}
+
+	if err != nil {
+		log.Printf("APIToken/Create [%v]: %s requested a token for %s with %v", httpRequest.RemoteAddr, claims.Email, owner, err)
+		http.Error(httpResponse, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	apiToken, token, err := h.tokens.Create(httpRequest.Context(), ownerType, owner, sanitize.SingleLine(request.Name), scopes, request.ExpiresAt, claims.Email)
+	if err != nil {
+		log.Printf("APIToken/Create [%v]: could not add token to %s: %v", httpRequest.RemoteAddr, owner, err)
+
+		switch {
+		case errors.Is(err, repos.ErrInvalidAPIToken), errors.Is(err, repos.ErrTooManyAPITokens):
+			http.Error(httpResponse, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, repos.ErrServiceAccountNotFound):
+			http.Error(httpResponse, err.Error(), http.StatusNotFound)
+		default:
+			recordAudit(h.auditRepo, httpRequest, repos.AuditActionAPITokenCreate, owner, actionResultFailed)
+			renderRepositoryError(httpResponse, err, "failed to create api token", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	log.Printf("APIToken/Create [%v]: %s added token %s to %s", httpRequest.RemoteAddr, claims.Email, apiToken.ID, owner)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionAPITokenCreate, owner+"/"+apiToken.ID, actionResultSuccess)
+
+	jsonResponse, jsonErr := json.Marshal(newAPITokenResponse(apiToken, token))
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+func (h *APITokenHandler) revoke(httpResponse http.ResponseWriter, httpRequest *http.Request, ownerType string, owner string) {
+	id := sanitize.AlphaNumeric(mux.Vars(httpRequest)["id"], false)
+	target := owner + "/" + id
+
+	err := h.tokens.Revoke(httpRequest.Context(), ownerType, owner, id)
+	if err != nil {
+		log.Printf("APIToken/Revoke [%v]: could not revoke %s: %v", httpRequest.RemoteAddr, target, err)
+
+		if errors.Is(err, repos.ErrAPITokenNotFound) {
+			http.Error(httpResponse, "api token not found", http.StatusNotFound)
+
+			return
+		}
+
+		recordAudit(h.auditRepo, httpRequest, repos.AuditActionAPITokenRevoke, target, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to revoke api token", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("APIToken/Revoke [%v]: revoked %s", httpRequest.RemoteAddr, target)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionAPITokenRevoke, target, actionResultSuccess)
+
+	renderActionResponse(httpResponse, httpRequest, &UserActionResponse{Result: actionResultSuccess})
+}
+
+// ListPersonal returns the API tokens of the current user, revoked and expired ones included.
+func (h *APITokenHandler) ListPersonal(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	claims, ok := selfServiceClaims(httpResponse, httpRequest)
+	if !ok {
+		return
+	}
+
+	h.list(httpResponse, httpRequest, repos.APITokenOwnerUser, claims.Email)
+}
+
+// CreatePersonal adds an API token acting as the current user, its scopes must be permissions of the user.
+// The token is only returned in this response.
+func (h *APITokenHandler) CreatePersonal(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	claims, ok := selfServiceClaims(httpResponse, httpRequest)
+	if !ok {
+		return
+	}
+
+	h.create(httpResponse, httpRequest, claims, repos.APITokenOwnerUser, claims.Email)
+}
+
+// RevokePersonal stops an API token of the current user from authenticating.
+func (h *APITokenHandler) RevokePersonal(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	claims, ok := selfServiceClaims(httpResponse, httpRequest)
+	if !ok {
+		return
+	}
+
+	h.revoke(httpResponse, httpRequest, repos.APITokenOwnerUser, claims.Email)
+}
+
+// serviceAccountClaims returns the claims of the request when it may manage service accounts.
+func serviceAccountClaims(httpResponse http.ResponseWriter, httpRequest *http.Request) (*helpers.AuthClaims, bool) {
+	claims, ok := selfServiceClaims(httpResponse, httpRequest)
+	if !ok {
+		return nil, false
+	}
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionServiceAccounts) {
+		http.Error(httpResponse, "not authorized", http.StatusUnauthorized)
+
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// ListServiceAccounts returns every service account.
+func (h *APITokenHandler) ListServiceAccounts(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if _, ok := serviceAccountClaims(httpResponse, httpRequest); !ok {
+		return
+	}
+
+	accounts, err := h.tokens.ServiceAccounts(httpRequest.Context())
+	if err != nil {
+		log.Printf("ServiceAccount/List [%v]: could not list service accounts: %v", httpRequest.RemoteAddr, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
+	}
+
+	jsonResponse, jsonErr := json.Marshal(accounts)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// CreateServiceAccount adds a service account, it has no tokens until some are created for it.
+func (h *APITokenHandler) CreateServiceAccount(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	claims, ok := serviceAccountClaims(httpResponse, httpRequest)
+	if !ok {
+		return
+	}
+
+	var request ServiceAccountCreateRequest
+
+	if err := json.NewDecoder(httpRequest.Body).Decode(&request); err != nil {
+		log.Printf("ServiceAccount/Create [src:%v] invalid post data %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable decode request", http.StatusBadRequest)
+
+		return
+	}
+
+	account, err := h.tokens.CreateServiceAccount(httpRequest.Context(), request.Name, sanitize.SingleLine(request.Description), claims.Email)
+	if err != nil {
+		log.Printf("ServiceAccount/Create [%v]: could not create %s: %v", httpRequest.RemoteAddr, sanitize.SingleLine(request.Name), err)
+
+		switch {
+		case errors.Is(err, repos.ErrInvalidServiceAccount):
+			http.Error(httpResponse, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, repos.ErrServiceAccountExists):
+			http.Error(httpResponse, err.Error(), http.StatusConflict)
+		default:
+			recordAudit(h.auditRepo, httpRequest, repos.AuditActionServiceAccountCreate, request.Name, actionResultFailed)
+			renderRepositoryError(httpResponse, err, "failed to create service account", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	log.Printf("ServiceAccount/Create [%v]: %s created %s", httpRequest.RemoteAddr, claims.Email, account.Name)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionServiceAccountCreate, account.Name, actionResultSuccess)
+
+	jsonResponse, jsonErr := json.Marshal(account)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// DeleteServiceAccount removes the service account and revokes its tokens.
+func (h *APITokenHandler) DeleteServiceAccount(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if _, ok := serviceAccountClaims(httpResponse, httpRequest); !ok {
+		return
+	}
+
+	name := mux.Vars(httpRequest)["name"]
+
+	err := h.tokens.DeleteServiceAccount(httpRequest.Context(), name)
+	if err != nil {
+		log.Printf("ServiceAccount/Delete [%v]: could not delete %s: %v", httpRequest.RemoteAddr, sanitize.SingleLine(name), err)
+
+		if errors.Is(err, repos.ErrServiceAccountNotFound) {
+			http.Error(httpResponse, "service account not found", http.StatusNotFound)
+
+			return
+		}
+
+		recordAudit(h.auditRepo, httpRequest, repos.AuditActionServiceAccountDelete, name, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to delete service account", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("ServiceAccount/Delete [%v]: deleted %s", httpRequest.RemoteAddr, name)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionServiceAccountDelete, name, actionResultSuccess)
+
+	renderActionResponse(httpResponse, httpRequest, &UserActionResponse{Result: actionResultSuccess})
+}
+
+// ListServiceAccountTokens returns the API tokens of the service account, revoked and expired ones included.
+func (h *APITokenHandler) ListServiceAccountTokens(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if _, ok := serviceAccountClaims(httpResponse, httpRequest); !ok {
+		return
+	}
+
+	h.list(httpResponse, httpRequest, repos.APITokenOwnerServiceAccount, mux.Vars(httpRequest)["name"])
+}
+
+// CreateServiceAccountToken adds an API token to the service account, its scopes must be permissions of the admin.
+// The token is only returned in this response.
+func (h *APITokenHandler) CreateServiceAccountToken(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	claims, ok := serviceAccountClaims(httpResponse, httpRequest)
+	if !ok {
+		return
+	}
+
+	h.create(httpResponse, httpRequest, claims, repos.APITokenOwnerServiceAccount, mux.Vars(httpRequest)["name"])
+}
+
+// RevokeServiceAccountToken stops an API token of the service account from authenticating.
+func (h *APITokenHandler) RevokeServiceAccountToken(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if _, ok := serviceAccountClaims(httpResponse, httpRequest); !ok {
+		return
+	}
+
+	h.revoke(httpResponse, httpRequest, repos.APITokenOwnerServiceAccount, mux.Vars(httpRequest)["name"])
+}
@@ -0,0 +1,225 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/httpd/middlewares"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func createAPITokenHandler(t *testing.T) (*handlers.APITokenHandler, *repos.APITokenRepository, *repos.AuditRepository) {
+	t.Helper()
+
+	_, _, auditRepo := createUserHandlerWithAudit(t)
+	tokenRepo := mocks.NewMockAPITokenRepository(t)
+
+	authHelper := helpers.NewAuthHelper("test.com", "secret", []string{adminEmail})
+
+	return handlers.NewAPITokenHandler(tokenRepo, auditRepo, authHelper), tokenRepo, auditRepo
+}
+
+// makeRequestToHandlerWithAPIToken sends req with a new API token of email, an existing user of userRepo.
+func makeRequestToHandlerWithAPIToken(t *testing.T, userRepo *repos.UserRepository, email string, scopes []string, path string, handler func(http.ResponseWriter, *http.Request), req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	tokenRepo := mocks.NewMockAPITokenRepository(t)
+
+	_, token, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, email, "script", scopes, 0, email)
+	if err != nil {
+		t.Fatalf("Could not add API token to test database: %v", err)
+	}
+
+	authHelper := helpers.NewAuthHelper("test.com", "secret", []string{adminEmail})
+	authMiddleware := middlewares.NewAuthMiddleware("/auth", []string{"/"}, []string{}, authHelper)
+	authMiddleware.SetAPITokens(tokenRepo, userRepo)
+
+	router := mux.NewRouter()
+	router.Use(authMiddleware.EnsureAuth)
+	router.HandleFunc(path, handler)
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	return res
+}
+
+func TestAPITokenHandler_CreatePersonal(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns the token once and it authenticates", func(t *testing.T) {
+		t.Parallel()
+
+		tokenHandler, tokenRepo, auditRepo := createAPITokenHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/tokens/", strings.NewReader(`{"name":"Provisioning","scopes":["users:read","users:create"]}`))
+		res := makeRequestToHandlerWithClaims(claims, "/tokens/", tokenHandler.CreatePersonal, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var created handlers.APITokenResponse
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+		assert.Equal(t, "Provisioning", created.Name)
+		assert.Equal(t, adminEmail, created.Owner)
+		assert.Equal(t, []string{"users:read", "users:create"}, created.Scopes)
+		assert.NotEmpty(t, created.Token)
+
+		apiToken, err := tokenRepo.Authenticate(context.Background(), created.Token, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, created.ID, apiToken.ID)
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionAPITokenCreate})
+		assert.Len(t, entries, 1)
+
+		// Listing never returns tokens
+		req = httptest.NewRequest(http.MethodGet, "/tokens/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/tokens/", tokenHandler.ListPersonal, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var listed []handlers.APITokenResponse
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&listed))
+		assert.Len(t, listed, 1)
+		assert.Empty(t, listed[0].Token)
+		assert.NotZero(t, listed[0].LastUsedAt)
+	})
+
+	t.Run("Refuses scopes the user was not granted", func(t *testing.T) {
+		t.Parallel()
+
+		tokenHandler, _, _ := createAPITokenHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		for _, scopes := range []string{`["users:read"]`, `["unknown"]`} {
+			req := httptest.NewRequest(http.MethodPost, "/tokens/", strings.NewReader(`{"name":"script","scopes":`+scopes+`}`))
+			res := makeRequestToHandlerWithClaims(claims, "/tokens/", tokenHandler.CreatePersonal, req)
+			assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode, scopes)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/tokens/", strings.NewReader(`{"name":"script","scopes":[]}`))
+		res := makeRequestToHandlerWithClaims(claims, "/tokens/", tokenHandler.CreatePersonal, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+	})
+
+	t.Run("Refuses scopes only granted by groups", func(t *testing.T) {
+		t.Parallel()
+
+		tokenHandler, _, _ := createAPITokenHandler(t)
+		// A group mapped admin, its configured role is user
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/tokens/", strings.NewReader(`{"name":"script","scopes":["users:read"]}`))
+		res := makeRequestToHandlerWithClaims(claims, "/tokens/", tokenHandler.CreatePersonal, req)
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+		assert.Contains(t, res.Body.String(), "cannot keep")
+	})
+
+	t.Run("Refuses requests sent with an API token", func(t *testing.T) {
+		t.Parallel()
+
+		tokenHandler, _, _ := createAPITokenHandler(t)
+		userHandler, userRepo := createUserHandler(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/"+regularUserEmail+"/", nil)
+		res := makeRequestToHandlerWithAPIToken(t, userRepo, adminEmail, []string{"users:read"}, "/users/{email}/", userHandler.View, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode, "the token authenticates")
+
+		req = httptest.NewRequest(http.MethodPost, "/tokens/", strings.NewReader(`{"name":"copy","scopes":["users:read"]}`))
+		res = makeRequestToHandlerWithAPIToken(t, userRepo, adminEmail, []string{"users:read"}, "/tokens/", tokenHandler.CreatePersonal, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+	})
+}
+
+func TestAPITokenHandler_RevokePersonal(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Revokes own token only", func(t *testing.T) {
+		t.Parallel()
+
+		tokenHandler, tokenRepo, _ := createAPITokenHandler(t)
+		apiToken, token, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, regularUserEmail, "script", nil, 0, regularUserEmail)
+		assert.NoError(t, err)
+
+		otherClaims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		req := httptest.NewRequest(http.MethodDelete, "/tokens/"+apiToken.ID+"/", nil)
+		res := makeRequestToHandlerWithClaims(otherClaims, "/tokens/{id}/", tokenHandler.RevokePersonal, req)
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+		req = httptest.NewRequest(http.MethodDelete, "/tokens/"+apiToken.ID+"/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/tokens/{id}/", tokenHandler.RevokePersonal, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		_, err = tokenRepo.Authenticate(context.Background(), token, time.Now())
+		assert.ErrorIs(t, err, repos.ErrAPITokenNotFound)
+	})
+}
+
+func TestAPITokenHandler_ServiceAccounts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return unauthorized without the service accounts permission", func(t *testing.T) {
+		t.Parallel()
+
+		tokenHandler, _, _ := createAPITokenHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/service-accounts/", strings.NewReader(`{"name":"provisioning"}`))
+		res := makeRequestToHandlerWithClaims(claims, "/service-accounts/", tokenHandler.CreateServiceAccount, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodGet, "/service-accounts/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/service-accounts/", tokenHandler.ListServiceAccounts, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Creates a service account with a token and deletes it", func(t *testing.T) {
+		t.Parallel()
+
+		tokenHandler, tokenRepo, auditRepo := createAPITokenHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/service-accounts/", strings.NewReader(`{"name":"provisioning","description":"Creates users"}`))
+		res := makeRequestToHandlerWithClaims(claims, "/service-accounts/", tokenHandler.CreateServiceAccount, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodPost, "/service-accounts/", strings.NewReader(`{"name":"provisioning"}`))
+		res = makeRequestToHandlerWithClaims(claims, "/service-accounts/", tokenHandler.CreateServiceAccount, req)
+		assert.Equal(t, http.StatusConflict, res.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodPost, "/service-accounts/provisioning/tokens/", strings.NewReader(`{"name":"script","scopes":["users:create"]}`))
+		res = makeRequestToHandlerWithClaims(claims, "/service-accounts/{name}/tokens/", tokenHandler.CreateServiceAccountToken, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var created handlers.APITokenResponse
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+		assert.Equal(t, "provisioning", created.Owner)
+		assert.Equal(t, adminEmail, created.CreatedBy)
+
+		req = httptest.NewRequest(http.MethodPost, "/service-accounts/unknown/tokens/", strings.NewReader(`{"name":"script","scopes":[]}`))
+		res = makeRequestToHandlerWithClaims(claims, "/service-accounts/{name}/tokens/", tokenHandler.CreateServiceAccountToken, req)
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodDelete, "/service-accounts/provisioning/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/service-accounts/{name}/", tokenHandler.DeleteServiceAccount, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		_, err := tokenRepo.Authenticate(context.Background(), created.Token, time.Now())
+		assert.ErrorIs(t, err, repos.ErrAPITokenNotFound)
+
+		for _, action := range []string{repos.AuditActionServiceAccountCreate, repos.AuditActionAPITokenCreate, repos.AuditActionServiceAccountDelete} {
+			entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: action})
+			assert.Len(t, entries, 1, action)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/mrz1836/go-sanitize"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+type AuditHandler struct {
+	auditRepo *repos.AuditRepository
+}
+
+type AuditEntryResponse struct {
+	Sequence int64  `json:"sequence"`
+	At       int64  `json:"at"`
+	Actor    string `json:"actor"`
+	Action   string `json:"action"`
+	Target   string `json:"target"`
+	SourceIP string `json:"source_ip"`
+	Result   string `json:"result"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+type AuditVerifyResponse struct {
+	Valid    bool  `json:"valid"`
+	Checked  int64 `json:"checked"`
+	BrokenAt int64 `json:"broken_at"`
+}
+
+const auditFormatCSV = "csv"
+
+func NewAuditHandler(auditRepo *repos.AuditRepository) *AuditHandler {
+	return &AuditHandler{
+		auditRepo: auditRepo,
+	}
+}
+
+// sourceIPFromRequest returns the IP part of the request remote address.
+func sourceIPFromRequest(httpRequest *http.Request) string {
+	host, _, err := net.SplitHostPort(httpRequest.RemoteAddr)
+	if err != nil {
+		return httpRequest.RemoteAddr
+	}
+
+	return host
+}
+
+// recordAudit appends an entry to the audit log, failures are logged as they must not prevent the action response.
+func recordAudit(auditRepo *repos.AuditRepository, httpRequest *http.Request, action string, target string, result string) {
+	if auditRepo == nil {
+		return
+	}
+
+	actor := ""
+	if claims, ok := helpers.AuthClaimsFromContext(httpRequest.Context()); ok {
+		actor = claims.Email
+	}
+
+	// The entry must be recorded even when the client is already gone
+	_, err := auditRepo.Append(context.Background(), actor, action, target, sourceIPFromRequest(httpRequest), result)
+	if err != nil {
+		log.Printf("Audit [src:%v]: failed to record %s on %s by %s: %v", httpRequest.RemoteAddr, action, target, actor, err)
+	}
+}
+
+func int64QueryValue(httpRequest *http.Request, key string) int64 {
+	value := sanitize.Numeric(httpRequest.URL.Query().Get(key))
+	if len(value) == 0 {
+		return 0
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("Audit [%v]: Could not parse %s '%s', ignoring", httpRequest.RemoteAddr, key, value)
+
+		return 0
+	}
+
+	return parsed
+}
+
+func renderAuditCSV(httpResponse http.ResponseWriter, httpRequest *http.Request, entries []repos.AuditEntry) {
+	httpResponse.Header().Set("Content-Type", "text/csv")
+	httpResponse.Header().Set("Content-Disposition", "attachment; filename=\"fringe-audit.csv\"")
+
+	writer := csv.NewWriter(httpResponse)
+	records := [][]string{{"sequence", "at", "actor", "action", "target", "source_ip", "result", "prev_hash", "hash"}}
+
+	for _, entry := range entries {
+		records = append(records, []string{
+			strconv.FormatInt(entry.Sequence, 10),
+			strconv.FormatInt(entry.At, 10),
+			entry.Actor,
+			entry.Action,
+			entry.Target,
+			entry.SourceIP,
+			entry.Result,
+			entry.PrevHash,
+			entry.Hash,
+		})
+	}
+
+	if err := writer.WriteAll(records); err != nil {
+		log.Printf("Audit/List [src:%v] failed to send csv response: %v", httpRequest.RemoteAddr, err)
+	}
+}
+
+// List returns the audit log entries matching the actor, action, target, since and until query parameters.
+// The format query parameter selects between json (default) and csv.
+func (a *AuditHandler) List(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionAuditRead) {
+		http.Error(httpResponse, "not authorized to read audit log", http.StatusUnauthorized)
+
+		return
+	}
+
+	query := httpRequest.URL.Query()
+	filter := repos.AuditFilter{
+		Actor:  sanitize.Email(query.Get("actor"), false),
+		Action: sanitize.SingleLine(query.Get("action")),
+		Target: sanitize.SingleLine(query.Get("target")),
+		Since:  int64QueryValue(httpRequest, "since"),
+		Until:  int64QueryValue(httpRequest, "until"),
+		Limit:  int(int64QueryValue(httpRequest, "limit")),
+	}
+
+	entries, err := a.auditRepo.Find(httpRequest.Context(), filter)
+	if err != nil {
+		log.Printf("Audit/List [%v]: could not get audit log: %v", httpRequest.RemoteAddr, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
+	}
+
+	if sanitize.AlphaNumeric(query.Get("format"), false) == auditFormatCSV {
+		renderAuditCSV(httpResponse, httpRequest, entries)
+
+		return
+	}
+
+	response := make([]AuditEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, AuditEntryResponse(entry))
+	}
+
+	jsonResponse, jsonErr := json.Marshal(response)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// Verify checks the whole audit log hash chain.
+func (a *AuditHandler) Verify(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionAuditRead) {
+		http.Error(httpResponse, "not authorized to read audit log", http.StatusUnauthorized)
+
+		return
+	}
+
+	checked, brokenAt, err := a.auditRepo.Verify(httpRequest.Context())
+	if err != nil && !errors.Is(err, repos.ErrAuditChainBroken) {
+		log.Printf("Audit/Verify [%v]: could not verify audit log: %v", httpRequest.RemoteAddr, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
+	}
+
+	if errors.Is(err, repos.ErrAuditChainBroken) {
+		log.Printf("Audit/Verify [%v]: WARNING audit log chain is broken at sequence %d", httpRequest.RemoteAddr, brokenAt)
+	}
+
+	response := AuditVerifyResponse{Valid: err == nil, Checked: checked, BrokenAt: brokenAt}
+
+	jsonResponse, jsonErr := json.Marshal(response)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
@@ -0,0 +1,156 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func createAuditHandler(t *testing.T) (*handlers.AuditHandler, *repos.AuditRepository) {
+	t.Helper()
+
+	auditRepo := mocks.NewMockAuditRepository(t)
+
+	for _, action := range []string{repos.AuditActionUserCreate, repos.AuditActionUserRenew, repos.AuditActionUserDelete} {
+		_, err := auditRepo.Append(context.Background(), adminEmail, action, regularUserEmail, "127.0.0.1", "success")
+		if err != nil {
+			t.Fatalf("Could not add entry to test audit log: %v", err)
+		}
+	}
+
+	return handlers.NewAuditHandler(auditRepo), auditRepo
+}
+
+func TestAuditHandler_List(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return unauthorized without audit permission", func(t *testing.T) {
+		t.Parallel()
+
+		auditHandler, _ := createAuditHandler(t)
+		claims := helpers.NewAuthClaims("helpdesk@test.com", "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/audit/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/audit/", auditHandler.List, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Return all entries to auditors", func(t *testing.T) {
+		t.Parallel()
+
+		auditHandler, _ := createAuditHandler(t)
+		claims := helpers.NewAuthClaims("auditor@test.com", "", "", helpers.AuditorRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/audit/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/audit/", auditHandler.List, req)
+
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var entries []handlers.AuditEntryResponse
+		err := json.Unmarshal(res.Body.Bytes(), &entries)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+	})
+
+	t.Run("Filters entries on action", func(t *testing.T) {
+		t.Parallel()
+
+		auditHandler, _ := createAuditHandler(t)
+		claims := helpers.NewAuthClaims("auditor@test.com", "", "", helpers.AuditorRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/audit/?action=%s", repos.AuditActionUserDelete), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/audit/", auditHandler.List, req)
+
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var entries []handlers.AuditEntryResponse
+		err := json.Unmarshal(res.Body.Bytes(), &entries)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, repos.AuditActionUserDelete, entries[0].Action)
+	})
+
+	t.Run("Filters entries on targets that are not emails", func(t *testing.T) {
+		t.Parallel()
+
+		auditHandler, auditRepo := createAuditHandler(t)
+		_, err := auditRepo.Append(context.Background(), adminEmail, repos.AuditActionDatabaseSnapshot, "Fringe Snapshot.db", "127.0.0.1", "success")
+		assert.NoError(t, err)
+
+		claims := helpers.NewAuthClaims("auditor@test.com", "", "", helpers.AuditorRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/audit/?target=Fringe+Snapshot.db", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/audit/", auditHandler.List, req)
+
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var entries []handlers.AuditEntryResponse
+		err = json.Unmarshal(res.Body.Bytes(), &entries)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, repos.AuditActionDatabaseSnapshot, entries[0].Action)
+	})
+
+	t.Run("Exports entries as csv", func(t *testing.T) {
+		t.Parallel()
+
+		auditHandler, _ := createAuditHandler(t)
+		claims := helpers.NewAuthClaims("auditor@test.com", "", "", helpers.AuditorRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/audit/?format=csv", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/audit/", auditHandler.List, req)
+
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assert.Equal(t, "text/csv", res.Result().Header.Get("Content-Type"))
+
+		records, err := csv.NewReader(res.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, 4)
+		assert.Equal(t, "sequence", records[0][0])
+	})
+}
+
+func TestAuditHandler_Verify(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Reports a valid chain", func(t *testing.T) {
+		t.Parallel()
+
+		auditHandler, _ := createAuditHandler(t)
+		claims := helpers.NewAuthClaims("auditor@test.com", "", "", helpers.AuditorRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/audit/verify/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/audit/verify/", auditHandler.Verify, req)
+
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.AuditVerifyResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.True(t, response.Valid)
+		assert.Equal(t, int64(3), response.Checked)
+	})
+
+	t.Run("Return unauthorized for regular users", func(t *testing.T) {
+		t.Parallel()
+
+		auditHandler, _ := createAuditHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/audit/verify/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/audit/verify/", auditHandler.Verify, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+}
@@ -1,43 +1,86 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/p-l/fringe/internal/httpd/helpers"
 	"github.com/p-l/fringe/internal/httpd/services"
 	"github.com/p-l/fringe/internal/repos"
 )
 
 type AuthHandler struct {
-	authHelper  *helpers.AuthHelper
-	googleOAuth *services.GoogleOAuthService
-	userRepo    *repos.UserRepository
+	authHelper     *helpers.AuthHelper
+	googleOAuth    *services.GoogleOAuthService
+	userRepo       *repos.UserRepository
+	providers      map[string]services.IdentityProvider
+	authorizations *services.AuthorizationRequests
+	sessions       *repos.SessionRepository
 }
 
+// LoginRequest holds the Google ID token of the user, verified locally with the Google signing keys.
 type LoginRequest struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
+	IDToken string `json:"id_token"`
 }
 
+// LoginResponse holds the access token and, when sessions are kept, the refresh token exchanged for the next one.
+// With cookie sessions both tokens are only sent in cookies and TokenType is helpers.CookieTokenType.
 type LoginResponse struct {
-	TokenType string `json:"token_type"`
-	Token     string `json:"token"`
-	Duration  int64  `json:"duration"`
-	Role      string `json:"role"`
+	TokenType    string               `json:"token_type"`
+	Token        string               `json:"token"`
+	Duration     int64                `json:"duration"`
+	RefreshToken string               `json:"refresh_token,omitempty"`
+	Role         string               `json:"role"`
+	Permissions  []helpers.Permission `json:"permissions"`
+
+	sessionExpiresAt int64
 }
 
-func NewAuthHandler(userRepo *repos.UserRepository, googleOAuthService *services.GoogleOAuthService, authHelper *helpers.AuthHelper) *AuthHandler {
+// NewAuthHandler returns a handler logging users in with the Google tokens sent by the client,
+// or with the authorization code flow of providers.
+func NewAuthHandler(userRepo *repos.UserRepository, googleOAuthService *services.GoogleOAuthService, authHelper *helpers.AuthHelper, providers ...services.IdentityProvider) *AuthHandler {
+	providersByID := make(map[string]services.IdentityProvider, len(providers))
+	for _, provider := range providers {
+		providersByID[provider.ID()] = provider
+	}
+
 	return &AuthHandler{
 		authHelper:  authHelper,
 		googleOAuth: googleOAuthService,
 		userRepo:    userRepo,
+		providers:   providersByID,
+
+		authorizations: services.NewAuthorizationRequests(),
 	}
 }
 
-// Login validates Google token and create JWT if its valid.
+// SetSessions keeps a session for each login so access tokens can be refreshed and revoked.
+func (a *AuthHandler) SetSessions(sessions *repos.SessionRepository) {
+	a.sessions = sessions
+}
+
+const (
+	// OAuthStateCookie binds the authorization request to the browser that started it.
+	OAuthStateCookie = "fringe_oauth_state"
+	oauthCookiePath  = "/auth/"
+
+	// Login errors returned to the client in the URL fragment of the callback redirect.
+	LoginErrorProvider = "provider"
+	LoginErrorState    = "state"
+	LoginErrorDomain   = "domain"
+	LoginErrorGroup    = "group"
+	LoginErrorDisabled = "disabled"
+)
+
+// Login validates the Google ID token and create JWT if its valid.
 func (a *AuthHandler) Login(httpResponse http.ResponseWriter, httpRequest *http.Request) {
 	defer func() { _ = httpRequest.Body.Close() }()
 
@@ -52,7 +95,8 @@ func (a *AuthHandler) Login(httpResponse http.ResponseWriter, httpRequest *http.
 		return
 	}
 
-	googleUserInfo, err := a.googleOAuth.AuthenticateUserWithToken(httpRequest.Context(), data.TokenType, data.AccessToken)
+	// Only ID tokens prove they were issued to fringe, access tokens of any Google client would be accepted
+	googleUserInfo, err := a.googleOAuth.AuthenticateUserWithIDToken(httpRequest.Context(), data.IDToken)
 	if err != nil {
 		log.Printf("Auth [src:%v] invalid token %v", httpRequest.RemoteAddr, err)
 		http.Error(httpResponse, "Unable to validate code", http.StatusUnauthorized)
@@ -60,19 +104,31 @@ func (a *AuthHandler) Login(httpResponse http.ResponseWriter, httpRequest *http.
 		return
 	}
 
-	if !a.authHelper.InAllowedDomain(googleUserInfo.Email) {
-		log.Printf("Auth [src:%v] email (%s) is not in allowed domain (%s)", httpRequest.RemoteAddr, googleUserInfo.Email, a.authHelper.AllowedDomain)
-		http.Error(httpResponse, "Domain is not allowed", http.StatusUnauthorized)
+	userInfo, err := a.googleOAuth.UserInfoWithGroups(httpRequest.Context(), googleUserInfo)
+	if err != nil {
+		log.Printf("Auth [src:%v] %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable to validate code", http.StatusUnauthorized)
 
 		return
 	}
 
-	role := a.authHelper.RoleForEmail(googleUserInfo.Email)
-	claims := helpers.NewAuthClaims(googleUserInfo.Email, googleUserInfo.Name, googleUserInfo.Picture, role)
-	signedTokenString := a.authHelper.NewJWTSignedString(claims)
-	duration := time.Unix(claims.ExpiresAt, 0).Unix() - time.Now().Unix()
+	claims, providerRole, loginError := authorizeUser(a.authHelper, a.userRepo, httpRequest, services.GoogleProviderID, userInfo)
+	if len(loginError) > 0 {
+		message := "Domain is not allowed"
+
+		switch loginError {
+		case LoginErrorGroup:
+			message = "User is not member of an allowed group"
+		case LoginErrorDisabled:
+			message = "Account is disabled"
+		}
+
+		http.Error(httpResponse, message, http.StatusUnauthorized)
+
+		return
+	}
 
-	response := LoginResponse{TokenType: "Bearer", Token: signedTokenString, Duration: duration, Role: role}
+	response := deliverLoginResponse(httpResponse, httpRequest, a.authHelper, newLoginResponse(httpRequest, a.authHelper, a.sessions, claims, providerRole))
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
@@ -92,6 +148,262 @@ func (a *AuthHandler) Login(httpResponse http.ResponseWriter, httpRequest *http.
 		return
 	}
 
+	refreshUser(httpRequest, a.userRepo, a.authHelper, claims, userInfo)
+}
+
+// authorizeUser returns the claims of the user and the role granted by its provider when its account belongs to
+// the allowed domain, or the login error refusing it. A role asserted by the provider applies to users without
+// a configured role. When groups are mapped, only users with a configured role or a member of a mapped group are
+// allowed and the group role applies if higher.
+// Disabled and expired accounts are refused, users without an account yet may log in to enroll.
+func authorizeUser(authHelper *helpers.AuthHelper, userRepo *repos.UserRepository, httpRequest *http.Request, provider string, userInfo *services.UserInfo) (*helpers.AuthClaims, string, string) {
+	if !authHelper.InProviderDomain(provider, userInfo.Email) {
+		log.Printf("Auth [src:%v] email (%s) is not in allowed domain (%s)", httpRequest.RemoteAddr, userInfo.Email, authHelper.ProviderDomain(provider))
+
+		return nil, "", LoginErrorDomain
+	}
+
+	user, err := userRepo.FindByEmail(httpRequest.Context(), userInfo.Email)
+	if err == nil {
+		err = user.CheckEnabled(time.Now())
+	}
+
+	if err != nil && !errors.Is(err, repos.ErrUserNotFound) {
+		log.Printf("Auth [src:%v] account of %s cannot log in: %v", httpRequest.RemoteAddr, userInfo.Email, err)
+
+		return nil, "", LoginErrorDisabled
+	}
+
+	// The hosted domain proves the Google account is managed by the workspace, not only named after it
+	if provider == services.GoogleProviderID && !authHelper.IsAllowedHostedDomain(userInfo.HostedDomain) {
+		log.Printf("Auth [src:%v] account (%s) is not managed by allowed domain (%s)", httpRequest.RemoteAddr, userInfo.Email, authHelper.AllowedDomain)
+
+		return nil, "", LoginErrorDomain
+	}
+
+	configuredRole := authHelper.RoleForEmail(userInfo.Email) != helpers.UserRoleString
+	providerRole := helpers.UserRoleString
+
+	if !configuredRole && helpers.IsKnownRole(userInfo.Role) {
+		providerRole = userInfo.Role
+	}
+
+	if mapping := authHelper.GroupMapping(); mapping.Enabled() {
+		access := mapping.Resolve(userInfo.Groups)
+		if !access.Allowed && !configuredRole {
+			log.Printf("Auth [src:%v] %s is not member of an allowed group", httpRequest.RemoteAddr, userInfo.Email)
+
+			return nil, "", LoginErrorGroup
+		}
+
+		providerRole = helpers.HigherRole(providerRole, access.Role)
+	}
+
+	return helpers.NewAuthClaims(userInfo.Email, userInfo.Name, userInfo.Picture, sessionRole(authHelper, userInfo.Email, providerRole)), providerRole, ""
+}
+
+// sessionRole returns the configured role of the user or the role granted by its provider, whichever is higher.
+// Sessions compute it again on refresh so configuration changes apply without logging in again.
+func sessionRole(authHelper *helpers.AuthHelper, email string, providerRole string) string {
+	return helpers.HigherRole(authHelper.RoleForEmail(email), providerRole)
+}
+
+// refreshUser updates the profile and the RADIUS group of the user, if it exists, after each login.
+func refreshUser(httpRequest *http.Request, userRepo *repos.UserRepository, authHelper *helpers.AuthHelper, claims *helpers.AuthClaims, userInfo *services.UserInfo) {
 	// try to update the profile if the user exists
-	_, _ = a.userRepo.UpdateProfile(claims.Email, claims.Name, claims.Picture)
+	_, _ = userRepo.UpdateProfile(httpRequest.Context(), claims.Email, claims.Name, claims.Picture)
+
+	mapping := authHelper.GroupMapping()
+	if !mapping.Enabled() || len(mapping.RadiusAttribute) == 0 {
+		return
+	}
+
+	access := mapping.Resolve(userInfo.Groups)
+
+	err := userRepo.SetAttribute(httpRequest.Context(), claims.Email, mapping.RadiusAttribute, access.RadiusGroup)
+	if err != nil && !errors.Is(err, repos.ErrUserNotFound) {
+		log.Printf("Auth [src:%v] could not update radius group of %s: %v", httpRequest.RemoteAddr, claims.Email, err)
+	}
+}
+
+// newLoginResponse starts a session for the claims, without sessions or when it cannot be stored the access token
+// is returned alone and the user logs in again once it expires.
+func newLoginResponse(httpRequest *http.Request, authHelper *helpers.AuthHelper, sessions *repos.SessionRepository, claims *helpers.AuthClaims, providerRole string) LoginResponse {
+	refreshToken := ""
+	sessionExpiresAt := int64(0)
+
+	if sessions != nil {
+		session, token, err := sessions.Create(httpRequest.Context(), claims.Email, claims.Name, claims.Picture, claims.Role, providerRole)
+		if err != nil {
+			log.Printf("Auth [src:%v] could not start session for %s: %v", httpRequest.RemoteAddr, claims.Email, err)
+		} else {
+			claims.SessionID = session.ID
+			refreshToken = token
+			sessionExpiresAt = session.ExpiresAt
+		}
+	}
+
+	response := signedLoginResponse(authHelper, claims, refreshToken)
+	response.sessionExpiresAt = sessionExpiresAt
+
+	return response
+}
+
+func signedLoginResponse(authHelper *helpers.AuthHelper, claims *helpers.AuthClaims, refreshToken string) LoginResponse {
+	signedTokenString := authHelper.NewJWTSignedString(claims)
+	duration := time.Unix(claims.ExpiresAt, 0).Unix() - time.Now().Unix()
+
+	return LoginResponse{TokenType: "Bearer", Token: signedTokenString, Duration: duration, RefreshToken: refreshToken, Role: claims.Role, Permissions: claims.Permissions}
+}
+
+// deliverLoginResponse sets the session cookies when tokens are kept in cookies, the tokens are then removed
+// from the response so scripts never see them.
+func deliverLoginResponse(httpResponse http.ResponseWriter, httpRequest *http.Request, authHelper *helpers.AuthHelper, response LoginResponse) LoginResponse {
+	if !authHelper.CookieSessions() {
+		return response
+	}
+
+	helpers.SetSessionCookies(httpResponse, httpRequest, helpers.SessionCookies{
+		Token:            response.Token,
+		TokenExpiresAt:   time.Now().Unix() + response.Duration,
+		RefreshToken:     response.RefreshToken,
+		SessionExpiresAt: response.sessionExpiresAt,
+	})
+
+	response.TokenType = helpers.CookieTokenType
+	response.Token = ""
+	response.RefreshToken = ""
+
+	return response
+}
+
+// ProviderLogin starts the authorization code flow and redirects the browser to the identity provider.
+func (a *AuthHandler) ProviderLogin(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	provider, found := a.providers[mux.Vars(httpRequest)["provider"]]
+	if !found {
+		http.Error(httpResponse, "Unknown identity provider", http.StatusNotFound)
+
+		return
+	}
+
+	request, err := a.authorizations.Begin(provider.ID(), time.Now())
+	if errors.Is(err, services.ErrTooManyAuthorizationRequests) {
+		log.Printf("Auth [src:%v] could not start authorization: %v", httpRequest.RemoteAddr, err)
+		httpResponse.Header().Set("Retry-After", "60")
+		http.Error(httpResponse, "Too many logins in progress, try again later", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	if err != nil {
+		log.Printf("Auth [src:%v] could not start authorization: %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable to start login", http.StatusInternalServerError)
+
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(httpRequest.Context(), request)
+	if err != nil {
+		log.Printf("Auth [src:%v] could not start authorization with %s: %v", httpRequest.RemoteAddr, provider.ID(), err)
+		redirectWithLoginError(httpResponse, httpRequest, LoginErrorProvider)
+
+		return
+	}
+
+	http.SetCookie(httpResponse, &http.Cookie{
+		Name:     OAuthStateCookie,
+		Value:    request.State,
+		Path:     oauthCookiePath,
+		MaxAge:   int(services.AuthorizationRequestTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(httpResponse, httpRequest, authURL, http.StatusFound)
+}
+
+// ProviderCallback completes the authorization code flow and hands the Fringe session to the client in the URL fragment,
+// the provider tokens never reach the browser.
+func (a *AuthHandler) ProviderCallback(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	query := httpRequest.URL.Query()
+
+	// The state cookie is single use
+	http.SetCookie(httpResponse, &http.Cookie{Name: OAuthStateCookie, Path: oauthCookiePath, MaxAge: -1, HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+
+	provider, found := a.providers[mux.Vars(httpRequest)["provider"]]
+	if !found {
+		http.Error(httpResponse, "Unknown identity provider", http.StatusNotFound)
+
+		return
+	}
+
+	if providerError := query.Get("error"); len(providerError) > 0 {
+		log.Printf("Auth [src:%v] %s refused login: %s", httpRequest.RemoteAddr, provider.ID(), providerError)
+		redirectWithLoginError(httpResponse, httpRequest, LoginErrorProvider)
+
+		return
+	}
+
+	state := query.Get("state")
+
+	cookie, err := httpRequest.Cookie(OAuthStateCookie)
+	if err != nil || len(state) == 0 || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		log.Printf("Auth [src:%v] callback state does not match the browser", httpRequest.RemoteAddr)
+		redirectWithLoginError(httpResponse, httpRequest, LoginErrorState)
+
+		return
+	}
+
+	request, err := a.authorizations.Complete(state, time.Now())
+	if err == nil && request.Provider != provider.ID() {
+		err = fmt.Errorf("%w: started with %s", services.ErrUnknownAuthorizationRequest, request.Provider)
+	}
+
+	if err != nil {
+		log.Printf("Auth [src:%v] %v", httpRequest.RemoteAddr, err)
+		redirectWithLoginError(httpResponse, httpRequest, LoginErrorState)
+
+		return
+	}
+
+	userInfo, err := provider.AuthenticateUserWithCode(httpRequest.Context(), query.Get("code"), request)
+	if err != nil {
+		log.Printf("Auth [src:%v] invalid code %v", httpRequest.RemoteAddr, err)
+		redirectWithLoginError(httpResponse, httpRequest, LoginErrorProvider)
+
+		return
+	}
+
+	claims, providerRole, loginError := authorizeUser(a.authHelper, a.userRepo, httpRequest, provider.ID(), userInfo)
+	if len(loginError) > 0 {
+		redirectWithLoginError(httpResponse, httpRequest, loginError)
+
+		return
+	}
+
+	redirectWithSession(httpResponse, httpRequest, a.authHelper, newLoginResponse(httpRequest, a.authHelper, a.sessions, claims, providerRole))
+	refreshUser(httpRequest, a.userRepo, a.authHelper, claims, userInfo)
+}
+
+// redirectWithSession returns the browser to the client with the session in the URL fragment, never sent to servers.
+// With cookie sessions the fragment has no token.
+func redirectWithSession(httpResponse http.ResponseWriter, httpRequest *http.Request, authHelper *helpers.AuthHelper, response LoginResponse) {
+	response = deliverLoginResponse(httpResponse, httpRequest, authHelper, response)
+	fragment := url.Values{
+		"token":      {response.Token},
+		"token_type": {response.TokenType},
+		"duration":   {strconv.FormatInt(response.Duration, 10)},
+		"role":       {response.Role},
+	}
+
+	if len(response.RefreshToken) > 0 {
+		fragment.Set("refresh_token", response.RefreshToken)
+	}
+
+	http.Redirect(httpResponse, httpRequest, fmt.Sprintf("/#%s", fragment.Encode()), http.StatusFound)
+}
+
+func redirectWithLoginError(httpResponse http.ResponseWriter, httpRequest *http.Request, loginError string) {
+	http.Redirect(httpResponse, httpRequest, fmt.Sprintf("/#%s", url.Values{"login_error": {loginError}}.Encode()), http.StatusFound)
 }
@@ -2,49 +2,160 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/p-l/fringe/internal/httpd/handlers"
 	"github.com/p-l/fringe/internal/httpd/helpers"
 	"github.com/p-l/fringe/internal/httpd/services"
 	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
 	"github.com/stretchr/testify/assert"
 )
 
+// newGoogleLoginHandler returns an AuthHandler logging in with ID tokens signed by the returned Google mock.
+func newGoogleLoginHandler(t *testing.T, authHelper *helpers.AuthHelper) (*handlers.AuthHandler, *mocks.MockIdentityProvider) {
+	t.Helper()
+
+	authHandler, google, _ := newGoogleLoginHandlerWithUsers(t, authHelper)
+
+	return authHandler, google
+}
+
+func newGoogleLoginHandlerWithUsers(t *testing.T, authHelper *helpers.AuthHelper) (*handlers.AuthHandler, *mocks.MockIdentityProvider, *repos.UserRepository) {
+	t.Helper()
+
+	google := mocks.NewMockIdentityProvider(t, services.GoogleIssuer, "id", services.GoogleJWKSURL)
+	googleOAuth := services.NewGoogleOAuthService(google.HTTPClient(nil), "id", "secret", "callback")
+	userRepo := mocks.NewMockUserRepository(t)
+
+	return handlers.NewAuthHandler(userRepo, googleOAuth, authHelper), google, userRepo
+}
+
+func postLogin(t *testing.T, authHandler *handlers.AuthHandler, loginRequest handlers.LoginRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	jsonBytes, err := json.Marshal(loginRequest)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/", bytes.NewBuffer(jsonBytes))
+	req.Header.Set("Content-Type", "application/json")
+
+	res := httptest.NewRecorder()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/auth/", authHandler.Login)
+	router.ServeHTTP(res, req)
+
+	return res
+}
+
 func TestAuthHandler_Login(t *testing.T) {
 	t.Parallel()
 
+	verifiedUser := map[string]interface{}{"email": "email@test.com", "email_verified": true, "name": "Person Name", "hd": "test.com"}
+
 	t.Run("Refuse authentication to outside domains", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Body: ioutil.NopCloser(bytes.NewBufferString(
-					`{ "sub": "a_sub", "email": "email@domain.com", "email_verified": true, "picture": "https://profile/picture/url", "hd": "domain.com" }`)),
-				Header: make(http.Header),
-			}
-		})
+		authHandler, google := newGoogleLoginHandler(t, helpers.NewAuthHelper("test.com", "secret", []string{}))
+
+		idToken := google.SignIDToken(t, map[string]interface{}{"email": "email@domain.com", "email_verified": true, "hd": "domain.com"})
+		res := postLogin(t, authHandler, handlers.LoginRequest{IDToken: idToken})
+
+		// Ensure Access Deny for domain reason
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+		assert.Contains(t, res.Body.String(), "Domain is not allowed")
+	})
+
+	t.Run("Returns token for verified ID tokens", func(t *testing.T) {
+		t.Parallel()
+
+		authHandler, google := newGoogleLoginHandler(t, helpers.NewAuthHelper("test.com", "secret", []string{}))
+
+		res := postLogin(t, authHandler, handlers.LoginRequest{IDToken: google.SignIDToken(t, verifiedUser)})
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		// includes bearer token in response
+		var response handlers.LoginResponse
+		assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+		assert.NotEmpty(t, response.Token)
+		assert.Equal(t, response.TokenType, "Bearer")
+		assert.Empty(t, response.RefreshToken)
+	})
+
+	t.Run("Returns refresh token when sessions are enabled", func(t *testing.T) {
+		t.Parallel()
 
-		googleOAuth := services.NewGoogleOAuthService(client, "id", "secret", "callback")
 		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
-		userRepo := mocks.NewMockUserRepository(t)
+		authHandler, google := newGoogleLoginHandler(t, authHelper)
+		sessions := mocks.NewMockSessionRepository(t)
+		authHandler.SetSessions(sessions)
+
+		res := postLogin(t, authHandler, handlers.LoginRequest{IDToken: google.SignIDToken(t, verifiedUser)})
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.LoginResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, response.RefreshToken)
+
+		claims, err := authHelper.AuthClaimsFromSignedToken(response.Token)
+		assert.NoError(t, err)
 
-		authHandler := handlers.NewAuthHandler(userRepo, googleOAuth, authHelper)
+		session, _, err := sessions.Rotate(context.Background(), response.RefreshToken)
+		assert.NoError(t, err)
+		assert.Equal(t, session.ID, claims.SessionID)
+		assert.Equal(t, "email@test.com", session.Email)
+	})
+
+	t.Run("Sets session cookies instead of returning tokens with cookie sessions", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		authHelper.SetCookieSessions(true)
+		authHandler, google := newGoogleLoginHandler(t, authHelper)
+		authHandler.SetSessions(mocks.NewMockSessionRepository(t))
 
-		loginRequest := handlers.LoginRequest{
-			AccessToken: "test_token",
-			TokenType:   "token",
+		res := postLogin(t, authHandler, handlers.LoginRequest{IDToken: google.SignIDToken(t, verifiedUser)})
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.LoginResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, helpers.CookieTokenType, response.TokenType)
+		assert.Empty(t, response.Token)
+		assert.Empty(t, response.RefreshToken)
+
+		cookies := map[string]*http.Cookie{}
+		for _, cookie := range res.Result().Cookies() {
+			cookies[cookie.Name] = cookie
 		}
-		jsonBytes, err := json.Marshal(loginRequest)
+
+		assert.Contains(t, cookies, helpers.SessionCookie)
+		assert.Contains(t, cookies, helpers.RefreshCookie)
+		assert.Contains(t, cookies, helpers.CSRFCookie)
+		assert.True(t, cookies[helpers.SessionCookie].HttpOnly)
+		assert.True(t, cookies[helpers.SessionCookie].Secure)
+		assert.False(t, cookies[helpers.CSRFCookie].HttpOnly)
+
+		claims, err := authHelper.AuthClaimsFromSignedToken(cookies[helpers.SessionCookie].Value)
 		assert.NoError(t, err)
+		assert.Equal(t, "email@test.com", claims.Email)
+	})
+
+	t.Run("Returns error on invalid post data", func(t *testing.T) {
+		t.Parallel()
+
+		authHandler, _ := newGoogleLoginHandler(t, helpers.NewAuthHelper("test.com", "secret", []string{}))
 
-		req := httptest.NewRequest(http.MethodPost, "/auth/", bytes.NewBuffer(jsonBytes))
+		req := httptest.NewRequest(http.MethodPost, "/auth/", nil)
 		req.Header.Set("Content-Type", "application/json")
 
 		res := httptest.NewRecorder()
@@ -53,117 +164,327 @@ func TestAuthHandler_Login(t *testing.T) {
 		router.HandleFunc("/auth/", authHandler.Login)
 		router.ServeHTTP(res, req)
 
-		// Ensure Access Deny for domain reason
 		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Returns error on invalid or missing ID tokens", func(t *testing.T) {
+		t.Parallel()
+
+		authHandler, google := newGoogleLoginHandler(t, helpers.NewAuthHelper("test.com", "secret", []string{}))
+		unverified := google.SignIDToken(t, map[string]interface{}{"email": "email@test.com", "email_verified": false, "hd": "test.com"})
+
+		for name, loginRequest := range map[string]handlers.LoginRequest{
+			"invalid":    {IDToken: "invalid_test_token"},
+			"unverified": {IDToken: unverified},
+			"missing":    {},
+		} {
+			res := postLogin(t, authHandler, loginRequest)
+			assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode, name)
+		}
+	})
+
+	t.Run("Refuses disabled and expired accounts", func(t *testing.T) {
+		t.Parallel()
+
+		authHandler, google, userRepo := newGoogleLoginHandlerWithUsers(t, helpers.NewAuthHelper("test.com", "secret", []string{}))
 
-		body := res.Body.String()
-		assert.Contains(t, body, "Domain is not allowed")
+		for _, email := range []string{"disabled@test.com", "expired@test.com"} {
+			_, err := userRepo.Create(context.Background(), email, "", "", "a-password")
+			assert.NoError(t, err)
+		}
+
+		assert.NoError(t, userRepo.Disable(context.Background(), "disabled@test.com"))
+		assert.NoError(t, userRepo.SetExpiry(context.Background(), "expired@test.com", time.Now().Add(-time.Minute).Unix()))
+
+		for _, email := range []string{"disabled@test.com", "expired@test.com"} {
+			idToken := google.SignIDToken(t, map[string]interface{}{"email": email, "email_verified": true, "hd": "test.com"})
+			res := postLogin(t, authHandler, handlers.LoginRequest{IDToken: idToken})
+
+			assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode, email)
+			assert.Contains(t, res.Body.String(), "Account is disabled", email)
+		}
 	})
 
-	t.Run("Returns token on successful auth", func(t *testing.T) {
+	t.Run("Refuses accounts outside the hosted domain", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Body: ioutil.NopCloser(bytes.NewBufferString(
-					`{ "sub": "a_sub", "email": "email@test.com", "email_verified": true, "picture": "https://profile/picture/url", "name": "Person Name", "hd": "domain.com" }`)),
-				Header: make(http.Header),
-			}
+		authHandler, google := newGoogleLoginHandler(t, helpers.NewAuthHelper("test.com", "secret", []string{}))
+
+		// A consumer account named after the domain has no hd claim
+		idToken := google.SignIDToken(t, map[string]interface{}{"email": "email@test.com", "email_verified": true, "name": "Person Name"})
+		res := postLogin(t, authHandler, handlers.LoginRequest{IDToken: idToken})
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+		assert.Contains(t, res.Body.String(), "Domain is not allowed")
+	})
+}
+
+func newProviderRouter(authHandler *handlers.AuthHandler) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc(services.LoginPath("{provider}"), authHandler.ProviderLogin)
+	router.HandleFunc(services.CallbackPath("{provider}"), authHandler.ProviderCallback)
+
+	return router
+}
+
+// startProviderLogin runs ProviderLogin and returns the state cookie and the query of the redirect to the provider.
+func startProviderLogin(t *testing.T, authHandler *handlers.AuthHandler, provider string) (*http.Cookie, url.Values) {
+	t.Helper()
+
+	res := httptest.NewRecorder()
+	newProviderRouter(authHandler).ServeHTTP(res, httptest.NewRequest(http.MethodGet, services.LoginPath(provider), nil))
+	assert.Equal(t, http.StatusFound, res.Result().StatusCode)
+
+	redirect, err := url.Parse(res.Result().Header.Get("Location"))
+	assert.NoError(t, err)
+
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == handlers.OAuthStateCookie {
+			assert.True(t, cookie.HttpOnly)
+			assert.True(t, cookie.Secure)
+
+			return cookie, redirect.Query()
+		}
+	}
+
+	t.Fatal("no state cookie set")
+
+	return nil, nil
+}
+
+func callProviderCallback(authHandler *handlers.AuthHandler, provider string, cookie *http.Cookie, query url.Values) url.Values {
+	req := httptest.NewRequest(http.MethodGet, services.CallbackPath(provider)+"?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	res := httptest.NewRecorder()
+	newProviderRouter(authHandler).ServeHTTP(res, req)
+
+	redirect, _ := url.Parse(res.Result().Header.Get("Location"))
+	fragment, _ := url.ParseQuery(redirect.Fragment)
+
+	return fragment
+}
+
+func TestAuthHandler_ProviderCallback(t *testing.T) {
+	t.Parallel()
+
+	newGoogleAuthHandler := func(t *testing.T, email string, nonce func() string) *handlers.AuthHandler {
+		t.Helper()
+
+		google := mocks.NewMockIdentityProvider(t, services.GoogleIssuer, "id", services.GoogleJWKSURL)
+		client := google.HTTPClient(func(req *http.Request) *http.Response {
+			claims := map[string]interface{}{"email": email, "email_verified": true, "hd": "test.com", "nonce": nonce()}
+
+			return google.TokenEndpoint(t, services.GoogleTokenURL, claims)(req)
 		})
 
-		googleOAuth := services.NewGoogleOAuthService(client, "id", "secret", "callback")
+		googleOAuth := services.NewGoogleOAuthService(client, "id", "secret", "https://test.com/auth/google/callback")
 		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
-		userRepo := mocks.NewMockUserRepository(t)
-		authHandler := handlers.NewAuthHandler(userRepo, googleOAuth, authHelper)
 
-		loginRequest := handlers.LoginRequest{
-			AccessToken: "test_token",
-			TokenType:   "token_type",
+		return handlers.NewAuthHandler(mocks.NewMockUserRepository(t), googleOAuth, authHelper, googleOAuth)
+	}
+
+	newOIDCAuthHandler := func(t *testing.T, claims map[string]interface{}, providerDomain string, nonce func() string) *handlers.AuthHandler {
+		t.Helper()
+
+		idp := mocks.NewMockIdentityProvider(t, "https://sso.test.com/realms/main", "fringe", "https://sso.test.com/realms/main/certs")
+		client := idp.HTTPClient(func(req *http.Request) *http.Response {
+			claims["nonce"] = nonce()
+
+			return idp.TokenEndpoint(t, idp.TokenURL(), claims)(req)
+		})
+
+		keycloak := services.NewOIDCProvider(client, services.OIDCProviderSettings{
+			ID:          "keycloak",
+			DisplayName: "Keycloak",
+			Issuer:      idp.Issuer,
+			ClientID:    "fringe",
+			RedirectURL: "https://test.com/auth/keycloak/callback",
+			Scopes:      []string{"openid", "email"},
+			Claims:      services.ClaimMapping{Email: "email", Name: "name", Picture: "picture", Groups: "groups"},
+		})
+		googleOAuth := services.NewGoogleOAuthService(client, "id", "secret", "https://test.com/auth/google/callback")
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+
+		if len(providerDomain) > 0 {
+			authHelper.SetProviderDomain("keycloak", providerDomain)
 		}
-		jsonBytes, err := json.Marshal(loginRequest)
-		assert.NoError(t, err)
 
-		req := httptest.NewRequest(http.MethodPost, "/auth/", bytes.NewBuffer(jsonBytes))
-		req.Header.Set("Content-Type", "application/json")
+		return handlers.NewAuthHandler(mocks.NewMockUserRepository(t), googleOAuth, authHelper, googleOAuth, keycloak)
+	}
 
-		res := httptest.NewRecorder()
+	t.Run("Returns session token in the fragment after the code exchange", func(t *testing.T) {
+		t.Parallel()
 
-		router := mux.NewRouter()
-		router.HandleFunc("/auth/", authHandler.Login)
-		router.ServeHTTP(res, req)
+		var query url.Values
 
-		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		authHandler := newGoogleAuthHandler(t, "email@test.com", func() string { return query.Get("nonce") })
+		cookie, query := startProviderLogin(t, authHandler, services.GoogleProviderID)
 
-		// includes bearer token in response
-		var response handlers.LoginResponse
-		err = json.Unmarshal(res.Body.Bytes(), &response)
-		assert.NoError(t, err)
+		fragment := callProviderCallback(authHandler, services.GoogleProviderID, cookie, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+		assert.NotEmpty(t, fragment.Get("token"))
+		assert.Equal(t, "Bearer", fragment.Get("token_type"))
+		assert.Equal(t, helpers.UserRoleString, fragment.Get("role"))
+		assert.Empty(t, fragment.Get("login_error"))
 
-		assert.NotEmpty(t, response.Token)
-		assert.Equal(t, response.TokenType, "Bearer")
+		// The request is consumed by the first callback
+		fragment = callProviderCallback(authHandler, services.GoogleProviderID, cookie, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+		assert.Equal(t, handlers.LoginErrorState, fragment.Get("login_error"))
 	})
 
-	t.Run("Returns error on invalid post data", func(t *testing.T) {
+	t.Run("Refuses callbacks without the browser state", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Body:       nil,
-				Header:     make(http.Header),
-			}
-		})
+		var query url.Values
 
-		googleOAuth := services.NewGoogleOAuthService(client, "id", "secret", "callback")
-		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
-		userRepo := mocks.NewMockUserRepository(t)
-		authHandler := handlers.NewAuthHandler(userRepo, googleOAuth, authHelper)
+		authHandler := newGoogleAuthHandler(t, "email@test.com", func() string { return query.Get("nonce") })
+		cookie, query := startProviderLogin(t, authHandler, services.GoogleProviderID)
 
-		req := httptest.NewRequest(http.MethodPost, "/auth/", nil)
-		req.Header.Set("Content-Type", "application/json")
+		fragment := callProviderCallback(authHandler, services.GoogleProviderID, nil, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+		assert.Equal(t, handlers.LoginErrorState, fragment.Get("login_error"))
 
-		res := httptest.NewRecorder()
+		fragment = callProviderCallback(authHandler, services.GoogleProviderID, cookie, url.Values{"state": {"other_state"}, "code": {"the_code"}})
+		assert.Equal(t, handlers.LoginErrorState, fragment.Get("login_error"))
+		assert.Empty(t, fragment.Get("token"))
+	})
 
-		router := mux.NewRouter()
-		router.HandleFunc("/auth/", authHandler.Login)
-		router.ServeHTTP(res, req)
+	t.Run("Refuses accounts outside the domain and provider errors", func(t *testing.T) {
+		t.Parallel()
 
-		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+		var query url.Values
+
+		authHandler := newGoogleAuthHandler(t, "email@domain.com", func() string { return query.Get("nonce") })
+		cookie, query := startProviderLogin(t, authHandler, services.GoogleProviderID)
+
+		fragment := callProviderCallback(authHandler, services.GoogleProviderID, cookie, url.Values{"error": {"access_denied"}})
+		assert.Equal(t, handlers.LoginErrorProvider, fragment.Get("login_error"))
+
+		fragment = callProviderCallback(authHandler, services.GoogleProviderID, cookie, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+		assert.Equal(t, handlers.LoginErrorDomain, fragment.Get("login_error"))
+		assert.Empty(t, fragment.Get("token"))
 	})
 
-	t.Run("Returns error on refused Google user info", func(t *testing.T) {
+	t.Run("Logs in with OpenID Connect providers", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusUnauthorized,
-				Body:       nil,
-				Header:     make(http.Header),
-			}
+		var query url.Values
+
+		claims := map[string]interface{}{"email": "email@test.com", "email_verified": true, "name": "Person Name", "groups": []string{"staff"}}
+		authHandler := newOIDCAuthHandler(t, claims, "", func() string { return query.Get("nonce") })
+		cookie, query := startProviderLogin(t, authHandler, "keycloak")
+		assert.Equal(t, "openid email", query.Get("scope"))
+
+		fragment := callProviderCallback(authHandler, "keycloak", cookie, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+		assert.NotEmpty(t, fragment.Get("token"))
+		assert.Empty(t, fragment.Get("login_error"))
+	})
+
+	t.Run("Limits OpenID Connect users to the domain of the provider", func(t *testing.T) {
+		t.Parallel()
+
+		for email, loginError := range map[string]string{"email@partner.com": "", "email@test.com": handlers.LoginErrorDomain} {
+			var query url.Values
+
+			claims := map[string]interface{}{"email": email, "email_verified": true}
+			authHandler := newOIDCAuthHandler(t, claims, "partner.com", func() string { return query.Get("nonce") })
+			cookie, query := startProviderLogin(t, authHandler, "keycloak")
+
+			fragment := callProviderCallback(authHandler, "keycloak", cookie, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+			assert.Equal(t, loginError, fragment.Get("login_error"), email)
+		}
+	})
+
+	t.Run("Refuses callbacks for another provider and unknown providers", func(t *testing.T) {
+		t.Parallel()
+
+		var query url.Values
+
+		authHandler := newOIDCAuthHandler(t, map[string]interface{}{"email": "email@test.com", "email_verified": true}, "", func() string { return query.Get("nonce") })
+		cookie, query := startProviderLogin(t, authHandler, "keycloak")
+
+		res := httptest.NewRecorder()
+		newProviderRouter(authHandler).ServeHTTP(res, httptest.NewRequest(http.MethodGet, services.LoginPath("unknown"), nil))
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+
+		fragment := callProviderCallback(authHandler, services.GoogleProviderID, cookie, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+		assert.Equal(t, handlers.LoginErrorState, fragment.Get("login_error"))
+		assert.Empty(t, fragment.Get("token"))
+	})
+}
+
+func TestAuthHandler_GroupMapping(t *testing.T) {
+	t.Parallel()
+
+	newGroupsAuthHandler := func(t *testing.T, email string, nonce func() string) (*handlers.AuthHandler, *mocks.MockWorkspaceDirectory, *repos.UserRepository) {
+		t.Helper()
+
+		google := mocks.NewMockIdentityProvider(t, services.GoogleIssuer, "id", services.GoogleJWKSURL)
+		client := google.HTTPClient(func(req *http.Request) *http.Response {
+			claims := map[string]interface{}{"email": email, "email_verified": true, "hd": "test.com", "nonce": nonce()}
+
+			return google.TokenEndpoint(t, services.GoogleTokenURL, claims)(req)
 		})
 
-		googleOAuth := services.NewGoogleOAuthService(client, "id", "secret", "callback")
-		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		directory := mocks.NewMockWorkspaceDirectory(t)
+		googleOAuth := services.NewGoogleOAuthService(client, "id", "secret", "https://test.com/auth/google/callback")
+		googleOAuth.Directory = directory
+
+		mapping, err := helpers.NewGroupMapping("radius-group", []helpers.GroupRule{
+			{Group: "helpdesk@test.com", Role: helpers.HelpdeskRoleString, RadiusGroup: "support"},
+			{Group: "staff@test.com", RadiusGroup: "staff"},
+		})
+		assert.NoError(t, err)
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{"admin@test.com"})
+		authHelper.SetGroupMapping(mapping)
+
 		userRepo := mocks.NewMockUserRepository(t)
-		authHandler := handlers.NewAuthHandler(userRepo, googleOAuth, authHelper)
 
-		loginRequest := handlers.LoginRequest{
-			AccessToken: "invalid_test_token",
-			TokenType:   "token_type",
-		}
-		jsonBytes, err := json.Marshal(loginRequest)
+		return handlers.NewAuthHandler(userRepo, googleOAuth, authHelper, googleOAuth), directory, userRepo
+	}
+
+	t.Run("Grants the role and radius group of the directory groups", func(t *testing.T) {
+		t.Parallel()
+
+		var query url.Values
+
+		authHandler, directory, userRepo := newGroupsAuthHandler(t, "email@test.com", func() string { return query.Get("nonce") })
+		directory.SetUserGroups("email@test.com", "staff@test.com", "helpdesk@test.com")
+
+		_, err := userRepo.Create(context.Background(), "email@test.com", "", "", "a-password")
 		assert.NoError(t, err)
 
-		req := httptest.NewRequest(http.MethodPost, "/auth/", bytes.NewBuffer(jsonBytes))
-		req.Header.Set("Content-Type", "application/json")
+		cookie, query := startProviderLogin(t, authHandler, services.GoogleProviderID)
 
-		res := httptest.NewRecorder()
+		fragment := callProviderCallback(authHandler, services.GoogleProviderID, cookie, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+		assert.NotEmpty(t, fragment.Get("token"))
+		assert.Equal(t, helpers.HelpdeskRoleString, fragment.Get("role"))
 
-		router := mux.NewRouter()
-		router.HandleFunc("/auth/", authHandler.Login)
-		router.ServeHTTP(res, req)
+		user, err := userRepo.FindByEmail(context.Background(), "email@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "support", user.Attributes["radius-group"])
+	})
 
-		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	t.Run("Refuses users outside allowed groups unless they have a configured role", func(t *testing.T) {
+		t.Parallel()
+
+		var query url.Values
+
+		authHandler, directory, _ := newGroupsAuthHandler(t, "email@test.com", func() string { return query.Get("nonce") })
+		directory.SetUserGroups("email@test.com", "sales@test.com")
+
+		cookie, query := startProviderLogin(t, authHandler, services.GoogleProviderID)
+
+		fragment := callProviderCallback(authHandler, services.GoogleProviderID, cookie, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+		assert.Equal(t, handlers.LoginErrorGroup, fragment.Get("login_error"))
+		assert.Empty(t, fragment.Get("token"))
+
+		authHandler, _, _ = newGroupsAuthHandler(t, "admin@test.com", func() string { return query.Get("nonce") })
+		cookie, query = startProviderLogin(t, authHandler, services.GoogleProviderID)
+
+		fragment = callProviderCallback(authHandler, services.GoogleProviderID, cookie, url.Values{"state": {query.Get("state")}, "code": {"the_code"}})
+		assert.Equal(t, helpers.AdminRoleString, fragment.Get("role"))
 	})
 }
@@ -4,27 +4,45 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/p-l/fringe/internal/httpd/services"
 	"github.com/p-l/fringe/internal/system"
 )
 
 type ConfigHandler struct {
 	GoogleConfig system.GoogleConfig
+	providers    []services.LoginProvider
+}
+
+// providerResponse is an identity provider listed on the login page.
+type providerResponse struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	LoginURL string `json:"login_url"`
 }
 
 type configResponse struct {
-	GoogleClientID string `json:"google_client_id"`
+	GoogleClientID string             `json:"google_client_id"`
+	Providers      []providerResponse `json:"providers"`
 }
 
-func NewConfigHandler(googleConfig system.GoogleConfig) *ConfigHandler {
+func NewConfigHandler(googleConfig system.GoogleConfig, providers ...services.LoginProvider) *ConfigHandler {
 	return &ConfigHandler{
 		GoogleConfig: googleConfig,
+		providers:    providers,
 	}
 }
 
 func (h *ConfigHandler) Root(httpResponse http.ResponseWriter, httpRequest *http.Request) {
 	defer func() { _ = httpRequest.Body.Close() }()
 
-	config := configResponse{GoogleClientID: h.GoogleConfig.ClientID}
+	config := configResponse{GoogleClientID: h.GoogleConfig.ClientID, Providers: make([]providerResponse, 0, len(h.providers))}
+	for _, provider := range h.providers {
+		config.Providers = append(config.Providers, providerResponse{
+			ID:       provider.ID(),
+			Name:     provider.DisplayName(),
+			LoginURL: services.LoginPath(provider.ID()),
+		})
+	}
 
 	jsonResponse, err := json.Marshal(config)
 	if err != nil {
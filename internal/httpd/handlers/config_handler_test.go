@@ -7,6 +7,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/services"
 	"github.com/p-l/fringe/internal/system"
 	"github.com/stretchr/testify/assert"
 )
@@ -36,4 +37,18 @@ func TestConfigHandler_Root(t *testing.T) {
 		assert.Equal(t, res.Result().Header.Get("Content-Type"), "application/json")
 		assert.Equal(t, res.Result().StatusCode, http.StatusOK)
 	})
+	t.Run("Lists identity providers for the login page", func(t *testing.T) {
+		t.Parallel()
+
+		googleOAuth := services.NewGoogleOAuthService(http.DefaultClient, "client-id", "secret", "callback")
+		keycloak := services.NewOIDCProvider(http.DefaultClient, services.OIDCProviderSettings{ID: "keycloak", DisplayName: "Keycloak"})
+		configHandler := handlers.NewConfigHandler(system.GoogleConfig{ClientID: "client-id"}, googleOAuth, keycloak)
+
+		res := httptest.NewRecorder()
+		configHandler.Root(res, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		body := res.Body.String()
+		assert.Contains(t, body, `{"id":"google","name":"Google","login_url":"/auth/google/login"}`)
+		assert.Contains(t, body, `{"id":"keycloak","name":"Keycloak","login_url":"/auth/keycloak/login"}`)
+	})
 }
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mrz1836/go-sanitize"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// CredentialHandler lets users manage the per-device credentials of their own account, and users with the
+// permission manage those of others, for example to revoke the credential of a lost device.
+type CredentialHandler struct {
+	userRepo  *repos.UserRepository
+	auditRepo *repos.AuditRepository
+}
+
+type CredentialCreateRequest struct {
+	Name string `json:"name"`
+}
+
+type CredentialResponse struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	RevokedAt  int64  `json:"revoked_at"`
+	Password   string `json:"password,omitempty"`
+}
+
+func NewCredentialHandler(userRepo *repos.UserRepository, auditRepo *repos.AuditRepository) *CredentialHandler {
+	return &CredentialHandler{
+		userRepo:  userRepo,
+		auditRepo: auditRepo,
+	}
+}
+
+func newCredentialResponse(credential *repos.Credential, pwd string) CredentialResponse {
+	return CredentialResponse{
+		ID:         credential.ID,
+		Name:       credential.Name,
+		CreatedAt:  credential.CreatedAt,
+		LastUsedAt: credential.LastUsedAt,
+		RevokedAt:  credential.RevokedAt,
+		Password:   pwd,
+	}
+}
+
+// credentialOwner returns the email of the user whose credentials are managed, "me" being the current user.
+// Other users require the permission, credentials are never managed with an API token.
+func credentialOwner(httpResponse http.ResponseWriter, httpRequest *http.Request, permission helpers.Permission) (string, bool) {
+	claims, ok := selfServiceClaims(httpResponse, httpRequest)
+	if !ok {
+		return "", false
+	}
+
+	email := sanitize.Email(mux.Vars(httpRequest)["email"], false)
+	if strings.EqualFold(email, "me") {
+		email = claims.Email
+	}
+
+	if !claimsAllowsForUserPage(claims, email, permission) {
+		log.Printf("Credential [%v]: %s cannot manage %s credentials", httpRequest.RemoteAddr, claims.Email, email)
+		http.Error(httpResponse, "Not allowed", http.StatusForbidden)
+
+		return "", false
+	}
+
+	return email, true
+}
+
+// List returns the credentials of the user, revoked ones included.
+func (h *CredentialHandler) List(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	email, ok := credentialOwner(httpResponse, httpRequest, helpers.PermissionUsersRead)
+	if !ok {
+		return
+	}
+
+	credentials, err := h.userRepo.Credentials(httpRequest.Context(), email)
+	if err != nil {
+		log.Printf("Credential/List [%v]: could not list %s credentials: %v", httpRequest.RemoteAddr, email, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := make([]CredentialResponse, 0, len(credentials))
+	for index := range credentials {
+		response = append(response, newCredentialResponse(&credentials[index], ""))
+	}
+
+	jsonResponse, jsonErr := json.Marshal(response)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// Create adds a named credential to the user, its generated password is only returned in this response.
+func (h *CredentialHandler) Create(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	email, ok := credentialOwner(httpResponse, httpRequest, helpers.PermissionUsersCredentials)
+	if !ok {
+		return
+	}
+
+	var request CredentialCreateRequest
+
+	if err := json.NewDecoder(httpRequest.Body).Decode(&request); err != nil {
+		log.Printf("Credential/Create [src:%v] invalid post data %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable decode request", http.StatusBadRequest)
+
+		return
+	}
+
+	credential, pwd, err := h.userRepo.CreateCredential(httpRequest.Context(), email, sanitize.SingleLine(request.Name))
+	if err != nil {
+		log.Printf("Credential/Create [%v]: could not add credential to %s: %v", httpRequest.RemoteAddr, email, err)
+
+		switch {
+		case errors.Is(err, repos.ErrInvalidCredential), errors.Is(err, repos.ErrTooManyCredentials):
+			http.Error(httpResponse, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, repos.ErrUserNotFound):
+			http.Error(httpResponse, err.Error(), http.StatusNotFound)
+		default:
+			recordAudit(h.auditRepo, httpRequest, repos.AuditActionCredentialCreate, email, actionResultFailed)
+			renderRepositoryError(httpResponse, err, "failed to create credential", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	log.Printf("Credential/Create [%v]: %s added credential %s", httpRequest.RemoteAddr, email, credential.ID)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionCredentialCreate, email+"/"+credential.ID, actionResultSuccess)
+
+	jsonResponse, jsonErr := json.Marshal(newCredentialResponse(credential, pwd))
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// Revoke stops a credential of the user from authenticating.
+func (h *CredentialHandler) Revoke(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	email, ok := credentialOwner(httpResponse, httpRequest, helpers.PermissionUsersCredentials)
+	if !ok {
+		return
+	}
+
+	id := sanitize.AlphaNumeric(mux.Vars(httpRequest)["id"], false)
+	target := email + "/" + id
+
+	err := h.userRepo.RevokeCredential(httpRequest.Context(), email, id)
+	if err != nil {
+		log.Printf("Credential/Revoke [%v]: could not revoke %s: %v", httpRequest.RemoteAddr, target, err)
+
+		if errors.Is(err, repos.ErrCredentialNotFound) {
+			http.Error(httpResponse, "credential not found", http.StatusNotFound)
+
+			return
+		}
+
+		recordAudit(h.auditRepo, httpRequest, repos.AuditActionCredentialRevoke, target, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to revoke credential", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Credential/Revoke [%v]: revoked %s", httpRequest.RemoteAddr, target)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionCredentialRevoke, target, actionResultSuccess)
+
+	renderActionResponse(httpResponse, httpRequest, &UserActionResponse{Result: actionResultSuccess})
+}
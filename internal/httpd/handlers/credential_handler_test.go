@@ -0,0 +1,173 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func createCredentialHandler(t *testing.T) (*handlers.CredentialHandler, *repos.UserRepository, *repos.AuditRepository) {
+	t.Helper()
+
+	_, userRepo, auditRepo := createUserHandlerWithAudit(t)
+
+	return handlers.NewCredentialHandler(userRepo, auditRepo), userRepo, auditRepo
+}
+
+func TestCredentialHandler_Create(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns the password once and the credential authenticates", func(t *testing.T) {
+		t.Parallel()
+
+		credentialHandler, userRepo, auditRepo := createCredentialHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/me/credentials/", strings.NewReader(`{"name":"Laptop"}`))
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/credentials/", credentialHandler.Create, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var created handlers.CredentialResponse
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+		assert.Equal(t, "Laptop", created.Name)
+		assert.NotEmpty(t, created.Password)
+
+		credential, authenticated, err := userRepo.AuthenticateCredential(context.Background(), regularUserEmail, created.Password)
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+		assert.Equal(t, created.ID, credential.ID)
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionCredentialCreate})
+		assert.Len(t, entries, 1)
+
+		// Listing never returns passwords
+		req = httptest.NewRequest(http.MethodGet, "/users/me/credentials/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/users/{email}/credentials/", credentialHandler.List, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var listed []handlers.CredentialResponse
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&listed))
+		assert.Len(t, listed, 1)
+		assert.Empty(t, listed[0].Password)
+	})
+
+	t.Run("Refuses empty names", func(t *testing.T) {
+		t.Parallel()
+
+		credentialHandler, _, _ := createCredentialHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/me/credentials/", strings.NewReader(`{"name":""}`))
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/credentials/", credentialHandler.Create, req)
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+	})
+
+	t.Run("Refuses requests sent with an API token", func(t *testing.T) {
+		t.Parallel()
+
+		credentialHandler, userRepo, _ := createCredentialHandler(t)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/me/credentials/", strings.NewReader(`{"name":"Laptop"}`))
+		res := makeRequestToHandlerWithAPIToken(t, userRepo, regularUserEmail, nil, "/users/{email}/credentials/", credentialHandler.Create, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+
+		credentials, _ := userRepo.Credentials(context.Background(), regularUserEmail)
+		assert.Empty(t, credentials)
+	})
+}
+
+func TestCredentialHandler_Revoke(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Revokes own credential", func(t *testing.T) {
+		t.Parallel()
+
+		credentialHandler, userRepo, _ := createCredentialHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+		credential, password, _ := userRepo.CreateCredential(context.Background(), regularUserEmail, "Phone")
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/me/credentials/"+credential.ID+"/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/credentials/{id}/", credentialHandler.Revoke, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		authenticated, _ := userRepo.Authenticate(context.Background(), regularUserEmail, password)
+		assert.False(t, authenticated)
+	})
+
+	t.Run("Cannot revoke credentials of other users", func(t *testing.T) {
+		t.Parallel()
+
+		credentialHandler, userRepo, _ := createCredentialHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		credential, password, _ := userRepo.CreateCredential(context.Background(), regularUserEmail, "Phone")
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/me/credentials/"+credential.ID+"/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/credentials/{id}/", credentialHandler.Revoke, req)
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+
+		authenticated, _ := userRepo.Authenticate(context.Background(), regularUserEmail, password)
+		assert.True(t, authenticated)
+	})
+
+	t.Run("Revokes credentials of other users with the permission", func(t *testing.T) {
+		t.Parallel()
+
+		credentialHandler, userRepo, auditRepo := createCredentialHandler(t)
+		credential, password, _ := userRepo.CreateCredential(context.Background(), regularUserEmail, "Phone")
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+regularUserEmail+"/credentials/"+credential.ID+"/", nil)
+		res := makeRequestToHandlerWithClaims(helpers.NewAuthClaims("helpdesk@test.com", "", "", helpers.HelpdeskRoleString), "/users/{email}/credentials/{id}/", credentialHandler.Revoke, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		authenticated, _ := userRepo.Authenticate(context.Background(), regularUserEmail, password)
+		assert.False(t, authenticated)
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionCredentialRevoke, Target: regularUserEmail + "/" + credential.ID})
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Refuses to manage credentials of other users without the permission", func(t *testing.T) {
+		t.Parallel()
+
+		credentialHandler, userRepo, _ := createCredentialHandler(t)
+		credential, password, _ := userRepo.CreateCredential(context.Background(), adminEmail, "Phone")
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+adminEmail+"/credentials/"+credential.ID+"/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/credentials/{id}/", credentialHandler.Revoke, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodPost, "/users/"+adminEmail+"/credentials/", strings.NewReader(`{"name":"Laptop"}`))
+		res = makeRequestToHandlerWithClaims(claims, "/users/{email}/credentials/", credentialHandler.Create, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodGet, "/users/"+adminEmail+"/credentials/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/users/{email}/credentials/", credentialHandler.List, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+
+		authenticated, _ := userRepo.Authenticate(context.Background(), adminEmail, password)
+		assert.True(t, authenticated)
+	})
+
+	t.Run("Refuses requests sent with an API token", func(t *testing.T) {
+		t.Parallel()
+
+		credentialHandler, userRepo, _ := createCredentialHandler(t)
+		credential, password, _ := userRepo.CreateCredential(context.Background(), regularUserEmail, "Phone")
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/me/credentials/"+credential.ID+"/", nil)
+		res := makeRequestToHandlerWithAPIToken(t, userRepo, regularUserEmail, nil, "/users/{email}/credentials/{id}/", credentialHandler.Revoke, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+
+		authenticated, _ := userRepo.Authenticate(context.Background(), regularUserEmail, password)
+		assert.True(t, authenticated)
+	})
+}
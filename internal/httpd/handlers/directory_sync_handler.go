@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/jobs"
+)
+
+type DirectorySyncHandler struct {
+	directorySync *jobs.DirectorySync
+}
+
+func NewDirectorySyncHandler(directorySync *jobs.DirectorySync) *DirectorySyncHandler {
+	return &DirectorySyncHandler{
+		directorySync: directorySync,
+	}
+}
+
+// Report returns what the directory sync would change if it ran now, nothing is changed.
+func (h *DirectorySyncHandler) Report(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersRead) {
+		http.Error(httpResponse, "not authorized to view directory sync report", http.StatusUnauthorized)
+
+		return
+	}
+
+	report, err := h.directorySync.Report(httpRequest.Context(), time.Now())
+	if err != nil {
+		log.Printf("DirectorySync/Report [%v]: could not plan sync: %v", httpRequest.RemoteAddr, err)
+		renderRepositoryError(httpResponse, err, "failed to read directory", http.StatusBadGateway)
+
+		return
+	}
+
+	jsonResponse, jsonErr := json.Marshal(report)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// LastRun returns the changes made by the last run of the directory sync.
+func (h *DirectorySyncHandler) LastRun(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersRead) {
+		http.Error(httpResponse, "not authorized to view directory sync report", http.StatusUnauthorized)
+
+		return
+	}
+
+	report := h.directorySync.LastRun()
+	if report == nil {
+		http.Error(httpResponse, "directory sync has not run yet", http.StatusNotFound)
+
+		return
+	}
+
+	jsonResponse, jsonErr := json.Marshal(report)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// Run syncs the directory now and returns the changes made.
+func (h *DirectorySyncHandler) Run(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionDirectorySync) {
+		http.Error(httpResponse, "not authorized to run directory sync", http.StatusUnauthorized)
+
+		return
+	}
+
+	report, err := h.directorySync.Run(httpRequest.Context(), time.Now())
+	if err != nil {
+		log.Printf("DirectorySync/Run [%v]: sync failed: %v", httpRequest.RemoteAddr, err)
+		renderRepositoryError(httpResponse, err, "failed to sync directory", http.StatusBadGateway)
+
+		return
+	}
+
+	if claims, ok := helpers.AuthClaimsFromContext(httpRequest.Context()); ok {
+		log.Printf("DirectorySync/Run [%v]: %s synced %d user(s), %d change(s)", httpRequest.RemoteAddr, claims.Email, report.Checked, len(report.Changes))
+	}
+
+	jsonResponse, jsonErr := json.Marshal(report)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
@@ -0,0 +1,115 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+// createDirectorySyncHandler returns a handler whose directory only lists the admin, the regular user is missing.
+func createDirectorySyncHandler(t *testing.T) (*handlers.DirectorySyncHandler, *repos.UserRepository) {
+	t.Helper()
+
+	_, userRepo, auditRepo := createUserHandlerWithAudit(t)
+
+	directory := mocks.NewMockWorkspaceDirectory(t)
+	directory.SetUser(services.DirectoryUser{Email: adminEmail})
+
+	return handlers.NewDirectorySyncHandler(jobs.NewDirectorySync(userRepo, auditRepo, directory, "test.com", nil)), userRepo
+}
+
+func TestDirectorySyncHandler_Report(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return unauthorized for regular users", func(t *testing.T) {
+		t.Parallel()
+
+		directorySyncHandler, _ := createDirectorySyncHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/directory/report/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/directory/report/", directorySyncHandler.Report, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Return the dry run report", func(t *testing.T) {
+		t.Parallel()
+
+		directorySyncHandler, userRepo := createDirectorySyncHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AuditorRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/directory/report/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/directory/report/", directorySyncHandler.Report, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var report jobs.DirectorySyncReport
+		err := json.NewDecoder(res.Body).Decode(&report)
+		assert.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.Contains(t, report.Changes, jobs.DirectorySyncChange{Email: regularUserEmail, Action: jobs.DirectorySyncActionDisable, Reason: jobs.DirectorySyncReasonMissing})
+
+		user, err := userRepo.FindByEmail(context.Background(), regularUserEmail)
+		assert.NoError(t, err)
+		assert.Zero(t, user.DisabledAt)
+	})
+}
+
+func TestDirectorySyncHandler_Run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return unauthorized without the sync permission", func(t *testing.T) {
+		t.Parallel()
+
+		directorySyncHandler, _ := createDirectorySyncHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/directory/sync/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/directory/sync/", directorySyncHandler.Run, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Disable missing users and keep the report as last run", func(t *testing.T) {
+		t.Parallel()
+
+		directorySyncHandler, userRepo := createDirectorySyncHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/directory/last-run/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/directory/last-run/", directorySyncHandler.LastRun, req)
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodPost, "/directory/sync/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/directory/sync/", directorySyncHandler.Run, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var report jobs.DirectorySyncReport
+		err := json.NewDecoder(res.Body).Decode(&report)
+		assert.NoError(t, err)
+		assert.False(t, report.DryRun)
+
+		user, err := userRepo.FindByEmail(context.Background(), regularUserEmail)
+		assert.NoError(t, err)
+		assert.NotZero(t, user.DisabledAt)
+
+		req = httptest.NewRequest(http.MethodGet, "/directory/last-run/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/directory/last-run/", directorySyncHandler.LastRun, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var lastRun jobs.DirectorySyncReport
+		err = json.NewDecoder(res.Body).Decode(&lastRun)
+		assert.NoError(t, err)
+		assert.Equal(t, report, lastRun)
+	})
+}
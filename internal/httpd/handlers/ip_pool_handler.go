@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mrz1836/go-sanitize"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+type IPPoolHandler struct {
+	poolRepo  *repos.IPPoolRepository
+	auditRepo *repos.AuditRepository
+}
+
+type IPPoolResponse struct {
+	Name         string `json:"name"`
+	CIDR         string `json:"cidr"`
+	Netmask      string `json:"netmask"`
+	Size         int    `json:"size"`
+	LeaseSeconds int64  `json:"lease_seconds"`
+}
+
+type IPPoolListResponse struct {
+	Pools        []IPPoolResponse      `json:"pools"`
+	Leases       []repos.IPLease       `json:"leases"`
+	Reservations []repos.IPReservation `json:"reservations"`
+}
+
+type IPReservationRequest struct {
+	Address string `json:"address"`
+}
+
+func NewIPPoolHandler(poolRepo *repos.IPPoolRepository, auditRepo *repos.AuditRepository) *IPPoolHandler {
+	return &IPPoolHandler{
+		poolRepo:  poolRepo,
+		auditRepo: auditRepo,
+	}
+}
+
+// List returns the pools with their current leases and the static reservations.
+func (h *IPPoolHandler) List(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionNASManage) {
+		http.Error(httpResponse, "not authorized to view ip pools", http.StatusUnauthorized)
+
+		return
+	}
+
+	leases, err := h.poolRepo.Leases(httpRequest.Context(), time.Now())
+	if err != nil {
+		log.Printf("IPPool/List [%v]: could not list leases: %v", httpRequest.RemoteAddr, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
+	}
+
+	reservations, err := h.poolRepo.Reservations(httpRequest.Context())
+	if err != nil {
+		log.Printf("IPPool/List [%v]: could not list reservations: %v", httpRequest.RemoteAddr, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := IPPoolListResponse{Pools: []IPPoolResponse{}, Leases: leases, Reservations: reservations}
+
+	for _, pool := range h.poolRepo.Pools() {
+		response.Pools = append(response.Pools, IPPoolResponse{
+			Name:         pool.Name,
+			CIDR:         pool.Network.String(),
+			Netmask:      pool.Netmask().String(),
+			Size:         pool.Size(),
+			LeaseSeconds: int64(pool.LeaseDuration.Seconds()),
+		})
+	}
+
+	jsonResponse, jsonErr := json.Marshal(response)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// Reserve sets the address always leased to the user, it applies from the next authentication.
+func (h *IPPoolHandler) Reserve(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	vars := mux.Vars(httpRequest)
+	email := sanitize.Email(vars["email"], false)
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionNASManage) {
+		http.Error(httpResponse, "not authorized to reserve addresses", http.StatusUnauthorized)
+
+		return
+	}
+
+	var request IPReservationRequest
+
+	if err := json.NewDecoder(httpRequest.Body).Decode(&request); err != nil {
+		log.Printf("IPPool/Reserve [src:%v] invalid post data %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable decode request", http.StatusBadRequest)
+
+		return
+	}
+
+	if !helpers.IsEmailValid(email) {
+		log.Printf("IPPool/Reserve [%v]: Invalid email: %s", httpRequest.RemoteAddr, email)
+		http.Error(httpResponse, "invalid email", http.StatusBadRequest)
+
+		return
+	}
+
+	reservation, err := h.poolRepo.Reserve(httpRequest.Context(), email, request.Address, time.Now())
+	if err != nil {
+		log.Printf("IPPool/Reserve [%v]: failed to reserve %s for %s: %v", httpRequest.RemoteAddr, request.Address, email, err)
+
+		switch {
+		case errors.Is(err, repos.ErrAddressOutsidePool):
+			http.Error(httpResponse, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, repos.ErrAddressInUse):
+			recordAudit(h.auditRepo, httpRequest, repos.AuditActionIPReserve, email, actionResultFailed)
+			http.Error(httpResponse, err.Error(), http.StatusConflict)
+		default:
+			recordAudit(h.auditRepo, httpRequest, repos.AuditActionIPReserve, email, actionResultFailed)
+			renderRepositoryError(httpResponse, err, "failed to reserve address", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	log.Printf("IPPool/Reserve [%v]: %s reserved for %s", httpRequest.RemoteAddr, reservation.Address, email)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionIPReserve, email, actionResultSuccess)
+
+	jsonResponse, jsonErr := json.Marshal(reservation)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// Unreserve removes the user reservation, the address stays leased to the user until its lease ends.
+func (h *IPPoolHandler) Unreserve(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	vars := mux.Vars(httpRequest)
+	email := sanitize.Email(vars["email"], false)
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionNASManage) {
+		http.Error(httpResponse, "not authorized to reserve addresses", http.StatusUnauthorized)
+
+		return
+	}
+
+	err := h.poolRepo.Unreserve(httpRequest.Context(), email)
+	if err != nil {
+		log.Printf("IPPool/Unreserve [%v]: failed to remove %s reservation: %v", httpRequest.RemoteAddr, email, err)
+
+		if errors.Is(err, repos.ErrLeaseNotFound) {
+			recordAudit(h.auditRepo, httpRequest, repos.AuditActionIPUnreserve, email, actionResultNotFound)
+			http.Error(httpResponse, "user has no reservation", http.StatusNotFound)
+
+			return
+		}
+
+		recordAudit(h.auditRepo, httpRequest, repos.AuditActionIPUnreserve, email, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to remove reservation", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("IPPool/Unreserve [%v]: removed %s reservation", httpRequest.RemoteAddr, email)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionIPUnreserve, email, actionResultSuccess)
+
+	renderActionResponse(httpResponse, httpRequest, &UserActionResponse{Result: actionResultSuccess})
+}
@@ -0,0 +1,136 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func createIPPoolHandler(t *testing.T) (*handlers.IPPoolHandler, *repos.IPPoolRepository, *repos.AuditRepository) {
+	t.Helper()
+
+	poolRepo := mocks.NewMockIPPoolRepository(t)
+	auditRepo := mocks.NewMockAuditRepository(t)
+
+	return handlers.NewIPPoolHandler(poolRepo, auditRepo), poolRepo, auditRepo
+}
+
+func TestIPPoolHandler_List(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return unauthorized for helpdesk", func(t *testing.T) {
+		t.Parallel()
+
+		poolHandler, _, _ := createIPPoolHandler(t)
+		claims := helpers.NewAuthClaims("helpdesk@test.com", "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/ip-pools/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/ip-pools/", poolHandler.List, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Returns pools and leases", func(t *testing.T) {
+		t.Parallel()
+
+		poolHandler, poolRepo, _ := createIPPoolHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		_, err := poolRepo.Allocate(context.Background(), "office", regularUserEmail, "session-1", time.Now())
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/ip-pools/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/ip-pools/", poolHandler.List, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.IPPoolListResponse
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+		assert.Equal(t, []handlers.IPPoolResponse{{Name: "office", CIDR: "10.0.0.0/29", Netmask: "255.255.255.248", Size: 6, LeaseSeconds: 3600}}, response.Pools)
+		assert.Len(t, response.Leases, 1)
+		assert.Equal(t, regularUserEmail, response.Leases[0].Email)
+		assert.Empty(t, response.Reservations)
+	})
+}
+
+func TestIPPoolHandler_Reserve(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Reserves and audits the address", func(t *testing.T) {
+		t.Parallel()
+
+		poolHandler, poolRepo, auditRepo := createIPPoolHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPut, "/users/"+regularUserEmail+"/reservation/", strings.NewReader(`{"address":"10.0.0.4"}`))
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/reservation/", poolHandler.Reserve, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		reservations, _ := poolRepo.Reservations(context.Background())
+		assert.Equal(t, []repos.IPReservation{{Email: regularUserEmail, Pool: "office", Address: "10.0.0.4"}}, reservations)
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionIPReserve})
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Refuses addresses outside pools", func(t *testing.T) {
+		t.Parallel()
+
+		poolHandler, _, _ := createIPPoolHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPut, "/users/"+regularUserEmail+"/reservation/", strings.NewReader(`{"address":"192.168.0.1"}`))
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/reservation/", poolHandler.Reserve, req)
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+	})
+
+	t.Run("Refuses addresses leased to another user", func(t *testing.T) {
+		t.Parallel()
+
+		poolHandler, poolRepo, _ := createIPPoolHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		lease, _ := poolRepo.Allocate(context.Background(), "office", "other@test.com", "session-1", time.Now())
+
+		req := httptest.NewRequest(http.MethodPut, "/users/"+regularUserEmail+"/reservation/", strings.NewReader(`{"address":"`+lease.Address+`"}`))
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/reservation/", poolHandler.Reserve, req)
+		assert.Equal(t, http.StatusConflict, res.Result().StatusCode)
+	})
+}
+
+func TestIPPoolHandler_Unreserve(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns not found without reservation", func(t *testing.T) {
+		t.Parallel()
+
+		poolHandler, _, _ := createIPPoolHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+regularUserEmail+"/reservation/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/reservation/", poolHandler.Unreserve, req)
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+	})
+
+	t.Run("Removes the reservation", func(t *testing.T) {
+		t.Parallel()
+
+		poolHandler, poolRepo, _ := createIPPoolHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		_, _ = poolRepo.Reserve(context.Background(), regularUserEmail, "10.0.0.4", time.Now())
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+regularUserEmail+"/reservation/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/reservation/", poolHandler.Unreserve, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		reservations, _ := poolRepo.Reservations(context.Background())
+		assert.Empty(t, reservations)
+	})
+}
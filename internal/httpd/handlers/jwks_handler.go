@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+)
+
+// JWKSPath is where the public keys verifying access tokens are published.
+const JWKSPath = "/.well-known/jwks.json"
+
+// JWKSHandler publishes the public keys of the key set so other services can verify access tokens.
+type JWKSHandler struct {
+	keys *helpers.KeySet
+}
+
+func NewJWKSHandler(keys *helpers.KeySet) *JWKSHandler {
+	return &JWKSHandler{keys: keys}
+}
+
+func (h *JWKSHandler) Keys(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	jsonResponse, err := json.Marshal(h.keys.JWKS())
+	if err != nil {
+		log.Printf("JWKS [src:%v] failed to encode keys: %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	httpResponse.Header().Set("Content-Type", "application/json")
+	httpResponse.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(helpers.JWKSMaxAge.Seconds())))
+
+	if _, err := httpResponse.Write(jsonResponse); err != nil {
+		log.Printf("JWKS [src:%v] failed to send keys: %v", httpRequest.RemoteAddr, err)
+	}
+}
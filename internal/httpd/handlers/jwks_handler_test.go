@@ -0,0 +1,38 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKSHandler_Keys(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Publishes the public keys verifying access tokens", func(t *testing.T) {
+		t.Parallel()
+
+		key, err := helpers.NewSigningKey(helpers.SigningAlgorithmES256)
+		assert.NoError(t, err)
+
+		keys := helpers.NewKeySet()
+		keys.Replace([]helpers.SigningKey{key})
+
+		req := httptest.NewRequest(http.MethodGet, handlers.JWKSPath, nil)
+		res := httptest.NewRecorder()
+		handlers.NewJWKSHandler(keys).Keys(res, req)
+
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assert.Contains(t, res.Result().Header.Get("Cache-Control"), "max-age=")
+
+		var document helpers.JSONWebKeySet
+		err = json.NewDecoder(res.Body).Decode(&document)
+		assert.NoError(t, err)
+		assert.Equal(t, []helpers.JSONWebKey{key.PublicJSONWebKey()}, document.Keys)
+	})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/jobs"
+)
+
+type ReaperHandler struct {
+	reaper *jobs.Reaper
+}
+
+func NewReaperHandler(reaper *jobs.Reaper) *ReaperHandler {
+	return &ReaperHandler{
+		reaper: reaper,
+	}
+}
+
+// Report returns what the reaper would do if it ran now, nothing is changed.
+func (h *ReaperHandler) Report(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersRead) {
+		http.Error(httpResponse, "not authorized to view reaper report", http.StatusUnauthorized)
+
+		return
+	}
+
+	report, err := h.reaper.Report(httpRequest.Context(), time.Now())
+	if err != nil {
+		log.Printf("Reaper/Report [%v]: could not plan reaping: %v", httpRequest.RemoteAddr, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
+	}
+
+	jsonResponse, jsonErr := json.Marshal(report)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
@@ -0,0 +1,73 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+func createReaperHandler(t *testing.T) *handlers.ReaperHandler {
+	t.Helper()
+
+	_, userRepo, auditRepo := createUserHandlerWithAudit(t)
+
+	// Every user was seen at creation, a zero inactivity period makes them all candidates
+	reaper, err := jobs.NewReaper(userRepo, auditRepo, jobs.ReaperPolicy{
+		InactiveAfter: time.Hour,
+		WarnBefore:    time.Hour - time.Second,
+		Action:        jobs.ReapActionDisable,
+		Exclude:       []string{adminEmail},
+	}, nil)
+	if err != nil {
+		t.Fatalf("could not create reaper: %v", err)
+	}
+
+	return handlers.NewReaperHandler(reaper)
+}
+
+func TestReaperHandler_Report(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return unauthorized for regular users", func(t *testing.T) {
+		t.Parallel()
+
+		reaperHandler := createReaperHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/reaper/report/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/reaper/report/", reaperHandler.Report, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Return the dry run report", func(t *testing.T) {
+		t.Parallel()
+
+		reaperHandler := createReaperHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		time.Sleep(2 * time.Second)
+
+		req := httptest.NewRequest(http.MethodGet, "/reaper/report/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/reaper/report/", reaperHandler.Report, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var report jobs.ReapReport
+		err := json.NewDecoder(res.Body).Decode(&report)
+		assert.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.NotEmpty(t, report.Candidates)
+
+		for _, candidate := range report.Candidates {
+			assert.NotEqual(t, adminEmail, candidate.Email)
+			assert.Equal(t, jobs.ReapActionWarn, candidate.Action)
+		}
+	})
+}
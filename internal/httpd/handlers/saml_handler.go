@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// SAMLHandler logs users in with a SAML 2.0 identity provider and issues the same session as AuthHandler.
+type SAMLHandler struct {
+	authHelper      *helpers.AuthHelper
+	userRepo        *repos.UserRepository
+	serviceProvider *services.SAMLServiceProvider
+	requests        *services.AuthorizationRequests
+	sessions        *repos.SessionRepository
+}
+
+const (
+	// SAMLRequestCookie binds the AuthnRequest to the browser that started it.
+	// The identity provider posts the response cross-site so the cookie cannot be SameSite Lax.
+	SAMLRequestCookie = "fringe_saml_request"
+	samlCookiePath    = "/auth/saml/"
+
+	// SAMLMetadataPath serves the service provider metadata, SAMLAssertionConsumerPath receives the responses.
+	SAMLMetadataPath          = "/auth/saml/metadata"
+	SAMLAssertionConsumerPath = "/auth/saml/acs"
+)
+
+func NewSAMLHandler(userRepo *repos.UserRepository, serviceProvider *services.SAMLServiceProvider, authHelper *helpers.AuthHelper) *SAMLHandler {
+	return &SAMLHandler{
+		authHelper:      authHelper,
+		userRepo:        userRepo,
+		serviceProvider: serviceProvider,
+		requests:        services.NewAuthorizationRequests(),
+	}
+}
+
+// SetSessions keeps a session for each login so access tokens can be refreshed and revoked.
+func (h *SAMLHandler) SetSessions(sessions *repos.SessionRepository) {
+	h.sessions = sessions
+}
+
+// Metadata returns the service provider metadata to register with the identity provider.
+func (h *SAMLHandler) Metadata(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	metadata, err := h.serviceProvider.Metadata()
+	if err != nil {
+		log.Printf("SAML/Metadata [src:%v]: %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable to create metadata", http.StatusInternalServerError)
+
+		return
+	}
+
+	httpResponse.Header().Set("Content-Type", "application/samlmetadata+xml")
+
+	if _, err := httpResponse.Write(metadata); err != nil {
+		log.Printf("SAML/Metadata [src:%v]: failed to send metadata: %v", httpRequest.RemoteAddr, err)
+	}
+}
+
+// Login redirects the browser to the identity provider with a new AuthnRequest.
+func (h *SAMLHandler) Login(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	now := time.Now()
+
+	request, err := h.requests.Begin(services.SAMLProviderID, now)
+	if err != nil {
+		log.Printf("SAML/Login [src:%v]: could not start authentication: %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable to start login", http.StatusInternalServerError)
+
+		return
+	}
+
+	requestURL, err := h.serviceProvider.AuthnRequestURL(request, now)
+	if err != nil {
+		log.Printf("SAML/Login [src:%v]: %v", httpRequest.RemoteAddr, err)
+		redirectWithLoginError(httpResponse, httpRequest, LoginErrorProvider)
+
+		return
+	}
+
+	http.SetCookie(httpResponse, &http.Cookie{
+		Name:     SAMLRequestCookie,
+		Value:    request.State,
+		Path:     samlCookiePath,
+		MaxAge:   int(services.AuthorizationRequestTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	http.Redirect(httpResponse, httpRequest, requestURL, http.StatusFound)
+}
+
+// AssertionConsumer accepts the response posted by the identity provider (HTTP-POST binding)
+// and hands the Fringe session to the client in the URL fragment.
+func (h *SAMLHandler) AssertionConsumer(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	now := time.Now()
+
+	// The request cookie is single use
+	http.SetCookie(httpResponse, &http.Cookie{Name: SAMLRequestCookie, Path: samlCookiePath, MaxAge: -1, HttpOnly: true, Secure: true, SameSite: http.SameSiteNoneMode})
+
+	cookie, err := httpRequest.Cookie(SAMLRequestCookie)
+	if err != nil {
+		log.Printf("SAML/ACS [src:%v]: no pending request for this browser", httpRequest.RemoteAddr)
+		redirectWithLoginError(httpResponse, httpRequest, LoginErrorState)
+
+		return
+	}
+
+	request, err := h.requests.Complete(cookie.Value, now)
+	if err != nil {
+		log.Printf("SAML/ACS [src:%v]: %v", httpRequest.RemoteAddr, err)
+		redirectWithLoginError(httpResponse, httpRequest, LoginErrorState)
+
+		return
+	}
+
+	userInfo, err := h.serviceProvider.AuthenticateUserWithResponse(httpRequest.PostFormValue("SAMLResponse"), request, now)
+	if err != nil {
+		log.Printf("SAML/ACS [src:%v]: %v", httpRequest.RemoteAddr, err)
+		redirectWithLoginError(httpResponse, httpRequest, LoginErrorProvider)
+
+		return
+	}
+
+	claims, providerRole, loginError := authorizeUser(h.authHelper, h.userRepo, httpRequest, services.SAMLProviderID, userInfo)
+	if len(loginError) > 0 {
+		redirectWithLoginError(httpResponse, httpRequest, loginError)
+
+		return
+	}
+
+	redirectWithSession(httpResponse, httpRequest, h.authHelper, newLoginResponse(httpRequest, h.authHelper, h.sessions, claims, providerRole))
+	refreshUser(httpRequest, h.userRepo, h.authHelper, claims, userInfo)
+}
@@ -0,0 +1,154 @@
+package handlers_test
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSAMLACSURL      = "https://test.com/auth/saml/acs"
+	testSAMLEntityID    = "https://test.com/auth/saml/metadata"
+	testSAMLIdPEntityID = "https://idp.test.com/saml"
+)
+
+func newTestSAMLHandler(t *testing.T) (*handlers.SAMLHandler, *mocks.MockSAMLIdentityProvider) {
+	t.Helper()
+
+	idp := mocks.NewMockSAMLIdentityProvider(t, testSAMLIdPEntityID)
+	sp := services.NewSAMLServiceProvider(services.SAMLSettings{
+		DisplayName:     "SAML",
+		EntityID:        testSAMLEntityID,
+		ACSURL:          testSAMLACSURL,
+		IdPEntityID:     testSAMLIdPEntityID,
+		IdPSSOURL:       "https://idp.test.com/saml/sso",
+		IdPCertificates: []*x509.Certificate{idp.Certificate},
+		Attributes:      services.SAMLAttributeMapping{Email: "email", Name: "name", Role: "role"},
+	})
+	authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+
+	return handlers.NewSAMLHandler(mocks.NewMockUserRepository(t), sp, authHelper), idp
+}
+
+// startSAMLLogin runs Login and returns the request cookie.
+func startSAMLLogin(t *testing.T, samlHandler *handlers.SAMLHandler) *http.Cookie {
+	t.Helper()
+
+	res := httptest.NewRecorder()
+	samlHandler.Login(res, httptest.NewRequest(http.MethodGet, services.LoginPath(services.SAMLProviderID), nil))
+	assert.Equal(t, http.StatusFound, res.Result().StatusCode)
+	assert.True(t, strings.HasPrefix(res.Result().Header.Get("Location"), "https://idp.test.com/saml/sso?SAMLRequest="))
+
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == handlers.SAMLRequestCookie {
+			assert.True(t, cookie.HttpOnly)
+			assert.True(t, cookie.Secure)
+
+			return cookie
+		}
+	}
+
+	t.Fatal("no request cookie set")
+
+	return nil
+}
+
+func postSAMLResponse(samlHandler *handlers.SAMLHandler, cookie *http.Cookie, response string) url.Values {
+	form := url.Values{"SAMLResponse": {response}}
+	req := httptest.NewRequest(http.MethodPost, handlers.SAMLAssertionConsumerPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if cookie != nil {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	res := httptest.NewRecorder()
+	samlHandler.AssertionConsumer(res, req)
+
+	redirect, _ := url.Parse(res.Result().Header.Get("Location"))
+	fragment, _ := url.ParseQuery(redirect.Fragment)
+
+	return fragment
+}
+
+func TestSAMLHandler_Metadata(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns the service provider metadata", func(t *testing.T) {
+		t.Parallel()
+
+		samlHandler, _ := newTestSAMLHandler(t)
+
+		res := httptest.NewRecorder()
+		samlHandler.Metadata(res, httptest.NewRequest(http.MethodGet, handlers.SAMLMetadataPath, nil))
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assert.Equal(t, "application/samlmetadata+xml", res.Result().Header.Get("Content-Type"))
+		assert.Contains(t, res.Body.String(), testSAMLACSURL)
+	})
+}
+
+func TestSAMLHandler_AssertionConsumer(t *testing.T) {
+	t.Parallel()
+
+	assertion := func(cookie *http.Cookie, email string) mocks.SAMLAssertion {
+		return mocks.SAMLAssertion{
+			InResponseTo: "_" + cookie.Value,
+			Recipient:    testSAMLACSURL,
+			Audience:     testSAMLEntityID,
+			NameID:       email,
+			Attributes:   map[string]string{"email": email, "name": "Person Name", "role": helpers.AdminRoleString},
+		}
+	}
+
+	t.Run("Returns session token in the fragment with the asserted role", func(t *testing.T) {
+		t.Parallel()
+
+		samlHandler, idp := newTestSAMLHandler(t)
+		cookie := startSAMLLogin(t, samlHandler)
+		response := idp.Response(t, assertion(cookie, "email@test.com"), mocks.SAMLSignAssertion)
+
+		fragment := postSAMLResponse(samlHandler, cookie, response)
+		assert.NotEmpty(t, fragment.Get("token"))
+		assert.Equal(t, helpers.AdminRoleString, fragment.Get("role"))
+		assert.Empty(t, fragment.Get("login_error"))
+
+		// The request is consumed by the first response
+		fragment = postSAMLResponse(samlHandler, cookie, response)
+		assert.Equal(t, handlers.LoginErrorState, fragment.Get("login_error"))
+	})
+
+	t.Run("Refuses responses without the browser request", func(t *testing.T) {
+		t.Parallel()
+
+		samlHandler, idp := newTestSAMLHandler(t)
+		cookie := startSAMLLogin(t, samlHandler)
+
+		fragment := postSAMLResponse(samlHandler, nil, idp.Response(t, assertion(cookie, "email@test.com"), mocks.SAMLSignAssertion))
+		assert.Equal(t, handlers.LoginErrorState, fragment.Get("login_error"))
+		assert.Empty(t, fragment.Get("token"))
+	})
+
+	t.Run("Refuses unsigned responses and accounts outside the domain", func(t *testing.T) {
+		t.Parallel()
+
+		samlHandler, idp := newTestSAMLHandler(t)
+
+		cookie := startSAMLLogin(t, samlHandler)
+		fragment := postSAMLResponse(samlHandler, cookie, idp.Response(t, assertion(cookie, "email@test.com"), mocks.SAMLSignNothing))
+		assert.Equal(t, handlers.LoginErrorProvider, fragment.Get("login_error"))
+
+		cookie = startSAMLLogin(t, samlHandler)
+		fragment = postSAMLResponse(samlHandler, cookie, idp.Response(t, assertion(cookie, "email@domain.com"), mocks.SAMLSignAssertion))
+		assert.Equal(t, handlers.LoginErrorDomain, fragment.Get("login_error"))
+		assert.Empty(t, fragment.Get("token"))
+	})
+}
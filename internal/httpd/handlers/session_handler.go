@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mrz1836/go-sanitize"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// SessionHandler refreshes and ends the sessions started at login.
+// Ended sessions are added to the revocation list of authHelper so their access tokens stop working at once.
+type SessionHandler struct {
+	sessions   *repos.SessionRepository
+	userRepo   *repos.UserRepository
+	auditRepo  *repos.AuditRepository
+	authHelper *helpers.AuthHelper
+}
+
+type SessionRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type SessionRevokeResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+var errInvalidCSRFToken = errors.New("invalid csrf token")
+
+func NewSessionHandler(sessions *repos.SessionRepository, userRepo *repos.UserRepository, auditRepo *repos.AuditRepository, authHelper *helpers.AuthHelper) *SessionHandler {
+	return &SessionHandler{
+		sessions:   sessions,
+		userRepo:   userRepo,
+		auditRepo:  auditRepo,
+		authHelper: authHelper,
+	}
+}
+
+// refreshTokenFromRequest returns the refresh token of the body or, with cookie sessions, of the refresh cookie.
+// The cookie is only used along with a valid CSRF token.
+func (h *SessionHandler) refreshTokenFromRequest(httpRequest *http.Request, request SessionRequest) (string, error) {
+	if len(request.RefreshToken) > 0 || !h.authHelper.CookieSessions() {
+		return request.RefreshToken, nil
+	}
+
+	cookie, err := httpRequest.Cookie(helpers.RefreshCookie)
+	if err != nil {
+		return "", nil
+	}
+
+	if !helpers.HasValidCSRFToken(httpRequest) {
+		return "", errInvalidCSRFToken
+	}
+
+	return cookie.Value, nil
+}
+
+// accessTokenFromRequest returns the bearer token or, with cookie sessions, the session cookie.
+func (h *SessionHandler) accessTokenFromRequest(httpRequest *http.Request) string {
+	authorization := httpRequest.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	if cookie, err := httpRequest.Cookie(helpers.SessionCookie); err == nil && h.authHelper.CookieSessions() {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// refuseRefresh ends the cookie session of the browser, if any, and replies with status.
+func (h *SessionHandler) refuseRefresh(httpResponse http.ResponseWriter, message string, status int) {
+	if h.authHelper.CookieSessions() {
+		helpers.ClearSessionCookies(httpResponse)
+	}
+
+	http.Error(httpResponse, message, status)
+}
+
+func (h *SessionHandler) revoke(id string) {
+	if revocations := h.authHelper.RevocationList(); revocations != nil {
+		revocations.Revoke(id, time.Now())
+	}
+}
+
+// Refresh exchanges the refresh token for a new access token and a new refresh token.
+// Users disabled or no longer in the allowed domain lose their sessions instead.
+func (h *SessionHandler) Refresh(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	var request SessionRequest
+	if err := json.NewDecoder(httpRequest.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Session/Refresh [src:%v]: invalid post data %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Invalid refresh token", http.StatusUnauthorized)
+
+		return
+	}
+
+	presentedToken, err := h.refreshTokenFromRequest(httpRequest, request)
+	if err != nil {
+		log.Printf("Session/Refresh [src:%v]: refresh cookie sent without valid CSRF token", httpRequest.RemoteAddr)
+		http.Error(httpResponse, "Invalid CSRF token", http.StatusForbidden)
+
+		return
+	}
+
+	if len(presentedToken) == 0 {
+		log.Printf("Session/Refresh [src:%v]: no refresh token", httpRequest.RemoteAddr)
+		h.refuseRefresh(httpResponse, "Invalid refresh token", http.StatusUnauthorized)
+
+		return
+	}
+
+	session, refreshToken, err := h.sessions.Rotate(httpRequest.Context(), presentedToken)
+	if errors.Is(err, repos.ErrRefreshTokenReused) {
+		log.Printf("Session/Refresh [src:%v]: refresh token of %s was reused, session %s revoked", httpRequest.RemoteAddr, session.Email, session.ID)
+		h.revoke(session.ID)
+		h.refuseRefresh(httpResponse, "Invalid refresh token", http.StatusUnauthorized)
+
+		return
+	}
+
+	if errors.Is(err, repos.ErrSessionNotFound) {
+		log.Printf("Session/Refresh [src:%v]: unknown, expired or revoked session", httpRequest.RemoteAddr)
+		h.refuseRefresh(httpResponse, "Invalid refresh token", http.StatusUnauthorized)
+
+		return
+	}
+
+	if err != nil {
+		log.Printf("Session/Refresh [src:%v]: %v", httpRequest.RemoteAddr, err)
+		renderRepositoryError(httpResponse, err, "failed to refresh session", http.StatusInternalServerError)
+
+		return
+	}
+
+	// The rotated refresh token is never returned when refused, the session cannot be refreshed again
+	user, err := h.userRepo.FindByEmail(httpRequest.Context(), session.Email)
+	if err == nil {
+		err = user.CheckEnabled(time.Now())
+	}
+
+	if err != nil || !h.authHelper.InAllowedDomain(session.Email) {
+		log.Printf("Session/Refresh [src:%v]: %s is no longer allowed (%v), ending its sessions", httpRequest.RemoteAddr, session.Email, err)
+		_, _ = h.revokeUser(httpRequest, session.Email)
+		h.refuseRefresh(httpResponse, "User is not allowed", http.StatusUnauthorized)
+
+		return
+	}
+
+	claims := helpers.NewAuthClaims(session.Email, session.Name, session.Picture, sessionRole(h.authHelper, session.Email, session.ProviderRole))
+	claims.SessionID = session.ID
+
+	response := signedLoginResponse(h.authHelper, claims, refreshToken)
+	response.sessionExpiresAt = session.ExpiresAt
+
+	jsonResponse, jsonErr := json.Marshal(deliverLoginResponse(httpResponse, httpRequest, h.authHelper, response))
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// Logout ends the session of the refresh token and revokes the access token sent along, if any.
+// With cookie sessions the tokens may come from the cookies, which are cleared.
+func (h *SessionHandler) Logout(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	var request SessionRequest
+	if err := json.NewDecoder(httpRequest.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Session/Logout [src:%v]: invalid post data %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "invalid logout request", http.StatusBadRequest)
+
+		return
+	}
+
+	refreshToken, err := h.refreshTokenFromRequest(httpRequest, request)
+	if err != nil {
+		log.Printf("Session/Logout [src:%v]: refresh cookie sent without valid CSRF token", httpRequest.RemoteAddr)
+		http.Error(httpResponse, "Invalid CSRF token", http.StatusForbidden)
+
+		return
+	}
+
+	if accessToken := h.accessTokenFromRequest(httpRequest); len(accessToken) > 0 {
+		if claims, err := h.authHelper.AuthClaimsFromSignedToken(accessToken); err == nil {
+			h.revoke(claims.Id)
+		}
+	}
+
+	if h.authHelper.CookieSessions() {
+		helpers.ClearSessionCookies(httpResponse)
+	}
+
+	if len(refreshToken) > 0 {
+		session, err := h.sessions.Revoke(httpRequest.Context(), refreshToken)
+		if err != nil && !errors.Is(err, repos.ErrSessionNotFound) {
+			log.Printf("Session/Logout [src:%v]: %v", httpRequest.RemoteAddr, err)
+			renderRepositoryError(httpResponse, err, "failed to end session", http.StatusInternalServerError)
+
+			return
+		}
+
+		if err == nil {
+			log.Printf("Session/Logout [src:%v]: %s ended session %s", httpRequest.RemoteAddr, session.Email, session.ID)
+			h.revoke(session.ID)
+		}
+	}
+
+	httpResponse.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeUser ends every session of the user, the user must log in again on every device.
+func (h *SessionHandler) RevokeUser(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	email := sanitize.Email(mux.Vars(httpRequest)["email"], false)
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersSessions) {
+		http.Error(httpResponse, "not authorized to end user sessions", http.StatusUnauthorized)
+
+		return
+	}
+
+	if !helpers.IsEmailValid(email) {
+		log.Printf("Session/Revoke [%v]: Invalid email: %s", httpRequest.RemoteAddr, email)
+		http.Error(httpResponse, "invalid email", http.StatusBadRequest)
+
+		return
+	}
+
+	revoked, err := h.revokeUser(httpRequest, email)
+	if err != nil {
+		recordAudit(h.auditRepo, httpRequest, repos.AuditActionSessionRevoke, email, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to end user sessions", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("Session/Revoke [%v]: ended %d session(s) of %s", httpRequest.RemoteAddr, revoked, email)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionSessionRevoke, email, actionResultSuccess)
+
+	jsonResponse, jsonErr := json.Marshal(SessionRevokeResponse{Revoked: revoked})
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+func (h *SessionHandler) revokeUser(httpRequest *http.Request, email string) (int, error) {
+	return endUserSessions(httpRequest, h.sessions, h.authHelper, email)
+}
+
+// endUserSessions ends every session of the user, their access tokens are refused right away.
+func endUserSessions(httpRequest *http.Request, sessionRepo *repos.SessionRepository, authHelper *helpers.AuthHelper, email string) (int, error) {
+	sessions, err := sessionRepo.RevokeUser(httpRequest.Context(), email)
+	if err != nil {
+		log.Printf("Session/Revoke [%v]: could not end sessions of %s: %v", httpRequest.RemoteAddr, email, err)
+
+		return 0, err
+	}
+
+	if revocations := authHelper.RevocationList(); revocations != nil {
+		for _, session := range sessions {
+			revocations.Revoke(session.ID, time.Now())
+		}
+	}
+
+	return len(sessions), nil
+}
@@ -0,0 +1,409 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+type sessionTestContext struct {
+	handler    *handlers.SessionHandler
+	sessions   *repos.SessionRepository
+	userRepo   *repos.UserRepository
+	auditRepo  *repos.AuditRepository
+	authHelper *helpers.AuthHelper
+}
+
+func createSessionHandler(t *testing.T) sessionTestContext {
+	t.Helper()
+
+	_, userRepo, auditRepo := createUserHandlerWithAudit(t)
+	sessions := mocks.NewMockSessionRepository(t)
+
+	authHelper := helpers.NewAuthHelper("test.com", "secret", []string{adminEmail})
+	authHelper.SetRevocationList(helpers.NewRevocationList())
+
+	return sessionTestContext{
+		handler:    handlers.NewSessionHandler(sessions, userRepo, auditRepo, authHelper),
+		sessions:   sessions,
+		userRepo:   userRepo,
+		auditRepo:  auditRepo,
+		authHelper: authHelper,
+	}
+}
+
+func postSessionRequest(t *testing.T, path string, handler func(http.ResponseWriter, *http.Request), refreshToken string, bearer string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	jsonBytes, err := json.Marshal(handlers.SessionRequest{RefreshToken: refreshToken})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(jsonBytes))
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(bearer) > 0 {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	res := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc(path, handler)
+	router.ServeHTTP(res, req)
+
+	return res
+}
+
+func postCookieSessionRequest(t *testing.T, path string, handler func(http.ResponseWriter, *http.Request), cookies []*http.Cookie, csrfToken string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	if len(csrfToken) > 0 {
+		req.Header.Set(helpers.CSRFHeader, csrfToken)
+	}
+
+	res := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc(path, handler)
+	router.ServeHTTP(res, req)
+
+	return res
+}
+
+func responseCookies(res *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range res.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+
+	return cookies
+}
+
+func TestSessionHandler_Refresh(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Rotates the refresh token and issues a new access token", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		session, refreshToken, err := test.sessions.Create(context.Background(), regularUserEmail, "User", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		res := postSessionRequest(t, "/auth/refresh/", test.handler.Refresh, refreshToken, "")
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.LoginResponse
+		err = json.NewDecoder(res.Body).Decode(&response)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, response.Token)
+		assert.NotEmpty(t, response.RefreshToken)
+		assert.NotEqual(t, refreshToken, response.RefreshToken)
+
+		claims, err := test.authHelper.AuthClaimsFromSignedToken(response.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, regularUserEmail, claims.Email)
+		assert.Equal(t, session.ID, claims.SessionID)
+	})
+
+	t.Run("Ends the session when a refresh token is reused", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		_, refreshToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		res := postSessionRequest(t, "/auth/refresh/", test.handler.Refresh, refreshToken, "")
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.LoginResponse
+		err = json.NewDecoder(res.Body).Decode(&response)
+		assert.NoError(t, err)
+
+		claims, err := test.authHelper.AuthClaimsFromSignedToken(response.Token)
+		assert.NoError(t, err)
+
+		res = postSessionRequest(t, "/auth/refresh/", test.handler.Refresh, refreshToken, "")
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+		assert.True(t, test.authHelper.IsRevoked(claims))
+
+		res = postSessionRequest(t, "/auth/refresh/", test.handler.Refresh, response.RefreshToken, "")
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Refuses unknown refresh tokens", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+
+		for _, token := range []string{"", "unknown.token"} {
+			res := postSessionRequest(t, "/auth/refresh/", test.handler.Refresh, token, "")
+			assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+		}
+	})
+
+	t.Run("Ends the sessions of disabled users", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		_, refreshToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		err = test.userRepo.Disable(context.Background(), regularUserEmail)
+		assert.NoError(t, err)
+
+		res := postSessionRequest(t, "/auth/refresh/", test.handler.Refresh, refreshToken, "")
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+		assert.Contains(t, res.Body.String(), "User is not allowed")
+
+		revoked, err := test.sessions.RevokeUser(context.Background(), regularUserEmail)
+		assert.NoError(t, err)
+		assert.Empty(t, revoked)
+	})
+
+	t.Run("Ends the sessions of deleted and expired users", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		_, deletedToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+		_, expiredToken, err := test.sessions.Create(context.Background(), adminEmail, "", "", helpers.AdminRoleString, helpers.AdminRoleString)
+		assert.NoError(t, err)
+
+		assert.NoError(t, test.userRepo.Delete(context.Background(), regularUserEmail))
+		assert.NoError(t, test.userRepo.SetExpiry(context.Background(), adminEmail, time.Now().Add(-time.Minute).Unix()))
+
+		for _, refreshToken := range []string{deletedToken, expiredToken} {
+			res := postSessionRequest(t, "/auth/refresh/", test.handler.Refresh, refreshToken, "")
+			assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+			assert.Contains(t, res.Body.String(), "User is not allowed")
+		}
+
+		for _, email := range []string{regularUserEmail, adminEmail} {
+			revoked, err := test.sessions.RevokeUser(context.Background(), email)
+			assert.NoError(t, err)
+			assert.Empty(t, revoked, email)
+		}
+	})
+
+	t.Run("Computes the role again from the configuration and the provider role", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		_, demotedToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.AdminRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+		_, auditorToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.AuditorRoleString, helpers.AuditorRoleString)
+		assert.NoError(t, err)
+		_, adminToken, err := test.sessions.Create(context.Background(), adminEmail, "", "", helpers.AdminRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		for refreshToken, role := range map[string]string{
+			demotedToken: helpers.UserRoleString,
+			auditorToken: helpers.AuditorRoleString,
+			adminToken:   helpers.AdminRoleString,
+		} {
+			res := postSessionRequest(t, "/auth/refresh/", test.handler.Refresh, refreshToken, "")
+			assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+			var response handlers.LoginResponse
+			assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+			assert.Equal(t, role, response.Role)
+		}
+	})
+
+	t.Run("Rotates the refresh cookie with a valid CSRF token", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		test.authHelper.SetCookieSessions(true)
+
+		_, refreshToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		cookies := []*http.Cookie{{Name: helpers.RefreshCookie, Value: refreshToken}, {Name: helpers.CSRFCookie, Value: "csrf"}}
+
+		res := postCookieSessionRequest(t, "/auth/refresh/", test.handler.Refresh, cookies, "csrf")
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.LoginResponse
+		err = json.NewDecoder(res.Body).Decode(&response)
+		assert.NoError(t, err)
+		assert.Equal(t, helpers.CookieTokenType, response.TokenType)
+		assert.Empty(t, response.Token)
+
+		set := responseCookies(res)
+		assert.NotEqual(t, refreshToken, set[helpers.RefreshCookie].Value)
+		assert.Equal(t, "csrf", set[helpers.CSRFCookie].Value)
+
+		claims, err := test.authHelper.AuthClaimsFromSignedToken(set[helpers.SessionCookie].Value)
+		assert.NoError(t, err)
+		assert.Equal(t, regularUserEmail, claims.Email)
+	})
+
+	t.Run("Refuses the refresh cookie without a valid CSRF token", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		test.authHelper.SetCookieSessions(true)
+
+		_, refreshToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		cookies := []*http.Cookie{{Name: helpers.RefreshCookie, Value: refreshToken}, {Name: helpers.CSRFCookie, Value: "csrf"}}
+
+		for _, csrfToken := range []string{"", "other"} {
+			res := postCookieSessionRequest(t, "/auth/refresh/", test.handler.Refresh, cookies, csrfToken)
+			assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+		}
+
+		_, _, err = test.sessions.Rotate(context.Background(), refreshToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Ignores the refresh cookie without cookie sessions", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+
+		_, refreshToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		cookies := []*http.Cookie{{Name: helpers.RefreshCookie, Value: refreshToken}, {Name: helpers.CSRFCookie, Value: "csrf"}}
+
+		res := postCookieSessionRequest(t, "/auth/refresh/", test.handler.Refresh, cookies, "csrf")
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+}
+
+func TestSessionHandler_Logout(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Ends the session and revokes the access token", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		session, refreshToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+		claims.SessionID = session.ID
+		otherClaims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		res := postSessionRequest(t, "/auth/logout/", test.handler.Logout, refreshToken, test.authHelper.NewJWTSignedString(claims))
+		assert.Equal(t, http.StatusNoContent, res.Result().StatusCode)
+		assert.True(t, test.authHelper.IsRevoked(claims))
+		assert.False(t, test.authHelper.IsRevoked(otherClaims))
+
+		_, _, err = test.sessions.Rotate(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, repos.ErrSessionNotFound)
+	})
+
+	t.Run("Succeeds without a session", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+
+		res := postSessionRequest(t, "/auth/logout/", test.handler.Logout, "unknown.token", "invalid")
+		assert.Equal(t, http.StatusNoContent, res.Result().StatusCode)
+	})
+
+	t.Run("Ends the cookie session and clears the cookies", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		test.authHelper.SetCookieSessions(true)
+
+		session, refreshToken, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+		claims.SessionID = session.ID
+
+		cookies := []*http.Cookie{
+			{Name: helpers.SessionCookie, Value: test.authHelper.NewJWTSignedString(claims)},
+			{Name: helpers.RefreshCookie, Value: refreshToken},
+			{Name: helpers.CSRFCookie, Value: "csrf"},
+		}
+
+		res := postCookieSessionRequest(t, "/auth/logout/", test.handler.Logout, cookies, "csrf")
+		assert.Equal(t, http.StatusNoContent, res.Result().StatusCode)
+		assert.True(t, test.authHelper.IsRevoked(claims))
+
+		set := responseCookies(res)
+		for _, name := range []string{helpers.SessionCookie, helpers.RefreshCookie, helpers.CSRFCookie} {
+			assert.Contains(t, set, name)
+			assert.Empty(t, set[name].Value)
+			assert.Negative(t, set[name].MaxAge)
+		}
+
+		_, _, err = test.sessions.Rotate(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, repos.ErrSessionNotFound)
+	})
+}
+
+func TestSessionHandler_RevokeUser(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return unauthorized for regular users", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+adminEmail+"/sessions/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/sessions/", test.handler.RevokeUser, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Ends every session of the user", func(t *testing.T) {
+		t.Parallel()
+
+		test := createSessionHandler(t)
+
+		sessionIDs := []string{}
+
+		for i := 0; i < 2; i++ {
+			session, _, err := test.sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+			assert.NoError(t, err)
+
+			sessionIDs = append(sessionIDs, session.ID)
+		}
+
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+regularUserEmail+"/sessions/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/sessions/", test.handler.RevokeUser, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.SessionRevokeResponse
+		err := json.NewDecoder(res.Body).Decode(&response)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, response.Revoked)
+
+		for _, id := range sessionIDs {
+			sessionClaims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+			sessionClaims.SessionID = id
+			assert.True(t, test.authHelper.IsRevoked(sessionClaims))
+		}
+
+		entries, err := test.auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionSessionRevoke})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, adminEmail, entries[0].Actor)
+		assert.Equal(t, regularUserEmail, entries[0].Target)
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+type SnapshotHandler struct {
+	snapshotRepo *repos.SnapshotRepository
+	auditRepo    *repos.AuditRepository
+}
+
+func NewSnapshotHandler(snapshotRepo *repos.SnapshotRepository, auditRepo *repos.AuditRepository) *SnapshotHandler {
+	return &SnapshotHandler{
+		snapshotRepo: snapshotRepo,
+		auditRepo:    auditRepo,
+	}
+}
+
+// Download streams a consistent snapshot of the whole database, it can be restored with `fringe restore`.
+// The snapshot includes password hashes, downloads are recorded in the audit log.
+func (h *SnapshotHandler) Download(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionSnapshot) {
+		http.Error(httpResponse, "not authorized to download database snapshots", http.StatusUnauthorized)
+
+		return
+	}
+
+	tempDir, err := os.MkdirTemp("", "fringe-snapshot-")
+	if err != nil {
+		log.Printf("Snapshot/Download [%v]: could not create temporary directory: %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "failed to create snapshot", http.StatusInternalServerError)
+
+		return
+	}
+	defer func() { _ = os.RemoveAll(tempDir) }() //nolint:wsl
+
+	now := time.Now()
+	filename := jobs.SnapshotFileName(now)
+
+	snapshot, err := h.snapshotRepo.Write(httpRequest.Context(), filepath.Join(tempDir, filename))
+	if err != nil {
+		log.Printf("Snapshot/Download [%v]: could not write snapshot: %v", httpRequest.RemoteAddr, err)
+		recordAudit(h.auditRepo, httpRequest, repos.AuditActionDatabaseSnapshot, filename, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to create snapshot", http.StatusInternalServerError)
+
+		return
+	}
+
+	file, err := os.Open(snapshot.File)
+	if err != nil {
+		log.Printf("Snapshot/Download [%v]: could not read snapshot: %v", httpRequest.RemoteAddr, err)
+		recordAudit(h.auditRepo, httpRequest, repos.AuditActionDatabaseSnapshot, filename, actionResultFailed)
+		http.Error(httpResponse, "failed to create snapshot", http.StatusInternalServerError)
+
+		return
+	}
+	defer func() { _ = file.Close() }() //nolint:wsl
+
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionDatabaseSnapshot, filename, actionResultSuccess)
+
+	httpResponse.Header().Set("Content-Type", "application/octet-stream")
+	httpResponse.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	httpResponse.Header().Set("X-Snapshot-Sha256", snapshot.SHA256)
+	httpResponse.Header().Add("Cache-Control", "no-store, no-cache, must-revalidate")
+
+	http.ServeContent(httpResponse, httpRequest, filename, now, file)
+}
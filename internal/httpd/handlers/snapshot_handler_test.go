@@ -0,0 +1,78 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func createSnapshotHandler(t *testing.T) (*handlers.SnapshotHandler, *repos.AuditRepository) {
+	t.Helper()
+
+	db := mocks.NewMockDB(t)
+
+	userRepo, err := repos.NewUserRepository(db)
+	if err != nil {
+		t.Fatalf("could not create user repository: %v", err)
+	}
+
+	auditRepo, err := repos.NewAuditRepository(db)
+	if err != nil {
+		t.Fatalf("could not create audit repository: %v", err)
+	}
+
+	if _, err := userRepo.Create(context.Background(), regularUserEmail, "", "", "a-password"); err != nil {
+		t.Fatalf("could not add user: %v", err)
+	}
+
+	return handlers.NewSnapshotHandler(repos.NewSnapshotRepository(db), auditRepo), auditRepo
+}
+
+func TestSnapshotHandler_Download(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return unauthorized for helpdesk", func(t *testing.T) {
+		t.Parallel()
+
+		snapshotHandler, _ := createSnapshotHandler(t)
+		claims := helpers.NewAuthClaims("helpdesk@test.com", "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/snapshot/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/snapshot/", snapshotHandler.Download, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Streams a snapshot that can be verified", func(t *testing.T) {
+		t.Parallel()
+
+		snapshotHandler, auditRepo := createSnapshotHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/snapshot/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/snapshot/", snapshotHandler.Download, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assert.Contains(t, res.Header().Get("Content-Disposition"), "fringe-")
+
+		file := filepath.Join(t.TempDir(), "downloaded.db")
+		assert.NoError(t, os.WriteFile(file, res.Body.Bytes(), 0o600))
+
+		snapshot, err := repos.VerifySnapshot(context.Background(), file, mocks.AuditKey)
+		assert.NoError(t, err)
+		assert.Equal(t, res.Header().Get("X-Snapshot-Sha256"), snapshot.SHA256)
+		assert.Equal(t, int64(1), snapshot.Tables["users"])
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionDatabaseSnapshot})
+		assert.Len(t, entries, 1)
+		assert.Equal(t, adminEmail, entries[0].Actor)
+	})
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mrz1836/go-sanitize"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/pquerna/otp"
+)
+
+// TOTPHandler lets users enroll an authenticator app, radius then asks them for a one-time password.
+type TOTPHandler struct {
+	userRepo  *repos.UserRepository
+	auditRepo *repos.AuditRepository
+	issuer    string
+}
+
+type TOTPStatusResponse struct {
+	Enabled   bool  `json:"enabled"`
+	Pending   bool  `json:"pending"`
+	EnabledAt int64 `json:"enabled_at"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+	QRCode string `json:"qr_code"`
+}
+
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+const totpQRCodeSize = 256
+
+func NewTOTPHandler(userRepo *repos.UserRepository, auditRepo *repos.AuditRepository, issuer string) *TOTPHandler {
+	return &TOTPHandler{
+		userRepo:  userRepo,
+		auditRepo: auditRepo,
+		issuer:    issuer,
+	}
+}
+
+// totpQRCode returns the otpauth URL as a PNG data URL scanned by authenticator apps.
+func totpQRCode(url string) (string, error) {
+	key, err := otp.NewKeyFromURL(url)
+	if err != nil {
+		return "", fmt.Errorf("could not parse otpauth url: %w", err)
+	}
+
+	image, err := key.Image(totpQRCodeSize, totpQRCodeSize)
+	if err != nil {
+		return "", fmt.Errorf("could not create qr code: %w", err)
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image); err != nil {
+		return "", fmt.Errorf("could not encode qr code: %w", err)
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(encoded.Bytes()), nil
+}
+
+// Status returns whether the current user must give a one-time password.
+func (h *TOTPHandler) Status(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	claims, ok := helpers.AuthClaimsFromContext(httpRequest.Context())
+	if !ok {
+		http.Error(httpResponse, "not authorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	response := TOTPStatusResponse{}
+
+	userTOTP, err := h.userRepo.TOTP(httpRequest.Context(), claims.Email)
+	if err != nil && !errors.Is(err, repos.ErrTOTPNotEnrolled) {
+		log.Printf("TOTP/Status [%v]: could not read %s one-time password: %v", httpRequest.RemoteAddr, claims.Email, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err == nil {
+		response.Enabled = userTOTP.IsEnabled()
+		response.Pending = !userTOTP.IsEnabled()
+		response.EnabledAt = userTOTP.EnabledAt
+	}
+
+	jsonResponse, jsonErr := json.Marshal(response)
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// Enroll generates a new seed for the current user, it is only enabled once confirmed with a code.
+func (h *TOTPHandler) Enroll(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	claims, ok := selfServiceClaims(httpResponse, httpRequest)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.userRepo.EnrollTOTP(httpRequest.Context(), claims.Email, h.issuer)
+	if err != nil {
+		log.Printf("TOTP/Enroll [%v]: could not enroll %s: %v", httpRequest.RemoteAddr, claims.Email, err)
+
+		switch {
+		case errors.Is(err, repos.ErrTOTPAlreadyEnabled):
+			http.Error(httpResponse, err.Error(), http.StatusConflict)
+		case errors.Is(err, repos.ErrUserNotFound):
+			http.Error(httpResponse, err.Error(), http.StatusNotFound)
+		default:
+			renderRepositoryError(httpResponse, err, "failed to enroll one-time password", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	qrCode, err := totpQRCode(enrollment.URL)
+	if err != nil {
+		log.Printf("TOTP/Enroll [%v]: could not create %s qr code: %v", httpRequest.RemoteAddr, claims.Email, err)
+		http.Error(httpResponse, "failed to enroll one-time password", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("TOTP/Enroll [%v]: %s started enrollment", httpRequest.RemoteAddr, claims.Email)
+
+	jsonResponse, jsonErr := json.Marshal(TOTPEnrollResponse{Secret: enrollment.Secret, URL: enrollment.URL, QRCode: qrCode})
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// Confirm enables the pending enrollment of the current user with a first code from the authenticator app.
+func (h *TOTPHandler) Confirm(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	claims, ok := selfServiceClaims(httpResponse, httpRequest)
+	if !ok {
+		return
+	}
+
+	var request TOTPConfirmRequest
+
+	if err := json.NewDecoder(httpRequest.Body).Decode(&request); err != nil {
+		log.Printf("TOTP/Confirm [src:%v] invalid post data %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable decode request", http.StatusBadRequest)
+
+		return
+	}
+
+	err := h.userRepo.ConfirmTOTP(httpRequest.Context(), claims.Email, sanitize.Numeric(request.Code), time.Now())
+	if err != nil {
+		log.Printf("TOTP/Confirm [%v]: could not confirm %s: %v", httpRequest.RemoteAddr, claims.Email, err)
+
+		switch {
+		case errors.Is(err, repos.ErrInvalidOTP), errors.Is(err, repos.ErrOTPReplayed):
+			http.Error(httpResponse, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, repos.ErrTOTPNotEnrolled):
+			http.Error(httpResponse, err.Error(), http.StatusNotFound)
+		case errors.Is(err, repos.ErrTOTPAlreadyEnabled):
+			http.Error(httpResponse, err.Error(), http.StatusConflict)
+		default:
+			recordAudit(h.auditRepo, httpRequest, repos.AuditActionTOTPEnable, claims.Email, actionResultFailed)
+			renderRepositoryError(httpResponse, err, "failed to confirm one-time password", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	log.Printf("TOTP/Confirm [%v]: %s enabled one-time password", httpRequest.RemoteAddr, claims.Email)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionTOTPEnable, claims.Email, actionResultSuccess)
+
+	renderActionResponse(httpResponse, httpRequest, &UserActionResponse{Result: actionResultSuccess})
+}
+
+// Disable removes the one-time password of the current user.
+func (h *TOTPHandler) Disable(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	claims, ok := selfServiceClaims(httpResponse, httpRequest)
+	if !ok {
+		return
+	}
+
+	h.disable(httpResponse, httpRequest, claims.Email)
+}
+
+// Reset removes the one-time password of a user that lost its authenticator app.
+func (h *TOTPHandler) Reset(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersRenew) {
+		http.Error(httpResponse, "not authorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	h.disable(httpResponse, httpRequest, sanitize.Email(mux.Vars(httpRequest)["email"], false))
+}
+
+func (h *TOTPHandler) disable(httpResponse http.ResponseWriter, httpRequest *http.Request, email string) {
+	err := h.userRepo.DisableTOTP(httpRequest.Context(), email)
+	if err != nil {
+		log.Printf("TOTP/Disable [%v]: could not disable %s: %v", httpRequest.RemoteAddr, email, err)
+
+		if errors.Is(err, repos.ErrTOTPNotEnrolled) {
+			recordAudit(h.auditRepo, httpRequest, repos.AuditActionTOTPDisable, email, actionResultNotFound)
+			http.Error(httpResponse, err.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		recordAudit(h.auditRepo, httpRequest, repos.AuditActionTOTPDisable, email, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to disable one-time password", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("TOTP/Disable [%v]: disabled %s one-time password", httpRequest.RemoteAddr, email)
+	recordAudit(h.auditRepo, httpRequest, repos.AuditActionTOTPDisable, email, actionResultSuccess)
+
+	renderActionResponse(httpResponse, httpRequest, &UserActionResponse{Result: actionResultSuccess})
+}
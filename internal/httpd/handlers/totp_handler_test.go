@@ -0,0 +1,132 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/handlers"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTOTPHandler(t *testing.T) (*handlers.TOTPHandler, *repos.UserRepository, *repos.AuditRepository) {
+	t.Helper()
+
+	_, userRepo, auditRepo := createUserHandlerWithAudit(t)
+
+	return handlers.NewTOTPHandler(userRepo, auditRepo, "Fringe"), userRepo, auditRepo
+}
+
+func TestTOTPHandler_Enroll(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Enrollment is confirmed with a code", func(t *testing.T) {
+		t.Parallel()
+
+		totpHandler, userRepo, auditRepo := createTOTPHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/totp/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/totp/", totpHandler.Enroll, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var enrollment handlers.TOTPEnrollResponse
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&enrollment))
+		assert.NotEmpty(t, enrollment.Secret)
+		assert.Contains(t, enrollment.URL, "otpauth://totp/")
+		assert.True(t, strings.HasPrefix(enrollment.QRCode, "data:image/png;base64,"))
+
+		req = httptest.NewRequest(http.MethodPut, "/totp/", strings.NewReader(`{"code":"000000"}`))
+		res = makeRequestToHandlerWithClaims(claims, "/totp/", totpHandler.Confirm, req)
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+
+		code, _ := totp.GenerateCode(enrollment.Secret, time.Now())
+		req = httptest.NewRequest(http.MethodPut, "/totp/", strings.NewReader(`{"code":"`+code+`"}`))
+		res = makeRequestToHandlerWithClaims(claims, "/totp/", totpHandler.Confirm, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		enabled, _ := userRepo.HasTOTP(context.Background(), regularUserEmail)
+		assert.True(t, enabled)
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionTOTPEnable})
+		assert.Len(t, entries, 1)
+
+		req = httptest.NewRequest(http.MethodGet, "/totp/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/totp/", totpHandler.Status, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var status handlers.TOTPStatusResponse
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+		assert.True(t, status.Enabled)
+		assert.False(t, status.Pending)
+
+		// Enrolling again requires disabling first
+		req = httptest.NewRequest(http.MethodPost, "/totp/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/totp/", totpHandler.Enroll, req)
+		assert.Equal(t, http.StatusConflict, res.Result().StatusCode)
+	})
+}
+
+func TestTOTPHandler_Disable(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Users disable their own one-time password", func(t *testing.T) {
+		t.Parallel()
+
+		totpHandler, userRepo, _ := createTOTPHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+		_, _ = userRepo.EnrollTOTP(context.Background(), regularUserEmail, "Fringe")
+
+		req := httptest.NewRequest(http.MethodDelete, "/totp/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/totp/", totpHandler.Disable, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodDelete, "/totp/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/totp/", totpHandler.Disable, req)
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+	})
+
+	t.Run("Refuses requests sent with an API token", func(t *testing.T) {
+		t.Parallel()
+
+		totpHandler, userRepo, _ := createTOTPHandler(t)
+		_, _ = userRepo.EnrollTOTP(context.Background(), regularUserEmail, "Fringe")
+
+		req := httptest.NewRequest(http.MethodDelete, "/totp/", nil)
+		res := makeRequestToHandlerWithAPIToken(t, userRepo, regularUserEmail, nil, "/totp/", totpHandler.Disable, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+
+		_, err := userRepo.TOTP(context.Background(), regularUserEmail)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Only helpdesk and admins reset other users", func(t *testing.T) {
+		t.Parallel()
+
+		totpHandler, userRepo, auditRepo := createTOTPHandler(t)
+		_, _ = userRepo.EnrollTOTP(context.Background(), regularUserEmail, "Fringe")
+
+		claims := helpers.NewAuthClaims("other@test.com", "", "", helpers.UserRoleString)
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+regularUserEmail+"/totp/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/totp/", totpHandler.Reset, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+
+		claims = helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		req = httptest.NewRequest(http.MethodDelete, "/users/"+regularUserEmail+"/totp/", nil)
+		res = makeRequestToHandlerWithClaims(claims, "/users/{email}/totp/", totpHandler.Reset, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		_, err := userRepo.TOTP(context.Background(), regularUserEmail)
+		assert.ErrorIs(t, err, repos.ErrTOTPNotEnrolled)
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionTOTPDisable})
+		assert.Len(t, entries, 1)
+	})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,26 +14,44 @@ import (
 	"github.com/mrz1836/go-sanitize"
 	"github.com/p-l/fringe/internal/httpd/helpers"
 	"github.com/p-l/fringe/internal/repos"
-	"github.com/sethvargo/go-password/password"
 )
 
 type UserHandler struct {
 	userRepo   *repos.UserRepository
+	auditRepo  *repos.AuditRepository
 	authHelper *helpers.AuthHelper
+	sessions   *repos.SessionRepository
 }
 
 type UserCreateRequest struct {
-	Email string `json:"email"`
-	Name  string `json:"name"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// UserExpiryRequest sets the Unix time at which the account expires, 0 removes the expiry.
+type UserExpiryRequest struct {
+	ExpiresAt int64 `json:"expires_at"`
 }
 
 type UserResponse struct {
-	Email             string `json:"email"`
-	Name              string `json:"name"`
-	Picture           string `json:"picture"`
-	Password          string `json:"password"`
-	PasswordUpdatedAt int64  `json:"password_updated_at"`
-	LastSeenAt        int64  `json:"last_seen_at"`
+	Email              string            `json:"email"`
+	Name               string            `json:"name"`
+	Picture            string            `json:"picture"`
+	Password           string            `json:"password"`
+	PasswordUpdatedAt  int64             `json:"password_updated_at"`
+	LastSeenAt         int64             `json:"last_seen_at"`
+	CreatedAt          int64             `json:"created_at"`
+	DisabledAt         int64             `json:"disabled_at"`
+	InactivityWarnedAt int64             `json:"inactivity_warned_at"`
+	ExpiresAt          int64             `json:"expires_at"`
+	Attributes         map[string]string `json:"attributes"`
+}
+
+type UserListResponse struct {
+	Items      []UserResponse `json:"items"`
+	Total      int64          `json:"total"`
+	NextCursor string         `json:"next_cursor"`
 }
 
 type UserActionResponse struct {
@@ -45,23 +64,57 @@ const (
 	actionResultFailed   = "failed"
 	actionResultNotFound = "not_found"
 	actionResultExists   = "exists"
+	actionResultDenied   = "denied"
 )
 
-const (
-	newUserPasswordLen          = 24
-	newUserPasswordNumOfDigits  = 2
-	newUserPasswordNumOfSymbols = 2
-)
+// importMaxBodySize limits the size of import requests, larger imports are done with the import command.
+const importMaxBodySize = 16 << 20
 
-func NewUserHandler(userRepo *repos.UserRepository, authHelper *helpers.AuthHelper) *UserHandler {
+func NewUserHandler(userRepo *repos.UserRepository, auditRepo *repos.AuditRepository, authHelper *helpers.AuthHelper) *UserHandler {
 	return &UserHandler{
 		userRepo:   userRepo,
+		auditRepo:  auditRepo,
 		authHelper: authHelper,
 	}
 }
 
-func claimsAllowsForUserPage(claims *helpers.AuthClaims, targetEmail string) bool {
-	return claims != nil && (strings.EqualFold(claims.Email, targetEmail) || claims.IsAdmin())
+// SetSessions ends the sessions of deleted users.
+func (u *UserHandler) SetSessions(sessions *repos.SessionRepository) {
+	u.sessions = sessions
+}
+
+// claimsAllowsForUserPage allows users to act on their own account, acting on others requires the permission.
+// API tokens always need the permission, a leaked token must not be enough to take over the account.
+func claimsAllowsForUserPage(claims *helpers.AuthClaims, targetEmail string, permission helpers.Permission) bool {
+	if claims == nil {
+		return false
+	}
+
+	if len(claims.APITokenID) > 0 {
+		return claims.Can(permission)
+	}
+
+	return strings.EqualFold(claims.Email, targetEmail) || claims.Can(permission)
+}
+
+// selfServiceClaims returns the claims of the request when it was sent with an access token. Credentials,
+// one-time passwords and API tokens of an account are only managed by its user, never with an API token.
+func selfServiceClaims(httpResponse http.ResponseWriter, httpRequest *http.Request) (*helpers.AuthClaims, bool) {
+	claims, ok := helpers.AuthClaimsFromContext(httpRequest.Context())
+	if !ok {
+		http.Error(httpResponse, "not authorized", http.StatusUnauthorized)
+
+		return nil, false
+	}
+
+	if len(claims.APITokenID) > 0 {
+		log.Printf("User [%v]: %s tried %s with api token %s", httpRequest.RemoteAddr, claims.Email, httpRequest.URL.Path, claims.APITokenID)
+		http.Error(httpResponse, "API tokens cannot manage the account", http.StatusForbidden)
+
+		return nil, false
+	}
+
+	return claims, true
 }
 
 func renderResponseJSONResponse(httpResponse http.ResponseWriter, httpRequest *http.Request, jsonResponse []byte, jsonErr error) {
@@ -84,14 +137,32 @@ func renderResponseJSONResponse(httpResponse http.ResponseWriter, httpRequest *h
 	}
 }
 
+// renderRepositoryError replies with status, unless the repository timed out.
+// Timeouts are reported as 503 so clients know they can retry.
+func renderRepositoryError(httpResponse http.ResponseWriter, err error, message string, status int) {
+	if errors.Is(err, repos.ErrTimeout) {
+		httpResponse.Header().Set("Retry-After", "1")
+		http.Error(httpResponse, "database is busy, try again later", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	http.Error(httpResponse, message, status)
+}
+
 func renderUserResponse(httpResponse http.ResponseWriter, httpRequest *http.Request, user *repos.User, pwd string) {
 	response := UserResponse{
-		Email:             user.Email,
-		Name:              user.Name,
-		Picture:           user.Picture,
-		LastSeenAt:        user.LastSeenAt,
-		PasswordUpdatedAt: user.PasswordUpdatedAt,
-		Password:          pwd,
+		Email:              user.Email,
+		Name:               user.Name,
+		Picture:            user.Picture,
+		LastSeenAt:         user.LastSeenAt,
+		PasswordUpdatedAt:  user.PasswordUpdatedAt,
+		CreatedAt:          user.CreatedAt,
+		DisabledAt:         user.DisabledAt,
+		InactivityWarnedAt: user.InactivityWarnedAt,
+		ExpiresAt:          user.ExpiresAt,
+		Attributes:         user.Attributes,
+		Password:           pwd,
 	}
 
 	jsonResponse, jsonErr := json.Marshal(response)
@@ -103,24 +174,34 @@ func renderActionResponse(httpResponse http.ResponseWriter, httpRequest *http.Re
 	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
 }
 
-func renderUserListResponse(httpResponse http.ResponseWriter, httpRequest *http.Request, users []repos.User) {
-	returnedUsers := make([]UserResponse, 0, len(users))
-	for _, user := range users {
-		returnedUsers = append(returnedUsers, UserResponse{
-			Email:             user.Email,
-			Name:              user.Name,
-			Picture:           user.Picture,
-			PasswordUpdatedAt: user.PasswordUpdatedAt,
-			LastSeenAt:        user.LastSeenAt,
-			Password:          "",
+func renderUserListResponse(httpResponse http.ResponseWriter, httpRequest *http.Request, page *repos.UserPage) {
+	response := UserListResponse{
+		Items:      make([]UserResponse, 0, len(page.Items)),
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	}
+
+	for _, user := range page.Items {
+		response.Items = append(response.Items, UserResponse{
+			Email:              user.Email,
+			Name:               user.Name,
+			Picture:            user.Picture,
+			PasswordUpdatedAt:  user.PasswordUpdatedAt,
+			LastSeenAt:         user.LastSeenAt,
+			CreatedAt:          user.CreatedAt,
+			DisabledAt:         user.DisabledAt,
+			InactivityWarnedAt: user.InactivityWarnedAt,
+			ExpiresAt:          user.ExpiresAt,
+			Attributes:         user.Attributes,
+			Password:           "",
 		})
 	}
 
-	jsonResponse, jsonErr := json.Marshal(returnedUsers)
+	jsonResponse, jsonErr := json.Marshal(response)
 	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
 }
 
-func isAuthorizedAdminRequest(httpRequest *http.Request) (authorized bool) {
+func isAuthorizedRequest(httpRequest *http.Request, permission helpers.Permission) (authorized bool) {
 	claims, ok := helpers.AuthClaimsFromContext(httpRequest.Context())
 	if !ok {
 		log.Printf("User [%v]: failed to retrieve claims", httpRequest.RemoteAddr)
@@ -128,8 +209,8 @@ func isAuthorizedAdminRequest(httpRequest *http.Request) (authorized bool) {
 		return false
 	}
 
-	if !claims.IsAdmin() {
-		log.Printf("User [%v]: %s is not allowed to perform action", httpRequest.RemoteAddr, claims.Email)
+	if !claims.Can(permission) {
+		log.Printf("User [%v]: %s is missing %s permission to perform action", httpRequest.RemoteAddr, claims.Email, permission)
 
 		return false
 	}
@@ -137,13 +218,13 @@ func isAuthorizedAdminRequest(httpRequest *http.Request) (authorized bool) {
 	return true
 }
 
-func createNewUser(repo *repos.UserRepository, email string, name string, picture string) (user *repos.User, userPassword *string, err error) {
-	pwd, err := password.Generate(newUserPasswordLen, newUserPasswordNumOfDigits, newUserPasswordNumOfSymbols, false, false)
+func createNewUser(ctx context.Context, repo *repos.UserRepository, email string, name string, picture string, expiresAt int64) (user *repos.User, userPassword *string, err error) {
+	pwd, err := repos.GeneratePassword()
 	if err != nil {
-		return nil, nil, fmt.Errorf("password generation failed: %w", err)
+		return nil, nil, err
 	}
 
-	user, err = repo.Create(email, name, picture, pwd)
+	user, err = repo.CreateExpiring(ctx, email, name, picture, pwd, expiresAt)
 	if err != nil {
 		return nil, nil, fmt.Errorf("user creation failed: %w", err)
 	}
@@ -151,24 +232,17 @@ func createNewUser(repo *repos.UserRepository, email string, name string, pictur
 	return user, &pwd, nil
 }
 
+// List returns a page of users in an envelope with the total number of matching users and the next page cursor.
+// Query parameters: search, sort, order (asc or desc), inactive_since (Unix time), never_seen,
+// expiring_before (Unix time), per_page and cursor.
 func (u *UserHandler) List(httpResponse http.ResponseWriter, httpRequest *http.Request) {
 	defer func() { _ = httpRequest.Body.Close() }()
 
 	var err error
 
-	pageNumber := 0
 	pageSize := 10
-	pageQueried := sanitize.AlphaNumeric(httpRequest.URL.Query().Get("page"), false)
-	perPage := sanitize.AlphaNumeric(httpRequest.URL.Query().Get("per_page"), false)
-	searchQuery := sanitize.SingleLine(httpRequest.URL.Query().Get("search"))
-
-	if len(pageQueried) > 0 {
-		pageNumber, err = strconv.Atoi(pageQueried)
-		if err != nil {
-			pageNumber = 0
-			log.Printf("User/List [%v]: Could not parse page '%s', defaulting to %d", httpRequest.RemoteAddr, pageQueried, pageNumber)
-		}
-	}
+	query := httpRequest.URL.Query()
+	perPage := sanitize.AlphaNumeric(query.Get("per_page"), false)
 
 	if len(perPage) > 0 {
 		pageSize, err = strconv.Atoi(perPage)
@@ -178,32 +252,46 @@ func (u *UserHandler) List(httpResponse http.ResponseWriter, httpRequest *http.R
 		}
 	}
 
-	if !isAuthorizedAdminRequest(httpRequest) {
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersRead) {
 		http.Error(httpResponse, "not authorized to list users", http.StatusUnauthorized)
 
 		return
 	}
 
-	var users []repos.User
-	if len(searchQuery) > 0 {
-		users, err = u.userRepo.FindAllMatching(searchQuery, pageSize, pageNumber)
-	} else {
-		users, err = u.userRepo.AllUsers(pageSize, pageNumber)
+	listQuery := repos.UserListQuery{
+		Search:         sanitize.SingleLine(query.Get("search")),
+		Sort:           sanitize.PathName(query.Get("sort")),
+		InactiveSince:  int64QueryValue(httpRequest, "inactive_since"),
+		ExpiringBefore: int64QueryValue(httpRequest, "expiring_before"),
+		Limit:          pageSize,
+		Cursor:         sanitize.PathName(query.Get("cursor")),
+	}
+	listQuery.NeverSeen, _ = strconv.ParseBool(sanitize.AlphaNumeric(query.Get("never_seen"), false))
+
+	switch strings.ToLower(sanitize.AlphaNumeric(query.Get("order"), false)) {
+	case "asc":
+		listQuery.Descending = false
+	case "desc":
+		listQuery.Descending = true
+	default:
+		listQuery.Descending = repos.DefaultDescending(listQuery.Sort)
 	}
 
+	page, err := u.userRepo.ListUsers(httpRequest.Context(), listQuery)
 	if err != nil {
-		if errors.Is(err, repos.ErrUserNotFound) {
-			log.Printf("User/List [%v]: query for users (search=%s) and had no results", httpRequest.RemoteAddr, searchQuery)
-			users = []repos.User{}
-		} else {
-			log.Printf("User/List [%v]: could not get user list (page:%d, pageSize:%d search:%s): %v", httpRequest.RemoteAddr, pageNumber, pageSize, searchQuery, err)
-			http.Error(httpResponse, "failed to query database", http.StatusInternalServerError)
+		if errors.Is(err, repos.ErrInvalidSort) || errors.Is(err, repos.ErrInvalidCursor) {
+			http.Error(httpResponse, err.Error(), http.StatusBadRequest)
 
 			return
 		}
+
+		log.Printf("User/List [%v]: could not get user list (%+v): %v", httpRequest.RemoteAddr, listQuery, err)
+		renderRepositoryError(httpResponse, err, "failed to query database", http.StatusInternalServerError)
+
+		return
 	}
 
-	renderUserListResponse(httpResponse, httpRequest, users)
+	renderUserListResponse(httpResponse, httpRequest, page)
 }
 
 func (u *UserHandler) View(httpResponse http.ResponseWriter, httpRequest *http.Request) {
@@ -217,8 +305,8 @@ func (u *UserHandler) View(httpResponse http.ResponseWriter, httpRequest *http.R
 		email = claims.Email
 	}
 
-	if !claimsAllowsForUserPage(claims, email) {
-		log.Printf("User/View [%v]: %s requested %s page without admin rights", httpRequest.RemoteAddr, claims.Email, email)
+	if !claimsAllowsForUserPage(claims, email, helpers.PermissionUsersRead) {
+		log.Printf("User/View [%v]: %s requested %s page without read permission", httpRequest.RemoteAddr, claims.Email, email)
 		http.Error(httpResponse, "Not allowed", http.StatusForbidden)
 
 		return
@@ -226,12 +314,18 @@ func (u *UserHandler) View(httpResponse http.ResponseWriter, httpRequest *http.R
 
 	userPassword := ""
 
-	user, err := u.userRepo.FindByEmail(email)
+	user, err := u.userRepo.FindByEmail(httpRequest.Context(), email)
 	if errors.Is(err, repos.ErrUserNotFound) && strings.EqualFold(email, claims.Email) {
-		newUser, pwd, err := createNewUser(u.userRepo, claims.Email, claims.Name, claims.Picture)
+		newUser, pwd, err := createNewUser(httpRequest.Context(), u.userRepo, claims.Email, claims.Name, claims.Picture, 0)
+		if errors.Is(err, repos.ErrUserAlreadyExist) {
+			// A concurrent request enrolled the user first, its password was returned to that request
+			newUser, err = u.userRepo.FindByEmail(httpRequest.Context(), email)
+			pwd = &userPassword
+		}
+
 		if err != nil {
 			log.Printf("User/View [%v]: %s requested %s but failed: %v", httpRequest.RemoteAddr, claims.Email, email, err)
-			http.Error(httpResponse, err.Error(), http.StatusNotFound)
+			renderRepositoryError(httpResponse, err, err.Error(), http.StatusNotFound)
 
 			return
 		}
@@ -240,7 +334,7 @@ func (u *UserHandler) View(httpResponse http.ResponseWriter, httpRequest *http.R
 		user = newUser
 	} else if err != nil {
 		log.Printf("User/View [%v]: %s requested %s but failed: %v", httpRequest.RemoteAddr, claims.Email, email, err)
-		http.Error(httpResponse, err.Error(), http.StatusNotFound)
+		renderRepositoryError(httpResponse, err, err.Error(), http.StatusNotFound)
 
 		return
 	}
@@ -259,42 +353,64 @@ func (u *UserHandler) Renew(httpResponse http.ResponseWriter, httpRequest *http.
 		email = claims.Email
 	}
 
-	if !claimsAllowsForUserPage(claims, email) {
+	if !claimsAllowsForUserPage(claims, email, helpers.PermissionUsersRenew) {
 		log.Printf("User/Renew [%v]: %s cannot renew password for %s", httpRequest.RemoteAddr, claims.Email, email)
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserRenew, email, actionResultDenied)
 		http.Error(httpResponse, "Not allowed", http.StatusForbidden)
 
 		return
 	}
 
-	// Generate a password that is 64 characters long with 10 digits, 10 symbols,
-	// allowing upper and lower case letters, disallowing repeat characters.
-	pwd, err := password.Generate(newUserPasswordLen, newUserPasswordNumOfDigits, newUserPasswordNumOfSymbols, false, false)
+	// A disabled account is only enabled again by an admin, a new password must not bring it back
+	if strings.EqualFold(email, claims.Email) {
+		user, err := u.userRepo.FindByEmail(httpRequest.Context(), email)
+		if err == nil && user.DisabledAt != 0 {
+			log.Printf("User/Renew [%v]: %s cannot renew password of disabled account", httpRequest.RemoteAddr, email)
+			http.Error(httpResponse, "Account is disabled", http.StatusForbidden)
+
+			return
+		}
+	}
+
+	// Only renewals performed on behalf of another user are admin actions
+	auditRenew := func(result string) {
+		if !strings.EqualFold(email, claims.Email) {
+			recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserRenew, email, result)
+		}
+	}
+
+	pwd, err := repos.GeneratePassword()
 	if err != nil {
 		log.Printf("User/Renew [%v]: Fail to renew password for %s: %v", httpRequest.RemoteAddr, email, err)
+		auditRenew(actionResultFailed)
 		http.Error(httpResponse, "failed to renew password", http.StatusInternalServerError)
 
 		return
 	}
 
-	updated, err := u.userRepo.UpdatePassword(email, pwd)
+	updated, err := u.userRepo.UpdatePassword(httpRequest.Context(), email, pwd)
 	if err != nil {
 		log.Printf("User/Renew [%v]: Fail to renew password for %s: %v", httpRequest.RemoteAddr, email, err)
-		http.Error(httpResponse, "failed to renew password", http.StatusInternalServerError)
+		auditRenew(actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to renew password", http.StatusInternalServerError)
 
 		return
 	}
 
 	if !updated {
 		log.Printf("User/Renew [%v]: Fail to renew password for %s: %v", httpRequest.RemoteAddr, email, err)
+		auditRenew(actionResultFailed)
 		http.Error(httpResponse, "failed to renew password", http.StatusInternalServerError)
 
 		return
 	}
 
-	user, err := u.userRepo.FindByEmail(email)
+	auditRenew(actionResultSuccess)
+
+	user, err := u.userRepo.FindByEmail(httpRequest.Context(), email)
 	if err != nil {
 		log.Printf("User/Renew [%v]: Fail to get user after password renew %s: %v", httpRequest.RemoteAddr, email, err)
-		http.Error(httpResponse, "failed to renew password", http.StatusInternalServerError)
+		renderRepositoryError(httpResponse, err, "failed to renew password", http.StatusInternalServerError)
 
 		return
 	}
@@ -308,7 +424,8 @@ func (u *UserHandler) Delete(httpResponse http.ResponseWriter, httpRequest *http
 	vars := mux.Vars(httpRequest)
 	email := sanitize.Email(vars["email"], false)
 
-	if !isAuthorizedAdminRequest(httpRequest) {
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersDelete) {
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserDelete, email, actionResultDenied)
 		http.Error(httpResponse, "not authorized to delete user", http.StatusUnauthorized)
 
 		return
@@ -323,7 +440,15 @@ func (u *UserHandler) Delete(httpResponse http.ResponseWriter, httpRequest *http
 
 	response := UserActionResponse{}
 
-	err := u.userRepo.Delete(email)
+	err := u.userRepo.Delete(httpRequest.Context(), email)
+	if errors.Is(err, repos.ErrTimeout) {
+		log.Printf("User/Delete [%v]: timed out deleting: %s : %v", httpRequest.RemoteAddr, email, err)
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserDelete, email, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to delete user", http.StatusInternalServerError)
+
+		return
+	}
+
 	if err != nil {
 		log.Printf("User/Delete [%v]: failed to delete: %s : %v", httpRequest.RemoteAddr, email, err)
 		response.Result = actionResultFailed
@@ -337,6 +462,14 @@ func (u *UserHandler) Delete(httpResponse http.ResponseWriter, httpRequest *http
 		response.Result = actionResultSuccess
 	}
 
+	// Sessions are ended even when the user was already gone, they may have outlived it
+	if u.sessions != nil && response.Result != actionResultFailed {
+		if _, err := endUserSessions(httpRequest, u.sessions, u.authHelper, email); err != nil {
+			response.Result = actionResultFailed
+		}
+	}
+
+	recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserDelete, email, response.Result)
 	renderActionResponse(httpResponse, httpRequest, &response)
 }
 
@@ -357,7 +490,8 @@ func (u *UserHandler) Create(httpResponse http.ResponseWriter, httpRequest *http
 	email := sanitize.Email(request.Email, false)
 	name := sanitize.SingleLine(sanitize.Punctuation(request.Name))
 
-	if !isAuthorizedAdminRequest(httpRequest) {
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersCreate) {
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserCreate, email, actionResultDenied)
 		http.Error(httpResponse, "not authorized to create user", http.StatusUnauthorized)
 
 		return
@@ -377,9 +511,24 @@ func (u *UserHandler) Create(httpResponse http.ResponseWriter, httpRequest *http
 		return
 	}
 
+	if request.ExpiresAt < 0 {
+		log.Printf("User/Create [%v]: Invalid expiry for %s: %d", httpRequest.RemoteAddr, email, request.ExpiresAt)
+		http.Error(httpResponse, "invalid expiry", http.StatusBadRequest)
+
+		return
+	}
+
 	response := UserActionResponse{}
 
-	user, pwd, err := createNewUser(u.userRepo, email, name, "")
+	user, pwd, err := createNewUser(httpRequest.Context(), u.userRepo, email, name, "", request.ExpiresAt)
+	if errors.Is(err, repos.ErrTimeout) {
+		log.Printf("User/Create [%v]: timed out creating: %s : %v", httpRequest.RemoteAddr, email, err)
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserCreate, email, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to create user", http.StatusInternalServerError)
+
+		return
+	}
+
 	if err != nil {
 		log.Printf("User/Create [%v]: failed to create: %s : %v", httpRequest.RemoteAddr, email, err)
 		response.Result = actionResultExists
@@ -394,8 +543,295 @@ func (u *UserHandler) Create(httpResponse http.ResponseWriter, httpRequest *http
 			Password:          *pwd,
 			PasswordUpdatedAt: user.PasswordUpdatedAt,
 			LastSeenAt:        user.LastSeenAt,
+			ExpiresAt:         user.ExpiresAt,
+			Attributes:        user.Attributes,
 		}
 	}
 
+	recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserCreate, email, response.Result)
 	renderActionResponse(httpResponse, httpRequest, &response)
 }
+
+func transferFormatFromRequest(httpRequest *http.Request) string {
+	format := strings.ToLower(sanitize.AlphaNumeric(httpRequest.URL.Query().Get("format"), false))
+	if len(format) == 0 {
+		return repos.TransferFormatJSON
+	}
+
+	return format
+}
+
+// Export streams every user, including password hashes, as json or csv.
+func (u *UserHandler) Export(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersExport) {
+		http.Error(httpResponse, "not authorized to export users", http.StatusUnauthorized)
+
+		return
+	}
+
+	format := transferFormatFromRequest(httpRequest)
+	if format != repos.TransferFormatJSON && format != repos.TransferFormatCSV {
+		http.Error(httpResponse, "unsupported format", http.StatusBadRequest)
+
+		return
+	}
+
+	contentType := "application/json"
+	if format == repos.TransferFormatCSV {
+		contentType = "text/csv"
+	}
+
+	httpResponse.Header().Set("Content-Type", contentType)
+	httpResponse.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"fringe-users.%s\"", format))
+	httpResponse.Header().Add("Cache-Control", "no-store, no-cache, must-revalidate")
+
+	err := u.userRepo.ExportUsers(httpRequest.Context(), httpResponse, format)
+	if err != nil {
+		log.Printf("User/Export [%v]: failed to export users: %v", httpRequest.RemoteAddr, err)
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserExport, format, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to export users", http.StatusInternalServerError)
+
+		return
+	}
+
+	recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserExport, format, actionResultSuccess)
+}
+
+// Import creates or updates users from a json or csv body.
+// Query parameters: format (json or csv), dry_run (true to only report) and conflict (skip, overwrite or fail).
+func (u *UserHandler) Import(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersImport) {
+		http.Error(httpResponse, "not authorized to import users", http.StatusUnauthorized)
+
+		return
+	}
+
+	query := httpRequest.URL.Query()
+	dryRun, _ := strconv.ParseBool(sanitize.AlphaNumeric(query.Get("dry_run"), false))
+
+	conflict, err := repos.ParseConflictPolicy(sanitize.AlphaNumeric(query.Get("conflict"), false))
+	if err != nil {
+		http.Error(httpResponse, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	body := http.MaxBytesReader(httpResponse, httpRequest.Body, importMaxBodySize)
+
+	records, err := repos.DecodeUserImport(body, transferFormatFromRequest(httpRequest))
+	if err != nil {
+		log.Printf("User/Import [%v]: invalid import data: %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "unable to decode import data", http.StatusBadRequest)
+
+		return
+	}
+
+	report, err := u.userRepo.ImportUsers(httpRequest.Context(), records, repos.ImportOptions{
+		DryRun:        dryRun,
+		Conflict:      conflict,
+		AllowedDomain: u.authHelper.AllowedDomain,
+	})
+	if err != nil && !errors.Is(err, repos.ErrImportConflict) {
+		log.Printf("User/Import [%v]: failed to import users: %v", httpRequest.RemoteAddr, err)
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserImport, "", actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to import users", http.StatusInternalServerError)
+
+		return
+	}
+
+	if !dryRun {
+		result := actionResultSuccess
+		if err != nil {
+			result = actionResultExists
+		}
+
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserImport, fmt.Sprintf("%d records", len(records)), result)
+	}
+
+	jsonResponse, jsonErr := json.Marshal(report)
+	if jsonErr == nil && errors.Is(err, repos.ErrImportConflict) {
+		httpResponse.Header().Set("Content-Type", "application/json")
+		httpResponse.WriteHeader(http.StatusConflict)
+		_, _ = httpResponse.Write(jsonResponse)
+
+		return
+	}
+
+	renderResponseJSONResponse(httpResponse, httpRequest, jsonResponse, jsonErr)
+}
+
+// UpdateExpiry sets or removes the date at which the account stops authenticating.
+func (u *UserHandler) UpdateExpiry(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	vars := mux.Vars(httpRequest)
+	email := sanitize.Email(vars["email"], false)
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersExpiry) {
+		http.Error(httpResponse, "not authorized to change user expiry", http.StatusUnauthorized)
+
+		return
+	}
+
+	var request UserExpiryRequest
+
+	if err := json.NewDecoder(httpRequest.Body).Decode(&request); err != nil {
+		log.Printf("User/Expiry [src:%v] invalid post data %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable decode request", http.StatusBadRequest)
+
+		return
+	}
+
+	if !helpers.IsEmailValid(email) || request.ExpiresAt < 0 {
+		log.Printf("User/Expiry [%v]: Invalid expiry for %s: %d", httpRequest.RemoteAddr, email, request.ExpiresAt)
+		http.Error(httpResponse, "invalid email or expiry", http.StatusBadRequest)
+
+		return
+	}
+
+	err := u.userRepo.SetExpiry(httpRequest.Context(), email, request.ExpiresAt)
+	if err != nil {
+		log.Printf("User/Expiry [%v]: failed to set %s expiry: %v", httpRequest.RemoteAddr, email, err)
+
+		if errors.Is(err, repos.ErrUserNotFound) {
+			recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserExpiry, email, actionResultNotFound)
+			http.Error(httpResponse, err.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserExpiry, email, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to set user expiry", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("User/Expiry [%v]: user %s expires at %d", httpRequest.RemoteAddr, email, request.ExpiresAt)
+	recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserExpiry, email, actionResultSuccess)
+
+	user, err := u.userRepo.FindByEmail(httpRequest.Context(), email)
+	if err != nil {
+		log.Printf("User/Expiry [%v]: Fail to get user after expiry update %s: %v", httpRequest.RemoteAddr, email, err)
+		renderRepositoryError(httpResponse, err, "failed to set user expiry", http.StatusInternalServerError)
+
+		return
+	}
+
+	renderUserResponse(httpResponse, httpRequest, user, "")
+}
+
+// Enable lets a disabled user authenticate and log in again.
+func (u *UserHandler) Enable(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	vars := mux.Vars(httpRequest)
+	email := sanitize.Email(vars["email"], false)
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersEnable) {
+		http.Error(httpResponse, "not authorized to enable user", http.StatusUnauthorized)
+
+		return
+	}
+
+	if !helpers.IsEmailValid(email) {
+		log.Printf("User/Enable [%v]: Invalid email: %s", httpRequest.RemoteAddr, email)
+		http.Error(httpResponse, "invalid email", http.StatusBadRequest)
+
+		return
+	}
+
+	err := u.userRepo.Enable(httpRequest.Context(), email)
+	if err != nil {
+		log.Printf("User/Enable [%v]: failed to enable %s: %v", httpRequest.RemoteAddr, email, err)
+
+		if errors.Is(err, repos.ErrUserNotFound) {
+			recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserEnable, email, actionResultNotFound)
+			http.Error(httpResponse, err.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserEnable, email, actionResultFailed)
+		renderRepositoryError(httpResponse, err, "failed to enable user", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("User/Enable [%v]: user %s enabled", httpRequest.RemoteAddr, email)
+	recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserEnable, email, actionResultSuccess)
+
+	user, err := u.userRepo.FindByEmail(httpRequest.Context(), email)
+	if err != nil {
+		log.Printf("User/Enable [%v]: Fail to get user after enabling %s: %v", httpRequest.RemoteAddr, email, err)
+		renderRepositoryError(httpResponse, err, "failed to enable user", http.StatusInternalServerError)
+
+		return
+	}
+
+	renderUserResponse(httpResponse, httpRequest, user, "")
+}
+
+// UpdateAttributes replaces the custom attributes of the user with the JSON object of the request body.
+func (u *UserHandler) UpdateAttributes(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+	defer func() { _ = httpRequest.Body.Close() }()
+
+	vars := mux.Vars(httpRequest)
+	email := sanitize.Email(vars["email"], false)
+
+	if !isAuthorizedRequest(httpRequest, helpers.PermissionUsersAttributes) {
+		http.Error(httpResponse, "not authorized to change user attributes", http.StatusUnauthorized)
+
+		return
+	}
+
+	var attributes map[string]string
+
+	if err := json.NewDecoder(httpRequest.Body).Decode(&attributes); err != nil {
+		log.Printf("User/Attributes [src:%v] invalid post data %v", httpRequest.RemoteAddr, err)
+		http.Error(httpResponse, "Unable decode request", http.StatusBadRequest)
+
+		return
+	}
+
+	if !helpers.IsEmailValid(email) {
+		log.Printf("User/Attributes [%v]: Invalid email: %s", httpRequest.RemoteAddr, email)
+		http.Error(httpResponse, "invalid email", http.StatusBadRequest)
+
+		return
+	}
+
+	err := u.userRepo.SetAttributes(httpRequest.Context(), email, attributes)
+	if err != nil {
+		log.Printf("User/Attributes [%v]: failed to set %s attributes: %v", httpRequest.RemoteAddr, email, err)
+
+		switch {
+		case errors.Is(err, repos.ErrInvalidAttribute):
+			http.Error(httpResponse, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, repos.ErrUserNotFound):
+			recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserAttributes, email, actionResultNotFound)
+			http.Error(httpResponse, err.Error(), http.StatusNotFound)
+		default:
+			recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserAttributes, email, actionResultFailed)
+			renderRepositoryError(httpResponse, err, "failed to set user attributes", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	log.Printf("User/Attributes [%v]: user %s has %d attributes", httpRequest.RemoteAddr, email, len(attributes))
+	recordAudit(u.auditRepo, httpRequest, repos.AuditActionUserAttributes, email, actionResultSuccess)
+
+	user, err := u.userRepo.FindByEmail(httpRequest.Context(), email)
+	if err != nil {
+		log.Printf("User/Attributes [%v]: Fail to get user after attributes update %s: %v", httpRequest.RemoteAddr, email, err)
+		renderRepositoryError(httpResponse, err, "failed to set user attributes", http.StatusInternalServerError)
+
+		return
+	}
+
+	renderUserResponse(httpResponse, httpRequest, user, "")
+}
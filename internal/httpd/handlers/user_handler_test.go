@@ -2,12 +2,16 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jaswdr/faker"
@@ -27,11 +31,20 @@ const (
 func createUserHandler(t *testing.T) (*handlers.UserHandler, *repos.UserRepository) {
 	t.Helper()
 
+	userHandler, userRepo, _ := createUserHandlerWithAudit(t)
+
+	return userHandler, userRepo
+}
+
+func createUserHandlerWithAudit(t *testing.T) (*handlers.UserHandler, *repos.UserRepository, *repos.AuditRepository) {
+	t.Helper()
+
 	fake := faker.New()
 	userRepo := mocks.NewMockUserRepository(t)
+	auditRepo := mocks.NewMockAuditRepository(t)
 
 	for _, email := range []string{adminEmail, regularUserEmail} {
-		_, err := userRepo.Create(email, fake.Person().Name(), fake.Internet().URL(), fake.Internet().Password())
+		_, err := userRepo.Create(context.Background(), email, fake.Person().Name(), fake.Internet().URL(), fake.Internet().Password())
 		if err != nil {
 			t.Fatalf("Could not add admin user to test database: %v", err)
 		}
@@ -39,9 +52,9 @@ func createUserHandler(t *testing.T) (*handlers.UserHandler, *repos.UserReposito
 
 	authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
 
-	userHandler := handlers.NewUserHandler(userRepo, authHelper)
+	userHandler := handlers.NewUserHandler(userRepo, auditRepo, authHelper)
 
-	return userHandler, userRepo
+	return userHandler, userRepo, auditRepo
 }
 
 func makeRequestToHandlerWithClaims(claims *helpers.AuthClaims, path string, handler func(http.ResponseWriter, *http.Request), req *http.Request) *httptest.ResponseRecorder {
@@ -69,8 +82,8 @@ func TestUserHandler_List(t *testing.T) {
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       regularUserEmail,
-			Permissions: "",
+			Email: regularUserEmail,
+			Role:  "",
 		}
 
 		req := httptest.NewRequest(http.MethodGet, "/users/", nil)
@@ -85,7 +98,8 @@ func TestUserHandler_List(t *testing.T) {
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		req := httptest.NewRequest(http.MethodGet, "/users/", nil)
@@ -94,24 +108,54 @@ func TestUserHandler_List(t *testing.T) {
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 	})
 
-	t.Run("Defaults to page 0 if argument is not a number", func(t *testing.T) {
+	t.Run("Return OK for auditors", func(t *testing.T) {
 		t.Parallel()
 
 		userHandler, _ := createUserHandler(t)
-		claims := helpers.AuthClaims{
-			Email:       adminEmail,
-			Permissions: "admin",
-		}
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.AuditorRoleString)
 
-		req := httptest.NewRequest(http.MethodGet, "/users/?page=rabbit", nil)
-		res := makeRequestToHandlerWithClaims(&claims, "/users/", userHandler.List, req)
+		req := httptest.NewRequest(http.MethodGet, "/users/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.List, req)
 
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+	})
 
-		var usersInResponse []handlers.UserResponse
-		err := json.Unmarshal(res.Body.Bytes(), &usersInResponse)
-		assert.NoError(t, err)
-		assert.NotEmpty(t, usersInResponse)
+	t.Run("Return service unavailable when the database times out", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		userRepo.SetTimeouts(repos.Timeouts{Read: time.Nanosecond})
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.List, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, res.Result().StatusCode)
+		assert.NotEmpty(t, res.Result().Header.Get("Retry-After"))
+	})
+
+	t.Run("Refuses invalid cursors", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/?cursor=rabbit", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.List, req)
+
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+	})
+
+	t.Run("Refuses unknown sort", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/?sort=password", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.List, req)
+
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
 	})
 
 	t.Run("Defaults to 10 per_page if argument is not a number", func(t *testing.T) {
@@ -120,7 +164,8 @@ func TestUserHandler_List(t *testing.T) {
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		req := httptest.NewRequest(http.MethodGet, "/users/?per_page=rabbit", nil)
@@ -128,30 +173,129 @@ func TestUserHandler_List(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 
-		var usersInResponse []handlers.UserResponse
-		err := json.Unmarshal(res.Body.Bytes(), &usersInResponse)
+		var response handlers.UserListResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
 		assert.NoError(t, err)
-		assert.NotEmpty(t, usersInResponse)
+		assert.NotEmpty(t, response.Items)
+		assert.Equal(t, int64(len(response.Items)), response.Total)
+		assert.Empty(t, response.NextCursor)
 	})
 
-	t.Run("Return no users when asking for a page past max", func(t *testing.T) {
+	t.Run("Follows next cursor until the last page", func(t *testing.T) {
 		t.Parallel()
 
 		userHandler, _ := createUserHandler(t)
-		claims := helpers.AuthClaims{
-			Email:       adminEmail,
-			Permissions: "admin",
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		var emails []string
+
+		cursor := ""
+		for pages := 0; pages < 10; pages++ {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/?per_page=1&sort=name&cursor=%s", cursor), nil)
+			res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.List, req)
+			assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+			var response handlers.UserListResponse
+			err := json.Unmarshal(res.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Len(t, response.Items, 1)
+			assert.Equal(t, int64(3), response.Total)
+
+			emails = append(emails, response.Items[0].Email)
+
+			cursor = response.NextCursor
+			if len(cursor) == 0 {
+				break
+			}
 		}
 
-		req := httptest.NewRequest(http.MethodGet, "/users/?per_page=100&page=2", nil)
-		res := makeRequestToHandlerWithClaims(&claims, "/users/", userHandler.List, req)
+		assert.Len(t, emails, 3)
+		assert.Contains(t, emails, adminEmail)
+		assert.Contains(t, emails, regularUserEmail)
+	})
+
+	t.Run("Sorts by email in requested order", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/?sort=email&order=desc", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.List, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.UserListResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Len(t, response.Items, 3)
+
+		for index := 1; index < len(response.Items); index++ {
+			assert.Greater(t, response.Items[index-1].Email, response.Items[index].Email)
+		}
+	})
+
+	t.Run("Filters users never seen", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		// Seen is recorded with a one second resolution
+		time.Sleep(time.Second)
+		assert.NoError(t, userRepo.Seen(context.Background(), adminEmail))
+
+		req := httptest.NewRequest(http.MethodGet, "/users/?never_seen=true", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.List, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.UserListResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), response.Total)
+
+		for _, user := range response.Items {
+			assert.NotEqual(t, adminEmail, user.Email)
+		}
+	})
+
+	t.Run("Filters users inactive since a date", func(t *testing.T) {
+		t.Parallel()
 
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/?inactive_since=%d", time.Now().Add(-time.Hour).Unix()), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.List, req)
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 
-		var usersInResponse []handlers.UserResponse
-		err := json.Unmarshal(res.Body.Bytes(), &usersInResponse)
+		var response handlers.UserListResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
 		assert.NoError(t, err)
-		assert.Empty(t, usersInResponse)
+		assert.Empty(t, response.Items)
+		assert.Equal(t, int64(0), response.Total)
+	})
+
+	t.Run("Filters users expiring before a date", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		expiresAt := time.Now().Add(time.Hour).Unix()
+		assert.NoError(t, userRepo.SetExpiry(context.Background(), regularUserEmail, expiresAt))
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/?expiring_before=%d", time.Now().Add(24*time.Hour).Unix()), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.List, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.UserListResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), response.Total)
+
+		if assert.Len(t, response.Items, 1) {
+			assert.Equal(t, regularUserEmail, response.Items[0].Email)
+			assert.Equal(t, expiresAt, response.Items[0].ExpiresAt)
+		}
 	})
 
 	t.Run("Returns only users matching a query string", func(t *testing.T) {
@@ -160,7 +304,8 @@ func TestUserHandler_List(t *testing.T) {
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		escapedAdminEmail := url.QueryEscape(adminEmail)
@@ -169,10 +314,11 @@ func TestUserHandler_List(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 
-		var usersInResponse []handlers.UserResponse
-		err := json.Unmarshal(res.Body.Bytes(), &usersInResponse)
+		var response handlers.UserListResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
 		assert.NoError(t, err)
-		assert.Len(t, usersInResponse, 1)
+		assert.Len(t, response.Items, 1)
+		assert.Equal(t, int64(1), response.Total)
 	})
 
 	t.Run("Returns no users when nothing matches search string", func(t *testing.T) {
@@ -181,7 +327,8 @@ func TestUserHandler_List(t *testing.T) {
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/?search=%s", "ThereAreNoUserMatchingThisString"), nil)
@@ -189,10 +336,10 @@ func TestUserHandler_List(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 
-		var usersInResponse []handlers.UserResponse
-		err := json.Unmarshal(res.Body.Bytes(), &usersInResponse)
+		var response handlers.UserListResponse
+		err := json.Unmarshal(res.Body.Bytes(), &response)
 		assert.NoError(t, err)
-		assert.Empty(t, usersInResponse)
+		assert.Empty(t, response.Items)
 	})
 }
 
@@ -205,7 +352,8 @@ func TestUserHandler_View(t *testing.T) {
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		// View
@@ -221,7 +369,8 @@ func TestUserHandler_View(t *testing.T) {
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		// View
@@ -236,8 +385,8 @@ func TestUserHandler_View(t *testing.T) {
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       regularUserEmail,
-			Permissions: "",
+			Email: regularUserEmail,
+			Role:  "",
 		}
 
 		// View
@@ -252,8 +401,8 @@ func TestUserHandler_View(t *testing.T) {
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       regularUserEmail,
-			Permissions: "",
+			Email: regularUserEmail,
+			Role:  "",
 		}
 
 		// View
@@ -269,15 +418,32 @@ func TestUserHandler_View(t *testing.T) {
 		assert.Equal(t, user.Email, claims.Email)
 	})
 
+	t.Run("User sees own expiry", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+		expiresAt := time.Now().Add(time.Hour).Unix()
+		assert.NoError(t, userRepo.SetExpiry(context.Background(), regularUserEmail, expiresAt))
+
+		req := httptest.NewRequest(http.MethodGet, "/users/me/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/", userHandler.View, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var user handlers.UserResponse
+		assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &user))
+		assert.Equal(t, expiresAt, user.ExpiresAt)
+	})
+
 	t.Run("User enroll when first querying their info", func(t *testing.T) {
 		t.Parallel()
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       "new-user@newuser.com",
-			Name:        "New User",
-			Permissions: "",
-			Picture:     "",
+			Email:   "new-user@newuser.com",
+			Name:    "New User",
+			Role:    "",
+			Picture: "",
 		}
 
 		// View
@@ -287,13 +453,61 @@ func TestUserHandler_View(t *testing.T) {
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 	})
 
+	t.Run("Concurrent first queries enroll the user once", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims("New-User@newuser.com", "New User", "", helpers.UserRoleString)
+
+		const requests = 8
+
+		var waitGroup sync.WaitGroup
+
+		responses := make(chan handlers.UserResponse, requests)
+
+		for index := 0; index < requests; index++ {
+			waitGroup.Add(1)
+
+			go func() {
+				defer waitGroup.Done()
+
+				req := httptest.NewRequest(http.MethodGet, "/users/me/", nil)
+				res := makeRequestToHandlerWithClaims(claims, "/users/{email}/", userHandler.View, req)
+				assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+				var response handlers.UserResponse
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+				responses <- response
+			}()
+		}
+
+		waitGroup.Wait()
+		close(responses)
+
+		withPassword := 0
+
+		for response := range responses {
+			assert.Equal(t, "new-user@newuser.com", response.Email)
+
+			if len(response.Password) > 0 {
+				withPassword++
+			}
+		}
+
+		assert.Equal(t, 1, withPassword)
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Search: "newuser.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), page.Total)
+	})
+
 	t.Run("Regular user cannot view other users", func(t *testing.T) {
 		t.Parallel()
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       regularUserEmail,
-			Permissions: "",
+			Email: regularUserEmail,
+			Role:  "",
 		}
 
 		// View
@@ -314,7 +528,8 @@ func TestUserHandler_Renew(t *testing.T) {
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		// Renew
@@ -326,17 +541,129 @@ func TestUserHandler_Renew(t *testing.T) {
 		assert.Equal(t, "no-store, no-cache, must-revalidate", cacheControl)
 	})
 
+	t.Run("Helpdesk can renew existing users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims("helpdesk@test.com", "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s/renew", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}/renew", userHandler.Renew, req)
+
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+	})
+
+	t.Run("Auditor cannot renew other users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims("auditor@test.com", "", "", helpers.AuditorRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s/renew", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}/renew", userHandler.Renew, req)
+
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+	})
+
+	t.Run("Renewing another user is recorded in the audit log", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _, auditRepo := createUserHandlerWithAudit(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s/renew", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}/renew", userHandler.Renew, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionUserRenew})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, adminEmail, entries[0].Actor)
+		assert.Equal(t, regularUserEmail, entries[0].Target)
+		assert.Equal(t, "success", entries[0].Result)
+		assert.NotEmpty(t, entries[0].SourceIP)
+	})
+
+	t.Run("Denied renewals are recorded in the audit log", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _, auditRepo := createUserHandlerWithAudit(t)
+		claims := helpers.NewAuthClaims("auditor@test.com", "", "", helpers.AuditorRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s/renew", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}/renew", userHandler.Renew, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionUserRenew})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, "auditor@test.com", entries[0].Actor)
+		assert.Equal(t, "denied", entries[0].Result)
+	})
+
+	t.Run("Renewing own password is not recorded in the audit log", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _, auditRepo := createUserHandlerWithAudit(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/user/me/renew", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}/renew", userHandler.Renew, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{})
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("Disabled users cannot enable their account with a new password", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		assert.NoError(t, userRepo.Disable(context.Background(), regularUserEmail))
+
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+		req := httptest.NewRequest(http.MethodGet, "/user/me/renew", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}/renew", userHandler.Renew, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+
+		// Admins renew the password without enabling the account
+		claims = helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s/renew", regularUserEmail), nil)
+		res = makeRequestToHandlerWithClaims(claims, "/user/{email}/renew", userHandler.Renew, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		user, err := userRepo.FindByEmail(context.Background(), regularUserEmail)
+		assert.NoError(t, err)
+		assert.NotZero(t, user.DisabledAt)
+	})
+
+	t.Run("API tokens cannot renew the password of their user without the permission", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/user/me/renew", nil)
+		res := makeRequestToHandlerWithAPIToken(t, userRepo, regularUserEmail, nil, "/user/{email}/renew", userHandler.Renew, req)
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/user/%s/renew", regularUserEmail), nil)
+		res = makeRequestToHandlerWithAPIToken(t, userRepo, adminEmail, []string{string(helpers.PermissionUsersRenew)}, "/user/{email}/renew", userHandler.Renew, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+	})
+
 	t.Run("Admin cannot renew none-existing users", func(t *testing.T) {
 		t.Parallel()
 
 		userHandler, userRepo := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		// Make sure user doesn't exist
-		err := userRepo.Delete(regularUserEmail)
+		err := userRepo.Delete(context.Background(), regularUserEmail)
 		assert.NoError(t, err)
 
 		// Renew
@@ -351,8 +678,8 @@ func TestUserHandler_Renew(t *testing.T) {
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       regularUserEmail,
-			Permissions: "",
+			Email: regularUserEmail,
+			Role:  "",
 		}
 
 		// Renew
@@ -367,8 +694,8 @@ func TestUserHandler_Renew(t *testing.T) {
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       regularUserEmail,
-			Permissions: "",
+			Email: regularUserEmail,
+			Role:  "",
 		}
 
 		// Renew
@@ -389,8 +716,8 @@ func TestUserHandler_Renew(t *testing.T) {
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       regularUserEmail,
-			Permissions: "",
+			Email: regularUserEmail,
+			Role:  "",
 		}
 
 		// Renew
@@ -409,8 +736,8 @@ func TestUserHandler_Delete(t *testing.T) {
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       regularUserEmail,
-			Permissions: "",
+			Email: regularUserEmail,
+			Role:  "",
 		}
 
 		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/user/%s", regularUserEmail), nil)
@@ -425,11 +752,12 @@ func TestUserHandler_Delete(t *testing.T) {
 		userHandler, userRepo := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		// User is in the DB
-		user, err := userRepo.FindByEmail(regularUserEmail)
+		user, err := userRepo.FindByEmail(context.Background(), regularUserEmail)
 		assert.NoError(t, err)
 		assert.Equal(t, regularUserEmail, user.Email)
 
@@ -440,18 +768,104 @@ func TestUserHandler_Delete(t *testing.T) {
 		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
 
 		// User not in database
-		user, err = userRepo.FindByEmail(regularUserEmail)
+		user, err = userRepo.FindByEmail(context.Background(), regularUserEmail)
 		assert.ErrorIs(t, err, repos.ErrUserNotFound)
 		assert.Nil(t, user)
 	})
 
+	t.Run("Ends the sessions of deleted users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		sessions := mocks.NewMockSessionRepository(t)
+		userHandler.SetSessions(sessions)
+
+		_, _, err := sessions.Create(context.Background(), regularUserEmail, "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/user/%s", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}", userHandler.Delete, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		active, err := sessions.RevokeUser(context.Background(), regularUserEmail)
+		assert.NoError(t, err)
+		assert.Empty(t, active)
+	})
+
+	t.Run("Return unauthorized for helpdesk", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims("helpdesk@test.com", "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/user/%s", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}", userHandler.Delete, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+		assert.True(t, userRepo.Exists(context.Background(), regularUserEmail))
+	})
+
+	t.Run("Return service unavailable when the database times out", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo, auditRepo := createUserHandlerWithAudit(t)
+		userRepo.SetTimeouts(repos.Timeouts{Write: time.Nanosecond})
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/user/%s", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}", userHandler.Delete, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, res.Result().StatusCode)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionUserDelete})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, "failed", entries[0].Result)
+	})
+
+	t.Run("Denied deletions are recorded in the audit log", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _, auditRepo := createUserHandlerWithAudit(t)
+		claims := helpers.NewAuthClaims("helpdesk@test.com", "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/user/%s", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}", userHandler.Delete, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionUserDelete})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, regularUserEmail, entries[0].Target)
+		assert.Equal(t, "denied", entries[0].Result)
+	})
+
+	t.Run("Deleting is recorded in the audit log", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _, auditRepo := createUserHandlerWithAudit(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/user/%s", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/user/{email}", userHandler.Delete, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Target: regularUserEmail})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, repos.AuditActionUserDelete, entries[0].Action)
+		assert.Equal(t, "success", entries[0].Result)
+	})
+
 	t.Run("Refuses invalid email", func(t *testing.T) {
 		t.Parallel()
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		// Delete
@@ -467,7 +881,8 @@ func TestUserHandler_Delete(t *testing.T) {
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		// Delete
@@ -493,8 +908,8 @@ func TestUserHandler_Create(t *testing.T) {
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
-			Email:       regularUserEmail,
-			Permissions: "",
+			Email: regularUserEmail,
+			Role:  "",
 		}
 
 		userData := handlers.UserCreateRequest{
@@ -510,13 +925,33 @@ func TestUserHandler_Create(t *testing.T) {
 		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
 	})
 
+	t.Run("Return unauthorized for auditors", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims("auditor@test.com", "", "", helpers.AuditorRoleString)
+
+		userData := handlers.UserCreateRequest{
+			Email: "new.user@test.com",
+			Name:  "New User",
+		}
+		jsonBytes, err := json.Marshal(userData)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/", bytes.NewBuffer(jsonBytes))
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.Create, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
 	t.Run("Return invalid request on absent data", func(t *testing.T) {
 		t.Parallel()
 
 		userHandler, _ := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		req := httptest.NewRequest(http.MethodPost, "/users/", nil)
@@ -531,7 +966,8 @@ func TestUserHandler_Create(t *testing.T) {
 		userHandler, userRepo := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		userData := handlers.UserCreateRequest{
@@ -545,7 +981,7 @@ func TestUserHandler_Create(t *testing.T) {
 		res := makeRequestToHandlerWithClaims(&claims, "/users/", userHandler.Create, req)
 
 		// User is NOT in database
-		user, err := userRepo.FindByEmail(userData.Email)
+		user, err := userRepo.FindByEmail(context.Background(), userData.Email)
 		assert.Error(t, err, repos.ErrUserNotFound)
 		assert.Nil(t, user)
 
@@ -559,7 +995,8 @@ func TestUserHandler_Create(t *testing.T) {
 		userHandler, userRepo := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		userData := handlers.UserCreateRequest{
@@ -573,7 +1010,7 @@ func TestUserHandler_Create(t *testing.T) {
 		res := makeRequestToHandlerWithClaims(&claims, "/users/", userHandler.Create, req)
 
 		// User is NOT in database
-		user, err := userRepo.FindByEmail(userData.Email)
+		user, err := userRepo.FindByEmail(context.Background(), userData.Email)
 		assert.Error(t, err, repos.ErrUserNotFound)
 		assert.Nil(t, user)
 
@@ -587,7 +1024,8 @@ func TestUserHandler_Create(t *testing.T) {
 		userHandler, userRepo := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		userData := handlers.UserCreateRequest{
@@ -601,7 +1039,7 @@ func TestUserHandler_Create(t *testing.T) {
 		res := makeRequestToHandlerWithClaims(&claims, "/users/", userHandler.Create, req)
 
 		// User is in database
-		user, err := userRepo.FindByEmail(userData.Email)
+		user, err := userRepo.FindByEmail(context.Background(), userData.Email)
 		assert.NoError(t, err)
 		assert.NotNil(t, user)
 		assert.Equal(t, user.Email, userData.Email)
@@ -623,7 +1061,8 @@ func TestUserHandler_Create(t *testing.T) {
 		userHandler, userRepo := createUserHandler(t)
 		claims := helpers.AuthClaims{
 			Email:       adminEmail,
-			Permissions: "admin",
+			Role:        helpers.AdminRoleString,
+			Permissions: helpers.PermissionsForRole(helpers.AdminRoleString),
 		}
 
 		userData := handlers.UserCreateRequest{
@@ -637,7 +1076,7 @@ func TestUserHandler_Create(t *testing.T) {
 		res := makeRequestToHandlerWithClaims(&claims, "/users/", userHandler.Create, req)
 
 		// User is in database
-		user, err := userRepo.FindByEmail(userData.Email)
+		user, err := userRepo.FindByEmail(context.Background(), userData.Email)
 		assert.NoError(t, err)
 		assert.NotNil(t, user)
 		assert.Equal(t, user.Email, userData.Email)
@@ -658,7 +1097,420 @@ func TestUserHandler_Create(t *testing.T) {
 		assert.NotEmpty(t, response.User.Password)
 	})
 
+	t.Run("Sets the expiry of created users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		expiresAt := time.Now().Add(30 * 24 * time.Hour).Unix()
+
+		jsonBytes, err := json.Marshal(handlers.UserCreateRequest{Email: "contractor@test.com", ExpiresAt: expiresAt})
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/", bytes.NewBuffer(jsonBytes))
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.Create, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.UserActionResponse
+		assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+		assert.Equal(t, "success", response.Result)
+		assert.Equal(t, expiresAt, response.User.ExpiresAt)
+
+		user, err := userRepo.FindByEmail(context.Background(), "contractor@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, expiresAt, user.ExpiresAt)
+	})
+
+	t.Run("Refuses negative expiry", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		jsonBytes, err := json.Marshal(handlers.UserCreateRequest{Email: "contractor@test.com", ExpiresAt: -1})
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/", bytes.NewBuffer(jsonBytes))
+		res := makeRequestToHandlerWithClaims(claims, "/users/", userHandler.Create, req)
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+	})
+
 	t.Run("", func(t *testing.T) {
 		t.Parallel()
 	})
 }
+
+func TestUserHandler_Enable(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Only admins enable users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo, auditRepo := createUserHandlerWithAudit(t)
+		assert.NoError(t, userRepo.Disable(context.Background(), regularUserEmail))
+
+		for _, role := range []string{helpers.UserRoleString, helpers.HelpdeskRoleString} {
+			claims := helpers.NewAuthClaims("staff@test.com", "", "", role)
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s/enable/", regularUserEmail), nil)
+			res := makeRequestToHandlerWithClaims(claims, "/users/{email}/enable/", userHandler.Enable, req)
+			assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode, role)
+		}
+
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s/enable/", regularUserEmail), nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/enable/", userHandler.Enable, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		user, err := userRepo.FindByEmail(context.Background(), regularUserEmail)
+		assert.NoError(t, err)
+		assert.Zero(t, user.DisabledAt)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionUserEnable})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Return not found on unknown users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPut, "/users/unknown@test.com/enable/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/enable/", userHandler.Enable, req)
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+	})
+}
+
+func TestUserHandler_UpdateExpiry(t *testing.T) {
+	t.Parallel()
+
+	expiryRequest := func(t *testing.T, email string, expiresAt int64) *http.Request {
+		t.Helper()
+
+		jsonBytes, err := json.Marshal(handlers.UserExpiryRequest{ExpiresAt: expiresAt})
+		assert.NoError(t, err)
+
+		return httptest.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s/expiry/", url.PathEscape(email)), bytes.NewBuffer(jsonBytes))
+	}
+
+	t.Run("Return unauthorized for auditors", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims("auditor@test.com", "", "", helpers.AuditorRoleString)
+
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/expiry/", userHandler.UpdateExpiry, expiryRequest(t, regularUserEmail, 1))
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Users cannot change their own expiry", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.UserRoleString)
+
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/expiry/", userHandler.UpdateExpiry, expiryRequest(t, regularUserEmail, 0))
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Helpdesk sets the expiry and it is recorded in the audit log", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo, auditRepo := createUserHandlerWithAudit(t)
+		claims := helpers.NewAuthClaims("helpdesk@test.com", "", "", helpers.HelpdeskRoleString)
+		expiresAt := time.Now().Add(time.Hour).Unix()
+
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/expiry/", userHandler.UpdateExpiry, expiryRequest(t, regularUserEmail, expiresAt))
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.UserResponse
+		assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+		assert.Equal(t, expiresAt, response.ExpiresAt)
+		assert.Empty(t, response.Password)
+
+		user, _ := userRepo.FindByEmail(context.Background(), regularUserEmail)
+		assert.Equal(t, expiresAt, user.ExpiresAt)
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionUserExpiry})
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, regularUserEmail, entries[0].Target)
+			assert.Equal(t, "success", entries[0].Result)
+		}
+	})
+
+	t.Run("Refuses negative expiry", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/expiry/", userHandler.UpdateExpiry, expiryRequest(t, regularUserEmail, -1))
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+	})
+
+	t.Run("Return not found on unknown users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/expiry/", userHandler.UpdateExpiry, expiryRequest(t, "unknown@test.com", 1))
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+	})
+}
+
+func TestUserHandler_UpdateAttributes(t *testing.T) {
+	t.Parallel()
+
+	attributesRequest := func(t *testing.T, email string, attributes map[string]string) *http.Request {
+		t.Helper()
+
+		jsonBytes, err := json.Marshal(attributes)
+		assert.NoError(t, err)
+
+		return httptest.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s/attributes/", url.PathEscape(email)), bytes.NewBuffer(jsonBytes))
+	}
+
+	t.Run("Return unauthorized for helpdesk", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims("helpdesk@test.com", "", "", helpers.HelpdeskRoleString)
+
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/attributes/", userHandler.UpdateAttributes, attributesRequest(t, regularUserEmail, map[string]string{"class": "admins"}))
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Admin sets attributes and it is recorded in the audit log", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo, auditRepo := createUserHandlerWithAudit(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/attributes/", userHandler.UpdateAttributes, attributesRequest(t, regularUserEmail, map[string]string{"Department": "Sales"}))
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var response handlers.UserResponse
+		assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+		assert.Equal(t, map[string]string{"department": "Sales"}, response.Attributes)
+
+		user, _ := userRepo.FindByEmail(context.Background(), regularUserEmail)
+		assert.Equal(t, map[string]string{"department": "Sales"}, user.Attributes)
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionUserAttributes})
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Refuses invalid attributes", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/attributes/", userHandler.UpdateAttributes, attributesRequest(t, regularUserEmail, map[string]string{"not valid": "Sales"}))
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+	})
+
+	t.Run("Return not found on unknown users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		res := makeRequestToHandlerWithClaims(claims, "/users/{email}/attributes/", userHandler.UpdateAttributes, attributesRequest(t, "unknown@test.com", map[string]string{"class": "admins"}))
+		assert.Equal(t, http.StatusNotFound, res.Result().StatusCode)
+	})
+}
+
+func TestUserHandler_Export(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return unauthorized for helpdesk", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/export/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/export/", userHandler.Export, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+	})
+
+	t.Run("Admin can export as json", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo, auditRepo := createUserHandlerWithAudit(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		allUsers, _ := userRepo.AllUsers(context.Background(), 0, 0)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/export/", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/export/", userHandler.Export, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assert.Equal(t, "application/json", res.Result().Header.Get("Content-Type"))
+
+		var users []repos.User
+		err := json.NewDecoder(res.Body).Decode(&users)
+		assert.NoError(t, err)
+		assert.Len(t, users, len(allUsers))
+		assert.NotEmpty(t, users[0].PasswordHash)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionUserExport})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Admin can export as csv", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+		allUsers, _ := userRepo.AllUsers(context.Background(), 0, 0)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/export/?format=csv", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/export/", userHandler.Export, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assert.Equal(t, "text/csv", res.Result().Header.Get("Content-Type"))
+
+		records, err := repos.DecodeUserImport(res.Body, repos.TransferFormatCSV)
+		assert.NoError(t, err)
+		assert.Len(t, records, len(allUsers))
+	})
+
+	t.Run("Refuses unknown formats", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodGet, "/users/export/?format=xml", nil)
+		res := makeRequestToHandlerWithClaims(claims, "/users/export/", userHandler.Export, req)
+
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+	})
+}
+
+func TestUserHandler_Import(t *testing.T) {
+	t.Parallel()
+
+	importBody := func(emails ...string) *bytes.Buffer {
+		records := make([]map[string]string, 0, len(emails))
+		for _, email := range emails {
+			records = append(records, map[string]string{"email": email})
+		}
+
+		body, _ := json.Marshal(records)
+
+		return bytes.NewBuffer(body)
+	}
+
+	t.Run("Return unauthorized for helpdesk", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(regularUserEmail, "", "", helpers.HelpdeskRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/import/", importBody("new@test.com"))
+		res := makeRequestToHandlerWithClaims(claims, "/users/import/", userHandler.Import, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Result().StatusCode)
+		assert.False(t, userRepo.Exists(context.Background(), "new@test.com"))
+	})
+
+	t.Run("Admin can import users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo, auditRepo := createUserHandlerWithAudit(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/import/", importBody("new@test.com", regularUserEmail))
+		res := makeRequestToHandlerWithClaims(claims, "/users/import/", userHandler.Import, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var report repos.ImportReport
+		err := json.NewDecoder(res.Body).Decode(&report)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 1, report.Skipped)
+		assert.True(t, userRepo.Exists(context.Background(), "new@test.com"))
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionUserImport})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Dry run does not create users", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/import/?dry_run=true", importBody("new@test.com"))
+		res := makeRequestToHandlerWithClaims(claims, "/users/import/", userHandler.Import, req)
+
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+		assert.False(t, userRepo.Exists(context.Background(), "new@test.com"))
+	})
+
+	t.Run("Conflicts return the report with conflict status", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/import/?conflict=fail", importBody("new@test.com", regularUserEmail))
+		res := makeRequestToHandlerWithClaims(claims, "/users/import/", userHandler.Import, req)
+		assert.Equal(t, http.StatusConflict, res.Result().StatusCode)
+
+		var report repos.ImportReport
+		err := json.NewDecoder(res.Body).Decode(&report)
+		assert.NoError(t, err)
+		assert.Equal(t, repos.ImportStatusConflict, report.Results[1].Status)
+		assert.False(t, userRepo.Exists(context.Background(), "new@test.com"))
+	})
+
+	t.Run("Refuses unknown conflict policies", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, _ := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/import/?conflict=merge", importBody("new@test.com"))
+		res := makeRequestToHandlerWithClaims(claims, "/users/import/", userHandler.Import, req)
+
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+	})
+
+	t.Run("Refuses users outside the allowed domain", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		req := httptest.NewRequest(http.MethodPost, "/users/import/", importBody("new@test.com", "outsider@other.com"))
+		res := makeRequestToHandlerWithClaims(claims, "/users/import/", userHandler.Import, req)
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+
+		var report repos.ImportReport
+		err := json.NewDecoder(res.Body).Decode(&report)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 1, report.Invalid)
+		assert.False(t, userRepo.Exists(context.Background(), "outsider@other.com"))
+	})
+
+	t.Run("Refuses oversized bodies", func(t *testing.T) {
+		t.Parallel()
+
+		userHandler, userRepo := createUserHandler(t)
+		claims := helpers.NewAuthClaims(adminEmail, "", "", helpers.AdminRoleString)
+
+		body := `[{"email": "new@test.com", "name": "` + strings.Repeat("a", 17<<20) + `"}]`
+		req := httptest.NewRequest(http.MethodPost, "/users/import/", strings.NewReader(body))
+		res := makeRequestToHandlerWithClaims(claims, "/users/import/", userHandler.Import, req)
+
+		assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+		assert.False(t, userRepo.Exists(context.Background(), "new@test.com"))
+	})
+}
@@ -4,33 +4,124 @@ import (
 	"errors"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
 type AuthHelper struct {
-	secret        string
-	admins        []string
-	AllowedDomain string
+	secret              string
+	roleMembers         map[string][]string
+	groupMapping        *GroupMapping
+	revocations         *RevocationList
+	keys                *KeySet
+	issuer              string
+	secretAcceptedUntil time.Time
+	cookieSessions      bool
+	providerDomains     map[string]string
+	AllowedDomain       string
 }
 
 var ErrInvalidClaimsToken = errors.New("invalid claims token")
 
-const (
-	AdminRoleString = "admin"
-	UserRoleString  = "user"
-)
+// TokenAudience is the aud claim of the access tokens, they are meant for the fringe API.
+const TokenAudience = "fringe"
+
+// rolePrecedence is the order in which roles are looked up when an email is member of more than one.
+var rolePrecedence = []string{AdminRoleString, HelpdeskRoleString, AuditorRoleString} //nolint:gochecknoglobals
 
 func NewAuthHelper(allowedDomain string, secret string, adminsEmail []string) *AuthHelper {
 	return &AuthHelper{
-		secret:        secret,
-		admins:        adminsEmail,
-		AllowedDomain: allowedDomain,
+		secret:          secret,
+		roleMembers:     map[string][]string{AdminRoleString: adminsEmail},
+		providerDomains: map[string]string{},
+		AllowedDomain:   allowedDomain,
 	}
 }
 
+// SetRoleMembers replaces the list of emails granted the role.
+func (h *AuthHelper) SetRoleMembers(role string, emails []string) {
+	h.roleMembers[strings.ToLower(role)] = emails
+}
+
+// SetGroupMapping grants roles from identity provider groups, see GroupMapping.
+func (h *AuthHelper) SetGroupMapping(mapping *GroupMapping) {
+	h.groupMapping = mapping
+}
+
+// GroupMapping returns the mapping of identity provider groups, nil when groups are not used.
+func (h *AuthHelper) GroupMapping() *GroupMapping {
+	return h.groupMapping
+}
+
+// SetRevocationList refuses the tokens and sessions revoked in the list.
+func (h *AuthHelper) SetRevocationList(revocations *RevocationList) {
+	h.revocations = revocations
+}
+
+// RevocationList returns the list of revoked tokens and sessions, nil when tokens cannot be revoked.
+func (h *AuthHelper) RevocationList() *RevocationList {
+	return h.revocations
+}
+
+// IsRevoked returns true when the token, or its session, was revoked before it expired.
+func (h *AuthHelper) IsRevoked(claims *AuthClaims) bool {
+	return h.revocations != nil && h.revocations.IsRevoked(claims)
+}
+
+// SetKeySet signs tokens with the current key of the set instead of the secret.
+// Tokens signed with the secret before are accepted until they expire.
+func (h *AuthHelper) SetKeySet(keys *KeySet) {
+	h.keys = keys
+	h.secretAcceptedUntil = time.Now().Add(AuthClaimsDuration)
+}
+
+// KeySet returns the keys signing tokens, nil when tokens are signed with the secret.
+func (h *AuthHelper) KeySet() *KeySet {
+	return h.keys
+}
+
+// SetTokenIssuer sets the iss and aud claims of new tokens and refuses tokens from another issuer or for
+// another audience, so services verifying tokens with the published keys can tell fringe tokens apart.
+func (h *AuthHelper) SetTokenIssuer(issuer string) {
+	h.issuer = issuer
+}
+
+// SetCookieSessions hands tokens to the browser in HttpOnly cookies instead of the response body.
+func (h *AuthHelper) SetCookieSessions(enabled bool) {
+	h.cookieSessions = enabled
+}
+
+// CookieSessions returns true when tokens are handed to the browser in cookies, see SetSessionCookies.
+func (h *AuthHelper) CookieSessions() bool {
+	return h.cookieSessions
+}
+
 func (h *AuthHelper) NewJWTSignedString(claims *AuthClaims) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed := *claims
+	if len(h.issuer) > 0 {
+		signed.Issuer = h.issuer
+		signed.Audience = TokenAudience
+	}
+
+	if h.keys != nil {
+		key, found := h.keys.Current()
+		if !found {
+			log.Fatalf("!!! Error creating JWT signed string: no signing key")
+		}
+
+		token := jwt.NewWithClaims(key.signingMethod(), &signed)
+		token.Header["kid"] = key.ID
+
+		tokenString, err := token.SignedString(key.PrivateKey)
+		if err != nil {
+			log.Fatalf("!!! Error creating JWT signed string: %v", err)
+		}
+
+		return tokenString
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &signed)
 	jwtKey := []byte(h.secret)
 
 	tokenString, err := token.SignedString(jwtKey)
@@ -43,9 +134,8 @@ func (h *AuthHelper) NewJWTSignedString(claims *AuthClaims) string {
 
 func (h *AuthHelper) AuthClaimsFromSignedToken(tokenString string) (*AuthClaims, error) {
 	claims := &AuthClaims{}
-	jwtKey := []byte(h.secret)
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) { return jwtKey, nil })
+	token, err := jwt.ParseWithClaims(tokenString, claims, h.verificationKey)
 	if err != nil {
 		return nil, ErrInvalidClaimsToken
 	}
@@ -55,17 +145,92 @@ func (h *AuthHelper) AuthClaimsFromSignedToken(tokenString string) (*AuthClaims,
 		return nil, ErrInvalidClaimsToken
 	}
 
+	if len(h.issuer) > 0 && (!claims.VerifyIssuer(h.issuer, true) || !claims.VerifyAudience(TokenAudience, true)) {
+		return nil, ErrInvalidClaimsToken
+	}
+
 	return claims, nil
 }
 
+// verificationKey returns the public key named by the kid header, or the secret for tokens without kid.
+// The algorithm of the token must be the one of the key so a public key is never used as an HMAC secret.
+func (h *AuthHelper) verificationKey(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+
+	if len(keyID) == 0 {
+		if _, isHMAC := token.Method.(*jwt.SigningMethodHMAC); !isHMAC {
+			return nil, ErrInvalidClaimsToken
+		}
+
+		if h.keys != nil && time.Now().After(h.secretAcceptedUntil) {
+			return nil, ErrInvalidClaimsToken
+		}
+
+		return []byte(h.secret), nil
+	}
+
+	if h.keys == nil {
+		return nil, ErrInvalidClaimsToken
+	}
+
+	key, found := h.keys.Find(keyID)
+	if !found || token.Method.Alg() != key.Algorithm {
+		return nil, ErrInvalidClaimsToken
+	}
+
+	return key.PrivateKey.Public(), nil
+}
+
+// SetProviderDomain limits the users of the identity provider to domain instead of AllowedDomain.
+func (h *AuthHelper) SetProviderDomain(provider string, domain string) {
+	h.providerDomains[provider] = domain
+}
+
+// ProviderDomain returns the domain users of the identity provider must belong to.
+func (h *AuthHelper) ProviderDomain(provider string) string {
+	if domain, found := h.providerDomains[provider]; found {
+		return domain
+	}
+
+	return h.AllowedDomain
+}
+
+// InProviderDomain returns true when email belongs to the domain of the identity provider.
+func (h *AuthHelper) InProviderDomain(provider string, email string) bool {
+	return IsEmailInDomain(email, h.ProviderDomain(provider))
+}
+
+// InAllowedDomain returns true when email belongs to AllowedDomain or to the domain of an identity provider.
 func (h *AuthHelper) InAllowedDomain(email string) bool {
-	return IsEmailInDomain(email, h.AllowedDomain)
+	if IsEmailInDomain(email, h.AllowedDomain) {
+		return true
+	}
+
+	for _, domain := range h.providerDomains {
+		if IsEmailInDomain(email, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsAllowedHostedDomain returns true when the hd claim of the identity provider is the allowed domain.
+// Without an allowed domain there is no workspace accounts must be managed by, any hosted domain is accepted.
+func (h *AuthHelper) IsAllowedHostedDomain(hostedDomain string) bool {
+	if len(h.AllowedDomain) == 0 {
+		return true
+	}
+
+	return len(hostedDomain) > 0 && strings.EqualFold(hostedDomain, h.AllowedDomain)
 }
 
 func (h *AuthHelper) RoleForEmail(email string) string {
-	for _, adminEmail := range h.admins {
-		if strings.EqualFold(adminEmail, email) {
-			return AdminRoleString
+	for _, role := range rolePrecedence {
+		for _, memberEmail := range h.roleMembers[role] {
+			if strings.EqualFold(memberEmail, email) {
+				return role
+			}
 		}
 	}
 
@@ -103,6 +103,146 @@ func TestAuthHelper_AuthClaimsFromSignedToken(t *testing.T) {
 		assert.Equal(t, claims.Email, claimsFromToken.Email)
 		assert.Equal(t, claims.StandardClaims.ExpiresAt, claimsFromToken.StandardClaims.ExpiresAt)
 	})
+
+	t.Run("Signs and verifies tokens with the current key of the set", func(t *testing.T) {
+		t.Parallel()
+		fake := faker.New()
+
+		for _, algorithm := range []string{helpers.SigningAlgorithmES256, helpers.SigningAlgorithmEdDSA} {
+			previous, err := helpers.NewSigningKey(algorithm)
+			assert.NoError(t, err)
+
+			current, err := helpers.NewSigningKey(algorithm)
+			assert.NoError(t, err)
+
+			keys := helpers.NewKeySet()
+			keys.Replace([]helpers.SigningKey{previous})
+
+			authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+			authHelper.SetKeySet(keys)
+
+			claims := helpers.NewAuthClaims(fake.Internet().Email(), "", "", "")
+			previousToken := authHelper.NewJWTSignedString(claims)
+
+			keys.Replace([]helpers.SigningKey{previous, current})
+			currentToken := authHelper.NewJWTSignedString(claims)
+
+			for _, tokenString := range []string{previousToken, currentToken} {
+				claimsFromToken, err := authHelper.AuthClaimsFromSignedToken(tokenString)
+				assert.NoError(t, err)
+				assert.Equal(t, claims.Email, claimsFromToken.Email)
+			}
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(currentToken, &helpers.AuthClaims{})
+			assert.NoError(t, err)
+			assert.Equal(t, current.ID, parsed.Header["kid"])
+			assert.Equal(t, algorithm, parsed.Header["alg"])
+
+			// Retired keys no longer verify tokens
+			keys.Replace([]helpers.SigningKey{current})
+
+			_, err = authHelper.AuthClaimsFromSignedToken(previousToken)
+			assert.ErrorIs(t, err, helpers.ErrInvalidClaimsToken)
+		}
+	})
+
+	t.Run("Accepts tokens signed with the secret until they expire", func(t *testing.T) {
+		t.Parallel()
+		fake := faker.New()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		claims := helpers.NewAuthClaims(fake.Internet().Email(), "", "", "")
+		secretToken := authHelper.NewJWTSignedString(claims)
+
+		key, err := helpers.NewSigningKey(helpers.SigningAlgorithmES256)
+		assert.NoError(t, err)
+
+		keys := helpers.NewKeySet()
+		keys.Replace([]helpers.SigningKey{key})
+		authHelper.SetKeySet(keys)
+
+		_, err = authHelper.AuthClaimsFromSignedToken(secretToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Sets and verifies the issuer and audience", func(t *testing.T) {
+		t.Parallel()
+		fake := faker.New()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		authHelper.SetTokenIssuer("https://fringe.test.com")
+
+		claims := helpers.NewAuthClaims(fake.Internet().Email(), "", "", "")
+		tokenString := authHelper.NewJWTSignedString(claims)
+
+		parsed := &helpers.AuthClaims{}
+		_, _, err := new(jwt.Parser).ParseUnverified(tokenString, parsed)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://fringe.test.com", parsed.Issuer)
+		assert.Equal(t, helpers.TokenAudience, parsed.Audience)
+
+		_, err = authHelper.AuthClaimsFromSignedToken(tokenString)
+		assert.NoError(t, err)
+
+		otherIssuer := helpers.NewAuthHelper("test.com", "secret", []string{})
+		otherIssuer.SetTokenIssuer("https://other.test.com")
+
+		_, err = otherIssuer.AuthClaimsFromSignedToken(tokenString)
+		assert.ErrorIs(t, err, helpers.ErrInvalidClaimsToken)
+
+		claims.Issuer = "https://fringe.test.com"
+		claims.Audience = "another-service"
+		otherAudience, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		assert.NoError(t, err)
+
+		_, err = authHelper.AuthClaimsFromSignedToken(otherAudience)
+		assert.ErrorIs(t, err, helpers.ErrInvalidClaimsToken)
+	})
+
+	t.Run("Refuses tokens whose algorithm is not the one of their key", func(t *testing.T) {
+		t.Parallel()
+		fake := faker.New()
+
+		key, err := helpers.NewSigningKey(helpers.SigningAlgorithmEdDSA)
+		assert.NoError(t, err)
+
+		keys := helpers.NewKeySet()
+		keys.Replace([]helpers.SigningKey{key})
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		authHelper.SetKeySet(keys)
+
+		claims := helpers.NewAuthClaims(fake.Internet().Email(), "", "", "")
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = key.ID
+
+		tokenString, err := token.SignedString([]byte("secret"))
+		assert.NoError(t, err)
+
+		_, err = authHelper.AuthClaimsFromSignedToken(tokenString)
+		assert.ErrorIs(t, err, helpers.ErrInvalidClaimsToken)
+	})
+}
+
+func TestAuthHelper_IsAllowedHostedDomain(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Only the allowed domain is accepted", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		assert.True(t, authHelper.IsAllowedHostedDomain("Test.com"))
+		assert.False(t, authHelper.IsAllowedHostedDomain("other.com"))
+		assert.False(t, authHelper.IsAllowedHostedDomain(""))
+	})
+
+	t.Run("Any hosted domain is accepted without an allowed domain", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("", "secret", []string{})
+		assert.True(t, authHelper.IsAllowedHostedDomain("test.com"))
+		assert.True(t, authHelper.IsAllowedHostedDomain(""))
+	})
 }
 
 func TestAuthHelper_InAllowedDomain(t *testing.T) {
@@ -132,6 +272,42 @@ func TestAuthHelper_InAllowedDomain(t *testing.T) {
 	})
 }
 
+func TestAuthHelper_InProviderDomain(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Providers without a domain use the allowed domain", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		authHelper.SetProviderDomain("partner", "partner.com")
+
+		assert.True(t, authHelper.InProviderDomain("keycloak", "email@test.com"))
+		assert.False(t, authHelper.InProviderDomain("keycloak", "email@partner.com"))
+		assert.Equal(t, "test.com", authHelper.ProviderDomain("keycloak"))
+	})
+
+	t.Run("Providers with a domain only accept it", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		authHelper.SetProviderDomain("partner", "partner.com")
+
+		assert.True(t, authHelper.InProviderDomain("partner", "email@partner.com"))
+		assert.False(t, authHelper.InProviderDomain("partner", "email@test.com"))
+	})
+
+	t.Run("Provider domains are allowed", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		authHelper.SetProviderDomain("partner", "partner.com")
+
+		assert.True(t, authHelper.InAllowedDomain("email@test.com"))
+		assert.True(t, authHelper.InAllowedDomain("email@partner.com"))
+		assert.False(t, authHelper.InAllowedDomain("email@other.com"))
+	})
+}
+
 func TestAuthHelper_RoleForEmail(t *testing.T) {
 	t.Parallel()
 
@@ -144,6 +320,26 @@ func TestAuthHelper_RoleForEmail(t *testing.T) {
 		assert.Equal(t, authHelper.RoleForEmail("admin@TEST.com"), helpers.AdminRoleString)
 	})
 
+	t.Run("role for email in a role member list", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{"admin@test.com"})
+		authHelper.SetRoleMembers(helpers.HelpdeskRoleString, []string{"helpdesk@test.com"})
+		authHelper.SetRoleMembers(helpers.AuditorRoleString, []string{"auditor@test.com"})
+
+		assert.Equal(t, helpers.HelpdeskRoleString, authHelper.RoleForEmail("helpdesk@test.com"))
+		assert.Equal(t, helpers.AuditorRoleString, authHelper.RoleForEmail("AUDITOR@test.com"))
+	})
+
+	t.Run("admin role has precedence over other roles", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{"admin@test.com"})
+		authHelper.SetRoleMembers(helpers.AuditorRoleString, []string{"admin@test.com"})
+
+		assert.Equal(t, helpers.AdminRoleString, authHelper.RoleForEmail("admin@test.com"))
+	})
+
 	t.Run("user for email not in admin list", func(t *testing.T) {
 		t.Parallel()
 
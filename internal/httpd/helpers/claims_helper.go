@@ -2,41 +2,101 @@ package helpers
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
-const AuthClaimsDurationInMinutes = 60
+const (
+	AuthClaimsDurationInMinutes = 60
+	// AuthClaimsDuration is how long access tokens are valid, and how long a revocation must be remembered.
+	AuthClaimsDuration = AuthClaimsDurationInMinutes * time.Minute
+
+	tokenIDByteLength = 16
+)
 
 type userCtxKeyType string
 
 const userCtxKey userCtxKeyType = "auth_claims"
 
 type AuthClaims struct {
-	Email       string `json:"email"`
-	Name        string `json:"name"`
-	Picture     string `json:"picture"`
-	Permissions string `json:"permissions"`
+	Email       string       `json:"email"`
+	Name        string       `json:"name"`
+	Picture     string       `json:"picture"`
+	Role        string       `json:"role"`
+	Permissions []Permission `json:"permissions"`
+	// SessionID is the server side session refreshing the token, empty for tokens issued without one.
+	SessionID string `json:"sid,omitempty"`
+	// APITokenID is the API token the request was sent with, such claims are never signed.
+	APITokenID string `json:"-"`
 	jwt.StandardClaims
 }
 
-func NewAuthClaims(email string, name string, picture string, permissions string) *AuthClaims {
-	expirationTime := time.Now().Add(AuthClaimsDurationInMinutes * time.Minute)
+// newTokenID returns a random jti so each token can be revoked on its own.
+func newTokenID() string {
+	id := make([]byte, tokenIDByteLength)
+	if _, err := rand.Read(id); err != nil {
+		log.Panicf("!!! Error generating token id: %v", err)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+func NewAuthClaims(email string, name string, picture string, role string) *AuthClaims {
+	now := time.Now()
+	expirationTime := now.Add(AuthClaimsDuration)
 	// Create the JWT claims, which includes the username and expiry time
 	return &AuthClaims{
 		Email:       email,
 		Name:        name,
 		Picture:     picture,
-		Permissions: permissions,
+		Role:        role,
+		Permissions: PermissionsForRole(role),
 		StandardClaims: jwt.StandardClaims{
+			Id:       newTokenID(),
+			IssuedAt: now.Unix(),
 			// In JWT, the expiry time is expressed as unix milliseconds
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 }
 
+// NewAPITokenClaims returns the claims of a request sent with an API token, its scopes are the permissions.
+// Scopes of users are limited to the permissions of their current role so a demoted user loses them, service
+// accounts have no role and keep the scopes an admin granted.
+func NewAPITokenClaims(email string, name string, role string, scopes []string, tokenID string, expiresAt int64) *AuthClaims {
+	granted := map[Permission]bool{}
+	for _, permission := range PermissionsForRole(role) {
+		granted[permission] = true
+	}
+
+	permissions := make([]Permission, 0, len(scopes))
+
+	for _, scope := range scopes {
+		if role != ServiceAccountRoleString && !granted[Permission(scope)] {
+			continue
+		}
+
+		permissions = append(permissions, Permission(scope))
+	}
+
+	return &AuthClaims{
+		Email:       email,
+		Name:        name,
+		Role:        role,
+		Permissions: permissions,
+		APITokenID:  tokenID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			ExpiresAt: expiresAt,
+		},
+	}
+}
+
 func AuthClaimsFromContext(ctx context.Context) (*AuthClaims, bool) {
 	claims, ok := ctx.Value(userCtxKey).(*AuthClaims)
 	if !ok {
@@ -50,13 +110,27 @@ func (c *AuthClaims) ContextWithClaims(ctx context.Context) context.Context {
 	return context.WithValue(ctx, userCtxKey, c)
 }
 
+// Refresh extends the claims as a new token, revoking the previous token leaves the refreshed one valid.
 func (c *AuthClaims) Refresh() *AuthClaims {
-	expirationTime := time.Now().Add(AuthClaimsDurationInMinutes * time.Minute)
-	c.StandardClaims.ExpiresAt = expirationTime.Unix()
+	now := time.Now()
+	c.StandardClaims.Id = newTokenID()
+	c.StandardClaims.IssuedAt = now.Unix()
+	c.StandardClaims.ExpiresAt = now.Add(AuthClaimsDuration).Unix()
 
 	return c
 }
 
 func (c *AuthClaims) IsAdmin() bool {
-	return strings.EqualFold(c.Permissions, AdminRoleString)
+	return strings.EqualFold(c.Role, AdminRoleString)
+}
+
+// Can returns true if the claims were granted the permission.
+func (c *AuthClaims) Can(permission Permission) bool {
+	for _, granted := range c.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
 }
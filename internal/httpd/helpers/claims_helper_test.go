@@ -21,6 +21,50 @@ func TestNewAuthClaims(t *testing.T) {
 
 		assert.Greater(t, claims.StandardClaims.ExpiresAt, time.Now().Unix())
 	})
+
+	t.Run("new claims have their own token id", func(t *testing.T) {
+		t.Parallel()
+		fake := faker.New()
+
+		email := fake.Internet().Email()
+		first := helpers.NewAuthClaims(email, "", "", helpers.UserRoleString)
+		second := helpers.NewAuthClaims(email, "", "", helpers.UserRoleString)
+
+		assert.NotEmpty(t, first.Id)
+		assert.NotEqual(t, first.Id, second.Id)
+		assert.NotZero(t, first.IssuedAt)
+	})
+}
+
+func TestNewAPITokenClaims(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Grants the scopes as permissions", func(t *testing.T) {
+		t.Parallel()
+
+		expiresAt := time.Now().Add(time.Hour).Unix()
+		claims := helpers.NewAPITokenClaims("provisioning", "script", helpers.ServiceAccountRoleString, []string{"users:read"}, "token-id", expiresAt)
+
+		assert.True(t, claims.Can(helpers.PermissionUsersRead))
+		assert.False(t, claims.Can(helpers.PermissionUsersCreate))
+		assert.False(t, claims.IsAdmin())
+		assert.Equal(t, "token-id", claims.APITokenID)
+		assert.Equal(t, "token-id", claims.Id)
+		assert.Equal(t, expiresAt, claims.ExpiresAt)
+	})
+
+	t.Run("Limits the scopes of users to their current role", func(t *testing.T) {
+		t.Parallel()
+
+		scopes := []string{string(helpers.PermissionUsersRead), string(helpers.PermissionUsersDelete)}
+
+		admin := helpers.NewAPITokenClaims("admin@test.com", "script", helpers.AdminRoleString, scopes, "token-id", 0)
+		assert.True(t, admin.Can(helpers.PermissionUsersRead))
+		assert.True(t, admin.Can(helpers.PermissionUsersDelete))
+
+		demoted := helpers.NewAPITokenClaims("admin@test.com", "script", helpers.UserRoleString, scopes, "token-id", 0)
+		assert.Empty(t, demoted.Permissions)
+	})
 }
 
 func TestAuthClaimsFromContext(t *testing.T) {
@@ -78,9 +122,11 @@ func TestAuthClaims_Refresh(t *testing.T) {
 		claims := helpers.NewAuthClaims(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), helpers.UserRoleString)
 		claimsOriginalExpiry := time.Now().Add(-1 * time.Minute).Unix()
 		claims.StandardClaims.ExpiresAt = claimsOriginalExpiry
+		claimsOriginalID := claims.Id
 		returnedClaims := claims.Refresh()
 
 		assert.NotEqual(t, claimsOriginalExpiry, claims.StandardClaims.ExpiresAt)
+		assert.NotEqual(t, claimsOriginalID, claims.Id)
 		assert.Greater(t, claims.StandardClaims.ExpiresAt, time.Now().Unix())
 		assert.Equal(t, claims, returnedClaims)
 	})
@@ -125,3 +171,34 @@ func TestAuthClaims_IsAdmin(t *testing.T) {
 		assert.False(t, claims.IsAdmin())
 	})
 }
+
+func TestAuthClaims_Can(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Return true for permissions granted by the role", func(t *testing.T) {
+		t.Parallel()
+		fake := faker.New()
+
+		claims := helpers.NewAuthClaims(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), helpers.HelpdeskRoleString)
+
+		assert.True(t, claims.Can(helpers.PermissionUsersRenew))
+	})
+
+	t.Run("Return false for permissions not granted by the role", func(t *testing.T) {
+		t.Parallel()
+		fake := faker.New()
+
+		claims := helpers.NewAuthClaims(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), helpers.HelpdeskRoleString)
+
+		assert.False(t, claims.Can(helpers.PermissionUsersDelete))
+	})
+
+	t.Run("Return false for users without a role", func(t *testing.T) {
+		t.Parallel()
+		fake := faker.New()
+
+		claims := helpers.NewAuthClaims(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), helpers.UserRoleString)
+
+		assert.False(t, claims.Can(helpers.PermissionUsersRead))
+	})
+}
@@ -0,0 +1,100 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// GroupRule grants members of Group the fringe Role and the RADIUS group RadiusGroup, either may be empty.
+type GroupRule struct {
+	Group       string
+	Role        string
+	RadiusGroup string
+}
+
+// GroupAccess is what the groups of a user are granted. Allowed is false when none of them is mapped.
+type GroupAccess struct {
+	Allowed     bool
+	Role        string
+	RadiusGroup string
+}
+
+// GroupMapping maps identity provider groups, compared case insensitively, to fringe roles and RADIUS groups.
+// The RADIUS group is stored in the user attribute RadiusAttribute so reply attributes can send it.
+type GroupMapping struct {
+	RadiusAttribute string
+	rules           []GroupRule
+}
+
+var ErrInvalidGroupRule = errors.New("invalid group mapping")
+
+func NewGroupMapping(radiusAttribute string, rules []GroupRule) (*GroupMapping, error) {
+	mapping := &GroupMapping{RadiusAttribute: strings.ToLower(strings.TrimSpace(radiusAttribute)), rules: make([]GroupRule, 0, len(rules))}
+
+	for _, rule := range rules {
+		rule.Group = strings.ToLower(strings.TrimSpace(rule.Group))
+		rule.Role = strings.ToLower(strings.TrimSpace(rule.Role))
+
+		if len(rule.Group) == 0 {
+			return nil, fmt.Errorf("%w: group is required", ErrInvalidGroupRule)
+		}
+
+		if len(rule.Role) > 0 && !IsKnownRole(rule.Role) {
+			return nil, fmt.Errorf("%w: unknown role '%s' for group %s", ErrInvalidGroupRule, rule.Role, rule.Group)
+		}
+
+		if len(rule.RadiusGroup) > 0 && len(mapping.RadiusAttribute) == 0 {
+			return nil, fmt.Errorf("%w: radius group of %s needs a radius attribute", ErrInvalidGroupRule, rule.Group)
+		}
+
+		mapping.rules = append(mapping.rules, rule)
+	}
+
+	return mapping, nil
+}
+
+// Enabled returns true when groups are mapped, only their members may then log in.
+func (m *GroupMapping) Enabled() bool {
+	return m != nil && len(m.rules) > 0
+}
+
+// Resolve returns the access granted to members of groups. The role with the most permissions wins
+// and the RADIUS group comes from the first matching rule, rules are in configuration order.
+func (m *GroupMapping) Resolve(groups []string) GroupAccess {
+	access := GroupAccess{Role: UserRoleString}
+	if m == nil {
+		return access
+	}
+
+	member := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		member[strings.ToLower(strings.TrimSpace(group))] = true
+	}
+
+	for _, rule := range m.rules {
+		if !member[rule.Group] {
+			continue
+		}
+
+		access.Allowed = true
+		access.Role = HigherRole(access.Role, rule.Role)
+
+		if len(access.RadiusGroup) == 0 {
+			access.RadiusGroup = rule.RadiusGroup
+		}
+	}
+
+	return access
+}
+
+// HigherRole returns the role of first and second granting the most permissions.
+func HigherRole(first string, second string) string {
+	for _, role := range rolePrecedence {
+		if strings.EqualFold(first, role) || strings.EqualFold(second, role) {
+			return role
+		}
+	}
+
+	return UserRoleString
+}
@@ -0,0 +1,74 @@
+package helpers_test
+
+import (
+	"testing"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewGroupMapping(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses invalid rules", func(t *testing.T) {
+		t.Parallel()
+
+		for _, rule := range []helpers.GroupRule{
+			{Group: " ", Role: helpers.AdminRoleString},
+			{Group: "staff@test.com", Role: "superuser"},
+		} {
+			_, err := helpers.NewGroupMapping("radius-group", []helpers.GroupRule{rule})
+			assert.ErrorIs(t, err, helpers.ErrInvalidGroupRule)
+		}
+
+		_, err := helpers.NewGroupMapping("", []helpers.GroupRule{{Group: "staff@test.com", RadiusGroup: "staff"}})
+		assert.ErrorIs(t, err, helpers.ErrInvalidGroupRule)
+	})
+
+	t.Run("Is disabled without rules", func(t *testing.T) {
+		t.Parallel()
+
+		mapping, err := helpers.NewGroupMapping("radius-group", nil)
+		assert.NoError(t, err)
+		assert.False(t, mapping.Enabled())
+		assert.False(t, (*helpers.GroupMapping)(nil).Enabled())
+	})
+}
+
+func TestGroupMapping_Resolve(t *testing.T) {
+	t.Parallel()
+
+	mapping, err := helpers.NewGroupMapping("Radius-Group", []helpers.GroupRule{
+		{Group: "staff@test.com", RadiusGroup: "staff"},
+		{Group: "Auditors@test.com", Role: helpers.AuditorRoleString, RadiusGroup: "auditors"},
+		{Group: "admins@test.com", Role: helpers.AdminRoleString},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "radius-group", mapping.RadiusAttribute)
+
+	t.Run("Grants the highest role and the first radius group", func(t *testing.T) {
+		t.Parallel()
+
+		access := mapping.Resolve([]string{"ADMINS@test.com", "auditors@test.com", "staff@test.com"})
+		assert.Equal(t, helpers.GroupAccess{Allowed: true, Role: helpers.AdminRoleString, RadiusGroup: "staff"}, access)
+	})
+
+	t.Run("Refuses users outside mapped groups", func(t *testing.T) {
+		t.Parallel()
+
+		access := mapping.Resolve([]string{"sales@test.com"})
+		assert.Equal(t, helpers.GroupAccess{Allowed: false, Role: helpers.UserRoleString}, access)
+	})
+}
+
+func TestHigherRole(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns the role with the most permissions", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, helpers.AdminRoleString, helpers.HigherRole(helpers.AuditorRoleString, helpers.AdminRoleString))
+		assert.Equal(t, helpers.HelpdeskRoleString, helpers.HigherRole(helpers.HelpdeskRoleString, ""))
+		assert.Equal(t, helpers.UserRoleString, helpers.HigherRole(helpers.UserRoleString, "unknown"))
+	})
+}
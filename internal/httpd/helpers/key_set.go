@@ -0,0 +1,220 @@
+package helpers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// SigningAlgorithmHS256 signs tokens with the JWT secret, they cannot be verified without it.
+	SigningAlgorithmHS256 = "HS256"
+	SigningAlgorithmES256 = "ES256"
+	SigningAlgorithmEdDSA = "EdDSA"
+
+	keyIDByteLength   = 8
+	p256CoordinateLen = 32
+)
+
+// JWKSMaxAge is how long verifiers may cache the published keys.
+// New keys are published this long before they sign tokens so verifiers know them once they are used.
+const JWKSMaxAge = 15 * time.Minute
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+// SigningKey signs access tokens from ActivatesAt, its ID is sent in the kid header so verifiers can pick the
+// public key.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	ActivatesAt time.Time
+}
+
+// KeySet holds the keys verifying access tokens, the newest active key also signs new tokens.
+type KeySet struct {
+	lock sync.RWMutex
+	keys []SigningKey
+}
+
+// JSONWebKey is a public key as published in a JWKS document.
+type JSONWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{keys: []SigningKey{}}
+}
+
+// IsAsymmetricAlgorithm returns true for the algorithms signing with a SigningKey.
+func IsAsymmetricAlgorithm(algorithm string) bool {
+	return algorithm == SigningAlgorithmES256 || algorithm == SigningAlgorithmEdDSA
+}
+
+// NewSigningKey generates a key for the algorithm with a random ID.
+func NewSigningKey(algorithm string) (SigningKey, error) {
+	var privateKey crypto.Signer
+
+	var err error
+
+	switch algorithm {
+	case SigningAlgorithmES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case SigningAlgorithmEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return SigningKey{}, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("could not generate %s key: %w", algorithm, err)
+	}
+
+	id := make([]byte, keyIDByteLength)
+	if _, err := rand.Read(id); err != nil {
+		return SigningKey{}, fmt.Errorf("could not generate key id: %w", err)
+	}
+
+	return SigningKey{ID: hex.EncodeToString(id), Algorithm: algorithm, PrivateKey: privateKey}, nil
+}
+
+// ParseSigningKey returns the key from its PKCS #8 DER encoding, the key type must match the algorithm.
+func ParseSigningKey(id string, algorithm string, der []byte) (SigningKey, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("could not parse signing key %s: %w", id, err)
+	}
+
+	key := SigningKey{ID: id, Algorithm: algorithm}
+
+	switch privateKey := parsed.(type) {
+	case *ecdsa.PrivateKey:
+		if algorithm == SigningAlgorithmES256 && privateKey.Curve == elliptic.P256() {
+			key.PrivateKey = privateKey
+		}
+	case ed25519.PrivateKey:
+		if algorithm == SigningAlgorithmEdDSA {
+			key.PrivateKey = privateKey
+		}
+	}
+
+	if key.PrivateKey == nil {
+		return SigningKey{}, fmt.Errorf("%w: key %s is not a %s key", ErrUnsupportedAlgorithm, id, algorithm)
+	}
+
+	return key, nil
+}
+
+// MarshalPrivateKey returns the PKCS #8 DER encoding of the private key.
+func (k *SigningKey) MarshalPrivateKey() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode signing key %s: %w", k.ID, err)
+	}
+
+	return der, nil
+}
+
+func (k *SigningKey) signingMethod() jwt.SigningMethod {
+	if k.Algorithm == SigningAlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+
+	return jwt.SigningMethodES256
+}
+
+// PublicJSONWebKey returns the public key in JWK format.
+func (k *SigningKey) PublicJSONWebKey() JSONWebKey {
+	webKey := JSONWebKey{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+
+	switch publicKey := k.PrivateKey.Public().(type) {
+	case *ecdsa.PublicKey:
+		webKey.KeyType = "EC"
+		webKey.Curve = "P-256"
+		webKey.X = base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, p256CoordinateLen)))
+		webKey.Y = base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, p256CoordinateLen)))
+	case ed25519.PublicKey:
+		webKey.KeyType = "OKP"
+		webKey.Curve = "Ed25519"
+		webKey.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	}
+
+	return webKey
+}
+
+// Replace swaps the keys of the set, keys are sorted oldest first and the last active one signs new tokens.
+func (s *KeySet) Replace(keys []SigningKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.keys = append([]SigningKey{}, keys...)
+}
+
+// Current returns the key signing new tokens, false when the set is empty.
+// Keys not yet active are skipped, the oldest key signs when none is active.
+func (s *KeySet) Current() (SigningKey, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.keys) == 0 {
+		return SigningKey{}, false
+	}
+
+	now := time.Now()
+
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if !s.keys[i].ActivatesAt.After(now) {
+			return s.keys[i], true
+		}
+	}
+
+	return s.keys[0], true
+}
+
+// Find returns the key with the ID, false when it is unknown or retired.
+func (s *KeySet) Find(id string) (SigningKey, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, key := range s.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+
+	return SigningKey{}, false
+}
+
+// JWKS returns the public keys of the set, to be published for other services verifying tokens.
+func (s *KeySet) JWKS() JSONWebKeySet {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	document := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(s.keys))}
+	for i := range s.keys {
+		document.Keys = append(document.Keys, s.keys[i].PublicJSONWebKey())
+	}
+
+	return document
+}
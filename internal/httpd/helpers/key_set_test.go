@@ -0,0 +1,119 @@
+package helpers_test
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSigningKey(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Survives a round trip through PKCS #8", func(t *testing.T) {
+		t.Parallel()
+
+		for _, algorithm := range []string{helpers.SigningAlgorithmES256, helpers.SigningAlgorithmEdDSA} {
+			key, err := helpers.NewSigningKey(algorithm)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, key.ID)
+
+			der, err := key.MarshalPrivateKey()
+			assert.NoError(t, err)
+
+			parsed, err := helpers.ParseSigningKey(key.ID, algorithm, der)
+			assert.NoError(t, err)
+			assert.Equal(t, key.PublicJSONWebKey(), parsed.PublicJSONWebKey())
+		}
+	})
+
+	t.Run("Refuses unsupported algorithms and mismatched keys", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := helpers.NewSigningKey(helpers.SigningAlgorithmHS256)
+		assert.ErrorIs(t, err, helpers.ErrUnsupportedAlgorithm)
+
+		key, err := helpers.NewSigningKey(helpers.SigningAlgorithmEdDSA)
+		assert.NoError(t, err)
+
+		der, err := key.MarshalPrivateKey()
+		assert.NoError(t, err)
+
+		_, err = helpers.ParseSigningKey(key.ID, helpers.SigningAlgorithmES256, der)
+		assert.ErrorIs(t, err, helpers.ErrUnsupportedAlgorithm)
+	})
+}
+
+func TestKeySet_JWKS(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Publishes the public keys of the set", func(t *testing.T) {
+		t.Parallel()
+
+		ecKey, err := helpers.NewSigningKey(helpers.SigningAlgorithmES256)
+		assert.NoError(t, err)
+
+		edKey, err := helpers.NewSigningKey(helpers.SigningAlgorithmEdDSA)
+		assert.NoError(t, err)
+
+		keys := helpers.NewKeySet()
+		assert.Empty(t, keys.JWKS().Keys)
+
+		keys.Replace([]helpers.SigningKey{ecKey, edKey})
+
+		current, found := keys.Current()
+		assert.True(t, found)
+		assert.Equal(t, edKey.ID, current.ID)
+
+		document := keys.JWKS()
+		assert.Len(t, document.Keys, 2)
+
+		ec := document.Keys[0]
+		assert.Equal(t, helpers.JSONWebKey{KeyID: ecKey.ID, KeyType: "EC", Use: "sig", Algorithm: "ES256", Curve: "P-256", X: ec.X, Y: ec.Y}, ec)
+
+		x, err := base64.RawURLEncoding.DecodeString(ec.X)
+		assert.NoError(t, err)
+		assert.Len(t, x, 32)
+
+		ed := document.Keys[1]
+		assert.Equal(t, "OKP", ed.KeyType)
+		assert.Equal(t, "Ed25519", ed.Curve)
+		assert.Empty(t, ed.Y)
+
+		_, found = keys.Find("unknown")
+		assert.False(t, found)
+	})
+}
+
+func TestKeySet_Current(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Signs with the newest active key", func(t *testing.T) {
+		t.Parallel()
+
+		previous, err := helpers.NewSigningKey(helpers.SigningAlgorithmES256)
+		assert.NoError(t, err)
+
+		pending, err := helpers.NewSigningKey(helpers.SigningAlgorithmES256)
+		assert.NoError(t, err)
+
+		pending.ActivatesAt = time.Now().Add(helpers.JWKSMaxAge)
+
+		keys := helpers.NewKeySet()
+		keys.Replace([]helpers.SigningKey{previous, pending})
+
+		current, found := keys.Current()
+		assert.True(t, found)
+		assert.Equal(t, previous.ID, current.ID)
+		assert.Len(t, keys.JWKS().Keys, 2)
+
+		// The oldest key signs when none is active
+		keys.Replace([]helpers.SigningKey{pending})
+
+		current, found = keys.Current()
+		assert.True(t, found)
+		assert.Equal(t, pending.ID, current.ID)
+	})
+}
@@ -0,0 +1,104 @@
+package helpers
+
+import "strings"
+
+// Permission is a single capability checked by handlers before performing an action.
+type Permission string
+
+const (
+	PermissionUsersRead       Permission = "users:read"
+	PermissionUsersCreate     Permission = "users:create"
+	PermissionUsersRenew      Permission = "users:renew"
+	PermissionUsersDelete     Permission = "users:delete"
+	PermissionUsersExpiry     Permission = "users:expiry"
+	PermissionUsersAttributes Permission = "users:attributes"
+	PermissionUsersExport     Permission = "users:export"
+	PermissionUsersImport     Permission = "users:import"
+	PermissionUsersSessions   Permission = "users:sessions"
+	PermissionUsersEnable     Permission = "users:enable"
+	// PermissionUsersCredentials manages the per-device credentials of other users, users always manage their own.
+	PermissionUsersCredentials Permission = "users:credentials"
+	PermissionAuditRead        Permission = "audit:read"
+	PermissionNASManage        Permission = "nas:manage"
+	PermissionSnapshot         Permission = "database:snapshot"
+	PermissionDirectorySync    Permission = "directory:sync"
+	PermissionServiceAccounts  Permission = "service-accounts:manage"
+)
+
+const (
+	AdminRoleString    = "admin"
+	HelpdeskRoleString = "helpdesk"
+	AuditorRoleString  = "auditor"
+	UserRoleString     = "user"
+	// ServiceAccountRoleString is the role of requests made with the API tokens of service accounts,
+	// it cannot be assigned to users and its permissions are the scopes of the token.
+	ServiceAccountRoleString = "service-account"
+)
+
+// rolePermissions bundles permissions into the roles assignable to users.
+// Regular users have no permissions, they can only act on their own account.
+var rolePermissions = map[string][]Permission{ //nolint:gochecknoglobals
+	AdminRoleString: {
+		PermissionUsersRead,
+		PermissionUsersCreate,
+		PermissionUsersRenew,
+		PermissionUsersDelete,
+		PermissionUsersExpiry,
+		PermissionUsersAttributes,
+		PermissionUsersExport,
+		PermissionUsersImport,
+		PermissionUsersSessions,
+		PermissionUsersEnable,
+		PermissionUsersCredentials,
+		PermissionAuditRead,
+		PermissionNASManage,
+		PermissionSnapshot,
+		PermissionDirectorySync,
+		PermissionServiceAccounts,
+	},
+	HelpdeskRoleString: {
+		PermissionUsersRead,
+		PermissionUsersCreate,
+		PermissionUsersRenew,
+		PermissionUsersExpiry,
+		PermissionUsersCredentials,
+	},
+	AuditorRoleString: {
+		PermissionUsersRead,
+		PermissionAuditRead,
+	},
+	UserRoleString: {},
+}
+
+// PermissionsForRole returns the permissions granted to the role, unknown roles have none.
+func PermissionsForRole(role string) []Permission {
+	permissions, found := rolePermissions[strings.ToLower(role)]
+	if !found {
+		return []Permission{}
+	}
+
+	granted := make([]Permission, len(permissions))
+	copy(granted, permissions)
+
+	return granted
+}
+
+// IsKnownRole returns true if the role is one of the roles defined by fringe.
+func IsKnownRole(role string) bool {
+	_, found := rolePermissions[strings.ToLower(role)]
+
+	return found
+}
+
+// IsKnownPermission returns true if the permission is granted by one of the roles, it can then be an API token scope.
+func IsKnownPermission(permission Permission) bool {
+	for _, permissions := range rolePermissions {
+		for _, granted := range permissions {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,113 @@
+package helpers_test
+
+import (
+	"testing"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermissionsForRole(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Admin has every permission", func(t *testing.T) {
+		t.Parallel()
+
+		permissions := helpers.PermissionsForRole(helpers.AdminRoleString)
+
+		assert.Contains(t, permissions, helpers.PermissionUsersRead)
+		assert.Contains(t, permissions, helpers.PermissionUsersCreate)
+		assert.Contains(t, permissions, helpers.PermissionUsersRenew)
+		assert.Contains(t, permissions, helpers.PermissionUsersDelete)
+		assert.Contains(t, permissions, helpers.PermissionUsersExpiry)
+		assert.Contains(t, permissions, helpers.PermissionUsersAttributes)
+		assert.Contains(t, permissions, helpers.PermissionAuditRead)
+		assert.Contains(t, permissions, helpers.PermissionNASManage)
+		assert.Contains(t, permissions, helpers.PermissionSnapshot)
+		assert.Contains(t, permissions, helpers.PermissionDirectorySync)
+		assert.Contains(t, permissions, helpers.PermissionUsersSessions)
+		assert.Contains(t, permissions, helpers.PermissionUsersEnable)
+		assert.Contains(t, permissions, helpers.PermissionUsersCredentials)
+		assert.Contains(t, permissions, helpers.PermissionServiceAccounts)
+	})
+
+	t.Run("Helpdesk can renew but not delete", func(t *testing.T) {
+		t.Parallel()
+
+		permissions := helpers.PermissionsForRole(helpers.HelpdeskRoleString)
+
+		assert.Contains(t, permissions, helpers.PermissionUsersRenew)
+		assert.Contains(t, permissions, helpers.PermissionUsersExpiry)
+		assert.Contains(t, permissions, helpers.PermissionUsersCredentials)
+		assert.NotContains(t, permissions, helpers.PermissionUsersDelete)
+		assert.NotContains(t, permissions, helpers.PermissionUsersAttributes)
+	})
+
+	t.Run("Auditor is read only", func(t *testing.T) {
+		t.Parallel()
+
+		permissions := helpers.PermissionsForRole(helpers.AuditorRoleString)
+
+		assert.ElementsMatch(t, []helpers.Permission{helpers.PermissionUsersRead, helpers.PermissionAuditRead}, permissions)
+	})
+
+	t.Run("Role lookup ignores case", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, helpers.PermissionsForRole(helpers.AdminRoleString), helpers.PermissionsForRole("ADMIN"))
+	})
+
+	t.Run("Users and unknown roles have no permissions", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Empty(t, helpers.PermissionsForRole(helpers.UserRoleString))
+		assert.Empty(t, helpers.PermissionsForRole("unknown"))
+		assert.Empty(t, helpers.PermissionsForRole(""))
+	})
+
+	t.Run("Returned permissions can be modified without altering the role", func(t *testing.T) {
+		t.Parallel()
+
+		permissions := helpers.PermissionsForRole(helpers.AuditorRoleString)
+		permissions[0] = helpers.PermissionUsersDelete
+
+		assert.NotContains(t, helpers.PermissionsForRole(helpers.AuditorRoleString), helpers.PermissionUsersDelete)
+	})
+}
+
+func TestIsKnownRole(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns true for defined roles", func(t *testing.T) {
+		t.Parallel()
+
+		for _, role := range []string{helpers.AdminRoleString, helpers.HelpdeskRoleString, helpers.AuditorRoleString, helpers.UserRoleString} {
+			assert.True(t, helpers.IsKnownRole(role))
+		}
+	})
+
+	t.Run("Returns false for undefined roles", func(t *testing.T) {
+		t.Parallel()
+
+		assert.False(t, helpers.IsKnownRole("superuser"))
+	})
+}
+
+func TestIsKnownPermission(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns true for permissions granted by a role", func(t *testing.T) {
+		t.Parallel()
+
+		for _, permission := range helpers.PermissionsForRole(helpers.AdminRoleString) {
+			assert.True(t, helpers.IsKnownPermission(permission))
+		}
+	})
+
+	t.Run("Returns false for undefined permissions", func(t *testing.T) {
+		t.Parallel()
+
+		assert.False(t, helpers.IsKnownPermission("users:everything"))
+		assert.False(t, helpers.IsKnownPermission(""))
+	})
+}
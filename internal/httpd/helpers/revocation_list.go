@@ -0,0 +1,49 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationList holds the sessions and tokens revoked before their access tokens expire.
+// IDs are forgotten once every token they could match has expired.
+type RevocationList struct {
+	lock    sync.RWMutex
+	revoked map[string]int64
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{revoked: map[string]int64{}}
+}
+
+// Revoke refuses the session or token ID until the time tokens issued before now expire.
+func (l *RevocationList) Revoke(id string, now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for revokedID, until := range l.revoked {
+		if until < now.Unix() {
+			delete(l.revoked, revokedID)
+		}
+	}
+
+	l.revoked[id] = now.Add(AuthClaimsDuration).Unix()
+}
+
+// IsRevoked returns true when the token or its session was revoked.
+func (l *RevocationList) IsRevoked(claims *AuthClaims) bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	for _, id := range []string{claims.Id, claims.SessionID} {
+		if len(id) == 0 {
+			continue
+		}
+
+		if _, found := l.revoked[id]; found {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,44 @@
+package helpers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevocationList_IsRevoked(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses revoked tokens and tokens of revoked sessions", func(t *testing.T) {
+		t.Parallel()
+
+		revocations := helpers.NewRevocationList()
+
+		revokedToken := helpers.NewAuthClaims("user@test.com", "", "", helpers.UserRoleString)
+		revokedSession := helpers.NewAuthClaims("user@test.com", "", "", helpers.UserRoleString)
+		revokedSession.SessionID = "revoked-session"
+		valid := helpers.NewAuthClaims("user@test.com", "", "", helpers.UserRoleString)
+		valid.SessionID = "active-session"
+
+		revocations.Revoke(revokedToken.Id, time.Now())
+		revocations.Revoke(revokedSession.SessionID, time.Now())
+
+		assert.True(t, revocations.IsRevoked(revokedToken))
+		assert.True(t, revocations.IsRevoked(revokedSession))
+		assert.False(t, revocations.IsRevoked(valid))
+	})
+
+	t.Run("Forgets revocations once the tokens they match expired", func(t *testing.T) {
+		t.Parallel()
+
+		revocations := helpers.NewRevocationList()
+		claims := helpers.NewAuthClaims("user@test.com", "", "", helpers.UserRoleString)
+
+		revocations.Revoke(claims.Id, time.Now().Add(-2*helpers.AuthClaimsDuration))
+		revocations.Revoke("other", time.Now())
+
+		assert.False(t, revocations.IsRevoked(claims))
+	})
+}
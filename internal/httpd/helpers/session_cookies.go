@@ -0,0 +1,130 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// SessionCookie holds the access token when sessions are kept in cookies, scripts cannot read it.
+	SessionCookie = "fringe_session"
+	// RefreshCookie holds the refresh token, it is only sent to the refresh and logout endpoints.
+	RefreshCookie = "fringe_refresh"
+	// CSRFCookie holds the CSRF token the client reads and sends back in CSRFHeader.
+	CSRFCookie = "fringe_csrf"
+	CSRFHeader = "X-CSRF-Token"
+	// CookieTokenType replaces Bearer in login responses when the tokens are only sent in cookies.
+	CookieTokenType = "Cookie"
+
+	sessionCookiePath   = "/api/"
+	refreshCookiePath   = "/api/auth/"
+	csrfCookiePath      = "/"
+	csrfTokenByteLength = 32
+)
+
+// SessionCookies are the tokens handed to the browser in cookies. Expiries are unix times.
+type SessionCookies struct {
+	Token            string
+	TokenExpiresAt   int64
+	RefreshToken     string
+	SessionExpiresAt int64
+}
+
+// IsStateChanging returns true for the methods that must carry a CSRF token when authenticated by cookie.
+func IsStateChanging(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// HasValidCSRFToken returns true when the CSRF header matches the CSRF cookie, a cross site page can send
+// the cookie but cannot read it to set the header.
+func HasValidCSRFToken(httpRequest *http.Request) bool {
+	cookie, err := httpRequest.Cookie(CSRFCookie)
+	if err != nil || len(cookie.Value) == 0 {
+		return false
+	}
+
+	header := httpRequest.Header.Get(CSRFHeader)
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
+
+// csrfTokenForRequest keeps the CSRF token of the browser so requests in flight during a refresh stay valid.
+func csrfTokenForRequest(httpRequest *http.Request) string {
+	if cookie, err := httpRequest.Cookie(CSRFCookie); err == nil && len(cookie.Value) > 0 {
+		return cookie.Value
+	}
+
+	token := make([]byte, csrfTokenByteLength)
+	if _, err := rand.Read(token); err != nil {
+		log.Panicf("could not generate csrf token: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// SetSessionCookies hands the tokens to the browser in HttpOnly cookies along with the CSRF token.
+// The refresh and CSRF cookies last as long as the session, or the access token without session.
+func SetSessionCookies(httpResponse http.ResponseWriter, httpRequest *http.Request, cookies SessionCookies) {
+	expiresAt := cookies.TokenExpiresAt
+	if len(cookies.RefreshToken) > 0 && cookies.SessionExpiresAt > expiresAt {
+		expiresAt = cookies.SessionExpiresAt
+	}
+
+	http.SetCookie(httpResponse, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    cookies.Token,
+		Path:     sessionCookiePath,
+		Expires:  time.Unix(cookies.TokenExpiresAt, 0),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	if len(cookies.RefreshToken) > 0 {
+		http.SetCookie(httpResponse, &http.Cookie{
+			Name:     RefreshCookie,
+			Value:    cookies.RefreshToken,
+			Path:     refreshCookiePath,
+			Expires:  time.Unix(expiresAt, 0),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	http.SetCookie(httpResponse, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    csrfTokenForRequest(httpRequest),
+		Path:     csrfCookiePath,
+		Expires:  time.Unix(expiresAt, 0),
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearSessionCookies removes the session, refresh and CSRF cookies from the browser.
+func ClearSessionCookies(httpResponse http.ResponseWriter) {
+	for _, cookie := range []struct{ name, path string }{
+		{SessionCookie, sessionCookiePath},
+		{RefreshCookie, refreshCookiePath},
+		{CSRFCookie, csrfCookiePath},
+	} {
+		http.SetCookie(httpResponse, &http.Cookie{
+			Name:     cookie.name,
+			Path:     cookie.path,
+			MaxAge:   -1,
+			HttpOnly: cookie.name != CSRFCookie,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package helpers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsStateChanging(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Only safe methods do not change state", func(t *testing.T) {
+		t.Parallel()
+
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+			assert.False(t, helpers.IsStateChanging(method), method)
+		}
+
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			assert.True(t, helpers.IsStateChanging(method), method)
+		}
+	})
+}
+
+func TestHasValidCSRFToken(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Requires the header to match the cookie", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			cookie string
+			header string
+			valid  bool
+		}{
+			{cookie: "token", header: "token", valid: true},
+			{cookie: "token", header: "other", valid: false},
+			{cookie: "token", header: "", valid: false},
+			{cookie: "", header: "", valid: false},
+		}
+
+		for _, test := range tests {
+			req := httptest.NewRequest(http.MethodPost, "/api/users/", nil)
+			if len(test.cookie) > 0 {
+				req.AddCookie(&http.Cookie{Name: helpers.CSRFCookie, Value: test.cookie})
+			}
+
+			req.Header.Set(helpers.CSRFHeader, test.header)
+
+			assert.Equal(t, test.valid, helpers.HasValidCSRFToken(req), test)
+		}
+	})
+}
+
+func TestSetSessionCookies(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Sets HttpOnly session cookies and a readable CSRF cookie", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/", nil)
+		res := httptest.NewRecorder()
+
+		helpers.SetSessionCookies(res, req, helpers.SessionCookies{
+			Token:            "token",
+			TokenExpiresAt:   now.Add(time.Hour).Unix(),
+			RefreshToken:     "refresh",
+			SessionExpiresAt: now.Add(24 * time.Hour).Unix(),
+		})
+
+		cookies := map[string]*http.Cookie{}
+		for _, cookie := range res.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+
+		assert.Len(t, cookies, 3)
+		assert.Equal(t, "token", cookies[helpers.SessionCookie].Value)
+		assert.Equal(t, "refresh", cookies[helpers.RefreshCookie].Value)
+		assert.NotEmpty(t, cookies[helpers.CSRFCookie].Value)
+		assert.Equal(t, now.Add(24*time.Hour).Unix(), cookies[helpers.RefreshCookie].Expires.Unix())
+
+		for _, cookie := range cookies {
+			assert.True(t, cookie.Secure, cookie.Name)
+			assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite, cookie.Name)
+			assert.Equal(t, cookie.Name != helpers.CSRFCookie, cookie.HttpOnly, cookie.Name)
+		}
+	})
+
+	t.Run("Keeps the CSRF token of the browser", func(t *testing.T) {
+		t.Parallel()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh/", nil)
+		req.AddCookie(&http.Cookie{Name: helpers.CSRFCookie, Value: "existing"})
+		res := httptest.NewRecorder()
+
+		helpers.SetSessionCookies(res, req, helpers.SessionCookies{Token: "token", TokenExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+		for _, cookie := range res.Result().Cookies() {
+			assert.NotEqual(t, helpers.RefreshCookie, cookie.Name)
+
+			if cookie.Name == helpers.CSRFCookie {
+				assert.Equal(t, "existing", cookie.Value)
+			}
+		}
+	})
+}
@@ -1,24 +1,33 @@
 package middlewares
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/mrz1836/go-sanitize"
 	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
 )
 
 type AuthMiddleware struct {
 	authHelper     *helpers.AuthHelper
+	apiTokens      *repos.APITokenRepository
+	userRepo       *repos.UserRepository
 	AuthPath       string
 	ExcludedPaths  []string
 	ProtectedPaths []string
 }
 
-var errInvalidAuthorizationString = errors.New("authorization string is invalid")
+var (
+	errInvalidAuthorizationString = errors.New("authorization string is invalid")
+	errAPITokenOwnerNotAllowed    = errors.New("api token owner is no longer allowed")
+)
 
 func extractBearerTokenFromAuthorization(authorization string) (token string, err error) {
 	if !strings.HasPrefix(authorization, "Bearer ") {
@@ -33,6 +42,24 @@ func extractBearerTokenFromAuthorization(authorization string) (token string, er
 	return splitAuthorization[1], nil
 }
 
+// tokenFromRequest returns the bearer token of the Authorization header or, with cookie sessions, the session cookie.
+// fromCookie is true when the token came from the cookie and the request must carry a CSRF token.
+func (a *AuthMiddleware) tokenFromRequest(httpRequest *http.Request) (token string, fromCookie bool, err error) {
+	authorization := httpRequest.Header.Get("Authorization")
+	if len(authorization) > 0 || !a.authHelper.CookieSessions() {
+		token, err = extractBearerTokenFromAuthorization(authorization)
+
+		return token, false, err
+	}
+
+	cookie, err := httpRequest.Cookie(helpers.SessionCookie)
+	if err != nil || len(cookie.Value) == 0 {
+		return "", false, errInvalidAuthorizationString
+	}
+
+	return cookie.Value, true, nil
+}
+
 func NewAuthMiddleware(redirectToAuthPath string, protectedPaths []string, excludedPaths []string, authHelper *helpers.AuthHelper) *AuthMiddleware {
 	return &AuthMiddleware{
 		authHelper:     authHelper,
@@ -42,6 +69,43 @@ func NewAuthMiddleware(redirectToAuthPath string, protectedPaths []string, exclu
 	}
 }
 
+// SetAPITokens accepts the API tokens of apiTokens as bearer tokens. Tokens of users are refused once the user
+// is deleted, disabled, expired or out of the allowed domain, and when the user cannot be read.
+func (a *AuthMiddleware) SetAPITokens(apiTokens *repos.APITokenRepository, userRepo *repos.UserRepository) {
+	a.apiTokens = apiTokens
+	a.userRepo = userRepo
+}
+
+// apiTokenClaims returns the claims of the owner of the API token, limited to the scopes of the token.
+// Users get their configured role, tokens are never created with scopes only granted at login by groups.
+func (a *AuthMiddleware) apiTokenClaims(ctx context.Context, token string) (*helpers.AuthClaims, error) {
+	apiToken, err := a.apiTokens.Authenticate(ctx, token, time.Now())
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	role := helpers.ServiceAccountRoleString
+
+	if apiToken.OwnerType == repos.APITokenOwnerUser {
+		if !a.authHelper.InAllowedDomain(apiToken.Owner) {
+			return nil, errAPITokenOwnerNotAllowed
+		}
+
+		user, err := a.userRepo.FindByEmail(ctx, apiToken.Owner)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errAPITokenOwnerNotAllowed, err)
+		}
+
+		if err := user.CheckEnabled(time.Now()); err != nil {
+			return nil, fmt.Errorf("%w: %v", errAPITokenOwnerNotAllowed, err)
+		}
+
+		role = a.authHelper.RoleForEmail(apiToken.Owner)
+	}
+
+	return helpers.NewAPITokenClaims(apiToken.Owner, apiToken.Name, role, apiToken.ScopeList(), apiToken.ID, apiToken.ExpiresAt), nil
+}
+
 func (a *AuthMiddleware) IsProtected(path string) bool {
 	for _, protected := range a.ProtectedPaths {
 		if strings.HasPrefix(path, protected) {
@@ -73,9 +137,7 @@ func (a *AuthMiddleware) EnsureAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		authorization := httpRequest.Header.Get("Authorization")
-
-		token, err := extractBearerTokenFromAuthorization(authorization)
+		token, fromCookie, err := a.tokenFromRequest(httpRequest)
 		if err != nil {
 			log.Printf("Auth [src:%v] %s requested without valid Bearer token, redirecting to %s: %v", httpRequest.RemoteAddr, sanitize.URL(uri.Path), a.AuthPath, err)
 			http.Error(httpResponse, "Invalid token", http.StatusForbidden)
@@ -83,7 +145,20 @@ func (a *AuthMiddleware) EnsureAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		claims, err := a.authHelper.AuthClaimsFromSignedToken(token)
+		if fromCookie && helpers.IsStateChanging(httpRequest.Method) && !helpers.HasValidCSRFToken(httpRequest) {
+			log.Printf("Auth [src:%v] %s %s requested with session cookie without valid CSRF token", httpRequest.RemoteAddr, httpRequest.Method, sanitize.URL(uri.Path))
+			http.Error(httpResponse, "Invalid CSRF token", http.StatusForbidden)
+
+			return
+		}
+
+		var claims *helpers.AuthClaims
+		if a.apiTokens != nil && strings.HasPrefix(token, repos.APITokenPrefix) {
+			claims, err = a.apiTokenClaims(httpRequest.Context(), token)
+		} else {
+			claims, err = a.authHelper.AuthClaimsFromSignedToken(token)
+		}
+
 		if err != nil {
 			log.Printf("Auth [src:%v] %v ", httpRequest.RemoteAddr, err)
 			http.Error(httpResponse, "Invalid claims", http.StatusForbidden)
@@ -91,6 +166,13 @@ func (a *AuthMiddleware) EnsureAuth(next http.Handler) http.Handler {
 			return
 		}
 
+		if a.authHelper.IsRevoked(claims) {
+			log.Printf("Auth [src:%v] token %s of %s was revoked", httpRequest.RemoteAddr, claims.Id, claims.Email)
+			http.Error(httpResponse, "Revoked token", http.StatusForbidden)
+
+			return
+		}
+
 		// Success add claims to context
 		ctx := claims.ContextWithClaims(httpRequest.Context())
 
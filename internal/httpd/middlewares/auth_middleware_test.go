@@ -1,6 +1,7 @@
 package middlewares_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +12,8 @@ import (
 	"github.com/jaswdr/faker"
 	"github.com/p-l/fringe/internal/httpd/helpers"
 	"github.com/p-l/fringe/internal/httpd/middlewares"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -121,6 +124,31 @@ func TestAuthMiddleware_EnsureAuth(t *testing.T) {
 		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
 	})
 
+	t.Run("rejects revoked tokens", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("@test.com", "secret", []string{})
+		authHelper.SetRevocationList(helpers.NewRevocationList())
+		authMiddleware := middlewares.NewAuthMiddleware(authPath, []string{"/"}, []string{"/no-auth"}, authHelper)
+
+		claims := helpers.NewAuthClaims("user@test.com", "", "", "")
+		claims.SessionID = "ended-session"
+		authHelper.RevocationList().Revoke(claims.SessionID, time.Now())
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", authHelper.NewJWTSignedString(claims)))
+		res := httptest.NewRecorder()
+
+		router := mux.NewRouter()
+		router.Use(authMiddleware.EnsureAuth)
+		router.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+			t.Errorf("Root Handler must not be called")
+		})
+		router.ServeHTTP(res, req)
+
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+	})
+
 	t.Run("rejects invalid token type strings", func(t *testing.T) {
 		t.Parallel()
 		fake := faker.New()
@@ -166,6 +194,154 @@ func TestAuthMiddleware_EnsureAuth(t *testing.T) {
 
 		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
 	})
+
+	t.Run("accepts session cookies with a CSRF token on state changing methods", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("@test.com", "secret", []string{})
+		authHelper.SetCookieSessions(true)
+		authMiddleware := middlewares.NewAuthMiddleware(authPath, []string{"/"}, []string{"/no-auth"}, authHelper)
+
+		token := authHelper.NewJWTSignedString(helpers.NewAuthClaims("user@test.com", "", "", ""))
+
+		tests := []struct {
+			method   string
+			csrf     string
+			expected int
+		}{
+			{http.MethodGet, "", http.StatusTeapot},
+			{http.MethodPost, "csrf-token", http.StatusTeapot},
+			{http.MethodPost, "", http.StatusForbidden},
+			{http.MethodDelete, "other-token", http.StatusForbidden},
+		}
+
+		for _, test := range tests {
+			req := httptest.NewRequest(test.method, "/", nil)
+			req.AddCookie(&http.Cookie{Name: helpers.SessionCookie, Value: token})
+			req.AddCookie(&http.Cookie{Name: helpers.CSRFCookie, Value: "csrf-token"})
+			req.Header.Set(helpers.CSRFHeader, test.csrf)
+			res := httptest.NewRecorder()
+
+			router := mux.NewRouter()
+			router.Use(authMiddleware.EnsureAuth)
+			router.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+				_, ok := helpers.AuthClaimsFromContext(request.Context())
+				assert.True(t, ok)
+				writer.WriteHeader(http.StatusTeapot)
+			})
+			router.ServeHTTP(res, req)
+
+			assert.Equal(t, test.expected, res.Result().StatusCode, "%s with csrf %q", test.method, test.csrf)
+		}
+	})
+
+	t.Run("ignores session cookies unless cookie sessions are enabled", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("@test.com", "secret", []string{})
+		authMiddleware := middlewares.NewAuthMiddleware(authPath, []string{"/"}, []string{"/no-auth"}, authHelper)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: helpers.SessionCookie, Value: authHelper.NewJWTSignedString(helpers.NewAuthClaims("user@test.com", "", "", ""))})
+		res := httptest.NewRecorder()
+
+		router := mux.NewRouter()
+		router.Use(authMiddleware.EnsureAuth)
+		router.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+			t.Errorf("Root Handler must not be called")
+		})
+		router.ServeHTTP(res, req)
+
+		assert.Equal(t, http.StatusForbidden, res.Result().StatusCode)
+	})
+
+	t.Run("accepts API tokens with their scopes as permissions", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+		userRepo := mocks.NewMockUserRepository(t)
+		authMiddleware := middlewares.NewAuthMiddleware(authPath, []string{"/"}, []string{}, authHelper)
+		authMiddleware.SetAPITokens(tokenRepo, userRepo)
+
+		_, err := tokenRepo.CreateServiceAccount(context.Background(), "provisioning", "", "admin@test.com")
+		assert.NoError(t, err)
+
+		apiToken, token, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerServiceAccount, "provisioning", "script", []string{string(helpers.PermissionUsersCreate)}, 0, "admin@test.com")
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res := httptest.NewRecorder()
+
+		router := mux.NewRouter()
+		router.Use(authMiddleware.EnsureAuth)
+		router.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+			claims, ok := helpers.AuthClaimsFromContext(request.Context())
+			assert.True(t, ok)
+			assert.Equal(t, "provisioning", claims.Email)
+			assert.Equal(t, helpers.ServiceAccountRoleString, claims.Role)
+			assert.Equal(t, apiToken.ID, claims.APITokenID)
+			assert.True(t, claims.Can(helpers.PermissionUsersCreate))
+			assert.False(t, claims.Can(helpers.PermissionUsersDelete))
+			writer.WriteHeader(http.StatusTeapot)
+		})
+		router.ServeHTTP(res, req)
+
+		assert.Equal(t, http.StatusTeapot, res.Result().StatusCode)
+	})
+
+	t.Run("rejects API tokens of disabled, expired and deleted users and unknown API tokens", func(t *testing.T) {
+		t.Parallel()
+
+		authHelper := helpers.NewAuthHelper("test.com", "secret", []string{})
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+		userRepo := mocks.NewMockUserRepository(t)
+		authMiddleware := middlewares.NewAuthMiddleware(authPath, []string{"/"}, []string{}, authHelper)
+		authMiddleware.SetAPITokens(tokenRepo, userRepo)
+
+		tokens := map[string]string{}
+
+		for _, email := range []string{"user@test.com", "disabled@test.com", "expired@test.com", "deleted@test.com"} {
+			_, err := userRepo.Create(context.Background(), email, "User", "", faker.New().Internet().Password())
+			assert.NoError(t, err)
+
+			_, token, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, email, "script", nil, 0, email)
+			assert.NoError(t, err)
+
+			tokens[email] = token
+		}
+
+		router := mux.NewRouter()
+		router.Use(authMiddleware.EnsureAuth)
+		router.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(http.StatusTeapot)
+		})
+
+		serve := func(token string) int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			return res.Result().StatusCode
+		}
+
+		for email, token := range tokens {
+			assert.Equal(t, http.StatusTeapot, serve(token), email)
+		}
+
+		assert.Equal(t, http.StatusForbidden, serve(repos.APITokenPrefix+"unknown_secret"))
+
+		assert.NoError(t, userRepo.Disable(context.Background(), "disabled@test.com"))
+		assert.NoError(t, userRepo.SetExpiry(context.Background(), "expired@test.com", time.Now().Add(-time.Minute).Unix()))
+		assert.NoError(t, userRepo.Delete(context.Background(), "deleted@test.com"))
+
+		assert.Equal(t, http.StatusTeapot, serve(tokens["user@test.com"]))
+		assert.Equal(t, http.StatusForbidden, serve(tokens["disabled@test.com"]))
+		assert.Equal(t, http.StatusForbidden, serve(tokens["expired@test.com"]))
+		assert.Equal(t, http.StatusForbidden, serve(tokens["deleted@test.com"]))
+	})
 }
 
 func TestAuthMiddleware_IsProtected(t *testing.T) {
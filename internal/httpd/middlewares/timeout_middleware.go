@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+)
+
+// TimeoutMiddleware bounds how long requests may take, except on the transfer paths which stream whole databases or
+// user lists and are only bound by the server timeouts.
+type TimeoutMiddleware struct {
+	timeout       time.Duration
+	TransferPaths []string
+}
+
+func NewTimeoutMiddleware(timeout time.Duration, transferPaths []string) *TimeoutMiddleware {
+	return &TimeoutMiddleware{
+		timeout:       timeout,
+		TransferPaths: transferPaths,
+	}
+}
+
+func (m *TimeoutMiddleware) isTransferPath(path string) bool {
+	for _, transferPath := range m.TransferPaths {
+		if path == transferPath {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LimitRequests answers 503 Service Unavailable and cancels the request context once the timeout has passed.
+func (m *TimeoutMiddleware) LimitRequests(next http.Handler) http.Handler {
+	limited := http.TimeoutHandler(next, m.timeout, "request timed out")
+
+	return http.HandlerFunc(func(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+		if m.isTransferPath(httpRequest.URL.Path) {
+			next.ServeHTTP(httpResponse, httpRequest)
+
+			return
+		}
+
+		limited.ServeHTTP(httpResponse, httpRequest)
+	})
+}
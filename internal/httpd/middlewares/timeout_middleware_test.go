@@ -0,0 +1,47 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/p-l/fringe/internal/httpd/middlewares"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutMiddleware_LimitRequests(t *testing.T) {
+	t.Parallel()
+
+	slowHandler := func(httpResponse http.ResponseWriter, httpRequest *http.Request) {
+		select {
+		case <-time.After(time.Millisecond * 100):
+			httpResponse.WriteHeader(http.StatusOK)
+		case <-httpRequest.Context().Done():
+		}
+	}
+
+	router := mux.NewRouter()
+	router.Use(middlewares.NewTimeoutMiddleware(time.Millisecond*10, []string{"/api/snapshot/"}).LimitRequests)
+	router.HandleFunc("/api/users/", slowHandler)
+	router.HandleFunc("/api/snapshot/", slowHandler)
+
+	t.Run("Times out slow requests", func(t *testing.T) {
+		t.Parallel()
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/api/users/", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, res.Result().StatusCode)
+	})
+
+	t.Run("Lets transfers take longer", func(t *testing.T) {
+		t.Parallel()
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/api/snapshot/", nil))
+
+		assert.Equal(t, http.StatusOK, res.Result().StatusCode)
+	})
+}
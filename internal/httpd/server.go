@@ -1,12 +1,14 @@
 package httpd
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -14,6 +16,7 @@ import (
 	"github.com/p-l/fringe/internal/httpd/helpers"
 	"github.com/p-l/fringe/internal/httpd/middlewares"
 	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/jobs"
 	"github.com/p-l/fringe/internal/repos"
 	"github.com/p-l/fringe/internal/system"
 	"github.com/rs/cors"
@@ -27,35 +30,221 @@ type Texts struct {
 
 const (
 	httpsTimeouts        = time.Second * 5
+	transferTimeout      = time.Minute * 10
 	preFlightCacheMaxAge = time.Minute * 5
 )
 
+// identityProviders returns Google, when configured, followed by the OpenID Connect providers.
+func identityProviders(config system.Config, googleOAuth *services.GoogleOAuthService) []services.IdentityProvider {
+	providers := make([]services.IdentityProvider, 0, len(config.OAuth.Providers)+1)
+	if len(googleOAuth.ClientID) > 0 {
+		providers = append(providers, googleOAuth)
+	}
+
+	for _, provider := range config.OAuth.Providers {
+		providers = append(providers, services.NewOIDCProvider(http.DefaultClient, services.OIDCProviderSettings{
+			ID:           provider.Name,
+			DisplayName:  provider.DisplayName,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  fmt.Sprintf("https://%s%s", config.Web.Domain, services.CallbackPath(provider.Name)),
+			Scopes:       provider.Scopes,
+			Claims: services.ClaimMapping{
+				Email:   provider.Claims.Email,
+				Name:    provider.Claims.Name,
+				Picture: provider.Claims.Picture,
+				Groups:  provider.Claims.Groups,
+			},
+			AllowMissingEmailVerified: provider.AllowMissingEmailVerified,
+		}))
+	}
+
+	return providers
+}
+
+// newSAMLServiceProvider returns the SAML service provider, or nil when SAML is disabled.
+func newSAMLServiceProvider(config system.Config) *services.SAMLServiceProvider {
+	if !config.SAML.Enabled {
+		return nil
+	}
+
+	pemCertificates, err := os.ReadFile(config.SAML.IdPCertificateFile)
+	if err != nil {
+		log.Panicf("could not read saml identity provider certificate: %v", err)
+	}
+
+	certificates, err := services.ParseCertificates(pemCertificates)
+	if err != nil {
+		log.Panicf("could not parse saml identity provider certificate: %v", err)
+	}
+
+	entityID := config.SAML.EntityID
+	if len(entityID) == 0 {
+		entityID = fmt.Sprintf("https://%s%s", config.Web.Domain, handlers.SAMLMetadataPath)
+	}
+
+	return services.NewSAMLServiceProvider(services.SAMLSettings{
+		DisplayName:     config.SAML.DisplayName,
+		EntityID:        entityID,
+		ACSURL:          fmt.Sprintf("https://%s%s", config.Web.Domain, handlers.SAMLAssertionConsumerPath),
+		IdPEntityID:     config.SAML.IdPEntityID,
+		IdPSSOURL:       config.SAML.IdPSSOURL,
+		IdPCertificates: certificates,
+		Attributes: services.SAMLAttributeMapping{
+			Email:  config.SAML.Attributes.Email,
+			Name:   config.SAML.Attributes.Name,
+			Role:   config.SAML.Attributes.Role,
+			Groups: config.SAML.Attributes.Groups,
+		},
+	})
+}
+
+// NewRevocationList returns the revocation list with the sessions revoked while their access tokens may still be valid,
+// tokens revoked before a restart are refused after it.
+func NewRevocationList(sessionRepo *repos.SessionRepository) *helpers.RevocationList {
+	revocations := helpers.NewRevocationList()
+
+	revoked, err := sessionRepo.RevokedSince(context.Background(), time.Now().Add(-helpers.AuthClaimsDuration).Unix())
+	if err != nil {
+		log.Panicf("could not load revoked sessions: %v", err)
+	}
+
+	for _, session := range revoked {
+		revocations.Revoke(session.ID, time.Unix(session.RevokedAt, 0))
+	}
+
+	return revocations
+}
+
 // NewHTTPServer Create and configure the HTTP server.
-func NewHTTPServer(config system.Config, repo *repos.UserRepository, clientAssets fs.FS, jwtSecret string) *http.Server {
-	googleOAuth := services.NewGoogleOAuthService(http.DefaultClient, config.OAuth.Google.ClientID, config.OAuth.Google.ClientSecret, fmt.Sprintf("https://%s/auth/google/callback", config.Web.Domain))
+func NewHTTPServer(config system.Config, repo *repos.UserRepository, auditRepo *repos.AuditRepository, sessionRepo *repos.SessionRepository, revocations *helpers.RevocationList, tokenRepo *repos.APITokenRepository, reaper *jobs.Reaper, snapshotRepo *repos.SnapshotRepository, poolRepo *repos.IPPoolRepository, groups *helpers.GroupMapping, directory *services.WorkspaceDirectory, directorySync *jobs.DirectorySync, keys *helpers.KeySet, clientAssets fs.FS, jwtSecret string) *http.Server {
+	googleOAuth := services.NewGoogleOAuthService(http.DefaultClient, config.OAuth.Google.ClientID, config.OAuth.Google.ClientSecret, fmt.Sprintf("https://%s%s", config.Web.Domain, services.CallbackPath(services.GoogleProviderID)))
+	googleOAuth.HostedDomain = config.Security.AllowedDomain
+
+	// Google ID tokens do not carry groups, they are read from the directory
+	if groups.Enabled() && directory != nil {
+		googleOAuth.Directory = directory
+	}
+	providers := identityProviders(config, googleOAuth)
+	samlServiceProvider := newSAMLServiceProvider(config)
+
+	loginProviders := make([]services.LoginProvider, 0, len(providers)+1)
+	for _, provider := range providers {
+		loginProviders = append(loginProviders, provider)
+	}
+
+	if samlServiceProvider != nil {
+		loginProviders = append(loginProviders, samlServiceProvider)
+	}
 
 	authHelper := helpers.NewAuthHelper(config.Security.AllowedDomain, jwtSecret, config.Security.AuthorizedAdminEmails)
+	authHelper.SetRoleMembers(helpers.HelpdeskRoleString, config.Security.HelpdeskEmails)
+	authHelper.SetRoleMembers(helpers.AuditorRoleString, config.Security.AuditorEmails)
+	authHelper.SetGroupMapping(groups)
+	authHelper.SetRevocationList(revocations)
+	authHelper.SetCookieSessions(config.Web.CookieSessions)
+	authHelper.SetTokenIssuer(fmt.Sprintf("https://%s", config.Web.Domain))
+
+	for _, provider := range config.OAuth.Providers {
+		if len(provider.AllowedDomain) > 0 {
+			authHelper.SetProviderDomain(provider.Name, provider.AllowedDomain)
+		}
+	}
+
+	if keys != nil {
+		authHelper.SetKeySet(keys)
+	}
 
 	logMiddleware := middlewares.NewLogMiddleware(log.Default())
+	timeoutMiddleware := middlewares.NewTimeoutMiddleware(httpsTimeouts, []string{"/api/snapshot/", "/api/users/export/", "/api/users/import/"})
 	authMiddleware := middlewares.NewAuthMiddleware("/auth/", []string{"/api"}, []string{"/api/auth/", "/api/config/"}, authHelper)
+	authMiddleware.SetAPITokens(tokenRepo, repo)
 
 	defaultHandler := handlers.NewDefaultHandler()
-	authHandler := handlers.NewAuthHandler(repo, googleOAuth, authHelper)
-	userHandler := handlers.NewUserHandler(repo, authHelper)
-	configHandler := handlers.NewConfigHandler(config.OAuth.Google)
+	authHandler := handlers.NewAuthHandler(repo, googleOAuth, authHelper, providers...)
+	authHandler.SetSessions(sessionRepo)
+	sessionHandler := handlers.NewSessionHandler(sessionRepo, repo, auditRepo, authHelper)
+	userHandler := handlers.NewUserHandler(repo, auditRepo, authHelper)
+	userHandler.SetSessions(sessionRepo)
+	auditHandler := handlers.NewAuditHandler(auditRepo)
+	snapshotHandler := handlers.NewSnapshotHandler(snapshotRepo, auditRepo)
+	credentialHandler := handlers.NewCredentialHandler(repo, auditRepo)
+	apiTokenHandler := handlers.NewAPITokenHandler(tokenRepo, auditRepo, authHelper)
+	totpHandler := handlers.NewTOTPHandler(repo, auditRepo, config.Radius.TOTPIssuer)
+	ipPoolHandler := handlers.NewIPPoolHandler(poolRepo, auditRepo)
+	configHandler := handlers.NewConfigHandler(config.OAuth.Google, loginProviders...)
 
 	router := mux.NewRouter()
 	router.Use(logMiddleware.LogRequests)
+	router.Use(timeoutMiddleware.LimitRequests)
 	router.Use(authMiddleware.EnsureAuth)
 
 	// Hook the handlers
 	router.HandleFunc("/api/auth/", authHandler.Login).Methods(http.MethodPost)
+	router.HandleFunc("/api/auth/refresh/", sessionHandler.Refresh).Methods(http.MethodPost)
+	router.HandleFunc("/api/auth/logout/", sessionHandler.Logout).Methods(http.MethodPost)
+	if samlServiceProvider != nil {
+		samlHandler := handlers.NewSAMLHandler(repo, samlServiceProvider, authHelper)
+		samlHandler.SetSessions(sessionRepo)
+		router.HandleFunc(handlers.SAMLMetadataPath, samlHandler.Metadata).Methods(http.MethodGet)
+		router.HandleFunc(services.LoginPath(services.SAMLProviderID), samlHandler.Login).Methods(http.MethodGet)
+		router.HandleFunc(handlers.SAMLAssertionConsumerPath, samlHandler.AssertionConsumer).Methods(http.MethodPost)
+	}
+
+	router.HandleFunc(services.LoginPath("{provider}"), authHandler.ProviderLogin).Methods(http.MethodGet)
+	router.HandleFunc(services.CallbackPath("{provider}"), authHandler.ProviderCallback).Methods(http.MethodGet)
 	router.HandleFunc("/api/config/", configHandler.Root).Methods(http.MethodGet)
 	router.HandleFunc("/api/users/", userHandler.List).Methods(http.MethodGet)
 	router.HandleFunc("/api/users/", userHandler.Create).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/export/", userHandler.Export).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/import/", userHandler.Import).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/me/tokens/", apiTokenHandler.ListPersonal).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/me/tokens/", apiTokenHandler.CreatePersonal).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/me/tokens/{id}/", apiTokenHandler.RevokePersonal).Methods(http.MethodDelete)
+	router.HandleFunc("/api/users/me/totp/", totpHandler.Status).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/me/totp/", totpHandler.Enroll).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/me/totp/", totpHandler.Confirm).Methods(http.MethodPut)
+	router.HandleFunc("/api/users/me/totp/", totpHandler.Disable).Methods(http.MethodDelete)
 	router.HandleFunc("/api/users/{email}/", userHandler.View).Methods(http.MethodGet)
 	router.HandleFunc("/api/users/{email}/", userHandler.Delete).Methods(http.MethodDelete)
 	router.HandleFunc("/api/users/{email}/renew/", userHandler.Renew).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{email}/expiry/", userHandler.UpdateExpiry).Methods(http.MethodPut)
+	router.HandleFunc("/api/users/{email}/enable/", userHandler.Enable).Methods(http.MethodPut)
+	router.HandleFunc("/api/users/{email}/attributes/", userHandler.UpdateAttributes).Methods(http.MethodPut)
+	router.HandleFunc("/api/users/{email}/totp/", totpHandler.Reset).Methods(http.MethodDelete)
+	router.HandleFunc("/api/users/{email}/credentials/", credentialHandler.List).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{email}/credentials/", credentialHandler.Create).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/{email}/credentials/{id}/", credentialHandler.Revoke).Methods(http.MethodDelete)
+	router.HandleFunc("/api/users/{email}/sessions/", sessionHandler.RevokeUser).Methods(http.MethodDelete)
+	router.HandleFunc("/api/users/{email}/reservation/", ipPoolHandler.Reserve).Methods(http.MethodPut)
+	router.HandleFunc("/api/users/{email}/reservation/", ipPoolHandler.Unreserve).Methods(http.MethodDelete)
+	router.HandleFunc("/api/service-accounts/", apiTokenHandler.ListServiceAccounts).Methods(http.MethodGet)
+	router.HandleFunc("/api/service-accounts/", apiTokenHandler.CreateServiceAccount).Methods(http.MethodPost)
+	router.HandleFunc("/api/service-accounts/{name}/", apiTokenHandler.DeleteServiceAccount).Methods(http.MethodDelete)
+	router.HandleFunc("/api/service-accounts/{name}/tokens/", apiTokenHandler.ListServiceAccountTokens).Methods(http.MethodGet)
+	router.HandleFunc("/api/service-accounts/{name}/tokens/", apiTokenHandler.CreateServiceAccountToken).Methods(http.MethodPost)
+	router.HandleFunc("/api/service-accounts/{name}/tokens/{id}/", apiTokenHandler.RevokeServiceAccountToken).Methods(http.MethodDelete)
+	router.HandleFunc("/api/ip-pools/", ipPoolHandler.List).Methods(http.MethodGet)
+	router.HandleFunc("/api/audit/", auditHandler.List).Methods(http.MethodGet)
+	router.HandleFunc("/api/audit/verify/", auditHandler.Verify).Methods(http.MethodGet)
+
+	if reaper != nil {
+		reaperHandler := handlers.NewReaperHandler(reaper)
+		router.HandleFunc("/api/reaper/report/", reaperHandler.Report).Methods(http.MethodGet)
+	}
+
+	if directorySync != nil {
+		directorySyncHandler := handlers.NewDirectorySyncHandler(directorySync)
+		router.HandleFunc("/api/directory/report/", directorySyncHandler.Report).Methods(http.MethodGet)
+		router.HandleFunc("/api/directory/last-run/", directorySyncHandler.LastRun).Methods(http.MethodGet)
+		router.HandleFunc("/api/directory/sync/", directorySyncHandler.Run).Methods(http.MethodPost)
+	}
+
+	router.HandleFunc("/api/snapshot/", snapshotHandler.Download).Methods(http.MethodGet)
+	if keys != nil {
+		router.HandleFunc(handlers.JWKSPath, handlers.NewJWKSHandler(keys).Keys).Methods(http.MethodGet)
+	}
 
 	// Serve the web client
 	if len(config.Web.ReverseProxy) == 0 {
@@ -74,30 +263,33 @@ func NewHTTPServer(config system.Config, repo *repos.UserRepository, clientAsset
 		router.NotFoundHandler = reverseProxy
 	}
 
-	httpdHandler := addCORS(config.Web.AllowOrigins, router)
+	httpdHandler := addCORS(config.Web.AllowOrigins, config.Web.CookieSessions, router)
 
 	log.Printf("Created httpd server on %s", config.Services.HTTPSBindAddress)
+	// Transfers need longer than the other requests, which the timeout middleware bounds to httpsTimeouts
 	httpd := http.Server{
-		Handler:      httpdHandler,
-		Addr:         config.Services.HTTPSBindAddress,
-		WriteTimeout: httpsTimeouts,
-		ReadTimeout:  httpsTimeouts,
-		IdleTimeout:  httpsTimeouts,
+		Handler:           httpdHandler,
+		Addr:              config.Services.HTTPSBindAddress,
+		WriteTimeout:      transferTimeout,
+		ReadTimeout:       transferTimeout,
+		ReadHeaderTimeout: httpsTimeouts,
+		IdleTimeout:       httpsTimeouts,
 	}
 
 	return &httpd
 }
 
-func addCORS(allowedOrigins []string, router *mux.Router) http.Handler {
+// addCORS allows the origins to call the API, with credentials when sessions are kept in cookies.
+func addCORS(allowedOrigins []string, allowCredentials bool, router *mux.Router) http.Handler {
 	for _, origin := range allowedOrigins {
 		log.Printf("HTTPD Allowed Origin: %s", origin)
 	}
 
 	corsHandler := cors.New(cors.Options{
-		AllowCredentials: false,
+		AllowCredentials: allowCredentials,
 		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodOptions, http.MethodDelete},
-		AllowedHeaders:   []string{"accept", "authorization", "content-type"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodOptions, http.MethodDelete},
+		AllowedHeaders:   []string{"accept", "authorization", "content-type", "x-csrf-token"},
 		MaxAge:           int(preFlightCacheMaxAge.Seconds()),
 		Debug:            true,
 	})
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AuthorizationRequest is a login started with an identity provider, it is completed by the callback with the same State.
+// Nonce binds the ID token to the request and CodeVerifier proves the code is redeemed by fringe (PKCE).
+type AuthorizationRequest struct {
+	Provider     string
+	State        string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// AuthorizationRequests keeps the pending logins until their callback or expiry, at most AuthorizationRequestsMax
+// at once since anyone can start a login.
+type AuthorizationRequests struct {
+	lock    sync.Mutex
+	pending map[string]*AuthorizationRequest
+}
+
+var (
+	ErrUnknownAuthorizationRequest  = errors.New("unknown or expired authorization request")
+	ErrTooManyAuthorizationRequests = errors.New("too many pending authorization requests")
+)
+
+const (
+	// AuthorizationRequestTimeout is how long users have to log in with the identity provider.
+	AuthorizationRequestTimeout = 10 * time.Minute
+	// AuthorizationRequestsMax bounds the memory used by logins never completed.
+	AuthorizationRequestsMax  = 10000
+	authorizationRandomLength = 32
+)
+
+func randomURLString() (string, error) {
+	random := make([]byte, authorizationRandomLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("could not generate random string: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge of the code verifier.
+func (r *AuthorizationRequest) CodeChallenge() string {
+	digest := sha256.Sum256([]byte(r.CodeVerifier))
+
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func NewAuthorizationRequests() *AuthorizationRequests {
+	return &AuthorizationRequests{pending: map[string]*AuthorizationRequest{}}
+}
+
+// Begin returns a new pending request to provider with random state, nonce and code verifier.
+func (a *AuthorizationRequests) Begin(provider string, now time.Time) (*AuthorizationRequest, error) {
+	request := AuthorizationRequest{Provider: provider, ExpiresAt: now.Add(AuthorizationRequestTimeout)}
+
+	for _, target := range []*string{&request.State, &request.Nonce, &request.CodeVerifier} {
+		random, err := randomURLString()
+		if err != nil {
+			return nil, err
+		}
+
+		*target = random
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for state, pending := range a.pending {
+		if now.After(pending.ExpiresAt) {
+			delete(a.pending, state)
+		}
+	}
+
+	if len(a.pending) >= AuthorizationRequestsMax {
+		return nil, ErrTooManyAuthorizationRequests
+	}
+
+	a.pending[request.State] = &request
+
+	return &request, nil
+}
+
+// Complete consumes the pending request with state, each request completes once.
+func (a *AuthorizationRequests) Complete(state string, now time.Time) (*AuthorizationRequest, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	request, found := a.pending[state]
+	delete(a.pending, state)
+
+	if !found || now.After(request.ExpiresAt) {
+		return nil, ErrUnknownAuthorizationRequest
+	}
+
+	return request, nil
+}
@@ -0,0 +1,92 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizationRequests_Complete(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Requests complete once", func(t *testing.T) {
+		t.Parallel()
+
+		requests := services.NewAuthorizationRequests()
+		now := time.Now()
+
+		request, err := requests.Begin("google", now)
+		assert.NoError(t, err)
+		assert.NotEqual(t, request.State, request.Nonce)
+		assert.NotEqual(t, request.State, request.CodeVerifier)
+
+		completed, err := requests.Complete(request.State, now)
+		assert.NoError(t, err)
+		assert.Equal(t, request, completed)
+
+		_, err = requests.Complete(request.State, now)
+		assert.ErrorIs(t, err, services.ErrUnknownAuthorizationRequest)
+	})
+
+	t.Run("Refuses unknown and expired requests", func(t *testing.T) {
+		t.Parallel()
+
+		requests := services.NewAuthorizationRequests()
+		now := time.Now()
+
+		_, err := requests.Complete("unknown", now)
+		assert.ErrorIs(t, err, services.ErrUnknownAuthorizationRequest)
+
+		request, err := requests.Begin("google", now)
+		assert.NoError(t, err)
+
+		_, err = requests.Complete(request.State, now.Add(services.AuthorizationRequestTimeout+time.Second))
+		assert.ErrorIs(t, err, services.ErrUnknownAuthorizationRequest)
+	})
+}
+
+func TestAuthorizationRequests_Begin(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses new requests once the pending ones reach the limit", func(t *testing.T) {
+		t.Parallel()
+
+		requests := services.NewAuthorizationRequests()
+		now := time.Now()
+
+		var first *services.AuthorizationRequest
+
+		for index := 0; index < services.AuthorizationRequestsMax; index++ {
+			request, err := requests.Begin("google", now)
+			assert.NoError(t, err)
+
+			if first == nil {
+				first = request
+			}
+		}
+
+		_, err := requests.Begin("google", now)
+		assert.ErrorIs(t, err, services.ErrTooManyAuthorizationRequests)
+
+		// Completed and expired requests make room
+		_, err = requests.Complete(first.State, now)
+		assert.NoError(t, err)
+
+		_, err = requests.Begin("google", now)
+		assert.NoError(t, err)
+
+		_, err = requests.Begin("google", now.Add(services.AuthorizationRequestTimeout+time.Second))
+		assert.NoError(t, err)
+	})
+}
+
+func TestAuthorizationRequest_CodeChallenge(t *testing.T) {
+	t.Parallel()
+
+	request := services.AuthorizationRequest{CodeVerifier: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"}
+
+	// Example from RFC 7636 Appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", request.CodeChallenge())
+}
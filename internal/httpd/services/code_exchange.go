@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// oauthClient is the registration of fringe with an identity provider.
+type oauthClient struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+}
+
+// tokenResponse is the reply of an OAuth token endpoint, only the ID token is used.
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+var errCodeExchangeFailed = errors.New("could not exchange authorization code")
+
+// exchangeCode redeems the authorization code with the client secret and the PKCE code verifier.
+func exchangeCode(ctx context.Context, httpClient *http.Client, tokenURL string, client oauthClient, code string, codeVerifier string) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {client.redirectURL},
+		"client_id":     {client.clientID},
+		"client_secret": {client.clientSecret},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errCodeExchangeFailed, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errCodeExchangeFailed, err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: expected 200 from %s and got %d", errCodeExchangeFailed, tokenURL, resp.StatusCode)
+	}
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("%w: %v", errCodeExchangeFailed, err)
+	}
+
+	if len(tokens.IDToken) == 0 {
+		return nil, fmt.Errorf("%w: no id_token in response", errCodeExchangeFailed)
+	}
+
+	return &tokens, nil
+}
+
+// authCodeURL returns the authorization endpoint URL starting the login of request.
+func authCodeURL(authURL string, client oauthClient, scopes []string, request *AuthorizationRequest, extra url.Values) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.clientID},
+		"redirect_uri":          {client.redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {request.State},
+		"nonce":                 {request.Nonce},
+		"code_challenge":        {request.CodeChallenge()},
+		"code_challenge_method": {"S256"},
+	}
+
+	for name, values := range extra {
+		query[name] = values
+	}
+
+	separator := "?"
+	if strings.Contains(authURL, "?") {
+		separator = "&"
+	}
+
+	return authURL + separator + query.Encode()
+}
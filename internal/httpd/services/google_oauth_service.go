@@ -2,21 +2,26 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/subtle"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/p-l/fringe/internal/httpd/helpers"
 )
 
+// GoogleOAuthService logs users in with Google, HostedDomain hints the Workspace domain of accounts when set.
+// Groups of users are read from Directory when set.
 type GoogleOAuthService struct {
 	ClientID          string
 	ClientSecret      string
 	ClientCallbackURL string
+	HostedDomain      string
+	Directory         GroupDirectory
 	httpClient        *http.Client
+	idTokens          *IDTokenVerifier
 }
 
 type GoogleUserInfo struct {
@@ -27,68 +32,140 @@ type GoogleUserInfo struct {
 	Profile       string `json:"profile"`
 	Picture       string `json:"picture"`
 	Email         string `json:"email"`
-	VerifiedEmail bool   `json:"verified_email"`
+	VerifiedEmail bool   `json:"email_verified"`
 	HD            string `json:"hd"`
 }
 
 var ErrGoogleAuthenticationFailed = errors.New("invalid response from google API")
 
+const (
+	GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+	GoogleIssuer  = "https://accounts.google.com"
+	// GoogleProviderID names Google in the login and callback URLs.
+	GoogleProviderID = "google"
+	// GoogleAuthURL and GoogleTokenURL are the endpoints of the authorization code flow.
+	GoogleAuthURL  = "https://accounts.google.com/o/oauth2/v2/auth"
+	GoogleTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+var googleScopes = []string{"openid", "email", "profile"}
+
 func NewGoogleOAuthService(httpClient *http.Client, clientID string, clientSecret string, clientCallbackURL string) *GoogleOAuthService {
 	return &GoogleOAuthService{
 		ClientID:          clientID,
 		ClientSecret:      clientSecret,
 		ClientCallbackURL: clientCallbackURL,
 		httpClient:        httpClient,
+		idTokens:          NewIDTokenVerifier(NewJWKS(httpClient, GoogleJWKSURL), clientID, GoogleIssuer, "accounts.google.com"),
 	}
 }
 
-//
+// validateUserInfo refuses accounts without a valid and verified email.
+func validateUserInfo(userInfo *GoogleUserInfo) error {
+	if !helpers.IsEmailValid(userInfo.Email) {
+		return fmt.Errorf("invalid email '%s': %w", userInfo.Email, ErrGoogleAuthenticationFailed)
+	}
 
-func (g *GoogleOAuthService) fetchGoogleUserInfoWithToken(ctx context.Context, tokenType string, token string) (userInfo *GoogleUserInfo, err error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://openidconnect.googleapis.com/v1/userinfo", nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to request userinfo: %w", err)
+	if !userInfo.VerifiedEmail {
+		return fmt.Errorf("email '%s' is not verified: %w", userInfo.Email, ErrGoogleAuthenticationFailed)
 	}
 
-	req.Header.Add("Authorization", fmt.Sprintf("%s %s", tokenType, token))
+	return nil
+}
 
-	resp, err := g.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to request userinfo: %w", err)
+// AuthenticateUserWithIDToken verifies the ID token signature with Google keys and returns the user it identifies.
+func (g *GoogleOAuthService) AuthenticateUserWithIDToken(ctx context.Context, idToken string) (*GoogleUserInfo, error) {
+	return g.userInfoFromIDToken(ctx, idToken, "")
+}
+
+func (g *GoogleOAuthService) client() oauthClient {
+	return oauthClient{clientID: g.ClientID, clientSecret: g.ClientSecret, redirectURL: g.ClientCallbackURL}
+}
+
+func (g *GoogleOAuthService) ID() string {
+	return GoogleProviderID
+}
+
+func (g *GoogleOAuthService) DisplayName() string {
+	return "Google"
+}
+
+// AuthCodeURL returns the Google URL where users log in for request.
+func (g *GoogleOAuthService) AuthCodeURL(_ context.Context, request *AuthorizationRequest) (string, error) {
+	extra := url.Values{}
+	if len(g.HostedDomain) > 0 {
+		extra.Set("hd", g.HostedDomain)
 	}
 
-	defer func() { _ = resp.Body.Close() }()
+	return authCodeURL(GoogleAuthURL, g.client(), googleScopes, request, extra), nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("expected 200 from https://openidconnect.googleapis.com/v1/userinfo and got %d: %w", resp.StatusCode, ErrGoogleAuthenticationFailed)
+// AuthenticateUserWithCode redeems the code returned to the callback of request and returns the user of its ID token.
+func (g *GoogleOAuthService) AuthenticateUserWithCode(ctx context.Context, code string, request *AuthorizationRequest) (*UserInfo, error) {
+	tokens, err := exchangeCode(ctx, g.httpClient, GoogleTokenURL, g.client(), code, request.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrGoogleAuthenticationFailed, err)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	googleUserInfo, err := g.userInfoFromIDToken(ctx, tokens.IDToken, request.Nonce)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read userinfo: %w", err)
+		return nil, err
 	}
 
-	log.Printf("Google Reply: %s", body)
+	return g.UserInfoWithGroups(ctx, googleUserInfo)
+}
 
-	var googleUserInfo GoogleUserInfo
+// UserInfoWithGroups returns the provider independent user with its Workspace groups, ID tokens do not carry them.
+func (g *GoogleOAuthService) UserInfoWithGroups(ctx context.Context, googleUserInfo *GoogleUserInfo) (*UserInfo, error) {
+	userInfo := googleUserInfo.UserInfo()
+	if g.Directory == nil {
+		return userInfo, nil
+	}
 
-	err = json.Unmarshal(body, &googleUserInfo)
+	groups, err := g.Directory.UserGroups(ctx, userInfo.Email)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse userinfo: %w", err)
+		return nil, fmt.Errorf("%w: could not read groups of %s: %v", ErrGoogleAuthenticationFailed, userInfo.Email, err)
 	}
 
-	if !helpers.IsEmailValid(googleUserInfo.Email) {
-		return nil, fmt.Errorf("invalid email '%s': %w", googleUserInfo.Email, ErrGoogleAuthenticationFailed)
-	}
+	userInfo.Groups = groups
+
+	return userInfo, nil
+}
 
-	return &googleUserInfo, nil
+// UserInfo returns the provider independent user.
+func (u *GoogleUserInfo) UserInfo() *UserInfo {
+	return &UserInfo{
+		Subject:      u.Sub,
+		Email:        u.Email,
+		Name:         u.Name,
+		Picture:      u.Picture,
+		HostedDomain: u.HD,
+	}
 }
 
-func (g *GoogleOAuthService) AuthenticateUserWithToken(ctx context.Context, tokenType string, token string) (*GoogleUserInfo, error) {
-	userInfo, err := g.fetchGoogleUserInfoWithToken(ctx, tokenType, token)
+// userInfoFromIDToken verifies idToken and, when nonce is set, that it was issued for the same request.
+func (g *GoogleOAuthService) userInfoFromIDToken(ctx context.Context, idToken string, nonce string) (*GoogleUserInfo, error) {
+	claims, err := g.idTokens.Verify(ctx, idToken, time.Now())
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrGoogleAuthenticationFailed, err)
 	}
 
-	return userInfo, nil
+	if len(nonce) > 0 && subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: id token nonce does not match the request", ErrGoogleAuthenticationFailed)
+	}
+
+	userInfo := GoogleUserInfo{
+		Sub:           claims.Subject,
+		Name:          claims.Name,
+		Picture:       claims.Picture,
+		Email:         claims.Email,
+		VerifiedEmail: claims.EmailVerified,
+		HD:            claims.HD,
+	}
+
+	if err := validateUserInfo(&userInfo); err != nil {
+		return nil, err
+	}
+
+	return &userInfo, nil
 }
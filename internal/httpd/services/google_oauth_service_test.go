@@ -1,128 +1,139 @@
 package services_test
 
 import (
-	"bytes"
 	"context"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/p-l/fringe/internal/httpd/services"
 	"github.com/p-l/fringe/internal/mocks"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestGoogleOAuthService_AuthenticateUserWithCode(t *testing.T) {
+func TestGoogleOAuthService_AuthenticateUserWithIDToken(t *testing.T) {
 	t.Parallel()
 
-	t.Run("Refuse authentication when token call fails", func(t *testing.T) {
+	t.Run("Accepts verified accounts without calling userinfo", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusTeapot,
-				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`)),
-				Header:     make(http.Header),
-			}
-		})
-
-		service := services.NewGoogleOAuthService(client, "client_id", "client_secret", "https://redirect.url/somewhere/callback")
-		googleUser, err := service.AuthenticateUserWithToken(context.Background(), "bearer", "code")
-		assert.Error(t, err)
-		assert.ErrorIs(t, err, services.ErrGoogleAuthenticationFailed)
-		assert.Nil(t, googleUser)
+		google := mocks.NewMockIdentityProvider(t, services.GoogleIssuer, "client_id", services.GoogleJWKSURL)
+		service := services.NewGoogleOAuthService(google.HTTPClient(nil), "client_id", "client_secret", "https://redirect.url/somewhere/callback")
+
+		token := google.SignIDToken(t, map[string]interface{}{"email": "email@domain.com", "email_verified": true, "hd": "domain.com", "name": "Person Name"})
+		googleUser, err := service.AuthenticateUserWithIDToken(context.Background(), token)
+		assert.NoError(t, err)
+		assert.Equal(t, "email@domain.com", googleUser.Email)
+		assert.Equal(t, "domain.com", googleUser.HD)
+		assert.Equal(t, "Person Name", googleUser.Name)
 	})
 
-	t.Run("Refuse authentication when user info call fails", func(t *testing.T) {
+	t.Run("Refuses unverified emails", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusTeapot,
-				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`)),
-				Header:     make(http.Header),
-			}
-		})
-
-		service := services.NewGoogleOAuthService(client, "client_id", "client_secret", "https://redirect.url/somewhere/callback")
-		googleUser, err := service.AuthenticateUserWithToken(context.Background(), "bearer", "code")
-		assert.Error(t, err)
+		google := mocks.NewMockIdentityProvider(t, services.GoogleIssuer, "client_id", services.GoogleJWKSURL)
+		service := services.NewGoogleOAuthService(google.HTTPClient(nil), "client_id", "client_secret", "https://redirect.url/somewhere/callback")
+
+		token := google.SignIDToken(t, map[string]interface{}{"email": "email@domain.com", "email_verified": false, "hd": "domain.com"})
+		googleUser, err := service.AuthenticateUserWithIDToken(context.Background(), token)
 		assert.ErrorIs(t, err, services.ErrGoogleAuthenticationFailed)
 		assert.Nil(t, googleUser)
 	})
 
-	t.Run("Refuses authentication on empty user info", func(t *testing.T) {
+	t.Run("Refuses tokens issued for other clients", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Body: ioutil.NopCloser(
-					bytes.NewBufferString(`{ "sub": "a_sub", "picture": "https://profile/picture/url", "hd": "domain.com" }`)),
-				Header: make(http.Header),
-			}
-		})
-
-		service := services.NewGoogleOAuthService(client, "client_id", "client_secret", "https://redirect.url/somewhere/callback")
-		googleUser, err := service.AuthenticateUserWithToken(context.Background(), "bearer", "code")
-		assert.Error(t, err)
+		google := mocks.NewMockIdentityProvider(t, services.GoogleIssuer, "other_client_id", services.GoogleJWKSURL)
+		service := services.NewGoogleOAuthService(google.HTTPClient(nil), "client_id", "client_secret", "https://redirect.url/somewhere/callback")
+
+		token := google.SignIDToken(t, map[string]interface{}{"email": "email@domain.com", "email_verified": true})
+		_, err := service.AuthenticateUserWithIDToken(context.Background(), token)
 		assert.ErrorIs(t, err, services.ErrGoogleAuthenticationFailed)
-		assert.Nil(t, googleUser)
 	})
+}
+
+func TestGoogleOAuthService_AuthCodeURL(t *testing.T) {
+	t.Parallel()
 
-	t.Run("Refuses authentication on malformed user info", func(t *testing.T) {
+	t.Run("Sends state, nonce and PKCE challenge", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Body: ioutil.NopCloser(
-					bytes.NewBufferString(`INVALID RESPONSE`)),
-				Header: make(http.Header),
-			}
-		})
-
-		service := services.NewGoogleOAuthService(client, "client_id", "client_secret", "https://redirect.url/somewhere/callback")
-		googleUser, err := service.AuthenticateUserWithToken(context.Background(), "bearer", "code")
-		assert.Error(t, err)
-		assert.ErrorIs(t, err, services.ErrGoogleAuthenticationFailed)
-		assert.Nil(t, googleUser)
+		service := services.NewGoogleOAuthService(http.DefaultClient, "client_id", "client_secret", "https://redirect.url/auth/google/callback")
+		request, err := services.NewAuthorizationRequests().Begin(services.GoogleProviderID, time.Now())
+		assert.NoError(t, err)
+
+		service.HostedDomain = "domain.com"
+
+		authURL, err := service.AuthCodeURL(context.Background(), request)
+		assert.NoError(t, err)
+
+		redirect, err := url.Parse(authURL)
+		assert.NoError(t, err)
+
+		query := redirect.Query()
+		assert.Equal(t, "accounts.google.com", redirect.Host)
+		assert.Equal(t, "code", query.Get("response_type"))
+		assert.Equal(t, "client_id", query.Get("client_id"))
+		assert.Equal(t, "https://redirect.url/auth/google/callback", query.Get("redirect_uri"))
+		assert.Equal(t, request.State, query.Get("state"))
+		assert.Equal(t, request.Nonce, query.Get("nonce"))
+		assert.Equal(t, request.CodeChallenge(), query.Get("code_challenge"))
+		assert.Equal(t, "S256", query.Get("code_challenge_method"))
+		assert.Equal(t, "domain.com", query.Get("hd"))
+		assert.Empty(t, query.Get("client_secret"))
 	})
+}
 
-	t.Run("Refuses authentication absent response", func(t *testing.T) {
+func TestGoogleOAuthService_AuthenticateUserWithCode(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Exchanges the code with the secret and code verifier", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Body:       nil,
-				Header:     make(http.Header),
-			}
-		})
-
-		service := services.NewGoogleOAuthService(client, "client_id", "client_secret", "https://redirect.url/somewhere/callback")
-		googleUser, err := service.AuthenticateUserWithToken(context.Background(), "bearer", "code")
-		assert.Error(t, err)
+		request, err := services.NewAuthorizationRequests().Begin(services.GoogleProviderID, time.Now())
+		assert.NoError(t, err)
+
+		google := mocks.NewMockIdentityProvider(t, services.GoogleIssuer, "client_id", services.GoogleJWKSURL)
+		claims := map[string]interface{}{"email": "email@domain.com", "email_verified": true, "nonce": request.Nonce}
+		service := services.NewGoogleOAuthService(google.HTTPClient(google.TokenEndpoint(t, services.GoogleTokenURL, claims)), "client_id", "client_secret", "https://redirect.url/auth/google/callback")
+
+		googleUser, err := service.AuthenticateUserWithCode(context.Background(), "the_code", request)
+		assert.NoError(t, err)
+		assert.Equal(t, "email@domain.com", googleUser.Email)
+
+		form := google.TokenRequest()
+		assert.Equal(t, "authorization_code", form.Get("grant_type"))
+		assert.Equal(t, "the_code", form.Get("code"))
+		assert.Equal(t, "client_secret", form.Get("client_secret"))
+		assert.Equal(t, request.CodeVerifier, form.Get("code_verifier"))
+		assert.Equal(t, "https://redirect.url/auth/google/callback", form.Get("redirect_uri"))
+	})
+
+	t.Run("Refuses ID tokens issued for another request", func(t *testing.T) {
+		t.Parallel()
+
+		request, err := services.NewAuthorizationRequests().Begin(services.GoogleProviderID, time.Now())
+		assert.NoError(t, err)
+
+		google := mocks.NewMockIdentityProvider(t, services.GoogleIssuer, "client_id", services.GoogleJWKSURL)
+		claims := map[string]interface{}{"email": "email@domain.com", "email_verified": true, "nonce": "other_nonce"}
+		service := services.NewGoogleOAuthService(google.HTTPClient(google.TokenEndpoint(t, services.GoogleTokenURL, claims)), "client_id", "client_secret", "callback")
+
+		_, err = service.AuthenticateUserWithCode(context.Background(), "the_code", request)
 		assert.ErrorIs(t, err, services.ErrGoogleAuthenticationFailed)
-		assert.Nil(t, googleUser)
 	})
 
-	t.Run("Accept authentication on user info", func(t *testing.T) {
+	t.Run("Refuses authentication when the code exchange fails", func(t *testing.T) {
 		t.Parallel()
 
-		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Body: ioutil.NopCloser(bytes.NewBufferString(
-					`{ "sub": "a_sub", "email": "email@domain.com", "email_verified": true, "picture": "https://profile/picture/url", "hd": "domain.com" }`)),
-				Header: make(http.Header),
-			}
-		})
-
-		service := services.NewGoogleOAuthService(client, "client_id", "client_secret", "https://redirect.url/somewhere/callback")
-		googleUser, err := service.AuthenticateUserWithToken(context.Background(), "bearer", "code")
+		request, err := services.NewAuthorizationRequests().Begin(services.GoogleProviderID, time.Now())
 		assert.NoError(t, err)
-		assert.NotNil(t, googleUser)
-		assert.Equalf(t, "email@domain.com", googleUser.Email, "Expect userinfo email to match email in JSON")
+
+		google := mocks.NewMockIdentityProvider(t, services.GoogleIssuer, "client_id", services.GoogleJWKSURL)
+		service := services.NewGoogleOAuthService(google.HTTPClient(nil), "client_id", "client_secret", "callback")
+
+		_, err = service.AuthenticateUserWithCode(context.Background(), "the_code", request)
+		assert.ErrorIs(t, err, services.ErrGoogleAuthenticationFailed)
 	})
 }
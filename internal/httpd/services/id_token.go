@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// audience is the aud claim, a single string or an array of strings.
+type audience []string
+
+// IDTokenClaims are the OpenID Connect claims used by fringe.
+type IDTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   bool     `json:"email_verified"`
+	Name            string   `json:"name"`
+	Picture         string   `json:"picture"`
+	HD              string   `json:"hd"`
+}
+
+// IDTokenVerifier checks ID tokens signed by an identity provider for the client.
+type IDTokenVerifier struct {
+	keys     *JWKS
+	clientID string
+	issuers  []string
+}
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// idTokenLeeway tolerates clock differences with the identity provider.
+const idTokenLeeway = time.Minute
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("invalid aud claim: %w", err)
+	}
+
+	*a = multiple
+
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Valid is required by jwt.Claims, the claims are validated by IDTokenVerifier.Verify.
+func (c *IDTokenClaims) Valid() error {
+	return nil
+}
+
+// NewIDTokenVerifier returns a verifier accepting tokens of clientID issued by one of issuers.
+func NewIDTokenVerifier(keys *JWKS, clientID string, issuers ...string) *IDTokenVerifier {
+	return &IDTokenVerifier{
+		keys:     keys,
+		clientID: clientID,
+		issuers:  issuers,
+	}
+}
+
+// Verify checks the signature, issuer, audience and expiry of the token and returns its claims.
+func (v *IDTokenVerifier) Verify(ctx context.Context, rawToken string, now time.Time) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	parser := jwt.Parser{ValidMethods: []string{"RS256", "ES256"}, SkipClaimsValidation: true}
+
+	_, err := parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		return v.keys.Key(ctx, kid, now)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if !v.isTrustedIssuer(claims.Issuer) {
+		return nil, fmt.Errorf("%w: untrusted issuer %s", ErrInvalidIDToken, claims.Issuer)
+	}
+
+	if !claims.Audience.contains(v.clientID) {
+		return nil, fmt.Errorf("%w: issued for another audience", ErrInvalidIDToken)
+	}
+
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != v.clientID {
+		return nil, fmt.Errorf("%w: issued for another party", ErrInvalidIDToken)
+	}
+
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(idTokenLeeway)) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+
+	if now.Add(idTokenLeeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+func (v *IDTokenVerifier) isTrustedIssuer(issuer string) bool {
+	for _, trusted := range v.issuers {
+		if issuer == trusted {
+			return true
+		}
+	}
+
+	return false
+}
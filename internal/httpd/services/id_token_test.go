@@ -0,0 +1,102 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testIssuer   = "https://idp.test.com"
+	testClientID = "client_id"
+	testJWKSURL  = "https://idp.test.com/certs"
+)
+
+func newTestVerifier(t *testing.T) (*services.IDTokenVerifier, *mocks.MockIdentityProvider) {
+	t.Helper()
+
+	provider := mocks.NewMockIdentityProvider(t, testIssuer, testClientID, testJWKSURL)
+	keys := services.NewJWKS(provider.HTTPClient(nil), testJWKSURL)
+
+	return services.NewIDTokenVerifier(keys, testClientID, testIssuer), provider
+}
+
+func TestIDTokenVerifier_Verify(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Accepts tokens signed by the provider", func(t *testing.T) {
+		t.Parallel()
+
+		verifier, provider := newTestVerifier(t)
+		token := provider.SignIDToken(t, map[string]interface{}{"email": "user@test.com", "email_verified": true, "hd": "test.com"})
+
+		claims, err := verifier.Verify(context.Background(), token, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", claims.Email)
+		assert.True(t, claims.EmailVerified)
+		assert.Equal(t, "test.com", claims.HD)
+	})
+
+	t.Run("Refuses other issuers, audiences and expired tokens", func(t *testing.T) {
+		t.Parallel()
+
+		verifier, provider := newTestVerifier(t)
+
+		for name, claims := range map[string]map[string]interface{}{
+			"issuer":   {"iss": "https://other.test.com"},
+			"audience": {"aud": "other_client"},
+			"parties":  {"aud": []string{testClientID, "other_client"}, "azp": "other_client"},
+			"expired":  {"exp": time.Now().Add(-time.Hour).Unix()},
+			"future":   {"iat": time.Now().Add(time.Hour).Unix()},
+		} {
+			_, err := verifier.Verify(context.Background(), provider.SignIDToken(t, claims), time.Now())
+			assert.ErrorIs(t, err, services.ErrInvalidIDToken, name)
+		}
+	})
+
+	t.Run("Refuses tokens signed by another key", func(t *testing.T) {
+		t.Parallel()
+
+		verifier, _ := newTestVerifier(t)
+		other := mocks.NewMockIdentityProvider(t, testIssuer, testClientID, testJWKSURL)
+
+		_, err := verifier.Verify(context.Background(), other.SignIDToken(t, nil), time.Now())
+		assert.ErrorIs(t, err, services.ErrInvalidIDToken)
+	})
+}
+
+func TestJWKS_Key(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Keys are cached", func(t *testing.T) {
+		t.Parallel()
+
+		provider := mocks.NewMockIdentityProvider(t, testIssuer, testClientID, testJWKSURL)
+		keys := services.NewJWKS(provider.HTTPClient(nil), testJWKSURL)
+		now := time.Now()
+
+		for i := 0; i < 3; i++ {
+			_, err := keys.Key(context.Background(), provider.KeyID, now)
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, int64(1), provider.JWKSRequests())
+
+		// Unknown keys refresh at most once per interval
+		_, err := keys.Key(context.Background(), "unknown", now)
+		assert.ErrorIs(t, err, services.ErrUnknownSigningKey)
+		assert.Equal(t, int64(1), provider.JWKSRequests())
+
+		_, _ = keys.Key(context.Background(), "unknown", now.Add(services.MinRefreshInterval))
+		assert.Equal(t, int64(2), provider.JWKSRequests())
+
+		// Keys expire after the max-age of the reply
+		_, err = keys.Key(context.Background(), provider.KeyID, now.Add(2*time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), provider.JWKSRequests())
+	})
+}
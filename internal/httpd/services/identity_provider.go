@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+)
+
+// LoginProvider is an identity provider listed on the login page.
+type LoginProvider interface {
+	// ID names the provider in the login and callback URLs.
+	ID() string
+	// DisplayName is shown on the login page.
+	DisplayName() string
+}
+
+// IdentityProvider logs users in with the authorization code flow, such as Google or an OpenID Connect provider.
+type IdentityProvider interface {
+	LoginProvider
+	// AuthCodeURL returns the provider URL where users log in for request.
+	AuthCodeURL(ctx context.Context, request *AuthorizationRequest) (string, error)
+	// AuthenticateUserWithCode redeems the code returned to the callback of request and returns the user it identifies.
+	AuthenticateUserWithCode(ctx context.Context, code string, request *AuthorizationRequest) (*UserInfo, error)
+}
+
+// UserInfo is the user identified by an identity provider.
+// HostedDomain is set by providers vouching that the account is managed by a domain (Google Workspace hd claim).
+// Role is the fringe role asserted by the provider, if any.
+type UserInfo struct {
+	Subject      string
+	Email        string
+	Name         string
+	Picture      string
+	Groups       []string
+	HostedDomain string
+	Role         string
+}
+
+// LoginPath returns the path starting the login with the provider named id.
+func LoginPath(id string) string {
+	return "/auth/" + id + "/login"
+}
+
+// CallbackPath returns the path where the provider named id returns users after login.
+func CallbackPath(id string) string {
+	return "/auth/" + id + "/callback"
+}
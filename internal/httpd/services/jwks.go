@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWKS fetches and caches the signing keys published by an identity provider.
+// Keys are kept for the max-age sent by the provider, an unknown kid refreshes them at most once per MinRefreshInterval.
+type JWKS struct {
+	URL        string
+	httpClient *http.Client
+
+	lock        sync.Mutex
+	keys        map[string]interface{}
+	expiresAt   time.Time
+	refreshedAt time.Time
+}
+
+// jsonWebKey is a single key of a JWKS document, only RSA and P-256 keys are used.
+type jsonWebKey struct {
+	KeyID   string `json:"kid"`
+	KeyType string `json:"kty"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+var (
+	ErrUnknownSigningKey = errors.New("unknown signing key")
+	errInvalidJWKS       = errors.New("invalid jwks")
+)
+
+const (
+	// JWKSDefaultMaxAge is how long keys are cached when the provider sends no max-age.
+	JWKSDefaultMaxAge = time.Hour
+	// MinRefreshInterval limits the refreshes caused by tokens signed with unknown keys.
+	MinRefreshInterval = time.Minute
+)
+
+func NewJWKS(httpClient *http.Client, url string) *JWKS {
+	return &JWKS{
+		URL:        url,
+		httpClient: httpClient,
+		keys:       map[string]interface{}{},
+	}
+}
+
+// Key returns the public key identified by kid, an *rsa.PublicKey or an *ecdsa.PublicKey.
+func (j *JWKS) Key(ctx context.Context, kid string, now time.Time) (interface{}, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	key, found := j.keys[kid]
+
+	stale := now.After(j.expiresAt)
+	if !found && now.Sub(j.refreshedAt) >= MinRefreshInterval {
+		stale = true
+	}
+
+	if stale {
+		if err := j.refresh(ctx, now); err != nil {
+			// Keep using the cached keys while the provider is unreachable
+			if !found {
+				return nil, err
+			}
+
+			return key, nil
+		}
+
+		key, found = j.keys[kid]
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSigningKey, kid)
+	}
+
+	return key, nil
+}
+
+func (j *JWKS) refresh(ctx context.Context, now time.Time) error {
+	j.refreshedAt = now
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to request jwks: %w", err)
+	}
+
+	resp, err := j.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request jwks: %w", err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: expected 200 from %s and got %d", errInvalidJWKS, j.URL, resp.StatusCode)
+	}
+
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidJWKS, err)
+	}
+
+	keys := map[string]interface{}{}
+
+	for _, webKey := range document.Keys {
+		if webKey.Use != "" && webKey.Use != "sig" {
+			continue
+		}
+
+		key, err := webKey.publicKey()
+		if err != nil {
+			// Providers may publish key types fringe does not use
+			continue
+		}
+
+		keys[webKey.KeyID] = key
+	}
+
+	j.keys = keys
+	j.expiresAt = now.Add(maxAge(resp.Header.Get("Cache-Control")))
+
+	return nil
+}
+
+// maxAge returns the max-age directive of the Cache-Control header, or JWKSDefaultMaxAge.
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return JWKSDefaultMaxAge
+}
+
+func decodeBase64URLInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidJWKS, err)
+	}
+
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		modulus, err := decodeBase64URLInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent, err := decodeBase64URLInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: modulus, E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("%w: unsupported curve %s", errInvalidJWKS, k.Curve)
+		}
+
+		x, err := decodeBase64URLInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBase64URLInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %s", errInvalidJWKS, k.KeyType)
+	}
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+)
+
+// ClaimMapping names the ID token claims holding the user email, name, picture and groups.
+type ClaimMapping struct {
+	Email   string
+	Name    string
+	Picture string
+	Groups  string
+}
+
+// OIDCProviderSettings is the registration of fringe with an OpenID Connect provider such as Keycloak, Okta or Entra ID.
+// Emails must be verified by the provider, AllowMissingEmailVerified also accepts ID tokens without email_verified
+// for providers, such as Entra ID, that never send it.
+type OIDCProviderSettings struct {
+	ID                        string
+	DisplayName               string
+	Issuer                    string
+	ClientID                  string
+	ClientSecret              string
+	RedirectURL               string
+	Scopes                    []string
+	Claims                    ClaimMapping
+	AllowMissingEmailVerified bool
+}
+
+// OIDCProvider logs users in with a provider discovered from its issuer URL.
+// Discovery happens on first use and is retried on failure so fringe starts while the provider is unreachable.
+type OIDCProvider struct {
+	settings   OIDCProviderSettings
+	httpClient *http.Client
+
+	lock      sync.Mutex
+	discovery *oidcDiscovery
+	idTokens  *IDTokenVerifier
+}
+
+// oidcDiscovery is the part of the provider metadata used by fringe.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var (
+	ErrOIDCAuthenticationFailed = errors.New("openid connect authentication failed")
+	ErrOIDCDiscoveryFailed      = errors.New("openid connect discovery failed")
+)
+
+// DiscoveryPath is appended to the issuer URL to fetch the provider metadata.
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+func NewOIDCProvider(httpClient *http.Client, settings OIDCProviderSettings) *OIDCProvider {
+	settings.Issuer = strings.TrimSuffix(settings.Issuer, "/")
+
+	return &OIDCProvider{
+		settings:   settings,
+		httpClient: httpClient,
+	}
+}
+
+func (p *OIDCProvider) ID() string {
+	return p.settings.ID
+}
+
+func (p *OIDCProvider) DisplayName() string {
+	return p.settings.DisplayName
+}
+
+func (p *OIDCProvider) client() oauthClient {
+	return oauthClient{clientID: p.settings.ClientID, clientSecret: p.settings.ClientSecret, redirectURL: p.settings.RedirectURL}
+}
+
+// discover returns the provider metadata, fetched once.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, *IDTokenVerifier, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, p.idTokens, nil
+	}
+
+	url := p.settings.Issuer + DiscoveryPath
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrOIDCDiscoveryFailed, err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrOIDCDiscoveryFailed, err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%w: expected 200 from %s and got %d", ErrOIDCDiscoveryFailed, url, resp.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrOIDCDiscoveryFailed, err)
+	}
+
+	// The metadata must be for the configured issuer (OpenID Connect Discovery 1.0 section 4.3)
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.settings.Issuer {
+		return nil, nil, fmt.Errorf("%w: metadata is for issuer %s", ErrOIDCDiscoveryFailed, discovery.Issuer)
+	}
+
+	if len(discovery.AuthorizationEndpoint) == 0 || len(discovery.TokenEndpoint) == 0 || len(discovery.JWKSURI) == 0 {
+		return nil, nil, fmt.Errorf("%w: missing endpoints in metadata", ErrOIDCDiscoveryFailed)
+	}
+
+	p.discovery = &discovery
+	p.idTokens = NewIDTokenVerifier(NewJWKS(p.httpClient, discovery.JWKSURI), p.settings.ClientID, discovery.Issuer)
+
+	return p.discovery, p.idTokens, nil
+}
+
+// AuthCodeURL returns the provider URL where users log in for request.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, request *AuthorizationRequest) (string, error) {
+	discovery, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return authCodeURL(discovery.AuthorizationEndpoint, p.client(), p.settings.Scopes, request, nil), nil
+}
+
+// AuthenticateUserWithCode redeems the code returned to the callback of request and maps the claims of its ID token.
+func (p *OIDCProvider) AuthenticateUserWithCode(ctx context.Context, code string, request *AuthorizationRequest) (*UserInfo, error) {
+	discovery, idTokens, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := exchangeCode(ctx, p.httpClient, discovery.TokenEndpoint, p.client(), code, request.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCAuthenticationFailed, err)
+	}
+
+	claims, err := idTokens.Verify(ctx, tokens.IDToken, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCAuthenticationFailed, err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(request.Nonce)) != 1 {
+		return nil, fmt.Errorf("%w: id token nonce does not match the request", ErrOIDCAuthenticationFailed)
+	}
+
+	// The signature was verified above, the claims are read again to follow the configured mapping
+	mapClaims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokens.IDToken, mapClaims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCAuthenticationFailed, err)
+	}
+
+	return p.userInfo(claims.Subject, mapClaims)
+}
+
+func (p *OIDCProvider) userInfo(subject string, claims jwt.MapClaims) (*UserInfo, error) {
+	mapping := p.settings.Claims
+	userInfo := UserInfo{
+		Subject: subject,
+		Email:   strings.ToLower(stringClaim(claims, mapping.Email)),
+		Name:    stringClaim(claims, mapping.Name),
+		Picture: stringClaim(claims, mapping.Picture),
+		Groups:  stringsClaim(claims, mapping.Groups),
+	}
+
+	if !helpers.IsEmailValid(userInfo.Email) {
+		return nil, fmt.Errorf("%w: invalid email '%s' in claim %s", ErrOIDCAuthenticationFailed, userInfo.Email, mapping.Email)
+	}
+
+	if !p.emailVerified(claims) {
+		return nil, fmt.Errorf("%w: email '%s' is not verified", ErrOIDCAuthenticationFailed, userInfo.Email)
+	}
+
+	return &userInfo, nil
+}
+
+// emailVerified returns true when the email_verified claim is true, or is missing and the provider is allowed to omit it.
+func (p *OIDCProvider) emailVerified(claims jwt.MapClaims) bool {
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return strings.EqualFold(verified, "true")
+	case nil:
+		return p.settings.AllowMissingEmailVerified
+	default:
+		return false
+	}
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+
+	return value
+}
+
+// stringsClaim reads a claim holding a single string or an array of strings.
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	default:
+		return []string{}
+	}
+}
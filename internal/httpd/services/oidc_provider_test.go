@@ -0,0 +1,156 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+const testOIDCIssuer = "https://sso.test.com/realms/main"
+
+// newTestOIDCProvider returns a provider registered with the mock identity provider, settings only set the claims
+// mapping and the email_verified requirement.
+func newTestOIDCProvider(t *testing.T, claims map[string]interface{}, settings services.OIDCProviderSettings) (*services.OIDCProvider, *mocks.MockIdentityProvider) {
+	t.Helper()
+
+	idp := mocks.NewMockIdentityProvider(t, testOIDCIssuer, testClientID, testOIDCIssuer+"/certs")
+	provider := services.NewOIDCProvider(idp.HTTPClient(idp.TokenEndpoint(t, idp.TokenURL(), claims)), services.OIDCProviderSettings{
+		ID:                        "keycloak",
+		DisplayName:               "Keycloak",
+		Issuer:                    testOIDCIssuer + "/",
+		ClientID:                  testClientID,
+		ClientSecret:              "client_secret",
+		RedirectURL:               "https://fringe.test.com/auth/keycloak/callback",
+		Scopes:                    []string{"openid", "email", "profile"},
+		Claims:                    settings.Claims,
+		AllowMissingEmailVerified: settings.AllowMissingEmailVerified,
+	})
+
+	return provider, idp
+}
+
+func TestOIDCProvider_AuthCodeURL(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Uses the discovered authorization endpoint", func(t *testing.T) {
+		t.Parallel()
+
+		provider, _ := newTestOIDCProvider(t, nil, services.OIDCProviderSettings{})
+		request, err := services.NewAuthorizationRequests().Begin("keycloak", time.Now())
+		assert.NoError(t, err)
+
+		authURL, err := provider.AuthCodeURL(context.Background(), request)
+		assert.NoError(t, err)
+
+		redirect, err := url.Parse(authURL)
+		assert.NoError(t, err)
+		assert.Equal(t, testOIDCIssuer+"/authorize", redirect.Scheme+"://"+redirect.Host+redirect.Path)
+		assert.Equal(t, "openid email profile", redirect.Query().Get("scope"))
+		assert.Equal(t, request.CodeChallenge(), redirect.Query().Get("code_challenge"))
+	})
+
+	t.Run("Refuses metadata of another issuer", func(t *testing.T) {
+		t.Parallel()
+
+		client := mocks.NewMockHTTPClient(func(req *http.Request) *http.Response {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"issuer": "https://evil.test.com", "authorization_endpoint": "a", "token_endpoint": "t", "jwks_uri": "j"}`)),
+				Header:     make(http.Header),
+			}
+		})
+		provider := services.NewOIDCProvider(client, services.OIDCProviderSettings{ID: "keycloak", Issuer: testOIDCIssuer})
+
+		request, err := services.NewAuthorizationRequests().Begin("keycloak", time.Now())
+		assert.NoError(t, err)
+
+		_, err = provider.AuthCodeURL(context.Background(), request)
+		assert.ErrorIs(t, err, services.ErrOIDCDiscoveryFailed)
+	})
+}
+
+func TestOIDCProvider_AuthenticateUserWithCode(t *testing.T) {
+	t.Parallel()
+
+	authenticate := func(t *testing.T, claims map[string]interface{}, settings services.OIDCProviderSettings) (*services.UserInfo, error) {
+		t.Helper()
+
+		request, err := services.NewAuthorizationRequests().Begin("keycloak", time.Now())
+		assert.NoError(t, err)
+
+		if _, found := claims["nonce"]; !found {
+			claims["nonce"] = request.Nonce
+		}
+
+		provider, _ := newTestOIDCProvider(t, claims, settings)
+
+		return provider.AuthenticateUserWithCode(context.Background(), "the_code", request)
+	}
+
+	standardClaims := services.OIDCProviderSettings{Claims: services.ClaimMapping{Email: "email", Name: "name", Picture: "picture", Groups: "groups"}}
+
+	t.Run("Maps standard claims", func(t *testing.T) {
+		t.Parallel()
+
+		userInfo, err := authenticate(t, map[string]interface{}{
+			"sub": "a_sub", "email": "Person@Test.com", "email_verified": true, "name": "Person Name", "picture": "https://picture", "groups": []string{"staff", "vpn"},
+		}, standardClaims)
+		assert.NoError(t, err)
+		assert.Equal(t, &services.UserInfo{
+			Subject: "a_sub",
+			Email:   "person@test.com",
+			Name:    "Person Name",
+			Picture: "https://picture",
+			Groups:  []string{"staff", "vpn"},
+		}, userInfo)
+	})
+
+	t.Run("Maps configured claims", func(t *testing.T) {
+		t.Parallel()
+
+		userInfo, err := authenticate(t, map[string]interface{}{
+			"preferred_username": "person@test.com", "email_verified": true, "display_name": "Person Name", "roles": "admins",
+		}, services.OIDCProviderSettings{Claims: services.ClaimMapping{Email: "preferred_username", Name: "display_name", Picture: "picture", Groups: "roles"}})
+		assert.NoError(t, err)
+		assert.Equal(t, "person@test.com", userInfo.Email)
+		assert.Equal(t, "Person Name", userInfo.Name)
+		assert.Equal(t, []string{"admins"}, userInfo.Groups)
+	})
+
+	t.Run("Refuses unverified or missing emails and replayed tokens", func(t *testing.T) {
+		t.Parallel()
+
+		for name, claims := range map[string]map[string]interface{}{
+			"unverified":           {"email": "person@test.com", "email_verified": false},
+			"unverified string":    {"email": "person@test.com", "email_verified": "false"},
+			"missing verification": {"email": "person@test.com"},
+			"missing email":        {"name": "Person Name", "email_verified": true},
+			"other nonce":          {"email": "person@test.com", "email_verified": true, "nonce": "other_nonce"},
+		} {
+			_, err := authenticate(t, claims, standardClaims)
+			assert.ErrorIs(t, err, services.ErrOIDCAuthenticationFailed, name)
+		}
+	})
+
+	t.Run("Accepts emails without verification claim when the provider is allowed to omit it", func(t *testing.T) {
+		t.Parallel()
+
+		settings := standardClaims
+		settings.AllowMissingEmailVerified = true
+
+		userInfo, err := authenticate(t, map[string]interface{}{"email": "person@test.com"}, settings)
+		assert.NoError(t, err)
+		assert.Equal(t, "person@test.com", userInfo.Email)
+
+		_, err = authenticate(t, map[string]interface{}{"email": "person@test.com", "email_verified": false}, settings)
+		assert.ErrorIs(t, err, services.ErrOIDCAuthenticationFailed)
+	})
+}
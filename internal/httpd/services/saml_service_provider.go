@@ -0,0 +1,427 @@
+package services
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+// SAMLAttributeMapping names the assertion attributes holding the user email, name, role and groups.
+// The email is read from the NameID when Email is empty.
+type SAMLAttributeMapping struct {
+	Email  string
+	Name   string
+	Role   string
+	Groups string
+}
+
+// SAMLSettings is the registration of fringe, identified by EntityID, with a SAML 2.0 identity provider.
+// Assertions are posted to ACSURL and must be signed by one of IdPCertificates.
+type SAMLSettings struct {
+	DisplayName     string
+	EntityID        string
+	ACSURL          string
+	IdPEntityID     string
+	IdPSSOURL       string
+	IdPCertificates []*x509.Certificate
+	Attributes      SAMLAttributeMapping
+}
+
+// SAMLServiceProvider logs users in with a SAML 2.0 identity provider using the HTTP-Redirect binding for requests
+// and the HTTP-POST binding for responses. Only responses to a pending request are accepted.
+type SAMLServiceProvider struct {
+	settings SAMLSettings
+}
+
+var (
+	ErrInvalidSAMLResponse = errors.New("invalid saml response")
+	ErrInvalidCertificate  = errors.New("invalid certificate")
+)
+
+const (
+	// SAMLProviderID names the SAML identity provider in the login URL.
+	SAMLProviderID = "saml"
+	// samlLeeway tolerates clock differences with the identity provider.
+	samlLeeway = time.Minute
+
+	samlMetadataNS        = "urn:oasis:names:tc:SAML:2.0:metadata"
+	samlProtocolNS        = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNS       = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlStatusSuccess     = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer            = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlHTTPPostBinding   = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlEmailNameIDFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+)
+
+func NewSAMLServiceProvider(settings SAMLSettings) *SAMLServiceProvider {
+	return &SAMLServiceProvider{settings: settings}
+}
+
+// ParseCertificates returns the certificates of a PEM file.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("%w: no certificate found", ErrInvalidCertificate)
+	}
+
+	return certificates, nil
+}
+
+func (s *SAMLServiceProvider) ID() string {
+	return SAMLProviderID
+}
+
+func (s *SAMLServiceProvider) DisplayName() string {
+	return s.settings.DisplayName
+}
+
+// Metadata returns the SP metadata document to register fringe with the identity provider.
+func (s *SAMLServiceProvider) Metadata() ([]byte, error) {
+	document := etree.NewDocument()
+	document.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+
+	descriptor := document.CreateElement("md:EntityDescriptor")
+	descriptor.CreateAttr("xmlns:md", samlMetadataNS)
+	descriptor.CreateAttr("entityID", s.settings.EntityID)
+
+	sp := descriptor.CreateElement("md:SPSSODescriptor")
+	sp.CreateAttr("AuthnRequestsSigned", "false")
+	sp.CreateAttr("WantAssertionsSigned", "true")
+	sp.CreateAttr("protocolSupportEnumeration", samlProtocolNS)
+	sp.CreateElement("md:NameIDFormat").SetText(samlEmailNameIDFormat)
+
+	acs := sp.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", samlHTTPPostBinding)
+	acs.CreateAttr("Location", s.settings.ACSURL)
+	acs.CreateAttr("index", "0")
+	acs.CreateAttr("isDefault", "true")
+
+	document.Indent(2)
+
+	metadata, err := document.WriteToBytes()
+	if err != nil {
+		return nil, fmt.Errorf("could not write metadata: %w", err)
+	}
+
+	return metadata, nil
+}
+
+// requestID is the AuthnRequest ID of request, xs:ID values cannot start with a digit.
+func requestID(request *AuthorizationRequest) string {
+	return "_" + request.State
+}
+
+// AuthnRequestURL returns the identity provider URL where users log in for request (HTTP-Redirect binding).
+func (s *SAMLServiceProvider) AuthnRequestURL(request *AuthorizationRequest, now time.Time) (string, error) {
+	document := etree.NewDocument()
+
+	authnRequest := document.CreateElement("samlp:AuthnRequest")
+	authnRequest.CreateAttr("xmlns:samlp", samlProtocolNS)
+	authnRequest.CreateAttr("xmlns:saml", samlAssertionNS)
+	authnRequest.CreateAttr("ID", requestID(request))
+	authnRequest.CreateAttr("Version", "2.0")
+	authnRequest.CreateAttr("IssueInstant", now.UTC().Format(time.RFC3339))
+	authnRequest.CreateAttr("Destination", s.settings.IdPSSOURL)
+	authnRequest.CreateAttr("AssertionConsumerServiceURL", s.settings.ACSURL)
+	authnRequest.CreateAttr("ProtocolBinding", samlHTTPPostBinding)
+	authnRequest.CreateElement("saml:Issuer").SetText(s.settings.EntityID)
+
+	policy := authnRequest.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("Format", samlEmailNameIDFormat)
+	policy.CreateAttr("AllowCreate", "true")
+
+	var deflated bytes.Buffer
+
+	writer, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return "", fmt.Errorf("could not compress authn request: %w", err)
+	}
+
+	if _, err := document.WriteTo(writer); err != nil {
+		return "", fmt.Errorf("could not write authn request: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("could not compress authn request: %w", err)
+	}
+
+	query := url.Values{"SAMLRequest": {base64.StdEncoding.EncodeToString(deflated.Bytes())}}
+
+	separator := "?"
+	if strings.Contains(s.settings.IdPSSOURL, "?") {
+		separator = "&"
+	}
+
+	return s.settings.IdPSSOURL + separator + query.Encode(), nil
+}
+
+// AuthenticateUserWithResponse verifies the base64 encoded response posted in answer to request and returns the user
+// of its assertion. The response or its assertion must be signed by the identity provider.
+func (s *SAMLServiceProvider) AuthenticateUserWithResponse(encodedResponse string, request *AuthorizationRequest, now time.Time) (*UserInfo, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encodedResponse)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLResponse, err)
+	}
+
+	document := etree.NewDocument()
+	if err := document.ReadFromBytes(decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLResponse, err)
+	}
+
+	response := document.Root()
+	if response == nil || response.Tag != "Response" || response.NamespaceURI() != samlProtocolNS {
+		return nil, fmt.Errorf("%w: not a saml response", ErrInvalidSAMLResponse)
+	}
+
+	if err := s.checkResponse(response, request); err != nil {
+		return nil, err
+	}
+
+	assertion, err := s.signedAssertion(response, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAssertion(assertion, request, now); err != nil {
+		return nil, err
+	}
+
+	return s.userInfo(assertion)
+}
+
+// checkResponse checks the status and, when present, the destination and request of the response.
+func (s *SAMLServiceProvider) checkResponse(response *etree.Element, request *AuthorizationRequest) error {
+	statusCode := samlChild(samlChild(response, samlProtocolNS, "Status"), samlProtocolNS, "StatusCode")
+	if statusCode == nil || statusCode.SelectAttrValue("Value", "") != samlStatusSuccess {
+		return fmt.Errorf("%w: identity provider did not authenticate the user", ErrInvalidSAMLResponse)
+	}
+
+	if destination := response.SelectAttrValue("Destination", ""); len(destination) > 0 && destination != s.settings.ACSURL {
+		return fmt.Errorf("%w: sent to %s", ErrInvalidSAMLResponse, destination)
+	}
+
+	if inResponseTo := response.SelectAttrValue("InResponseTo", ""); len(inResponseTo) > 0 && inResponseTo != requestID(request) {
+		return fmt.Errorf("%w: answers another request", ErrInvalidSAMLResponse)
+	}
+
+	return nil
+}
+
+// signedAssertion returns the assertion as covered by the signature of the response or of the assertion itself.
+// Only the signed content is read, elements wrapped around it are ignored.
+func (s *SAMLServiceProvider) signedAssertion(response *etree.Element, now time.Time) (*etree.Element, error) {
+	validation := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: s.settings.IdPCertificates})
+	validation.Clock = dsig.NewFakeClockAt(now)
+
+	if len(samlChildren(response, samlAssertionNS, "EncryptedAssertion")) > 0 {
+		return nil, fmt.Errorf("%w: encrypted assertions are not supported", ErrInvalidSAMLResponse)
+	}
+
+	signed := response
+
+	if samlChild(response, dsig.Namespace, dsig.SignatureTag) != nil {
+		validated, err := validation.Validate(response)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLResponse, err)
+		}
+
+		signed = validated
+	}
+
+	assertions := samlChildren(signed, samlAssertionNS, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("%w: expected one assertion and got %d", ErrInvalidSAMLResponse, len(assertions))
+	}
+
+	assertion := assertions[0]
+
+	if signed == response {
+		if samlChild(assertion, dsig.Namespace, dsig.SignatureTag) == nil {
+			return nil, fmt.Errorf("%w: neither the response nor the assertion is signed", ErrInvalidSAMLResponse)
+		}
+
+		// Namespaces declared on the response must be kept to validate the assertion alone
+		context, err := etreeutils.NSBuildParentContext(assertion)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLResponse, err)
+		}
+
+		detached, err := etreeutils.NSDetatch(context, assertion)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLResponse, err)
+		}
+
+		assertion, err = validation.Validate(detached)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLResponse, err)
+		}
+	}
+
+	return assertion, nil
+}
+
+// checkAssertion checks the issuer, audience, validity period and bearer confirmation of the assertion.
+func (s *SAMLServiceProvider) checkAssertion(assertion *etree.Element, request *AuthorizationRequest, now time.Time) error {
+	issuer := samlChild(assertion, samlAssertionNS, "Issuer")
+	if issuer == nil || strings.TrimSpace(issuer.Text()) != s.settings.IdPEntityID {
+		return fmt.Errorf("%w: untrusted issuer", ErrInvalidSAMLResponse)
+	}
+
+	conditions := samlChild(assertion, samlAssertionNS, "Conditions")
+	if conditions == nil {
+		return fmt.Errorf("%w: missing conditions", ErrInvalidSAMLResponse)
+	}
+
+	if err := checkValidityPeriod(conditions, now); err != nil {
+		return err
+	}
+
+	audienceFound := false
+
+	for _, restriction := range samlChildren(conditions, samlAssertionNS, "AudienceRestriction") {
+		for _, audience := range samlChildren(restriction, samlAssertionNS, "Audience") {
+			audienceFound = audienceFound || strings.TrimSpace(audience.Text()) == s.settings.EntityID
+		}
+	}
+
+	if !audienceFound {
+		return fmt.Errorf("%w: issued for another audience", ErrInvalidSAMLResponse)
+	}
+
+	subject := samlChild(assertion, samlAssertionNS, "Subject")
+	if subject == nil {
+		return fmt.Errorf("%w: missing subject", ErrInvalidSAMLResponse)
+	}
+
+	for _, confirmation := range samlChildren(subject, samlAssertionNS, "SubjectConfirmation") {
+		data := samlChild(confirmation, samlAssertionNS, "SubjectConfirmationData")
+		if confirmation.SelectAttrValue("Method", "") != samlBearer || data == nil {
+			continue
+		}
+
+		if data.SelectAttrValue("Recipient", "") != s.settings.ACSURL || data.SelectAttrValue("InResponseTo", "") != requestID(request) {
+			continue
+		}
+
+		if checkValidityPeriod(data, now) == nil && len(data.SelectAttrValue("NotOnOrAfter", "")) > 0 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: no bearer confirmation for this request", ErrInvalidSAMLResponse)
+}
+
+// checkValidityPeriod checks the NotBefore and NotOnOrAfter attributes of element.
+func checkValidityPeriod(element *etree.Element, now time.Time) error {
+	if notBefore := element.SelectAttrValue("NotBefore", ""); len(notBefore) > 0 {
+		instant, err := time.Parse(time.RFC3339, notBefore)
+		if err != nil || now.Add(samlLeeway).Before(instant) {
+			return fmt.Errorf("%w: not yet valid", ErrInvalidSAMLResponse)
+		}
+	}
+
+	if notOnOrAfter := element.SelectAttrValue("NotOnOrAfter", ""); len(notOnOrAfter) > 0 {
+		instant, err := time.Parse(time.RFC3339, notOnOrAfter)
+		if err != nil || !now.Add(-samlLeeway).Before(instant) {
+			return fmt.Errorf("%w: expired", ErrInvalidSAMLResponse)
+		}
+	}
+
+	return nil
+}
+
+func (s *SAMLServiceProvider) userInfo(assertion *etree.Element) (*UserInfo, error) {
+	attributes := map[string][]string{}
+
+	for _, statement := range samlChildren(assertion, samlAssertionNS, "AttributeStatement") {
+		for _, attribute := range samlChildren(statement, samlAssertionNS, "Attribute") {
+			name := attribute.SelectAttrValue("Name", "")
+			for _, value := range samlChildren(attribute, samlAssertionNS, "AttributeValue") {
+				attributes[name] = append(attributes[name], strings.TrimSpace(value.Text()))
+			}
+		}
+	}
+
+	nameID := samlChild(samlChild(assertion, samlAssertionNS, "Subject"), samlAssertionNS, "NameID")
+
+	userInfo := UserInfo{
+		Name:   firstValue(attributes, s.settings.Attributes.Name),
+		Role:   firstValue(attributes, s.settings.Attributes.Role),
+		Groups: append([]string{}, attributes[s.settings.Attributes.Groups]...),
+	}
+
+	if nameID != nil {
+		userInfo.Subject = strings.TrimSpace(nameID.Text())
+	}
+
+	userInfo.Email = userInfo.Subject
+	if len(s.settings.Attributes.Email) > 0 {
+		userInfo.Email = firstValue(attributes, s.settings.Attributes.Email)
+	}
+
+	userInfo.Email = strings.ToLower(userInfo.Email)
+
+	if !helpers.IsEmailValid(userInfo.Email) {
+		return nil, fmt.Errorf("%w: invalid email '%s'", ErrInvalidSAMLResponse, userInfo.Email)
+	}
+
+	return &userInfo, nil
+}
+
+func firstValue(attributes map[string][]string, name string) string {
+	if values := attributes[name]; len(name) > 0 && len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// samlChildren returns the child elements of el named tag in namespace, whatever their prefix.
+func samlChildren(el *etree.Element, namespace string, tag string) []*etree.Element {
+	children := make([]*etree.Element, 0)
+	if el == nil {
+		return children
+	}
+
+	for _, child := range el.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == namespace {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+func samlChild(el *etree.Element, namespace string, tag string) *etree.Element {
+	if children := samlChildren(el, namespace, tag); len(children) > 0 {
+		return children[0]
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package services_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSAMLEntityID    = "https://fringe.test.com/auth/saml/metadata"
+	testSAMLACSURL      = "https://fringe.test.com/auth/saml/acs"
+	testSAMLIdPEntityID = "https://idp.test.com/saml"
+	testSAMLIdPSSOURL   = "https://idp.test.com/saml/sso"
+)
+
+func newTestSAMLServiceProvider(t *testing.T) (*services.SAMLServiceProvider, *mocks.MockSAMLIdentityProvider) {
+	t.Helper()
+
+	idp := mocks.NewMockSAMLIdentityProvider(t, testSAMLIdPEntityID)
+	certificates, err := services.ParseCertificates(idp.CertificatePEM())
+	assert.NoError(t, err)
+
+	sp := services.NewSAMLServiceProvider(services.SAMLSettings{
+		DisplayName:     "Okta",
+		EntityID:        testSAMLEntityID,
+		ACSURL:          testSAMLACSURL,
+		IdPEntityID:     testSAMLIdPEntityID,
+		IdPSSOURL:       testSAMLIdPSSOURL,
+		IdPCertificates: certificates,
+		Attributes:      services.SAMLAttributeMapping{Email: "email", Name: "displayName", Role: "role", Groups: "groups"},
+	})
+
+	return sp, idp
+}
+
+func testSAMLAssertion(request *services.AuthorizationRequest) mocks.SAMLAssertion {
+	return mocks.SAMLAssertion{
+		InResponseTo: "_" + request.State,
+		Recipient:    testSAMLACSURL,
+		Audience:     testSAMLEntityID,
+		NameID:       "00u1ab2cd3",
+		Attributes:   map[string]string{"email": "User@Test.com", "displayName": "Test User", "role": "admin"},
+		Groups:       []string{"staff", "vpn-users"},
+	}
+}
+
+func TestParseCertificates(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Reads the certificates of a PEM file", func(t *testing.T) {
+		t.Parallel()
+
+		idp := mocks.NewMockSAMLIdentityProvider(t, testSAMLIdPEntityID)
+		certificates, err := services.ParseCertificates(append(idp.CertificatePEM(), idp.CertificatePEM()...))
+		assert.NoError(t, err)
+		assert.Len(t, certificates, 2)
+	})
+
+	t.Run("Fails without certificate", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := services.ParseCertificates([]byte("not a certificate"))
+		assert.ErrorIs(t, err, services.ErrInvalidCertificate)
+	})
+}
+
+func TestSAMLServiceProvider_Metadata(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Describes the entity and assertion consumer service", func(t *testing.T) {
+		t.Parallel()
+
+		sp, _ := newTestSAMLServiceProvider(t)
+		metadata, err := sp.Metadata()
+		assert.NoError(t, err)
+		assert.Contains(t, string(metadata), `entityID="`+testSAMLEntityID+`"`)
+		assert.Contains(t, string(metadata), `Location="`+testSAMLACSURL+`"`)
+		assert.Contains(t, string(metadata), `Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"`)
+	})
+}
+
+func TestSAMLServiceProvider_AuthnRequestURL(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Redirects to the identity provider with a deflated request", func(t *testing.T) {
+		t.Parallel()
+
+		sp, _ := newTestSAMLServiceProvider(t)
+		request, err := services.NewAuthorizationRequests().Begin(services.SAMLProviderID, time.Now())
+		assert.NoError(t, err)
+
+		requestURL, err := sp.AuthnRequestURL(request, time.Now())
+		assert.NoError(t, err)
+
+		redirect, err := url.Parse(requestURL)
+		assert.NoError(t, err)
+		assert.Equal(t, testSAMLIdPSSOURL, redirect.Scheme+"://"+redirect.Host+redirect.Path)
+
+		deflated, err := base64.StdEncoding.DecodeString(redirect.Query().Get("SAMLRequest"))
+		assert.NoError(t, err)
+
+		inflated, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+		assert.NoError(t, err)
+		assert.Contains(t, string(inflated), `ID="_`+request.State+`"`)
+		assert.Contains(t, string(inflated), `AssertionConsumerServiceURL="`+testSAMLACSURL+`"`)
+		assert.Contains(t, string(inflated), testSAMLEntityID)
+	})
+}
+
+func TestSAMLServiceProvider_AuthenticateUserWithResponse(t *testing.T) {
+	t.Parallel()
+
+	begin := func(t *testing.T) *services.AuthorizationRequest {
+		t.Helper()
+
+		request, err := services.NewAuthorizationRequests().Begin(services.SAMLProviderID, time.Now())
+		assert.NoError(t, err)
+
+		return request
+	}
+
+	t.Run("Maps the attributes of a signed assertion", func(t *testing.T) {
+		t.Parallel()
+
+		sp, idp := newTestSAMLServiceProvider(t)
+		request := begin(t)
+
+		userInfo, err := sp.AuthenticateUserWithResponse(idp.Response(t, testSAMLAssertion(request), mocks.SAMLSignAssertion), request, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, "00u1ab2cd3", userInfo.Subject)
+		assert.Equal(t, "user@test.com", userInfo.Email)
+		assert.Equal(t, "Test User", userInfo.Name)
+		assert.Equal(t, "admin", userInfo.Role)
+		assert.Equal(t, []string{"staff", "vpn-users"}, userInfo.Groups)
+	})
+
+	t.Run("Accepts a signed response", func(t *testing.T) {
+		t.Parallel()
+
+		sp, idp := newTestSAMLServiceProvider(t)
+		request := begin(t)
+
+		userInfo, err := sp.AuthenticateUserWithResponse(idp.Response(t, testSAMLAssertion(request), mocks.SAMLSignResponse), request, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", userInfo.Email)
+	})
+
+	t.Run("Reads the email from the NameID without mapping", func(t *testing.T) {
+		t.Parallel()
+
+		idp := mocks.NewMockSAMLIdentityProvider(t, testSAMLIdPEntityID)
+		sp := services.NewSAMLServiceProvider(services.SAMLSettings{
+			EntityID:        testSAMLEntityID,
+			ACSURL:          testSAMLACSURL,
+			IdPEntityID:     testSAMLIdPEntityID,
+			IdPCertificates: []*x509.Certificate{idp.Certificate},
+		})
+		request := begin(t)
+		assertion := testSAMLAssertion(request)
+		assertion.NameID = "Name.ID@test.com"
+
+		userInfo, err := sp.AuthenticateUserWithResponse(idp.Response(t, assertion, mocks.SAMLSignAssertion), request, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, "name.id@test.com", userInfo.Email)
+		assert.Empty(t, userInfo.Role)
+	})
+
+	t.Run("Refuses invalid responses", func(t *testing.T) {
+		t.Parallel()
+
+		sp, idp := newTestSAMLServiceProvider(t)
+		otherIdP := mocks.NewMockSAMLIdentityProvider(t, testSAMLIdPEntityID)
+
+		tests := []struct {
+			name     string
+			response func(t *testing.T, request *services.AuthorizationRequest) string
+		}{
+			{
+				name: "unsigned",
+				response: func(t *testing.T, request *services.AuthorizationRequest) string {
+					return idp.Response(t, testSAMLAssertion(request), mocks.SAMLSignNothing)
+				},
+			},
+			{
+				name: "signed with another key",
+				response: func(t *testing.T, request *services.AuthorizationRequest) string {
+					return otherIdP.Response(t, testSAMLAssertion(request), mocks.SAMLSignAssertion)
+				},
+			},
+			{
+				name: "answering another request",
+				response: func(t *testing.T, request *services.AuthorizationRequest) string {
+					return idp.Response(t, testSAMLAssertion(begin(t)), mocks.SAMLSignAssertion)
+				},
+			},
+			{
+				name: "issued for another audience",
+				response: func(t *testing.T, request *services.AuthorizationRequest) string {
+					assertion := testSAMLAssertion(request)
+					assertion.Audience = "https://other.test.com"
+
+					return idp.Response(t, assertion, mocks.SAMLSignAssertion)
+				},
+			},
+			{
+				name: "issued by another identity provider",
+				response: func(t *testing.T, request *services.AuthorizationRequest) string {
+					assertion := testSAMLAssertion(request)
+					assertion.Issuer = "https://other.test.com"
+
+					return idp.Response(t, assertion, mocks.SAMLSignAssertion)
+				},
+			},
+			{
+				name: "expired",
+				response: func(t *testing.T, request *services.AuthorizationRequest) string {
+					assertion := testSAMLAssertion(request)
+					assertion.NotBefore = time.Now().Add(-time.Hour)
+					assertion.NotOnOrAfter = time.Now().Add(-10 * time.Minute)
+
+					return idp.Response(t, assertion, mocks.SAMLSignAssertion)
+				},
+			},
+			{
+				name: "tampered after signature",
+				response: func(t *testing.T, request *services.AuthorizationRequest) string {
+					decoded, err := base64.StdEncoding.DecodeString(idp.Response(t, testSAMLAssertion(request), mocks.SAMLSignAssertion))
+					assert.NoError(t, err)
+
+					return base64.StdEncoding.EncodeToString([]byte(strings.Replace(string(decoded), "User@Test.com", "admin@test.com", 1)))
+				},
+			},
+			{
+				name: "not base64",
+				response: func(t *testing.T, request *services.AuthorizationRequest) string {
+					return "<samlp:Response/>"
+				},
+			},
+		}
+
+		for _, test := range tests {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
+				request := begin(t)
+				_, err := sp.AuthenticateUserWithResponse(test.response(t, request), request, time.Now())
+				assert.ErrorIs(t, err, services.ErrInvalidSAMLResponse)
+			})
+		}
+	})
+}
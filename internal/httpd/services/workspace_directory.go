@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// GroupDirectory returns the groups a user is a member of.
+type GroupDirectory interface {
+	UserGroups(ctx context.Context, email string) ([]string, error)
+}
+
+// UserDirectory lists the accounts of a domain.
+type UserDirectory interface {
+	Users(ctx context.Context, domain string) ([]DirectoryUser, error)
+}
+
+// DirectoryUser is an account of the directory, Suspended is also set for archived accounts.
+type DirectoryUser struct {
+	Email     string
+	Aliases   []string
+	Name      string
+	Picture   string
+	Suspended bool
+}
+
+// ServiceAccountKey is the part of a Google service account JSON key used by fringe.
+type ServiceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// WorkspaceDirectory reads the Google Workspace directory with a service account granted domain-wide delegation.
+// The service account acts as Subject, a Workspace administrator allowed to read the directory.
+type WorkspaceDirectory struct {
+	httpClient *http.Client
+	key        ServiceAccountKey
+	privateKey *rsa.PrivateKey
+	subject    string
+
+	lock        sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+var (
+	ErrInvalidServiceAccount = errors.New("invalid service account key")
+	ErrDirectoryRequest      = errors.New("directory request failed")
+)
+
+const (
+	// WorkspaceDirectoryURL is the Admin SDK Directory API.
+	WorkspaceDirectoryURL = "https://admin.googleapis.com/admin/directory/v1"
+	// WorkspaceDirectoryScopes are the scopes to delegate to the service account.
+	WorkspaceDirectoryScopes = "https://www.googleapis.com/auth/admin.directory.group.readonly https://www.googleapis.com/auth/admin.directory.user.readonly"
+
+	serviceAccountTokenLifetime = time.Hour
+	// accessTokenLeeway renews access tokens before they expire during a request.
+	accessTokenLeeway = time.Minute
+	directoryPageSize = "200"
+	usersPageSize     = "500"
+)
+
+// NewWorkspaceDirectory returns a directory reading as subject with the service account JSON key.
+func NewWorkspaceDirectory(httpClient *http.Client, serviceAccountJSON []byte, subject string) (*WorkspaceDirectory, error) {
+	var key ServiceAccountKey
+	if err := json.Unmarshal(serviceAccountJSON, &key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidServiceAccount, err)
+	}
+
+	if len(key.ClientEmail) == 0 || len(key.TokenURI) == 0 {
+		return nil, fmt.Errorf("%w: client_email and token_uri are required", ErrInvalidServiceAccount)
+	}
+
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(key.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidServiceAccount, err)
+	}
+
+	return &WorkspaceDirectory{
+		httpClient: httpClient,
+		key:        key,
+		privateKey: privateKey,
+		subject:    subject,
+	}, nil
+}
+
+// token returns an access token, obtained with a signed assertion (RFC 7523) and reused until it expires.
+func (d *WorkspaceDirectory) token(ctx context.Context) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	if len(d.accessToken) > 0 && now.Add(accessTokenLeeway).Before(d.expiresAt) {
+		return d.accessToken, nil
+	}
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   d.key.ClientEmail,
+		"sub":   d.subject,
+		"scope": WorkspaceDirectoryScopes,
+		"aud":   d.key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(serviceAccountTokenLifetime).Unix(),
+	}).SignedString(d.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("%w: could not sign assertion: %v", ErrDirectoryRequest, err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDirectoryRequest, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	if err := d.do(req, &tokens); err != nil {
+		return "", err
+	}
+
+	if len(tokens.AccessToken) == 0 {
+		return "", fmt.Errorf("%w: no access_token in response", ErrDirectoryRequest)
+	}
+
+	d.accessToken = tokens.AccessToken
+	d.expiresAt = now.Add(time.Duration(tokens.ExpiresIn) * time.Second)
+
+	return d.accessToken, nil
+}
+
+// get decodes the JSON reply of the directory API at path with query.
+func (d *WorkspaceDirectory) get(ctx context.Context, path string, query url.Values, reply interface{}) error {
+	token, err := d.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, WorkspaceDirectoryURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDirectoryRequest, err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return d.do(req, reply)
+}
+
+func (d *WorkspaceDirectory) do(req *http.Request, reply interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDirectoryRequest, err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: expected 200 from %s and got %d", ErrDirectoryRequest, req.URL.Path, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		return fmt.Errorf("%w: %v", ErrDirectoryRequest, err)
+	}
+
+	return nil
+}
+
+// UserGroups returns the email of the groups the user is a direct member of.
+func (d *WorkspaceDirectory) UserGroups(ctx context.Context, email string) ([]string, error) {
+	groups := make([]string, 0)
+	query := url.Values{"userKey": {email}, "maxResults": {directoryPageSize}}
+
+	for {
+		var page struct {
+			Groups []struct {
+				Email string `json:"email"`
+			} `json:"groups"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+
+		if err := d.get(ctx, "/groups", query, &page); err != nil {
+			return nil, err
+		}
+
+		for _, group := range page.Groups {
+			groups = append(groups, strings.ToLower(group.Email))
+		}
+
+		if len(page.NextPageToken) == 0 {
+			return groups, nil
+		}
+
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// Users returns every account of the domain.
+func (d *WorkspaceDirectory) Users(ctx context.Context, domain string) ([]DirectoryUser, error) {
+	users := make([]DirectoryUser, 0)
+	query := url.Values{"domain": {domain}, "maxResults": {usersPageSize}, "projection": {"basic"}}
+
+	for {
+		var page struct {
+			Users []struct {
+				PrimaryEmail string   `json:"primaryEmail"`
+				Aliases      []string `json:"aliases"`
+				Name         struct {
+					FullName string `json:"fullName"`
+				} `json:"name"`
+				ThumbnailPhotoURL string `json:"thumbnailPhotoUrl"`
+				Suspended         bool   `json:"suspended"`
+				Archived          bool   `json:"archived"`
+			} `json:"users"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+
+		if err := d.get(ctx, "/users", query, &page); err != nil {
+			return nil, err
+		}
+
+		for _, user := range page.Users {
+			users = append(users, DirectoryUser{
+				Email:     strings.ToLower(user.PrimaryEmail),
+				Aliases:   user.Aliases,
+				Name:      user.Name.FullName,
+				Picture:   user.ThumbnailPhotoURL,
+				Suspended: user.Suspended || user.Archived,
+			})
+		}
+
+		if len(page.NextPageToken) == 0 {
+			return users, nil
+		}
+
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
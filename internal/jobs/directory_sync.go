@@ -0,0 +1,226 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+type DirectorySyncAction string
+
+const (
+	DirectorySyncActionDisable DirectorySyncAction = "disable"
+	DirectorySyncActionProfile DirectorySyncAction = "profile"
+)
+
+const (
+	// DirectorySyncReasonSuspended is given for accounts suspended or archived in the directory.
+	DirectorySyncReasonSuspended = "suspended"
+	// DirectorySyncReasonMissing is given for accounts deleted from the directory, or never part of it.
+	DirectorySyncReasonMissing = "missing"
+)
+
+// DirectorySyncActor is the audit log actor for changes made by the directory sync.
+const DirectorySyncActor = "directory-sync"
+
+// ErrEmptyDirectory is returned instead of disabling every user when the directory lists no account.
+var ErrEmptyDirectory = errors.New("directory returned no account")
+
+// DirectorySyncChange is a change to a user. Reason explains a disable action, Name and Picture are the new profile.
+type DirectorySyncChange struct {
+	Email   string              `json:"email"`
+	Action  DirectorySyncAction `json:"action"`
+	Reason  string              `json:"reason,omitempty"`
+	Name    string              `json:"name,omitempty"`
+	Picture string              `json:"picture,omitempty"`
+	Error   string              `json:"error,omitempty"`
+}
+
+type DirectorySyncReport struct {
+	DryRun  bool                  `json:"dry_run"`
+	RanAt   int64                 `json:"ran_at"`
+	Checked int                   `json:"checked"`
+	Changes []DirectorySyncChange `json:"changes"`
+}
+
+// DirectorySync deprovisions users of domain who are suspended or missing from the directory, unless listed in
+// exclude, and copies their name and picture from the directory. Users of other domains are left alone.
+type DirectorySync struct {
+	userRepo  *repos.UserRepository
+	auditRepo *repos.AuditRepository
+	directory services.UserDirectory
+	domain    string
+	excluded  map[string]bool
+	sessions  *UserSessions
+
+	lock    sync.Mutex
+	lastRun *DirectorySyncReport
+}
+
+// NewDirectorySync returns a DirectorySync listing the accounts of domain in directory.
+func NewDirectorySync(userRepo *repos.UserRepository, auditRepo *repos.AuditRepository, directory services.UserDirectory, domain string, exclude []string) *DirectorySync {
+	excluded := map[string]bool{}
+	for _, email := range exclude {
+		excluded[strings.ToLower(email)] = true
+	}
+
+	return &DirectorySync{
+		userRepo:  userRepo,
+		auditRepo: auditRepo,
+		directory: directory,
+		domain:    strings.ToLower(domain),
+		excluded:  excluded,
+	}
+}
+
+// Plan returns the changes the sync would make and how many users were checked.
+func (s *DirectorySync) Plan(ctx context.Context) ([]DirectorySyncChange, int, error) {
+	users, err := enabledUsers(ctx, s.userRepo)
+	if err != nil {
+		return nil, 0, fmt.Errorf("directory sync could not list users: %w", err)
+	}
+
+	accounts, err := s.directory.Users(ctx, s.domain)
+	if err != nil {
+		return nil, 0, fmt.Errorf("directory sync could not list accounts: %w", err)
+	}
+
+	// An empty directory is a misconfiguration rather than a domain without anybody left
+	if len(accounts) == 0 {
+		return nil, 0, ErrEmptyDirectory
+	}
+
+	directory := make(map[string]services.DirectoryUser, len(accounts))
+
+	for _, account := range accounts {
+		directory[strings.ToLower(account.Email)] = account
+		for _, alias := range account.Aliases {
+			directory[strings.ToLower(alias)] = account
+		}
+	}
+
+	checked := 0
+	changes := make([]DirectorySyncChange, 0)
+
+	for _, user := range users {
+		email := strings.ToLower(user.Email)
+		if !strings.HasSuffix(email, "@"+s.domain) {
+			continue
+		}
+
+		checked++
+
+		account, found := directory[email]
+
+		switch {
+		case s.excluded[email] && (!found || account.Suspended):
+			continue
+		case !found:
+			changes = append(changes, DirectorySyncChange{Email: user.Email, Action: DirectorySyncActionDisable, Reason: DirectorySyncReasonMissing})
+		case account.Suspended:
+			changes = append(changes, DirectorySyncChange{Email: user.Email, Action: DirectorySyncActionDisable, Reason: DirectorySyncReasonSuspended})
+		default:
+			// Accounts without a name or picture in the directory keep the ones from their last login
+			name, picture := user.Name, user.Picture
+			if len(account.Name) > 0 {
+				name = account.Name
+			}
+
+			if len(account.Picture) > 0 {
+				picture = account.Picture
+			}
+
+			if name != user.Name || picture != user.Picture {
+				changes = append(changes, DirectorySyncChange{Email: user.Email, Action: DirectorySyncActionProfile, Name: name, Picture: picture})
+			}
+		}
+	}
+
+	return changes, checked, nil
+}
+
+// Report returns the plan without applying it.
+func (s *DirectorySync) Report(ctx context.Context, now time.Time) (*DirectorySyncReport, error) {
+	changes, checked, err := s.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirectorySyncReport{DryRun: true, RanAt: now.Unix(), Checked: checked, Changes: changes}, nil
+}
+
+// Run applies the plan. A failure on a user is reported and does not stop the others from being processed.
+func (s *DirectorySync) Run(ctx context.Context, now time.Time) (*DirectorySyncReport, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	changes, checked, err := s.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, change := range changes {
+		if err := s.apply(ctx, change); err != nil {
+			log.Printf("DirectorySync: could not %s %s: %v", change.Action, change.Email, err)
+			changes[index].Error = err.Error()
+		}
+	}
+
+	s.lastRun = &DirectorySyncReport{DryRun: false, RanAt: now.Unix(), Checked: checked, Changes: changes}
+
+	return s.lastRun, nil
+}
+
+// LastRun returns the report of the last successful run, nil if the sync never ran.
+func (s *DirectorySync) LastRun() *DirectorySyncReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.lastRun
+}
+
+// SetSessions ends the sessions of the users the sync disables.
+func (s *DirectorySync) SetSessions(sessions *UserSessions) {
+	s.sessions = sessions
+}
+
+func (s *DirectorySync) apply(ctx context.Context, change DirectorySyncChange) error {
+	var err error
+
+	action := ""
+
+	switch change.Action {
+	case DirectorySyncActionDisable:
+		action = repos.AuditActionUserDisable
+		err = s.userRepo.Disable(ctx, change.Email)
+		if err == nil {
+			err = s.sessions.End(ctx, change.Email)
+		}
+	case DirectorySyncActionProfile:
+		action = repos.AuditActionUserProfile
+		_, err = s.userRepo.UpdateProfile(ctx, change.Email, change.Name, change.Picture)
+	}
+
+	recordAudit(ctx, s.auditRepo, DirectorySyncActor, action, change.Email, err)
+
+	return err
+}
+
+// Schedule syncs the directory immediately, then every interval until the context is done.
+func (s *DirectorySync) Schedule(ctx context.Context, interval time.Duration) {
+	schedule(ctx, "DirectorySync", interval, func(ctx context.Context, now time.Time) (string, error) {
+		report, err := s.Run(ctx, now)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("checked %d user(s), %d change(s)", report.Checked, len(report.Changes)), nil
+	})
+}
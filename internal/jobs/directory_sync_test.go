@@ -0,0 +1,98 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectorySync_Run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Disables suspended and missing users and updates profiles", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		userRepo, auditRepo := newReaperRepositories(t, now, map[string]int{
+			"active@test.com":    1,
+			"alias@test.com":     1,
+			"suspended@test.com": 1,
+			"deleted@test.com":   1,
+			"service@test.com":   1,
+			"guest@other.com":    1,
+		})
+
+		directory := mocks.NewMockWorkspaceDirectory(t)
+		directory.SetUser(services.DirectoryUser{Email: "Active@test.com", Name: "Active User", Picture: "https://pictures.test.com/active"})
+		directory.SetUser(services.DirectoryUser{Email: "primary@test.com", Aliases: []string{"alias@test.com"}})
+		directory.SetUser(services.DirectoryUser{Email: "suspended@test.com", Suspended: true})
+
+		directorySync := jobs.NewDirectorySync(userRepo, auditRepo, directory, "Test.com", []string{"Service@test.com"})
+
+		planned, err := directorySync.Report(context.Background(), now)
+		assert.NoError(t, err)
+		assert.True(t, planned.DryRun)
+		assert.Nil(t, directorySync.LastRun())
+
+		report, err := directorySync.Run(context.Background(), now)
+		assert.NoError(t, err)
+		assert.False(t, report.DryRun)
+		assert.Equal(t, 5, report.Checked)
+		assert.ElementsMatch(t, []jobs.DirectorySyncChange{
+			{Email: "active@test.com", Action: jobs.DirectorySyncActionProfile, Name: "Active User", Picture: "https://pictures.test.com/active"},
+			{Email: "suspended@test.com", Action: jobs.DirectorySyncActionDisable, Reason: jobs.DirectorySyncReasonSuspended},
+			{Email: "deleted@test.com", Action: jobs.DirectorySyncActionDisable, Reason: jobs.DirectorySyncReasonMissing},
+		}, report.Changes)
+		assert.ElementsMatch(t, planned.Changes, report.Changes)
+		assert.Equal(t, report, directorySync.LastRun())
+
+		active, err := userRepo.FindByEmail(context.Background(), "active@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "Active User", active.Name)
+		assert.Equal(t, "https://pictures.test.com/active", active.Picture)
+
+		for _, email := range []string{"suspended@test.com", "deleted@test.com"} {
+			user, err := userRepo.FindByEmail(context.Background(), email)
+			assert.NoError(t, err)
+			assert.NotZero(t, user.DisabledAt, email)
+		}
+
+		for _, email := range []string{"alias@test.com", "service@test.com", "guest@other.com"} {
+			user, err := userRepo.FindByEmail(context.Background(), email)
+			assert.NoError(t, err)
+			assert.Zero(t, user.DisabledAt, email)
+		}
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Actor: jobs.DirectorySyncActor})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+
+		// Applied changes are not made again
+		report, err = directorySync.Run(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, report.Checked)
+		assert.Empty(t, report.Changes)
+	})
+
+	t.Run("Refuses to disable everyone when the directory is empty", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		userRepo, auditRepo := newReaperRepositories(t, now, map[string]int{"user@test.com": 1})
+
+		directorySync := jobs.NewDirectorySync(userRepo, auditRepo, mocks.NewMockWorkspaceDirectory(t), "test.com", nil)
+
+		_, err := directorySync.Run(context.Background(), now)
+		assert.ErrorIs(t, err, jobs.ErrEmptyDirectory)
+
+		user, err := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Zero(t, user.DisabledAt)
+	})
+}
@@ -0,0 +1,178 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+type GroupSyncAction string
+
+const (
+	GroupSyncActionDisable     GroupSyncAction = "disable"
+	GroupSyncActionRadiusGroup GroupSyncAction = "radius-group"
+)
+
+// GroupSyncActor is the audit log actor for changes made by the group sync.
+const GroupSyncActor = "group-sync"
+
+// GroupSyncChange is a change made to a user, RadiusGroup is the new group of the radius-group action.
+type GroupSyncChange struct {
+	Email       string          `json:"email"`
+	Action      GroupSyncAction `json:"action"`
+	RadiusGroup string          `json:"radius_group,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+type GroupSyncReport struct {
+	RanAt   int64             `json:"ran_at"`
+	Checked int               `json:"checked"`
+	Changes []GroupSyncChange `json:"changes"`
+}
+
+// GroupSync refreshes the group mapping of users between logins. Users no longer member of an allowed group are
+// disabled, unless listed in exclude, sessions started with another group role are ended and RADIUS groups follow
+// group changes.
+type GroupSync struct {
+	userRepo  *repos.UserRepository
+	auditRepo *repos.AuditRepository
+	directory services.GroupDirectory
+	mapping   *helpers.GroupMapping
+	excluded  map[string]bool
+	sessions  *UserSessions
+}
+
+// NewGroupSync returns a GroupSync reading groups from directory, exclude usually lists users with a configured role.
+func NewGroupSync(userRepo *repos.UserRepository, auditRepo *repos.AuditRepository, directory services.GroupDirectory, mapping *helpers.GroupMapping, exclude []string) *GroupSync {
+	excluded := map[string]bool{}
+	for _, email := range exclude {
+		excluded[strings.ToLower(email)] = true
+	}
+
+	return &GroupSync{
+		userRepo:  userRepo,
+		auditRepo: auditRepo,
+		directory: directory,
+		mapping:   mapping,
+		excluded:  excluded,
+	}
+}
+
+// SetSessions ends the sessions of the users the sync disables or whose group role changed.
+func (s *GroupSync) SetSessions(sessions *UserSessions) {
+	s.sessions = sessions
+}
+
+// Run checks every enabled user. A failure on a user is reported and does not stop the others from being processed.
+func (s *GroupSync) Run(ctx context.Context, now time.Time) (*GroupSyncReport, error) {
+	report := &GroupSyncReport{RanAt: now.Unix(), Changes: []GroupSyncChange{}}
+
+	users, err := enabledUsers(ctx, s.userRepo)
+	if err != nil {
+		return nil, fmt.Errorf("group sync could not list users: %w", err)
+	}
+
+	for _, user := range users {
+		report.Checked++
+
+		change, err := s.syncUser(ctx, user)
+		if err != nil {
+			log.Printf("GroupSync: could not sync %s: %v", user.Email, err)
+			change.Error = err.Error()
+		}
+
+		if len(change.Action) > 0 {
+			report.Changes = append(report.Changes, change)
+		}
+	}
+
+	return report, nil
+}
+
+// enabledUsers returns every user not disabled yet, users are read before any change so pages do not shift.
+func enabledUsers(ctx context.Context, userRepo *repos.UserRepository) ([]repos.User, error) {
+	users := make([]repos.User, 0)
+
+	for page := 1; ; page++ {
+		pageUsers, err := userRepo.AllUsers(ctx, repos.UserRepositoryListMaxLimit, page)
+		if errors.Is(err, repos.ErrUserNotFound) {
+			return users, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range pageUsers {
+			if user.DisabledAt == 0 {
+				users = append(users, user)
+			}
+		}
+
+		if len(pageUsers) < repos.UserRepositoryListMaxLimit {
+			return users, nil
+		}
+	}
+}
+
+func (s *GroupSync) syncUser(ctx context.Context, user repos.User) (GroupSyncChange, error) {
+	change := GroupSyncChange{Email: user.Email}
+
+	groups, err := s.directory.UserGroups(ctx, user.Email)
+	if err != nil {
+		return change, fmt.Errorf("could not read groups: %w", err)
+	}
+
+	access := s.mapping.Resolve(groups)
+
+	if !access.Allowed && !s.excluded[strings.ToLower(user.Email)] {
+		change.Action = GroupSyncActionDisable
+		err = s.userRepo.Disable(ctx, user.Email)
+		if err == nil {
+			err = s.sessions.End(ctx, user.Email)
+		}
+		recordAudit(ctx, s.auditRepo, GroupSyncActor, repos.AuditActionUserDisable, user.Email, err)
+
+		return change, err
+	}
+
+	// Group changes only reach sessions through a new login, the role of a session is never changed in place
+	ended, err := s.sessions.EndRoleChanged(ctx, user.Email, access.Role)
+	if ended > 0 || err != nil {
+		recordAudit(ctx, s.auditRepo, GroupSyncActor, repos.AuditActionSessionRevoke, user.Email, err)
+	}
+
+	if err != nil {
+		return change, err
+	}
+
+	if len(s.mapping.RadiusAttribute) == 0 || user.Attributes[s.mapping.RadiusAttribute] == access.RadiusGroup {
+		return change, nil
+	}
+
+	change.Action = GroupSyncActionRadiusGroup
+	change.RadiusGroup = access.RadiusGroup
+	err = s.userRepo.SetAttribute(ctx, user.Email, s.mapping.RadiusAttribute, access.RadiusGroup)
+	recordAudit(ctx, s.auditRepo, GroupSyncActor, repos.AuditActionUserAttributes, user.Email, err)
+
+	return change, err
+}
+
+// Schedule syncs groups immediately, then every interval until the context is done.
+func (s *GroupSync) Schedule(ctx context.Context, interval time.Duration) {
+	schedule(ctx, "GroupSync", interval, func(ctx context.Context, now time.Time) (string, error) {
+		report, err := s.Run(ctx, now)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("checked %d user(s), %d change(s)", report.Checked, len(report.Changes)), nil
+	})
+}
@@ -0,0 +1,124 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestGroupMapping(t *testing.T) *helpers.GroupMapping {
+	t.Helper()
+
+	mapping, err := helpers.NewGroupMapping("radius-group", []helpers.GroupRule{
+		{Group: "admins@test.com", Role: helpers.AdminRoleString, RadiusGroup: "admins"},
+		{Group: "staff@test.com", RadiusGroup: "staff"},
+	})
+	assert.NoError(t, err)
+
+	return mapping
+}
+
+func TestGroupSync_Run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Disables users outside allowed groups and updates radius groups", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		userRepo, auditRepo := newReaperRepositories(t, now, map[string]int{
+			"admin@test.com":   1,
+			"staff@test.com":   1,
+			"left@test.com":    1,
+			"service@test.com": 1,
+		})
+		assert.NoError(t, userRepo.SetAttribute(context.Background(), "staff@test.com", "radius-group", "staff"))
+
+		directory := mocks.NewMockWorkspaceDirectory(t)
+		directory.SetUserGroups("admin@test.com", "Admins@test.com")
+		directory.SetUserGroups("staff@test.com", "staff@test.com")
+
+		sessions := mocks.NewMockSessionRepository(t)
+		_, _, err := sessions.Create(context.Background(), "left@test.com", "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		groupSync := jobs.NewGroupSync(userRepo, auditRepo, directory, newTestGroupMapping(t), []string{"Service@test.com"})
+		groupSync.SetSessions(jobs.NewUserSessions(sessions, nil))
+
+		report, err := groupSync.Run(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, report.Checked)
+		assert.ElementsMatch(t, []jobs.GroupSyncChange{
+			{Email: "admin@test.com", Action: jobs.GroupSyncActionRadiusGroup, RadiusGroup: "admins"},
+			{Email: "left@test.com", Action: jobs.GroupSyncActionDisable},
+		}, report.Changes)
+
+		admin, err := userRepo.FindByEmail(context.Background(), "admin@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "admins", admin.Attributes["radius-group"])
+
+		left, err := userRepo.FindByEmail(context.Background(), "left@test.com")
+		assert.NoError(t, err)
+		assert.NotZero(t, left.DisabledAt)
+
+		active, err := sessions.RevokeUser(context.Background(), "left@test.com")
+		assert.NoError(t, err)
+		assert.Empty(t, active)
+
+		service, err := userRepo.FindByEmail(context.Background(), "service@test.com")
+		assert.NoError(t, err)
+		assert.Zero(t, service.DisabledAt)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Actor: jobs.GroupSyncActor})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+
+		// Disabled users are not checked again
+		report, err = groupSync.Run(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, report.Checked)
+		assert.Empty(t, report.Changes)
+	})
+	t.Run("Ends sessions started with another group role", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		userRepo, auditRepo := newReaperRepositories(t, now, map[string]int{
+			"promoted@test.com": 1,
+			"staff@test.com":    1,
+		})
+
+		directory := mocks.NewMockWorkspaceDirectory(t)
+		directory.SetUserGroups("promoted@test.com", "admins@test.com")
+		directory.SetUserGroups("staff@test.com", "staff@test.com")
+
+		sessions := mocks.NewMockSessionRepository(t)
+		for _, email := range []string{"promoted@test.com", "staff@test.com"} {
+			_, _, err := sessions.Create(context.Background(), email, "", "", helpers.UserRoleString, helpers.UserRoleString)
+			assert.NoError(t, err)
+		}
+
+		groupSync := jobs.NewGroupSync(userRepo, auditRepo, directory, newTestGroupMapping(t), nil)
+		groupSync.SetSessions(jobs.NewUserSessions(sessions, nil))
+
+		_, err := groupSync.Run(context.Background(), now)
+		assert.NoError(t, err)
+
+		promoted, err := sessions.RevokeUser(context.Background(), "promoted@test.com")
+		assert.NoError(t, err)
+		assert.Empty(t, promoted)
+
+		staff, err := sessions.RevokeUser(context.Background(), "staff@test.com")
+		assert.NoError(t, err)
+		assert.Len(t, staff, 1)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Action: repos.AuditActionSessionRevoke})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+}
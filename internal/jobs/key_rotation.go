@@ -0,0 +1,131 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// KeyRotation replaces the key signing access tokens every interval.
+// A new key is published in the JWKS helpers.JWKSMaxAge before it signs tokens so verifiers caching the keys
+// know it once it is used. The previous key keeps verifying tokens, and stays published, for the overlap.
+type KeyRotation struct {
+	keyRepo   *repos.SigningKeyRepository
+	keys      *helpers.KeySet
+	algorithm string
+	interval  time.Duration
+	overlap   time.Duration
+}
+
+// NewKeyRotation returns a KeyRotation storing keys for algorithm in keyRepo and loading them in keys.
+func NewKeyRotation(keyRepo *repos.SigningKeyRepository, keys *helpers.KeySet, algorithm string, interval time.Duration, overlap time.Duration) *KeyRotation {
+	return &KeyRotation{
+		keyRepo:   keyRepo,
+		keys:      keys,
+		algorithm: algorithm,
+		interval:  interval,
+		overlap:   overlap,
+	}
+}
+
+// Run creates a key when there is none, or the newest is older than the interval or uses another algorithm,
+// then loads the keys not retired at now in the key set. Returns true when a key was created.
+func (r *KeyRotation) Run(ctx context.Context, now time.Time) (bool, error) {
+	stored, err := r.keyRepo.Active(ctx, now)
+	if err != nil {
+		return false, fmt.Errorf("could not load signing keys: %w", err)
+	}
+
+	rotated := false
+
+	if r.isDue(stored, now) {
+		created, err := r.create(ctx, now, len(stored) > 0)
+		if err != nil {
+			return false, err
+		}
+
+		stored = append(stored, created)
+		rotated = true
+	}
+
+	keys := make([]helpers.SigningKey, 0, len(stored))
+
+	for _, storedKey := range stored {
+		key, err := helpers.ParseSigningKey(storedKey.ID, storedKey.Algorithm, storedKey.PrivateKey)
+		if err != nil {
+			log.Printf("KeyRotation: skipping signing key %s: %v", storedKey.ID, err)
+
+			continue
+		}
+
+		key.ActivatesAt = time.Unix(storedKey.ActivatesAt, 0)
+		keys = append(keys, key)
+	}
+
+	r.keys.Replace(keys)
+
+	if _, err := r.keyRepo.Purge(ctx, now); err != nil {
+		return rotated, fmt.Errorf("could not purge signing keys: %w", err)
+	}
+
+	return rotated, nil
+}
+
+func (r *KeyRotation) isDue(stored []repos.SigningKey, now time.Time) bool {
+	if len(stored) == 0 {
+		return true
+	}
+
+	newest := stored[len(stored)-1]
+
+	return newest.Algorithm != r.algorithm || now.Unix() >= newest.CreatedAt+int64(r.interval.Seconds())
+}
+
+// create stores a new key, it signs tokens immediately only when no other key can sign until it is published.
+func (r *KeyRotation) create(ctx context.Context, now time.Time, delayed bool) (repos.SigningKey, error) {
+	key, err := helpers.NewSigningKey(r.algorithm)
+	if err != nil {
+		return repos.SigningKey{}, fmt.Errorf("could not create signing key: %w", err)
+	}
+
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		return repos.SigningKey{}, fmt.Errorf("could not create signing key: %w", err)
+	}
+
+	activatesAt := now
+	if delayed {
+		activatesAt = now.Add(helpers.JWKSMaxAge)
+	}
+
+	stored := repos.SigningKey{
+		ID:          key.ID,
+		Algorithm:   key.Algorithm,
+		PrivateKey:  der,
+		CreatedAt:   now.Unix(),
+		ActivatesAt: activatesAt.Unix(),
+		RetiresAt:   activatesAt.Add(r.interval + r.overlap).Unix(),
+	}
+
+	if err := r.keyRepo.Create(ctx, stored); err != nil {
+		return repos.SigningKey{}, fmt.Errorf("could not create signing key: %w", err)
+	}
+
+	return stored, nil
+}
+
+// Schedule rotates keys when due immediately, then every interval until the context is done.
+func (r *KeyRotation) Schedule(ctx context.Context, interval time.Duration) {
+	schedule(ctx, "KeyRotation", interval, func(ctx context.Context, now time.Time) (string, error) {
+		rotated, err := r.Run(ctx, now)
+		if err != nil || !rotated {
+			return "", err
+		}
+
+		return fmt.Sprintf("created a new %s signing key", r.algorithm), nil
+	})
+}
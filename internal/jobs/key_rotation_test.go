@@ -0,0 +1,111 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRotation_Run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Creates a key on first run and keeps it until the interval", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		keys := helpers.NewKeySet()
+		rotation := jobs.NewKeyRotation(mocks.NewMockSigningKeyRepository(t), keys, helpers.SigningAlgorithmES256, 24*time.Hour, 2*time.Hour)
+
+		rotated, err := rotation.Run(context.Background(), now)
+		assert.NoError(t, err)
+		assert.True(t, rotated)
+
+		first, found := keys.Current()
+		assert.True(t, found)
+		assert.Equal(t, helpers.SigningAlgorithmES256, first.Algorithm)
+
+		rotated, err = rotation.Run(context.Background(), now.Add(23*time.Hour))
+		assert.NoError(t, err)
+		assert.False(t, rotated)
+
+		current, _ := keys.Current()
+		assert.Equal(t, first.ID, current.ID)
+	})
+
+	t.Run("Publishes a new key before it signs tokens", func(t *testing.T) {
+		t.Parallel()
+
+		// The second key is created now and activates once verifiers may have refreshed their cached keys
+		start := time.Now().Add(-24 * time.Hour)
+		keys := helpers.NewKeySet()
+		rotation := jobs.NewKeyRotation(mocks.NewMockSigningKeyRepository(t), keys, helpers.SigningAlgorithmES256, 24*time.Hour, 2*time.Hour)
+
+		_, err := rotation.Run(context.Background(), start)
+		assert.NoError(t, err)
+
+		first, _ := keys.Current()
+
+		rotated, err := rotation.Run(context.Background(), start.Add(24*time.Hour))
+		assert.NoError(t, err)
+		assert.True(t, rotated)
+		assert.Len(t, keys.JWKS().Keys, 2)
+
+		current, _ := keys.Current()
+		assert.Equal(t, first.ID, current.ID)
+	})
+
+	t.Run("Keeps the previous key for the overlap after rotating", func(t *testing.T) {
+		t.Parallel()
+
+		// The second key, created 24 hours after the first, is active now
+		start := time.Now().Add(-24*time.Hour - helpers.JWKSMaxAge - time.Minute)
+		keys := helpers.NewKeySet()
+		rotation := jobs.NewKeyRotation(mocks.NewMockSigningKeyRepository(t), keys, helpers.SigningAlgorithmEdDSA, 24*time.Hour, 2*time.Hour)
+
+		_, err := rotation.Run(context.Background(), start)
+		assert.NoError(t, err)
+
+		first, _ := keys.Current()
+
+		rotated, err := rotation.Run(context.Background(), start.Add(24*time.Hour))
+		assert.NoError(t, err)
+		assert.True(t, rotated)
+
+		second, _ := keys.Current()
+		assert.NotEqual(t, first.ID, second.ID)
+		assert.Len(t, keys.JWKS().Keys, 2)
+
+		_, found := keys.Find(first.ID)
+		assert.True(t, found)
+
+		_, err = rotation.Run(context.Background(), start.Add(26*time.Hour))
+		assert.NoError(t, err)
+
+		_, found = keys.Find(first.ID)
+		assert.False(t, found)
+		assert.Len(t, keys.JWKS().Keys, 1)
+	})
+
+	t.Run("Rotates immediately when the algorithm changes", func(t *testing.T) {
+		t.Parallel()
+
+		start := time.Now().Add(-time.Hour)
+		keyRepo := mocks.NewMockSigningKeyRepository(t)
+		keys := helpers.NewKeySet()
+
+		_, err := jobs.NewKeyRotation(keyRepo, keys, helpers.SigningAlgorithmES256, 24*time.Hour, 2*time.Hour).Run(context.Background(), start)
+		assert.NoError(t, err)
+
+		rotated, err := jobs.NewKeyRotation(keyRepo, keys, helpers.SigningAlgorithmEdDSA, 24*time.Hour, 2*time.Hour).Run(context.Background(), start)
+		assert.NoError(t, err)
+		assert.True(t, rotated)
+
+		current, _ := keys.Current()
+		assert.Equal(t, helpers.SigningAlgorithmEdDSA, current.Algorithm)
+	})
+}
@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// LeaseExpirer frees the IP pool addresses whose lease ended without an accounting Stop.
+type LeaseExpirer struct {
+	poolRepo *repos.IPPoolRepository
+}
+
+// NewLeaseExpirer returns a LeaseExpirer for the leases of poolRepo.
+func NewLeaseExpirer(poolRepo *repos.IPPoolRepository) *LeaseExpirer {
+	return &LeaseExpirer{poolRepo: poolRepo}
+}
+
+// Run frees the leases expired at now and returns how many were freed.
+func (e *LeaseExpirer) Run(ctx context.Context, now time.Time) (int64, error) {
+	expired, err := e.poolRepo.ExpireLeases(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("could not expire leases: %w", err)
+	}
+
+	return expired, nil
+}
+
+// Schedule frees expired leases immediately, then every interval until the context is done.
+func (e *LeaseExpirer) Schedule(ctx context.Context, interval time.Duration) {
+	schedule(ctx, "LeaseExpirer", interval, func(ctx context.Context, now time.Time) (string, error) {
+		expired, err := e.Run(ctx, now)
+		if err != nil || expired == 0 {
+			return "", err
+		}
+
+		return fmt.Sprintf("freed %d expired leases", expired), nil
+	})
+}
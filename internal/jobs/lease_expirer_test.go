@@ -0,0 +1,32 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseExpirer_Run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Frees expired leases", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		_, err := poolRepo.Allocate(context.Background(), "office", "user@test.com", "session-1", now.Add(-2*time.Hour))
+		assert.NoError(t, err)
+
+		expired, err := jobs.NewLeaseExpirer(poolRepo).Run(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), expired)
+
+		lease, err := poolRepo.Allocate(context.Background(), "office", "other@test.com", "session-2", now)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", lease.Address)
+	})
+}
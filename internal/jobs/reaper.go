@@ -0,0 +1,237 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+type ReapAction string
+
+const (
+	ReapActionWarn    ReapAction = "warn"
+	ReapActionPending ReapAction = "pending"
+	ReapActionDisable ReapAction = "disable"
+	ReapActionDelete  ReapAction = "delete"
+)
+
+// ReaperActor is the audit log actor for changes made by the reaper.
+const ReaperActor = "reaper"
+
+var ErrInvalidReaperPolicy = errors.New("invalid reaper policy")
+
+// ReaperPolicy describes when inactive users are warned and what happens to them once InactiveAfter is reached.
+type ReaperPolicy struct {
+	InactiveAfter time.Duration
+	WarnBefore    time.Duration
+	Action        ReapAction
+	Exclude       []string
+}
+
+// ReapCandidate is a user the reaper will act upon. DeadlineAt is when the policy action will be applied.
+type ReapCandidate struct {
+	Email      string     `json:"email"`
+	LastSeenAt int64      `json:"last_seen_at"`
+	WarnedAt   int64      `json:"warned_at"`
+	DeadlineAt int64      `json:"deadline_at"`
+	Action     ReapAction `json:"action"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type ReapReport struct {
+	DryRun     bool            `json:"dry_run"`
+	RanAt      int64           `json:"ran_at"`
+	Candidates []ReapCandidate `json:"candidates"`
+}
+
+// Notifier warns users their account is about to be reaped.
+type Notifier interface {
+	NotifyInactive(candidate ReapCandidate) error
+}
+
+// LogNotifier only logs warnings, users see them on their own page.
+type LogNotifier struct{}
+
+func (LogNotifier) NotifyInactive(candidate ReapCandidate) error {
+	log.Printf("Reaper: %s has been inactive since %v and will be reaped on %v", candidate.Email, time.Unix(candidate.LastSeenAt, 0), time.Unix(candidate.DeadlineAt, 0))
+
+	return nil
+}
+
+// Reaper warns, then disables or deletes, users who did not authenticate for a while.
+type Reaper struct {
+	userRepo  *repos.UserRepository
+	auditRepo *repos.AuditRepository
+	policy    ReaperPolicy
+	notifier  Notifier
+	excluded  map[string]bool
+	sessions  *UserSessions
+}
+
+// NewReaper returns a Reaper applying the policy, a nil notifier logs warnings.
+func NewReaper(userRepo *repos.UserRepository, auditRepo *repos.AuditRepository, policy ReaperPolicy, notifier Notifier) (*Reaper, error) {
+	if policy.Action != ReapActionDisable && policy.Action != ReapActionDelete {
+		return nil, fmt.Errorf("%w: action must be %s or %s not '%s'", ErrInvalidReaperPolicy, ReapActionDisable, ReapActionDelete, policy.Action)
+	}
+
+	if policy.InactiveAfter <= 0 || policy.WarnBefore < 0 || policy.WarnBefore >= policy.InactiveAfter {
+		return nil, fmt.Errorf("%w: warn-before must be shorter than inactive-after", ErrInvalidReaperPolicy)
+	}
+
+	if notifier == nil {
+		notifier = LogNotifier{}
+	}
+
+	excluded := map[string]bool{}
+	for _, email := range policy.Exclude {
+		excluded[strings.ToLower(email)] = true
+	}
+
+	return &Reaper{
+		userRepo:  userRepo,
+		auditRepo: auditRepo,
+		policy:    policy,
+		notifier:  notifier,
+		excluded:  excluded,
+	}, nil
+}
+
+// Plan returns what the reaper would do at the given time.
+func (r *Reaper) Plan(ctx context.Context, now time.Time) ([]ReapCandidate, error) {
+	warnSince := now.Add(-(r.policy.InactiveAfter - r.policy.WarnBefore)).Unix()
+	inactiveSince := now.Add(-r.policy.InactiveAfter).Unix()
+	warnedBefore := now.Add(-r.policy.WarnBefore).Unix()
+
+	users, err := r.userRepo.FindInactiveSince(ctx, warnSince)
+	if err != nil {
+		return nil, fmt.Errorf("reaper could not find inactive users: %w", err)
+	}
+
+	candidates := make([]ReapCandidate, 0, len(users))
+
+	for _, user := range users {
+		if r.excluded[strings.ToLower(user.Email)] {
+			continue
+		}
+
+		// Disabled users are already reaped unless the policy deletes them
+		if user.DisabledAt != 0 && r.policy.Action == ReapActionDisable {
+			continue
+		}
+
+		candidate := ReapCandidate{
+			Email:      user.Email,
+			LastSeenAt: user.LastSeenAt,
+			WarnedAt:   user.InactivityWarnedAt,
+		}
+
+		// The action always comes at least WarnBefore after the warning
+		deadline := time.Unix(user.LastSeenAt, 0).Add(r.policy.InactiveAfter)
+		warnedAt := now
+		if user.InactivityWarnedAt != 0 {
+			warnedAt = time.Unix(user.InactivityWarnedAt, 0)
+		}
+
+		if minimum := warnedAt.Add(r.policy.WarnBefore); deadline.Before(minimum) {
+			deadline = minimum
+		}
+
+		candidate.DeadlineAt = deadline.Unix()
+
+		switch {
+		case user.InactivityWarnedAt == 0:
+			candidate.Action = ReapActionWarn
+		case user.LastSeenAt < inactiveSince && user.InactivityWarnedAt <= warnedBefore:
+			candidate.Action = r.policy.Action
+		default:
+			candidate.Action = ReapActionPending
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+// Report returns the plan without applying it.
+func (r *Reaper) Report(ctx context.Context, now time.Time) (*ReapReport, error) {
+	candidates, err := r.Plan(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReapReport{DryRun: true, RanAt: now.Unix(), Candidates: candidates}, nil
+}
+
+// Run applies the plan. A failure on a user is reported and does not stop the others from being processed.
+func (r *Reaper) Run(ctx context.Context, now time.Time) (*ReapReport, error) {
+	candidates, err := r.Plan(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, candidate := range candidates {
+		if err := r.apply(ctx, candidate, now); err != nil {
+			log.Printf("Reaper: could not %s %s: %v", candidate.Action, candidate.Email, err)
+			candidates[index].Error = err.Error()
+		}
+	}
+
+	return &ReapReport{DryRun: false, RanAt: now.Unix(), Candidates: candidates}, nil
+}
+
+// SetSessions ends the sessions of the users the reaper disables or deletes.
+func (r *Reaper) SetSessions(sessions *UserSessions) {
+	r.sessions = sessions
+}
+
+func (r *Reaper) apply(ctx context.Context, candidate ReapCandidate, now time.Time) error {
+	var err error
+
+	action := ""
+
+	switch candidate.Action {
+	case ReapActionWarn:
+		action = repos.AuditActionUserInactiveWarn
+
+		err = r.userRepo.MarkInactivityWarned(ctx, candidate.Email, now.Unix())
+		if err == nil {
+			err = r.notifier.NotifyInactive(candidate)
+		}
+	case ReapActionDisable:
+		action = repos.AuditActionUserDisable
+		err = r.userRepo.Disable(ctx, candidate.Email)
+		if err == nil {
+			err = r.sessions.End(ctx, candidate.Email)
+		}
+	case ReapActionDelete:
+		action = repos.AuditActionUserDelete
+		err = r.userRepo.Delete(ctx, candidate.Email)
+		if err == nil {
+			err = r.sessions.End(ctx, candidate.Email)
+		}
+	case ReapActionPending:
+		return nil
+	}
+
+	recordAudit(ctx, r.auditRepo, ReaperActor, action, candidate.Email, err)
+
+	return err
+}
+
+// Schedule runs the reaper immediately, then every interval until the context is done.
+func (r *Reaper) Schedule(ctx context.Context, interval time.Duration) {
+	schedule(ctx, "Reaper", interval, func(ctx context.Context, now time.Time) (string, error) {
+		report, err := r.Run(ctx, now)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("processed %d inactive user(s)", len(report.Candidates)), nil
+	})
+}
@@ -0,0 +1,216 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+const day = 24 * time.Hour
+
+type recordingNotifier struct {
+	notified []string
+}
+
+func (n *recordingNotifier) NotifyInactive(candidate jobs.ReapCandidate) error {
+	n.notified = append(n.notified, candidate.Email)
+
+	return nil
+}
+
+func defaultPolicy(action jobs.ReapAction) jobs.ReaperPolicy {
+	return jobs.ReaperPolicy{
+		InactiveAfter: 90 * day,
+		WarnBefore:    14 * day,
+		Action:        action,
+		Exclude:       []string{"Service@test.com"},
+	}
+}
+
+// importedPasswordHash is an argon2id hash of "imported-password" used for users imported by the tests.
+const importedPasswordHash = "$argon2id$v=19$m=65536,t=1,p=2$5GpJksUpTq8g0CIIlTb0bw$CWKRX4ZIKoP5L1qzmEimxoeqEGxhUIdScqZSNE5S/6I"
+
+// newReaperRepositories returns repositories holding users last seen the given number of days before now.
+func newReaperRepositories(t *testing.T, now time.Time, lastSeenDaysAgo map[string]int) (*repos.UserRepository, *repos.AuditRepository) {
+	t.Helper()
+
+	db := mocks.NewMockDB(t)
+
+	userRepo, err := repos.NewUserRepository(db)
+	if err != nil {
+		t.Fatalf("could not create user repository: %v", err)
+	}
+
+	auditRepo, err := repos.NewAuditRepository(db)
+	if err != nil {
+		t.Fatalf("could not create audit repository: %v", err)
+	}
+
+	records := make([]repos.UserImportRecord, 0, len(lastSeenDaysAgo))
+	for email, days := range lastSeenDaysAgo {
+		lastSeen := now.Add(-time.Duration(days) * day).Unix()
+		records = append(records, repos.UserImportRecord{
+			User: repos.User{Email: email, PasswordHash: importedPasswordHash, CreatedAt: lastSeen - 1, LastSeenAt: lastSeen},
+		})
+	}
+
+	if _, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{}); err != nil {
+		t.Fatalf("could not add users: %v", err)
+	}
+
+	return userRepo, auditRepo
+}
+
+func actionsByEmail(candidates []jobs.ReapCandidate) map[string]jobs.ReapAction {
+	actions := map[string]jobs.ReapAction{}
+	for _, candidate := range candidates {
+		actions[candidate.Email] = candidate.Action
+	}
+
+	return actions
+}
+
+func TestNewReaper(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses unknown actions", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := jobs.NewReaper(nil, nil, defaultPolicy("archive"), nil)
+		assert.ErrorIs(t, err, jobs.ErrInvalidReaperPolicy)
+	})
+
+	t.Run("Refuses warning longer than inactivity", func(t *testing.T) {
+		t.Parallel()
+
+		policy := defaultPolicy(jobs.ReapActionDisable)
+		policy.WarnBefore = policy.InactiveAfter
+
+		_, err := jobs.NewReaper(nil, nil, policy, nil)
+		assert.ErrorIs(t, err, jobs.ErrInvalidReaperPolicy)
+	})
+}
+
+func TestReaper_Plan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Warns users close to the threshold and ignores active or excluded ones", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		userRepo, auditRepo := newReaperRepositories(t, now, map[string]int{
+			"active@test.com":  10,
+			"soon@test.com":    80,
+			"gone@test.com":    200,
+			"service@test.com": 200,
+		})
+
+		reaper, err := jobs.NewReaper(userRepo, auditRepo, defaultPolicy(jobs.ReapActionDisable), nil)
+		assert.NoError(t, err)
+
+		candidates, err := reaper.Plan(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]jobs.ReapAction{
+			"soon@test.com": jobs.ReapActionWarn,
+			"gone@test.com": jobs.ReapActionWarn,
+		}, actionsByEmail(candidates))
+	})
+
+	t.Run("Gives warned users at least the warning period", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		userRepo, auditRepo := newReaperRepositories(t, now, map[string]int{"gone@test.com": 200})
+		assert.NoError(t, userRepo.MarkInactivityWarned(context.Background(), "gone@test.com", now.Add(-2*day).Unix()))
+
+		reaper, _ := jobs.NewReaper(userRepo, auditRepo, defaultPolicy(jobs.ReapActionDisable), nil)
+
+		candidates, err := reaper.Plan(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Len(t, candidates, 1)
+		assert.Equal(t, jobs.ReapActionPending, candidates[0].Action)
+		assert.Equal(t, now.Add(12*day).Unix(), candidates[0].DeadlineAt)
+
+		candidates, err = reaper.Plan(context.Background(), now.Add(13*day))
+		assert.NoError(t, err)
+		assert.Equal(t, jobs.ReapActionDisable, candidates[0].Action)
+	})
+}
+
+func TestReaper_Run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Warns, then disables inactive users", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		userRepo, auditRepo := newReaperRepositories(t, now, map[string]int{"gone@test.com": 200})
+		notifier := &recordingNotifier{}
+		reaper, _ := jobs.NewReaper(userRepo, auditRepo, defaultPolicy(jobs.ReapActionDisable), notifier)
+
+		_, err := reaper.Run(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"gone@test.com"}, notifier.notified)
+
+		user, _ := userRepo.FindByEmail(context.Background(), "gone@test.com")
+		assert.Equal(t, now.Unix(), user.InactivityWarnedAt)
+		assert.Zero(t, user.DisabledAt)
+
+		report, err := reaper.Run(context.Background(), now.Add(15*day))
+		assert.NoError(t, err)
+		assert.False(t, report.DryRun)
+		assert.Equal(t, jobs.ReapActionDisable, report.Candidates[0].Action)
+
+		user, _ = userRepo.FindByEmail(context.Background(), "gone@test.com")
+		assert.NotZero(t, user.DisabledAt)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Actor: jobs.ReaperActor})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+		assert.Equal(t, repos.AuditActionUserDisable, entries[0].Action)
+		assert.Equal(t, repos.AuditActionUserInactiveWarn, entries[1].Action)
+
+		// Already disabled users are left alone
+		report, err = reaper.Run(context.Background(), now.Add(16*day))
+		assert.NoError(t, err)
+		assert.Empty(t, report.Candidates)
+	})
+
+	t.Run("Deletes inactive users when the policy says so", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		userRepo, auditRepo := newReaperRepositories(t, now, map[string]int{"gone@test.com": 200})
+		reaper, _ := jobs.NewReaper(userRepo, auditRepo, defaultPolicy(jobs.ReapActionDelete), &recordingNotifier{})
+
+		_, err := reaper.Run(context.Background(), now)
+		assert.NoError(t, err)
+		_, err = reaper.Run(context.Background(), now.Add(15*day))
+		assert.NoError(t, err)
+
+		assert.False(t, userRepo.Exists(context.Background(), "gone@test.com"))
+	})
+
+	t.Run("Report does not change users", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		userRepo, auditRepo := newReaperRepositories(t, now, map[string]int{"gone@test.com": 200})
+		notifier := &recordingNotifier{}
+		reaper, _ := jobs.NewReaper(userRepo, auditRepo, defaultPolicy(jobs.ReapActionDisable), notifier)
+
+		report, err := reaper.Report(context.Background(), now)
+		assert.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.Len(t, report.Candidates, 1)
+		assert.Empty(t, notifier.notified)
+
+		user, _ := userRepo.FindByEmail(context.Background(), "gone@test.com")
+		assert.Zero(t, user.InactivityWarnedAt)
+	})
+}
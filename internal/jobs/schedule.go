@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// runFunc runs a job once and returns the summary logged after it succeeded, an empty summary is not logged.
+type runFunc func(ctx context.Context, now time.Time) (string, error)
+
+// schedule runs the job immediately, then every interval until the context is done.
+func schedule(ctx context.Context, name string, interval time.Duration, run runFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		summary, err := run(ctx, time.Now())
+		if err != nil {
+			log.Printf("%s: run failed: %v", name, err)
+		} else if len(summary) > 0 {
+			log.Printf("%s: %s", name, summary)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordAudit appends the action taken by the job actor on the user to the audit log, if there is one.
+func recordAudit(ctx context.Context, auditRepo *repos.AuditRepository, actor string, action string, email string, actionErr error) {
+	if auditRepo == nil {
+		return
+	}
+
+	result := "success"
+	if actionErr != nil {
+		result = "failed"
+	}
+
+	if _, err := auditRepo.Append(ctx, actor, action, email, "", result); err != nil {
+		log.Printf("%s: failed to record %s on %s: %v", actor, action, email, err)
+	}
+}
@@ -0,0 +1,125 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+const (
+	snapshotFilePrefix          = "fringe-"
+	snapshotFileExt             = ".db"
+	snapshotDirectoryPermission = 0o700
+)
+
+var ErrInvalidSnapshotPolicy = errors.New("invalid snapshot policy")
+
+// SnapshotPolicy describes where snapshots are written and how many of the most recent ones are kept.
+type SnapshotPolicy struct {
+	Directory string
+	Retention int
+}
+
+// Snapshotter writes timestamped snapshots of the database and deletes the oldest ones.
+type Snapshotter struct {
+	snapshotRepo *repos.SnapshotRepository
+	policy       SnapshotPolicy
+}
+
+// NewSnapshotter returns a Snapshotter applying the policy, the directory is created if needed.
+func NewSnapshotter(snapshotRepo *repos.SnapshotRepository, policy SnapshotPolicy) (*Snapshotter, error) {
+	if len(policy.Directory) == 0 {
+		return nil, fmt.Errorf("%w: directory is required", ErrInvalidSnapshotPolicy)
+	}
+
+	if policy.Retention < 1 {
+		return nil, fmt.Errorf("%w: retention must keep at least one snapshot", ErrInvalidSnapshotPolicy)
+	}
+
+	if err := os.MkdirAll(policy.Directory, snapshotDirectoryPermission); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshotPolicy, err)
+	}
+
+	return &Snapshotter{snapshotRepo: snapshotRepo, policy: policy}, nil
+}
+
+// SnapshotFileName returns the name of a snapshot taken at the given time, names sort in chronological order.
+func SnapshotFileName(at time.Time) string {
+	return snapshotFilePrefix + at.UTC().Format(repos.SnapshotTimeFormat) + snapshotFileExt
+}
+
+// Run writes a snapshot then deletes the snapshots exceeding the retention.
+func (s *Snapshotter) Run(ctx context.Context, now time.Time) (*repos.Snapshot, error) {
+	snapshot, err := s.snapshotRepo.Write(ctx, filepath.Join(s.policy.Directory, SnapshotFileName(now)))
+	if err != nil {
+		return nil, fmt.Errorf("could not write snapshot: %w", err)
+	}
+
+	if err := s.prune(); err != nil {
+		return snapshot, err
+	}
+
+	return snapshot, nil
+}
+
+// Snapshots returns the snapshot files in the directory, most recent first.
+func (s *Snapshotter) Snapshots() ([]string, error) {
+	entries, err := os.ReadDir(s.policy.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("could not list snapshots: %w", err)
+	}
+
+	files := []string{}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), snapshotFilePrefix) && strings.HasSuffix(entry.Name(), snapshotFileExt) {
+			files = append(files, filepath.Join(s.policy.Directory, entry.Name()))
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files, nil
+}
+
+func (s *Snapshotter) prune() error {
+	files, err := s.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	for len(files) > s.policy.Retention {
+		expired := files[len(files)-1]
+		files = files[:len(files)-1]
+
+		if err := os.Remove(expired); err != nil {
+			return fmt.Errorf("could not delete expired snapshot: %w", err)
+		}
+
+		_ = os.Remove(expired + repos.SnapshotChecksumExt)
+
+		log.Printf("Snapshotter: deleted expired snapshot %s", expired)
+	}
+
+	return nil
+}
+
+// Schedule writes a snapshot immediately, then every interval until the context is done.
+func (s *Snapshotter) Schedule(ctx context.Context, interval time.Duration) {
+	schedule(ctx, "Snapshotter", interval, func(ctx context.Context, now time.Time) (string, error) {
+		snapshot, err := s.Run(ctx, now)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("wrote %s (%d bytes)", snapshot.File, snapshot.Size), nil
+	})
+}
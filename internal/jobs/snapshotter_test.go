@@ -0,0 +1,114 @@
+package jobs_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSnapshotter(t *testing.T, directory string, retention int) *jobs.Snapshotter {
+	t.Helper()
+
+	db := mocks.NewMockDB(t)
+	if _, err := repos.NewUserRepository(db); err != nil {
+		t.Fatalf("could not create user repository: %v", err)
+	}
+
+	if _, err := repos.NewAuditRepository(db); err != nil {
+		t.Fatalf("could not create audit repository: %v", err)
+	}
+
+	snapshotter, err := jobs.NewSnapshotter(repos.NewSnapshotRepository(db), jobs.SnapshotPolicy{Directory: directory, Retention: retention})
+	if err != nil {
+		t.Fatalf("could not create snapshotter: %v", err)
+	}
+
+	return snapshotter
+}
+
+func TestNewSnapshotter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses policies without directory or retention", func(t *testing.T) {
+		t.Parallel()
+
+		snapshotRepo := repos.NewSnapshotRepository(mocks.NewMockDB(t))
+
+		_, err := jobs.NewSnapshotter(snapshotRepo, jobs.SnapshotPolicy{Retention: 1})
+		assert.ErrorIs(t, err, jobs.ErrInvalidSnapshotPolicy)
+
+		_, err = jobs.NewSnapshotter(snapshotRepo, jobs.SnapshotPolicy{Directory: t.TempDir(), Retention: 0})
+		assert.ErrorIs(t, err, jobs.ErrInvalidSnapshotPolicy)
+	})
+}
+
+func TestSnapshotter_Run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Writes timestamped snapshots and keeps the most recent ones", func(t *testing.T) {
+		t.Parallel()
+
+		directory := filepath.Join(t.TempDir(), "snapshots")
+		snapshotter := newSnapshotter(t, directory, 2)
+		start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		for hours := 0; hours < 3; hours++ {
+			snapshot, err := snapshotter.Run(context.Background(), start.Add(time.Duration(hours)*time.Hour))
+			assert.NoError(t, err)
+			assert.FileExists(t, snapshot.File)
+		}
+
+		files, err := snapshotter.Snapshots()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			filepath.Join(directory, "fringe-20210601T140000Z.db"),
+			filepath.Join(directory, "fringe-20210601T130000Z.db"),
+		}, files)
+		assert.NoFileExists(t, filepath.Join(directory, "fringe-20210601T120000Z.db"+repos.SnapshotChecksumExt))
+
+		_, err = repos.VerifySnapshot(context.Background(), files[0], "")
+		assert.NoError(t, err)
+	})
+}
+
+func TestSnapshotter_Schedule(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Writes a snapshot immediately and stops once the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		directory := filepath.Join(t.TempDir(), "snapshots")
+		snapshotter := newSnapshotter(t, directory, 2)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+
+		go func() {
+			snapshotter.Schedule(ctx, time.Hour)
+			close(stopped)
+		}()
+
+		assert.Eventually(t, func() bool {
+			snapshots, _ := snapshotter.Snapshots()
+
+			return len(snapshots) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		cancel()
+
+		assert.Eventually(t, func() bool {
+			select {
+			case <-stopped:
+				return true
+			default:
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
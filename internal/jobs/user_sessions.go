@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// UserSessions ends the sessions of the users a job disables or deletes, their access tokens are refused right away.
+type UserSessions struct {
+	sessions    *repos.SessionRepository
+	revocations *helpers.RevocationList
+}
+
+// NewUserSessions returns UserSessions revoking sessions in sessions, revocations may be nil.
+func NewUserSessions(sessions *repos.SessionRepository, revocations *helpers.RevocationList) *UserSessions {
+	return &UserSessions{sessions: sessions, revocations: revocations}
+}
+
+// End revokes every session of the user, a nil UserSessions has nothing to end.
+func (u *UserSessions) End(ctx context.Context, email string) error {
+	if u == nil {
+		return nil
+	}
+
+	sessions, err := u.sessions.RevokeUser(ctx, email)
+	if err != nil {
+		return fmt.Errorf("could not end sessions: %w", err)
+	}
+
+	u.revoke(sessions)
+
+	return nil
+}
+
+// EndRoleChanged revokes the sessions of the user started with another provider role than providerRole and
+// returns how many were ended, a nil UserSessions has nothing to end.
+func (u *UserSessions) EndRoleChanged(ctx context.Context, email string, providerRole string) (int, error) {
+	if u == nil {
+		return 0, nil
+	}
+
+	sessions, err := u.sessions.RevokeUserRole(ctx, email, providerRole)
+	if err != nil {
+		return 0, fmt.Errorf("could not end sessions: %w", err)
+	}
+
+	u.revoke(sessions)
+
+	return len(sessions), nil
+}
+
+func (u *UserSessions) revoke(sessions []repos.Session) {
+	if u.revocations == nil {
+		return
+	}
+
+	for _, session := range sessions {
+		u.revocations.Revoke(session.ID, time.Now())
+	}
+}
@@ -0,0 +1,41 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/jobs"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserSessions_End(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Revokes the sessions and their access tokens", func(t *testing.T) {
+		t.Parallel()
+
+		sessions := mocks.NewMockSessionRepository(t)
+		session, _, err := sessions.Create(context.Background(), "user@test.com", "", "", helpers.UserRoleString, helpers.UserRoleString)
+		assert.NoError(t, err)
+
+		revocations := helpers.NewRevocationList()
+		assert.NoError(t, jobs.NewUserSessions(sessions, revocations).End(context.Background(), "user@test.com"))
+
+		claims := helpers.NewAuthClaims("user@test.com", "", "", helpers.UserRoleString)
+		claims.SessionID = session.ID
+		assert.True(t, revocations.IsRevoked(claims))
+
+		active, err := sessions.RevokeUser(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Empty(t, active)
+	})
+
+	t.Run("Nil sessions have nothing to end", func(t *testing.T) {
+		t.Parallel()
+
+		var userSessions *jobs.UserSessions
+		assert.NoError(t, userSessions.End(context.Background(), "user@test.com"))
+	})
+}
@@ -0,0 +1,19 @@
+package mocks
+
+import (
+	"testing"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// NewMockAPITokenRepository returns an actual repos.APITokenRepository without tokens in a temporary directory.
+func NewMockAPITokenRepository(t *testing.T) *repos.APITokenRepository {
+	t.Helper()
+
+	tokenRepo, err := repos.NewAPITokenRepository(NewMockDB(t))
+	if err != nil {
+		t.Fatalf("NewMockAPITokenRepository: Could not initate api token repository: %v", err)
+	}
+
+	return tokenRepo
+}
@@ -0,0 +1,24 @@
+package mocks
+
+import (
+	"testing"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// AuditKey keys the hashes of the mock audit logs.
+const AuditKey = "audit-secret"
+
+// NewMockAuditRepository returns an actual repos.AuditRepository with an empty keyed log in a temporary directory.
+func NewMockAuditRepository(t *testing.T) *repos.AuditRepository {
+	t.Helper()
+
+	auditRepo, err := repos.NewAuditRepository(NewMockDB(t))
+	if err != nil {
+		t.Fatalf("NewMockAuditRepository: Could not initate audit repository: %v", err)
+	}
+
+	auditRepo.SetKey(AuditKey)
+
+	return auditRepo
+}
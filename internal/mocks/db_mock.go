@@ -0,0 +1,32 @@
+package mocks
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"modernc.org/ql"
+)
+
+// NewMockDB returns a connexion to an empty ql database in a temporary directory closed at the end of the test.
+func NewMockDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	tempDir := t.TempDir()
+
+	// Initialize Database connexion
+	ql.RegisterDriver()
+
+	connexion, err := sqlx.Open("ql", tempDir+"/db")
+	if err != nil {
+		t.Fatalf("NewMockDB: could not connect to database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		err := connexion.Close()
+		if err != nil {
+			t.Fatalf("NewMockDB.Cleanup could clean up temp database (%s): %v", tempDir, err)
+		}
+	})
+
+	return connexion
+}
@@ -0,0 +1,189 @@
+package mocks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// MockIdentityProvider signs ID tokens with a test key and serves its JWKS, standing in for Google or an OIDC provider.
+type MockIdentityProvider struct {
+	Issuer       string
+	ClientID     string
+	KeyID        string
+	JWKSURL      string
+	key          *rsa.PrivateKey
+	jwksRequests int64
+	lock         sync.Mutex
+	tokenForm    url.Values
+}
+
+const mockIdentityProviderKeyBits = 2048
+
+func NewMockIdentityProvider(t *testing.T, issuer string, clientID string, jwksURL string) *MockIdentityProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, mockIdentityProviderKeyBits)
+	if err != nil {
+		t.Fatalf("could not generate identity provider key: %v", err)
+	}
+
+	return &MockIdentityProvider{
+		Issuer:   issuer,
+		ClientID: clientID,
+		KeyID:    "test-key",
+		JWKSURL:  jwksURL,
+		key:      key,
+	}
+}
+
+// JWKS returns the JWKS document publishing the public key.
+func (p *MockIdentityProvider) JWKS() []byte {
+	document := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": p.KeyID,
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	}
+
+	encoded, _ := json.Marshal(document)
+
+	return encoded
+}
+
+// JWKSRequests returns how many times the JWKS was fetched.
+func (p *MockIdentityProvider) JWKSRequests() int64 {
+	return atomic.LoadInt64(&p.jwksRequests)
+}
+
+// SignIDToken returns an ID token valid for an hour, claims override the issuer, audience and times.
+func (p *MockIdentityProvider) SignIDToken(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	mapClaims := jwt.MapClaims{
+		"iss": p.Issuer,
+		"aud": p.ClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	for name, value := range claims {
+		mapClaims[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, mapClaims)
+	token.Header["kid"] = p.KeyID
+
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatalf("could not sign id token: %v", err)
+	}
+
+	return signed
+}
+
+// Discovery returns the OpenID Connect metadata of the provider, its token endpoint is TokenURL.
+func (p *MockIdentityProvider) Discovery() []byte {
+	encoded, _ := json.Marshal(map[string]string{
+		"issuer":                 p.Issuer,
+		"authorization_endpoint": p.Issuer + "/authorize",
+		"token_endpoint":         p.TokenURL(),
+		"jwks_uri":               p.JWKSURL,
+	})
+
+	return encoded
+}
+
+// TokenURL is the token endpoint published by Discovery.
+func (p *MockIdentityProvider) TokenURL() string {
+	return p.Issuer + "/token"
+}
+
+// HTTPClient returns a client serving the JWKS and the discovery metadata, other requests are answered by next when set.
+func (p *MockIdentityProvider) HTTPClient(next RoundTripFunc) *http.Client {
+	return NewMockHTTPClient(func(req *http.Request) *http.Response {
+		if req.URL.String() == p.Issuer+"/.well-known/openid-configuration" {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBuffer(p.Discovery())),
+				Header:     make(http.Header),
+			}
+		}
+
+		if req.URL.String() == p.JWKSURL {
+			atomic.AddInt64(&p.jwksRequests, 1)
+
+			header := make(http.Header)
+			header.Set("Cache-Control", "public, max-age=3600")
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBuffer(p.JWKS())),
+				Header:     header,
+			}
+		}
+
+		if next != nil {
+			return next(req)
+		}
+
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`)),
+			Header:     make(http.Header),
+		}
+	})
+}
+
+// TokenEndpoint returns a handler for HTTPClient answering tokenURL with an ID token signed with claims.
+func (p *MockIdentityProvider) TokenEndpoint(t *testing.T, tokenURL string, claims map[string]interface{}) RoundTripFunc {
+	t.Helper()
+
+	idToken := p.SignIDToken(t, claims)
+
+	return func(req *http.Request) *http.Response {
+		if req.Method != http.MethodPost || req.URL.String() != tokenURL || req.ParseForm() != nil {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`)),
+				Header:     make(http.Header),
+			}
+		}
+
+		p.lock.Lock()
+		p.tokenForm = req.PostForm
+		p.lock.Unlock()
+
+		body, _ := json.Marshal(map[string]string{"id_token": idToken, "access_token": "access_token", "token_type": "Bearer"})
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+			Header:     make(http.Header),
+		}
+	}
+}
+
+// TokenRequest returns the form of the last request answered by TokenEndpoint.
+func (p *MockIdentityProvider) TokenRequest() url.Values {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.tokenForm
+}
@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// NewMockIPPoolRepository returns an actual repos.IPPoolRepository leasing from the "office" pool 10.0.0.0/29 (6 addresses).
+func NewMockIPPoolRepository(t *testing.T) *repos.IPPoolRepository {
+	t.Helper()
+
+	pool, err := repos.NewIPPool("office", "10.0.0.0/29", time.Hour)
+	if err != nil {
+		t.Fatalf("NewMockIPPoolRepository: Could not create pool: %v", err)
+	}
+
+	poolRepo, err := repos.NewIPPoolRepository(NewMockDB(t), []*repos.IPPool{pool})
+	if err != nil {
+		t.Fatalf("NewMockIPPoolRepository: Could not initate ip pool repository: %v", err)
+	}
+
+	return poolRepo
+}
@@ -0,0 +1,210 @@
+package mocks
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// MockSAMLIdentityProvider signs SAML responses with a test key, standing in for a SAML 2.0 identity provider.
+type MockSAMLIdentityProvider struct {
+	EntityID    string
+	Certificate *x509.Certificate
+	key         *rsa.PrivateKey
+}
+
+// SAMLSigning selects the signed element of a mock response.
+type SAMLSigning int
+
+const (
+	SAMLSignAssertion SAMLSigning = iota
+	SAMLSignResponse
+	SAMLSignNothing
+)
+
+// SAMLAssertion describes the assertion of a mock response, Issuer and validity default to the provider and the next 5 minutes.
+// Groups are the values of the multi-valued attribute "groups".
+type SAMLAssertion struct {
+	Issuer       string
+	InResponseTo string
+	Recipient    string
+	Audience     string
+	NameID       string
+	Attributes   map[string]string
+	Groups       []string
+	NotBefore    time.Time
+	NotOnOrAfter time.Time
+}
+
+const (
+	mockSAMLKeyBits  = 2048
+	mockSAMLValidity = 5 * time.Minute
+)
+
+func NewMockSAMLIdentityProvider(t *testing.T, entityID string) *MockSAMLIdentityProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, mockSAMLKeyBits)
+	if err != nil {
+		t.Fatalf("could not generate saml identity provider key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: entityID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create saml identity provider certificate: %v", err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("could not parse saml identity provider certificate: %v", err)
+	}
+
+	return &MockSAMLIdentityProvider{EntityID: entityID, Certificate: certificate, key: key}
+}
+
+// GetKeyPair implements dsig.X509KeyStore.
+func (p *MockSAMLIdentityProvider) GetKeyPair() (*rsa.PrivateKey, []byte, error) {
+	return p.key, p.Certificate.Raw, nil
+}
+
+// CertificatePEM returns the signing certificate as published by identity providers.
+func (p *MockSAMLIdentityProvider) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.Certificate.Raw})
+}
+
+// Response returns the base64 encoded response carrying assertion, as posted by the browser to the service provider.
+func (p *MockSAMLIdentityProvider) Response(t *testing.T, assertion SAMLAssertion, signing SAMLSigning) string {
+	t.Helper()
+
+	now := time.Now().UTC()
+	if len(assertion.Issuer) == 0 {
+		assertion.Issuer = p.EntityID
+	}
+
+	if assertion.NotBefore.IsZero() {
+		assertion.NotBefore = now.Add(-time.Minute)
+	}
+
+	if assertion.NotOnOrAfter.IsZero() {
+		assertion.NotOnOrAfter = now.Add(mockSAMLValidity)
+	}
+
+	signer := dsig.NewDefaultSigningContext(p)
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	document := etree.NewDocument()
+	response := document.CreateElement("samlp:Response")
+	response.CreateAttr("xmlns:samlp", "urn:oasis:names:tc:SAML:2.0:protocol")
+	response.CreateAttr("xmlns:saml", "urn:oasis:names:tc:SAML:2.0:assertion")
+	response.CreateAttr("ID", "_response-"+randomID(t))
+	response.CreateAttr("Version", "2.0")
+	response.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	response.CreateAttr("Destination", assertion.Recipient)
+	response.CreateAttr("InResponseTo", assertion.InResponseTo)
+	response.CreateElement("saml:Issuer").SetText(assertion.Issuer)
+	response.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", "urn:oasis:names:tc:SAML:2.0:status:Success")
+
+	assertionElement := p.assertion(t, assertion, now)
+	if signing == SAMLSignAssertion {
+		signed, err := signer.SignEnveloped(assertionElement)
+		if err != nil {
+			t.Fatalf("could not sign assertion: %v", err)
+		}
+
+		assertionElement = signed
+	}
+
+	response.AddChild(assertionElement)
+
+	if signing == SAMLSignResponse {
+		signed, err := signer.SignEnveloped(response)
+		if err != nil {
+			t.Fatalf("could not sign response: %v", err)
+		}
+
+		document.SetRoot(signed)
+	}
+
+	encoded, err := document.WriteToBytes()
+	if err != nil {
+		t.Fatalf("could not write response: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(encoded)
+}
+
+func (p *MockSAMLIdentityProvider) assertion(t *testing.T, assertion SAMLAssertion, now time.Time) *etree.Element {
+	t.Helper()
+
+	element := etree.NewElement("saml:Assertion")
+	element.CreateAttr("xmlns:saml", "urn:oasis:names:tc:SAML:2.0:assertion")
+	element.CreateAttr("ID", "_assertion-"+randomID(t))
+	element.CreateAttr("Version", "2.0")
+	element.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	element.CreateElement("saml:Issuer").SetText(assertion.Issuer)
+
+	subject := element.CreateElement("saml:Subject")
+	nameID := subject.CreateElement("saml:NameID")
+	nameID.CreateAttr("Format", "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress")
+	nameID.SetText(assertion.NameID)
+
+	confirmation := subject.CreateElement("saml:SubjectConfirmation")
+	confirmation.CreateAttr("Method", "urn:oasis:names:tc:SAML:2.0:cm:bearer")
+
+	confirmationData := confirmation.CreateElement("saml:SubjectConfirmationData")
+	confirmationData.CreateAttr("InResponseTo", assertion.InResponseTo)
+	confirmationData.CreateAttr("Recipient", assertion.Recipient)
+	confirmationData.CreateAttr("NotOnOrAfter", assertion.NotOnOrAfter.UTC().Format(time.RFC3339))
+
+	conditions := element.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", assertion.NotBefore.UTC().Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", assertion.NotOnOrAfter.UTC().Format(time.RFC3339))
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(assertion.Audience)
+
+	statement := element.CreateElement("saml:AttributeStatement")
+
+	for name, value := range assertion.Attributes {
+		attribute := statement.CreateElement("saml:Attribute")
+		attribute.CreateAttr("Name", name)
+		attribute.CreateElement("saml:AttributeValue").SetText(value)
+	}
+
+	if len(assertion.Groups) > 0 {
+		attribute := statement.CreateElement("saml:Attribute")
+		attribute.CreateAttr("Name", "groups")
+
+		for _, group := range assertion.Groups {
+			attribute.CreateElement("saml:AttributeValue").SetText(group)
+		}
+	}
+
+	return element
+}
+
+func randomID(t *testing.T) string {
+	t.Helper()
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("could not generate id: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random)
+}
@@ -0,0 +1,19 @@
+package mocks
+
+import (
+	"testing"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// NewMockSessionRepository returns an actual repos.SessionRepository without sessions in a temporary directory.
+func NewMockSessionRepository(t *testing.T) *repos.SessionRepository {
+	t.Helper()
+
+	sessionRepo, err := repos.NewSessionRepository(NewMockDB(t))
+	if err != nil {
+		t.Fatalf("NewMockSessionRepository: Could not initate session repository: %v", err)
+	}
+
+	return sessionRepo
+}
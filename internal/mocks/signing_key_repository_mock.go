@@ -0,0 +1,19 @@
+package mocks
+
+import (
+	"testing"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// NewMockSigningKeyRepository returns an actual repos.SigningKeyRepository without keys in a temporary directory.
+func NewMockSigningKeyRepository(t *testing.T) *repos.SigningKeyRepository {
+	t.Helper()
+
+	keyRepo, err := repos.NewSigningKeyRepository(NewMockDB(t), "a signing key secret of at least 32 characters")
+	if err != nil {
+		t.Fatalf("NewMockSigningKeyRepository: Could not initate signing key repository: %v", err)
+	}
+
+	return keyRepo
+}
@@ -0,0 +1,218 @@
+package mocks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/p-l/fringe/internal/httpd/services"
+)
+
+// MockWorkspaceDirectory stands in for the Google Workspace directory, in memory or behind its HTTP API.
+// Its HTTP API accepts the service account of ServiceAccountJSON and returns PageSize items per page.
+type MockWorkspaceDirectory struct {
+	TokenURL string
+	PageSize int
+
+	key           *rsa.PrivateKey
+	tokenRequests int64
+	lock          sync.Mutex
+	groups        map[string][]string
+	users         []services.DirectoryUser
+}
+
+const (
+	mockDirectoryAPIURL      = "https://admin.googleapis.com/admin/directory/v1"
+	mockDirectoryAccessToken = "directory-access-token"
+	mockServiceAccountEmail  = "fringe@project.iam.gserviceaccount.com"
+)
+
+func NewMockWorkspaceDirectory(t *testing.T) *MockWorkspaceDirectory {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, mockIdentityProviderKeyBits)
+	if err != nil {
+		t.Fatalf("could not generate service account key: %v", err)
+	}
+
+	return &MockWorkspaceDirectory{
+		TokenURL: "https://oauth2.googleapis.com/token",
+		PageSize: 2,
+		key:      key,
+		groups:   map[string][]string{},
+	}
+}
+
+// SetUserGroups replaces the groups of the user.
+func (d *MockWorkspaceDirectory) SetUserGroups(email string, groups ...string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.groups[strings.ToLower(email)] = groups
+}
+
+// SetUser adds the account or replaces the account with the same email, the domain is taken from the email.
+func (d *MockWorkspaceDirectory) SetUser(user services.DirectoryUser) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	user.Email = strings.ToLower(user.Email)
+
+	for i, existing := range d.users {
+		if existing.Email == user.Email {
+			d.users[i] = user
+
+			return
+		}
+	}
+
+	d.users = append(d.users, user)
+}
+
+// Users implements the directory in memory.
+func (d *MockWorkspaceDirectory) Users(_ context.Context, domain string) ([]services.DirectoryUser, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	users := make([]services.DirectoryUser, 0, len(d.users))
+
+	for _, user := range d.users {
+		if strings.HasSuffix(user.Email, "@"+strings.ToLower(domain)) {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+// UserGroups implements the directory in memory.
+func (d *MockWorkspaceDirectory) UserGroups(_ context.Context, email string) ([]string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]string{}, d.groups[strings.ToLower(email)]...), nil
+}
+
+// ServiceAccountJSON returns the JSON key of the service account accepted by HTTPClient.
+func (d *MockWorkspaceDirectory) ServiceAccountJSON() []byte {
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(d.key)})
+
+	encoded, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": mockServiceAccountEmail,
+		"private_key":  string(privateKey),
+		"token_uri":    d.TokenURL,
+	})
+
+	return encoded
+}
+
+// TokenRequests returns how many access tokens were issued.
+func (d *MockWorkspaceDirectory) TokenRequests() int64 {
+	return atomic.LoadInt64(&d.tokenRequests)
+}
+
+// HTTPClient returns a client serving the token endpoint and the directory API.
+func (d *MockWorkspaceDirectory) HTTPClient() *http.Client {
+	return NewMockHTTPClient(func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost && req.URL.String() == d.TokenURL {
+			return d.token(req)
+		}
+
+		if req.Header.Get("Authorization") != "Bearer "+mockDirectoryAccessToken {
+			return jsonResponse(http.StatusUnauthorized, map[string]string{})
+		}
+
+		if req.Method == http.MethodGet && req.URL.Scheme+"://"+req.URL.Host+req.URL.Path == mockDirectoryAPIURL+"/groups" {
+			groups, _ := d.UserGroups(req.Context(), req.URL.Query().Get("userKey"))
+			items := make([]map[string]interface{}, 0, len(groups))
+
+			for _, group := range groups {
+				items = append(items, map[string]interface{}{"email": group})
+			}
+
+			return d.page(req, "groups", items)
+		}
+
+		if req.Method == http.MethodGet && req.URL.Scheme+"://"+req.URL.Host+req.URL.Path == mockDirectoryAPIURL+"/users" {
+			users, _ := d.Users(req.Context(), req.URL.Query().Get("domain"))
+			items := make([]map[string]interface{}, 0, len(users))
+
+			for _, user := range users {
+				items = append(items, map[string]interface{}{
+					"primaryEmail":      user.Email,
+					"aliases":           user.Aliases,
+					"name":              map[string]string{"fullName": user.Name},
+					"thumbnailPhotoUrl": user.Picture,
+					"suspended":         user.Suspended,
+				})
+			}
+
+			return d.page(req, "users", items)
+		}
+
+		return jsonResponse(http.StatusNotFound, map[string]string{})
+	})
+}
+
+// token checks the service account assertion and issues an access token.
+func (d *MockWorkspaceDirectory) token(req *http.Request) *http.Response {
+	if req.ParseForm() != nil || req.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		return jsonResponse(http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+	}
+
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(req.PostForm.Get("assertion"), claims, func(token *jwt.Token) (interface{}, error) {
+		return &d.key.PublicKey, nil
+	})
+	if err != nil || claims["iss"] != mockServiceAccountEmail || claims["aud"] != d.TokenURL || claims["sub"] == nil {
+		return jsonResponse(http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	}
+
+	atomic.AddInt64(&d.tokenRequests, 1)
+
+	return jsonResponse(http.StatusOK, map[string]interface{}{"access_token": mockDirectoryAccessToken, "expires_in": 3600, "token_type": "Bearer"})
+}
+
+// page returns the page of items selected by the pageToken of req, tokens are offsets.
+func (d *MockWorkspaceDirectory) page(req *http.Request, name string, items []map[string]interface{}) *http.Response {
+	offset, _ := strconv.Atoi(req.URL.Query().Get("pageToken"))
+	if offset > len(items) {
+		offset = len(items)
+	}
+
+	end := offset + d.PageSize
+	if end > len(items) {
+		end = len(items)
+	}
+
+	reply := map[string]interface{}{name: items[offset:end]}
+	if end < len(items) {
+		reply["nextPageToken"] = strconv.Itoa(end)
+	}
+
+	return jsonResponse(http.StatusOK, reply)
+}
+
+func jsonResponse(statusCode int, reply interface{}) *http.Response {
+	body, _ := json.Marshal(reply)
+
+	return &http.Response{
+		StatusCode: statusCode,
+		Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+		Header:     make(http.Header),
+	}
+}
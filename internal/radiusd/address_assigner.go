@@ -0,0 +1,109 @@
+package radiusd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mrz1836/go-sanitize"
+	"github.com/p-l/fringe/internal/repos"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+)
+
+// AddressAssigner leases addresses from the IP pools and adds them to Access-Accept replies.
+type AddressAssigner struct {
+	poolRepo      *repos.IPPoolRepository
+	defaultPool   string
+	poolAttribute string
+}
+
+// NewAddressAssigner returns an AddressAssigner leasing from the pool named by the user poolAttribute, or from defaultPool.
+// Users without a pool only get their reserved address, if any.
+func NewAddressAssigner(poolRepo *repos.IPPoolRepository, defaultPool string, poolAttribute string) (*AddressAssigner, error) {
+	defaultPool = strings.TrimSpace(defaultPool)
+	if len(defaultPool) > 0 {
+		if _, err := poolRepo.Pool(defaultPool); err != nil {
+			return nil, fmt.Errorf("invalid default ip pool: %w", err)
+		}
+	}
+
+	return &AddressAssigner{
+		poolRepo:      poolRepo,
+		defaultPool:   defaultPool,
+		poolAttribute: strings.ToLower(strings.TrimSpace(poolAttribute)),
+	}, nil
+}
+
+// SessionID returns the Acct-Session-Id of the request, or its Calling-Station-Id when the NAS sends no session.
+// Requests with neither are refused an address, see repos.ErrMissingSessionID.
+func SessionID(packet *radius.Packet) string {
+	if sessionID := rfc2866.AcctSessionID_GetString(packet); len(sessionID) > 0 {
+		return sessionID
+	}
+
+	return rfc2865.CallingStationID_GetString(packet)
+}
+
+// poolFor returns the name of the pool the user leases from, empty when the user has no pool.
+func (a *AddressAssigner) poolFor(user *repos.User) string {
+	if len(a.poolAttribute) > 0 {
+		if pool, found := user.Attributes[a.poolAttribute]; found {
+			return pool
+		}
+	}
+
+	return a.defaultPool
+}
+
+// Assign leases an address to the user for the session of request and sets Framed-IP-Address and Framed-IP-Netmask in response.
+func (a *AddressAssigner) Assign(ctx context.Context, request *radius.Packet, response *radius.Packet, user *repos.User, now time.Time) (*repos.IPLease, error) {
+	poolName := a.poolFor(user)
+	if len(poolName) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	lease, err := a.poolRepo.Allocate(ctx, poolName, user.Email, SessionID(request), now)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	pool, err := a.poolRepo.Pool(lease.Pool)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	if err := rfc2865.FramedIPAddress_Set(response, net.ParseIP(lease.Address)); err != nil {
+		return nil, fmt.Errorf("could not set Framed-IP-Address: %w", err)
+	}
+
+	if err := rfc2865.FramedIPNetmask_Set(response, pool.Netmask()); err != nil {
+		return nil, fmt.Errorf("could not set Framed-IP-Netmask: %w", err)
+	}
+
+	return lease, nil
+}
+
+// Account renews the lease of the address on Start and Interim-Update and releases it on Stop.
+func (a *AddressAssigner) Account(ctx context.Context, request *radius.Packet, now time.Time) error {
+	address := rfc2865.FramedIPAddress_Get(request)
+	if address == nil {
+		return nil
+	}
+
+	email := sanitize.Email(rfc2865.UserName_GetString(request), false)
+
+	switch rfc2866.AcctStatusType_Get(request) {
+	case rfc2866.AcctStatusType_Value_Stop:
+		return a.poolRepo.Release(ctx, address.String(), email) //nolint:wrapcheck
+	case rfc2866.AcctStatusType_Value_Start, rfc2866.AcctStatusType_Value_InterimUpdate:
+		_, err := a.poolRepo.Renew(ctx, address.String(), email, SessionID(request), now)
+
+		return err //nolint:wrapcheck
+	default:
+		return nil
+	}
+}
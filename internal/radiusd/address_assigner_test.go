@@ -0,0 +1,138 @@
+package radiusd_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/radiusd"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+)
+
+func newAccessRequest(t *testing.T, email string, sessionID string) *radius.Packet {
+	t.Helper()
+
+	request := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	assert.NoError(t, rfc2865.UserName_SetString(request, email))
+	assert.NoError(t, rfc2866.AcctSessionID_SetString(request, sessionID))
+
+	return request
+}
+
+func TestNewAddressAssigner(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses unknown default pool", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := radiusd.NewAddressAssigner(mocks.NewMockIPPoolRepository(t), "lab", "")
+		assert.ErrorIs(t, err, repos.ErrUnknownIPPool)
+	})
+}
+
+func TestAddressAssigner_Assign(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	t.Run("Sets the leased address and netmask", func(t *testing.T) {
+		t.Parallel()
+
+		addresses, err := radiusd.NewAddressAssigner(mocks.NewMockIPPoolRepository(t), "office", "")
+		assert.NoError(t, err)
+
+		request := newAccessRequest(t, "user@test.com", "session-1")
+		response := request.Response(radius.CodeAccessAccept)
+
+		lease, err := addresses.Assign(context.Background(), request, response, &repos.User{Email: "user@test.com"}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, "session-1", lease.SessionID)
+		assert.Equal(t, net.ParseIP("10.0.0.1").To4(), rfc2865.FramedIPAddress_Get(response).To4())
+		assert.Equal(t, net.ParseIP("255.255.255.248").To4(), rfc2865.FramedIPNetmask_Get(response).To4())
+	})
+
+	t.Run("Requests without session get no address", func(t *testing.T) {
+		t.Parallel()
+
+		addresses, err := radiusd.NewAddressAssigner(mocks.NewMockIPPoolRepository(t), "office", "")
+		assert.NoError(t, err)
+
+		request := radius.New(radius.CodeAccessRequest, []byte("secret"))
+		response := request.Response(radius.CodeAccessAccept)
+
+		_, err = addresses.Assign(context.Background(), request, response, &repos.User{Email: "user@test.com"}, now)
+		assert.ErrorIs(t, err, repos.ErrMissingSessionID)
+		assert.Nil(t, rfc2865.FramedIPAddress_Get(response))
+	})
+
+	t.Run("Users without pool get no address", func(t *testing.T) {
+		t.Parallel()
+
+		addresses, err := radiusd.NewAddressAssigner(mocks.NewMockIPPoolRepository(t), "", "ip-pool")
+		assert.NoError(t, err)
+
+		request := newAccessRequest(t, "user@test.com", "session-1")
+		response := request.Response(radius.CodeAccessAccept)
+
+		lease, err := addresses.Assign(context.Background(), request, response, &repos.User{Email: "user@test.com"}, now)
+		assert.NoError(t, err)
+		assert.Nil(t, lease)
+		assert.Nil(t, rfc2865.FramedIPAddress_Get(response))
+	})
+
+	t.Run("Pool is selected by user attribute", func(t *testing.T) {
+		t.Parallel()
+
+		addresses, err := radiusd.NewAddressAssigner(mocks.NewMockIPPoolRepository(t), "", "ip-pool")
+		assert.NoError(t, err)
+
+		request := newAccessRequest(t, "user@test.com", "session-1")
+		response := request.Response(radius.CodeAccessAccept)
+		user := repos.User{Email: "user@test.com", Attributes: map[string]string{"ip-pool": "office"}}
+
+		lease, err := addresses.Assign(context.Background(), request, response, &user, now)
+		assert.NoError(t, err)
+		assert.Equal(t, "office", lease.Pool)
+
+		user.Attributes["ip-pool"] = "lab"
+		_, err = addresses.Assign(context.Background(), request, response, &user, now)
+		assert.ErrorIs(t, err, repos.ErrUnknownIPPool)
+	})
+}
+
+func TestAddressAssigner_Account(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Stop releases the address", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		addresses, _ := radiusd.NewAddressAssigner(poolRepo, "office", "")
+
+		request := newAccessRequest(t, "user@test.com", "session-1")
+		lease, _ := addresses.Assign(context.Background(), request, request.Response(radius.CodeAccessAccept), &repos.User{Email: "user@test.com"}, now)
+
+		accounting := radius.New(radius.CodeAccountingRequest, []byte("secret"))
+		_ = rfc2865.UserName_SetString(accounting, "user@test.com")
+		_ = rfc2865.FramedIPAddress_Set(accounting, net.ParseIP(lease.Address))
+		_ = rfc2866.AcctStatusType_Set(accounting, rfc2866.AcctStatusType_Value_InterimUpdate)
+
+		assert.NoError(t, addresses.Account(context.Background(), accounting, now.Add(30*time.Minute)))
+
+		leases, _ := poolRepo.Leases(context.Background(), now)
+		assert.Equal(t, now.Add(90*time.Minute).Unix(), leases[0].ExpiresAt)
+
+		_ = rfc2866.AcctStatusType_Set(accounting, rfc2866.AcctStatusType_Value_Stop)
+		assert.NoError(t, addresses.Account(context.Background(), accounting, now))
+
+		leases, _ = poolRepo.Leases(context.Background(), now)
+		assert.Empty(t, leases)
+	})
+}
@@ -0,0 +1,182 @@
+package radiusd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/p-l/fringe/internal/repos"
+)
+
+// OTPMode selects how a NAS client sends the one-time password of users enrolled in TOTP.
+type OTPMode string
+
+const (
+	// OTPModeConcatenated expects the code appended to the password in a single Access-Request.
+	OTPModeConcatenated OTPMode = "concatenated"
+	// OTPModeChallenge answers the password with an Access-Challenge asking for the code in a second Access-Request.
+	OTPModeChallenge OTPMode = "challenge"
+)
+
+var (
+	ErrInvalidOTPMode   = errors.New("invalid one-time password mode")
+	ErrInvalidNASClient = errors.New("invalid nas client")
+	ErrUnknownChallenge = errors.New("unknown or expired challenge")
+)
+
+const (
+	// ChallengeTimeout is how long users have to answer an Access-Challenge.
+	ChallengeTimeout     = 2 * time.Minute
+	ChallengeMessage     = "Enter your verification code"
+	challengeStateLength = 16
+)
+
+// ParseOTPMode returns the mode named by mode, an empty mode is OTPModeConcatenated.
+func ParseOTPMode(mode string) (OTPMode, error) {
+	switch OTPMode(strings.ToLower(strings.TrimSpace(mode))) {
+	case "", OTPModeConcatenated:
+		return OTPModeConcatenated, nil
+	case OTPModeChallenge:
+		return OTPModeChallenge, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidOTPMode, mode)
+	}
+}
+
+// NASClient is a NAS, or a network of NAS, sending requests with its own OTPMode.
+type NASClient struct {
+	Name    string
+	Network *net.IPNet
+	OTPMode OTPMode
+}
+
+// NewNASClient returns the NAS client at address, a single IP or a CIDR network.
+func NewNASClient(name string, address string, mode string) (*NASClient, error) {
+	otpMode, err := ParseOTPMode(mode)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidNASClient, name, err)
+	}
+
+	address = strings.TrimSpace(address)
+	if ip := net.ParseIP(address); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+
+		return &NASClient{Name: name, Network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, OTPMode: otpMode}, nil
+	}
+
+	_, network, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: address must be an IP or a CIDR: %v", ErrInvalidNASClient, name, err)
+	}
+
+	return &NASClient{Name: name, Network: network, OTPMode: otpMode}, nil
+}
+
+type otpChallenge struct {
+	email     string
+	expiresAt time.Time
+}
+
+// OTPPolicy selects the OTPMode of each NAS client and keeps the pending challenges.
+// A nil OTPPolicy uses OTPModeConcatenated for every client.
+type OTPPolicy struct {
+	defaultMode OTPMode
+	clients     []NASClient
+
+	lock       sync.Mutex
+	challenges map[string]otpChallenge
+}
+
+// NewOTPPolicy returns an OTPPolicy using defaultMode for NAS not matching any of clients.
+func NewOTPPolicy(defaultMode OTPMode, clients []NASClient) *OTPPolicy {
+	return &OTPPolicy{
+		defaultMode: defaultMode,
+		clients:     clients,
+		challenges:  map[string]otpChallenge{},
+	}
+}
+
+// ModeFor returns the mode of the first client matching the address of the NAS.
+func (p *OTPPolicy) ModeFor(addr net.Addr) OTPMode {
+	if p == nil {
+		return OTPModeConcatenated
+	}
+
+	var ip net.IP
+
+	switch typed := addr.(type) {
+	case *net.UDPAddr:
+		ip = typed.IP
+	case *net.TCPAddr:
+		ip = typed.IP
+	}
+
+	for _, client := range p.clients {
+		if ip != nil && client.Network.Contains(ip) {
+			return client.OTPMode
+		}
+	}
+
+	return p.defaultMode
+}
+
+// Challenge records that the user gave a valid password and returns the State sent in the Access-Challenge.
+func (p *OTPPolicy) Challenge(email string, now time.Time) (string, error) {
+	state := make([]byte, challengeStateLength)
+	if _, err := rand.Read(state); err != nil {
+		return "", fmt.Errorf("could not generate challenge state: %w", err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for pending, challenge := range p.challenges {
+		if now.After(challenge.expiresAt) {
+			delete(p.challenges, pending)
+		}
+	}
+
+	encoded := hex.EncodeToString(state)
+	p.challenges[encoded] = otpChallenge{email: repos.CanonicalEmail(email), expiresAt: now.Add(ChallengeTimeout)}
+
+	return encoded, nil
+}
+
+// Answer consumes the challenge with state, it fails when the challenge expired or was sent to another user.
+func (p *OTPPolicy) Answer(state string, email string, now time.Time) error {
+	if p == nil {
+		return ErrUnknownChallenge
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	challenge, found := p.challenges[state]
+	delete(p.challenges, state)
+
+	if !found || now.After(challenge.expiresAt) || challenge.email != repos.CanonicalEmail(email) {
+		return ErrUnknownChallenge
+	}
+
+	return nil
+}
+
+// SplitOTP returns the password and the code appended to it by users of OTPModeConcatenated.
+func SplitOTP(password string) (string, string, error) {
+	if len(password) <= repos.TOTPDigits {
+		return "", "", repos.ErrInvalidOTP
+	}
+
+	split := len(password) - repos.TOTPDigits
+
+	return password[:split], password[split:], nil
+}
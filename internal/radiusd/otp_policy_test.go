@@ -0,0 +1,108 @@
+package radiusd_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/radiusd"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNASClient(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Accepts addresses and networks", func(t *testing.T) {
+		t.Parallel()
+
+		client, err := radiusd.NewNASClient("vpn", "10.0.0.1", "Challenge")
+		assert.NoError(t, err)
+		assert.Equal(t, radiusd.OTPModeChallenge, client.OTPMode)
+		assert.Equal(t, "10.0.0.1/32", client.Network.String())
+
+		client, err = radiusd.NewNASClient("wifi", "10.1.0.0/16", "")
+		assert.NoError(t, err)
+		assert.Equal(t, radiusd.OTPModeConcatenated, client.OTPMode)
+		assert.Equal(t, "10.1.0.0/16", client.Network.String())
+	})
+
+	t.Run("Refuses invalid addresses and modes", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := radiusd.NewNASClient("vpn", "vpn.test.com", "challenge")
+		assert.ErrorIs(t, err, radiusd.ErrInvalidNASClient)
+
+		_, err = radiusd.NewNASClient("vpn", "10.0.0.1", "push")
+		assert.ErrorIs(t, err, radiusd.ErrInvalidNASClient)
+	})
+}
+
+func TestOTPPolicy_ModeFor(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Clients override the default mode", func(t *testing.T) {
+		t.Parallel()
+
+		vpn, _ := radiusd.NewNASClient("vpn", "10.0.0.1", "challenge")
+		policy := radiusd.NewOTPPolicy(radiusd.OTPModeConcatenated, []radiusd.NASClient{*vpn})
+
+		assert.Equal(t, radiusd.OTPModeChallenge, policy.ModeFor(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1645}))
+		assert.Equal(t, radiusd.OTPModeConcatenated, policy.ModeFor(&net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1645}))
+	})
+
+	t.Run("Nil policies concatenate", func(t *testing.T) {
+		t.Parallel()
+
+		var policy *radiusd.OTPPolicy
+
+		assert.Equal(t, radiusd.OTPModeConcatenated, policy.ModeFor(&net.UDPAddr{IP: net.ParseIP("10.0.0.1")}))
+		assert.ErrorIs(t, policy.Answer("state", "user@test.com", time.Now()), radiusd.ErrUnknownChallenge)
+	})
+}
+
+func TestOTPPolicy_Challenge(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Challenges are answered once by the same user", func(t *testing.T) {
+		t.Parallel()
+
+		policy := radiusd.NewOTPPolicy(radiusd.OTPModeChallenge, nil)
+		now := time.Now()
+
+		state, err := policy.Challenge("User@test.com", now)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, policy.Answer(state, "other@test.com", now), radiusd.ErrUnknownChallenge)
+
+		state, _ = policy.Challenge("User@test.com", now)
+		assert.NoError(t, policy.Answer(state, "user@test.com", now))
+		assert.ErrorIs(t, policy.Answer(state, "user@test.com", now), radiusd.ErrUnknownChallenge)
+	})
+
+	t.Run("Challenges expire", func(t *testing.T) {
+		t.Parallel()
+
+		policy := radiusd.NewOTPPolicy(radiusd.OTPModeChallenge, nil)
+		now := time.Now()
+
+		state, _ := policy.Challenge("user@test.com", now)
+		err := policy.Answer(state, "user@test.com", now.Add(radiusd.ChallengeTimeout+time.Second))
+		assert.ErrorIs(t, err, radiusd.ErrUnknownChallenge)
+	})
+}
+
+func TestSplitOTP(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Splits the code from the end of the password", func(t *testing.T) {
+		t.Parallel()
+
+		password, code, err := radiusd.SplitOTP("a-password123456")
+		assert.NoError(t, err)
+		assert.Equal(t, "a-password", password)
+		assert.Equal(t, "123456", code)
+
+		_, _, err = radiusd.SplitOTP("123456")
+		assert.ErrorIs(t, err, repos.ErrInvalidOTP)
+	})
+}
@@ -0,0 +1,84 @@
+package radiusd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/p-l/fringe/internal/repos"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+var ErrUnsupportedReplyAttribute = errors.New("unsupported radius reply attribute")
+
+type replyAttributeSetter func(packet *radius.Packet, value string) error
+
+// replyAttributeSetters lists the RADIUS attributes that can be filled from user attributes, by lower case name.
+var replyAttributeSetters = map[string]replyAttributeSetter{ //nolint:gochecknoglobals
+	"class":         rfc2865.Class_AddString,
+	"filter-id":     rfc2865.FilterID_AddString,
+	"reply-message": rfc2865.ReplyMessage_AddString,
+	"callback-id":   rfc2865.CallbackID_AddString,
+	"session-timeout": func(packet *radius.Packet, value string) error {
+		seconds, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid Session-Timeout '%s': %w", value, err)
+		}
+
+		return rfc2865.SessionTimeout_Add(packet, rfc2865.SessionTimeout(seconds))
+	},
+	"idle-timeout": func(packet *radius.Packet, value string) error {
+		seconds, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid Idle-Timeout '%s': %w", value, err)
+		}
+
+		return rfc2865.IdleTimeout_Add(packet, rfc2865.IdleTimeout(seconds))
+	},
+}
+
+// ReplyAttributes maps RADIUS attribute names to the user attribute copied into Access-Accept replies.
+type ReplyAttributes map[string]string
+
+// NewReplyAttributes validates the mapping, RADIUS attribute names are case insensitive.
+func NewReplyAttributes(mapping map[string]string) (ReplyAttributes, error) {
+	attributes := ReplyAttributes{}
+
+	for radiusName, userAttribute := range mapping {
+		radiusName = strings.ToLower(strings.TrimSpace(radiusName))
+		if _, found := replyAttributeSetters[radiusName]; !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedReplyAttribute, radiusName)
+		}
+
+		attributes[radiusName] = strings.ToLower(strings.TrimSpace(userAttribute))
+	}
+
+	return attributes, nil
+}
+
+// Apply adds the mapped attributes of the user to the packet, attributes the user does not have are skipped.
+func (a ReplyAttributes) Apply(packet *radius.Packet, user *repos.User) error {
+	radiusNames := make([]string, 0, len(a))
+	for radiusName := range a {
+		radiusNames = append(radiusNames, radiusName)
+	}
+
+	// Replies are easier to compare in captures when attributes always come in the same order
+	sort.Strings(radiusNames)
+
+	for _, radiusName := range radiusNames {
+		value, found := user.Attributes[a[radiusName]]
+		if !found {
+			continue
+		}
+
+		if err := replyAttributeSetters[radiusName](packet, value); err != nil {
+			return fmt.Errorf("could not add %s to reply: %w", radiusName, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package radiusd_test
+
+import (
+	"testing"
+
+	"github.com/p-l/fringe/internal/radiusd"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func TestNewReplyAttributes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses unsupported attributes", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := radiusd.NewReplyAttributes(map[string]string{"Framed-Protocol": "protocol"})
+		assert.ErrorIs(t, err, radiusd.ErrUnsupportedReplyAttribute)
+	})
+
+	t.Run("Attribute names ignore case", func(t *testing.T) {
+		t.Parallel()
+
+		attributes, err := radiusd.NewReplyAttributes(map[string]string{"Filter-Id": "VPN-Filter"})
+		assert.NoError(t, err)
+		assert.Equal(t, radiusd.ReplyAttributes{"filter-id": "vpn-filter"}, attributes)
+	})
+}
+
+func TestReplyAttributes_Apply(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Copies the mapped user attributes", func(t *testing.T) {
+		t.Parallel()
+
+		attributes, err := radiusd.NewReplyAttributes(map[string]string{"class": "vpn-class", "filter-id": "vpn-filter", "session-timeout": "session"})
+		assert.NoError(t, err)
+
+		packet := radius.New(radius.CodeAccessAccept, []byte("secret"))
+		user := repos.User{Attributes: map[string]string{"vpn-class": "contractors", "session": "3600", "department": "Sales"}}
+
+		assert.NoError(t, attributes.Apply(packet, &user))
+		assert.Equal(t, "contractors", rfc2865.Class_GetString(packet))
+		assert.Equal(t, rfc2865.SessionTimeout(3600), rfc2865.SessionTimeout_Get(packet))
+
+		// Users without the attribute get no Filter-Id
+		_, err = rfc2865.FilterID_Lookup(packet)
+		assert.ErrorIs(t, err, radius.ErrNoAttribute)
+	})
+
+	t.Run("Refuses invalid numeric values", func(t *testing.T) {
+		t.Parallel()
+
+		attributes, err := radiusd.NewReplyAttributes(map[string]string{"idle-timeout": "idle"})
+		assert.NoError(t, err)
+
+		packet := radius.New(radius.CodeAccessAccept, []byte("secret"))
+		user := repos.User{Attributes: map[string]string{"idle": "soon"}}
+
+		assert.Error(t, attributes.Apply(packet, &user))
+	})
+}
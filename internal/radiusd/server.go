@@ -1,16 +1,82 @@
 package radiusd
 
 import (
+	"errors"
 	"log"
+	"time"
 
 	"github.com/mrz1836/go-sanitize"
 	"github.com/p-l/fringe/internal/repos"
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
 )
 
+// authentication is the outcome of an Access-Request, a pending challenge is sent when challenge is set.
+type authentication struct {
+	credential    *repos.Credential
+	authenticated bool
+	challenge     string
+}
+
+// authenticate checks the password of the request and, for users enrolled in TOTP, their one-time password.
+func authenticate(request *radius.Request, repo *repos.UserRepository, otp *OTPPolicy, username string, password string) (authentication, error) {
+	ctx := request.Context()
+	now := time.Now()
+
+	if state := rfc2865.State_GetString(request.Packet); len(state) > 0 {
+		if err := otp.Answer(state, username, now); err != nil {
+			return authentication{}, err
+		}
+
+		err := repo.VerifyTOTP(ctx, username, password, now)
+
+		return authentication{authenticated: err == nil}, err //nolint:wrapcheck
+	}
+
+	enrolled, err := repo.HasTOTP(ctx, username)
+	if err != nil {
+		return authentication{}, err //nolint:wrapcheck
+	}
+
+	if !enrolled {
+		credential, authenticated, err := repo.AuthenticateCredential(ctx, username, password)
+
+		return authentication{credential: credential, authenticated: authenticated}, err //nolint:wrapcheck
+	}
+
+	if otp.ModeFor(request.RemoteAddr) == OTPModeChallenge {
+		_, authenticated, err := repo.AuthenticateCredential(ctx, username, password)
+		if err != nil || !authenticated {
+			return authentication{}, err //nolint:wrapcheck
+		}
+
+		state, err := otp.Challenge(username, now)
+
+		return authentication{challenge: state}, err
+	}
+
+	password, code, err := SplitOTP(password)
+	if err != nil {
+		return authentication{}, err
+	}
+
+	credential, authenticated, err := repo.AuthenticateCredential(ctx, username, password)
+	if err != nil || !authenticated {
+		return authentication{}, err //nolint:wrapcheck
+	}
+
+	if err := repo.VerifyTOTP(ctx, username, code, now); err != nil {
+		return authentication{}, err //nolint:wrapcheck
+	}
+
+	return authentication{credential: credential, authenticated: true}, nil
+}
+
 // NewRadiusServer Creates and configure the Radius Server.
-func NewRadiusServer(repo *repos.UserRepository, secret string, listenAddress string) *radius.PacketServer {
+// Access-Accept replies carry the user attributes mapped by replyAttributes and, when addresses is not nil, a leased address.
+// Users enrolled in TOTP also give a one-time password, sent as selected by otp for the NAS.
+func NewRadiusServer(repo *repos.UserRepository, secret string, listenAddress string, replyAttributes ReplyAttributes, addresses *AddressAssigner, otp *OTPPolicy) *radius.PacketServer {
 	handler := func(writer radius.ResponseWriter, request *radius.Request) {
 		username := sanitize.Email(rfc2865.UserName_GetString(request.Packet), false)
 		password := sanitize.SingleLine(rfc2865.UserPassword_GetString(request.Packet))
@@ -22,18 +88,70 @@ func NewRadiusServer(repo *repos.UserRepository, secret string, listenAddress st
 			log.Printf("WARN: No password provided in radiusd request from: %v", request.RemoteAddr)
 		}
 
-		authenticated, err := repo.Authenticate(username, password)
-		if err != nil {
+		result, err := authenticate(request, repo, otp, username, password)
+		if errors.Is(err, repos.ErrTimeout) {
+			// Not answering lets the NAS retransmit instead of rejecting a user that may be valid
+			log.Printf("ERR: Timed out authenticating request from %v, no response sent: %v", request.RemoteAddr, err)
+
+			return
+		}
+
+		switch {
+		case errors.Is(err, repos.ErrUserExpired):
+			log.Printf("REJECT: Expired account %s in request from %v: %v", username, request.RemoteAddr, err)
+		case errors.Is(err, repos.ErrInvalidOTP), errors.Is(err, repos.ErrOTPReplayed), errors.Is(err, ErrUnknownChallenge):
+			log.Printf("REJECT: Invalid one-time password for %s in request from %v: %v", username, request.RemoteAddr, err)
+		case err != nil:
 			log.Printf("ERR: Could not authenticate request from %v: %v", request.RemoteAddr, err)
 		}
 
+		if err == nil && len(result.challenge) > 0 {
+			writeChallenge(writer, request, result.challenge)
+
+			return
+		}
+
+		authenticated := result.authenticated && err == nil
 		if authenticated {
 			code = radius.CodeAccessAccept
+
+			if result.credential != nil {
+				log.Printf("Authenticated %s with credential %s (%s)", username, result.credential.ID, result.credential.Name)
+			}
+		}
+
+		response := request.Response(code)
+
+		if authenticated && (len(replyAttributes) > 0 || addresses != nil) {
+			user, err := repo.FindByEmail(request.Context(), username)
+			if errors.Is(err, repos.ErrTimeout) {
+				log.Printf("ERR: Timed out reading %s attributes for request from %v, no response sent: %v", username, request.RemoteAddr, err)
+
+				return
+			}
+
+			if err == nil {
+				err = replyAttributes.Apply(response, user)
+			}
+
+			if err != nil {
+				// Accepting without the attributes could grant more than the policies they select
+				log.Printf("ERR: Could not add %s attributes to response to %v: %v", username, request.RemoteAddr, err)
+				response = request.Response(radius.CodeAccessReject)
+			}
+
+			if err == nil && addresses != nil {
+				response = assignAddress(addresses, request, response, user)
+			}
+		}
+
+		if response == nil {
+			return
 		}
 
-		log.Printf("Response %v to request from %v", code, request.RemoteAddr)
+		log.Printf("Response %v to request from %v", response.Code, request.RemoteAddr)
 
-		err = writer.Write(request.Response(code))
+		err = writer.Write(response)
 		if err != nil {
 			log.Printf("ERR: Could not send responde to %v: %v", request.RemoteAddr, err)
 		}
@@ -48,3 +166,82 @@ func NewRadiusServer(repo *repos.UserRepository, secret string, listenAddress st
 
 	return &server
 }
+
+// writeChallenge asks the user for its one-time password, the NAS sends it back with state.
+func writeChallenge(writer radius.ResponseWriter, request *radius.Request, state string) {
+	response := request.Response(radius.CodeAccessChallenge)
+
+	err := rfc2865.State_SetString(response, state)
+	if err == nil {
+		err = rfc2865.ReplyMessage_SetString(response, ChallengeMessage)
+	}
+
+	if err != nil {
+		log.Printf("ERR: Could not create challenge for request from %v: %v", request.RemoteAddr, err)
+		response = request.Response(radius.CodeAccessReject)
+	}
+
+	log.Printf("Response %v to request from %v", response.Code, request.RemoteAddr)
+
+	if err := writer.Write(response); err != nil {
+		log.Printf("ERR: Could not send responde to %v: %v", request.RemoteAddr, err)
+	}
+}
+
+// assignAddress adds the address leased to the user to response, nil is returned when the request must be left unanswered.
+func assignAddress(addresses *AddressAssigner, request *radius.Request, response *radius.Packet, user *repos.User) *radius.Packet {
+	lease, err := addresses.Assign(request.Context(), request.Packet, response, user, time.Now())
+	if errors.Is(err, repos.ErrTimeout) {
+		log.Printf("ERR: Timed out leasing address to %s for request from %v, no response sent: %v", user.Email, request.RemoteAddr, err)
+
+		return nil
+	}
+
+	if err != nil {
+		log.Printf("ERR: Could not lease address to %s for request from %v: %v", user.Email, request.RemoteAddr, err)
+
+		return request.Response(radius.CodeAccessReject)
+	}
+
+	if lease != nil {
+		log.Printf("Leased %s from pool %s to %s until %s", lease.Address, lease.Pool, user.Email, time.Unix(lease.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+
+	return response
+}
+
+// NewAccountingServer Creates and configure the Radius accounting server.
+// Accounting Start and Interim-Update renew address leases, Stop releases them.
+func NewAccountingServer(addresses *AddressAssigner, secret string, listenAddress string) *radius.PacketServer {
+	handler := func(writer radius.ResponseWriter, request *radius.Request) {
+		username := sanitize.Email(rfc2865.UserName_GetString(request.Packet), false)
+		status := rfc2866.AcctStatusType_Get(request.Packet)
+
+		log.Printf("Radius accounting %v for %s from %v", status, username, request.RemoteAddr)
+
+		err := addresses.Account(request.Context(), request.Packet, time.Now())
+		if errors.Is(err, repos.ErrTimeout) {
+			log.Printf("ERR: Timed out accounting request from %v, no response sent: %v", request.RemoteAddr, err)
+
+			return
+		}
+
+		if err != nil {
+			// The NAS would retransmit forever, the lease expires on its own
+			log.Printf("WARN: Could not account %v for %s from %v: %v", status, username, request.RemoteAddr, err)
+		}
+
+		if err := writer.Write(request.Response(radius.CodeAccountingResponse)); err != nil {
+			log.Printf("ERR: Could not send accounting response to %v: %v", request.RemoteAddr, err)
+		}
+	}
+
+	log.Printf("Created radius accounting server on %s", listenAddress)
+	server := radius.PacketServer{
+		Addr:         listenAddress,
+		Handler:      radius.HandlerFunc(handler),
+		SecretSource: radius.StaticSecretSource([]byte(secret)),
+	}
+
+	return &server
+}
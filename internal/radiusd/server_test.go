@@ -0,0 +1,126 @@
+package radiusd_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/radiusd"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+const testRadiusSecret = "radius-secret"
+
+// startRadiusServer serves the radius server on a local port and returns its address.
+func startRadiusServer(t *testing.T, userRepo *repos.UserRepository, otp *radiusd.OTPPolicy) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := radiusd.NewRadiusServer(userRepo, testRadiusSecret, conn.LocalAddr().String(), nil, nil, otp)
+
+	go func() { _ = server.Serve(conn) }()
+
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+
+	return conn.LocalAddr().String()
+}
+
+func accessRequest(t *testing.T, address string, email string, password string, state string) *radius.Packet {
+	t.Helper()
+
+	request := radius.New(radius.CodeAccessRequest, []byte(testRadiusSecret))
+	_ = rfc2865.UserName_SetString(request, email)
+
+	// The encoder reads a full block, the capacity must cover it
+	plaintext := make([]byte, len(password), len(password)+16)
+	copy(plaintext, password)
+	_ = rfc2865.UserPassword_Set(request, plaintext)
+
+	if len(state) > 0 {
+		_ = rfc2865.State_SetString(request, state)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := radius.Exchange(ctx, request, address)
+	assert.NoError(t, err)
+
+	return response
+}
+
+func createTOTPUser(t *testing.T, userRepo *repos.UserRepository, email string) string {
+	t.Helper()
+
+	_, _ = userRepo.Create(context.Background(), email, "", "", "a-password")
+	enrollment, err := userRepo.EnrollTOTP(context.Background(), email, "Fringe")
+	assert.NoError(t, err)
+
+	// Confirming with the code of the previous step leaves the current code usable
+	previous := time.Now().Add(-30 * time.Second)
+	code, _ := totp.GenerateCode(enrollment.Secret, previous)
+	assert.NoError(t, userRepo.ConfirmTOTP(context.Background(), email, code, previous))
+
+	return enrollment.Secret
+}
+
+func TestNewRadiusServer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Users without TOTP only give their password", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		address := startRadiusServer(t, userRepo, nil)
+
+		assert.Equal(t, radius.CodeAccessAccept, accessRequest(t, address, "user@test.com", "a-password", "").Code)
+		assert.Equal(t, radius.CodeAccessReject, accessRequest(t, address, "user@test.com", "wrong-password", "").Code)
+	})
+
+	t.Run("Concatenated codes are accepted once", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		secret := createTOTPUser(t, userRepo, "user@test.com")
+		address := startRadiusServer(t, userRepo, radiusd.NewOTPPolicy(radiusd.OTPModeConcatenated, nil))
+
+		assert.Equal(t, radius.CodeAccessReject, accessRequest(t, address, "user@test.com", "a-password", "").Code)
+
+		code, _ := totp.GenerateCode(secret, time.Now())
+		assert.Equal(t, radius.CodeAccessAccept, accessRequest(t, address, "user@test.com", "a-password"+code, "").Code)
+		assert.Equal(t, radius.CodeAccessReject, accessRequest(t, address, "user@test.com", "a-password"+code, "").Code)
+	})
+
+	t.Run("Challenge asks for the code after the password", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		secret := createTOTPUser(t, userRepo, "user@test.com")
+		local, _ := radiusd.NewNASClient("local", "127.0.0.1", "challenge")
+		address := startRadiusServer(t, userRepo, radiusd.NewOTPPolicy(radiusd.OTPModeConcatenated, []radiusd.NASClient{*local}))
+
+		assert.Equal(t, radius.CodeAccessReject, accessRequest(t, address, "user@test.com", "wrong-password", "").Code)
+
+		challenge := accessRequest(t, address, "user@test.com", "a-password", "")
+		assert.Equal(t, radius.CodeAccessChallenge, challenge.Code)
+		assert.Equal(t, radiusd.ChallengeMessage, rfc2865.ReplyMessage_GetString(challenge))
+
+		state := rfc2865.State_GetString(challenge)
+		assert.NotEmpty(t, state)
+
+		code, _ := totp.GenerateCode(secret, time.Now())
+		assert.Equal(t, radius.CodeAccessAccept, accessRequest(t, address, "user@test.com", code, state).Code)
+
+		// The state is consumed by the first answer
+		assert.Equal(t, radius.CodeAccessReject, accessRequest(t, address, "user@test.com", code, state).Code)
+	})
+}
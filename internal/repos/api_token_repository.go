@@ -0,0 +1,450 @@
+package repos
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// APITokenRepository keeps the service accounts and the API tokens scripts authenticate with.
+// Tokens belong to a user or to a service account, only the sha256 of their secret is stored.
+type APITokenRepository struct {
+	db       *sqlx.DB
+	timeouts Timeouts
+}
+
+// ServiceAccount is an identity of its own for automation, it is not a user and cannot log in.
+type ServiceAccount struct {
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+	CreatedBy   string `db:"created_by" json:"created_by"`
+	CreatedAt   int64  `db:"created_at" json:"created_at"`
+}
+
+// APIToken authenticates as its owner with the permissions listed in Scopes, separated by spaces.
+type APIToken struct {
+	ID         string `db:"id" json:"id"`
+	OwnerType  string `db:"owner_type" json:"owner_type"`
+	Owner      string `db:"owner" json:"owner"`
+	Name       string `db:"name" json:"name"`
+	Scopes     string `db:"scopes" json:"scopes"`
+	SecretHash string `db:"secret_hash" json:"-"`
+	CreatedBy  string `db:"created_by" json:"created_by"`
+	CreatedAt  int64  `db:"created_at" json:"created_at"`
+	ExpiresAt  int64  `db:"expires_at" json:"expires_at"`
+	LastUsedAt int64  `db:"last_used_at" json:"last_used_at"`
+	RevokedAt  int64  `db:"revoked_at" json:"revoked_at"`
+}
+
+var (
+	ErrAPITokenNotFound       = errors.New("api token could not be found")
+	ErrInvalidAPIToken        = errors.New("invalid api token")
+	ErrTooManyAPITokens       = errors.New("owner has too many active api tokens")
+	ErrServiceAccountNotFound = errors.New("service account could not be found")
+	ErrServiceAccountExists   = errors.New("service account already exists")
+	ErrInvalidServiceAccount  = errors.New("invalid service account name")
+
+	serviceAccountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+)
+
+const (
+	APITokenOwnerUser           = "user"
+	APITokenOwnerServiceAccount = "service-account"
+	// APITokenPrefix starts every API token, it tells them apart from access tokens.
+	APITokenPrefix = "fringe_"
+	// APITokensMax bounds the active tokens of an owner.
+	APITokensMax    = 20
+	APITokenNameMax = 64
+	// DefaultAPITokenLifetime applies to tokens created without expiry, none may last longer than MaxAPITokenLifetime.
+	DefaultAPITokenLifetime      = 90 * 24 * time.Hour
+	MaxAPITokenLifetime          = 365 * 24 * time.Hour
+	ServiceAccountDescriptionMax = 256
+	apiTokenIDByteLength         = 8
+	apiTokenSecretByteLength     = 32
+	apiTokenSeparator            = "_"
+	// apiTokenUseResolution bounds how often last_used_at is written for a token used by every request of a script.
+	apiTokenUseResolution = time.Minute
+)
+
+func NewAPITokenRepository(db *sqlx.DB) (*APITokenRepository, error) {
+	if err := createAPITokenTables(db); err != nil {
+		return nil, err
+	}
+
+	return &APITokenRepository{
+		db:       db,
+		timeouts: Timeouts{Read: DefaultReadTimeout, Write: DefaultWriteTimeout},
+	}, nil
+}
+
+// SetTimeouts changes how long each read and write operation may take before failing with ErrTimeout.
+func (r *APITokenRepository) SetTimeouts(timeouts Timeouts) {
+	r.timeouts = timeouts
+}
+
+func createAPITokenTables(db *sqlx.DB) error {
+	createTx := db.MustBegin()
+	defer func() { _ = createTx.Rollback() }()
+
+	createTx.MustExec("CREATE TABLE IF NOT EXISTS service_accounts (" +
+		"name string NOT NULL, " +
+		"description string, " +
+		"created_by string NOT NULL, " +
+		"created_at int64 NOT NULL)")
+	createTx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_service_accounts_name ON service_accounts (name)")
+	createTx.MustExec("CREATE TABLE IF NOT EXISTS api_tokens (" +
+		"id string NOT NULL, " +
+		"owner_type string NOT NULL, " +
+		"owner string NOT NULL, " +
+		"name string NOT NULL, " +
+		"scopes string NOT NULL, " +
+		"secret_hash string NOT NULL, " +
+		"created_by string NOT NULL, " +
+		"created_at int64 NOT NULL, " +
+		"expires_at int64 NOT NULL, " +
+		"last_used_at int64 NOT NULL, " +
+		"revoked_at int64 NOT NULL)")
+	createTx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_id ON api_tokens (id)")
+	createTx.MustExec("CREATE INDEX IF NOT EXISTS idx_api_tokens_owner ON api_tokens (owner)")
+
+	if err := createTx.Commit(); err != nil {
+		return fmt.Errorf("cannot create api_tokens table: %w", err)
+	}
+
+	return nil
+}
+
+// ScopeList returns the scopes of the token.
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// IsActive returns true until the token is revoked or expires.
+func (t *APIToken) IsActive(now time.Time) bool {
+	return t.RevokedAt == 0 && now.Unix() < t.ExpiresAt
+}
+
+// newAPITokenID returns a hexadecimal ID, the separator following it in a token cannot be part of it.
+func newAPITokenID() (string, error) {
+	id := make([]byte, apiTokenIDByteLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("%w: %v", errSessionTokenFailure, err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// canonicalOwner returns the owner as stored, emails of users are canonical.
+func canonicalOwner(ownerType string, owner string) string {
+	if ownerType == APITokenOwnerUser {
+		return CanonicalEmail(owner)
+	}
+
+	return owner
+}
+
+// splitAPIToken returns the ID and the secret of an API token.
+func splitAPIToken(token string) (string, string, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return "", "", ErrAPITokenNotFound
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(token, APITokenPrefix), apiTokenSeparator, 2) //nolint:gomnd
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", ErrAPITokenNotFound
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// CreateServiceAccount adds a service account named with lowercase letters, digits and dashes.
+func (r *APITokenRepository) CreateServiceAccount(ctx context.Context, name string, description string, createdBy string) (*ServiceAccount, error) {
+	description = strings.TrimSpace(description)
+
+	if !serviceAccountNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: use 1 to 63 lowercase letters, digits and dashes", ErrInvalidServiceAccount)
+	}
+
+	if len(description) > ServiceAccountDescriptionMax || strings.ContainsAny(description, "\r\n\t") {
+		return nil, fmt.Errorf("%w: description must be a single line of at most %d characters", ErrInvalidServiceAccount, ServiceAccountDescriptionMax)
+	}
+
+	account := ServiceAccount{Name: name, Description: description, CreatedBy: CanonicalEmail(createdBy), CreatedAt: time.Now().Unix()}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	createTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create service account %s: %w", name, err)
+	}
+	defer func() { _ = createTx.Rollback() }() //nolint:wsl
+
+	var existing int64
+	if err := createTx.GetContext(ctx, &existing, "SELECT count(*) FROM service_accounts WHERE name == $1", name); err != nil {
+		return nil, fmt.Errorf("could not create service account %s: %w", name, err)
+	}
+
+	if existing > 0 {
+		return nil, ErrServiceAccountExists
+	}
+
+	_, err = createTx.ExecContext(ctx, "INSERT INTO service_accounts (name, description, created_by, created_at) VALUES ($1,$2,$3,$4)",
+		account.Name, account.Description, account.CreatedBy, account.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("could not create service account %s: %w", name, err)
+	}
+
+	if err := createTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not create service account %s: %w", name, err)
+	}
+
+	return &account, nil
+}
+
+// ServiceAccounts returns every service account sorted by name.
+func (r *APITokenRepository) ServiceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	accounts := []ServiceAccount{}
+	if err := r.db.SelectContext(ctx, &accounts, "SELECT * FROM service_accounts ORDER BY name"); err != nil {
+		return nil, fmt.Errorf("could not list service accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// DeleteServiceAccount removes the service account and revokes its tokens, they remain listed.
+func (r *APITokenRepository) DeleteServiceAccount(ctx context.Context, name string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	deleteTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not delete service account %s: %w", name, err)
+	}
+	defer func() { _ = deleteTx.Rollback() }() //nolint:wsl
+
+	result, err := deleteTx.ExecContext(ctx, "DELETE FROM service_accounts WHERE name == $1", name)
+	if err != nil {
+		return fmt.Errorf("could not delete service account %s: %w", name, err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrServiceAccountNotFound
+	}
+
+	_, err = deleteTx.ExecContext(ctx, "UPDATE api_tokens SET revoked_at = $1 WHERE owner_type == $2 AND owner == $3 AND revoked_at == 0",
+		time.Now().Unix(), APITokenOwnerServiceAccount, name)
+	if err != nil {
+		return fmt.Errorf("could not revoke service account %s tokens: %w", name, err)
+	}
+
+	if err := deleteTx.Commit(); err != nil {
+		return fmt.Errorf("could not delete service account %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// Create adds a token to the owner and returns it along with the token to send, the only time it is available.
+// Service accounts must exist. Tokens expire at expiresAt, after DefaultAPITokenLifetime when 0.
+func (r *APITokenRepository) Create(ctx context.Context, ownerType string, owner string, name string, scopes []string, expiresAt int64, createdBy string) (*APIToken, string, error) {
+	owner = canonicalOwner(ownerType, owner)
+	name = strings.TrimSpace(name)
+	now := time.Now()
+
+	if expiresAt == 0 {
+		expiresAt = now.Add(DefaultAPITokenLifetime).Unix()
+	}
+
+	if err := validateAPIToken(ownerType, name, expiresAt, now); err != nil {
+		return nil, "", err
+	}
+
+	id, err := newAPITokenID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomSessionToken(apiTokenSecretByteLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := APIToken{
+		ID:         id,
+		OwnerType:  ownerType,
+		Owner:      owner,
+		Name:       name,
+		Scopes:     strings.Join(scopes, " "),
+		SecretHash: hashRefreshSecret(secret),
+		CreatedBy:  CanonicalEmail(createdBy),
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  expiresAt,
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	createTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create api token for %s: %w", owner, err)
+	}
+	defer func() { _ = createTx.Rollback() }() //nolint:wsl
+
+	if ownerType == APITokenOwnerServiceAccount {
+		var accounts int64
+		if err := createTx.GetContext(ctx, &accounts, "SELECT count(*) FROM service_accounts WHERE name == $1", owner); err != nil {
+			return nil, "", fmt.Errorf("could not create api token for %s: %w", owner, err)
+		}
+
+		if accounts == 0 {
+			return nil, "", ErrServiceAccountNotFound
+		}
+	}
+
+	var active int64
+
+	err = createTx.GetContext(ctx, &active, "SELECT count(*) FROM api_tokens WHERE owner_type == $1 AND owner == $2 AND revoked_at == 0 AND expires_at > $3",
+		ownerType, owner, now.Unix())
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create api token for %s: %w", owner, err)
+	}
+
+	if active >= APITokensMax {
+		return nil, "", fmt.Errorf("%w: at most %d", ErrTooManyAPITokens, APITokensMax)
+	}
+
+	_, err = createTx.ExecContext(ctx, "INSERT INTO api_tokens (id, owner_type, owner, name, scopes, secret_hash, created_by, created_at, expires_at, last_used_at, revoked_at) "+
+		"VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,0,0)",
+		token.ID, token.OwnerType, token.Owner, token.Name, token.Scopes, token.SecretHash, token.CreatedBy, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create api token for %s: %w", owner, err)
+	}
+
+	if err := createTx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("could not create api token for %s: %w", owner, err)
+	}
+
+	return &token, APITokenPrefix + id + apiTokenSeparator + secret, nil
+}
+
+func validateAPIToken(ownerType string, name string, expiresAt int64, now time.Time) error {
+	if ownerType != APITokenOwnerUser && ownerType != APITokenOwnerServiceAccount {
+		return fmt.Errorf("%w: unknown owner type %s", ErrInvalidAPIToken, ownerType)
+	}
+
+	if len(name) == 0 || len(name) > APITokenNameMax || strings.ContainsAny(name, "\r\n\t") {
+		return fmt.Errorf("%w: name must be a single line of 1 to %d characters", ErrInvalidAPIToken, APITokenNameMax)
+	}
+
+	if expiresAt <= now.Unix() || expiresAt > now.Add(MaxAPITokenLifetime).Unix() {
+		return fmt.Errorf("%w: expiry must be in the next %d days", ErrInvalidAPIToken, int(MaxAPITokenLifetime.Hours()/24)) //nolint:gomnd
+	}
+
+	return nil
+}
+
+// Tokens returns the tokens of the owner, revoked and expired ones included, oldest first.
+func (r *APITokenRepository) Tokens(ctx context.Context, ownerType string, owner string) ([]APIToken, error) {
+	owner = canonicalOwner(ownerType, owner)
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	tokens := []APIToken{}
+
+	err := r.db.SelectContext(ctx, &tokens, "SELECT * FROM api_tokens WHERE owner_type == $1 AND owner == $2 ORDER BY created_at", ownerType, owner)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s api tokens: %w", owner, err)
+	}
+
+	return tokens, nil
+}
+
+// Revoke stops the token of the owner from authenticating, it remains listed with its revocation time.
+func (r *APITokenRepository) Revoke(ctx context.Context, ownerType string, owner string, id string) error {
+	owner = canonicalOwner(ownerType, owner)
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	revokeTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not revoke %s api token: %w", owner, err)
+	}
+	defer func() { _ = revokeTx.Rollback() }() //nolint:wsl
+
+	result, err := revokeTx.ExecContext(ctx, "UPDATE api_tokens SET revoked_at = $1 WHERE id == $2 AND owner_type == $3 AND owner == $4 AND revoked_at == 0",
+		time.Now().Unix(), id, ownerType, owner)
+	if err != nil {
+		return fmt.Errorf("could not revoke %s api token: %w", owner, err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	if err := revokeTx.Commit(); err != nil {
+		return fmt.Errorf("could not revoke %s api token: %w", owner, err)
+	}
+
+	return nil
+}
+
+// Authenticate returns the active token matching the token sent by a script and records its use.
+// ErrAPITokenNotFound is returned for unknown, revoked and expired tokens alike.
+func (r *APITokenRepository) Authenticate(ctx context.Context, token string, now time.Time) (*APIToken, error) {
+	id, secret, err := splitAPIToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	useTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not authenticate api token: %w", err)
+	}
+	defer func() { _ = useTx.Rollback() }() //nolint:wsl
+
+	tokens := []APIToken{}
+	if err := useTx.SelectContext(ctx, &tokens, "SELECT * FROM api_tokens WHERE id == $1", id); err != nil {
+		return nil, fmt.Errorf("could not authenticate api token: %w", err)
+	}
+
+	if len(tokens) != 1 {
+		return nil, ErrAPITokenNotFound
+	}
+
+	apiToken := &tokens[0]
+	if subtle.ConstantTimeCompare([]byte(hashRefreshSecret(secret)), []byte(apiToken.SecretHash)) != 1 || !apiToken.IsActive(now) {
+		return nil, ErrAPITokenNotFound
+	}
+
+	if now.Unix()-apiToken.LastUsedAt < int64(apiTokenUseResolution.Seconds()) {
+		return apiToken, nil
+	}
+
+	apiToken.LastUsedAt = now.Unix()
+	if _, err := useTx.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = $1 WHERE id == $2", apiToken.LastUsedAt, apiToken.ID); err != nil {
+		return nil, fmt.Errorf("could not update api token last_used_at: %w", err)
+	}
+
+	if err := useTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not update api token last_used_at: %w", err)
+	}
+
+	return apiToken, nil
+}
@@ -0,0 +1,201 @@
+package repos_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPITokenRepository_Create(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Stores only the hash of the token secret", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		tokenRepo, err := repos.NewAPITokenRepository(db)
+		assert.NoError(t, err)
+
+		apiToken, token, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, "User@Test.com", "script", []string{"users:read"}, 0, "user@test.com")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(token, repos.APITokenPrefix+apiToken.ID+"_"))
+		assert.Equal(t, "user@test.com", apiToken.Owner)
+		assert.Equal(t, []string{"users:read"}, apiToken.ScopeList())
+		assert.InDelta(t, time.Now().Add(repos.DefaultAPITokenLifetime).Unix(), apiToken.ExpiresAt, 5)
+
+		secret := strings.TrimPrefix(token, repos.APITokenPrefix+apiToken.ID+"_")
+
+		var stored string
+		assert.NoError(t, db.Get(&stored, "SELECT secret_hash FROM api_tokens"))
+		assert.NotContains(t, stored, secret)
+	})
+
+	t.Run("Refuses invalid names and expiries", func(t *testing.T) {
+		t.Parallel()
+
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+		now := time.Now()
+
+		tests := []struct {
+			name      string
+			expiresAt int64
+		}{
+			{name: "", expiresAt: 0},
+			{name: "two\nlines", expiresAt: 0},
+			{name: strings.Repeat("a", repos.APITokenNameMax+1), expiresAt: 0},
+			{name: "expired", expiresAt: now.Add(-time.Minute).Unix()},
+			{name: "too long", expiresAt: now.Add(repos.MaxAPITokenLifetime + time.Hour).Unix()},
+		}
+
+		for _, test := range tests {
+			_, _, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, "user@test.com", test.name, nil, test.expiresAt, "user@test.com")
+			assert.ErrorIs(t, err, repos.ErrInvalidAPIToken, test.name)
+		}
+	})
+
+	t.Run("Bounds the active tokens of an owner", func(t *testing.T) {
+		t.Parallel()
+
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+
+		for i := 0; i < repos.APITokensMax; i++ {
+			_, _, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, "user@test.com", "script", nil, 0, "user@test.com")
+			assert.NoError(t, err)
+		}
+
+		_, _, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, "user@test.com", "script", nil, 0, "user@test.com")
+		assert.ErrorIs(t, err, repos.ErrTooManyAPITokens)
+
+		_, _, err = tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, "other@test.com", "script", nil, 0, "other@test.com")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Requires the service account to exist", func(t *testing.T) {
+		t.Parallel()
+
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+
+		_, _, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerServiceAccount, "provisioning", "script", nil, 0, "admin@test.com")
+		assert.ErrorIs(t, err, repos.ErrServiceAccountNotFound)
+
+		_, err = tokenRepo.CreateServiceAccount(context.Background(), "provisioning", "Creates users", "admin@test.com")
+		assert.NoError(t, err)
+
+		_, _, err = tokenRepo.Create(context.Background(), repos.APITokenOwnerServiceAccount, "provisioning", "script", nil, 0, "admin@test.com")
+		assert.NoError(t, err)
+	})
+}
+
+func TestAPITokenRepository_Authenticate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns the token and records its use", func(t *testing.T) {
+		t.Parallel()
+
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+		created, token, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, "user@test.com", "script", []string{"users:read", "users:create"}, 0, "user@test.com")
+		assert.NoError(t, err)
+
+		now := time.Now()
+		apiToken, err := tokenRepo.Authenticate(context.Background(), token, now)
+		assert.NoError(t, err)
+		assert.Equal(t, created.ID, apiToken.ID)
+		assert.Equal(t, now.Unix(), apiToken.LastUsedAt)
+
+		tokens, err := tokenRepo.Tokens(context.Background(), repos.APITokenOwnerUser, "user@test.com")
+		assert.NoError(t, err)
+		assert.Len(t, tokens, 1)
+		assert.Equal(t, now.Unix(), tokens[0].LastUsedAt)
+	})
+
+	t.Run("Refuses unknown, altered, expired and revoked tokens", func(t *testing.T) {
+		t.Parallel()
+
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+		apiToken, token, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, "user@test.com", "script", nil, 0, "user@test.com")
+		assert.NoError(t, err)
+
+		for _, invalid := range []string{"", "fringe_", "fringe_unknown_secret", token + "a", strings.TrimPrefix(token, repos.APITokenPrefix)} {
+			_, err := tokenRepo.Authenticate(context.Background(), invalid, time.Now())
+			assert.ErrorIs(t, err, repos.ErrAPITokenNotFound, invalid)
+		}
+
+		_, err = tokenRepo.Authenticate(context.Background(), token, time.Unix(apiToken.ExpiresAt, 0))
+		assert.ErrorIs(t, err, repos.ErrAPITokenNotFound)
+
+		assert.NoError(t, tokenRepo.Revoke(context.Background(), repos.APITokenOwnerUser, "user@test.com", apiToken.ID))
+		assert.ErrorIs(t, tokenRepo.Revoke(context.Background(), repos.APITokenOwnerUser, "user@test.com", apiToken.ID), repos.ErrAPITokenNotFound)
+
+		_, err = tokenRepo.Authenticate(context.Background(), token, time.Now())
+		assert.ErrorIs(t, err, repos.ErrAPITokenNotFound)
+	})
+
+	t.Run("Only the owner revokes a token", func(t *testing.T) {
+		t.Parallel()
+
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+		apiToken, token, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerUser, "user@test.com", "script", nil, 0, "user@test.com")
+		assert.NoError(t, err)
+
+		err = tokenRepo.Revoke(context.Background(), repos.APITokenOwnerUser, "other@test.com", apiToken.ID)
+		assert.ErrorIs(t, err, repos.ErrAPITokenNotFound)
+
+		_, err = tokenRepo.Authenticate(context.Background(), token, time.Now())
+		assert.NoError(t, err)
+	})
+}
+
+func TestAPITokenRepository_ServiceAccounts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses invalid and duplicate names", func(t *testing.T) {
+		t.Parallel()
+
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+
+		for _, name := range []string{"", "Provisioning", "-provisioning", "with space", "admin@test.com", strings.Repeat("a", 64)} {
+			_, err := tokenRepo.CreateServiceAccount(context.Background(), name, "", "admin@test.com")
+			assert.ErrorIs(t, err, repos.ErrInvalidServiceAccount, name)
+		}
+
+		_, err := tokenRepo.CreateServiceAccount(context.Background(), "provisioning", "", "admin@test.com")
+		assert.NoError(t, err)
+
+		_, err = tokenRepo.CreateServiceAccount(context.Background(), "provisioning", "", "admin@test.com")
+		assert.ErrorIs(t, err, repos.ErrServiceAccountExists)
+	})
+
+	t.Run("Deleting a service account revokes its tokens", func(t *testing.T) {
+		t.Parallel()
+
+		tokenRepo := mocks.NewMockAPITokenRepository(t)
+		_, err := tokenRepo.CreateServiceAccount(context.Background(), "provisioning", "Creates users", "admin@test.com")
+		assert.NoError(t, err)
+		_, err = tokenRepo.CreateServiceAccount(context.Background(), "backup", "", "admin@test.com")
+		assert.NoError(t, err)
+
+		_, token, err := tokenRepo.Create(context.Background(), repos.APITokenOwnerServiceAccount, "provisioning", "script", nil, 0, "admin@test.com")
+		assert.NoError(t, err)
+
+		assert.NoError(t, tokenRepo.DeleteServiceAccount(context.Background(), "provisioning"))
+		assert.ErrorIs(t, tokenRepo.DeleteServiceAccount(context.Background(), "provisioning"), repos.ErrServiceAccountNotFound)
+
+		_, err = tokenRepo.Authenticate(context.Background(), token, time.Now())
+		assert.ErrorIs(t, err, repos.ErrAPITokenNotFound)
+
+		accounts, err := tokenRepo.ServiceAccounts(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, accounts, 1)
+		assert.Equal(t, "backup", accounts[0].Name)
+
+		tokens, err := tokenRepo.Tokens(context.Background(), repos.APITokenOwnerServiceAccount, "provisioning")
+		assert.NoError(t, err)
+		assert.Len(t, tokens, 1)
+		assert.NotZero(t, tokens[0].RevokedAt)
+	})
+}
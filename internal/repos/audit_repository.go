@@ -0,0 +1,351 @@
+package repos
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditRepository stores admin actions in an append-only table.
+// Each entry includes the hash of the previous one so any modification or deletion breaks the chain.
+// Hashes are keyed once SetKey is called, without the key a modified log cannot be chained again.
+type AuditRepository struct {
+	db         *sqlx.DB
+	appendLock sync.Mutex
+	timeouts   Timeouts
+	key        string
+}
+
+type AuditEntry struct {
+	Sequence int64  `db:"sequence"`
+	At       int64  `db:"at"`
+	Actor    string `db:"actor"`
+	Action   string `db:"action"`
+	Target   string `db:"target"`
+	SourceIP string `db:"source_ip"`
+	Result   string `db:"result"`
+	PrevHash string `db:"prev_hash"`
+	Hash     string `db:"hash"`
+}
+
+// AuditFilter restricts the entries returned by Find, empty fields are ignored.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  int64
+	Until  int64
+	Limit  int
+}
+
+var ErrAuditChainBroken = errors.New("audit log hash chain is broken")
+
+const (
+	AuditActionUserCreate = "user.create"
+	AuditActionUserDelete = "user.delete"
+	AuditActionUserRenew  = "user.renew"
+	AuditActionUserExport = "user.export"
+	AuditActionUserImport = "user.import"
+	AuditActionUserExpiry = "user.expiry"
+
+	AuditActionUserAttributes = "user.attributes"
+	AuditActionUserProfile    = "user.profile"
+
+	AuditActionCredentialCreate = "credential.create"
+	AuditActionCredentialRevoke = "credential.revoke"
+
+	AuditActionSessionRevoke = "session.revoke"
+
+	AuditActionAPITokenCreate       = "api_token.create"
+	AuditActionAPITokenRevoke       = "api_token.revoke"
+	AuditActionServiceAccountCreate = "service_account.create"
+	AuditActionServiceAccountDelete = "service_account.delete"
+
+	AuditActionTOTPEnable  = "totp.enable"
+	AuditActionTOTPDisable = "totp.disable"
+
+	AuditActionUserInactiveWarn = "user.inactive_warn"
+	AuditActionUserDisable      = "user.disable"
+	AuditActionUserEnable       = "user.enable"
+
+	AuditActionDatabaseSnapshot = "database.snapshot"
+
+	AuditActionIPReserve   = "ip.reserve"
+	AuditActionIPUnreserve = "ip.unreserve"
+
+	AuditRepositoryListMaxLimit = 1000
+)
+
+// NewAuditRepository returns a ready to use AuditRepository.
+func NewAuditRepository(db *sqlx.DB) (*AuditRepository, error) {
+	if err := createAuditTable(db); err != nil {
+		return nil, err
+	}
+
+	return &AuditRepository{db: db, timeouts: Timeouts{Read: DefaultReadTimeout, Write: DefaultWriteTimeout}}, nil
+}
+
+// SetTimeouts changes how long each read and write operation may take before failing with ErrTimeout.
+func (r *AuditRepository) SetTimeouts(timeouts Timeouts) {
+	r.timeouts = timeouts
+}
+
+func createAuditTable(db *sqlx.DB) error {
+	createTx := db.MustBegin()
+	defer func() { _ = createTx.Rollback() }()
+
+	createTx.MustExec("CREATE TABLE IF NOT EXISTS audit_log (" +
+		"sequence int64 NOT NULL, " +
+		"at int64 NOT NULL, " +
+		"actor string NOT NULL, " +
+		"action string NOT NULL, " +
+		"target string, " +
+		"source_ip string, " +
+		"result string, " +
+		"prev_hash string NOT NULL, " +
+		"hash string NOT NULL)")
+	createTx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_log_sequence ON audit_log (sequence)")
+
+	if err := createTx.Commit(); err != nil {
+		return fmt.Errorf("cannot create audit_log table: %w", err)
+	}
+
+	return nil
+}
+
+// SetKey hashes entries with an HMAC keyed with key, the log must already be keyed with it (see Rekey).
+func (r *AuditRepository) SetKey(key string) {
+	r.key = key
+}
+
+// Rekey hashes the log again with key, for logs written before hashes were keyed, then keys new entries with it.
+// Entries are rehashed up to the first one that does not verify, Verify still reports where the chain breaks.
+// It returns the number of entries rehashed.
+func (r *AuditRepository) Rekey(ctx context.Context, key string) (int64, error) {
+	r.appendLock.Lock()
+	defer r.appendLock.Unlock()
+
+	rekeyTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not rekey audit log: %w", err)
+	}
+	defer func() { _ = rekeyTx.Rollback() }() //nolint:wsl
+
+	entries := []AuditEntry{}
+	if err := rekeyTx.SelectContext(ctx, &entries, "SELECT * FROM audit_log ORDER BY sequence"); err != nil {
+		return 0, fmt.Errorf("could not rekey audit log: %w", err)
+	}
+
+	previous := AuditEntry{}
+	rekeyedHash := ""
+
+	var rekeyed int64
+
+	for _, entry := range entries {
+		if entry.Sequence != previous.Sequence+1 || entry.PrevHash != previous.Hash || entry.Hash != entry.ComputeHash(r.key) {
+			break
+		}
+
+		previous = entry
+		entry.PrevHash = rekeyedHash
+		entry.Hash = entry.ComputeHash(key)
+
+		_, err := rekeyTx.ExecContext(ctx, "UPDATE audit_log SET prev_hash = $1, hash = $2 WHERE sequence == $3", entry.PrevHash, entry.Hash, entry.Sequence)
+		if err != nil {
+			return 0, fmt.Errorf("could not rekey audit log: %w", err)
+		}
+
+		rekeyedHash = entry.Hash
+		rekeyed++
+	}
+
+	if err := rekeyTx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not rekey audit log: %w", err)
+	}
+
+	r.key = key
+
+	return rekeyed, nil
+}
+
+// ComputeHash returns the hex encoded HMAC-SHA256, keyed with key, of the entry content chained with the previous
+// entry hash. Logs written before hashes were keyed use a plain sha256, computed with an empty key.
+func (e *AuditEntry) ComputeHash(key string) string {
+	fields := []string{
+		strconv.FormatInt(e.Sequence, 10),
+		strconv.FormatInt(e.At, 10),
+		e.Actor,
+		e.Action,
+		e.Target,
+		e.SourceIP,
+		e.Result,
+		e.PrevHash,
+	}
+
+	// Length prefix each field so values containing the separator cannot be shifted between fields
+	var builder strings.Builder
+	for _, field := range fields {
+		builder.WriteString(strconv.Itoa(len(field)))
+		builder.WriteString(":")
+		builder.WriteString(field)
+		builder.WriteString("|")
+	}
+
+	if len(key) == 0 {
+		sum := sha256.Sum256([]byte(builder.String()))
+
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(builder.String()))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Append records an action at the end of the log.
+func (r *AuditRepository) Append(ctx context.Context, actor string, action string, target string, sourceIP string, result string) (*AuditEntry, error) {
+	r.appendLock.Lock()
+	defer r.appendLock.Unlock()
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	appendTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not append %s to audit log: %w", action, err)
+	}
+	defer func() { _ = appendTx.Rollback() }() //nolint:wsl
+
+	entry := AuditEntry{
+		Sequence: 1,
+		At:       time.Now().Unix(),
+		Actor:    actor,
+		Action:   action,
+		Target:   target,
+		SourceIP: sourceIP,
+		Result:   result,
+	}
+
+	var last AuditEntry
+
+	err = appendTx.GetContext(ctx, &last, "SELECT * FROM audit_log ORDER BY sequence DESC LIMIT 1")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("could not append %s to audit log: %w", action, err)
+	}
+
+	if err == nil {
+		entry.Sequence = last.Sequence + 1
+		entry.PrevHash = last.Hash
+	}
+
+	entry.Hash = entry.ComputeHash(r.key)
+
+	_, err = appendTx.ExecContext(ctx, "INSERT INTO audit_log (sequence, at, actor, action, target, source_ip, result, prev_hash, hash) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)",
+		entry.Sequence, entry.At, entry.Actor, entry.Action, entry.Target, entry.SourceIP, entry.Result, entry.PrevHash, entry.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("could not append %s to audit log: %w", action, err)
+	}
+
+	if err := appendTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not append %s to audit log: %w", action, err)
+	}
+
+	return &entry, nil
+}
+
+// Find returns the entries matching the filter, most recent first.
+// Passing 0 as the limit will use AuditRepositoryListMaxLimit as the limit.
+func (r *AuditRepository) Find(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > AuditRepositoryListMaxLimit {
+		limit = AuditRepositoryListMaxLimit
+	}
+
+	conditions := []string{"true"}
+	args := []interface{}{}
+
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if len(filter.Actor) > 0 {
+		addCondition("actor == $%d", filter.Actor)
+	}
+
+	if len(filter.Action) > 0 {
+		addCondition("action == $%d", filter.Action)
+	}
+
+	if len(filter.Target) > 0 {
+		addCondition("target == $%d", filter.Target)
+	}
+
+	if filter.Since > 0 {
+		addCondition("at >= $%d", filter.Since)
+	}
+
+	if filter.Until > 0 {
+		addCondition("at <= $%d", filter.Until)
+	}
+
+	args = append(args, limit)
+	query := fmt.Sprintf("SELECT * FROM audit_log WHERE %s ORDER BY sequence DESC LIMIT $%d", strings.Join(conditions, " AND "), len(args))
+
+	entries := []AuditEntry{}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	if err := r.db.SelectContext(ctx, &entries, query, args...); err != nil {
+		return nil, fmt.Errorf("could not retrieve audit log entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Verify walks the whole log and returns the number of entries checked.
+// When an entry was modified, removed or inserted ErrAuditChainBroken is returned with the sequence where the chain breaks.
+func (r *AuditRepository) Verify(ctx context.Context) (checked int64, brokenAt int64, err error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	rows, err := r.db.QueryxContext(ctx, "SELECT * FROM audit_log ORDER BY sequence")
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	previous := AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.StructScan(&entry); err != nil {
+			return checked, 0, fmt.Errorf("could not read audit log: %w", err)
+		}
+
+		if entry.Sequence != previous.Sequence+1 || entry.PrevHash != previous.Hash || entry.Hash != entry.ComputeHash(r.key) {
+			return checked, entry.Sequence, ErrAuditChainBroken
+		}
+
+		previous = entry
+		checked++
+	}
+
+	if err := rows.Err(); err != nil {
+		return checked, 0, fmt.Errorf("could not read audit log: %w", err)
+	}
+
+	return checked, 0, nil
+}
@@ -0,0 +1,226 @@
+package repos_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditRepository_Append(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Chains entries with the previous hash", func(t *testing.T) {
+		t.Parallel()
+
+		auditRepo, err := repos.NewAuditRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		first, err := auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "user@test.com", "127.0.0.1", "success")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), first.Sequence)
+		assert.Empty(t, first.PrevHash)
+		assert.Equal(t, first.ComputeHash(""), first.Hash)
+
+		second, err := auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserDelete, "user@test.com", "127.0.0.1", "success")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), second.Sequence)
+		assert.Equal(t, first.Hash, second.PrevHash)
+	})
+}
+
+func TestAuditRepository_Find(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Filters on actor, action and target", func(t *testing.T) {
+		t.Parallel()
+
+		auditRepo, err := repos.NewAuditRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		_, _ = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "one@test.com", "127.0.0.1", "success")
+		_, _ = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserDelete, "one@test.com", "127.0.0.1", "success")
+		_, _ = auditRepo.Append(context.Background(), "helpdesk@test.com", repos.AuditActionUserRenew, "two@test.com", "127.0.0.1", "failed")
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Actor: "admin@test.com"})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+		// Most recent first
+		assert.Equal(t, repos.AuditActionUserDelete, entries[0].Action)
+
+		entries, err = auditRepo.Find(context.Background(), repos.AuditFilter{Actor: "admin@test.com", Action: repos.AuditActionUserCreate})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+
+		entries, err = auditRepo.Find(context.Background(), repos.AuditFilter{Target: "two@test.com"})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, "failed", entries[0].Result)
+
+		entries, err = auditRepo.Find(context.Background(), repos.AuditFilter{Limit: 1})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Returns an empty list when nothing matches", func(t *testing.T) {
+		t.Parallel()
+
+		auditRepo, err := repos.NewAuditRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		entries, err := auditRepo.Find(context.Background(), repos.AuditFilter{Actor: "nobody@test.com"})
+		assert.NoError(t, err)
+		assert.NotNil(t, entries)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestAuditRepository_Verify(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Accepts an untouched log", func(t *testing.T) {
+		t.Parallel()
+
+		auditRepo, err := repos.NewAuditRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			_, err = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "user@test.com", "127.0.0.1", "success")
+			assert.NoError(t, err)
+		}
+
+		checked, brokenAt, err := auditRepo.Verify(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), checked)
+		assert.Zero(t, brokenAt)
+	})
+
+	t.Run("Detects modified entries", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		auditRepo, err := repos.NewAuditRepository(db)
+		assert.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, err = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "user@test.com", "127.0.0.1", "success")
+			assert.NoError(t, err)
+		}
+
+		tamperTx := db.MustBegin()
+		tamperTx.MustExec("UPDATE audit_log SET actor = $1 WHERE sequence == $2", "someone@test.com", 2)
+		assert.NoError(t, tamperTx.Commit())
+
+		_, brokenAt, err := auditRepo.Verify(context.Background())
+		assert.ErrorIs(t, err, repos.ErrAuditChainBroken)
+		assert.Equal(t, int64(2), brokenAt)
+	})
+
+	t.Run("Detects removed entries", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		auditRepo, err := repos.NewAuditRepository(db)
+		assert.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, err = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "user@test.com", "127.0.0.1", "success")
+			assert.NoError(t, err)
+		}
+
+		tamperTx := db.MustBegin()
+		tamperTx.MustExec("DELETE FROM audit_log WHERE sequence == $1", 2)
+		assert.NoError(t, tamperTx.Commit())
+
+		_, brokenAt, err := auditRepo.Verify(context.Background())
+		assert.ErrorIs(t, err, repos.ErrAuditChainBroken)
+		assert.Equal(t, int64(3), brokenAt)
+	})
+}
+
+func TestAuditRepository_SetKey(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Keyed logs do not verify with another key", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		auditRepo, err := repos.NewAuditRepository(db)
+		assert.NoError(t, err)
+		auditRepo.SetKey("audit-secret")
+
+		entry, err := auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "user@test.com", "127.0.0.1", "success")
+		assert.NoError(t, err)
+		assert.Equal(t, entry.ComputeHash("audit-secret"), entry.Hash)
+		assert.NotEqual(t, entry.ComputeHash(""), entry.Hash)
+
+		checked, _, err := auditRepo.Verify(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), checked)
+
+		otherRepo, err := repos.NewAuditRepository(db)
+		assert.NoError(t, err)
+		otherRepo.SetKey("other-secret")
+
+		_, brokenAt, err := otherRepo.Verify(context.Background())
+		assert.ErrorIs(t, err, repos.ErrAuditChainBroken)
+		assert.Equal(t, int64(1), brokenAt)
+	})
+}
+
+func TestAuditRepository_Rekey(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Keys entries written without a key", func(t *testing.T) {
+		t.Parallel()
+
+		auditRepo, err := repos.NewAuditRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, _ = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "user@test.com", "127.0.0.1", "success")
+		}
+
+		rekeyed, err := auditRepo.Rekey(context.Background(), "audit-secret")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), rekeyed)
+
+		_, err = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserDelete, "user@test.com", "127.0.0.1", "success")
+		assert.NoError(t, err)
+
+		checked, _, err := auditRepo.Verify(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), checked)
+
+		entries, _ := auditRepo.Find(context.Background(), repos.AuditFilter{})
+		for _, entry := range entries {
+			assert.Equal(t, entry.ComputeHash("audit-secret"), entry.Hash)
+		}
+	})
+
+	t.Run("Keeps reporting modified entries", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		auditRepo, err := repos.NewAuditRepository(db)
+		assert.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			_, _ = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "user@test.com", "127.0.0.1", "success")
+		}
+
+		tamperTx := db.MustBegin()
+		tamperTx.MustExec("UPDATE audit_log SET actor = $1 WHERE sequence == $2", "someone@test.com", 2)
+		assert.NoError(t, tamperTx.Commit())
+
+		rekeyed, err := auditRepo.Rekey(context.Background(), "audit-secret")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), rekeyed)
+
+		_, brokenAt, err := auditRepo.Verify(context.Background())
+		assert.ErrorIs(t, err, repos.ErrAuditChainBroken)
+		assert.Equal(t, int64(2), brokenAt)
+	})
+}
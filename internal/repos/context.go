@@ -0,0 +1,57 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Timeouts bounds how long a single repository operation may take.
+// Reads are queries, writes are anything holding a transaction. Zero disables the limit.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+const (
+	DefaultReadTimeout  = 5 * time.Second
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "database operation timed out" }
+
+// Is lets errors.Is(ErrTimeout, context.DeadlineExceeded) hold like for any other deadline.
+func (timeoutError) Is(target error) bool { return target == context.DeadlineExceeded } //nolint:errorlint,goerr113
+
+func (timeoutError) Timeout() bool { return true }
+
+// ErrTimeout is returned, wrapped, when a repository operation exceeds its timeout.
+var ErrTimeout error = timeoutError{} //nolint:gochecknoglobals
+
+// operationContext reports deadlines as ErrTimeout so callers can tell them apart from other failures.
+type operationContext struct {
+	context.Context //nolint:containedctx
+}
+
+func (c operationContext) Err() error {
+	err := c.Context.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+
+	return err //nolint:wrapcheck
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+
+		return operationContext{ctx}, cancel
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return operationContext{ctx}, cancel
+}
@@ -0,0 +1,88 @@
+package repos_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRepository_Timeouts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ReadTimeout", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		userRepo.SetTimeouts(repos.Timeouts{Read: time.Nanosecond})
+
+		_, err := userRepo.AllUsers(context.Background(), 0, 0)
+		assert.ErrorIs(t, err, repos.ErrTimeout)
+
+		_, err = userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.ErrorIs(t, err, repos.ErrTimeout)
+
+		_, err = userRepo.ListUsers(context.Background(), repos.UserListQuery{})
+		assert.ErrorIs(t, err, repos.ErrTimeout)
+	})
+
+	t.Run("WriteTimeout", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		userRepo.SetTimeouts(repos.Timeouts{Write: time.Nanosecond})
+
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.ErrorIs(t, err, repos.ErrTimeout)
+		assert.False(t, userRepo.Exists(context.Background(), "user@test.com"))
+	})
+
+	t.Run("TimeoutIsDeadlineExceeded", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		userRepo.SetTimeouts(repos.Timeouts{Read: time.Nanosecond})
+
+		_, err := userRepo.AllUsers(context.Background(), 0, 0)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("CanceledIsNotTimeout", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := userRepo.Create(ctx, "user@test.com", "", "", "a-password")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, repos.ErrTimeout)
+	})
+
+	t.Run("NoTimeoutWhenZero", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		userRepo.SetTimeouts(repos.Timeouts{})
+
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+	})
+}
+
+func TestAuditRepository_Timeouts(t *testing.T) {
+	t.Parallel()
+
+	auditRepo := mocks.NewMockAuditRepository(t)
+	auditRepo.SetTimeouts(repos.Timeouts{Read: time.Nanosecond, Write: time.Nanosecond})
+
+	_, err := auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "user@test.com", "127.0.0.1", "ok")
+	assert.ErrorIs(t, err, repos.ErrTimeout)
+
+	_, err = auditRepo.Find(context.Background(), repos.AuditFilter{})
+	assert.ErrorIs(t, err, repos.ErrTimeout)
+}
@@ -0,0 +1,282 @@
+package repos
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// FieldCipher encrypts user columns using envelope encryption.
+// Data keys are random, stored in the encryption_keys table wrapped by the master key which never touches the database.
+// Each data key holds an AES-256-GCM key for values and an HMAC-SHA256 key for the email blind index.
+type FieldCipher struct {
+	db        *sqlx.DB
+	masterKey cipher.AEAD
+	lock      sync.RWMutex
+	current   *dataKey
+	// rotation is held for reading from sealing values until they are stored, and for writing while keys rotate
+	rotation sync.RWMutex
+	keys     map[int64]*dataKey
+}
+
+type dataKey struct {
+	id         int64
+	encryption cipher.AEAD
+	index      []byte
+}
+
+type storedDataKey struct {
+	ID        int64  `db:"id"`
+	Wrapped   string `db:"wrapped"`
+	CreatedAt int64  `db:"created_at"`
+}
+
+// sealedPrefix marks encrypted values, it is followed by the data key id and the base64 nonce and ciphertext.
+const sealedPrefix = "enc:v1:"
+
+const (
+	// MasterSecretMinLen is the minimum length of the secret the master key is derived from.
+	MasterSecretMinLen = 32
+
+	dataKeyLen = 32
+)
+
+var (
+	ErrInvalidMasterKey = errors.New("invalid encryption master key")
+	ErrUnknownDataKey   = errors.New("unknown encryption data key")
+	ErrInvalidSealed    = errors.New("invalid encrypted value")
+)
+
+// NewFieldCipher returns a FieldCipher using the master key derived from masterSecret.
+// The first data key is created if the database has none.
+func NewFieldCipher(db *sqlx.DB, masterSecret string) (*FieldCipher, error) {
+	if len(masterSecret) < MasterSecretMinLen {
+		return nil, fmt.Errorf("%w: secret must be at least %d characters", ErrInvalidMasterKey, MasterSecretMinLen)
+	}
+
+	masterKey := sha256.Sum256([]byte(masterSecret))
+
+	masterAEAD, err := newAEAD(masterKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	fieldCipher := &FieldCipher{db: db, masterKey: masterAEAD, keys: map[int64]*dataKey{}}
+
+	if err := fieldCipher.loadKeys(); err != nil {
+		return nil, err
+	}
+
+	return fieldCipher, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+
+	return aead, nil
+}
+
+func (c *FieldCipher) loadKeys() error {
+	loadTx := c.db.MustBegin()
+	defer func() { _ = loadTx.Rollback() }()
+
+	loadTx.MustExec("CREATE TABLE IF NOT EXISTS encryption_keys (id int64 NOT NULL, wrapped string NOT NULL, created_at int64 NOT NULL)")
+	loadTx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_encryption_keys_id ON encryption_keys (id)")
+
+	var stored []storedDataKey
+	if err := loadTx.Select(&stored, "SELECT * FROM encryption_keys ORDER BY id"); err != nil {
+		return fmt.Errorf("could not read encryption keys: %w", err)
+	}
+
+	if len(stored) == 0 {
+		key, err := c.newDataKey(loadTx)
+		if err != nil {
+			return err
+		}
+
+		c.current = key
+		c.keys[key.id] = key
+
+		if err := loadTx.Commit(); err != nil {
+			return fmt.Errorf("could not store encryption key: %w", err)
+		}
+
+		return nil
+	}
+
+	for _, row := range stored {
+		key, err := c.unwrap(row)
+		if err != nil {
+			return err
+		}
+
+		c.keys[key.id] = key
+		c.current = key
+	}
+
+	return nil
+}
+
+// newDataKey creates a data key and stores it, wrapped, in the transaction.
+func (c *FieldCipher) newDataKey(tx *sqlx.Tx) (*dataKey, error) {
+	var lastID int64
+	if err := tx.Get(&lastID, "SELECT count(*) FROM encryption_keys"); err != nil {
+		return nil, fmt.Errorf("could not read encryption keys: %w", err)
+	}
+
+	if lastID > 0 {
+		if err := tx.Get(&lastID, "SELECT max(id) FROM encryption_keys"); err != nil {
+			return nil, fmt.Errorf("could not read encryption keys: %w", err)
+		}
+	}
+
+	material := make([]byte, 2*dataKeyLen)
+	if _, err := rand.Read(material); err != nil {
+		return nil, fmt.Errorf("could not generate encryption key: %w", err)
+	}
+
+	row := storedDataKey{ID: lastID + 1, CreatedAt: time.Now().Unix()}
+
+	nonce := make([]byte, c.masterKey.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate encryption key: %w", err)
+	}
+
+	row.Wrapped = base64.StdEncoding.EncodeToString(c.masterKey.Seal(nonce, nonce, material, dataKeyAdditionalData(row.ID)))
+
+	if _, err := tx.Exec("INSERT INTO encryption_keys (id, wrapped, created_at) VALUES ($1,$2,$3)", row.ID, row.Wrapped, row.CreatedAt); err != nil {
+		return nil, fmt.Errorf("could not store encryption key: %w", err)
+	}
+
+	return newDataKeyFromMaterial(row.ID, material)
+}
+
+func (c *FieldCipher) unwrap(row storedDataKey) (*dataKey, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(row.Wrapped)
+	if err != nil || len(wrapped) < c.masterKey.NonceSize() {
+		return nil, fmt.Errorf("%w: data key %d is malformed", ErrInvalidMasterKey, row.ID)
+	}
+
+	nonceSize := c.masterKey.NonceSize()
+
+	material, err := c.masterKey.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], dataKeyAdditionalData(row.ID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: data key %d cannot be unwrapped", ErrInvalidMasterKey, row.ID)
+	}
+
+	return newDataKeyFromMaterial(row.ID, material)
+}
+
+func dataKeyAdditionalData(id int64) []byte {
+	return []byte("fringe data key " + strconv.FormatInt(id, 10))
+}
+
+func newDataKeyFromMaterial(id int64, material []byte) (*dataKey, error) {
+	encryption, err := newAEAD(material[:dataKeyLen])
+	if err != nil {
+		return nil, err
+	}
+
+	return &dataKey{id: id, encryption: encryption, index: material[dataKeyLen:]}, nil
+}
+
+// active returns the data key used for new values.
+func (c *FieldCipher) active() *dataKey {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.current
+}
+
+// activate makes key the only data key, older keys must have been deleted from the database.
+func (c *FieldCipher) activate(key *dataKey) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.current = key
+	c.keys = map[int64]*dataKey{key.id: key}
+}
+
+// seal encrypts the value of a column, the row identity and the column name are authenticated so values cannot be
+// swapped between rows or columns. The row identity of users is the email blind index they are stored under.
+func (c *FieldCipher) seal(key *dataKey, row string, column string, value string) (string, error) {
+	nonce := make([]byte, key.encryption.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not encrypt %s: %w", column, err)
+	}
+
+	sealed := key.encryption.Seal(nonce, nonce, []byte(value), fieldAdditionalData(row, column))
+
+	return sealedPrefix + strconv.FormatInt(key.id, 10) + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a value sealed for the row and column, values that were never encrypted are returned as is.
+func (c *FieldCipher) open(row string, column string, value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, sealedPrefix), ":", 2) //nolint:gomnd
+	if len(parts) != 2 {                                                     //nolint:gomnd
+		return "", fmt.Errorf("%w in %s", ErrInvalidSealed, column)
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w in %s", ErrInvalidSealed, column)
+	}
+
+	c.lock.RLock()
+	key, found := c.keys[id]
+	c.lock.RUnlock()
+
+	if !found {
+		return "", fmt.Errorf("%w: %d", ErrUnknownDataKey, id)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil || len(sealed) < key.encryption.NonceSize() {
+		return "", fmt.Errorf("%w in %s", ErrInvalidSealed, column)
+	}
+
+	nonceSize := key.encryption.NonceSize()
+
+	opened, err := key.encryption.Open(nil, sealed[:nonceSize], sealed[nonceSize:], fieldAdditionalData(row, column))
+	if err != nil {
+		return "", fmt.Errorf("%w in %s", ErrInvalidSealed, column)
+	}
+
+	return string(opened), nil
+}
+
+func fieldAdditionalData(row string, column string) []byte {
+	return []byte(column + ":" + row)
+}
+
+// blindIndex returns the value stored in place of the email, equal emails always get the same index.
+func (c *FieldCipher) blindIndex(key *dataKey, email string) string {
+	mac := hmac.New(sha256.New, key.index)
+	mac.Write([]byte(CanonicalEmail(email)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,163 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// When the user database is encrypted, leases and reservations store the email blind index of their user in the
+// email column, as the users table does, and the email itself encrypted in sealed_email.
+
+// ipEmailColumn names the sealed emails of leases and reservations in their additional data.
+const ipEmailColumn = "ip_email"
+
+// ipAddressTables hold the email of the user an address is leased or reserved to.
+var ipAddressTables = []string{"ip_leases", "ip_reservations"} //nolint:gochecknoglobals
+
+// SetCipher encrypts the emails of leases and reservations with cipher from now on, emails stored in clear text are
+// encrypted right away. The cipher must be the one of the UserRepository, data key rotations reseal both.
+func (r *IPPoolRepository) SetCipher(ctx context.Context, cipher *FieldCipher) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	cipher.rotation.RLock()
+	defer cipher.rotation.RUnlock()
+
+	encryptTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not encrypt ip leases: %w", err)
+	}
+	defer func() { _ = encryptTx.Rollback() }() //nolint:wsl
+
+	for _, table := range ipAddressTables {
+		var emails []string
+
+		query := fmt.Sprintf("SELECT DISTINCT email FROM %s WHERE sealed_email == \"\"", table)
+		if err := encryptTx.SelectContext(ctx, &emails, query); err != nil {
+			return fmt.Errorf("could not encrypt %s: %w", table, err)
+		}
+
+		for _, email := range emails {
+			if err := resealIPAddresses(ctx, encryptTx, cipher, cipher.active(), email, email); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := encryptTx.Commit(); err != nil {
+		return fmt.Errorf("could not encrypt ip leases: %w", err)
+	}
+
+	r.cipher = cipher
+
+	return nil
+}
+
+// resealIPAddresses stores the leases and reservations held under storedEmail with email encrypted by key.
+func resealIPAddresses(ctx context.Context, tx *sqlx.Tx, cipher *FieldCipher, key *dataKey, storedEmail string, email string) error {
+	blindIndex := cipher.blindIndex(key, email)
+
+	sealedEmail, err := cipher.seal(key, blindIndex, ipEmailColumn, CanonicalEmail(email))
+	if err != nil {
+		return err
+	}
+
+	for _, table := range ipAddressTables {
+		query := fmt.Sprintf("UPDATE %s SET email = $1, sealed_email = $2 WHERE email == $3", table)
+		if _, err := tx.ExecContext(ctx, query, blindIndex, sealedEmail, storedEmail); err != nil {
+			return fmt.Errorf("could not encrypt %s of %s: %w", table, email, err)
+		}
+	}
+
+	return nil
+}
+
+// resealAllIPAddresses stores the leases and reservations with their email encrypted by key, during a data key
+// rotation. Nothing is done when the ip pool tables were never created.
+func resealAllIPAddresses(ctx context.Context, tx *sqlx.Tx, cipher *FieldCipher, key *dataKey) error {
+	exists, err := tableExists(tx, "ip_leases")
+	if err != nil || !exists {
+		return err
+	}
+
+	for _, table := range ipAddressTables {
+		var rows []struct {
+			Email       string `db:"email"`
+			SealedEmail string `db:"sealed_email"`
+		}
+
+		query := fmt.Sprintf("SELECT DISTINCT email, sealed_email FROM %s WHERE sealed_email != \"\"", table)
+		if err := tx.SelectContext(ctx, &rows, query); err != nil {
+			return fmt.Errorf("could not encrypt %s: %w", table, err)
+		}
+
+		for _, row := range rows {
+			email, err := cipher.open(row.Email, ipEmailColumn, row.SealedEmail)
+			if err != nil {
+				return err
+			}
+
+			if err := resealIPAddresses(ctx, tx, cipher, key, row.Email, email); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// holdDataKey keeps the active data key from being rotated until the returned function is called.
+func (r *IPPoolRepository) holdDataKey() func() {
+	if r.cipher == nil {
+		return func() {}
+	}
+
+	r.cipher.rotation.RLock()
+
+	return r.cipher.rotation.RUnlock
+}
+
+// lookupEmail returns the value of the email column for the email.
+func (r *IPPoolRepository) lookupEmail(email string) string {
+	email = CanonicalEmail(email)
+
+	if r.cipher == nil {
+		return email
+	}
+
+	return r.cipher.blindIndex(r.cipher.active(), email)
+}
+
+// sealEmail returns the values of the email and sealed_email columns for the email.
+func (r *IPPoolRepository) sealEmail(email string) (string, string, error) {
+	email = CanonicalEmail(email)
+
+	if r.cipher == nil {
+		return email, "", nil
+	}
+
+	key := r.cipher.active()
+	blindIndex := r.cipher.blindIndex(key, email)
+
+	sealedEmail, err := r.cipher.seal(key, blindIndex, ipEmailColumn, email)
+	if err != nil {
+		return "", "", err
+	}
+
+	return blindIndex, sealedEmail, nil
+}
+
+// openEmail returns the email of a lease or reservation from its email and sealed_email columns.
+func (r *IPPoolRepository) openEmail(storedEmail string, sealedEmail string) (string, error) {
+	if len(sealedEmail) == 0 {
+		return storedEmail, nil
+	}
+
+	if r.cipher == nil {
+		return "", ErrMissingCipher
+	}
+
+	return r.cipher.open(storedEmail, ipEmailColumn, sealedEmail)
+}
@@ -0,0 +1,640 @@
+package repos
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// IPPool is a named range of IPv4 addresses leased to users for LeaseDuration.
+type IPPool struct {
+	Name          string
+	Network       *net.IPNet
+	LeaseDuration time.Duration
+}
+
+// IPLease is an address handed out to a user, the address is free again once ExpiresAt is reached or it is released.
+// SessionID identifies the connection, a user reconnecting with the same session gets the same address.
+type IPLease struct {
+	Address     string `db:"address" json:"address"`
+	Pool        string `db:"pool" json:"pool"`
+	Email       string `db:"email" json:"email"`
+	SessionID   string `db:"session_id" json:"session_id"`
+	Static      bool   `db:"static" json:"static"`
+	ExpiresAt   int64  `db:"expires_at" json:"expires_at"`
+	SealedEmail string `db:"sealed_email" json:"-"`
+}
+
+// IPReservation is an address only ever leased to one user.
+type IPReservation struct {
+	Email       string `db:"email" json:"email"`
+	Pool        string `db:"pool" json:"pool"`
+	Address     string `db:"address" json:"address"`
+	SealedEmail string `db:"sealed_email" json:"-"`
+}
+
+// IPPoolRepository leases addresses from the pools, leases and reservations are stored in the database.
+type IPPoolRepository struct {
+	db            *sqlx.DB
+	pools         map[string]*IPPool
+	timeouts      Timeouts
+	cipher        *FieldCipher
+	maxUserLeases int
+}
+
+var (
+	ErrInvalidIPPool      = errors.New("invalid ip pool")
+	ErrUnknownIPPool      = errors.New("unknown ip pool")
+	ErrIPPoolExhausted    = errors.New("no address left in ip pool")
+	ErrAddressOutsidePool = errors.New("address is not in an ip pool")
+	ErrAddressInUse       = errors.New("address is reserved or leased to another user")
+	ErrLeaseNotFound      = errors.New("ip lease could not be found")
+	ErrMissingSessionID   = errors.New("a session id is required to lease an address")
+	ErrTooManyLeases      = errors.New("user holds too many ip leases")
+)
+
+const ipPoolMinHostBits = 2 // A /30 is the smallest pool with usable host addresses
+
+// NewIPPool returns a pool of the IPv4 addresses in cidr, the network and broadcast addresses are never leased.
+func NewIPPool(name string, cidr string, leaseDuration time.Duration) (*IPPool, error) {
+	if len(strings.TrimSpace(name)) == 0 {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidIPPool)
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidIPPool, name, err)
+	}
+
+	ones, bits := network.Mask.Size()
+	if network.IP.To4() == nil || bits != net.IPv4len*8 || bits-ones < ipPoolMinHostBits {
+		return nil, fmt.Errorf("%w: %s must be an IPv4 network of at least 4 addresses", ErrInvalidIPPool, name)
+	}
+
+	if leaseDuration <= 0 {
+		return nil, fmt.Errorf("%w: %s lease duration must be positive", ErrInvalidIPPool, name)
+	}
+
+	return &IPPool{Name: name, Network: network, LeaseDuration: leaseDuration}, nil
+}
+
+// Netmask returns the mask of the pool network in dotted form.
+func (p *IPPool) Netmask() net.IP {
+	return net.IP(p.Network.Mask).To4()
+}
+
+// Size returns the number of addresses that can be leased.
+func (p *IPPool) Size() int {
+	ones, bits := p.Network.Mask.Size()
+
+	return 1<<(bits-ones) - 2
+}
+
+// IsHost returns true if the address can be leased from the pool.
+func (p *IPPool) IsHost(address net.IP) bool {
+	address = address.To4()
+	if address == nil || !p.Network.Contains(address) {
+		return false
+	}
+
+	offset := binary.BigEndian.Uint32(address) - binary.BigEndian.Uint32(p.Network.IP.To4())
+
+	return offset != 0 && int(offset) <= p.Size()
+}
+
+// hosts calls visit with every address of the pool in order until it returns false.
+func (p *IPPool) hosts(visit func(address string) bool) {
+	first := binary.BigEndian.Uint32(p.Network.IP.To4())
+	address := make(net.IP, net.IPv4len)
+
+	for offset := 1; offset <= p.Size(); offset++ {
+		binary.BigEndian.PutUint32(address, first+uint32(offset))
+
+		if !visit(address.String()) {
+			return
+		}
+	}
+}
+
+// NewIPPoolRepository returns a ready to use IPPoolRepository leasing from pools, pools cannot overlap.
+func NewIPPoolRepository(db *sqlx.DB, pools []*IPPool) (*IPPoolRepository, error) {
+	byName := map[string]*IPPool{}
+
+	for _, pool := range pools {
+		if _, found := byName[pool.Name]; found {
+			return nil, fmt.Errorf("%w: %s is defined twice", ErrInvalidIPPool, pool.Name)
+		}
+
+		for _, other := range byName {
+			if other.Network.Contains(pool.Network.IP) || pool.Network.Contains(other.Network.IP) {
+				return nil, fmt.Errorf("%w: %s overlaps %s", ErrInvalidIPPool, pool.Name, other.Name)
+			}
+		}
+
+		byName[pool.Name] = pool
+	}
+
+	if err := createIPPoolTables(db); err != nil {
+		return nil, err
+	}
+
+	return &IPPoolRepository{db: db, pools: byName, timeouts: Timeouts{Read: DefaultReadTimeout, Write: DefaultWriteTimeout}}, nil
+}
+
+// SetTimeouts changes how long each read and write operation may take before failing with ErrTimeout.
+func (r *IPPoolRepository) SetTimeouts(timeouts Timeouts) {
+	r.timeouts = timeouts
+}
+
+// SetMaxUserLeases limits the addresses leased to a user at once, reserved addresses excluded. 0 means no limit.
+func (r *IPPoolRepository) SetMaxUserLeases(maxLeases int) {
+	r.maxUserLeases = maxLeases
+}
+
+func createIPPoolTables(db *sqlx.DB) error {
+	createTx := db.MustBegin()
+	defer func() { _ = createTx.Rollback() }()
+
+	createTx.MustExec("CREATE TABLE IF NOT EXISTS ip_leases (" +
+		"address string NOT NULL, " +
+		"pool string NOT NULL, " +
+		"email string NOT NULL, " +
+		"session_id string NOT NULL, " +
+		"static bool NOT NULL, " +
+		"expires_at int64 NOT NULL, " +
+		"sealed_email string)")
+	createTx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_ip_leases_address ON ip_leases (address)")
+	createTx.MustExec("CREATE TABLE IF NOT EXISTS ip_reservations (" +
+		"email string NOT NULL, " +
+		"pool string NOT NULL, " +
+		"address string NOT NULL, " +
+		"sealed_email string)")
+	createTx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_ip_reservations_email ON ip_reservations (email)")
+
+	// Tables created before emails were encrypted
+	for _, table := range []string{"ip_leases", "ip_reservations"} {
+		if err := addMissingColumns(createTx, table, []columnMigration{{name: "sealed_email", columnType: "string", defaultValue: ""}}); err != nil {
+			return err
+		}
+	}
+
+	if err := createTx.Commit(); err != nil {
+		return fmt.Errorf("cannot create ip pool tables: %w", err)
+	}
+
+	return nil
+}
+
+// Pool returns the pool named name.
+func (r *IPPoolRepository) Pool(name string) (*IPPool, error) {
+	pool, found := r.pools[name]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIPPool, name)
+	}
+
+	return pool, nil
+}
+
+// Pools returns every pool sorted by name.
+func (r *IPPoolRepository) Pools() []*IPPool {
+	pools := make([]*IPPool, 0, len(r.pools))
+	for _, pool := range r.pools {
+		pools = append(pools, pool)
+	}
+
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+
+	return pools
+}
+
+// poolOf returns the pool the address can be leased from.
+func (r *IPPoolRepository) poolOf(address net.IP) (*IPPool, error) {
+	for _, pool := range r.pools {
+		if pool.IsHost(address) {
+			return pool, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrAddressOutsidePool, address)
+}
+
+// Allocate leases an address to the user for the session, ErrMissingSessionID is returned without a session.
+// Users with a reservation always get their reserved address, whatever the pool requested.
+// Others keep the address already leased for the same session or get the first free address of the pool,
+// unless they already hold as many leases as allowed.
+func (r *IPPoolRepository) Allocate(ctx context.Context, poolName string, email string, sessionID string, now time.Time) (*IPLease, error) {
+	email = CanonicalEmail(email)
+
+	// Without a session every request would lease another address
+	if len(strings.TrimSpace(sessionID)) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMissingSessionID, email)
+	}
+
+	pool, err := r.Pool(poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	defer r.holdDataKey()()
+
+	allocateTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not allocate address to %s: %w", email, err)
+	}
+	defer func() { _ = allocateTx.Rollback() }() //nolint:wsl
+
+	// Expired leases are free again
+	if _, err := allocateTx.ExecContext(ctx, "DELETE FROM ip_leases WHERE expires_at <= $1", now.Unix()); err != nil {
+		return nil, fmt.Errorf("could not allocate address to %s: %w", email, err)
+	}
+
+	lease, err := r.allocateInTx(ctx, allocateTx, pool, email, sessionID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := allocateTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not allocate address to %s: %w", email, err)
+	}
+
+	return lease, nil
+}
+
+func (r *IPPoolRepository) allocateInTx(ctx context.Context, tx *sqlx.Tx, pool *IPPool, email string, sessionID string, now time.Time) (*IPLease, error) {
+	var reservation IPReservation
+
+	storedEmail := r.lookupEmail(email)
+
+	err := tx.GetContext(ctx, &reservation, "SELECT * FROM ip_reservations WHERE email == $1 LIMIT 1", storedEmail)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("could not allocate address to %s: %w", email, err)
+	}
+
+	if err == nil {
+		reserved, found := r.pools[reservation.Pool]
+		if !found {
+			return nil, fmt.Errorf("%w: %s reserved for %s", ErrUnknownIPPool, reservation.Pool, email)
+		}
+
+		lease := IPLease{Address: reservation.Address, Pool: reserved.Name, Email: email, SessionID: sessionID, Static: true, ExpiresAt: now.Add(reserved.LeaseDuration).Unix()}
+
+		return &lease, r.upsertLease(ctx, tx, &lease)
+	}
+
+	var existing IPLease
+
+	err = tx.GetContext(ctx, &existing, "SELECT * FROM ip_leases WHERE email == $1 AND session_id == $2 AND pool == $3 LIMIT 1", storedEmail, sessionID, pool.Name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("could not allocate address to %s: %w", email, err)
+	}
+
+	if err == nil {
+		existing.Email = email
+		existing.ExpiresAt = now.Add(pool.LeaseDuration).Unix()
+
+		return &existing, r.upsertLease(ctx, tx, &existing)
+	}
+
+	if r.maxUserLeases > 0 {
+		var held int
+		if err := tx.GetContext(ctx, &held, "SELECT count(*) FROM ip_leases WHERE email == $1 AND static == false", storedEmail); err != nil {
+			return nil, fmt.Errorf("could not allocate address to %s: %w", email, err)
+		}
+
+		if held >= r.maxUserLeases {
+			return nil, fmt.Errorf("%w: %s holds %d", ErrTooManyLeases, email, held)
+		}
+	}
+
+	used := map[string]bool{}
+
+	var addresses []string
+	if err := tx.SelectContext(ctx, &addresses, "SELECT address FROM ip_leases WHERE pool == $1", pool.Name); err != nil {
+		return nil, fmt.Errorf("could not allocate address to %s: %w", email, err)
+	}
+
+	var reserved []string
+	if err := tx.SelectContext(ctx, &reserved, "SELECT address FROM ip_reservations WHERE pool == $1", pool.Name); err != nil {
+		return nil, fmt.Errorf("could not allocate address to %s: %w", email, err)
+	}
+
+	for _, address := range append(addresses, reserved...) {
+		used[address] = true
+	}
+
+	free := ""
+
+	pool.hosts(func(address string) bool {
+		if used[address] {
+			return true
+		}
+
+		free = address
+
+		return false
+	})
+
+	if len(free) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrIPPoolExhausted, pool.Name)
+	}
+
+	lease := IPLease{Address: free, Pool: pool.Name, Email: email, SessionID: sessionID, ExpiresAt: now.Add(pool.LeaseDuration).Unix()}
+
+	return &lease, r.upsertLease(ctx, tx, &lease)
+}
+
+// upsertLease stores the lease, replacing any lease of the same address.
+func (r *IPPoolRepository) upsertLease(ctx context.Context, tx *sqlx.Tx, lease *IPLease) error {
+	storedEmail, sealedEmail, err := r.sealEmail(lease.Email)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ip_leases WHERE address == $1", lease.Address); err != nil {
+		return fmt.Errorf("could not lease %s: %w", lease.Address, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO ip_leases (address, pool, email, session_id, static, expires_at, sealed_email) VALUES ($1,$2,$3,$4,$5,$6,$7)",
+		lease.Address, lease.Pool, storedEmail, lease.SessionID, lease.Static, lease.ExpiresAt, sealedEmail)
+	if err != nil {
+		return fmt.Errorf("could not lease %s: %w", lease.Address, err)
+	}
+
+	return nil
+}
+
+// Renew extends the lease of the address held by the user, ErrLeaseNotFound is returned if the user holds no such lease.
+func (r *IPPoolRepository) Renew(ctx context.Context, address string, email string, sessionID string, now time.Time) (*IPLease, error) {
+	email = CanonicalEmail(email)
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	defer r.holdDataKey()()
+
+	renewTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not renew %s: %w", address, err)
+	}
+	defer func() { _ = renewTx.Rollback() }() //nolint:wsl
+
+	var lease IPLease
+
+	err = renewTx.GetContext(ctx, &lease, "SELECT * FROM ip_leases WHERE address == $1 AND email == $2 AND expires_at > $3 LIMIT 1", address, r.lookupEmail(email), now.Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrLeaseNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not renew %s: %w", address, err)
+	}
+
+	pool, err := r.Pool(lease.Pool)
+	if err != nil {
+		return nil, err
+	}
+
+	lease.Email = email
+	lease.SessionID = sessionID
+	lease.ExpiresAt = now.Add(pool.LeaseDuration).Unix()
+
+	if err := r.upsertLease(ctx, renewTx, &lease); err != nil {
+		return nil, err
+	}
+
+	if err := renewTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not renew %s: %w", address, err)
+	}
+
+	return &lease, nil
+}
+
+// Release frees the address leased to the user, ErrLeaseNotFound is returned if the user holds no such lease.
+func (r *IPPoolRepository) Release(ctx context.Context, address string, email string) error {
+	return r.deleteLeases(ctx, "DELETE FROM ip_leases WHERE address == $1 AND email == $2", address, r.lookupEmail(email))
+}
+
+// ExpireLeases frees every lease expired at now and returns how many were freed.
+func (r *IPPoolRepository) ExpireLeases(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	expireTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not expire leases: %w", err)
+	}
+	defer func() { _ = expireTx.Rollback() }() //nolint:wsl
+
+	result, err := expireTx.ExecContext(ctx, "DELETE FROM ip_leases WHERE expires_at <= $1", now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("could not expire leases: %w", err)
+	}
+
+	if err := expireTx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not expire leases: %w", err)
+	}
+
+	expired, _ := result.RowsAffected()
+
+	return expired, nil
+}
+
+func (r *IPPoolRepository) deleteLeases(ctx context.Context, query string, args ...interface{}) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	deleteTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not release lease: %w", err)
+	}
+	defer func() { _ = deleteTx.Rollback() }() //nolint:wsl
+
+	result, err := deleteTx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("could not release lease: %w", err)
+	}
+
+	if err := deleteTx.Commit(); err != nil {
+		return fmt.Errorf("could not release lease: %w", err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrLeaseNotFound
+	}
+
+	return nil
+}
+
+// releaseIPAddresses deletes the leases and the reservation of a deleted user, stored under storedEmail.
+// Nothing is done when the ip pool tables were never created.
+func releaseIPAddresses(ctx context.Context, tx *sqlx.Tx, storedEmail string) error {
+	exists, err := tableExists(tx, "ip_leases")
+	if err != nil || !exists {
+		return err
+	}
+
+	for _, table := range ipAddressTables {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE email == $1", table), storedEmail); err != nil {
+			return fmt.Errorf("could not delete %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+// Leases returns the leases not expired at now, sorted by address.
+func (r *IPPoolRepository) Leases(ctx context.Context, now time.Time) ([]IPLease, error) {
+	leases := []IPLease{}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	if err := r.db.SelectContext(ctx, &leases, "SELECT * FROM ip_leases WHERE expires_at > $1 ORDER BY address", now.Unix()); err != nil {
+		return nil, fmt.Errorf("could not retrieve leases: %w", err)
+	}
+
+	for index := range leases {
+		email, err := r.openEmail(leases[index].Email, leases[index].SealedEmail)
+		if err != nil {
+			return nil, err
+		}
+
+		leases[index].Email = email
+		leases[index].SealedEmail = ""
+	}
+
+	return leases, nil
+}
+
+// Reserve sets the address always leased to the user, replacing any previous reservation of the user.
+// ErrAddressInUse is returned if the address is reserved for, or currently leased to, another user.
+func (r *IPPoolRepository) Reserve(ctx context.Context, email string, address string, now time.Time) (*IPReservation, error) {
+	email = CanonicalEmail(email)
+
+	ip := net.ParseIP(strings.TrimSpace(address))
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAddressOutsidePool, address)
+	}
+
+	pool, err := r.poolOf(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation := IPReservation{Email: email, Pool: pool.Name, Address: ip.To4().String()}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	defer r.holdDataKey()()
+
+	storedEmail, sealedEmail, err := r.sealEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	reserveTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not reserve %s: %w", reservation.Address, err)
+	}
+	defer func() { _ = reserveTx.Rollback() }() //nolint:wsl
+
+	var holders int64
+
+	err = reserveTx.GetContext(ctx, &holders, "SELECT count(*) FROM ip_reservations WHERE address == $1 AND email != $2", reservation.Address, storedEmail)
+	if err != nil {
+		return nil, fmt.Errorf("could not reserve %s: %w", reservation.Address, err)
+	}
+
+	if holders == 0 {
+		err = reserveTx.GetContext(ctx, &holders, "SELECT count(*) FROM ip_leases WHERE address == $1 AND email != $2 AND expires_at > $3", reservation.Address, storedEmail, now.Unix())
+		if err != nil {
+			return nil, fmt.Errorf("could not reserve %s: %w", reservation.Address, err)
+		}
+	}
+
+	if holders > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrAddressInUse, reservation.Address)
+	}
+
+	if _, err := reserveTx.ExecContext(ctx, "DELETE FROM ip_reservations WHERE email == $1", storedEmail); err != nil {
+		return nil, fmt.Errorf("could not reserve %s: %w", reservation.Address, err)
+	}
+
+	_, err = reserveTx.ExecContext(ctx, "INSERT INTO ip_reservations (email, pool, address, sealed_email) VALUES ($1,$2,$3,$4)",
+		storedEmail, reservation.Pool, reservation.Address, sealedEmail)
+	if err != nil {
+		return nil, fmt.Errorf("could not reserve %s: %w", reservation.Address, err)
+	}
+
+	if err := reserveTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not reserve %s: %w", reservation.Address, err)
+	}
+
+	return &reservation, nil
+}
+
+// Unreserve removes the reservation of the user, ErrLeaseNotFound is returned if the user has none.
+// The address stays leased to the user until its lease ends.
+func (r *IPPoolRepository) Unreserve(ctx context.Context, email string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	email = CanonicalEmail(email)
+
+	deleteTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not remove %s reservation: %w", email, err)
+	}
+	defer func() { _ = deleteTx.Rollback() }() //nolint:wsl
+
+	result, err := deleteTx.ExecContext(ctx, "DELETE FROM ip_reservations WHERE email == $1", r.lookupEmail(email))
+	if err != nil {
+		return fmt.Errorf("could not remove %s reservation: %w", email, err)
+	}
+
+	if err := deleteTx.Commit(); err != nil {
+		return fmt.Errorf("could not remove %s reservation: %w", email, err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrLeaseNotFound
+	}
+
+	return nil
+}
+
+// Reservations returns every reservation sorted by address.
+func (r *IPPoolRepository) Reservations(ctx context.Context) ([]IPReservation, error) {
+	reservations := []IPReservation{}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	if err := r.db.SelectContext(ctx, &reservations, "SELECT * FROM ip_reservations ORDER BY address"); err != nil {
+		return nil, fmt.Errorf("could not retrieve reservations: %w", err)
+	}
+
+	for index := range reservations {
+		email, err := r.openEmail(reservations[index].Email, reservations[index].SealedEmail)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations[index].Email = email
+		reservations[index].SealedEmail = ""
+	}
+
+	return reservations, nil
+}
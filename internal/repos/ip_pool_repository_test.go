@@ -0,0 +1,486 @@
+package repos_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewIPPool(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Excludes network and broadcast addresses", func(t *testing.T) {
+		t.Parallel()
+
+		pool, err := repos.NewIPPool("office", "10.0.0.0/29", time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, 6, pool.Size())
+		assert.Equal(t, "255.255.255.248", pool.Netmask().String())
+		assert.False(t, pool.IsHost(net.ParseIP("10.0.0.0")))
+		assert.True(t, pool.IsHost(net.ParseIP("10.0.0.1")))
+		assert.True(t, pool.IsHost(net.ParseIP("10.0.0.6")))
+		assert.False(t, pool.IsHost(net.ParseIP("10.0.0.7")))
+		assert.False(t, pool.IsHost(net.ParseIP("10.0.0.8")))
+	})
+
+	t.Run("Refuses invalid pools", func(t *testing.T) {
+		t.Parallel()
+
+		for _, cidr := range []string{"10.0.0.0", "10.0.0.0/31", "fd00::/64", ""} {
+			_, err := repos.NewIPPool("office", cidr, time.Hour)
+			assert.ErrorIs(t, err, repos.ErrInvalidIPPool, cidr)
+		}
+
+		_, err := repos.NewIPPool("", "10.0.0.0/24", time.Hour)
+		assert.ErrorIs(t, err, repos.ErrInvalidIPPool)
+
+		_, err = repos.NewIPPool("office", "10.0.0.0/24", 0)
+		assert.ErrorIs(t, err, repos.ErrInvalidIPPool)
+	})
+}
+
+func TestNewIPPoolRepository(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses overlapping pools", func(t *testing.T) {
+		t.Parallel()
+
+		office, _ := repos.NewIPPool("office", "10.0.0.0/16", time.Hour)
+		lab, _ := repos.NewIPPool("lab", "10.0.4.0/24", time.Hour)
+
+		_, err := repos.NewIPPoolRepository(mocks.NewMockDB(t), []*repos.IPPool{office, lab})
+		assert.ErrorIs(t, err, repos.ErrInvalidIPPool)
+	})
+
+	t.Run("Refuses duplicate names", func(t *testing.T) {
+		t.Parallel()
+
+		office, _ := repos.NewIPPool("office", "10.0.0.0/24", time.Hour)
+		other, _ := repos.NewIPPool("office", "10.1.0.0/24", time.Hour)
+
+		_, err := repos.NewIPPoolRepository(mocks.NewMockDB(t), []*repos.IPPool{office, other})
+		assert.ErrorIs(t, err, repos.ErrInvalidIPPool)
+	})
+}
+
+func TestIPPoolRepository_Allocate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	t.Run("Leases the first free address", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+
+		first, err := poolRepo.Allocate(context.Background(), "office", "first@test.com", "s1", now)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", first.Address)
+		assert.Equal(t, now.Add(time.Hour).Unix(), first.ExpiresAt)
+
+		second, err := poolRepo.Allocate(context.Background(), "office", "second@test.com", "s2", now)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.2", second.Address)
+	})
+
+	t.Run("Same session keeps its address", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+
+		first, _ := poolRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+		_, _ = poolRepo.Allocate(context.Background(), "office", "other@test.com", "s2", now)
+
+		again, err := poolRepo.Allocate(context.Background(), "office", "USER@test.com", "s1", now.Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, first.Address, again.Address)
+		assert.Equal(t, now.Add(time.Minute+time.Hour).Unix(), again.ExpiresAt)
+
+		other, err := poolRepo.Allocate(context.Background(), "office", "user@test.com", "s3", now)
+		assert.NoError(t, err)
+		assert.NotEqual(t, first.Address, other.Address)
+	})
+
+	t.Run("Fails when the pool is exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+
+		for i := 0; i < 6; i++ {
+			_, err := poolRepo.Allocate(context.Background(), "office", "user@test.com", string(rune('a'+i)), now)
+			assert.NoError(t, err)
+		}
+
+		_, err := poolRepo.Allocate(context.Background(), "office", "user@test.com", "z", now)
+		assert.ErrorIs(t, err, repos.ErrIPPoolExhausted)
+	})
+
+	t.Run("Expired leases are reused", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+
+		first, _ := poolRepo.Allocate(context.Background(), "office", "first@test.com", "s1", now)
+
+		second, err := poolRepo.Allocate(context.Background(), "office", "second@test.com", "s2", now.Add(2*time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, first.Address, second.Address)
+	})
+
+	t.Run("Reserved addresses are only leased to their user", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+
+		_, err := poolRepo.Reserve(context.Background(), "static@test.com", "10.0.0.1", now)
+		assert.NoError(t, err)
+
+		other, err := poolRepo.Allocate(context.Background(), "office", "other@test.com", "s1", now)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.2", other.Address)
+
+		static, err := poolRepo.Allocate(context.Background(), "office", "static@test.com", "s2", now)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", static.Address)
+		assert.True(t, static.Static)
+	})
+
+	t.Run("Refuses unknown pools", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+
+		_, err := poolRepo.Allocate(context.Background(), "lab", "user@test.com", "s1", now)
+		assert.ErrorIs(t, err, repos.ErrUnknownIPPool)
+	})
+
+	t.Run("Refuses requests without session", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+
+		for _, sessionID := range []string{"", " "} {
+			_, err := poolRepo.Allocate(context.Background(), "office", "user@test.com", sessionID, now)
+			assert.ErrorIs(t, err, repos.ErrMissingSessionID)
+		}
+
+		leases, _ := poolRepo.Leases(context.Background(), now)
+		assert.Empty(t, leases)
+	})
+
+	t.Run("Refuses users holding too many leases", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		poolRepo.SetMaxUserLeases(2)
+
+		_, _ = poolRepo.Reserve(context.Background(), "user@test.com", "10.0.0.6", now)
+
+		for _, sessionID := range []string{"s1", "s2"} {
+			_, err := poolRepo.Allocate(context.Background(), "office", "other@test.com", sessionID, now)
+			assert.NoError(t, err)
+		}
+
+		_, err := poolRepo.Allocate(context.Background(), "office", "other@test.com", "s3", now)
+		assert.ErrorIs(t, err, repos.ErrTooManyLeases)
+
+		// Sessions already leased keep their address and other users are not limited
+		_, err = poolRepo.Allocate(context.Background(), "office", "other@test.com", "s1", now)
+		assert.NoError(t, err)
+
+		_, err = poolRepo.Allocate(context.Background(), "office", "user@test.com", "s4", now)
+		assert.NoError(t, err)
+	})
+}
+
+func TestIPPoolRepository_Release(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	t.Run("Frees the address", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		lease, _ := poolRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+
+		assert.NoError(t, poolRepo.Release(context.Background(), lease.Address, "user@test.com"))
+
+		leases, err := poolRepo.Leases(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Empty(t, leases)
+	})
+
+	t.Run("Only the holder can release", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		lease, _ := poolRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+
+		err := poolRepo.Release(context.Background(), lease.Address, "other@test.com")
+		assert.ErrorIs(t, err, repos.ErrLeaseNotFound)
+	})
+}
+
+func TestIPPoolRepository_Renew(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	t.Run("Extends the lease", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		lease, _ := poolRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+
+		renewed, err := poolRepo.Renew(context.Background(), lease.Address, "user@test.com", "s1", now.Add(30*time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, now.Add(90*time.Minute).Unix(), renewed.ExpiresAt)
+	})
+
+	t.Run("Expired leases cannot be renewed", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		lease, _ := poolRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+
+		_, err := poolRepo.Renew(context.Background(), lease.Address, "user@test.com", "s1", now.Add(2*time.Hour))
+		assert.ErrorIs(t, err, repos.ErrLeaseNotFound)
+	})
+}
+
+func TestIPPoolRepository_ExpireLeases(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Deletes expired leases only", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		_, _ = poolRepo.Allocate(context.Background(), "office", "new@test.com", "s2", now)
+		_, _ = poolRepo.Allocate(context.Background(), "office", "old@test.com", "s1", now.Add(-2*time.Hour))
+
+		expired, err := poolRepo.ExpireLeases(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), expired)
+
+		leases, _ := poolRepo.Leases(context.Background(), now)
+		assert.Len(t, leases, 1)
+		assert.Equal(t, "new@test.com", leases[0].Email)
+	})
+}
+
+func TestIPPoolRepository_Reserve(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	t.Run("Replaces the previous reservation", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+
+		_, err := poolRepo.Reserve(context.Background(), "user@test.com", "10.0.0.3", now)
+		assert.NoError(t, err)
+
+		reservation, err := poolRepo.Reserve(context.Background(), "user@test.com", "10.0.0.4", now)
+		assert.NoError(t, err)
+		assert.Equal(t, "office", reservation.Pool)
+
+		reservations, err := poolRepo.Reservations(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []repos.IPReservation{{Email: "user@test.com", Pool: "office", Address: "10.0.0.4"}}, reservations)
+	})
+
+	t.Run("Refuses addresses outside pools", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+
+		for _, address := range []string{"10.0.0.0", "10.0.0.7", "192.168.1.1", "not-an-ip"} {
+			_, err := poolRepo.Reserve(context.Background(), "user@test.com", address, now)
+			assert.ErrorIs(t, err, repos.ErrAddressOutsidePool, address)
+		}
+	})
+
+	t.Run("Refuses addresses held by another user", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		lease, _ := poolRepo.Allocate(context.Background(), "office", "other@test.com", "s1", now)
+
+		_, err := poolRepo.Reserve(context.Background(), "user@test.com", lease.Address, now)
+		assert.ErrorIs(t, err, repos.ErrAddressInUse)
+
+		_, err = poolRepo.Reserve(context.Background(), "other@test.com", "10.0.0.5", now)
+		assert.NoError(t, err)
+
+		_, err = poolRepo.Reserve(context.Background(), "user@test.com", "10.0.0.5", now)
+		assert.ErrorIs(t, err, repos.ErrAddressInUse)
+	})
+
+	t.Run("Unreserve removes the reservation", func(t *testing.T) {
+		t.Parallel()
+
+		poolRepo := mocks.NewMockIPPoolRepository(t)
+		_, _ = poolRepo.Reserve(context.Background(), "user@test.com", "10.0.0.3", now)
+
+		assert.NoError(t, poolRepo.Unreserve(context.Background(), "user@test.com"))
+		assert.ErrorIs(t, poolRepo.Unreserve(context.Background(), "user@test.com"), repos.ErrLeaseNotFound)
+	})
+}
+
+func TestIPPoolRepository_SetCipher(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	newEncryptedPoolRepository := func(t *testing.T, db *sqlx.DB, userRepo *repos.UserRepository) *repos.IPPoolRepository {
+		t.Helper()
+
+		pool, _ := repos.NewIPPool("office", "10.0.0.0/29", time.Hour)
+		poolRepo, err := repos.NewIPPoolRepository(db, []*repos.IPPool{pool})
+		assert.NoError(t, err)
+
+		if userRepo != nil {
+			assert.NoError(t, poolRepo.SetCipher(context.Background(), userRepo.Cipher()))
+		}
+
+		return poolRepo
+	}
+
+	rawEmails := func(t *testing.T, db *sqlx.DB) string {
+		t.Helper()
+
+		var emails []string
+		assert.NoError(t, db.Select(&emails, "SELECT email FROM ip_leases"))
+
+		var reserved []string
+		assert.NoError(t, db.Select(&reserved, "SELECT email FROM ip_reservations"))
+
+		return strings.Join(append(emails, reserved...), " ")
+	}
+
+	t.Run("Stores emails encrypted", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		poolRepo := newEncryptedPoolRepository(t, db, newEncryptedUserRepository(t, db))
+
+		lease, err := poolRepo.Allocate(context.Background(), "office", "User@test.com", "s1", now)
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", lease.Email)
+
+		_, err = poolRepo.Reserve(context.Background(), "other@test.com", "10.0.0.5", now)
+		assert.NoError(t, err)
+		assert.NotContains(t, rawEmails(t, db), "@test.com")
+
+		leases, err := poolRepo.Leases(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", leases[0].Email)
+
+		reservations, err := poolRepo.Reservations(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []repos.IPReservation{{Email: "other@test.com", Pool: "office", Address: "10.0.0.5"}}, reservations)
+
+		_, err = poolRepo.Renew(context.Background(), lease.Address, "user@test.com", "s1", now)
+		assert.NoError(t, err)
+		assert.NoError(t, poolRepo.Release(context.Background(), lease.Address, "USER@test.com"))
+		assert.NoError(t, poolRepo.Unreserve(context.Background(), "other@test.com"))
+	})
+
+	t.Run("Encrypts emails stored in clear text", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		clearRepo := newEncryptedPoolRepository(t, db, nil)
+		lease, _ := clearRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+		_, _ = clearRepo.Reserve(context.Background(), "other@test.com", "10.0.0.5", now)
+		assert.Contains(t, rawEmails(t, db), "user@test.com")
+
+		poolRepo := newEncryptedPoolRepository(t, db, newEncryptedUserRepository(t, db))
+		assert.NotContains(t, rawEmails(t, db), "@test.com")
+
+		again, err := poolRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+		assert.NoError(t, err)
+		assert.Equal(t, lease.Address, again.Address)
+
+		reservations, err := poolRepo.Reservations(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "other@test.com", reservations[0].Email)
+	})
+
+	t.Run("Leases remain readable after the data key rotation", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		userRepo := newEncryptedUserRepository(t, db)
+		poolRepo := newEncryptedPoolRepository(t, db, userRepo)
+
+		lease, _ := poolRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+		_, _ = poolRepo.Reserve(context.Background(), "other@test.com", "10.0.0.5", now)
+
+		assert.NoError(t, userRepo.RotateEncryptionKey(context.Background()))
+
+		leases, err := poolRepo.Leases(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", leases[0].Email)
+
+		again, err := poolRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+		assert.NoError(t, err)
+		assert.Equal(t, lease.Address, again.Address)
+
+		reservations, err := poolRepo.Reservations(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "other@test.com", reservations[0].Email)
+	})
+}
+
+func TestUserRepository_Delete_ReleasesAddresses(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	for name, encrypted := range map[string]bool{"clear text": false, "encrypted": true} {
+		encrypted := encrypted
+
+		t.Run("Deleting a user releases its addresses in "+name+" databases", func(t *testing.T) {
+			t.Parallel()
+
+			db := mocks.NewMockDB(t)
+
+			userRepo, _ := repos.NewUserRepository(db)
+			if encrypted {
+				userRepo = newEncryptedUserRepository(t, db)
+			}
+
+			pool, _ := repos.NewIPPool("office", "10.0.0.0/29", time.Hour)
+			poolRepo, _ := repos.NewIPPoolRepository(db, []*repos.IPPool{pool})
+
+			if encrypted {
+				assert.NoError(t, poolRepo.SetCipher(context.Background(), userRepo.Cipher()))
+			}
+
+			_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+			_, _ = poolRepo.Allocate(context.Background(), "office", "user@test.com", "s1", now)
+			_, _ = poolRepo.Reserve(context.Background(), "user@test.com", "10.0.0.5", now)
+			_, _ = poolRepo.Allocate(context.Background(), "office", "other@test.com", "s2", now)
+
+			assert.NoError(t, userRepo.Delete(context.Background(), "user@test.com"))
+
+			leases, _ := poolRepo.Leases(context.Background(), now)
+			assert.Len(t, leases, 1)
+			assert.Equal(t, "other@test.com", leases[0].Email)
+
+			reservations, _ := poolRepo.Reservations(context.Background())
+			assert.Empty(t, reservations)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package repos
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// columnMigration is a column added to a table after its creation.
+// Rows existing before the migration get defaultValue instead of NULL.
+type columnMigration struct {
+	name         string
+	columnType   string
+	defaultValue interface{}
+}
+
+// addMissingColumns adds the columns not yet present in the table.
+func addMissingColumns(migrateTx *sqlx.Tx, table string, columns []columnMigration) error {
+	var existing []string
+
+	if err := migrateTx.Select(&existing, "SELECT Name FROM __Column WHERE TableName == $1", table); err != nil {
+		return fmt.Errorf("could not list %s columns: %w", table, err)
+	}
+
+	present := map[string]bool{}
+	for _, name := range existing {
+		present[name] = true
+	}
+
+	for _, column := range columns {
+		if present[column.name] {
+			continue
+		}
+
+		if _, err := migrateTx.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s %s", table, column.name, column.columnType)); err != nil {
+			return fmt.Errorf("could not add %s.%s: %w", table, column.name, err)
+		}
+
+		query := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s IS NULL", table, column.name, column.name)
+		if _, err := migrateTx.Exec(query, column.defaultValue); err != nil {
+			return fmt.Errorf("could not set %s.%s default value: %w", table, column.name, err)
+		}
+	}
+
+	return nil
+}
+
+// tableExists returns true when the table was created.
+func tableExists(migrateTx *sqlx.Tx, table string) (bool, error) {
+	var count int64
+
+	if err := migrateTx.Get(&count, "SELECT count(*) FROM __Table WHERE Name == $1", table); err != nil {
+		return false, fmt.Errorf("could not look for table %s: %w", table, err)
+	}
+
+	return count > 0, nil
+}
+
+// indexIsUnique returns true when the index exists and enforces unique values.
+func indexIsUnique(migrateTx *sqlx.Tx, index string) (bool, error) {
+	var unique []bool
+
+	if err := migrateTx.Select(&unique, "SELECT IsUnique FROM __Index WHERE Name == $1", index); err != nil {
+		return false, fmt.Errorf("could not read index %s: %w", index, err)
+	}
+
+	return len(unique) == 1 && unique[0], nil
+}
+
+// migrateAttributeValues fills attribute_values for the clear text users whose attributes were stored before it.
+func migrateAttributeValues(migrateTx *sqlx.Tx) error {
+	var rows []struct {
+		RowID      int64  `db:"row_id"`
+		Attributes string `db:"attributes"`
+	}
+
+	query := `SELECT id() AS row_id, attributes FROM users WHERE attributes != "" AND attribute_values == "" AND sealed_email == ""`
+	if err := migrateTx.Select(&rows, query); err != nil {
+		return fmt.Errorf("could not read users attributes: %w", err)
+	}
+
+	for _, row := range rows {
+		user := User{StoredAttributes: row.Attributes}
+		if err := user.decodeAttributes(); err != nil {
+			return err
+		}
+
+		if _, err := migrateTx.Exec("UPDATE users SET attribute_values = $1 WHERE id() == $2", encodeAttributeValues(user.Attributes), row.RowID); err != nil {
+			return fmt.Errorf("could not migrate user attributes: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ErrDuplicateUsers is returned when users only differing by the case of their email must be resolved before
+// emails can be made unique, the migration never picks which account to keep.
+var ErrDuplicateUsers = errors.New("users share the same email in different cases")
+
+// migrateUniqueUserEmails stores emails in canonical form and replaces the users email index by a unique one.
+// Users only differing by the case of their email are duplicates, they are reported and nothing is migrated
+// until an admin deletes or renames all but one of them.
+func migrateUniqueUserEmails(migrateTx *sqlx.Tx) error {
+	unique, err := indexIsUnique(migrateTx, "idx_users_email")
+	if err != nil || unique {
+		return err
+	}
+
+	var rows []struct {
+		RowID      int64  `db:"row_id"`
+		Email      string `db:"email"`
+		LastSeenAt int64  `db:"last_seen_at"`
+	}
+
+	// id() identifies rows even when the same email was stored twice
+	if err := migrateTx.Select(&rows, "SELECT id() AS row_id, email, last_seen_at FROM users ORDER BY email, last_seen_at DESC"); err != nil {
+		return fmt.Errorf("could not read users emails: %w", err)
+	}
+
+	stored := map[string][]string{}
+	duplicates := []string{}
+
+	for _, row := range rows {
+		email := CanonicalEmail(row.Email)
+
+		stored[email] = append(stored[email], fmt.Sprintf("%s last seen %s", row.Email, time.Unix(row.LastSeenAt, 0).UTC().Format(time.RFC3339)))
+		if len(stored[email]) == 2 { //nolint:gomnd
+			duplicates = append(duplicates, email)
+		}
+	}
+
+	if len(duplicates) > 0 {
+		report := make([]string, 0, len(duplicates))
+		for _, email := range duplicates {
+			report = append(report, email+" ("+strings.Join(stored[email], ", ")+")")
+		}
+
+		return fmt.Errorf("%w, delete or rename all but one of each with the previous release: %s", ErrDuplicateUsers, strings.Join(report, "; "))
+	}
+
+	for _, row := range rows {
+		if email := CanonicalEmail(row.Email); email != row.Email {
+			if _, err := migrateTx.Exec("UPDATE users SET email = $1 WHERE id() == $2", email, row.RowID); err != nil {
+				return fmt.Errorf("could not migrate user %s: %w", row.Email, err)
+			}
+		}
+	}
+
+	if _, err := migrateTx.Exec("DROP INDEX IF EXISTS idx_users_email"); err != nil {
+		return fmt.Errorf("could not drop users email index: %w", err)
+	}
+
+	if _, err := migrateTx.Exec("CREATE UNIQUE INDEX idx_users_email ON users (email)"); err != nil {
+		return fmt.Errorf("could not create users unique email index: %w", err)
+	}
+
+	return nil
+}
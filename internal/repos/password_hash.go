@@ -0,0 +1,203 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+)
+
+var ErrInvalidHashParams = errors.New("invalid argon2id parameters")
+
+const (
+	hashSaltLength = 16
+	hashKeyLength  = 32
+
+	// argon2 requires at least 8KiB of memory per lane.
+	hashMinMemoryPerLane = 8
+
+	benchStartMemory = 16 * 1024
+)
+
+// NewHashParams returns argon2id parameters using memory (in KiB), iterations and parallelism.
+func NewHashParams(memory uint32, iterations uint32, parallelism uint8) (*argon2id.Params, error) {
+	if iterations < 1 || parallelism < 1 {
+		return nil, fmt.Errorf("%w: iterations and parallelism must be at least 1", ErrInvalidHashParams)
+	}
+
+	if memory < hashMinMemoryPerLane*uint32(parallelism) {
+		return nil, fmt.Errorf("%w: memory must be at least %d KiB per parallel lane", ErrInvalidHashParams, hashMinMemoryPerLane)
+	}
+
+	return &argon2id.Params{
+		Memory:      memory,
+		Iterations:  iterations,
+		Parallelism: parallelism,
+		SaltLength:  hashSaltLength,
+		KeyLength:   hashKeyLength,
+	}, nil
+}
+
+// validatePasswordHash returns an error unless hash is an argon2id hash with usable parameters.
+func validatePasswordHash(hash string) error {
+	params, salt, key, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return fmt.Errorf("invalid password hash: %w", err)
+	}
+
+	if _, err := NewHashParams(params.Memory, params.Iterations, params.Parallelism); err != nil {
+		return fmt.Errorf("invalid password hash: %w", err)
+	}
+
+	if len(salt) == 0 || len(key) == 0 {
+		return fmt.Errorf("invalid password hash: %w: missing salt or key", ErrInvalidHashParams)
+	}
+
+	return nil
+}
+
+// SetHashParams changes the argon2id parameters used for new password hashes.
+// Existing hashes are upgraded the next time their user authenticates.
+func (r *UserRepository) SetHashParams(params *argon2id.Params) {
+	r.hashParams = params
+}
+
+func (r *UserRepository) createPasswordHash(password string) (string, error) {
+	if r.hashParams == nil {
+		return CreatePasswordHash(password)
+	}
+
+	hash, err := argon2id.CreateHash(password, r.hashParams)
+	if err != nil {
+		return "", fmt.Errorf("creting password hash failed: %w", err)
+	}
+
+	return hash, nil
+}
+
+// NeedsRehash returns true when the hash was not created with the repository's current parameters.
+func (r *UserRepository) NeedsRehash(hash string) bool {
+	current := r.hashParams
+	if current == nil {
+		current = argon2id.DefaultParams
+	}
+
+	params, salt, key, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != current.Memory ||
+		params.Iterations != current.Iterations ||
+		params.Parallelism != current.Parallelism ||
+		uint32(len(salt)) != current.SaltLength ||
+		uint32(len(key)) != current.KeyLength
+}
+
+// rehash replaces the user password hash with one using the current parameters.
+// The update only happens if the password was not changed since oldHash was read.
+func (r *UserRepository) rehash(ctx context.Context, email string, password string, oldHash string) {
+	defer r.holdDataKey()()
+
+	hash, err := r.createPasswordHash(password)
+	if err == nil {
+		hash, err = r.sealField(r.lookupEmail(email), "password", hash)
+	}
+
+	if err != nil {
+		log.Printf("could not rehash %s password: %v", email, err)
+
+		return
+	}
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	updateTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Printf("could not rehash %s password: %v", email, err)
+
+		return
+	}
+	defer func() { _ = updateTx.Rollback() }() //nolint:wsl
+
+	// Encrypted hashes differ each time they are stored, the stored value is compared once decrypted
+	var stored string
+	if err := updateTx.GetContext(ctx, &stored, "SELECT password FROM users WHERE email == $1", r.lookupEmail(email)); err != nil {
+		log.Printf("could not rehash %s password: %v", email, err)
+
+		return
+	}
+
+	if current, err := r.openField(r.lookupEmail(email), "password", stored); err != nil || current != oldHash {
+		return
+	}
+
+	// password_updated_at is left untouched, the password itself did not change
+	_, err = updateTx.ExecContext(ctx, "UPDATE users SET password = $1 WHERE email == $2 AND password == $3", hash, r.lookupEmail(email), stored)
+	if err != nil {
+		log.Printf("could not rehash %s password: %v", email, err)
+
+		return
+	}
+
+	if err := updateTx.Commit(); err != nil {
+		log.Printf("could not rehash %s password: %v", email, err)
+	}
+}
+
+// HashDuration returns how long creating a single hash takes with params.
+func HashDuration(params *argon2id.Params) (time.Duration, error) {
+	start := time.Now()
+
+	if _, err := argon2id.CreateHash("fringe-bench-hash", params); err != nil {
+		return 0, fmt.Errorf("could not benchmark hash: %w", err)
+	}
+
+	return time.Since(start), nil
+}
+
+// BenchmarkHashParams suggests argon2id parameters taking about target to hash on this machine.
+// Memory is doubled first, up to maxMemory KiB, as recommended by the argon2 RFC, then iterations are added.
+func BenchmarkHashParams(target time.Duration, maxMemory uint32, parallelism uint8) (*argon2id.Params, time.Duration, error) {
+	memory := uint32(benchStartMemory)
+	if memory > maxMemory {
+		memory = maxMemory
+	}
+
+	params, err := NewHashParams(memory, 1, parallelism)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	duration, err := HashDuration(params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for duration*2 <= target && params.Memory*2 <= maxMemory {
+		params.Memory *= 2
+
+		if duration, err = HashDuration(params); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	for {
+		perIteration := duration / time.Duration(params.Iterations)
+		if duration+perIteration > target {
+			break
+		}
+
+		params.Iterations++
+
+		if duration, err = HashDuration(params); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return params, duration, nil
+}
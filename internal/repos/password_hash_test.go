@@ -0,0 +1,162 @@
+package repos_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHashParams(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns params with the requested values", func(t *testing.T) {
+		t.Parallel()
+
+		params, err := repos.NewHashParams(32*1024, 3, 4)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(32*1024), params.Memory)
+		assert.Equal(t, uint32(3), params.Iterations)
+		assert.Equal(t, uint8(4), params.Parallelism)
+	})
+
+	t.Run("Refuses zero iterations or parallelism", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := repos.NewHashParams(32*1024, 0, 2)
+		assert.ErrorIs(t, err, repos.ErrInvalidHashParams)
+
+		_, err = repos.NewHashParams(32*1024, 1, 0)
+		assert.ErrorIs(t, err, repos.ErrInvalidHashParams)
+	})
+
+	t.Run("Refuses less than 8KiB per lane", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := repos.NewHashParams(15, 1, 2)
+		assert.ErrorIs(t, err, repos.ErrInvalidHashParams)
+	})
+}
+
+func TestUserRepository_NeedsRehash(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Default hashes do not need rehash with default params", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		hash, _ := repos.CreatePasswordHash("a-password")
+
+		assert.False(t, userRepo.NeedsRehash(hash))
+	})
+
+	t.Run("Hashes with other params need rehash", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		params, _ := repos.NewHashParams(16*1024, 2, 1)
+		userRepo.SetHashParams(params)
+
+		hash, _ := repos.CreatePasswordHash("a-password")
+		assert.True(t, userRepo.NeedsRehash(hash))
+
+		hash, _ = argon2id.CreateHash("a-password", params)
+		assert.False(t, userRepo.NeedsRehash(hash))
+	})
+
+	t.Run("Malformed hashes need rehash", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		assert.True(t, userRepo.NeedsRehash("not-a-hash"))
+	})
+}
+
+func TestUserRepository_Authenticate_Rehash(t *testing.T) {
+	t.Parallel()
+
+	t.Run("New users are hashed with configured params", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		params, _ := repos.NewHashParams(16*1024, 2, 1)
+		userRepo.SetHashParams(params)
+
+		user, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+
+		hashParams, _, _, err := argon2id.DecodeHash(user.PasswordHash)
+		assert.NoError(t, err)
+		assert.Equal(t, params.Memory, hashParams.Memory)
+		assert.Equal(t, params.Iterations, hashParams.Iterations)
+	})
+
+	t.Run("Successful login upgrades old hashes", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		before, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+
+		params, _ := repos.NewHashParams(16*1024, 2, 1)
+		userRepo.SetHashParams(params)
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "user@test.com", "a-password")
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+
+		assert.Eventually(t, func() bool {
+			user, err := userRepo.FindByEmail(context.Background(), "user@test.com")
+
+			return err == nil && !userRepo.NeedsRehash(user.PasswordHash)
+		}, 5*time.Second, 10*time.Millisecond)
+
+		after, _ := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.Equal(t, before.PasswordUpdatedAt, after.PasswordUpdatedAt)
+		assert.True(t, after.PasswordMatch("a-password"))
+	})
+
+	t.Run("Failed login does not rehash", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		before, _ := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		params, _ := repos.NewHashParams(16*1024, 2, 1)
+		userRepo.SetHashParams(params)
+
+		authenticated, _ := userRepo.Authenticate(context.Background(), "user@test.com", "wrong-password")
+		assert.False(t, authenticated)
+
+		time.Sleep(100 * time.Millisecond)
+
+		after, _ := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.Equal(t, before.PasswordHash, after.PasswordHash)
+	})
+}
+
+func TestBenchmarkHashParams(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Stays within max memory", func(t *testing.T) {
+		t.Parallel()
+
+		params, duration, err := repos.BenchmarkHashParams(50*time.Millisecond, 8*1024, 1)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, params.Memory, uint32(8*1024))
+		assert.GreaterOrEqual(t, params.Iterations, uint32(1))
+		assert.Greater(t, duration, time.Duration(0))
+	})
+
+	t.Run("Refuses invalid parallelism", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := repos.BenchmarkHashParams(time.Millisecond, 8*1024, 0)
+		assert.ErrorIs(t, err, repos.ErrInvalidHashParams)
+	})
+}
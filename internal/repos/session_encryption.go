@@ -0,0 +1,190 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// When the user database is encrypted, sessions store the email blind index of their user in the email column, as
+// the users table does, and the email itself encrypted in sealed_email. Names and pictures are encrypted in place.
+// Sealed session values are bound to the session ID.
+
+// Session columns are named in the additional data of their sealed values, apart from the users columns.
+const (
+	sessionEmailColumn   = "session_email"
+	sessionNameColumn    = "session_name"
+	sessionPictureColumn = "session_picture"
+)
+
+// SetCipher encrypts sessions with cipher from now on, sessions stored in clear text are encrypted right away.
+// The cipher must be the one of the UserRepository, data key rotations reseal both.
+func (r *SessionRepository) SetCipher(ctx context.Context, cipher *FieldCipher) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	cipher.rotation.RLock()
+	defer cipher.rotation.RUnlock()
+
+	encryptTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not encrypt sessions: %w", err)
+	}
+	defer func() { _ = encryptTx.Rollback() }() //nolint:wsl
+
+	sessions := []Session{}
+	if err := encryptTx.SelectContext(ctx, &sessions, "SELECT * FROM sessions WHERE sealed_email == \"\""); err != nil {
+		return fmt.Errorf("could not encrypt sessions: %w", err)
+	}
+
+	if err := resealSessions(ctx, encryptTx, cipher, cipher.active(), sessions); err != nil {
+		return err
+	}
+
+	if err := encryptTx.Commit(); err != nil {
+		return fmt.Errorf("could not encrypt sessions: %w", err)
+	}
+
+	r.cipher = cipher
+
+	return nil
+}
+
+// resealSessions stores the clear text sessions encrypted with key.
+func resealSessions(ctx context.Context, tx *sqlx.Tx, cipher *FieldCipher, key *dataKey, sessions []Session) error {
+	for _, session := range sessions {
+		sealed, err := cipher.sealSession(key, session)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE sessions SET email = $1, sealed_email = $2, name = $3, picture = $4 WHERE id == $5",
+			sealed.Email, sealed.SealedEmail, sealed.Name, sealed.Picture, session.ID)
+		if err != nil {
+			return fmt.Errorf("could not encrypt session %s: %w", session.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// resealAllSessions stores the sessions encrypted with key, during a data key rotation.
+// Nothing is done when the sessions table was never created.
+func resealAllSessions(ctx context.Context, tx *sqlx.Tx, cipher *FieldCipher, key *dataKey) error {
+	exists, err := tableExists(tx, "sessions")
+	if err != nil || !exists {
+		return err
+	}
+
+	sessions := []Session{}
+	if err := tx.SelectContext(ctx, &sessions, "SELECT * FROM sessions WHERE sealed_email != \"\""); err != nil {
+		return fmt.Errorf("could not encrypt sessions: %w", err)
+	}
+
+	for index := range sessions {
+		if err := cipher.openSession(&sessions[index]); err != nil {
+			return err
+		}
+	}
+
+	return resealSessions(ctx, tx, cipher, key, sessions)
+}
+
+// sealSession returns the session as stored in an encrypted database.
+func (c *FieldCipher) sealSession(key *dataKey, session Session) (Session, error) {
+	var err error
+
+	email := CanonicalEmail(session.Email)
+	sealed := session
+	sealed.Email = c.blindIndex(key, email)
+
+	for _, field := range []struct {
+		column string
+		value  string
+		target *string
+	}{
+		{column: sessionEmailColumn, value: email, target: &sealed.SealedEmail},
+		{column: sessionNameColumn, value: session.Name, target: &sealed.Name},
+		{column: sessionPictureColumn, value: session.Picture, target: &sealed.Picture},
+	} {
+		if *field.target, err = c.seal(key, session.ID, field.column, field.value); err != nil {
+			return session, err
+		}
+	}
+
+	return sealed, nil
+}
+
+// openSession decrypts the encrypted columns of the session.
+func (c *FieldCipher) openSession(session *Session) error {
+	var err error
+
+	for _, field := range []struct {
+		column string
+		value  string
+		target *string
+	}{
+		{column: sessionEmailColumn, value: session.SealedEmail, target: &session.Email},
+		{column: sessionNameColumn, value: session.Name, target: &session.Name},
+		{column: sessionPictureColumn, value: session.Picture, target: &session.Picture},
+	} {
+		if *field.target, err = c.open(session.ID, field.column, field.value); err != nil {
+			return err
+		}
+	}
+
+	session.SealedEmail = ""
+
+	return nil
+}
+
+// holdDataKey keeps the active data key from being rotated until the returned function is called.
+func (r *SessionRepository) holdDataKey() func() {
+	if r.cipher == nil {
+		return func() {}
+	}
+
+	r.cipher.rotation.RLock()
+
+	return r.cipher.rotation.RUnlock
+}
+
+// lookupEmail returns the value of the email column for the email.
+func (r *SessionRepository) lookupEmail(email string) string {
+	email = CanonicalEmail(email)
+
+	if r.cipher == nil {
+		return email
+	}
+
+	return r.cipher.blindIndex(r.cipher.active(), email)
+}
+
+// sealSession returns the session as it must be stored, encrypted when the database is.
+func (r *SessionRepository) sealSession(session Session) (Session, error) {
+	if r.cipher == nil {
+		return session, nil
+	}
+
+	return r.cipher.sealSession(r.cipher.active(), session)
+}
+
+// openSessions decrypts the sessions read from the database.
+func (r *SessionRepository) openSessions(sessions []Session) error {
+	for index := range sessions {
+		if len(sessions[index].SealedEmail) == 0 {
+			continue
+		}
+
+		if r.cipher == nil {
+			return ErrMissingCipher
+		}
+
+		if err := r.cipher.openSession(&sessions[index]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
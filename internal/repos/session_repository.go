@@ -0,0 +1,400 @@
+package repos
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SessionRepository keeps the sessions started at login. A session is extended by exchanging its refresh token
+// for a new one, only the sha256 of the current and previous refresh tokens are stored.
+// Presenting the previous token again means it was copied, the session is then revoked.
+type SessionRepository struct {
+	db       *sqlx.DB
+	cipher   *FieldCipher
+	timeouts Timeouts
+	lifetime time.Duration
+}
+
+// Session holds what is needed to issue new access tokens without going back to the identity provider.
+type Session struct {
+	ID           string `db:"id" json:"id"`
+	Email        string `db:"email" json:"email"`
+	Name         string `db:"name" json:"name"`
+	Picture      string `db:"picture" json:"picture"`
+	Role         string `db:"role" json:"role"`
+	ProviderRole string `db:"provider_role" json:"provider_role"`
+	RefreshHash  string `db:"refresh_hash" json:"-"`
+	PreviousHash string `db:"previous_hash" json:"-"`
+	CreatedAt    int64  `db:"created_at" json:"created_at"`
+	RefreshedAt  int64  `db:"refreshed_at" json:"refreshed_at"`
+	ExpiresAt    int64  `db:"expires_at" json:"expires_at"`
+	RevokedAt    int64  `db:"revoked_at" json:"revoked_at"`
+	SealedEmail  string `db:"sealed_email" json:"-"`
+}
+
+var (
+	ErrSessionNotFound     = errors.New("session could not be found")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+	errSessionTokenFailure = errors.New("could not generate session token")
+)
+
+const (
+	// DefaultSessionLifetime is how long users stay logged in without going back to their identity provider.
+	DefaultSessionLifetime = 7 * 24 * time.Hour
+	// sessionRetention keeps ended sessions long enough for revocations to outlive the access tokens they issued.
+	sessionRetention        = 24 * time.Hour
+	sessionIDByteLength     = 16
+	refreshSecretByteLength = 32
+	refreshTokenSeparator   = "."
+	revokeSessionQuery      = "UPDATE sessions SET revoked_at = $1 WHERE id == $2 AND revoked_at == 0"
+)
+
+func NewSessionRepository(db *sqlx.DB) (*SessionRepository, error) {
+	if err := createSessionTable(db); err != nil {
+		return nil, err
+	}
+
+	return &SessionRepository{
+		db:       db,
+		timeouts: Timeouts{Read: DefaultReadTimeout, Write: DefaultWriteTimeout},
+		lifetime: DefaultSessionLifetime,
+	}, nil
+}
+
+// SetTimeouts changes how long each read and write operation may take before failing with ErrTimeout.
+func (r *SessionRepository) SetTimeouts(timeouts Timeouts) {
+	r.timeouts = timeouts
+}
+
+// SetLifetime changes how long new sessions last, refreshing a session does not extend it.
+func (r *SessionRepository) SetLifetime(lifetime time.Duration) {
+	r.lifetime = lifetime
+}
+
+func createSessionTable(db *sqlx.DB) error {
+	createTx := db.MustBegin()
+	defer func() { _ = createTx.Rollback() }()
+
+	createTx.MustExec("CREATE TABLE IF NOT EXISTS sessions (" +
+		"id string NOT NULL, " +
+		"email string NOT NULL, " +
+		"name string, " +
+		"picture string, " +
+		"role string NOT NULL, " +
+		"provider_role string NOT NULL, " +
+		"refresh_hash string NOT NULL, " +
+		"previous_hash string NOT NULL, " +
+		"created_at int64 NOT NULL, " +
+		"refreshed_at int64 NOT NULL, " +
+		"expires_at int64 NOT NULL, " +
+		"revoked_at int64 NOT NULL, " +
+		"sealed_email string)")
+	createTx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_id ON sessions (id)")
+	createTx.MustExec("CREATE INDEX IF NOT EXISTS idx_sessions_email ON sessions (email)")
+
+	// Tables created before sessions were encrypted
+	if err := addMissingColumns(createTx, "sessions", []columnMigration{{name: "sealed_email", columnType: "string", defaultValue: ""}}); err != nil {
+		return err
+	}
+
+	if err := createTx.Commit(); err != nil {
+		return fmt.Errorf("cannot create sessions table: %w", err)
+	}
+
+	return nil
+}
+
+func randomSessionToken(length int) (string, error) {
+	random := make([]byte, length)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("%w: %v", errSessionTokenFailure, err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// splitRefreshToken returns the session ID and the secret of a refresh token.
+func splitRefreshToken(refreshToken string) (string, string, error) {
+	parts := strings.Split(refreshToken, refreshTokenSeparator)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", ErrSessionNotFound
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// IsActive returns true until the session is revoked or expires.
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == 0 && now.Unix() < s.ExpiresAt
+}
+
+// Create starts a session for the user and returns it with its first refresh token. The provider role is the part
+// of role granted by the identity provider or its groups, it is kept to compute the role again on refresh.
+func (r *SessionRepository) Create(ctx context.Context, email string, name string, picture string, role string, providerRole string) (*Session, string, error) {
+	id, err := randomSessionToken(sessionIDByteLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomSessionToken(refreshSecretByteLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := Session{
+		ID:           id,
+		Email:        CanonicalEmail(email),
+		Name:         name,
+		Picture:      picture,
+		Role:         role,
+		ProviderRole: providerRole,
+		RefreshHash:  hashRefreshSecret(secret),
+		CreatedAt:    now.Unix(),
+		RefreshedAt:  now.Unix(),
+		ExpiresAt:    now.Add(r.lifetime).Unix(),
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+	defer r.holdDataKey()()
+
+	stored, err := r.sealSession(session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	createTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create session for %s: %w", session.Email, err)
+	}
+	defer func() { _ = createTx.Rollback() }() //nolint:wsl
+
+	// Sessions are purged when new ones start rather than by a job of their own
+	if _, err := createTx.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at < $1", now.Add(-sessionRetention).Unix()); err != nil {
+		return nil, "", fmt.Errorf("could not purge expired sessions: %w", err)
+	}
+
+	_, err = createTx.ExecContext(ctx, "INSERT INTO sessions (id, email, name, picture, role, provider_role, refresh_hash, previous_hash, created_at, refreshed_at, expires_at, revoked_at, sealed_email) "+
+		"VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,0,$12)",
+		stored.ID, stored.Email, stored.Name, stored.Picture, stored.Role, stored.ProviderRole, stored.RefreshHash, stored.PreviousHash, stored.CreatedAt, stored.RefreshedAt, stored.ExpiresAt, stored.SealedEmail)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create session for %s: %w", session.Email, err)
+	}
+
+	if err := createTx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("could not create session for %s: %w", session.Email, err)
+	}
+
+	return &session, id + refreshTokenSeparator + secret, nil
+}
+
+// Rotate exchanges the refresh token for a new one. ErrRefreshTokenReused is returned with the session, now revoked,
+// when the token was already exchanged.
+func (r *SessionRepository) Rotate(ctx context.Context, refreshToken string) (*Session, string, error) {
+	id, secret, err := splitRefreshToken(refreshToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	newSecret, err := randomSessionToken(refreshSecretByteLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	rotateTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not refresh session: %w", err)
+	}
+	defer func() { _ = rotateTx.Rollback() }() //nolint:wsl
+
+	session, err := r.findSession(ctx, rotateTx, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	hash := hashRefreshSecret(secret)
+
+	if len(session.PreviousHash) > 0 && subtle.ConstantTimeCompare([]byte(hash), []byte(session.PreviousHash)) == 1 {
+		if session.RevokedAt == 0 {
+			session.RevokedAt = now.Unix()
+			if _, err := rotateTx.ExecContext(ctx, revokeSessionQuery, session.RevokedAt, session.ID); err != nil {
+				return nil, "", fmt.Errorf("could not revoke session: %w", err)
+			}
+
+			if err := rotateTx.Commit(); err != nil {
+				return nil, "", fmt.Errorf("could not revoke session: %w", err)
+			}
+		}
+
+		return session, "", ErrRefreshTokenReused
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(session.RefreshHash)) != 1 || !session.IsActive(now) {
+		return nil, "", ErrSessionNotFound
+	}
+
+	session.PreviousHash = session.RefreshHash
+	session.RefreshHash = hashRefreshSecret(newSecret)
+	session.RefreshedAt = now.Unix()
+
+	_, err = rotateTx.ExecContext(ctx, "UPDATE sessions SET refresh_hash = $1, previous_hash = $2, refreshed_at = $3 WHERE id == $4",
+		session.RefreshHash, session.PreviousHash, session.RefreshedAt, session.ID)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not refresh session: %w", err)
+	}
+
+	if err := rotateTx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("could not refresh session: %w", err)
+	}
+
+	return session, session.ID + refreshTokenSeparator + newSecret, nil
+}
+
+// Revoke ends the session of the current refresh token and returns it.
+func (r *SessionRepository) Revoke(ctx context.Context, refreshToken string) (*Session, error) {
+	id, secret, err := splitRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	revokeTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not revoke session: %w", err)
+	}
+	defer func() { _ = revokeTx.Rollback() }() //nolint:wsl
+
+	session, err := r.findSession(ctx, revokeTx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashRefreshSecret(secret)), []byte(session.RefreshHash)) != 1 || session.RevokedAt != 0 {
+		return nil, ErrSessionNotFound
+	}
+
+	session.RevokedAt = time.Now().Unix()
+	if _, err := revokeTx.ExecContext(ctx, revokeSessionQuery, session.RevokedAt, session.ID); err != nil {
+		return nil, fmt.Errorf("could not revoke session: %w", err)
+	}
+
+	if err := revokeTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not revoke session: %w", err)
+	}
+
+	return session, nil
+}
+
+// RevokeUser ends every active session of the user and returns them.
+func (r *SessionRepository) RevokeUser(ctx context.Context, email string) ([]Session, error) {
+	return r.revokeUserSessions(ctx, email, func(Session) bool { return true })
+}
+
+// RevokeUserRole ends the active sessions of the user started with another provider role than providerRole and
+// returns them, their users log in again to get their new role.
+func (r *SessionRepository) RevokeUserRole(ctx context.Context, email string, providerRole string) ([]Session, error) {
+	return r.revokeUserSessions(ctx, email, func(session Session) bool { return session.ProviderRole != providerRole })
+}
+
+func (r *SessionRepository) revokeUserSessions(ctx context.Context, email string, revoke func(Session) bool) ([]Session, error) {
+	email = CanonicalEmail(email)
+	now := time.Now()
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+	defer r.holdDataKey()()
+
+	revokeTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not revoke %s sessions: %w", email, err)
+	}
+	defer func() { _ = revokeTx.Rollback() }() //nolint:wsl
+
+	active := []Session{}
+	err = revokeTx.SelectContext(ctx, &active, "SELECT * FROM sessions WHERE email == $1 AND revoked_at == 0 AND expires_at > $2", r.lookupEmail(email), now.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("could not revoke %s sessions: %w", email, err)
+	}
+
+	if err := r.openSessions(active); err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+
+	for _, session := range active {
+		if !revoke(session) {
+			continue
+		}
+
+		session.RevokedAt = now.Unix()
+		if _, err := revokeTx.ExecContext(ctx, revokeSessionQuery, now.Unix(), session.ID); err != nil {
+			return nil, fmt.Errorf("could not revoke %s sessions: %w", email, err)
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if err := revokeTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not revoke %s sessions: %w", email, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokedSince returns the sessions revoked at or after since, to restore revocations after a restart.
+func (r *SessionRepository) RevokedSince(ctx context.Context, since int64) ([]Session, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	sessions := []Session{}
+	if err := r.db.SelectContext(ctx, &sessions, "SELECT * FROM sessions WHERE revoked_at >= $1 AND revoked_at > 0", since); err != nil {
+		return nil, fmt.Errorf("could not list revoked sessions: %w", err)
+	}
+
+	if err := r.openSessions(sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *SessionRepository) findSession(ctx context.Context, tx *sqlx.Tx, id string) (*Session, error) {
+	sessions := []Session{}
+	if err := tx.SelectContext(ctx, &sessions, "SELECT * FROM sessions WHERE id == $1", id); err != nil {
+		return nil, fmt.Errorf("could not read session: %w", err)
+	}
+
+	if len(sessions) != 1 {
+		return nil, ErrSessionNotFound
+	}
+
+	if err := r.openSessions(sessions); err != nil {
+		return nil, err
+	}
+
+	return &sessions[0], nil
+}
@@ -0,0 +1,246 @@
+package repos_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionRepository_Rotate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Exchanges the refresh token for a new one", func(t *testing.T) {
+		t.Parallel()
+
+		sessionRepo, err := repos.NewSessionRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		created, refreshToken, err := sessionRepo.Create(context.Background(), "User@test.com", "User", "https://pictures.test.com/user", "admin", "admin")
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", created.Email)
+		assert.NotContains(t, created.RefreshHash, refreshToken)
+
+		session, rotatedToken, err := sessionRepo.Rotate(context.Background(), refreshToken)
+		assert.NoError(t, err)
+		assert.NotEqual(t, refreshToken, rotatedToken)
+		assert.Equal(t, created.ID, session.ID)
+		assert.Equal(t, "admin", session.Role)
+		assert.Equal(t, created.ExpiresAt, session.ExpiresAt)
+
+		_, _, err = sessionRepo.Rotate(context.Background(), rotatedToken+"x")
+		assert.ErrorIs(t, err, repos.ErrSessionNotFound)
+	})
+
+	t.Run("Revokes the session when a refresh token is reused", func(t *testing.T) {
+		t.Parallel()
+
+		sessionRepo, err := repos.NewSessionRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		_, refreshToken, err := sessionRepo.Create(context.Background(), "user@test.com", "", "", "user", "user")
+		assert.NoError(t, err)
+
+		_, rotatedToken, err := sessionRepo.Rotate(context.Background(), refreshToken)
+		assert.NoError(t, err)
+
+		session, _, err := sessionRepo.Rotate(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, repos.ErrRefreshTokenReused)
+		assert.NotZero(t, session.RevokedAt)
+
+		// The legitimate holder is logged out too
+		_, _, err = sessionRepo.Rotate(context.Background(), rotatedToken)
+		assert.ErrorIs(t, err, repos.ErrSessionNotFound)
+	})
+
+	t.Run("Refuses expired sessions and malformed tokens", func(t *testing.T) {
+		t.Parallel()
+
+		sessionRepo, err := repos.NewSessionRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+		sessionRepo.SetLifetime(-time.Minute)
+
+		_, refreshToken, err := sessionRepo.Create(context.Background(), "user@test.com", "", "", "user", "user")
+		assert.NoError(t, err)
+
+		for _, token := range []string{refreshToken, "", "no-separator", "too.many.parts"} {
+			_, _, err = sessionRepo.Rotate(context.Background(), token)
+			assert.ErrorIs(t, err, repos.ErrSessionNotFound)
+		}
+	})
+}
+
+func TestSessionRepository_Revoke(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Ends the session of the refresh token", func(t *testing.T) {
+		t.Parallel()
+
+		sessionRepo, err := repos.NewSessionRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		created, refreshToken, err := sessionRepo.Create(context.Background(), "user@test.com", "", "", "user", "user")
+		assert.NoError(t, err)
+
+		session, err := sessionRepo.Revoke(context.Background(), refreshToken)
+		assert.NoError(t, err)
+		assert.Equal(t, created.ID, session.ID)
+
+		_, _, err = sessionRepo.Rotate(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, repos.ErrSessionNotFound)
+
+		_, err = sessionRepo.Revoke(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, repos.ErrSessionNotFound)
+
+		revoked, err := sessionRepo.RevokedSince(context.Background(), time.Now().Add(-time.Minute).Unix())
+		assert.NoError(t, err)
+		assert.Len(t, revoked, 1)
+	})
+
+	t.Run("Ends every session of a user", func(t *testing.T) {
+		t.Parallel()
+
+		sessionRepo, err := repos.NewSessionRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		tokens := []string{}
+
+		for _, email := range []string{"user@test.com", "USER@test.com", "other@test.com"} {
+			_, refreshToken, err := sessionRepo.Create(context.Background(), email, "", "", "user", "user")
+			assert.NoError(t, err)
+
+			tokens = append(tokens, refreshToken)
+		}
+
+		revoked, err := sessionRepo.RevokeUser(context.Background(), "User@test.com")
+		assert.NoError(t, err)
+		assert.Len(t, revoked, 2)
+
+		for _, token := range tokens[:2] {
+			_, _, err = sessionRepo.Rotate(context.Background(), token)
+			assert.ErrorIs(t, err, repos.ErrSessionNotFound)
+		}
+
+		_, _, err = sessionRepo.Rotate(context.Background(), tokens[2])
+		assert.NoError(t, err)
+	})
+	t.Run("Ends the sessions of a user started with another provider role", func(t *testing.T) {
+		t.Parallel()
+
+		sessionRepo, err := repos.NewSessionRepository(mocks.NewMockDB(t))
+		assert.NoError(t, err)
+
+		_, userToken, err := sessionRepo.Create(context.Background(), "user@test.com", "", "", "user", "user")
+		assert.NoError(t, err)
+		_, adminToken, err := sessionRepo.Create(context.Background(), "user@test.com", "", "", "admin", "admin")
+		assert.NoError(t, err)
+
+		revoked, err := sessionRepo.RevokeUserRole(context.Background(), "User@test.com", "user")
+		assert.NoError(t, err)
+		assert.Len(t, revoked, 1)
+		assert.Equal(t, "admin", revoked[0].ProviderRole)
+
+		_, _, err = sessionRepo.Rotate(context.Background(), adminToken)
+		assert.ErrorIs(t, err, repos.ErrSessionNotFound)
+
+		_, _, err = sessionRepo.Rotate(context.Background(), userToken)
+		assert.NoError(t, err)
+	})
+}
+
+func TestSessionRepository_SetCipher(t *testing.T) {
+	t.Parallel()
+
+	newEncryptedSessionRepository := func(t *testing.T, db *sqlx.DB, userRepo *repos.UserRepository) *repos.SessionRepository {
+		t.Helper()
+
+		sessionRepo, err := repos.NewSessionRepository(db)
+		assert.NoError(t, err)
+
+		if userRepo != nil {
+			assert.NoError(t, sessionRepo.SetCipher(context.Background(), userRepo.Cipher()))
+		}
+
+		return sessionRepo
+	}
+
+	rawSessions := func(t *testing.T, db *sqlx.DB) string {
+		t.Helper()
+
+		var sessions []repos.Session
+		assert.NoError(t, db.Select(&sessions, "SELECT * FROM sessions"))
+
+		var raw strings.Builder
+		for _, session := range sessions {
+			raw.WriteString(session.Email + " " + session.SealedEmail + " " + session.Name + " " + session.Picture + "\n")
+		}
+
+		return raw.String()
+	}
+
+	t.Run("Stores emails, names and pictures encrypted", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		sessionRepo := newEncryptedSessionRepository(t, db, newEncryptedUserRepository(t, db))
+
+		created, refreshToken, err := sessionRepo.Create(context.Background(), "User@test.com", "Secret Name", "https://pictures.test.com/user", "user", "user")
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", created.Email)
+		assert.NotContains(t, rawSessions(t, db), "@test.com")
+		assert.NotContains(t, rawSessions(t, db), "Secret Name")
+		assert.NotContains(t, rawSessions(t, db), "pictures")
+
+		session, _, err := sessionRepo.Rotate(context.Background(), refreshToken)
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", session.Email)
+		assert.Equal(t, "Secret Name", session.Name)
+		assert.Equal(t, "https://pictures.test.com/user", session.Picture)
+
+		revoked, err := sessionRepo.RevokeUser(context.Background(), "USER@test.com")
+		assert.NoError(t, err)
+		assert.Len(t, revoked, 1)
+		assert.Equal(t, "user@test.com", revoked[0].Email)
+	})
+
+	t.Run("Encrypts sessions stored in clear text", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		_, refreshToken, _ := newEncryptedSessionRepository(t, db, nil).Create(context.Background(), "user@test.com", "User", "", "user", "user")
+		assert.Contains(t, rawSessions(t, db), "user@test.com")
+
+		sessionRepo := newEncryptedSessionRepository(t, db, newEncryptedUserRepository(t, db))
+		assert.NotContains(t, rawSessions(t, db), "@test.com")
+
+		session, _, err := sessionRepo.Rotate(context.Background(), refreshToken)
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", session.Email)
+		assert.Equal(t, "User", session.Name)
+	})
+
+	t.Run("Sessions remain readable after the data key rotation", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		userRepo := newEncryptedUserRepository(t, db)
+		sessionRepo := newEncryptedSessionRepository(t, db, userRepo)
+
+		_, refreshToken, _ := sessionRepo.Create(context.Background(), "user@test.com", "User", "", "user", "user")
+
+		assert.NoError(t, userRepo.RotateEncryptionKey(context.Background()))
+
+		session, _, err := sessionRepo.Rotate(context.Background(), refreshToken)
+		assert.NoError(t, err)
+		assert.Equal(t, "user@test.com", session.Email)
+
+		revoked, err := sessionRepo.RevokeUser(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Len(t, revoked, 1)
+	})
+}
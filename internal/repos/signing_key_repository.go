@@ -0,0 +1,211 @@
+package repos
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SigningKeyRepository keeps the key pairs signing access tokens. Private keys are sealed with a key derived
+// from the secret, a copy of the database alone cannot be used to forge tokens.
+type SigningKeyRepository struct {
+	db       *sqlx.DB
+	sealKey  cipher.AEAD
+	timeouts Timeouts
+}
+
+// SigningKey is a key pair, PrivateKey is its PKCS #8 DER encoding.
+// Keys are published once created, sign tokens from ActivatesAt and verify them until RetiresAt.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  []byte
+	CreatedAt   int64
+	ActivatesAt int64
+	RetiresAt   int64
+}
+
+type storedSigningKey struct {
+	ID          string `db:"id"`
+	Algorithm   string `db:"algorithm"`
+	SealedKey   string `db:"sealed_key"`
+	CreatedAt   int64  `db:"created_at"`
+	ActivatesAt int64  `db:"activates_at"`
+	RetiresAt   int64  `db:"retires_at"`
+}
+
+var ErrInvalidSigningKey = errors.New("invalid signing key")
+
+func NewSigningKeyRepository(db *sqlx.DB, secret string) (*SigningKeyRepository, error) {
+	if len(secret) < MasterSecretMinLen {
+		return nil, fmt.Errorf("%w: secret must be at least %d characters", ErrInvalidSigningKey, MasterSecretMinLen)
+	}
+
+	sum := sha256.Sum256([]byte("fringe signing keys " + secret))
+
+	sealKey, err := newAEAD(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createSigningKeyTable(db); err != nil {
+		return nil, err
+	}
+
+	return &SigningKeyRepository{
+		db:       db,
+		sealKey:  sealKey,
+		timeouts: Timeouts{Read: DefaultReadTimeout, Write: DefaultWriteTimeout},
+	}, nil
+}
+
+// SetTimeouts changes how long each read and write operation may take before failing with ErrTimeout.
+func (r *SigningKeyRepository) SetTimeouts(timeouts Timeouts) {
+	r.timeouts = timeouts
+}
+
+func createSigningKeyTable(db *sqlx.DB) error {
+	createTx := db.MustBegin()
+	defer func() { _ = createTx.Rollback() }()
+
+	createTx.MustExec("CREATE TABLE IF NOT EXISTS signing_keys (" +
+		"id string NOT NULL, " +
+		"algorithm string NOT NULL, " +
+		"sealed_key string NOT NULL, " +
+		"created_at int64 NOT NULL, " +
+		"activates_at int64, " +
+		"retires_at int64 NOT NULL)")
+	createTx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_id ON signing_keys (id)")
+
+	// Keys created before activation was delayed signed tokens as soon as they were created
+	if err := addMissingColumns(createTx, "signing_keys", []columnMigration{{name: "activates_at", columnType: "int64", defaultValue: int64(0)}}); err != nil {
+		return err
+	}
+
+	if err := createTx.Commit(); err != nil {
+		return fmt.Errorf("cannot create signing_keys table: %w", err)
+	}
+
+	return nil
+}
+
+// signingKeyAdditionalData binds a sealed private key to its id and algorithm so rows cannot be swapped.
+func signingKeyAdditionalData(id string, algorithm string) []byte {
+	return []byte("fringe signing key " + id + " " + algorithm)
+}
+
+// Create stores the key with its private key sealed.
+func (r *SigningKeyRepository) Create(ctx context.Context, key SigningKey) error {
+	nonce := make([]byte, r.sealKey.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("could not seal signing key %s: %w", key.ID, err)
+	}
+
+	sealed := r.sealKey.Seal(nonce, nonce, key.PrivateKey, signingKeyAdditionalData(key.ID, key.Algorithm))
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	createTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not store signing key %s: %w", key.ID, err)
+	}
+	defer func() { _ = createTx.Rollback() }() //nolint:wsl
+
+	_, err = createTx.ExecContext(ctx, "INSERT INTO signing_keys (id, algorithm, sealed_key, created_at, activates_at, retires_at) VALUES ($1,$2,$3,$4,$5,$6)",
+		key.ID, key.Algorithm, base64.StdEncoding.EncodeToString(sealed), key.CreatedAt, key.ActivatesAt, key.RetiresAt)
+	if err != nil {
+		return fmt.Errorf("could not store signing key %s: %w", key.ID, err)
+	}
+
+	if err := createTx.Commit(); err != nil {
+		return fmt.Errorf("could not store signing key %s: %w", key.ID, err)
+	}
+
+	return nil
+}
+
+// Active returns the keys not retired at now, oldest first.
+// Keys sealed with another secret are skipped, they are replaced at the next rotation.
+func (r *SigningKeyRepository) Active(ctx context.Context, now time.Time) ([]SigningKey, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var stored []storedSigningKey
+
+	err := r.db.SelectContext(ctx, &stored, "SELECT * FROM signing_keys WHERE retires_at > $1 ORDER BY created_at", now.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing keys: %w", err)
+	}
+
+	keys := make([]SigningKey, 0, len(stored))
+
+	for _, row := range stored {
+		privateKey, err := r.open(row)
+		if err != nil {
+			continue
+		}
+
+		keys = append(keys, SigningKey{
+			ID:          row.ID,
+			Algorithm:   row.Algorithm,
+			PrivateKey:  privateKey,
+			CreatedAt:   row.CreatedAt,
+			ActivatesAt: row.ActivatesAt,
+			RetiresAt:   row.RetiresAt,
+		})
+	}
+
+	return keys, nil
+}
+
+func (r *SigningKeyRepository) open(row storedSigningKey) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(row.SealedKey)
+	if err != nil || len(sealed) < r.sealKey.NonceSize() {
+		return nil, fmt.Errorf("%w: %s is malformed", ErrInvalidSigningKey, row.ID)
+	}
+
+	nonceSize := r.sealKey.NonceSize()
+
+	privateKey, err := r.sealKey.Open(nil, sealed[:nonceSize], sealed[nonceSize:], signingKeyAdditionalData(row.ID, row.Algorithm))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s cannot be opened", ErrInvalidSigningKey, row.ID)
+	}
+
+	return privateKey, nil
+}
+
+// Purge deletes the keys retired at now and returns how many were deleted.
+func (r *SigningKeyRepository) Purge(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	purgeTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not purge signing keys: %w", err)
+	}
+	defer func() { _ = purgeTx.Rollback() }() //nolint:wsl
+
+	result, err := purgeTx.ExecContext(ctx, "DELETE FROM signing_keys WHERE retires_at <= $1", now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("could not purge signing keys: %w", err)
+	}
+
+	if err := purgeTx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not purge signing keys: %w", err)
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not purge signing keys: %w", err)
+	}
+
+	return purged, nil
+}
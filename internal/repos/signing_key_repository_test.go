@@ -0,0 +1,78 @@
+package repos_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+const signingKeySecret = "a signing key secret of at least 32 characters"
+
+func TestNewSigningKeyRepository(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses short secrets", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := repos.NewSigningKeyRepository(mocks.NewMockDB(t), "short")
+		assert.ErrorIs(t, err, repos.ErrInvalidSigningKey)
+	})
+}
+
+func TestSigningKeyRepository_Active(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns the keys not retired, oldest first", func(t *testing.T) {
+		t.Parallel()
+
+		keyRepo, err := repos.NewSigningKeyRepository(mocks.NewMockDB(t), signingKeySecret)
+		assert.NoError(t, err)
+
+		now := time.Now()
+		keys := []repos.SigningKey{
+			{ID: "retired", Algorithm: "ES256", PrivateKey: []byte("retired key"), CreatedAt: now.Add(-3 * time.Hour).Unix(), RetiresAt: now.Add(-time.Hour).Unix()},
+			{ID: "newest", Algorithm: "EdDSA", PrivateKey: []byte("newest key"), CreatedAt: now.Unix(), ActivatesAt: now.Add(15 * time.Minute).Unix(), RetiresAt: now.Add(2 * time.Hour).Unix()},
+			{ID: "previous", Algorithm: "ES256", PrivateKey: []byte("previous key"), CreatedAt: now.Add(-time.Hour).Unix(), RetiresAt: now.Add(time.Hour).Unix()},
+		}
+
+		for _, key := range keys {
+			assert.NoError(t, keyRepo.Create(context.Background(), key))
+		}
+
+		active, err := keyRepo.Active(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, []repos.SigningKey{keys[2], keys[1]}, active)
+
+		purged, err := keyRepo.Purge(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+	})
+
+	t.Run("Stores private keys sealed and skips keys sealed with another secret", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		keyRepo, err := repos.NewSigningKeyRepository(db, signingKeySecret)
+		assert.NoError(t, err)
+
+		now := time.Now()
+		err = keyRepo.Create(context.Background(), repos.SigningKey{ID: "key", Algorithm: "ES256", PrivateKey: []byte("private key"), CreatedAt: now.Unix(), RetiresAt: now.Add(time.Hour).Unix()})
+		assert.NoError(t, err)
+
+		var sealed string
+		assert.NoError(t, db.Get(&sealed, "SELECT sealed_key FROM signing_keys"))
+		assert.False(t, strings.Contains(sealed, "private key"))
+
+		otherRepo, err := repos.NewSigningKeyRepository(db, strings.ToUpper(signingKeySecret))
+		assert.NoError(t, err)
+
+		active, err := otherRepo.Active(context.Background(), now)
+		assert.NoError(t, err)
+		assert.Empty(t, active)
+	})
+}
@@ -0,0 +1,408 @@
+package repos
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"modernc.org/ql"
+)
+
+// SnapshotRepository copies the whole database to a new ql file while the server keeps running.
+// The copy is made in a single transaction, writes wait for it to finish and the snapshot is consistent.
+type SnapshotRepository struct {
+	db *sqlx.DB
+}
+
+// Snapshot describes a database snapshot file and the number of rows of each of its tables.
+type Snapshot struct {
+	File      string           `json:"file"`
+	CreatedAt int64            `json:"created_at"`
+	Size      int64            `json:"size"`
+	SHA256    string           `json:"sha256"`
+	Tables    map[string]int64 `json:"tables"`
+}
+
+type snapshotColumn struct {
+	Ordinal int64  `db:"Ordinal"`
+	Name    string `db:"Name"`
+	Type    string `db:"Type"`
+}
+
+type snapshotIndex struct {
+	Name       string `db:"Name"`
+	TableName  string `db:"TableName"`
+	ColumnName string `db:"ColumnName"`
+	IsUnique   bool   `db:"IsUnique"`
+}
+
+const (
+	// SnapshotChecksumExt is appended to a snapshot file name to name the file holding its sha256 checksum.
+	SnapshotChecksumExt = ".sha256"
+	// SnapshotTimeFormat is the UTC timestamp format used in snapshot file names.
+	SnapshotTimeFormat = "20060102T150405Z"
+
+	snapshotFilePermission = 0o600
+)
+
+var (
+	ErrSnapshotExists   = errors.New("snapshot file already exists")
+	ErrSnapshotChecksum = errors.New("snapshot checksum does not match")
+	ErrSnapshotInvalid  = errors.New("snapshot is not a valid fringe database")
+	ErrDatabaseInUse    = errors.New("database is in use, fringe must be stopped")
+)
+
+// snapshotRequiredTables must be present in every snapshot.
+var snapshotRequiredTables = []string{"users", "audit_log"} //nolint:gochecknoglobals
+
+func NewSnapshotRepository(db *sqlx.DB) *SnapshotRepository {
+	return &SnapshotRepository{db: db}
+}
+
+// openSnapshotDB opens a ql file without leaving an empty write ahead log next to it once closed.
+func openSnapshotDB(file string) (*sqlx.DB, error) {
+	ql.RegisterDriver()
+
+	db, err := sqlx.Open("ql", "file://"+filepath.ToSlash(filepath.Clean(file))+"?removeemptywal=1")
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", file, err)
+	}
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("could not open %s: %w", file, err)
+	}
+
+	return db, nil
+}
+
+// Write copies every table and index of the database to a new file along with a checksum file.
+func (r *SnapshotRepository) Write(ctx context.Context, file string) (*Snapshot, error) {
+	if _, err := os.Stat(file); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotExists, file)
+	}
+
+	snapshotDB, err := openSnapshotDB(file)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := r.copyTo(ctx, snapshotDB)
+	if closeErr := snapshotDB.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("could not close snapshot %s: %w", file, closeErr)
+	}
+
+	if err != nil {
+		_ = os.Remove(file)
+
+		return nil, err
+	}
+
+	snapshot, err := describeSnapshot(file)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot.Tables = tables
+
+	checksum := snapshot.SHA256 + "  " + filepath.Base(file) + "\n"
+	if err := os.WriteFile(file+SnapshotChecksumExt, []byte(checksum), snapshotFilePermission); err != nil {
+		return nil, fmt.Errorf("could not write snapshot checksum: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+func (r *SnapshotRepository) copyTo(ctx context.Context, snapshotDB *sqlx.DB) (map[string]int64, error) {
+	// Reading inside a transaction keeps writers out until the copy is done
+	readTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not start snapshot: %w", err)
+	}
+	defer func() { _ = readTx.Rollback() }() //nolint:wsl
+
+	writeTx, err := snapshotDB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not start snapshot: %w", err)
+	}
+	defer func() { _ = writeTx.Rollback() }() //nolint:wsl
+
+	var schemas []struct {
+		Name   string `db:"Name"`
+		Schema string `db:"Schema"`
+	}
+
+	// Without ORDER BY ql may return the same __Table row several times
+	if err := readTx.SelectContext(ctx, &schemas, "SELECT Name, Schema FROM __Table ORDER BY Name"); err != nil {
+		return nil, fmt.Errorf("could not list tables: %w", err)
+	}
+
+	tables := map[string]int64{}
+
+	for _, table := range schemas {
+		// ql system tables are created with the database
+		if strings.HasPrefix(table.Name, "__") {
+			continue
+		}
+
+		if _, err := writeTx.ExecContext(ctx, table.Schema); err != nil {
+			return nil, fmt.Errorf("could not create table %s: %w", table.Name, err)
+		}
+
+		count, err := copyTableRows(ctx, readTx, writeTx, table.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		tables[table.Name] = count
+	}
+
+	var indexes []snapshotIndex
+	if err := readTx.SelectContext(ctx, &indexes, "SELECT Name, TableName, ColumnName, IsUnique FROM __Index ORDER BY Name"); err != nil {
+		return nil, fmt.Errorf("could not list indexes: %w", err)
+	}
+
+	for _, index := range indexes {
+		if strings.HasPrefix(index.TableName, "__") {
+			continue
+		}
+
+		create := "CREATE INDEX"
+		if index.IsUnique {
+			create = "CREATE UNIQUE INDEX"
+		}
+
+		if _, err := writeTx.ExecContext(ctx, fmt.Sprintf("%s %s ON %s (%s)", create, index.Name, index.TableName, index.ColumnName)); err != nil {
+			return nil, fmt.Errorf("could not create index %s: %w", index.Name, err)
+		}
+	}
+
+	if err := writeTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not write snapshot: %w", err)
+	}
+
+	return tables, nil
+}
+
+func copyTableRows(ctx context.Context, readTx *sqlx.Tx, writeTx *sqlx.Tx, table string) (int64, error) {
+	var columns []snapshotColumn
+	if err := readTx.SelectContext(ctx, &columns, "SELECT Ordinal, Name, Type FROM __Column WHERE TableName == $1 ORDER BY Ordinal", table); err != nil {
+		return 0, fmt.Errorf("could not list %s columns: %w", table, err)
+	}
+
+	names := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+
+	for index, column := range columns {
+		names[index] = column.Name
+		placeholders[index] = fmt.Sprintf("$%d", index+1)
+	}
+
+	rows, err := readTx.QueryxContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(names, ", "), table))
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), strings.Join(placeholders, ","))
+	count := int64(0)
+
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return count, fmt.Errorf("could not read %s: %w", table, err)
+		}
+
+		// Strings are scanned as bytes, they must be inserted back as strings
+		for index, column := range columns {
+			if bytes, isBytes := values[index].([]byte); isBytes && column.Type == "string" {
+				values[index] = string(bytes)
+			}
+		}
+
+		if _, err := writeTx.ExecContext(ctx, insert, values...); err != nil {
+			return count, fmt.Errorf("could not copy %s: %w", table, err)
+		}
+
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("could not read %s: %w", table, err)
+	}
+
+	return count, nil
+}
+
+// describeSnapshot returns the snapshot file size, modification time and checksum.
+func describeSnapshot(file string) (*Snapshot, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot: %w", err)
+	}
+
+	reader, err := os.Open(filepath.Clean(file))
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot: %w", err)
+	}
+	defer func() { _ = reader.Close() }() //nolint:wsl
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, fmt.Errorf("could not read snapshot: %w", err)
+	}
+
+	return &Snapshot{
+		File:      file,
+		CreatedAt: info.ModTime().Unix(),
+		Size:      info.Size(),
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		Tables:    map[string]int64{},
+	}, nil
+}
+
+// VerifySnapshot checks the snapshot checksum, when its checksum file exists, then opens it and checks its content.
+// Every required table must be present, users must be readable and the audit log hash chain, keyed with auditKey,
+// unbroken.
+func VerifySnapshot(ctx context.Context, file string, auditKey string) (*Snapshot, error) {
+	snapshot, err := describeSnapshot(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if checksum, err := os.ReadFile(filepath.Clean(file + SnapshotChecksumExt)); err == nil {
+		fields := strings.Fields(string(checksum))
+		if len(fields) == 0 || fields[0] != snapshot.SHA256 {
+			return nil, fmt.Errorf("%w: %s", ErrSnapshotChecksum, file)
+		}
+	}
+
+	snapshotDB, err := openSnapshotDB(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotInvalid, err)
+	}
+	defer func() { _ = snapshotDB.Close() }() //nolint:wsl
+
+	var tables []string
+	if err := snapshotDB.SelectContext(ctx, &tables, "SELECT Name FROM __Table ORDER BY Name"); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotInvalid, err)
+	}
+
+	for _, table := range tables {
+		if strings.HasPrefix(table, "__") {
+			continue
+		}
+
+		var count int64
+		if err := snapshotDB.GetContext(ctx, &count, fmt.Sprintf("SELECT count(*) FROM %s", table)); err != nil {
+			return nil, fmt.Errorf("%w: could not read %s: %v", ErrSnapshotInvalid, table, err)
+		}
+
+		snapshot.Tables[table] = count
+	}
+
+	for _, table := range snapshotRequiredTables {
+		if _, found := snapshot.Tables[table]; !found {
+			return nil, fmt.Errorf("%w: table %s is missing", ErrSnapshotInvalid, table)
+		}
+	}
+
+	var users []User
+	if err := snapshotDB.SelectContext(ctx, &users, "SELECT * FROM users"); err != nil {
+		return nil, fmt.Errorf("%w: could not read users: %v", ErrSnapshotInvalid, err)
+	}
+
+	auditRepo := AuditRepository{db: snapshotDB}
+	auditRepo.SetKey(auditKey)
+
+	if _, brokenAt, err := auditRepo.Verify(ctx); err != nil {
+		return nil, fmt.Errorf("%w: audit log broken at entry %d: %v", ErrSnapshotInvalid, brokenAt, err)
+	}
+
+	return snapshot, nil
+}
+
+// RestoreSnapshot verifies the snapshot, see VerifySnapshot, then replaces the database file with it.
+// The replaced database is kept next to it and its name is returned, it is empty when there was no database.
+func RestoreSnapshot(ctx context.Context, file string, databaseFile string, auditKey string) (*Snapshot, string, error) {
+	snapshot, err := VerifySnapshot(ctx, file, auditKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	previous := ""
+
+	if _, err := os.Stat(databaseFile); err == nil {
+		// Opening the database fails while another process uses it and replays its write ahead log otherwise
+		databaseDB, err := openSnapshotDB(databaseFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrDatabaseInUse, err)
+		}
+
+		if err := databaseDB.Close(); err != nil {
+			return nil, "", fmt.Errorf("could not close %s: %w", databaseFile, err)
+		}
+
+		previous = fmt.Sprintf("%s.before-restore-%s", databaseFile, time.Now().UTC().Format(SnapshotTimeFormat))
+	}
+
+	// The snapshot is copied next to the database so that replacing it is a rename
+	restoring := databaseFile + ".restoring"
+	if err := copyFile(file, restoring); err != nil {
+		return nil, "", err
+	}
+
+	if copied, err := describeSnapshot(restoring); err != nil || copied.SHA256 != snapshot.SHA256 {
+		_ = os.Remove(restoring)
+
+		return nil, "", fmt.Errorf("%w: copy of %s differs", ErrSnapshotChecksum, file)
+	}
+
+	if len(previous) > 0 {
+		if err := os.Rename(databaseFile, previous); err != nil {
+			_ = os.Remove(restoring)
+
+			return nil, "", fmt.Errorf("could not move %s aside: %w", databaseFile, err)
+		}
+	}
+
+	if err := os.Rename(restoring, databaseFile); err != nil {
+		return nil, previous, fmt.Errorf("could not replace %s: %w", databaseFile, err)
+	}
+
+	return snapshot, previous, nil
+}
+
+func copyFile(source string, destination string) error {
+	reader, err := os.Open(filepath.Clean(source))
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", source, err)
+	}
+	defer func() { _ = reader.Close() }() //nolint:wsl
+
+	writer, err := os.OpenFile(filepath.Clean(destination), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, snapshotFilePermission)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %w", destination, err)
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		_ = writer.Close()
+
+		return fmt.Errorf("could not copy %s: %w", source, err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("could not copy %s: %w", source, err)
+	}
+
+	return nil
+}
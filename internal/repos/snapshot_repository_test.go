@@ -0,0 +1,218 @@
+package repos_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+	"modernc.org/ql"
+)
+
+// createSnapshotSource returns a database with two users and an audit log entry.
+func createSnapshotSource(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db := mocks.NewMockDB(t)
+	userRepo, _ := repos.NewUserRepository(db)
+	auditRepo, _ := repos.NewAuditRepository(db)
+
+	_, err := userRepo.Create(context.Background(), "first@test.com", "First", "", "a-password")
+	assert.NoError(t, err)
+	_, err = userRepo.Create(context.Background(), "second@test.com", "Second", "", "a-password")
+	assert.NoError(t, err)
+	_, err = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "second@test.com", "", "success")
+	assert.NoError(t, err)
+
+	return db
+}
+
+func openDatabaseFile(t *testing.T, file string) *sqlx.DB {
+	t.Helper()
+
+	ql.RegisterDriver()
+
+	db, err := sqlx.Open("ql", file)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+func TestSnapshotRepository_Write(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Copies every table while the database is in use", func(t *testing.T) {
+		t.Parallel()
+
+		db := createSnapshotSource(t)
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+
+		snapshot, err := repos.NewSnapshotRepository(db).Write(context.Background(), file)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), snapshot.Tables["users"])
+		assert.Equal(t, int64(1), snapshot.Tables["audit_log"])
+		assert.NotEmpty(t, snapshot.SHA256)
+		assert.FileExists(t, file+repos.SnapshotChecksumExt)
+
+		// The source database remains usable
+		userRepo, _ := repos.NewUserRepository(db)
+		_, err = userRepo.Create(context.Background(), "third@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+
+		snapshotRepo, err := repos.NewUserRepository(openDatabaseFile(t, file))
+		assert.NoError(t, err)
+
+		user, err := snapshotRepo.FindByEmail(context.Background(), "first@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "First", user.Name)
+		assert.False(t, snapshotRepo.Exists(context.Background(), "third@test.com"))
+
+		// Unique indexes are part of the snapshot
+		_, err = snapshotRepo.Create(context.Background(), "first@test.com", "", "", "a-password")
+		assert.ErrorIs(t, err, repos.ErrUserAlreadyExist)
+	})
+
+	t.Run("Refuses to overwrite an existing file", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+		assert.NoError(t, os.WriteFile(file, []byte("existing"), 0o600))
+
+		_, err := repos.NewSnapshotRepository(createSnapshotSource(t)).Write(context.Background(), file)
+		assert.ErrorIs(t, err, repos.ErrSnapshotExists)
+	})
+}
+
+func TestVerifySnapshot(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Accepts a snapshot", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+		written, _ := repos.NewSnapshotRepository(createSnapshotSource(t)).Write(context.Background(), file)
+
+		snapshot, err := repos.VerifySnapshot(context.Background(), file, "")
+		assert.NoError(t, err)
+		assert.Equal(t, written.SHA256, snapshot.SHA256)
+		assert.Equal(t, written.Tables, snapshot.Tables)
+	})
+
+	t.Run("Refuses a snapshot not matching its checksum", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+		_, _ = repos.NewSnapshotRepository(createSnapshotSource(t)).Write(context.Background(), file)
+		assert.NoError(t, os.WriteFile(file+repos.SnapshotChecksumExt, []byte("0000  snapshot.db\n"), 0o600))
+
+		_, err := repos.VerifySnapshot(context.Background(), file, "")
+		assert.ErrorIs(t, err, repos.ErrSnapshotChecksum)
+	})
+
+	t.Run("Refuses files that are not fringe databases", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+		assert.NoError(t, os.WriteFile(file, []byte("not a database"), 0o600))
+
+		_, err := repos.VerifySnapshot(context.Background(), file, "")
+		assert.ErrorIs(t, err, repos.ErrSnapshotInvalid)
+	})
+
+	t.Run("Refuses a snapshot with a broken audit log", func(t *testing.T) {
+		t.Parallel()
+
+		db := createSnapshotSource(t)
+		tamperTx := db.MustBegin()
+		tamperTx.MustExec("UPDATE audit_log SET target = \"someone@test.com\"")
+		assert.NoError(t, tamperTx.Commit())
+
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+		_, err := repos.NewSnapshotRepository(db).Write(context.Background(), file)
+		assert.NoError(t, err)
+
+		_, err = repos.VerifySnapshot(context.Background(), file, "")
+		assert.ErrorIs(t, err, repos.ErrSnapshotInvalid)
+	})
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Replaces the database and keeps the previous one", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+		_, _ = repos.NewSnapshotRepository(createSnapshotSource(t)).Write(context.Background(), file)
+
+		// Database with other users, closed as it would be with fringe stopped
+		databaseFile := filepath.Join(t.TempDir(), "fringe.db")
+		liveDB := openDatabaseFile(t, databaseFile)
+		liveRepo, _ := repos.NewUserRepository(liveDB)
+		_, _ = liveRepo.Create(context.Background(), "live@test.com", "", "", "a-password")
+		assert.NoError(t, liveDB.Close())
+
+		snapshot, previous, err := repos.RestoreSnapshot(context.Background(), file, databaseFile, "")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), snapshot.Tables["users"])
+		assert.FileExists(t, previous)
+
+		restoredRepo, _ := repos.NewUserRepository(openDatabaseFile(t, databaseFile))
+		assert.True(t, restoredRepo.Exists(context.Background(), "first@test.com"))
+		assert.False(t, restoredRepo.Exists(context.Background(), "live@test.com"))
+
+		previousRepo, _ := repos.NewUserRepository(openDatabaseFile(t, previous))
+		assert.True(t, previousRepo.Exists(context.Background(), "live@test.com"))
+	})
+
+	t.Run("Restores a keyed audit log with its key", func(t *testing.T) {
+		t.Parallel()
+
+		db := createSnapshotSource(t)
+		auditRepo, _ := repos.NewAuditRepository(db)
+		_, err := auditRepo.Rekey(context.Background(), "audit-key")
+		assert.NoError(t, err)
+		_, err = auditRepo.Append(context.Background(), "admin@test.com", repos.AuditActionUserCreate, "first@test.com", "", "success")
+		assert.NoError(t, err)
+
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+		_, err = repos.NewSnapshotRepository(db).Write(context.Background(), file)
+		assert.NoError(t, err)
+
+		databaseFile := filepath.Join(t.TempDir(), "fringe.db")
+
+		_, _, err = repos.RestoreSnapshot(context.Background(), file, databaseFile, "another-key")
+		assert.ErrorIs(t, err, repos.ErrSnapshotInvalid)
+		assert.NoFileExists(t, databaseFile)
+
+		snapshot, _, err := repos.RestoreSnapshot(context.Background(), file, databaseFile, "audit-key")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), snapshot.Tables["audit_log"])
+
+		restoredRepo, _ := repos.NewAuditRepository(openDatabaseFile(t, databaseFile))
+		restoredRepo.SetKey("audit-key")
+		_, _, err = restoredRepo.Verify(context.Background())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Leaves the database untouched when the snapshot is invalid", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+		assert.NoError(t, os.WriteFile(file, []byte("not a database"), 0o600))
+
+		databaseFile := filepath.Join(t.TempDir(), "fringe.db")
+		assert.NoError(t, os.WriteFile(databaseFile, []byte("live"), 0o600))
+
+		_, _, err := repos.RestoreSnapshot(context.Background(), file, databaseFile, "")
+		assert.ErrorIs(t, err, repos.ErrSnapshotInvalid)
+
+		content, _ := os.ReadFile(databaseFile)
+		assert.Equal(t, "live", string(content))
+	})
+}
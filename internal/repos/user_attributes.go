@@ -0,0 +1,193 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	UserAttributesMax        = 32
+	UserAttributeValueMaxLen = 253 // Longest value a RADIUS attribute can carry
+)
+
+var ErrInvalidAttribute = errors.New("invalid user attribute")
+
+var userAttributeNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,62}$`)
+
+// NormalizeAttributes returns the attributes with lower case names, empty values are removed.
+// ErrInvalidAttribute is returned for malformed names, multi-line or too long values and too many attributes.
+func NormalizeAttributes(attributes map[string]string) (map[string]string, error) {
+	normalized := map[string]string{}
+
+	for name, value := range attributes {
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		if !userAttributeNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%w: malformed name '%s'", ErrInvalidAttribute, name)
+		}
+
+		if len(value) > UserAttributeValueMaxLen || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("%w: invalid value for '%s'", ErrInvalidAttribute, name)
+		}
+
+		if len(value) > 0 {
+			normalized[name] = value
+		}
+	}
+
+	if len(normalized) > UserAttributesMax {
+		return nil, fmt.Errorf("%w: more than %d attributes", ErrInvalidAttribute, UserAttributesMax)
+	}
+
+	return normalized, nil
+}
+
+// encodeAttributes returns the attributes column value, users without attributes store an empty string.
+func encodeAttributes(attributes map[string]string) (string, error) {
+	if len(attributes) == 0 {
+		return "", nil
+	}
+
+	encoded, err := json.Marshal(attributes)
+	if err != nil {
+		return "", fmt.Errorf("could not encode user attributes: %w", err)
+	}
+
+	return string(encoded), nil
+}
+
+// encodeAttributeValues returns the attribute_values column value, the values sorted by attribute name, one per
+// line. Values never hold control characters so a search cannot match across two of them.
+func encodeAttributeValues(attributes map[string]string) string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		values = append(values, attributes[name])
+	}
+
+	return strings.Join(values, "\n")
+}
+
+// decodeAttributes fills Attributes from the stored column value.
+func (u *User) decodeAttributes() error {
+	u.Attributes = map[string]string{}
+
+	if len(u.StoredAttributes) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(u.StoredAttributes), &u.Attributes); err != nil {
+		return fmt.Errorf("could not decode %s attributes: %w", u.Email, err)
+	}
+
+	return nil
+}
+
+// attributesMatch returns true if match accepts the value of one of the user attributes.
+func (u *User) attributesMatch(match func(string) bool) bool {
+	for _, value := range u.Attributes {
+		if match(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetAttributes replaces every custom attribute of the user, ErrUserNotFound is returned if no user was updated.
+func (r *UserRepository) SetAttributes(ctx context.Context, email string, attributes map[string]string) error {
+	defer r.holdDataKey()()
+
+	return r.setAttributes(ctx, email, attributes)
+}
+
+func (r *UserRepository) setAttributes(ctx context.Context, email string, attributes map[string]string) error {
+	email = CanonicalEmail(email)
+
+	normalized, err := NormalizeAttributes(attributes)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := encodeAttributes(normalized)
+	if err != nil {
+		return err
+	}
+
+	stored, err := r.sealField(r.lookupEmail(email), "attributes", encoded)
+	if err != nil {
+		return fmt.Errorf("could not update %s attributes: %w", email, err)
+	}
+
+	values := ""
+	if r.cipher == nil {
+		values = encodeAttributeValues(normalized)
+	}
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	updateTx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not update %s attributes: %w", email, err)
+	}
+	defer func() { _ = updateTx.Rollback() }() //nolint:wsl
+
+	result, err := updateTx.ExecContext(ctx, "UPDATE users SET attributes = $1, attribute_values = $2, profile_updated_at = $3 WHERE email == $4",
+		stored, values, time.Now().Unix(), r.lookupEmail(email))
+	if err != nil {
+		return fmt.Errorf("could not update %s attributes: %w", email, err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected != 1 {
+		return ErrUserNotFound
+	}
+
+	if err := updateTx.Commit(); err != nil {
+		return fmt.Errorf("could not update %s attributes: %w", email, err)
+	}
+
+	return nil
+}
+
+// SetAttribute sets a single custom attribute of the user, an empty value removes it.
+// The other attributes are kept, the user is not updated when the value is unchanged.
+func (r *UserRepository) SetAttribute(ctx context.Context, email string, name string, value string) error {
+	// The user is read and written under the same data key
+	defer r.holdDataKey()()
+
+	user, err := r.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	value = strings.TrimSpace(value)
+
+	if user.Attributes[name] == value {
+		return nil
+	}
+
+	attributes := make(map[string]string, len(user.Attributes)+1)
+	for existingName, existingValue := range user.Attributes {
+		attributes[existingName] = existingValue
+	}
+
+	attributes[name] = value
+
+	return r.setAttributes(ctx, email, attributes)
+}
@@ -0,0 +1,178 @@
+package repos_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeAttributes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Lower cases names and drops empty values", func(t *testing.T) {
+		t.Parallel()
+
+		attributes, err := repos.NormalizeAttributes(map[string]string{" Department ": " Sales ", "employee-id": ""})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"department": "Sales"}, attributes)
+	})
+
+	t.Run("Refuses malformed names and values", func(t *testing.T) {
+		t.Parallel()
+
+		for _, attributes := range []map[string]string{
+			{"has space": "value"},
+			{"": "value"},
+			{"department": "multi\nline"},
+			{"department": strings.Repeat("a", repos.UserAttributeValueMaxLen+1)},
+		} {
+			_, err := repos.NormalizeAttributes(attributes)
+			assert.ErrorIs(t, err, repos.ErrInvalidAttribute)
+		}
+	})
+}
+
+func TestUserRepository_SetAttributes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Replaces the user attributes", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+
+		assert.NoError(t, userRepo.SetAttributes(context.Background(), "user@test.com", map[string]string{"department": "Sales", "employee-id": "42"}))
+		assert.NoError(t, userRepo.SetAttributes(context.Background(), "user@test.com", map[string]string{"department": "Support"}))
+
+		user, err := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"department": "Support"}, user.Attributes)
+	})
+
+	t.Run("Users without attributes have an empty set", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+
+		user, err := userRepo.FindByEmail(context.Background(), "user@test.com")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.NotNil(t, user.Attributes)
+		assert.Empty(t, user.Attributes)
+	})
+
+	t.Run("Return not found on unknown users", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		err := userRepo.SetAttributes(context.Background(), "unknown@test.com", map[string]string{"department": "Sales"})
+		assert.ErrorIs(t, err, repos.ErrUserNotFound)
+	})
+
+	t.Run("Attributes are encrypted and searchable", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		userRepo := newEncryptedUserRepository(t, db)
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+
+		assert.NoError(t, userRepo.SetAttributes(context.Background(), "user@test.com", map[string]string{"department": "Accounting"}))
+		assert.NotContains(t, rawUsersTable(t, db), "Accounting")
+
+		users, err := userRepo.FindAllMatching(context.Background(), "Account", 0, 0)
+		assert.NoError(t, err)
+
+		if assert.Len(t, users, 1) {
+			assert.Equal(t, map[string]string{"department": "Accounting"}, users[0].Attributes)
+		}
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Search: "accounting"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), page.Total)
+	})
+}
+
+func TestUserRepository_SetAttribute(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Sets and removes a single attribute", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+		assert.NoError(t, userRepo.SetAttributes(context.Background(), "user@test.com", map[string]string{"department": "Sales"}))
+
+		assert.NoError(t, userRepo.SetAttribute(context.Background(), "user@test.com", "Radius-Group", "staff"))
+
+		user, err := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"department": "Sales", "radius-group": "staff"}, user.Attributes)
+
+		assert.NoError(t, userRepo.SetAttribute(context.Background(), "user@test.com", "radius-group", ""))
+
+		user, err = userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"department": "Sales"}, user.Attributes)
+	})
+
+	t.Run("Return not found on unknown users", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		assert.ErrorIs(t, userRepo.SetAttribute(context.Background(), "nobody@test.com", "radius-group", "staff"), repos.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_FindAllMatchingAttributes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Finds users by attribute value", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+		assert.NoError(t, userRepo.SetAttributes(context.Background(), "user@test.com", map[string]string{"employee-id": "E-1234"}))
+
+		users, err := userRepo.FindAllMatching(context.Background(), "E-1234", 0, 0)
+		assert.NoError(t, err)
+
+		if assert.Len(t, users, 1) {
+			assert.Equal(t, "user@test.com", users[0].Email)
+			assert.Equal(t, "E-1234", users[0].Attributes["employee-id"])
+		}
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Search: "e-1234"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), page.Total)
+	})
+	t.Run("Does not match attribute names or their encoding", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+		assert.NoError(t, userRepo.SetAttributes(context.Background(), "user@test.com", map[string]string{"department": "Sales", "site": "Paris"}))
+
+		for _, search := range []string{"department", `":"`, "Sales.*Paris"} {
+			_, err = userRepo.FindAllMatching(context.Background(), search, 0, 0)
+			assert.ErrorIs(t, err, repos.ErrUserNotFound, search)
+
+			page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Search: search})
+			assert.NoError(t, err)
+			assert.Zero(t, page.Total, search)
+		}
+	})
+}
@@ -0,0 +1,320 @@
+package repos
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/jmoiron/sqlx"
+)
+
+// Credential is an additional password of a user, usually one per device, that can be revoked on its own.
+// Its password starts with its ID so authenticating checks a single hash, however many credentials the user has.
+// When the user database is encrypted the email column holds the email blind index and names and hashes are encrypted.
+type Credential struct {
+	ID           string `db:"id" json:"id"`
+	Email        string `db:"email" json:"email"`
+	Name         string `db:"name" json:"name"`
+	PasswordHash string `db:"password" json:"-"`
+	CreatedAt    int64  `db:"created_at" json:"created_at"`
+	LastUsedAt   int64  `db:"last_used_at" json:"last_used_at"`
+	RevokedAt    int64  `db:"revoked_at" json:"revoked_at"`
+}
+
+var (
+	ErrCredentialNotFound  = errors.New("credential could not be found")
+	ErrInvalidCredential   = errors.New("invalid credential name")
+	ErrTooManyCredentials  = errors.New("user has too many active credentials")
+	errCredentialIDFailure = errors.New("could not generate credential id")
+)
+
+const (
+	// UserCredentialsMax bounds the active credentials of a user, each one is checked when authenticating.
+	UserCredentialsMax     = 10
+	UserCredentialNameMax  = 64
+	credentialIDByteLength = 8
+	// credentialSeparator follows the credential ID at the start of its password.
+	credentialSeparator = "."
+)
+
+func createCredentialTable(tx *sqlx.Tx) {
+	tx.MustExec("CREATE TABLE IF NOT EXISTS user_credentials (" +
+		"id string NOT NULL, " +
+		"email string NOT NULL, " +
+		"name string NOT NULL, " +
+		"password string NOT NULL, " +
+		"created_at int64 NOT NULL, " +
+		"last_used_at int64 NOT NULL, " +
+		"revoked_at int64 NOT NULL)")
+	tx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_user_credentials_id ON user_credentials (id)")
+}
+
+func newCredentialID() (string, error) {
+	id := make([]byte, credentialIDByteLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("%w: %v", errCredentialIDFailure, err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// IsActive returns true until the credential is revoked.
+func (c *Credential) IsActive() bool {
+	return c.RevokedAt == 0
+}
+
+// CreateCredential adds a named credential with a generated password to the user and returns it with its password,
+// which is not stored. The user keeps its other credentials.
+func (r *UserRepository) CreateCredential(ctx context.Context, email string, name string) (*Credential, string, error) {
+	email = CanonicalEmail(email)
+	name = strings.TrimSpace(name)
+
+	if len(name) == 0 || len(name) > UserCredentialNameMax || strings.ContainsAny(name, "\r\n\t") {
+		return nil, "", fmt.Errorf("%w: name must be a single line of 1 to %d characters", ErrInvalidCredential, UserCredentialNameMax)
+	}
+
+	id, err := newCredentialID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := GeneratePassword()
+	if err != nil {
+		return nil, "", err
+	}
+
+	password := id + credentialSeparator + secret
+
+	hash, err := r.createPasswordHash(password)
+	if err != nil {
+		return nil, "", err
+	}
+
+	credential := Credential{ID: id, Email: email, Name: name, PasswordHash: hash, CreatedAt: time.Now().Unix()}
+
+	defer r.holdDataKey()()
+
+	sealedName, err := r.sealField(credentialRow(r.lookupEmail(email), id), "credential_name", credential.Name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sealedHash, err := r.sealField(credentialRow(r.lookupEmail(email), id), "credential_password", credential.PasswordHash)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	createTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not add credential to %s: %w", email, err)
+	}
+	defer func() { _ = createTx.Rollback() }() //nolint:wsl
+
+	var users int64
+	if err := createTx.GetContext(ctx, &users, "SELECT count(*) FROM users WHERE email == $1", r.lookupEmail(email)); err != nil {
+		return nil, "", fmt.Errorf("could not add credential to %s: %w", email, err)
+	}
+
+	if users == 0 {
+		return nil, "", ErrUserNotFound
+	}
+
+	var active int64
+	if err := createTx.GetContext(ctx, &active, "SELECT count(*) FROM user_credentials WHERE email == $1 AND revoked_at == 0", r.lookupEmail(email)); err != nil {
+		return nil, "", fmt.Errorf("could not add credential to %s: %w", email, err)
+	}
+
+	if active >= UserCredentialsMax {
+		return nil, "", fmt.Errorf("%w: at most %d", ErrTooManyCredentials, UserCredentialsMax)
+	}
+
+	_, err = createTx.ExecContext(ctx, "INSERT INTO user_credentials (id, email, name, password, created_at, last_used_at, revoked_at) VALUES ($1,$2,$3,$4,$5,0,0)",
+		credential.ID, r.lookupEmail(email), sealedName, sealedHash, credential.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not add credential to %s: %w", email, err)
+	}
+
+	if err := createTx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("could not add credential to %s: %w", email, err)
+	}
+
+	return &credential, password, nil
+}
+
+// Credentials returns the credentials of the user, revoked ones included, oldest first.
+func (r *UserRepository) Credentials(ctx context.Context, email string) ([]Credential, error) {
+	email = CanonicalEmail(email)
+	credentials := []Credential{}
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	err := r.db.SelectContext(ctx, &credentials, "SELECT * FROM user_credentials WHERE email == $1 ORDER BY created_at", r.lookupEmail(email))
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve %s credentials: %w", email, err)
+	}
+
+	for index := range credentials {
+		if err := r.openCredential(&credentials[index], email); err != nil {
+			return nil, fmt.Errorf("could not retrieve %s credentials: %w", email, err)
+		}
+	}
+
+	return credentials, nil
+}
+
+// RevokeCredential stops the credential from authenticating, it remains listed with its revocation time.
+func (r *UserRepository) RevokeCredential(ctx context.Context, email string, id string) error {
+	email = CanonicalEmail(email)
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	revokeTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not revoke %s credential: %w", email, err)
+	}
+	defer func() { _ = revokeTx.Rollback() }() //nolint:wsl
+
+	result, err := revokeTx.ExecContext(ctx, "UPDATE user_credentials SET revoked_at = $1 WHERE id == $2 AND email == $3 AND revoked_at == 0",
+		time.Now().Unix(), id, r.lookupEmail(email))
+	if err != nil {
+		return fmt.Errorf("could not revoke %s credential: %w", email, err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrCredentialNotFound
+	}
+
+	if err := revokeTx.Commit(); err != nil {
+		return fmt.Errorf("could not revoke %s credential: %w", email, err)
+	}
+
+	return nil
+}
+
+// passwordCredential returns the active credential of the user whose ID starts the password, nil when there is none.
+func (r *UserRepository) passwordCredential(ctx context.Context, email string, password string) (*Credential, error) {
+	separator := strings.Index(password, credentialSeparator)
+	if separator != 2*credentialIDByteLength {
+		return nil, nil //nolint:nilnil
+	}
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	credentials := []Credential{}
+
+	err := r.db.SelectContext(ctx, &credentials, "SELECT * FROM user_credentials WHERE id == $1 AND email == $2 AND revoked_at == 0",
+		password[:separator], r.lookupEmail(email))
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve %s credential: %w", email, err)
+	}
+
+	if len(credentials) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	if err := r.openCredential(&credentials[0], email); err != nil {
+		return nil, fmt.Errorf("could not retrieve %s credential: %w", email, err)
+	}
+
+	return &credentials[0], nil
+}
+
+// matchCredential returns true when the password is the one of the credential.
+func matchCredential(credential *Credential, password string) bool {
+	valid, _ := argon2id.ComparePasswordAndHash(password, credential.PasswordHash)
+
+	return valid
+}
+
+// credentialUsed records that the credential authenticated the user.
+func (r *UserRepository) credentialUsed(ctx context.Context, credential *Credential) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	credential.LastUsedAt = time.Now().Unix()
+
+	updateTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not update credential last_used_at: %w", err)
+	}
+	defer func() { _ = updateTx.Rollback() }() //nolint:wsl
+
+	if _, err := updateTx.ExecContext(ctx, "UPDATE user_credentials SET last_used_at = $1 WHERE id == $2", credential.LastUsedAt, credential.ID); err != nil {
+		return fmt.Errorf("could not update credential last_used_at: %w", err)
+	}
+
+	if err := updateTx.Commit(); err != nil {
+		return fmt.Errorf("could not update credential last_used_at: %w", err)
+	}
+
+	return nil
+}
+
+// openCredential decrypts a credential read from the database and sets its clear text email.
+func (r *UserRepository) openCredential(credential *Credential, email string) error {
+	var err error
+
+	row := credentialRow(credential.Email, credential.ID)
+	credential.Email = email
+
+	if credential.Name, err = r.openField(row, "credential_name", credential.Name); err != nil {
+		return err
+	}
+
+	credential.PasswordHash, err = r.openField(row, "credential_password", credential.PasswordHash)
+
+	return err
+}
+
+// credentialRow identifies a credential in sealed values, credentials are bound to the user they are stored under.
+func credentialRow(storedEmail string, id string) string {
+	return storedEmail + "/" + id
+}
+
+// resealCredentials moves the credentials stored under storedEmail to lookupEmail, encrypted with key.
+func resealCredentials(ctx context.Context, tx *sqlx.Tx, cipher *FieldCipher, key *dataKey, storedEmail string, lookupEmail string) error {
+	var credentials []Credential
+	if err := tx.SelectContext(ctx, &credentials, "SELECT * FROM user_credentials WHERE email == $1", storedEmail); err != nil {
+		return fmt.Errorf("could not encrypt credentials: %w", err)
+	}
+
+	for _, credential := range credentials {
+		sealed := credential
+
+		for _, field := range []struct {
+			column string
+			target *string
+		}{
+			{column: "credential_name", target: &sealed.Name},
+			{column: "credential_password", target: &sealed.PasswordHash},
+		} {
+			value, err := cipher.open(credentialRow(storedEmail, credential.ID), field.column, *field.target)
+			if err != nil {
+				return err
+			}
+
+			if *field.target, err = cipher.seal(key, credentialRow(lookupEmail, credential.ID), field.column, value); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, "UPDATE user_credentials SET email = $1, name = $2, password = $3 WHERE id == $4",
+			lookupEmail, sealed.Name, sealed.PasswordHash, credential.ID)
+		if err != nil {
+			return fmt.Errorf("could not encrypt credential %s: %w", credential.ID, err)
+		}
+	}
+
+	return nil
+}
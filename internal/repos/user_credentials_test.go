@@ -0,0 +1,193 @@
+package repos_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRepository_CreateCredential(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Credential authenticates along with the main password", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		created, laptopPassword, err := userRepo.CreateCredential(context.Background(), "User@test.com", " Laptop ")
+		assert.NoError(t, err)
+		assert.Equal(t, "Laptop", created.Name)
+		assert.NotEmpty(t, created.ID)
+		assert.True(t, strings.HasPrefix(laptopPassword, created.ID+"."))
+
+		credential, authenticated, err := userRepo.AuthenticateCredential(context.Background(), "user@test.com", laptopPassword)
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+		assert.Equal(t, created.ID, credential.ID)
+
+		credential, authenticated, err = userRepo.AuthenticateCredential(context.Background(), "user@test.com", "a-password")
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+		assert.Nil(t, credential)
+
+		authenticated, err = userRepo.Authenticate(context.Background(), "user@test.com", "wrong-password")
+		assert.NoError(t, err)
+		assert.False(t, authenticated)
+
+		authenticated, err = userRepo.Authenticate(context.Background(), "user@test.com", created.ID+".wrong-password")
+		assert.NoError(t, err)
+		assert.False(t, authenticated)
+	})
+
+	t.Run("Credentials of other users do not authenticate", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		_, _ = userRepo.Create(context.Background(), "other@test.com", "", "", "a-password")
+
+		_, password, err := userRepo.CreateCredential(context.Background(), "user@test.com", "Phone")
+		assert.NoError(t, err)
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "other@test.com", password)
+		assert.NoError(t, err)
+		assert.False(t, authenticated)
+	})
+
+	t.Run("Records when the credential was used", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		_, _, _ = userRepo.CreateCredential(context.Background(), "user@test.com", "Phone")
+		_, laptopPassword, _ := userRepo.CreateCredential(context.Background(), "user@test.com", "Laptop")
+
+		_, _ = userRepo.Authenticate(context.Background(), "user@test.com", laptopPassword)
+
+		credentials, err := userRepo.Credentials(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Len(t, credentials, 2)
+
+		for _, credential := range credentials {
+			if credential.Name == "Laptop" {
+				assert.NotZero(t, credential.LastUsedAt)
+			} else {
+				assert.Zero(t, credential.LastUsedAt)
+			}
+		}
+	})
+
+	t.Run("Refuses unknown users and invalid names", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		_, _, err := userRepo.CreateCredential(context.Background(), "nobody@test.com", "Phone")
+		assert.ErrorIs(t, err, repos.ErrUserNotFound)
+
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		_, _, err = userRepo.CreateCredential(context.Background(), "user@test.com", "  ")
+		assert.ErrorIs(t, err, repos.ErrInvalidCredential)
+	})
+
+	t.Run("Limits active credentials", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		for i := 0; i < repos.UserCredentialsMax; i++ {
+			_, _, err := userRepo.CreateCredential(context.Background(), "user@test.com", "Device")
+			assert.NoError(t, err)
+		}
+
+		_, _, err := userRepo.CreateCredential(context.Background(), "user@test.com", "Device")
+		assert.ErrorIs(t, err, repos.ErrTooManyCredentials)
+	})
+}
+
+func TestUserRepository_RevokeCredential(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Revoked credentials no longer authenticate", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		credential, password, _ := userRepo.CreateCredential(context.Background(), "user@test.com", "Phone")
+
+		assert.NoError(t, userRepo.RevokeCredential(context.Background(), "user@test.com", credential.ID))
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "user@test.com", password)
+		assert.NoError(t, err)
+		assert.False(t, authenticated)
+
+		credentials, _ := userRepo.Credentials(context.Background(), "user@test.com")
+		assert.NotZero(t, credentials[0].RevokedAt)
+		assert.ErrorIs(t, userRepo.RevokeCredential(context.Background(), "user@test.com", credential.ID), repos.ErrCredentialNotFound)
+	})
+
+	t.Run("Users cannot revoke credentials of others", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		credential, _, _ := userRepo.CreateCredential(context.Background(), "user@test.com", "Phone")
+
+		err := userRepo.RevokeCredential(context.Background(), "other@test.com", credential.ID)
+		assert.ErrorIs(t, err, repos.ErrCredentialNotFound)
+	})
+
+	t.Run("Deleting the user deletes its credentials", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		_, _, _ = userRepo.CreateCredential(context.Background(), "user@test.com", "Phone")
+
+		assert.NoError(t, userRepo.Delete(context.Background(), "user@test.com"))
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		credentials, err := userRepo.Credentials(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Empty(t, credentials)
+	})
+}
+
+func TestUserRepository_CredentialEncryption(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Credentials are encrypted with the users and follow key rotation", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		clear, err := repos.NewUserRepository(db)
+		assert.NoError(t, err)
+		_, _ = clear.Create(context.Background(), "user@test.com", "", "", "a-password")
+		_, password, _ := clear.CreateCredential(context.Background(), "user@test.com", "Secret Phone")
+
+		encrypted := newEncryptedUserRepository(t, db)
+
+		var raw []repos.Credential
+		assert.NoError(t, db.Select(&raw, "SELECT * FROM user_credentials"))
+		assert.NotEqual(t, "user@test.com", raw[0].Email)
+		assert.NotContains(t, raw[0].Name, "Secret Phone")
+
+		authenticated, err := encrypted.Authenticate(context.Background(), "user@test.com", password)
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+
+		assert.NoError(t, encrypted.RotateEncryptionKey(context.Background()))
+
+		credentials, err := newEncryptedUserRepository(t, db).Credentials(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Len(t, credentials, 1)
+		assert.Equal(t, "Secret Phone", credentials[0].Name)
+	})
+}
@@ -0,0 +1,446 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// When the user database is encrypted the email column holds the email blind index, which keeps lookups and the
+// unique index working, while the email itself is stored encrypted in sealed_email.
+// Names, pictures and password hashes are encrypted in place.
+
+var (
+	ErrEncryptionDisabled = errors.New("user database encryption is not enabled")
+	ErrMissingCipher      = errors.New("user database is encrypted, its master key is required")
+)
+
+// storedUserQuery selects users along with their ql row id, ql cannot select id() along with *.
+const storedUserQuery = "SELECT id() AS row_id, email AS stored_email, email, name, picture, password, created_at, profile_updated_at, password_updated_at, " +
+	"last_seen_at, disabled_at, inactivity_warned_at, expires_at, sealed_email, attributes FROM users"
+
+// storedUser is a users row along with its ql row id and its email column as stored, its credentials are stored under it.
+type storedUser struct {
+	RowID       int64  `db:"row_id"`
+	StoredEmail string `db:"stored_email"`
+	User
+}
+
+// SetCipher encrypts users with cipher from now on, users stored in clear text are encrypted right away.
+func (r *UserRepository) SetCipher(ctx context.Context, cipher *FieldCipher) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	encryptTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not encrypt users: %w", err)
+	}
+	defer func() { _ = encryptTx.Rollback() }() //nolint:wsl
+
+	var rows []storedUser
+	if err := encryptTx.SelectContext(ctx, &rows, storedUserQuery+" WHERE sealed_email == \"\""); err != nil {
+		return fmt.Errorf("could not encrypt users: %w", err)
+	}
+
+	if err := resealUsers(ctx, encryptTx, cipher, cipher.active(), rows); err != nil {
+		return err
+	}
+
+	if err := encryptTx.Commit(); err != nil {
+		return fmt.Errorf("could not encrypt users: %w", err)
+	}
+
+	r.cipher = cipher
+
+	return nil
+}
+
+// Cipher returns the cipher encrypting users, nil when the user database is not encrypted.
+func (r *UserRepository) Cipher() *FieldCipher {
+	return r.cipher
+}
+
+// IsEncrypted returns true when at least one user is stored encrypted.
+func (r *UserRepository) IsEncrypted(ctx context.Context) (bool, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	var count int64
+	if err := r.db.GetContext(ctx, &count, "SELECT count(*) FROM users WHERE sealed_email != \"\""); err != nil {
+		return false, fmt.Errorf("could not count encrypted users: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// RotateEncryptionKey re-encrypts every user, along with ip leases and sessions, with a new data key and deletes the
+// previous data keys.
+// Everything happens in a single transaction, a failure leaves users encrypted with the previous key.
+// Writes encrypting values wait for the rotation to finish.
+func (r *UserRepository) RotateEncryptionKey(ctx context.Context) error {
+	if r.cipher == nil {
+		return ErrEncryptionDisabled
+	}
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	// Values sealed with the previous data key but not stored yet would be left unreadable
+	r.cipher.rotation.Lock()
+	defer r.cipher.rotation.Unlock()
+
+	rotateTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not rotate encryption key: %w", err)
+	}
+	defer func() { _ = rotateTx.Rollback() }() //nolint:wsl
+
+	key, err := r.cipher.newDataKey(rotateTx)
+	if err != nil {
+		return err
+	}
+
+	var rows []storedUser
+	if err := rotateTx.SelectContext(ctx, &rows, storedUserQuery); err != nil {
+		return fmt.Errorf("could not rotate encryption key: %w", err)
+	}
+
+	for index := range rows {
+		if err := r.openUser(&rows[index].User); err != nil {
+			return err
+		}
+	}
+
+	if err := resealUsers(ctx, rotateTx, r.cipher, key, rows); err != nil {
+		return err
+	}
+
+	if err := resealAllIPAddresses(ctx, rotateTx, r.cipher, key); err != nil {
+		return err
+	}
+
+	if err := resealAllSessions(ctx, rotateTx, r.cipher, key); err != nil {
+		return err
+	}
+
+	if _, err := rotateTx.ExecContext(ctx, "DELETE FROM encryption_keys WHERE id != $1", key.id); err != nil {
+		return fmt.Errorf("could not delete previous encryption keys: %w", err)
+	}
+
+	if err := rotateTx.Commit(); err != nil {
+		return fmt.Errorf("could not rotate encryption key: %w", err)
+	}
+
+	r.cipher.activate(key)
+
+	return nil
+}
+
+// resealUsers stores the clear text users, their credentials and one-time password seeds, encrypted with key.
+func resealUsers(ctx context.Context, tx *sqlx.Tx, cipher *FieldCipher, key *dataKey, rows []storedUser) error {
+	for _, row := range rows {
+		sealed, err := cipher.sealUser(key, row.User)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET email = $1, sealed_email = $2, name = $3, picture = $4, password = $5, attributes = $6, attribute_values = $7 WHERE id() == $8",
+			sealed.Email, sealed.SealedEmail, sealed.Name, sealed.Picture, sealed.PasswordHash, sealed.StoredAttributes, sealed.StoredAttributeValues, row.RowID)
+		if err != nil {
+			return fmt.Errorf("could not encrypt user %s: %w", row.Email, err)
+		}
+
+		if err := resealCredentials(ctx, tx, cipher, key, row.StoredEmail, sealed.Email); err != nil {
+			return err
+		}
+
+		if err := resealTOTP(ctx, tx, cipher, key, row.StoredEmail, sealed.Email); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sealUser returns the user as stored in an encrypted database.
+func (c *FieldCipher) sealUser(key *dataKey, user User) (User, error) {
+	var err error
+
+	email := CanonicalEmail(user.Email)
+	sealed := user
+	sealed.Email = c.blindIndex(key, email)
+	// Encrypted users are searched once opened, their attribute values are not kept in clear text
+	sealed.StoredAttributeValues = ""
+
+	for _, field := range []struct {
+		column string
+		value  string
+		target *string
+	}{
+		{column: "email", value: email, target: &sealed.SealedEmail},
+		{column: "name", value: user.Name, target: &sealed.Name},
+		{column: "picture", value: user.Picture, target: &sealed.Picture},
+		{column: "password", value: user.PasswordHash, target: &sealed.PasswordHash},
+		{column: "attributes", value: user.StoredAttributes, target: &sealed.StoredAttributes},
+	} {
+		if *field.target, err = c.seal(key, sealed.Email, field.column, field.value); err != nil {
+			return user, err
+		}
+	}
+
+	return sealed, nil
+}
+
+// openUser decrypts a user read from the database then decodes its attributes.
+func (r *UserRepository) openUser(user *User) error {
+	if len(user.SealedEmail) > 0 {
+		if err := r.openSealedUser(user); err != nil {
+			return err
+		}
+	}
+
+	return user.decodeAttributes()
+}
+
+// openSealedUser decrypts the encrypted columns of the user.
+func (r *UserRepository) openSealedUser(user *User) error {
+	if r.cipher == nil {
+		return ErrMissingCipher
+	}
+
+	var err error
+
+	// The email column holds the blind index until the sealed email replaces it
+	row := user.Email
+
+	for _, field := range []struct {
+		column string
+		value  string
+		target *string
+	}{
+		{column: "email", value: user.SealedEmail, target: &user.Email},
+		{column: "name", value: user.Name, target: &user.Name},
+		{column: "picture", value: user.Picture, target: &user.Picture},
+		{column: "password", value: user.PasswordHash, target: &user.PasswordHash},
+		{column: "attributes", value: user.StoredAttributes, target: &user.StoredAttributes},
+	} {
+		if *field.target, err = r.cipher.open(row, field.column, field.value); err != nil {
+			return err
+		}
+	}
+
+	user.SealedEmail = ""
+
+	return nil
+}
+
+func (r *UserRepository) openUsers(users []User) error {
+	for index := range users {
+		if err := r.openUser(&users[index]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sealUser returns the user as it must be stored, encrypted when the database is.
+func (r *UserRepository) sealUser(user User) (User, error) {
+	var err error
+
+	if user.StoredAttributes, err = encodeAttributes(user.Attributes); err != nil {
+		return user, err
+	}
+
+	user.StoredAttributeValues = encodeAttributeValues(user.Attributes)
+
+	if r.cipher == nil {
+		return user, nil
+	}
+
+	return r.cipher.sealUser(r.cipher.active(), user)
+}
+
+// holdDataKey keeps the active data key from being rotated until the returned function is called.
+// Writes hold it from sealing their values until they are committed.
+func (r *UserRepository) holdDataKey() func() {
+	if r.cipher == nil {
+		return func() {}
+	}
+
+	r.cipher.rotation.RLock()
+
+	return r.cipher.rotation.RUnlock
+}
+
+// sealField returns the column value of the row as it must be stored.
+func (r *UserRepository) sealField(row string, column string, value string) (string, error) {
+	if r.cipher == nil {
+		return value, nil
+	}
+
+	return r.cipher.seal(r.cipher.active(), row, column, value)
+}
+
+// openField decrypts a single column value of the row.
+func (r *UserRepository) openField(row string, column string, value string) (string, error) {
+	if r.cipher == nil {
+		if strings.HasPrefix(value, sealedPrefix) {
+			return "", ErrMissingCipher
+		}
+
+		return value, nil
+	}
+
+	return r.cipher.open(row, column, value)
+}
+
+// lookupEmail returns the value of the email column for the email.
+func (r *UserRepository) lookupEmail(email string) string {
+	email = CanonicalEmail(email)
+
+	if r.cipher == nil {
+		return email
+	}
+
+	return r.cipher.blindIndex(r.cipher.active(), email)
+}
+
+// allOpenUsers returns every user decrypted, encrypted columns cannot be searched or sorted in queries.
+func (r *UserRepository) allOpenUsers(ctx context.Context) ([]User, error) {
+	users := []User{}
+
+	if err := r.db.SelectContext(ctx, &users, "SELECT * FROM users"); err != nil {
+		return nil, fmt.Errorf("could not retrieve users: %w", err)
+	}
+
+	if err := r.openUsers(users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// compareUserCursors orders users by their sort value then by email.
+func compareUserCursors(first userCursor, second userCursor) int {
+	switch {
+	case first.Text != second.Text:
+		return strings.Compare(first.Text, second.Text)
+	case first.Number < second.Number:
+		return -1
+	case first.Number > second.Number:
+		return 1
+	default:
+		return strings.Compare(first.Email, second.Email)
+	}
+}
+
+// listOpenUsers is ListUsers for encrypted databases, users are filtered, sorted and paginated once decrypted.
+func (r *UserRepository) listOpenUsers(ctx context.Context, query UserListQuery, sortBy string, limit int) (*UserPage, error) {
+	var after *userCursor
+
+	if len(query.Cursor) > 0 {
+		cursor, err := decodeUserCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		if cursor.Sort != sortBy || cursor.Descending != query.Descending {
+			return nil, fmt.Errorf("%w: cursor was created for another sort order", ErrInvalidCursor)
+		}
+
+		after = cursor
+	}
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	users, err := r.allOpenUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	search := strings.ToLower(query.Search)
+	direction := 1
+
+	if query.Descending {
+		direction = -1
+	}
+
+	page := UserPage{Items: []User{}}
+	matching := []User{}
+
+	for _, user := range users {
+		switch {
+		case len(search) > 0 && !strings.Contains(strings.ToLower(user.Email), search) && !strings.Contains(strings.ToLower(user.Name), search) &&
+			!user.attributesMatch(func(text string) bool { return strings.Contains(strings.ToLower(text), search) }):
+			continue
+		case query.InactiveSince > 0 && user.LastSeenAt >= query.InactiveSince:
+			continue
+		case query.NeverSeen && user.LastSeenAt > user.CreatedAt:
+			continue
+		case query.ExpiringBefore > 0 && (user.ExpiresAt == 0 || user.ExpiresAt >= query.ExpiringBefore):
+			continue
+		}
+
+		page.Total++
+
+		if after == nil || direction*compareUserCursors(cursorForUser(user, sortBy, query.Descending), *after) > 0 {
+			matching = append(matching, user)
+		}
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		return direction*compareUserCursors(cursorForUser(matching[i], sortBy, query.Descending), cursorForUser(matching[j], sortBy, query.Descending)) < 0
+	})
+
+	page.Items = matching
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = encodeUserCursor(cursorForUser(page.Items[limit-1], sortBy, query.Descending))
+	}
+
+	return &page, nil
+}
+
+// findAllOpenMatching is FindAllMatching for encrypted databases.
+func (r *UserRepository) findAllOpenMatching(ctx context.Context, searchQuery string, limit int, offset int) ([]User, error) {
+	search, err := regexp.Compile(searchQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve users matching %s: %w", searchQuery, err)
+	}
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	users, err := r.allOpenUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []User{}
+
+	for _, user := range users {
+		if search.MatchString(user.Email) || search.MatchString(user.Name) || user.attributesMatch(search.MatchString) {
+			matching = append(matching, user)
+		}
+	}
+
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Email < matching[j].Email })
+
+	if offset >= len(matching) {
+		return nil, ErrUserNotFound
+	}
+
+	matching = matching[offset:]
+	if len(matching) > limit {
+		matching = matching[:limit]
+	}
+
+	return matching, nil
+}
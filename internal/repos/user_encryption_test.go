@@ -0,0 +1,334 @@
+package repos_test
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMasterSecret = "a-master-secret-long-enough-for-tests"
+
+func newEncryptedUserRepository(t *testing.T, db *sqlx.DB) *repos.UserRepository {
+	t.Helper()
+
+	userRepo, err := repos.NewUserRepository(db)
+	assert.NoError(t, err)
+
+	cipher, err := repos.NewFieldCipher(db, testMasterSecret)
+	assert.NoError(t, err)
+	assert.NoError(t, userRepo.SetCipher(context.Background(), cipher))
+
+	return userRepo
+}
+
+// rawUsersTable returns every value stored in the users table as text.
+func rawUsersTable(t *testing.T, db *sqlx.DB) string {
+	t.Helper()
+
+	var rows []repos.User
+	assert.NoError(t, db.Select(&rows, "SELECT * FROM users"))
+
+	var raw strings.Builder
+	for _, row := range rows {
+		raw.WriteString(row.Email + " " + row.SealedEmail + " " + row.Name + " " + row.Picture + " " + row.PasswordHash + " " + row.StoredAttributes + "\n")
+	}
+
+	return raw.String()
+}
+
+func TestNewFieldCipher(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Refuses short master secrets", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := repos.NewFieldCipher(mocks.NewMockDB(t), "short")
+		assert.ErrorIs(t, err, repos.ErrInvalidMasterKey)
+	})
+
+	t.Run("Refuses a master secret other than the one wrapping the data key", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		_ = newEncryptedUserRepository(t, db)
+
+		_, err := repos.NewFieldCipher(db, "another-master-secret-long-enough-for-tests")
+		assert.ErrorIs(t, err, repos.ErrInvalidMasterKey)
+	})
+}
+
+func TestUserRepository_Encryption(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Users are stored encrypted and read in clear text", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		userRepo := newEncryptedUserRepository(t, db)
+
+		_, err := userRepo.Create(context.Background(), "JDoe@Test.com", "John Doe", "https://test.com/jdoe.png", "a-password")
+		assert.NoError(t, err)
+
+		raw := rawUsersTable(t, db)
+		assert.NotContains(t, raw, "jdoe@test.com")
+		assert.NotContains(t, raw, "John Doe")
+		assert.NotContains(t, raw, "jdoe.png")
+		assert.NotContains(t, raw, "argon2id")
+
+		user, err := userRepo.FindByEmail(context.Background(), "jdoe@TEST.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "jdoe@test.com", user.Email)
+		assert.Equal(t, "John Doe", user.Name)
+		assert.Equal(t, "https://test.com/jdoe.png", user.Picture)
+		assert.Empty(t, user.SealedEmail)
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "jdoe@test.com", "a-password")
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+
+		_, err = userRepo.UpdateProfile(context.Background(), "jdoe@test.com", "Johnny Doe", "")
+		assert.NoError(t, err)
+		assert.NotContains(t, rawUsersTable(t, db), "Johnny")
+
+		user, _ = userRepo.FindByEmail(context.Background(), "jdoe@test.com")
+		assert.Equal(t, "Johnny Doe", user.Name)
+
+		_, err = userRepo.UpdatePassword(context.Background(), "jdoe@test.com", "new-password")
+		assert.NoError(t, err)
+
+		authenticated, _ = userRepo.Authenticate(context.Background(), "jdoe@test.com", "new-password")
+		assert.True(t, authenticated)
+
+		assert.NoError(t, userRepo.Delete(context.Background(), "jdoe@test.com"))
+		assert.False(t, userRepo.Exists(context.Background(), "jdoe@test.com"))
+	})
+
+	t.Run("Emails stay unique", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newEncryptedUserRepository(t, mocks.NewMockDB(t))
+
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+
+		_, err = userRepo.Create(context.Background(), "USER@test.com", "", "", "a-password")
+		assert.ErrorIs(t, err, repos.ErrUserAlreadyExist)
+	})
+
+	t.Run("Refuses values copied from another user", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		userRepo := newEncryptedUserRepository(t, db)
+
+		_, err := userRepo.Create(context.Background(), "admin@test.com", "", "", "admin-password")
+		assert.NoError(t, err)
+		_, err = userRepo.Create(context.Background(), "user@test.com", "", "", "user-password")
+		assert.NoError(t, err)
+
+		var rows []repos.User
+		assert.NoError(t, db.Select(&rows, "SELECT * FROM users"))
+		assert.Len(t, rows, 2)
+
+		// Someone able to write to the database swaps the password hashes of the users
+		swapTx := db.MustBegin()
+		swapTx.MustExec("UPDATE users SET password = $1 WHERE email == $2", rows[1].PasswordHash, rows[0].Email)
+		swapTx.MustExec("UPDATE users SET password = $1 WHERE email == $2", rows[0].PasswordHash, rows[1].Email)
+		assert.NoError(t, swapTx.Commit())
+
+		for _, email := range []string{"admin@test.com", "user@test.com"} {
+			for _, password := range []string{"admin-password", "user-password"} {
+				authenticated, err := userRepo.Authenticate(context.Background(), email, password)
+				assert.ErrorIs(t, err, repos.ErrInvalidSealed)
+				assert.False(t, authenticated)
+			}
+		}
+	})
+
+	t.Run("Users stored in clear text are encrypted when the cipher is set", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		clearRepo, _ := repos.NewUserRepository(db)
+		_, _ = clearRepo.Create(context.Background(), "user@test.com", "Clear Name", "", "a-password")
+
+		encrypted, err := clearRepo.IsEncrypted(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, encrypted)
+
+		userRepo := newEncryptedUserRepository(t, db)
+		assert.NotContains(t, rawUsersTable(t, db), "Clear Name")
+
+		encrypted, _ = userRepo.IsEncrypted(context.Background())
+		assert.True(t, encrypted)
+
+		user, err := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "Clear Name", user.Name)
+
+		// Without the cipher encrypted users cannot be read
+		_, err = clearRepo.FindByEmail(context.Background(), user.Email)
+		assert.ErrorIs(t, err, repos.ErrUserNotFound)
+
+		_, err = clearRepo.AllUsers(context.Background(), 0, 0)
+		assert.ErrorIs(t, err, repos.ErrMissingCipher)
+	})
+
+	t.Run("Lists, searches and sorts encrypted users", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newEncryptedUserRepository(t, mocks.NewMockDB(t))
+
+		for _, user := range []struct{ email, name string }{
+			{email: "charlie@test.com", name: "Alpha"},
+			{email: "alice@test.com", name: "Charlie"},
+			{email: "bob@test.com", name: "Bravo"},
+			{email: "dave@other.com", name: "Delta"},
+		} {
+			_, err := userRepo.Create(context.Background(), user.email, user.name, "", "a-password")
+			assert.NoError(t, err)
+		}
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Search: "TEST.com", Limit: 2})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), page.Total)
+		assert.Equal(t, []string{"alice@test.com", "bob@test.com"}, []string{page.Items[0].Email, page.Items[1].Email})
+
+		page, err = userRepo.ListUsers(context.Background(), repos.UserListQuery{Search: "test.com", Limit: 2, Cursor: page.NextCursor})
+		assert.NoError(t, err)
+		assert.Len(t, page.Items, 1)
+		assert.Equal(t, "charlie@test.com", page.Items[0].Email)
+		assert.Empty(t, page.NextCursor)
+
+		page, err = userRepo.ListUsers(context.Background(), repos.UserListQuery{Sort: repos.UserSortName, Descending: true})
+		assert.NoError(t, err)
+		assert.Equal(t, "Delta", page.Items[0].Name)
+		assert.Equal(t, "Alpha", page.Items[3].Name)
+
+		users, err := userRepo.FindAllMatching(context.Background(), "^b", 0, 0)
+		assert.NoError(t, err)
+		assert.Len(t, users, 1)
+		assert.Equal(t, "bob@test.com", users[0].Email)
+
+		var exported bytes.Buffer
+		assert.NoError(t, userRepo.ExportUsers(context.Background(), &exported, repos.TransferFormatCSV))
+		assert.Less(t, strings.Index(exported.String(), "alice@test.com"), strings.Index(exported.String(), "bob@test.com"))
+		assert.Contains(t, exported.String(), "Delta")
+	})
+
+	t.Run("Imports encrypted users", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		userRepo := newEncryptedUserRepository(t, db)
+		_, _ = userRepo.Create(context.Background(), "existing@test.com", "", "", "a-password")
+
+		report, err := userRepo.ImportUsers(context.Background(), []repos.UserImportRecord{
+			{User: repos.User{Email: "Existing@test.com"}, Password: "another-password"},
+			{User: repos.User{Email: "new@test.com", Name: "Imported Name"}, Password: "a-password"},
+		}, repos.ImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 1, report.Skipped)
+		assert.NotContains(t, rawUsersTable(t, db), "Imported Name")
+
+		authenticated, _ := userRepo.Authenticate(context.Background(), "new@test.com", "a-password")
+		assert.True(t, authenticated)
+	})
+
+	t.Run("Rehashes encrypted password hashes", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newEncryptedUserRepository(t, mocks.NewMockDB(t))
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		params, _ := repos.NewHashParams(16*1024, 2, 1)
+		userRepo.SetHashParams(params)
+
+		authenticated, _ := userRepo.Authenticate(context.Background(), "user@test.com", "a-password")
+		assert.True(t, authenticated)
+
+		assert.Eventually(t, func() bool {
+			user, err := userRepo.FindByEmail(context.Background(), "user@test.com")
+
+			return err == nil && !userRepo.NeedsRehash(user.PasswordHash)
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestUserRepository_RotateEncryptionKey(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Users are re-encrypted with the new data key", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		userRepo := newEncryptedUserRepository(t, db)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "A Name", "", "a-password")
+		before := rawUsersTable(t, db)
+
+		assert.NoError(t, userRepo.RotateEncryptionKey(context.Background()))
+		assert.NotEqual(t, before, rawUsersTable(t, db))
+
+		var keys int64
+		assert.NoError(t, db.Get(&keys, "SELECT count(*) FROM encryption_keys"))
+		assert.Equal(t, int64(1), keys)
+
+		user, err := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "A Name", user.Name)
+
+		// Opening the database again uses the new data key
+		reopened := newEncryptedUserRepository(t, db)
+		authenticated, err := reopened.Authenticate(context.Background(), "user@test.com", "a-password")
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+	})
+
+	t.Run("Values written during a rotation stay readable", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newEncryptedUserRepository(t, mocks.NewMockDB(t))
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		rotated := make(chan error)
+
+		go func() {
+			for i := 0; i < 5; i++ {
+				if err := userRepo.RotateEncryptionKey(context.Background()); err != nil {
+					rotated <- err
+
+					return
+				}
+			}
+
+			rotated <- nil
+		}()
+
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, userRepo.SetAttribute(context.Background(), "user@test.com", "step", strconv.Itoa(i)))
+		}
+
+		assert.NoError(t, <-rotated)
+
+		user, err := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "19", user.Attributes["step"])
+	})
+
+	t.Run("Refuses to rotate without encryption", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		assert.ErrorIs(t, userRepo.RotateEncryptionKey(context.Background()), repos.ErrEncryptionDisabled)
+	})
+}
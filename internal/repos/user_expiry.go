@@ -0,0 +1,14 @@
+package repos
+
+import (
+	"context"
+)
+
+// SetExpiry changes the Unix time at which the user stops authenticating, 0 removes the expiry.
+func (r *UserRepository) SetExpiry(ctx context.Context, email string, expiresAt int64) error {
+	if expiresAt < 0 {
+		return ErrInvalidExpiry
+	}
+
+	return r.updateUserTimestamp(ctx, email, "expires_at", expiresAt)
+}
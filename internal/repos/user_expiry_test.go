@@ -0,0 +1,119 @@
+package repos_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRepository_CreateExpiring(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Stores the expiry", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		expiresAt := time.Now().Add(time.Hour).Unix()
+
+		created, err := userRepo.CreateExpiring(context.Background(), "contractor@test.com", "", "", "a-password", expiresAt)
+		assert.NoError(t, err)
+		assert.Equal(t, expiresAt, created.ExpiresAt)
+
+		user, err := userRepo.FindByEmail(context.Background(), "contractor@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, expiresAt, user.ExpiresAt)
+	})
+
+	t.Run("Refuses negative expiry", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		_, err := userRepo.CreateExpiring(context.Background(), "contractor@test.com", "", "", "a-password", -1)
+		assert.ErrorIs(t, err, repos.ErrInvalidExpiry)
+	})
+}
+
+func TestUserRepository_SetExpiry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Expired users cannot authenticate", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.Create(context.Background(), "contractor@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+
+		assert.NoError(t, userRepo.SetExpiry(context.Background(), "contractor@test.com", time.Now().Add(-time.Minute).Unix()))
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "contractor@test.com", "a-password")
+		assert.ErrorIs(t, err, repos.ErrUserExpired)
+		assert.False(t, authenticated)
+	})
+
+	t.Run("Users authenticate until they expire", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.CreateExpiring(context.Background(), "contractor@test.com", "", "", "a-password", time.Now().Add(-time.Minute).Unix())
+		assert.NoError(t, err)
+
+		assert.NoError(t, userRepo.SetExpiry(context.Background(), "contractor@test.com", time.Now().Add(time.Hour).Unix()))
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "contractor@test.com", "a-password")
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+
+		// Removing the expiry keeps the account forever
+		assert.NoError(t, userRepo.SetExpiry(context.Background(), "contractor@test.com", 0))
+
+		user, _ := userRepo.FindByEmail(context.Background(), "contractor@test.com")
+		assert.Zero(t, user.ExpiresAt)
+		assert.False(t, user.IsExpired(time.Now()))
+	})
+
+	t.Run("Return not found on unknown users", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		assert.ErrorIs(t, userRepo.SetExpiry(context.Background(), "unknown@test.com", 1), repos.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_ListUsersExpiringBefore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Lists users expiring before the date, soonest first", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		now := time.Now()
+
+		for email, expiresAt := range map[string]int64{
+			"later@test.com":   now.Add(48 * time.Hour).Unix(),
+			"soon@test.com":    now.Add(time.Hour).Unix(),
+			"expired@test.com": now.Add(-time.Hour).Unix(),
+			"never@test.com":   0,
+		} {
+			_, err := userRepo.CreateExpiring(context.Background(), email, "", "", "a-password", expiresAt)
+			assert.NoError(t, err)
+		}
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{
+			ExpiringBefore: now.Add(24 * time.Hour).Unix(),
+			Sort:           repos.UserSortExpiresAt,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), page.Total)
+
+		if assert.Len(t, page.Items, 2) {
+			assert.Equal(t, "expired@test.com", page.Items[0].Email)
+			assert.Equal(t, "soon@test.com", page.Items[1].Email)
+		}
+	})
+}
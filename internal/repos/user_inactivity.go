@@ -0,0 +1,93 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FindInactiveSince returns every user not seen since the Unix time, least recently seen first.
+func (r *UserRepository) FindInactiveSince(ctx context.Context, since int64) ([]User, error) {
+	users := []User{}
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	if err := r.db.SelectContext(ctx, &users, "SELECT * FROM users WHERE last_seen_at < $1 ORDER BY last_seen_at", since); err != nil {
+		return nil, fmt.Errorf("could not retrieve users inactive since %d: %w", since, err)
+	}
+
+	if err := r.openUsers(users); err != nil {
+		return nil, fmt.Errorf("could not retrieve users inactive since %d: %w", since, err)
+	}
+
+	return users, nil
+}
+
+// MarkInactivityWarned records when the user was warned that the account will be reaped.
+func (r *UserRepository) MarkInactivityWarned(ctx context.Context, email string, warnedAt int64) error {
+	return r.updateUserTimestamp(ctx, email, "inactivity_warned_at", warnedAt)
+}
+
+// Disable prevents the user from authenticating, or logging in, until an admin enables the account again.
+func (r *UserRepository) Disable(ctx context.Context, email string) error {
+	return r.updateUserTimestamp(ctx, email, "disabled_at", time.Now().Unix())
+}
+
+// Enable lets a disabled user authenticate again. The inactivity warning is cleared so the user is warned again
+// before being reaped.
+func (r *UserRepository) Enable(ctx context.Context, email string) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	email = CanonicalEmail(email)
+
+	updateTx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not enable %s: %w", email, err)
+	}
+	defer func() { _ = updateTx.Rollback() }() //nolint:wsl
+
+	result, err := updateTx.ExecContext(ctx, "UPDATE users SET disabled_at = 0, inactivity_warned_at = 0 WHERE email == $1", r.lookupEmail(email))
+	if err != nil {
+		return fmt.Errorf("could not enable %s: %w", email, err)
+	}
+
+	if err := updateTx.Commit(); err != nil {
+		return fmt.Errorf("could not enable %s: %w", email, err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected != 1 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepository) updateUserTimestamp(ctx context.Context, email string, column string, value int64) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	email = CanonicalEmail(email)
+
+	updateTx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not update %s %s: %w", email, column, err)
+	}
+	defer func() { _ = updateTx.Rollback() }() //nolint:wsl
+
+	result, err := updateTx.ExecContext(ctx, fmt.Sprintf("UPDATE users SET %s = $1 WHERE email == $2", column), value, r.lookupEmail(email))
+	if err != nil {
+		return fmt.Errorf("could not update %s %s: %w", email, column, err)
+	}
+
+	if err := updateTx.Commit(); err != nil {
+		return fmt.Errorf("could not update %s %s: %w", email, column, err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected != 1 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package repos_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRepository_Disable(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Disabled users cannot authenticate", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+
+		assert.NoError(t, userRepo.Disable(context.Background(), "user@test.com"))
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "user@test.com", "a-password")
+		assert.ErrorIs(t, err, repos.ErrUserDisabled)
+		assert.False(t, authenticated)
+	})
+
+	t.Run("New password does not enable the user again", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		_ = userRepo.Disable(context.Background(), "user@test.com")
+
+		_, err := userRepo.UpdatePassword(context.Background(), "user@test.com", "new-password")
+		assert.NoError(t, err)
+
+		user, _ := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.NotZero(t, user.DisabledAt)
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "user@test.com", "new-password")
+		assert.ErrorIs(t, err, repos.ErrUserDisabled)
+		assert.False(t, authenticated)
+	})
+
+	t.Run("Return not found on unknown users", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		assert.ErrorIs(t, userRepo.Disable(context.Background(), "unknown@test.com"), repos.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_Enable(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Enabled users authenticate again", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		_ = userRepo.MarkInactivityWarned(context.Background(), "user@test.com", time.Now().Unix())
+		_ = userRepo.Disable(context.Background(), "user@test.com")
+
+		assert.NoError(t, userRepo.Enable(context.Background(), "user@test.com"))
+
+		user, _ := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.Zero(t, user.DisabledAt)
+		assert.Zero(t, user.InactivityWarnedAt)
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "user@test.com", "a-password")
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+	})
+
+	t.Run("Return not found on unknown users", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		assert.ErrorIs(t, userRepo.Enable(context.Background(), "unknown@test.com"), repos.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_FindInactiveSince(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Authenticating clears the inactivity warning", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		_ = userRepo.MarkInactivityWarned(context.Background(), "user@test.com", time.Now().Unix())
+
+		_, err := userRepo.Authenticate(context.Background(), "user@test.com", "a-password")
+		assert.NoError(t, err)
+
+		user, _ := userRepo.FindByEmail(context.Background(), "user@test.com")
+		assert.Zero(t, user.InactivityWarnedAt)
+	})
+
+	t.Run("Returns users not seen since the time", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo, _ := repos.NewUserRepository(mocks.NewMockDB(t))
+		_, _ = userRepo.ImportUsers(context.Background(), []repos.UserImportRecord{
+			{User: repos.User{Email: "old@test.com", PasswordHash: importedPasswordHash, LastSeenAt: 100}},
+			{User: repos.User{Email: "recent@test.com", PasswordHash: importedPasswordHash, LastSeenAt: 300}},
+		}, repos.ImportOptions{})
+
+		users, err := userRepo.FindInactiveSince(context.Background(), 200)
+		assert.NoError(t, err)
+		assert.Len(t, users, 1)
+		assert.Equal(t, "old@test.com", users[0].Email)
+	})
+}
+
+func TestNewUserRepository_Migrations(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Adds missing columns to existing tables", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+
+		// Users table as created by the first release
+		createTx := db.MustBegin()
+		createTx.MustExec("CREATE TABLE users (email string NOT NULL, password string NOT NULL, name string NOT NULL, picture string," +
+			"created_at int64, profile_updated_at int64, password_updated_at int64, last_seen_at int64)")
+		createTx.MustExec("INSERT INTO users (email, password, name, picture, created_at, profile_updated_at, password_updated_at, last_seen_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)",
+			"old@test.com", "hash", "Old User", "", int64(1), int64(1), int64(1), int64(1))
+		assert.NoError(t, createTx.Commit())
+
+		userRepo, err := repos.NewUserRepository(db)
+		assert.NoError(t, err)
+
+		user, err := userRepo.FindByEmail(context.Background(), "old@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "Old User", user.Name)
+		assert.Zero(t, user.DisabledAt)
+
+		// Opening again does not add the columns twice
+		_, err = repos.NewUserRepository(db)
+		assert.NoError(t, err)
+	})
+	t.Run("Fills the searched attribute values of existing users", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		userRepo, err := repos.NewUserRepository(db)
+		assert.NoError(t, err)
+
+		_, err = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		assert.NoError(t, err)
+		assert.NoError(t, userRepo.SetAttributes(context.Background(), "user@test.com", map[string]string{"department": "Accounting"}))
+
+		// Attributes stored before their values were searched separately
+		updateTx := db.MustBegin()
+		updateTx.MustExec(`UPDATE users SET attribute_values = ""`)
+		assert.NoError(t, updateTx.Commit())
+
+		userRepo, err = repos.NewUserRepository(db)
+		assert.NoError(t, err)
+
+		users, err := userRepo.FindAllMatching(context.Background(), "Accounting", 0, 0)
+		assert.NoError(t, err)
+		assert.Len(t, users, 1)
+	})
+
+	t.Run("Stores emails in canonical form with a unique index", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		createLegacyUsers(t, db, "jdoe@test.com", "Other@Test.com")
+
+		userRepo, err := repos.NewUserRepository(db)
+		assert.NoError(t, err)
+
+		user, err := userRepo.FindByEmail(context.Background(), "other@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "other@test.com", user.Email)
+
+		_, err = userRepo.Create(context.Background(), "OTHER@test.com", "", "", "a-password")
+		assert.ErrorIs(t, err, repos.ErrUserAlreadyExist)
+
+		// Opening again keeps the unique index
+		_, err = repos.NewUserRepository(db)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Reports users only differing by email case and keeps them", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+
+		// Users differing only by email case could be created before emails were unique
+		createLegacyUsers(t, db, "jdoe@test.com", "JDoe@test.com", "jdoe@test.com", "Other@Test.com")
+
+		_, err := repos.NewUserRepository(db)
+		assert.ErrorIs(t, err, repos.ErrDuplicateUsers)
+		assert.Contains(t, err.Error(), "JDoe@test.com")
+		assert.NotContains(t, err.Error(), "Other@Test.com")
+
+		var emails []string
+		assert.NoError(t, db.Select(&emails, "SELECT email FROM users"))
+		assert.ElementsMatch(t, []string{"jdoe@test.com", "JDoe@test.com", "jdoe@test.com", "Other@Test.com"}, emails)
+
+		// Once the duplicates are resolved the migration completes
+		deleteTx := db.MustBegin()
+		deleteTx.MustExec("DELETE FROM users WHERE email == $1", "jdoe@test.com")
+		assert.NoError(t, deleteTx.Commit())
+
+		userRepo, err := repos.NewUserRepository(db)
+		assert.NoError(t, err)
+
+		user, err := userRepo.FindByEmail(context.Background(), "jdoe@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "jdoe@test.com", user.Email)
+	})
+}
+
+// createLegacyUsers creates the users table as it was before emails were unique, with a user for each email.
+func createLegacyUsers(t *testing.T, db *sqlx.DB, emails ...string) {
+	t.Helper()
+
+	createTx := db.MustBegin()
+	createTx.MustExec("CREATE TABLE users (email string NOT NULL, password string NOT NULL, name string NOT NULL, picture string," +
+		"created_at int64, profile_updated_at int64, password_updated_at int64, last_seen_at int64)")
+	createTx.MustExec("CREATE INDEX idx_users_email ON users (email)")
+
+	for _, email := range emails {
+		createTx.MustExec("INSERT INTO users (email, password, name, picture, created_at, profile_updated_at, password_updated_at, last_seen_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)",
+			email, "hash", "", "", int64(1), int64(1), int64(1), int64(1))
+	}
+
+	assert.NoError(t, createTx.Commit())
+}
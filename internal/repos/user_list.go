@@ -0,0 +1,226 @@
+package repos
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	UserSortEmail             = "email"
+	UserSortName              = "name"
+	UserSortLastSeenAt        = "last_seen_at"
+	UserSortPasswordUpdatedAt = "password_updated_at"
+	UserSortCreatedAt         = "created_at"
+	UserSortExpiresAt         = "expires_at"
+)
+
+var (
+	ErrInvalidSort   = errors.New("invalid user sort")
+	ErrInvalidCursor = errors.New("invalid user list cursor")
+)
+
+// userSortIsTimestamp lists the sortable columns, timestamps sort most recent first by default.
+var userSortIsTimestamp = map[string]bool{ //nolint:gochecknoglobals
+	UserSortEmail:             false,
+	UserSortName:              false,
+	UserSortLastSeenAt:        true,
+	UserSortPasswordUpdatedAt: true,
+	UserSortCreatedAt:         true,
+	UserSortExpiresAt:         true,
+}
+
+// UserListQuery selects a page of users.
+// InactiveSince keeps users not seen since that Unix time and NeverSeen users not seen since their creation.
+// ExpiringBefore keeps users with an expiry set before that Unix time, including already expired users.
+// Cursor is the NextCursor of the previous page, it must be used with the same Sort and Descending values.
+type UserListQuery struct {
+	Search         string
+	Sort           string
+	Descending     bool
+	InactiveSince  int64
+	NeverSeen      bool
+	ExpiringBefore int64
+	Limit          int
+	Cursor         string
+}
+
+// UserPage holds a page of users, the total number of users matching the query and the cursor of the next page.
+// NextCursor is empty on the last page.
+type UserPage struct {
+	Items      []User
+	Total      int64
+	NextCursor string
+}
+
+// userCursor identifies the last user of a page by its sort value, email breaks ties.
+type userCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Text       string `json:"t,omitempty"`
+	Number     int64  `json:"n,omitempty"`
+	Email      string `json:"e"`
+}
+
+// DefaultDescending returns the natural order of a sort, most recent first for timestamps.
+func DefaultDescending(sort string) bool {
+	return userSortIsTimestamp[sort]
+}
+
+func encodeUserCursor(cursor userCursor) string {
+	encoded, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeUserCursor(encoded string) (*userCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	var cursor userCursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	return &cursor, nil
+}
+
+func cursorForUser(user User, sort string, descending bool) userCursor {
+	cursor := userCursor{Sort: sort, Descending: descending, Email: user.Email}
+
+	switch sort {
+	case UserSortName:
+		cursor.Text = user.Name
+	case UserSortLastSeenAt:
+		cursor.Number = user.LastSeenAt
+	case UserSortPasswordUpdatedAt:
+		cursor.Number = user.PasswordUpdatedAt
+	case UserSortCreatedAt:
+		cursor.Number = user.CreatedAt
+	case UserSortExpiresAt:
+		cursor.Number = user.ExpiresAt
+	}
+
+	return cursor
+}
+
+// ListUsers returns a page of users matching the query.
+// Passing 0 as the limit will use UserRepositoryListMaxLimit as the limit.
+func (r *UserRepository) ListUsers(ctx context.Context, query UserListQuery) (*UserPage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > UserRepositoryListMaxLimit {
+		limit = UserRepositoryListMaxLimit
+	}
+
+	sort := query.Sort
+	if len(sort) == 0 {
+		sort = UserSortEmail
+	}
+
+	if _, found := userSortIsTimestamp[sort]; !found {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSort, sort)
+	}
+
+	if r.cipher != nil {
+		return r.listOpenUsers(ctx, query, sort, limit)
+	}
+
+	conditions := []string{"true"}
+	args := []interface{}{}
+
+	addCondition := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			args = append(args, value)
+			placeholders = append(placeholders, len(args))
+		}
+
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+	}
+
+	if len(query.Search) > 0 {
+		search := "(?i)" + regexp.QuoteMeta(query.Search)
+		addCondition("(email LIKE $%d OR name LIKE $%d OR attribute_values LIKE $%d)", search, search, search)
+	}
+
+	if query.InactiveSince > 0 {
+		addCondition("last_seen_at < $%d", query.InactiveSince)
+	}
+
+	if query.NeverSeen {
+		conditions = append(conditions, "last_seen_at <= created_at")
+	}
+
+	if query.ExpiringBefore > 0 {
+		addCondition("(expires_at > 0 AND expires_at < $%d)", query.ExpiringBefore)
+	}
+
+	page := UserPage{Items: []User{}}
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	err := r.db.GetContext(ctx, &page.Total, fmt.Sprintf("SELECT count(*) FROM users WHERE %s", strings.Join(conditions, " AND ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not count users: %w", err)
+	}
+
+	if len(query.Cursor) > 0 {
+		cursor, err := decodeUserCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		if cursor.Sort != sort || cursor.Descending != query.Descending {
+			return nil, fmt.Errorf("%w: cursor was created for another sort order", ErrInvalidCursor)
+		}
+
+		operator := ">"
+		if query.Descending {
+			operator = "<"
+		}
+
+		switch {
+		case sort == UserSortEmail:
+			addCondition("email "+operator+" $%d", cursor.Email)
+		case sort == UserSortName:
+			addCondition("(name "+operator+" $%d OR (name == $%d AND email "+operator+" $%d))", cursor.Text, cursor.Text, cursor.Email)
+		default:
+			addCondition("("+sort+" "+operator+" $%d OR ("+sort+" == $%d AND email "+operator+" $%d))", cursor.Number, cursor.Number, cursor.Email)
+		}
+	}
+
+	orderBy := sort
+	if sort != UserSortEmail {
+		orderBy += ", email"
+	}
+
+	if query.Descending {
+		orderBy += " DESC"
+	}
+
+	// One more user than requested tells if there is a next page
+	args = append(args, limit+1)
+	selectQuery := fmt.Sprintf("SELECT * FROM users WHERE %s ORDER BY %s LIMIT $%d", strings.Join(conditions, " AND "), orderBy, len(args))
+
+	if err := r.db.SelectContext(ctx, &page.Items, selectQuery, args...); err != nil {
+		return nil, fmt.Errorf("could not retrieve users: %w", err)
+	}
+
+	if err := r.openUsers(page.Items); err != nil {
+		return nil, fmt.Errorf("could not retrieve users: %w", err)
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = encodeUserCursor(cursorForUser(page.Items[limit-1], sort, query.Descending))
+	}
+
+	return &page, nil
+}
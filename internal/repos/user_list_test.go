@@ -0,0 +1,194 @@
+package repos_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func newListUserRepository(t *testing.T, count int) *repos.UserRepository {
+	t.Helper()
+
+	userRepo, err := repos.NewUserRepository(mocks.NewMockDB(t))
+	if err != nil {
+		t.Fatalf("could not create repository: %v", err)
+	}
+
+	records := make([]repos.UserImportRecord, 0, count)
+	for index := 0; index < count; index++ {
+		records = append(records, repos.UserImportRecord{
+			User: repos.User{
+				Email: fmt.Sprintf("user%02d@test.com", index),
+				// Names repeat to exercise ties broken by email
+				Name:         fmt.Sprintf("Name %d", index%3),
+				PasswordHash: importedPasswordHash,
+				CreatedAt:    1000,
+				LastSeenAt:   int64(1000 + index*10),
+			},
+		})
+	}
+
+	if _, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{}); err != nil {
+		t.Fatalf("could not add users: %v", err)
+	}
+
+	return userRepo
+}
+
+func collectAllPages(t *testing.T, userRepo *repos.UserRepository, query repos.UserListQuery) []string {
+	t.Helper()
+
+	var emails []string
+
+	for pages := 0; pages < 100; pages++ {
+		page, err := userRepo.ListUsers(context.Background(), query)
+		assert.NoError(t, err)
+
+		for _, user := range page.Items {
+			emails = append(emails, user.Email)
+		}
+
+		if len(page.NextCursor) == 0 {
+			break
+		}
+
+		query.Cursor = page.NextCursor
+	}
+
+	return emails
+}
+
+func TestUserRepository_ListUsers(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns total and an empty page on empty database", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newListUserRepository(t, 0)
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{})
+		assert.NoError(t, err)
+		assert.NotNil(t, page.Items)
+		assert.Empty(t, page.Items)
+		assert.Equal(t, int64(0), page.Total)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("Pages through every user exactly once for each sort", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newListUserRepository(t, 11)
+		sorts := []string{repos.UserSortEmail, repos.UserSortName, repos.UserSortLastSeenAt, repos.UserSortPasswordUpdatedAt, repos.UserSortCreatedAt}
+
+		for _, sort := range sorts {
+			for _, descending := range []bool{false, true} {
+				emails := collectAllPages(t, userRepo, repos.UserListQuery{Sort: sort, Descending: descending, Limit: 4})
+
+				assert.Len(t, emails, 11, "sort %s descending %v", sort, descending)
+
+				unique := map[string]bool{}
+				for _, email := range emails {
+					unique[email] = true
+				}
+
+				assert.Len(t, unique, 11, "sort %s descending %v", sort, descending)
+			}
+		}
+	})
+
+	t.Run("Sorts by name then email", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newListUserRepository(t, 6)
+
+		emails := collectAllPages(t, userRepo, repos.UserListQuery{Sort: repos.UserSortName, Limit: 2})
+		assert.Equal(t, []string{
+			"user00@test.com", "user03@test.com",
+			"user01@test.com", "user04@test.com",
+			"user02@test.com", "user05@test.com",
+		}, emails)
+	})
+
+	t.Run("Sorts last seen most recent first when descending", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newListUserRepository(t, 3)
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Sort: repos.UserSortLastSeenAt, Descending: true})
+		assert.NoError(t, err)
+		assert.Equal(t, "user02@test.com", page.Items[0].Email)
+		assert.Equal(t, "user00@test.com", page.Items[2].Email)
+	})
+
+	t.Run("Filters inactive and never seen users", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newListUserRepository(t, 5)
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{InactiveSince: 1020})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), page.Total)
+
+		page, err = userRepo.ListUsers(context.Background(), repos.UserListQuery{NeverSeen: true})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), page.Total)
+		assert.Equal(t, "user00@test.com", page.Items[0].Email)
+	})
+
+	t.Run("Search ignores case and regular expression characters", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newListUserRepository(t, 5)
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Search: "USER01"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), page.Total)
+
+		page, err = userRepo.ListUsers(context.Background(), repos.UserListQuery{Search: ".*"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), page.Total)
+	})
+
+	t.Run("Total ignores the cursor and limit", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newListUserRepository(t, 5)
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Limit: 2})
+		assert.NoError(t, err)
+		assert.Len(t, page.Items, 2)
+		assert.Equal(t, int64(5), page.Total)
+
+		page, err = userRepo.ListUsers(context.Background(), repos.UserListQuery{Limit: 2, Cursor: page.NextCursor})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), page.Total)
+	})
+
+	t.Run("Refuses unknown sort", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newListUserRepository(t, 0)
+
+		_, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Sort: "password"})
+		assert.ErrorIs(t, err, repos.ErrInvalidSort)
+	})
+
+	t.Run("Refuses cursors from another sort", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := newListUserRepository(t, 5)
+
+		page, err := userRepo.ListUsers(context.Background(), repos.UserListQuery{Sort: repos.UserSortName, Limit: 2})
+		assert.NoError(t, err)
+
+		_, err = userRepo.ListUsers(context.Background(), repos.UserListQuery{Sort: repos.UserSortEmail, Limit: 2, Cursor: page.NextCursor})
+		assert.ErrorIs(t, err, repos.ErrInvalidCursor)
+
+		_, err = userRepo.ListUsers(context.Background(), repos.UserListQuery{Cursor: "not a cursor"})
+		assert.ErrorIs(t, err, repos.ErrInvalidCursor)
+	})
+}
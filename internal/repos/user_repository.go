@@ -1,30 +1,46 @@
 package repos
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/argon2id"
 	"github.com/jmoiron/sqlx"
+	"github.com/sethvargo/go-password/password"
 )
 
 // UserRepository stores and access data in a sqlite database.
 type UserRepository struct {
-	db *sqlx.DB
+	db         *sqlx.DB
+	hashParams *argon2id.Params
+	timeouts   Timeouts
+	cipher     *FieldCipher
 }
 
 type User struct {
-	Email             string `db:"email"`
-	Name              string `db:"name"`
-	Picture           string `db:"picture"`
-	PasswordHash      string `db:"password"`
-	CreatedAt         int64  `db:"created_at"`
-	ProfileUpdatedAt  int64  `db:"profile_updated_at"`
-	PasswordUpdatedAt int64  `db:"password_updated_at"`
-	LastSeenAt        int64  `db:"last_seen_at"`
+	Email              string `db:"email" json:"email"`
+	Name               string `db:"name" json:"name"`
+	Picture            string `db:"picture" json:"picture"`
+	PasswordHash       string `db:"password" json:"password_hash"`
+	CreatedAt          int64  `db:"created_at" json:"created_at"`
+	ProfileUpdatedAt   int64  `db:"profile_updated_at" json:"profile_updated_at"`
+	PasswordUpdatedAt  int64  `db:"password_updated_at" json:"password_updated_at"`
+	LastSeenAt         int64  `db:"last_seen_at" json:"last_seen_at"`
+	DisabledAt         int64  `db:"disabled_at" json:"disabled_at"`
+	InactivityWarnedAt int64  `db:"inactivity_warned_at" json:"inactivity_warned_at"`
+	ExpiresAt          int64  `db:"expires_at" json:"expires_at"`
+	SealedEmail        string `db:"sealed_email" json:"-"`
+
+	// Attributes are the custom attributes set by admins, they are stored encoded in StoredAttributes.
+	// StoredAttributeValues holds their values only, it is searched instead of the encoded attributes.
+	Attributes            map[string]string `db:"-" json:"attributes"`
+	StoredAttributes      string            `db:"attributes" json:"-"`
+	StoredAttributeValues string            `db:"attribute_values" json:"-"`
 }
 
 var (
@@ -32,6 +48,9 @@ var (
 	ErrUserAlreadyExist = errors.New("user with same email already exist in database")
 	ErrInvalidEmail     = errors.New("invalid user email field")
 	ErrInvalidPassword  = errors.New("invalid password")
+	ErrUserDisabled     = errors.New("user account is disabled")
+	ErrUserExpired      = errors.New("user account has expired")
+	ErrInvalidExpiry    = errors.New("invalid user expiry")
 )
 
 const (
@@ -39,13 +58,42 @@ const (
 	UserPasswordMinLen         = 6
 )
 
+const (
+	generatedPasswordLen          = 24
+	generatedPasswordNumOfDigits  = 2
+	generatedPasswordNumOfSymbols = 2
+)
+
+// userColumnMigrations lists the columns added to the users table since its first release.
+var userColumnMigrations = []columnMigration{ //nolint:gochecknoglobals
+	{name: "disabled_at", columnType: "int64", defaultValue: int64(0)},
+	{name: "inactivity_warned_at", columnType: "int64", defaultValue: int64(0)},
+	{name: "sealed_email", columnType: "string", defaultValue: ""},
+	{name: "expires_at", columnType: "int64", defaultValue: int64(0)},
+	{name: "attributes", columnType: "string", defaultValue: ""},
+	{name: "attribute_values", columnType: "string", defaultValue: ""},
+}
+
 // NewUserRepository returns a ready to use UserRepository with a new database connexion.
 func NewUserRepository(db *sqlx.DB) (*UserRepository, error) {
 	if err := createUserTable(db); err != nil {
 		return nil, err
 	}
 
-	return &UserRepository{db: db}, nil
+	return &UserRepository{db: db, timeouts: Timeouts{Read: DefaultReadTimeout, Write: DefaultWriteTimeout}}, nil
+}
+
+// SetTimeouts changes how long each read and write operation may take before failing with ErrTimeout.
+func (r *UserRepository) SetTimeouts(timeouts Timeouts) {
+	r.timeouts = timeouts
+}
+
+func (r *UserRepository) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, r.timeouts.Read)
+}
+
+func (r *UserRepository) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, r.timeouts.Write)
 }
 
 func createUserTable(db *sqlx.DB) error {
@@ -61,7 +109,22 @@ func createUserTable(db *sqlx.DB) error {
 		"profile_updated_at int64," +
 		"password_updated_at int64," +
 		"last_seen_at int64)")
-	createTx.MustExec("CREATE INDEX IF NOT EXISTS idx_users_email ON users (email)")
+	createTx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email)")
+
+	if err := addMissingColumns(createTx, "users", userColumnMigrations); err != nil {
+		return err
+	}
+
+	if err := migrateUniqueUserEmails(createTx); err != nil {
+		return err
+	}
+
+	if err := migrateAttributeValues(createTx); err != nil {
+		return err
+	}
+
+	createCredentialTable(createTx)
+	createTOTPTable(createTx)
 
 	if err := createTx.Commit(); err != nil {
 		return fmt.Errorf("cannot create users table: %w", err)
@@ -70,6 +133,18 @@ func createUserTable(db *sqlx.DB) error {
 	return nil
 }
 
+// CanonicalEmail returns the form in which emails are stored and looked up.
+// Email addresses are compared without case, JDoe@example.com and jdoe@example.com are the same user.
+func CanonicalEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// isUniqueViolation returns true when the error comes from inserting a value already present in a unique index.
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "cannot insert into unique index")
+}
+
+// CreatePasswordHash hashes the password using argon2id.DefaultParams.
 func CreatePasswordHash(password string) (string, error) {
 	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
 	if err != nil {
@@ -79,6 +154,34 @@ func CreatePasswordHash(password string) (string, error) {
 	return hash, nil
 }
 
+// GeneratePassword returns a random password suitable for a new user or a password renewal.
+func GeneratePassword() (string, error) {
+	pwd, err := password.Generate(generatedPasswordLen, generatedPasswordNumOfDigits, generatedPasswordNumOfSymbols, false, false)
+	if err != nil {
+		return "", fmt.Errorf("password generation failed: %w", err)
+	}
+
+	return pwd, nil
+}
+
+// IsExpired returns true once the account expiry, if any, is reached.
+func (u *User) IsExpired(now time.Time) bool {
+	return u.ExpiresAt != 0 && now.Unix() >= u.ExpiresAt
+}
+
+// CheckEnabled returns ErrUserDisabled for disabled users and ErrUserExpired once the account expiry is reached.
+func (u *User) CheckEnabled(now time.Time) error {
+	if u.DisabledAt != 0 {
+		return ErrUserDisabled
+	}
+
+	if u.IsExpired(now) {
+		return fmt.Errorf("%w on %s", ErrUserExpired, time.Unix(u.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
 func (u *User) PasswordMatch(password string) bool {
 	valid, err := argon2id.ComparePasswordAndHash(password, u.PasswordHash)
 	if err != nil {
@@ -91,10 +194,15 @@ func (u *User) PasswordMatch(password string) bool {
 }
 
 // FindByEmail Looks for a user record that matches the provided email and returns a pointer to User stuck if found or nil if not.
-func (r *UserRepository) FindByEmail(email string) (*User, error) {
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	email = CanonicalEmail(email)
+
 	var user User
 
-	if err := r.db.Get(&user, "SELECT * FROM users WHERE email == $1 LIMIT 1", email); err != nil {
+	if err := r.db.GetContext(ctx, &user, "SELECT * FROM users WHERE email == $1 LIMIT 1", r.lookupEmail(email)); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
@@ -102,12 +210,24 @@ func (r *UserRepository) FindByEmail(email string) (*User, error) {
 		return nil, fmt.Errorf("could not retrieve user %s: %w", email, err)
 	}
 
+	if err := r.openUser(&user); err != nil {
+		return nil, fmt.Errorf("could not retrieve user %s: %w", email, err)
+	}
+
 	return &user, nil
 }
 
 // Create INSERT a new user record with email and argon2id password hash from the provided password.
-// If the user already exists returns the record from the Database, otherwise return the newly created User.
-func (r *UserRepository) Create(email string, name string, picture string, password string) (*User, error) {
+// The email is stored in canonical form, ErrUserAlreadyExist is returned if a user with the same email exists.
+// The unique index on email makes concurrent creations of the same user fail instead of adding duplicates.
+func (r *UserRepository) Create(ctx context.Context, email string, name string, picture string, password string) (*User, error) {
+	return r.CreateExpiring(ctx, email, name, picture, password, 0)
+}
+
+// CreateExpiring is Create for accounts that stop authenticating at the expiresAt Unix time, 0 never expires.
+func (r *UserRepository) CreateExpiring(ctx context.Context, email string, name string, picture string, password string, expiresAt int64) (*User, error) {
+	email = CanonicalEmail(email)
+
 	if len(email) == 0 {
 		return nil, ErrInvalidEmail
 	}
@@ -116,12 +236,15 @@ func (r *UserRepository) Create(email string, name string, picture string, passw
 		return nil, ErrInvalidPassword
 	}
 
-	if r.Exists(email) {
-		return nil, ErrUserAlreadyExist
+	if expiresAt < 0 {
+		return nil, ErrInvalidExpiry
 	}
 
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
 	// Create User
-	hash, err := CreatePasswordHash(password)
+	hash, err := r.createPasswordHash(password)
 	if err != nil {
 		return nil, err
 	}
@@ -136,27 +259,44 @@ func (r *UserRepository) Create(email string, name string, picture string, passw
 		CreatedAt:         now.Unix(),
 		ProfileUpdatedAt:  now.Unix(),
 		PasswordUpdatedAt: now.Unix(),
+		ExpiresAt:         expiresAt,
+		Attributes:        map[string]string{},
 	}
 
-	insertTx, err := r.db.Begin()
+	defer r.holdDataKey()()
+
+	stored, err := r.sealUser(newUser)
+	if err != nil {
+		return nil, fmt.Errorf("could not create user %s: %w", email, err)
+	}
+
+	insertTx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not create user %s: %w", email, err)
 	}
 	defer func() { _ = insertTx.Rollback() }() //nolint:wsl
 
 	// Insert record in the database
-	insert, err := insertTx.Prepare("INSERT INTO users (email, name, picture, password, created_at, profile_updated_at, password_updated_at, last_seen_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)")
+	insert, err := insertTx.PrepareContext(ctx, "INSERT INTO users (email, name, picture, password, created_at, profile_updated_at, password_updated_at, last_seen_at, disabled_at, inactivity_warned_at, expires_at, sealed_email, attributes, attribute_values) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)")
 	if err != nil {
 		return nil, fmt.Errorf("could not create user %s: %w", email, err)
 	}
 	defer insert.Close()
 
-	_, err = insert.Exec(newUser.Email, newUser.Name, newUser.Picture, newUser.PasswordHash, newUser.CreatedAt, newUser.ProfileUpdatedAt, newUser.PasswordUpdatedAt, newUser.LastSeenAt)
+	_, err = insert.ExecContext(ctx, stored.Email, stored.Name, stored.Picture, stored.PasswordHash, stored.CreatedAt, stored.ProfileUpdatedAt, stored.PasswordUpdatedAt, stored.LastSeenAt, stored.DisabledAt, stored.InactivityWarnedAt, stored.ExpiresAt, stored.SealedEmail, stored.StoredAttributes, stored.StoredAttributeValues)
+	if isUniqueViolation(err) {
+		return nil, ErrUserAlreadyExist
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("could not create user %s: %w", email, err)
 	}
 
 	if err := insertTx.Commit(); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrUserAlreadyExist
+		}
+
 		return nil, fmt.Errorf("could not create user %s: %w", email, err)
 	}
 
@@ -165,17 +305,25 @@ func (r *UserRepository) Create(email string, name string, picture string, passw
 
 // UpdatePassword replaces the specified user's (found by email address) by the password provided.
 // The password is not stored as is. It is hashed with argon2id.
-func (r *UserRepository) UpdatePassword(email string, password string) (updated bool, err error) {
-	if !r.Exists(email) {
-		return false, ErrUserNotFound
-	}
+// A disabled account stays disabled, only Enable clears it. ErrUserNotFound is returned if no user was updated.
+func (r *UserRepository) UpdatePassword(ctx context.Context, email string, password string) (updated bool, err error) {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
 
-	hash, err := CreatePasswordHash(password)
+	email = CanonicalEmail(email)
+
+	hash, err := r.createPasswordHash(password)
 	if err != nil {
 		return false, err
 	}
 
-	updateTx, err := r.db.Begin()
+	defer r.holdDataKey()()
+
+	if hash, err = r.sealField(r.lookupEmail(email), "password", hash); err != nil {
+		return false, fmt.Errorf("could not update %s password: %w", email, err)
+	}
+
+	updateTx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return false, fmt.Errorf("could not update %s password: %w", email, err)
 	}
@@ -184,35 +332,51 @@ func (r *UserRepository) UpdatePassword(email string, password string) (updated
 	now := time.Now()
 
 	// Insert or update record in the database
-	stmt, err := updateTx.Prepare("UPDATE users SET password = $1, password_updated_at = $2 WHERE email == $3")
+	stmt, err := updateTx.PrepareContext(ctx, "UPDATE users SET password = $1, password_updated_at = $2, inactivity_warned_at = 0 WHERE email == $3")
 	if err != nil {
 		return false, fmt.Errorf("could not update %s password: %w", email, err)
 	}
 	defer stmt.Close()
 
-	result, err := stmt.Exec(hash, now.Unix(), email)
+	result, err := stmt.ExecContext(ctx, hash, now.Unix(), r.lookupEmail(email))
 	if err != nil {
 		return false, fmt.Errorf("could not update %s password: %w", email, err)
 	}
 
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected != 1 {
+		return false, ErrUserNotFound
+	}
+
 	err = updateTx.Commit()
 	if err != nil {
 		return false, fmt.Errorf("could not update %s password: %w", email, err)
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-
-	return rowsAffected >= 1, nil
+	return true, nil
 }
 
-func (r *UserRepository) UpdateProfile(email string, name string, picture string) (bool, error) {
+// UpdateProfile replaces the user name and picture, ErrUserNotFound is returned if no user was updated.
+func (r *UserRepository) UpdateProfile(ctx context.Context, email string, name string, picture string) (bool, error) {
 	log.Printf("Update Profile: Email:%s Name:%s Picture:%s", email, name, picture)
 
-	if !r.Exists(email) {
-		return false, ErrUserNotFound
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	email = CanonicalEmail(email)
+
+	defer r.holdDataKey()()
+
+	storedName, err := r.sealField(r.lookupEmail(email), "name", name)
+	if err != nil {
+		return false, fmt.Errorf("could not update %s profile information: %w", email, err)
 	}
 
-	updateTx, err := r.db.Begin()
+	storedPicture, err := r.sealField(r.lookupEmail(email), "picture", picture)
+	if err != nil {
+		return false, fmt.Errorf("could not update %s profile information: %w", email, err)
+	}
+
+	updateTx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return false, fmt.Errorf("could not update %s profile information: %w", email, err)
 	}
@@ -221,60 +385,57 @@ func (r *UserRepository) UpdateProfile(email string, name string, picture string
 	now := time.Now()
 
 	// Insert or update record in the database
-	stmt, err := updateTx.Prepare("UPDATE users SET name = '$1', picture = '$2', profile_updated_at = $3 WHERE email == $4")
+	stmt, err := updateTx.PrepareContext(ctx, "UPDATE users SET name = $1, picture = $2, profile_updated_at = $3 WHERE email == $4")
 	if err != nil {
 		return false, fmt.Errorf("could not update %s profile information: %w", email, err)
 	}
 	defer stmt.Close()
 
-	result, err := stmt.Exec(name, picture, now.Unix(), email)
+	result, err := stmt.ExecContext(ctx, storedName, storedPicture, now.Unix(), r.lookupEmail(email))
 	if err != nil {
 		return false, fmt.Errorf("could not update %s profile information: %w", email, err)
 	}
 
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected != 1 {
+		return false, ErrUserNotFound
+	}
+
 	err = updateTx.Commit()
 	if err != nil {
 		return false, fmt.Errorf("could not update %s profile information: %w", email, err)
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-
-	return rowsAffected >= 1, nil
+	return true, nil
 }
 
-func (r *UserRepository) Exists(email string) bool {
-	_, err := r.FindByEmail(email)
+func (r *UserRepository) Exists(ctx context.Context, email string) bool {
+	_, err := r.FindByEmail(ctx, email)
 
 	// if err == null then user is present
 	return err == nil
 }
 
-// Seen updates user's last_seen_at value with current Unix time.
-func (r *UserRepository) Seen(email string) error {
-	if !r.Exists(email) {
-		return ErrUserNotFound
-	}
+// Seen updates user's last_seen_at value with current Unix time and clears any inactivity warning.
+func (r *UserRepository) Seen(ctx context.Context, email string) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
 
+	email = CanonicalEmail(email)
 	now := time.Now()
 
-	updateTx, err := r.db.Begin()
+	updateTx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("could not update %s last_seen_at: %w", email, err)
 	}
 	defer func() { _ = updateTx.Rollback() }() //nolint:wsl
 
-	update, err := updateTx.Prepare("UPDATE users SET last_seen_at = $1 WHERE email == $2")
+	update, err := updateTx.PrepareContext(ctx, "UPDATE users SET last_seen_at = $1, inactivity_warned_at = 0 WHERE email == $2")
 	if err != nil {
 		return fmt.Errorf("could not update %s last_seen_at: %w", email, err)
 	}
 	defer update.Close()
 
-	result, err := update.Exec(now.Unix(), email)
-	if err != nil {
-		return fmt.Errorf("could not update %s last_seen_at: %w", email, err)
-	}
-
-	err = updateTx.Commit()
+	result, err := update.ExecContext(ctx, now.Unix(), r.lookupEmail(email))
 	if err != nil {
 		return fmt.Errorf("could not update %s last_seen_at: %w", email, err)
 	}
@@ -288,33 +449,76 @@ func (r *UserRepository) Seen(email string) error {
 		return ErrUserNotFound
 	}
 
+	err = updateTx.Commit()
+	if err != nil {
+		return fmt.Errorf("could not update %s last_seen_at: %w", email, err)
+	}
+
 	return nil
 }
 
 // Authenticate validates if the email and password combination matches an existing user
-// in the database with a password resulting in the same password hash.
-// Updates last_seen_at if user is authenticated.
-func (r *UserRepository) Authenticate(email string, password string) (bool, error) {
-	user, err := r.FindByEmail(email)
+// in the database with a password resulting in the same password hash, or one of its active credentials.
+// Disabled users are refused with ErrUserDisabled and users past their expiry with ErrUserExpired.
+// Updates last_seen_at if user is authenticated and upgrades, in the background, hashes using older parameters.
+func (r *UserRepository) Authenticate(ctx context.Context, email string, password string) (bool, error) {
+	_, authenticated, err := r.AuthenticateCredential(ctx, email, password)
+
+	return authenticated, err
+}
+
+// AuthenticateCredential works as Authenticate and also returns the credential that matched the password,
+// nil when the user authenticated with its main password. The credential last_used_at is updated.
+func (r *UserRepository) AuthenticateCredential(ctx context.Context, email string, password string) (*Credential, bool, error) {
+	email = CanonicalEmail(email)
+
+	user, err := r.FindByEmail(ctx, email)
 	if err != nil {
-		return false, err
+		return nil, false, err
+	}
+
+	if err := user.CheckEnabled(time.Now()); err != nil {
+		return nil, false, err
+	}
+
+	// A single password hash is checked, the credential named by the password or else the main password
+	credential, err := r.passwordCredential(ctx, email, password)
+	if err != nil {
+		return nil, false, err
 	}
 
-	authenticated := user.PasswordMatch(password)
-	if authenticated {
-		err = r.Seen(email)
-		if err != nil {
-			return false, err
+	var authenticated bool
+
+	if credential != nil {
+		authenticated = matchCredential(credential, password)
+	} else {
+		authenticated = user.PasswordMatch(password)
+	}
+
+	if !authenticated {
+		return nil, false, nil
+	}
+
+	if err := r.Seen(ctx, email); err != nil {
+		return nil, false, err
+	}
+
+	if credential != nil {
+		if err := r.credentialUsed(ctx, credential); err != nil {
+			return nil, false, err
 		}
+	} else if r.NeedsRehash(user.PasswordHash) {
+		// The request may be over before the rehash is done, it must not be canceled with it
+		go r.rehash(context.Background(), email, password, user.PasswordHash)
 	}
 
-	return authenticated, nil
+	return credential, true, nil
 }
 
 // AllUsers Return list of users sorted by email.
 // Passing 0 as the limit will use UserRepositoryListMaxLimit as the limit.
 // Page 0 and 1 are seen as the same page number essentially: `offset = (page - 1) * limit`.
-func (r *UserRepository) AllUsers(limit int, page int) ([]User, error) {
+func (r *UserRepository) AllUsers(ctx context.Context, limit int, page int) ([]User, error) {
 	offset := 0
 
 	if limit == 0 || limit > UserRepositoryListMaxLimit {
@@ -327,7 +531,10 @@ func (r *UserRepository) AllUsers(limit int, page int) ([]User, error) {
 
 	var users []User
 
-	err := r.db.Select(&users, "SELECT * FROM users ORDER BY last_seen_at DESC LIMIT $1 OFFSET $2 ", limit, offset)
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	err := r.db.SelectContext(ctx, &users, "SELECT * FROM users ORDER BY last_seen_at DESC LIMIT $1 OFFSET $2 ", limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve users (limit: %d offset:%d) %w", limit, offset, err)
 	}
@@ -336,10 +543,17 @@ func (r *UserRepository) AllUsers(limit int, page int) ([]User, error) {
 		return nil, ErrUserNotFound
 	}
 
+	if err := r.openUsers(users); err != nil {
+		return nil, fmt.Errorf("could not retrieve users (limit: %d offset:%d) %w", limit, offset, err)
+	}
+
 	return users, nil
 }
 
-func (r *UserRepository) FindAllMatching(searchQuery string, limit int, page int) ([]User, error) {
+// FindAllMatching returns users whose email, name or custom attribute values contain searchQuery.
+// Values are matched with LIKE on the email, name and attribute_values columns, ql evaluates LIKE as an unanchored
+// regular expression so plain text matches as a substring anywhere in the value.
+func (r *UserRepository) FindAllMatching(ctx context.Context, searchQuery string, limit int, page int) ([]User, error) {
 	offset := 0
 
 	if limit == 0 || limit > UserRepositoryListMaxLimit {
@@ -350,9 +564,16 @@ func (r *UserRepository) FindAllMatching(searchQuery string, limit int, page int
 		offset = (page - 1) * limit
 	}
 
+	if r.cipher != nil {
+		return r.findAllOpenMatching(ctx, searchQuery, limit, offset)
+	}
+
 	var users []User
 
-	err := r.db.Select(&users, "SELECT * FROM users WHERE (email LIKE $1 OR name LIKE $1) ORDER BY email LIMIT $2 OFFSET $3 ", searchQuery, limit, offset)
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	err := r.db.SelectContext(ctx, &users, "SELECT * FROM users WHERE (email LIKE $1 OR name LIKE $1 OR attribute_values LIKE $1) ORDER BY email LIMIT $2 OFFSET $3 ", searchQuery, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve users (limit: %d offset:%d) %w", limit, offset, err)
 	}
@@ -361,33 +582,33 @@ func (r *UserRepository) FindAllMatching(searchQuery string, limit int, page int
 		return nil, ErrUserNotFound
 	}
 
+	if err := r.openUsers(users); err != nil {
+		return nil, fmt.Errorf("could not retrieve users (limit: %d offset:%d) %w", limit, offset, err)
+	}
+
 	return users, nil
 }
 
 // Delete delete user record for given email.
-func (r *UserRepository) Delete(email string) error {
-	if !r.Exists(email) {
-		return ErrUserNotFound
-	}
+func (r *UserRepository) Delete(ctx context.Context, email string) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
 
-	delTx, err := r.db.Begin()
+	email = CanonicalEmail(email)
+
+	delTx, err := r.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("could not delte %s: %w", email, err)
 	}
 	defer func() { _ = delTx.Rollback() }() //nolint:wsl
 
-	delStmt, err := delTx.Prepare("DELETE FROM users WHERE email == $1")
+	delStmt, err := delTx.PrepareContext(ctx, "DELETE FROM users WHERE email == $1")
 	if err != nil {
 		return fmt.Errorf("could not delete %s: %w ", email, err)
 	}
 	defer delStmt.Close()
 
-	result, err := delStmt.Exec(email)
-	if err != nil {
-		return fmt.Errorf("could not delete %s: %w", email, err)
-	}
-
-	err = delTx.Commit()
+	result, err := delStmt.ExecContext(ctx, r.lookupEmail(email))
 	if err != nil {
 		return fmt.Errorf("could not delete %s: %w", email, err)
 	}
@@ -401,5 +622,22 @@ func (r *UserRepository) Delete(email string) error {
 		return ErrUserNotFound
 	}
 
+	if _, err := delTx.ExecContext(ctx, "DELETE FROM user_credentials WHERE email == $1", r.lookupEmail(email)); err != nil {
+		return fmt.Errorf("could not delete %s credentials: %w", email, err)
+	}
+
+	if _, err := delTx.ExecContext(ctx, "DELETE FROM user_totp WHERE email == $1", r.lookupEmail(email)); err != nil {
+		return fmt.Errorf("could not delete %s one-time password: %w", email, err)
+	}
+
+	if err := releaseIPAddresses(ctx, delTx, r.lookupEmail(email)); err != nil {
+		return fmt.Errorf("could not release %s addresses: %w", email, err)
+	}
+
+	err = delTx.Commit()
+	if err != nil {
+		return fmt.Errorf("could not delete %s: %w", email, err)
+	}
+
 	return nil
 }
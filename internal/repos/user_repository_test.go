@@ -1,13 +1,17 @@
 package repos_test
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jaswdr/faker"
 	"github.com/jmoiron/sqlx"
+	"github.com/p-l/fringe/internal/mocks"
 	"github.com/p-l/fringe/internal/repos"
 	"github.com/stretchr/testify/assert"
 )
@@ -26,7 +30,21 @@ func dbOpen() (*sqlx.DB, sqlmock.Sqlmock) {
 
 	mockSQL.ExpectBegin()
 	mockSQL.ExpectExec("CREATE TABLE").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockSQL.ExpectExec("CREATE INDEX").WillReturnResult(sqlmock.NewResult(1, 1))
+	mockSQL.ExpectExec("CREATE UNIQUE INDEX").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// Every migrated column is already present
+	columns := sqlmock.NewRows([]string{"Name"})
+	for _, column := range append(userTableColumns(), "disabled_at", "inactivity_warned_at", "sealed_email", "expires_at", "attributes", "attribute_values") {
+		columns.AddRow(column)
+	}
+
+	mockSQL.ExpectQuery("SELECT Name FROM __Column").WillReturnRows(columns)
+	mockSQL.ExpectQuery("SELECT IsUnique FROM __Index").WillReturnRows(sqlmock.NewRows([]string{"IsUnique"}).AddRow(true))
+	mockSQL.ExpectQuery("SELECT id\\(\\) AS row_id, attributes FROM users").WillReturnRows(sqlmock.NewRows([]string{"row_id", "attributes"}))
+	mockSQL.ExpectExec("CREATE TABLE IF NOT EXISTS user_credentials").WillReturnResult(sqlmock.NewResult(1, 1))
+	mockSQL.ExpectExec("CREATE UNIQUE INDEX").WillReturnResult(sqlmock.NewResult(1, 1))
+	mockSQL.ExpectExec("CREATE TABLE IF NOT EXISTS user_totp").WillReturnResult(sqlmock.NewResult(1, 1))
+	mockSQL.ExpectExec("CREATE UNIQUE INDEX").WillReturnResult(sqlmock.NewResult(1, 1))
 	mockSQL.ExpectCommit()
 
 	return db, mockSQL
@@ -129,7 +147,7 @@ func TestUserRepository_FindByEmail(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		user, err := userRepo.FindByEmail(email)
+		user, err := userRepo.FindByEmail(context.Background(), email)
 		assert.Nil(t, err)
 		assert.NotNil(t, user)
 
@@ -164,7 +182,7 @@ func TestUserRepository_FindByEmail(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		user, err := userRepo.FindByEmail(email)
+		user, err := userRepo.FindByEmail(context.Background(), email)
 		assert.ErrorIs(t, err, repos.ErrUserNotFound)
 		assert.Nil(t, user)
 
@@ -184,8 +202,6 @@ func TestUserRepository_Create(t *testing.T) {
 		db, mockSQL := dbOpen()
 		defer db.Close()
 
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows(userTableColumns()))
-
 		mockSQL.ExpectBegin()
 		mockSQL.ExpectPrepare("INSERT INTO users").WillBeClosed()
 		mockSQL.ExpectExec("").WillReturnResult(sqlmock.NewResult(1, 1))
@@ -199,7 +215,7 @@ func TestUserRepository_Create(t *testing.T) {
 		name := fake.Person().Name()
 		picture := fake.Internet().URL()
 
-		user, err := userRepo.Create(email, name, picture, password)
+		user, err := userRepo.Create(context.Background(), email, name, picture, password)
 		if err != nil {
 			t.Errorf("Creating user failed: %v", err)
 		}
@@ -232,22 +248,17 @@ func TestUserRepository_Create(t *testing.T) {
 		email := fake.Internet().Email()
 		name := fake.Person().Name()
 		picture := fake.Internet().URL()
-		password := fake.Internet().Password()
-		passwordHash, _ := repos.CreatePasswordHash(password)
-		createdAt := fake.Time().Unix(time.Now())
-		profileUpdatedAt := fake.Time().Unix(time.Now())
-		passwordUpdatedAt := fake.Time().Unix(time.Now())
-		lastSeenAt := fake.Time().Unix(time.Now())
-
 		newPassword := fake.Internet().Password()
 
-		// Return the fake User
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()).AddRow(email, name, picture, passwordHash, createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt))
+		// The unique index refuses the insert
+		mockSQL.ExpectBegin()
+		mockSQL.ExpectPrepare("INSERT INTO users").WillBeClosed()
+		mockSQL.ExpectExec("").WillReturnError(fmt.Errorf("cannot insert into unique index: duplicate value(s): [%s]", email))
+		mockSQL.ExpectRollback()
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		user, err := userRepo.Create(email, name, picture, newPassword)
+		user, err := userRepo.Create(context.Background(), email, name, picture, newPassword)
 		assert.ErrorIs(t, err, repos.ErrUserAlreadyExist)
 
 		// CreatUser will return
@@ -272,7 +283,7 @@ func TestUserRepository_Create(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		user, err := userRepo.Create("", name, picture, newPassword)
+		user, err := userRepo.Create(context.Background(), "", name, picture, newPassword)
 		assert.ErrorIs(t, err, repos.ErrInvalidEmail)
 		assert.Nil(t, user)
 
@@ -295,11 +306,11 @@ func TestUserRepository_Create(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		user, err := userRepo.Create(email, name, picture, "")
+		user, err := userRepo.Create(context.Background(), email, name, picture, "")
 		assert.ErrorIs(t, err, repos.ErrInvalidPassword)
 		assert.Nil(t, user)
 
-		user, err = userRepo.Create(email, name, picture, "12345")
+		user, err = userRepo.Create(context.Background(), email, name, picture, "12345")
 		assert.ErrorIs(t, err, repos.ErrInvalidPassword)
 		assert.Nil(t, user)
 
@@ -323,12 +334,14 @@ func TestUserRepository_UpdatePassword(t *testing.T) {
 
 		newPassword := fake.Internet().Password()
 
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()))
+		mockSQL.ExpectBegin()
+		mockSQL.ExpectPrepare("UPDATE users SET password = .*, password_updated_at = .* WHERE").WillBeClosed()
+		mockSQL.ExpectExec("").WillReturnResult(sqlmock.NewResult(0, 0))
+		mockSQL.ExpectRollback()
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		success, err := userRepo.UpdatePassword(email, newPassword)
+		success, err := userRepo.UpdatePassword(context.Background(), email, newPassword)
 		assert.NotNil(t, err)
 		assert.False(t, success)
 
@@ -346,17 +359,8 @@ func TestUserRepository_UpdatePassword(t *testing.T) {
 
 		fake := faker.New()
 		email := fake.Internet().Email()
-		name := fake.Person().Name()
-		picture := fake.Internet().URL()
-		passwordHash, _ := repos.CreatePasswordHash(fake.Internet().Password())
-		createdAt := fake.Time().Unix(time.Now())
-		profileUpdatedAt := fake.Time().Unix(time.Now())
-		passwordUpdatedAt := fake.Time().Unix(time.Now())
-		lastSeenAt := fake.Time().Unix(time.Now())
 		newPassword := fake.Internet().Password()
 
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()).AddRow(email, name, picture, passwordHash, createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt))
 		mockSQL.ExpectBegin()
 		// Ensure that password, updated_at AND last_seen_at are updated
 		mockSQL.ExpectPrepare("UPDATE users SET password = .*, password_updated_at = .* WHERE").WillBeClosed()
@@ -365,7 +369,7 @@ func TestUserRepository_UpdatePassword(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		success, err := userRepo.UpdatePassword(email, newPassword)
+		success, err := userRepo.UpdatePassword(context.Background(), email, newPassword)
 		assert.Nil(t, err)
 		assert.True(t, success)
 
@@ -390,12 +394,14 @@ func TestUserRepository_UpdateProfile(t *testing.T) {
 		newPicture := fake.Internet().URL()
 		newName := fake.Person().Name()
 
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()))
+		mockSQL.ExpectBegin()
+		mockSQL.ExpectPrepare("UPDATE users SET name = .*, picture = .*, profile_updated_at = .* WHERE email == .*").WillBeClosed()
+		mockSQL.ExpectExec("").WillReturnResult(sqlmock.NewResult(0, 0))
+		mockSQL.ExpectRollback()
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		success, err := userRepo.UpdateProfile(email, newName, newPicture)
+		success, err := userRepo.UpdateProfile(context.Background(), email, newName, newPicture)
 		assert.NotNil(t, err)
 		assert.False(t, success)
 
@@ -413,18 +419,9 @@ func TestUserRepository_UpdateProfile(t *testing.T) {
 
 		fake := faker.New()
 		email := fake.Internet().Email()
-		name := fake.Person().Name()
-		picture := fake.Internet().URL()
-		passwordHash, _ := repos.CreatePasswordHash(fake.Internet().Password())
-		createdAt := fake.Time().Unix(time.Now())
-		profileUpdatedAt := fake.Time().Unix(time.Now())
-		passwordUpdatedAt := fake.Time().Unix(time.Now())
-		lastSeenAt := fake.Time().Unix(time.Now())
 		newName := fake.Person().Name()
 		newPicture := fake.Internet().URL()
 
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()).AddRow(email, name, picture, passwordHash, createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt))
 		mockSQL.ExpectBegin()
 		// Ensure that password, updated_at AND last_seen_at are updated
 		mockSQL.ExpectPrepare("UPDATE users SET name = .*, picture = .*, profile_updated_at = .* WHERE email == .*").WillBeClosed()
@@ -433,7 +430,7 @@ func TestUserRepository_UpdateProfile(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		success, err := userRepo.UpdateProfile(email, newName, newPicture)
+		success, err := userRepo.UpdateProfile(context.Background(), email, newName, newPicture)
 		assert.Nil(t, err)
 		assert.True(t, success)
 
@@ -451,28 +448,19 @@ func TestUserRepository_UpdateProfile(t *testing.T) {
 
 		fake := faker.New()
 		email := fake.Internet().Email()
-		name := fake.Person().Name()
-		picture := fake.Internet().URL()
-		passwordHash, _ := repos.CreatePasswordHash(fake.Internet().Password())
-		createdAt := fake.Time().Unix(time.Now())
-		profileUpdatedAt := fake.Time().Unix(time.Now())
-		passwordUpdatedAt := fake.Time().Unix(time.Now())
-		lastSeenAt := fake.Time().Unix(time.Now())
 		newName := fake.Person().Name()
 		newPicture := fake.Internet().URL()
 
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()).AddRow(email, name, picture, passwordHash, createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt))
 		mockSQL.ExpectBegin()
 		// Ensure that password, updated_at AND last_seen_at are updated
 		mockSQL.ExpectPrepare("UPDATE users SET name = .*, picture = .*, profile_updated_at = .* WHERE email == .*").WillBeClosed()
 		mockSQL.ExpectExec("").WithArgs(newName, newPicture, sqlmock.AnyArg(), email).WillReturnResult(sqlmock.NewResult(0, 0))
-		mockSQL.ExpectCommit()
+		mockSQL.ExpectRollback()
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		success, err := userRepo.UpdateProfile(email, newName, newPicture)
-		assert.NoError(t, err)
+		success, err := userRepo.UpdateProfile(context.Background(), email, newName, newPicture)
+		assert.ErrorIs(t, err, repos.ErrUserNotFound)
 		assert.False(t, success)
 
 		// we make sure that all expectations were met
@@ -508,7 +496,7 @@ func TestUserRepository_Exists(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		assert.True(t, userRepo.Exists(email))
+		assert.True(t, userRepo.Exists(context.Background(), email))
 
 		// we make sure that all expectations were met
 		if err := mockSQL.ExpectationsWereMet(); err != nil {
@@ -530,7 +518,7 @@ func TestUserRepository_Exists(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		assert.False(t, userRepo.Exists(email))
+		assert.False(t, userRepo.Exists(context.Background(), email))
 
 		// we make sure that all expectations were met
 		if err := mockSQL.ExpectationsWereMet(); err != nil {
@@ -550,11 +538,14 @@ func TestUserRepository_Seen(t *testing.T) {
 		fake := faker.New()
 		email := fake.Internet().Email()
 
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows(userTableColumns()))
+		mockSQL.ExpectBegin()
+		mockSQL.ExpectPrepare("UPDATE users SET last_seen_at = .* WHERE").WillBeClosed()
+		mockSQL.ExpectExec("").WillReturnResult(sqlmock.NewResult(0, 0))
+		mockSQL.ExpectRollback()
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		err := userRepo.Seen(email)
+		err := userRepo.Seen(context.Background(), email)
 		assert.NotNil(t, err)
 		assert.ErrorIs(t, err, repos.ErrUserNotFound)
 
@@ -572,16 +563,6 @@ func TestUserRepository_Seen(t *testing.T) {
 
 		fake := faker.New()
 		email := fake.Internet().Email()
-		name := fake.Person().Name()
-		picture := fake.Internet().URL()
-		passwordHash, _ := repos.CreatePasswordHash(fake.Internet().Password())
-		createdAt := fake.Time().Unix(time.Now())
-		profileUpdatedAt := fake.Time().Unix(time.Now())
-		passwordUpdatedAt := fake.Time().Unix(time.Now())
-		lastSeenAt := fake.Time().Unix(time.Now())
-
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()).AddRow(email, name, picture, passwordHash, createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt))
 
 		mockSQL.ExpectBegin()
 		// Ensures target "last_seen_at" column
@@ -591,7 +572,7 @@ func TestUserRepository_Seen(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		err := userRepo.Seen(email)
+		err := userRepo.Seen(context.Background(), email)
 		assert.Nil(t, err)
 
 		// we make sure that all expectations were met
@@ -618,7 +599,7 @@ func TestUserRepository_Authenticate(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		success, err := userRepo.Authenticate(email, password)
+		success, err := userRepo.Authenticate(context.Background(), email, password)
 		assert.ErrorIs(t, err, repos.ErrUserNotFound)
 		assert.False(t, success)
 
@@ -646,8 +627,6 @@ func TestUserRepository_Authenticate(t *testing.T) {
 		lastSeenAt := fake.Time().Unix(time.Now())
 
 		// Return the fake User
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()).AddRow(email, name, picture, passwordHash, createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt))
 		mockSQL.ExpectQuery("SELECT").WillReturnRows(
 			sqlmock.NewRows(userTableColumns()).AddRow(email, name, picture, passwordHash, createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt))
 		mockSQL.ExpectBegin()
@@ -658,7 +637,7 @@ func TestUserRepository_Authenticate(t *testing.T) {
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		success, err := userRepo.Authenticate(email, password)
+		success, err := userRepo.Authenticate(context.Background(), email, password)
 		assert.Nil(t, err)
 		assert.True(t, success)
 
@@ -684,7 +663,7 @@ func TestUserRepository_AllUsers(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.AllUsers(0, 0)
+		users, err := userRepo.AllUsers(context.Background(), 0, 0)
 		assert.ErrorIs(t, err, repos.ErrUserNotFound)
 		assert.Nil(t, users)
 
@@ -718,7 +697,7 @@ func TestUserRepository_AllUsers(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.AllUsers(0, 0)
+		users, err := userRepo.AllUsers(context.Background(), 0, 0)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 1)
@@ -751,7 +730,7 @@ func TestUserRepository_AllUsers(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.AllUsers(2, 0)
+		users, err := userRepo.AllUsers(context.Background(), 2, 0)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 2)
@@ -782,7 +761,7 @@ func TestUserRepository_AllUsers(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.AllUsers(2, 1)
+		users, err := userRepo.AllUsers(context.Background(), 2, 1)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 2)
@@ -791,7 +770,7 @@ func TestUserRepository_AllUsers(t *testing.T) {
 		secondQueryRows.AddRow(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), fake.Internet().Password(), createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt)
 		secondQueryRows.AddRow(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), fake.Internet().Password(), createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt)
 		mockSQL.ExpectQuery("SELECT").WithArgs(2, 0).WillReturnRows(secondQueryRows)
-		users, err = userRepo.AllUsers(2, 0)
+		users, err = userRepo.AllUsers(context.Background(), 2, 0)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 2)
@@ -821,7 +800,7 @@ func TestUserRepository_AllUsers(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.AllUsers(1, 1)
+		users, err := userRepo.AllUsers(context.Background(), 1, 1)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 1)
@@ -829,7 +808,7 @@ func TestUserRepository_AllUsers(t *testing.T) {
 		secondQueryRows := sqlmock.NewRows(userTableColumns())
 		secondQueryRows.AddRow(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), fake.Internet().Password(), createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt)
 		mockSQL.ExpectQuery("SELECT").WithArgs(1, 1).WillReturnRows(secondQueryRows)
-		users, err = userRepo.AllUsers(1, 2)
+		users, err = userRepo.AllUsers(context.Background(), 1, 2)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 1)
@@ -857,7 +836,7 @@ func TestUserRepository_FindAllMatching(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.FindAllMatching("query", 0, 0)
+		users, err := userRepo.FindAllMatching(context.Background(), "query", 0, 0)
 		assert.ErrorIs(t, err, repos.ErrUserNotFound)
 		assert.Nil(t, users)
 
@@ -891,7 +870,7 @@ func TestUserRepository_FindAllMatching(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.FindAllMatching("query", 10, 1)
+		users, err := userRepo.FindAllMatching(context.Background(), "query", 10, 1)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 1)
@@ -924,7 +903,7 @@ func TestUserRepository_FindAllMatching(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.FindAllMatching("query", 2, 0)
+		users, err := userRepo.FindAllMatching(context.Background(), "query", 2, 0)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 2)
@@ -955,7 +934,7 @@ func TestUserRepository_FindAllMatching(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.FindAllMatching("query", 2, 1)
+		users, err := userRepo.FindAllMatching(context.Background(), "query", 2, 1)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 2)
@@ -964,7 +943,7 @@ func TestUserRepository_FindAllMatching(t *testing.T) {
 		secondQueryRows.AddRow(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), fake.Internet().Password(), createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt)
 		secondQueryRows.AddRow(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), fake.Internet().Password(), createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt)
 		mockSQL.ExpectQuery("SELECT").WithArgs("query", 2, 0).WillReturnRows(secondQueryRows)
-		users, err = userRepo.FindAllMatching("query", 2, 0)
+		users, err = userRepo.FindAllMatching(context.Background(), "query", 2, 0)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 2)
@@ -994,7 +973,7 @@ func TestUserRepository_FindAllMatching(t *testing.T) {
 		userRepo, err := repos.NewUserRepository(db)
 		assert.NoError(t, err)
 
-		users, err := userRepo.FindAllMatching("query", 1, 1)
+		users, err := userRepo.FindAllMatching(context.Background(), "query", 1, 1)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 1)
@@ -1002,7 +981,7 @@ func TestUserRepository_FindAllMatching(t *testing.T) {
 		secondQueryRows := sqlmock.NewRows(userTableColumns())
 		secondQueryRows.AddRow(fake.Internet().Email(), fake.Person().Name(), fake.Internet().URL(), fake.Internet().Password(), createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt)
 		mockSQL.ExpectQuery("SELECT").WithArgs("query", 1, 1).WillReturnRows(secondQueryRows)
-		users, err = userRepo.FindAllMatching("query", 1, 2)
+		users, err = userRepo.FindAllMatching(context.Background(), "query", 1, 2)
 		assert.NoError(t, err)
 		assert.NotNil(t, users)
 		assert.Len(t, users, 1)
@@ -1029,26 +1008,21 @@ func TestUserRepository_Delete(t *testing.T) {
 
 		fake := faker.New()
 		email := fake.Internet().Email()
-		name := fake.Person().Name()
-		picture := fake.Internet().URL()
-		passwordHash, _ := repos.CreatePasswordHash(fake.Internet().Password())
-		createdAt := fake.Time().Unix(time.Now())
-		profileUpdatedAt := fake.Time().Unix(time.Now())
-		passwordUpdatedAt := fake.Time().Unix(time.Now())
-		lastSeenAt := fake.Time().Unix(time.Now())
-
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()).AddRow(email, name, picture, passwordHash, createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt))
 
 		mockSQL.ExpectBegin()
 		// Ensures target "last_seen_at" column
 		mockSQL.ExpectPrepare("DELETE FROM users WHERE email == .*").WillBeClosed()
 		mockSQL.ExpectExec("").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 1))
+		mockSQL.ExpectExec("DELETE FROM user_credentials WHERE email == .*").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 0))
+		mockSQL.ExpectExec("DELETE FROM user_totp WHERE email == .*").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 0))
+		mockSQL.ExpectQuery("SELECT count\\(\\*\\) FROM __Table WHERE Name == .*").WithArgs("ip_leases").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mockSQL.ExpectExec("DELETE FROM ip_leases WHERE email == .*").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 0))
+		mockSQL.ExpectExec("DELETE FROM ip_reservations WHERE email == .*").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 0))
 		mockSQL.ExpectCommit()
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		err := userRepo.Delete(email)
+		err := userRepo.Delete(context.Background(), email)
 		assert.Nil(t, err)
 
 		// we make sure that all expectations were met
@@ -1066,11 +1040,14 @@ func TestUserRepository_Delete(t *testing.T) {
 		fake := faker.New()
 		email := fake.Internet().Email()
 
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows(userTableColumns()))
+		mockSQL.ExpectBegin()
+		mockSQL.ExpectPrepare("DELETE FROM users WHERE email == .*").WillBeClosed()
+		mockSQL.ExpectExec("").WillReturnResult(sqlmock.NewResult(0, 0))
+		mockSQL.ExpectRollback()
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		err := userRepo.Delete(email)
+		err := userRepo.Delete(context.Background(), email)
 		assert.ErrorIs(t, err, repos.ErrUserNotFound)
 
 		// we make sure that all expectations were met
@@ -1087,26 +1064,16 @@ func TestUserRepository_Delete(t *testing.T) {
 
 		fake := faker.New()
 		email := fake.Internet().Email()
-		name := fake.Person().Name()
-		picture := fake.Internet().URL()
-		passwordHash, _ := repos.CreatePasswordHash(fake.Internet().Password())
-		createdAt := fake.Time().Unix(time.Now())
-		profileUpdatedAt := fake.Time().Unix(time.Now())
-		passwordUpdatedAt := fake.Time().Unix(time.Now())
-		lastSeenAt := fake.Time().Unix(time.Now())
-
-		mockSQL.ExpectQuery("SELECT").WillReturnRows(
-			sqlmock.NewRows(userTableColumns()).AddRow(email, name, picture, passwordHash, createdAt, profileUpdatedAt, passwordUpdatedAt, lastSeenAt))
 
 		mockSQL.ExpectBegin()
 		// Ensures target "last_seen_at" column
 		mockSQL.ExpectPrepare("DELETE FROM users WHERE email == .*").WillBeClosed()
 		mockSQL.ExpectExec("").WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 0))
-		mockSQL.ExpectCommit()
+		mockSQL.ExpectRollback()
 
 		userRepo, _ := repos.NewUserRepository(db)
 
-		err := userRepo.Delete(email)
+		err := userRepo.Delete(context.Background(), email)
 		assert.Error(t, err)
 
 		// we make sure that all expectations were met
@@ -1132,7 +1099,7 @@ func TestNewUserRepository(t *testing.T) {
 
 		mockSQL.ExpectBegin()
 		mockSQL.ExpectExec("CREATE TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
-		mockSQL.ExpectExec("CREATE INDEX").WillReturnError(sqlmock.ErrCancelled)
+		mockSQL.ExpectExec("CREATE UNIQUE INDEX").WillReturnError(sqlmock.ErrCancelled)
 		mockSQL.ExpectCommit()
 
 		assert.Panics(t, func() {
@@ -1140,3 +1107,154 @@ func TestNewUserRepository(t *testing.T) {
 		})
 	})
 }
+
+func TestUserRepository_CanonicalEmail(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Stores and finds emails without case", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		user, err := userRepo.Create(context.Background(), " JDoe@Test.com", "John Doe", "", "a-password")
+		assert.NoError(t, err)
+		assert.Equal(t, "jdoe@test.com", user.Email)
+
+		_, err = userRepo.Create(context.Background(), "jdoe@test.com", "John Doe", "", "a-password")
+		assert.ErrorIs(t, err, repos.ErrUserAlreadyExist)
+
+		found, err := userRepo.FindByEmail(context.Background(), "JDOE@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "jdoe@test.com", found.Email)
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "JDoe@test.com", "a-password")
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+	})
+
+	t.Run("Updates and deletes without case", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		_, err := userRepo.Create(context.Background(), "jdoe@test.com", "John Doe", "", "a-password")
+		assert.NoError(t, err)
+
+		updated, err := userRepo.UpdateProfile(context.Background(), "JDoe@test.com", "Johnny Doe", "https://test.com/jdoe.png")
+		assert.NoError(t, err)
+		assert.True(t, updated)
+
+		user, err := userRepo.FindByEmail(context.Background(), "jdoe@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "Johnny Doe", user.Name)
+		assert.Equal(t, "https://test.com/jdoe.png", user.Picture)
+
+		updated, err = userRepo.UpdatePassword(context.Background(), "JDOE@TEST.COM", "new-password")
+		assert.NoError(t, err)
+		assert.True(t, updated)
+
+		assert.NoError(t, userRepo.Delete(context.Background(), "JDoe@Test.com"))
+		assert.False(t, userRepo.Exists(context.Background(), "jdoe@test.com"))
+	})
+}
+
+func TestUserRepository_Races(t *testing.T) {
+	t.Parallel()
+
+	const racers = 16
+
+	t.Run("Only one of concurrent creators succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		before, _ := userRepo.AllUsers(context.Background(), 0, 0)
+
+		var waitGroup sync.WaitGroup
+
+		start := make(chan struct{})
+		errs := make(chan error, racers)
+
+		for index := 0; index < racers; index++ {
+			// Half of the creators use another case for the same email
+			email := "jdoe@test.com"
+			if index%2 == 1 {
+				email = "JDoe@Test.com"
+			}
+
+			waitGroup.Add(1)
+
+			go func(email string) {
+				defer waitGroup.Done()
+				<-start
+
+				_, err := userRepo.Create(context.Background(), email, "John Doe", "", "a-password")
+				errs <- err
+			}(email)
+		}
+
+		close(start)
+		waitGroup.Wait()
+		close(errs)
+
+		created := 0
+
+		for err := range errs {
+			if err == nil {
+				created++
+
+				continue
+			}
+
+			assert.ErrorIs(t, err, repos.ErrUserAlreadyExist)
+		}
+
+		assert.Equal(t, 1, created)
+
+		after, err := userRepo.AllUsers(context.Background(), 0, 0)
+		assert.NoError(t, err)
+		assert.Len(t, after, len(before)+1)
+	})
+
+	t.Run("Only one of concurrent deleters succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		_, err := userRepo.Create(context.Background(), "jdoe@test.com", "John Doe", "", "a-password")
+		assert.NoError(t, err)
+
+		var waitGroup sync.WaitGroup
+
+		start := make(chan struct{})
+		errs := make(chan error, racers)
+
+		for index := 0; index < racers; index++ {
+			waitGroup.Add(1)
+
+			go func() {
+				defer waitGroup.Done()
+				<-start
+
+				errs <- userRepo.Delete(context.Background(), "jdoe@test.com")
+			}()
+		}
+
+		close(start)
+		waitGroup.Wait()
+		close(errs)
+
+		deleted := 0
+
+		for err := range errs {
+			if err == nil {
+				deleted++
+
+				continue
+			}
+
+			assert.ErrorIs(t, err, repos.ErrUserNotFound)
+		}
+
+		assert.Equal(t, 1, deleted)
+	})
+}
@@ -0,0 +1,336 @@
+package repos
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// UserTOTP is the time-based one-time password seed of a user, it is enabled once a first code is confirmed.
+// LastStep is the time step of the last accepted code, codes of that step or earlier are refused.
+type UserTOTP struct {
+	Email     string `db:"email" json:"email"`
+	Secret    string `db:"secret" json:"-"`
+	CreatedAt int64  `db:"created_at" json:"created_at"`
+	EnabledAt int64  `db:"enabled_at" json:"enabled_at"`
+	LastStep  int64  `db:"last_step" json:"-"`
+}
+
+// TOTPEnrollment is returned once when enrolling, URL is the otpauth URI shown as a QR code to authenticator apps.
+type TOTPEnrollment struct {
+	Secret string
+	URL    string
+}
+
+var (
+	ErrTOTPNotEnrolled     = errors.New("user has no one-time password enrolled")
+	ErrTOTPAlreadyEnabled  = errors.New("user one-time password is already enabled")
+	ErrInvalidOTP          = errors.New("invalid one-time password")
+	ErrOTPReplayed         = errors.New("one-time password was already used")
+	errTOTPGenerateFailure = errors.New("could not generate one-time password secret")
+)
+
+const (
+	// TOTPDigits is the length of the codes, the codes are appended to passwords by some clients.
+	TOTPDigits     = 6
+	totpPeriod     = 30
+	totpSkew       = 1
+	totpSecretSize = 20
+)
+
+func createTOTPTable(tx *sqlx.Tx) {
+	tx.MustExec("CREATE TABLE IF NOT EXISTS user_totp (" +
+		"email string NOT NULL, " +
+		"secret string NOT NULL, " +
+		"created_at int64 NOT NULL, " +
+		"enabled_at int64 NOT NULL, " +
+		"last_step int64 NOT NULL)")
+	tx.MustExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_user_totp_email ON user_totp (email)")
+}
+
+// IsEnabled returns true once the enrollment was confirmed with a valid code.
+func (t *UserTOTP) IsEnabled() bool {
+	return t.EnabledAt != 0
+}
+
+// matchStep returns the time step of the code, within one step of now, or ErrInvalidOTP.
+func (t *UserTOTP) matchStep(code string, now time.Time) (int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, ErrInvalidOTP
+	}
+
+	current := now.Unix() / totpPeriod
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totp.GenerateCodeCustom(t.Secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("could not generate one-time password: %w", err)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+
+	return 0, ErrInvalidOTP
+}
+
+// EnrollTOTP generates a new seed for the user, it replaces a pending enrollment but not an enabled one.
+func (r *UserRepository) EnrollTOTP(ctx context.Context, email string, issuer string) (*TOTPEnrollment, error) {
+	email = CanonicalEmail(email)
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: email,
+		Period:      totpPeriod,
+		SecretSize:  totpSecretSize,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errTOTPGenerateFailure, err)
+	}
+
+	defer r.holdDataKey()()
+
+	sealedSecret, err := r.sealField(r.lookupEmail(email), "totp_secret", key.Secret())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	enrollTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not enroll %s one-time password: %w", email, err)
+	}
+	defer func() { _ = enrollTx.Rollback() }() //nolint:wsl
+
+	var users int64
+	if err := enrollTx.GetContext(ctx, &users, "SELECT count(*) FROM users WHERE email == $1", r.lookupEmail(email)); err != nil {
+		return nil, fmt.Errorf("could not enroll %s one-time password: %w", email, err)
+	}
+
+	if users == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	var enabled int64
+	if err := enrollTx.GetContext(ctx, &enabled, "SELECT count(*) FROM user_totp WHERE email == $1 AND enabled_at != 0", r.lookupEmail(email)); err != nil {
+		return nil, fmt.Errorf("could not enroll %s one-time password: %w", email, err)
+	}
+
+	if enabled > 0 {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	if _, err := enrollTx.ExecContext(ctx, "DELETE FROM user_totp WHERE email == $1", r.lookupEmail(email)); err != nil {
+		return nil, fmt.Errorf("could not enroll %s one-time password: %w", email, err)
+	}
+
+	_, err = enrollTx.ExecContext(ctx, "INSERT INTO user_totp (email, secret, created_at, enabled_at, last_step) VALUES ($1,$2,$3,0,0)",
+		r.lookupEmail(email), sealedSecret, time.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("could not enroll %s one-time password: %w", email, err)
+	}
+
+	if err := enrollTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not enroll %s one-time password: %w", email, err)
+	}
+
+	return &TOTPEnrollment{Secret: key.Secret(), URL: key.URL()}, nil
+}
+
+// TOTP returns the one-time password enrollment of the user, pending or enabled.
+func (r *UserRepository) TOTP(ctx context.Context, email string) (*UserTOTP, error) {
+	email = CanonicalEmail(email)
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	var userTOTP UserTOTP
+
+	err := r.db.GetContext(ctx, &userTOTP, "SELECT * FROM user_totp WHERE email == $1", r.lookupEmail(email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve %s one-time password: %w", email, err)
+	}
+
+	if err := r.openTOTP(&userTOTP, email); err != nil {
+		return nil, fmt.Errorf("could not retrieve %s one-time password: %w", email, err)
+	}
+
+	return &userTOTP, nil
+}
+
+// HasTOTP returns true when the user must provide a one-time password along with its password.
+func (r *UserRepository) HasTOTP(ctx context.Context, email string) (bool, error) {
+	userTOTP, err := r.TOTP(ctx, email)
+	if errors.Is(err, ErrTOTPNotEnrolled) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return userTOTP.IsEnabled(), nil
+}
+
+// ConfirmTOTP enables the pending enrollment of the user when code is valid.
+func (r *UserRepository) ConfirmTOTP(ctx context.Context, email string, code string, now time.Time) error {
+	return r.acceptTOTP(ctx, email, code, now, false)
+}
+
+// VerifyTOTP checks the code against the enabled seed of the user, each code is only accepted once.
+func (r *UserRepository) VerifyTOTP(ctx context.Context, email string, code string, now time.Time) error {
+	return r.acceptTOTP(ctx, email, code, now, true)
+}
+
+// acceptTOTP records the step of a valid code, enabling the enrollment when it is pending.
+func (r *UserRepository) acceptTOTP(ctx context.Context, email string, code string, now time.Time, enabled bool) error {
+	email = CanonicalEmail(email)
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	acceptTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not verify %s one-time password: %w", email, err)
+	}
+	defer func() { _ = acceptTx.Rollback() }() //nolint:wsl
+
+	var userTOTP UserTOTP
+
+	err = acceptTx.GetContext(ctx, &userTOTP, "SELECT * FROM user_totp WHERE email == $1", r.lookupEmail(email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTOTPNotEnrolled
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not verify %s one-time password: %w", email, err)
+	}
+
+	switch {
+	case enabled && !userTOTP.IsEnabled():
+		return ErrTOTPNotEnrolled
+	case !enabled && userTOTP.IsEnabled():
+		return ErrTOTPAlreadyEnabled
+	}
+
+	if err := r.openTOTP(&userTOTP, email); err != nil {
+		return fmt.Errorf("could not verify %s one-time password: %w", email, err)
+	}
+
+	step, err := userTOTP.matchStep(code, now)
+	if err != nil {
+		return err
+	}
+
+	if step <= userTOTP.LastStep {
+		return ErrOTPReplayed
+	}
+
+	enabledAt := userTOTP.EnabledAt
+	if enabledAt == 0 {
+		enabledAt = now.Unix()
+	}
+
+	// Matching the previous step makes concurrent requests with the same code accept it only once
+	result, err := acceptTx.ExecContext(ctx, "UPDATE user_totp SET last_step = $1, enabled_at = $2 WHERE email == $3 AND last_step == $4",
+		step, enabledAt, r.lookupEmail(email), userTOTP.LastStep)
+	if err != nil {
+		return fmt.Errorf("could not verify %s one-time password: %w", email, err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrOTPReplayed
+	}
+
+	if err := acceptTx.Commit(); err != nil {
+		return fmt.Errorf("could not verify %s one-time password: %w", email, err)
+	}
+
+	return nil
+}
+
+// DisableTOTP removes the one-time password of the user, enabled or pending.
+func (r *UserRepository) DisableTOTP(ctx context.Context, email string) error {
+	email = CanonicalEmail(email)
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	disableTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not disable %s one-time password: %w", email, err)
+	}
+	defer func() { _ = disableTx.Rollback() }() //nolint:wsl
+
+	result, err := disableTx.ExecContext(ctx, "DELETE FROM user_totp WHERE email == $1", r.lookupEmail(email))
+	if err != nil {
+		return fmt.Errorf("could not disable %s one-time password: %w", email, err)
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrTOTPNotEnrolled
+	}
+
+	if err := disableTx.Commit(); err != nil {
+		return fmt.Errorf("could not disable %s one-time password: %w", email, err)
+	}
+
+	return nil
+}
+
+// openTOTP decrypts a seed read from the database and sets its clear text email.
+func (r *UserRepository) openTOTP(userTOTP *UserTOTP, email string) error {
+	var err error
+
+	userTOTP.Secret, err = r.openField(userTOTP.Email, "totp_secret", userTOTP.Secret)
+	userTOTP.Email = email
+
+	return err
+}
+
+// resealTOTP moves the seed stored under storedEmail to lookupEmail, encrypted with key.
+func resealTOTP(ctx context.Context, tx *sqlx.Tx, cipher *FieldCipher, key *dataKey, storedEmail string, lookupEmail string) error {
+	var seeds []UserTOTP
+	if err := tx.SelectContext(ctx, &seeds, "SELECT * FROM user_totp WHERE email == $1", storedEmail); err != nil {
+		return fmt.Errorf("could not encrypt one-time password: %w", err)
+	}
+
+	for _, seed := range seeds {
+		secret, err := cipher.open(storedEmail, "totp_secret", seed.Secret)
+		if err != nil {
+			return err
+		}
+
+		if secret, err = cipher.seal(key, lookupEmail, "totp_secret", secret); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE user_totp SET email = $1, secret = $2 WHERE email == $3", lookupEmail, secret, storedEmail); err != nil {
+			return fmt.Errorf("could not encrypt one-time password: %w", err)
+		}
+	}
+
+	return nil
+}
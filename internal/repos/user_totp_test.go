@@ -0,0 +1,193 @@
+package repos_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func enrollTOTP(t *testing.T, userRepo *repos.UserRepository, email string, now time.Time) string {
+	t.Helper()
+
+	enrollment, err := userRepo.EnrollTOTP(context.Background(), email, "Fringe")
+	assert.NoError(t, err)
+
+	code, _ := totp.GenerateCode(enrollment.Secret, now)
+	assert.NoError(t, userRepo.ConfirmTOTP(context.Background(), email, code, now))
+
+	return enrollment.Secret
+}
+
+func TestUserRepository_EnrollTOTP(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Enrollment is pending until confirmed", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		enrollment, err := userRepo.EnrollTOTP(context.Background(), "User@test.com", "Fringe")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, enrollment.Secret)
+		assert.Contains(t, enrollment.URL, "otpauth://totp/Fringe:user@test.com")
+
+		enabled, err := userRepo.HasTOTP(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.False(t, enabled)
+
+		now := time.Now()
+		err = userRepo.ConfirmTOTP(context.Background(), "user@test.com", "000000x", now)
+		assert.ErrorIs(t, err, repos.ErrInvalidOTP)
+
+		code, _ := totp.GenerateCode(enrollment.Secret, now)
+		assert.NoError(t, userRepo.ConfirmTOTP(context.Background(), "user@test.com", code, now))
+
+		enabled, err = userRepo.HasTOTP(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.True(t, enabled)
+
+		_, err = userRepo.EnrollTOTP(context.Background(), "user@test.com", "Fringe")
+		assert.ErrorIs(t, err, repos.ErrTOTPAlreadyEnabled)
+	})
+
+	t.Run("Refuses unknown users", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		_, err := userRepo.EnrollTOTP(context.Background(), "nobody@test.com", "Fringe")
+		assert.ErrorIs(t, err, repos.ErrUserNotFound)
+
+		enabled, err := userRepo.HasTOTP(context.Background(), "nobody@test.com")
+		assert.NoError(t, err)
+		assert.False(t, enabled)
+	})
+}
+
+func TestUserRepository_VerifyTOTP(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Codes are accepted once", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		enrolledAt := time.Now()
+		secret := enrollTOTP(t, userRepo, "user@test.com", enrolledAt)
+
+		// The confirmation code cannot be used again
+		code, _ := totp.GenerateCode(secret, enrolledAt)
+		err := userRepo.VerifyTOTP(context.Background(), "user@test.com", code, enrolledAt)
+		assert.ErrorIs(t, err, repos.ErrOTPReplayed)
+
+		later := enrolledAt.Add(time.Minute)
+		code, _ = totp.GenerateCode(secret, later)
+		assert.NoError(t, userRepo.VerifyTOTP(context.Background(), "user@test.com", code, later))
+
+		err = userRepo.VerifyTOTP(context.Background(), "user@test.com", code, later)
+		assert.ErrorIs(t, err, repos.ErrOTPReplayed)
+
+		// Codes of earlier steps are refused even when never used
+		earlier, _ := totp.GenerateCode(secret, later.Add(-30*time.Second))
+		err = userRepo.VerifyTOTP(context.Background(), "user@test.com", earlier, later)
+		assert.ErrorIs(t, err, repos.ErrOTPReplayed)
+	})
+
+	t.Run("Refuses codes out of the time window", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		now := time.Now()
+		secret := enrollTOTP(t, userRepo, "user@test.com", now)
+
+		stale, _ := totp.GenerateCode(secret, now.Add(5*time.Minute))
+		err := userRepo.VerifyTOTP(context.Background(), "user@test.com", stale, now.Add(10*time.Minute))
+		assert.ErrorIs(t, err, repos.ErrInvalidOTP)
+	})
+
+	t.Run("Pending enrollments do not verify", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		now := time.Now()
+		enrollment, _ := userRepo.EnrollTOTP(context.Background(), "user@test.com", "Fringe")
+		code, _ := totp.GenerateCode(enrollment.Secret, now)
+
+		err := userRepo.VerifyTOTP(context.Background(), "user@test.com", code, now)
+		assert.ErrorIs(t, err, repos.ErrTOTPNotEnrolled)
+	})
+}
+
+func TestUserRepository_DisableTOTP(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Disabled users no longer need a code", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		_ = enrollTOTP(t, userRepo, "user@test.com", time.Now())
+
+		assert.NoError(t, userRepo.DisableTOTP(context.Background(), "user@test.com"))
+
+		enabled, err := userRepo.HasTOTP(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.False(t, enabled)
+
+		err = userRepo.DisableTOTP(context.Background(), "user@test.com")
+		assert.ErrorIs(t, err, repos.ErrTOTPNotEnrolled)
+	})
+
+	t.Run("Deleting the user deletes its seed", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+		_ = enrollTOTP(t, userRepo, "user@test.com", time.Now())
+
+		assert.NoError(t, userRepo.Delete(context.Background(), "user@test.com"))
+		_, _ = userRepo.Create(context.Background(), "user@test.com", "", "", "a-password")
+
+		_, err := userRepo.TOTP(context.Background(), "user@test.com")
+		assert.ErrorIs(t, err, repos.ErrTOTPNotEnrolled)
+	})
+}
+
+func TestUserRepository_TOTPEncryption(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Seeds are encrypted with the users and follow key rotation", func(t *testing.T) {
+		t.Parallel()
+
+		db := mocks.NewMockDB(t)
+		clear, err := repos.NewUserRepository(db)
+		assert.NoError(t, err)
+		_, _ = clear.Create(context.Background(), "user@test.com", "", "", "a-password")
+		secret := enrollTOTP(t, clear, "user@test.com", time.Now())
+
+		encrypted := newEncryptedUserRepository(t, db)
+
+		var raw []repos.UserTOTP
+		assert.NoError(t, db.Select(&raw, "SELECT * FROM user_totp"))
+		assert.NotEqual(t, "user@test.com", raw[0].Email)
+		assert.NotContains(t, raw[0].Secret, secret)
+
+		assert.NoError(t, encrypted.RotateEncryptionKey(context.Background()))
+
+		userTOTP, err := newEncryptedUserRepository(t, db).TOTP(context.Background(), "user@test.com")
+		assert.NoError(t, err)
+		assert.Equal(t, secret, userTOTP.Secret)
+		assert.True(t, userTOTP.IsEnabled())
+	})
+}
@@ -0,0 +1,418 @@
+package repos
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserImportRecord is a user read from an import file.
+// Password is an optional clear text password hashed on import when no PasswordHash is provided.
+type UserImportRecord struct {
+	User
+	Password string `json:"password"`
+}
+
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+)
+
+const (
+	TransferFormatJSON = "json"
+	TransferFormatCSV  = "csv"
+)
+
+const (
+	ImportStatusCreated     = "created"
+	ImportStatusOverwritten = "overwritten"
+	ImportStatusSkipped     = "skipped"
+	ImportStatusConflict    = "conflict"
+	ImportStatusInvalid     = "invalid"
+)
+
+// ImportOptions controls an import, when AllowedDomain is set records with emails in other domains are invalid.
+type ImportOptions struct {
+	DryRun        bool
+	Conflict      ConflictPolicy
+	AllowedDomain string
+}
+
+// ImportResult describes what happened to a single record.
+// Password is only set when a password was generated for the user.
+type ImportResult struct {
+	Email    string `json:"email"`
+	Status   string `json:"status"`
+	Password string `json:"password,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun      bool           `json:"dry_run"`
+	Created     int            `json:"created"`
+	Overwritten int            `json:"overwritten"`
+	Skipped     int            `json:"skipped"`
+	Invalid     int            `json:"invalid"`
+	Results     []ImportResult `json:"results"`
+}
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported import/export format")
+	ErrInvalidConflict   = errors.New("invalid import conflict policy")
+	ErrImportConflict    = errors.New("import conflicts with existing users")
+	ErrInvalidRecord     = errors.New("invalid import record")
+)
+
+// userCSVColumns is the column order used in csv exports, imports also accept an optional password column.
+var userCSVColumns = []string{"email", "name", "picture", "password_hash", "created_at", "profile_updated_at", "password_updated_at", "last_seen_at", "disabled_at", "inactivity_warned_at", "expires_at", "attributes"} //nolint:gochecknoglobals
+
+// ParseConflictPolicy validates a conflict policy string, empty defaults to ConflictSkip.
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch ConflictPolicy(strings.ToLower(policy)) {
+	case "", ConflictSkip:
+		return ConflictSkip, nil
+	case ConflictOverwrite:
+		return ConflictOverwrite, nil
+	case ConflictFail:
+		return ConflictFail, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidConflict, policy)
+	}
+}
+
+// ExportUsers writes every user, including its password hash, to the writer in the requested format.
+func (r *UserRepository) ExportUsers(ctx context.Context, writer io.Writer, format string) error {
+	var users []User
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	if err := r.db.SelectContext(ctx, &users, "SELECT * FROM users ORDER BY email"); err != nil {
+		return fmt.Errorf("could not retrieve users for export: %w", err)
+	}
+
+	if users == nil {
+		users = []User{}
+	}
+
+	if err := r.openUsers(users); err != nil {
+		return fmt.Errorf("could not retrieve users for export: %w", err)
+	}
+
+	// The email column only holds a lookup index when users are encrypted
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+
+	switch format {
+	case TransferFormatJSON:
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", " ")
+
+		if err := encoder.Encode(users); err != nil {
+			return fmt.Errorf("could not encode users: %w", err)
+		}
+
+		return nil
+	case TransferFormatCSV:
+		return exportUsersCSV(writer, users)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+}
+
+func exportUsersCSV(writer io.Writer, users []User) error {
+	csvWriter := csv.NewWriter(writer)
+	records := [][]string{userCSVColumns}
+
+	for _, user := range users {
+		attributes, err := encodeAttributes(user.Attributes)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, []string{
+			user.Email,
+			user.Name,
+			user.Picture,
+			user.PasswordHash,
+			strconv.FormatInt(user.CreatedAt, 10),
+			strconv.FormatInt(user.ProfileUpdatedAt, 10),
+			strconv.FormatInt(user.PasswordUpdatedAt, 10),
+			strconv.FormatInt(user.LastSeenAt, 10),
+			strconv.FormatInt(user.DisabledAt, 10),
+			strconv.FormatInt(user.InactivityWarnedAt, 10),
+			strconv.FormatInt(user.ExpiresAt, 10),
+			attributes,
+		})
+	}
+
+	if err := csvWriter.WriteAll(records); err != nil {
+		return fmt.Errorf("could not encode users: %w", err)
+	}
+
+	return nil
+}
+
+// DecodeUserImport reads user records from json or csv.
+// CSV files must start with a header row naming the columns, unknown columns are ignored.
+func DecodeUserImport(reader io.Reader, format string) ([]UserImportRecord, error) {
+	switch format {
+	case TransferFormatJSON:
+		var records []UserImportRecord
+		if err := json.NewDecoder(reader).Decode(&records); err != nil {
+			return nil, fmt.Errorf("could not decode users: %w", err)
+		}
+
+		return records, nil
+	case TransferFormatCSV:
+		return decodeUserImportCSV(reader)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+}
+
+func decodeUserImportCSV(reader io.Reader) ([]UserImportRecord, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+
+	rows, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not decode users: %w", err)
+	}
+
+	if len(rows) == 0 {
+		return []UserImportRecord{}, nil
+	}
+
+	columns := map[string]int{}
+	for index, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = index
+	}
+
+	if _, found := columns["email"]; !found {
+		return nil, fmt.Errorf("%w: csv header has no email column", ErrInvalidRecord)
+	}
+
+	value := func(row []string, column string) string {
+		index, found := columns[column]
+		if !found || index >= len(row) {
+			return ""
+		}
+
+		return strings.TrimSpace(row[index])
+	}
+
+	timestamp := func(row []string, column string) int64 {
+		parsed, _ := strconv.ParseInt(value(row, column), 10, 64)
+
+		return parsed
+	}
+
+	records := make([]UserImportRecord, 0, len(rows)-1)
+	for line, row := range rows[1:] {
+		attributes := map[string]string{}
+		if encoded := value(row, "attributes"); len(encoded) > 0 {
+			if err := json.Unmarshal([]byte(encoded), &attributes); err != nil {
+				return nil, fmt.Errorf("%w: line %d attributes: %v", ErrInvalidRecord, line+2, err)
+			}
+		}
+
+		records = append(records, UserImportRecord{
+			User: User{
+				Email:              value(row, "email"),
+				Name:               value(row, "name"),
+				Picture:            value(row, "picture"),
+				PasswordHash:       value(row, "password_hash"),
+				CreatedAt:          timestamp(row, "created_at"),
+				ProfileUpdatedAt:   timestamp(row, "profile_updated_at"),
+				PasswordUpdatedAt:  timestamp(row, "password_updated_at"),
+				LastSeenAt:         timestamp(row, "last_seen_at"),
+				DisabledAt:         timestamp(row, "disabled_at"),
+				InactivityWarnedAt: timestamp(row, "inactivity_warned_at"),
+				ExpiresAt:          timestamp(row, "expires_at"),
+				Attributes:         attributes,
+			},
+			Password: value(row, "password"),
+		})
+	}
+
+	return records, nil
+}
+
+// prepareImportedUser returns the user to store and the generated password if one was needed.
+// Provided password hashes are stored as is and must be argon2id hashes.
+func (r *UserRepository) prepareImportedUser(record UserImportRecord, options ImportOptions, now int64) (User, string, error) {
+	user := record.User
+	user.Email = CanonicalEmail(user.Email)
+	generatedPassword := ""
+
+	if len(user.Email) == 0 || !strings.Contains(user.Email, "@") {
+		return user, "", fmt.Errorf("%w: missing or malformed email", ErrInvalidRecord)
+	}
+
+	if len(options.AllowedDomain) > 0 && !strings.HasSuffix(user.Email, "@"+CanonicalEmail(options.AllowedDomain)) {
+		return user, "", fmt.Errorf("%w: email is not in domain %s", ErrInvalidRecord, options.AllowedDomain)
+	}
+
+	attributes, err := NormalizeAttributes(user.Attributes)
+	if err != nil {
+		return user, "", fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+
+	user.Attributes = attributes
+
+	if len(user.PasswordHash) == 0 {
+		pwd := record.Password
+		if len(pwd) == 0 {
+			generated, err := GeneratePassword()
+			if err != nil {
+				return user, "", err
+			}
+
+			pwd = generated
+			generatedPassword = generated
+		} else if len(pwd) < UserPasswordMinLen {
+			return user, "", fmt.Errorf("%w: %v", ErrInvalidRecord, ErrInvalidPassword)
+		}
+
+		hash, err := r.createPasswordHash(pwd)
+		if err != nil {
+			return user, "", err
+		}
+
+		user.PasswordHash = hash
+		user.PasswordUpdatedAt = now
+	} else if err := validatePasswordHash(user.PasswordHash); err != nil {
+		return user, "", fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+
+	for _, timestamp := range []*int64{&user.CreatedAt, &user.ProfileUpdatedAt, &user.PasswordUpdatedAt} {
+		if *timestamp == 0 {
+			*timestamp = now
+		}
+	}
+
+	// Users without last seen information were never seen since their creation
+	if user.LastSeenAt == 0 {
+		user.LastSeenAt = user.CreatedAt
+	}
+
+	return user, generatedPassword, nil
+}
+
+// preparedImport is an import record once validated and its password hashed.
+type preparedImport struct {
+	user              User
+	generatedPassword string
+	err               error
+}
+
+// ImportUsers stores the records in a single transaction.
+// Existing users are skipped, overwritten or make the whole import fail depending on the conflict policy.
+// Nothing is written when DryRun is set, the report still describes what would have happened.
+func (r *UserRepository) ImportUsers(ctx context.Context, records []UserImportRecord, options ImportOptions) (*ImportReport, error) {
+	report := ImportReport{DryRun: options.DryRun, Results: make([]ImportResult, 0, len(records))}
+	now := time.Now().Unix()
+	conflicts := 0
+
+	// Hashing passwords is slow, it is done before the transaction so it does not count against the write timeout
+	prepared := make([]preparedImport, 0, len(records))
+
+	for _, record := range records {
+		user, generatedPassword, err := r.prepareImportedUser(record, options, now)
+		prepared = append(prepared, preparedImport{user: user, generatedPassword: generatedPassword, err: err})
+	}
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+	defer r.holdDataKey()()
+
+	importTx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not import users: %w", err)
+	}
+	defer func() { _ = importTx.Rollback() }() //nolint:wsl
+
+	for index, record := range records {
+		result := ImportResult{Email: record.Email}
+		user, generatedPassword := prepared[index].user, prepared[index].generatedPassword
+
+		if err := prepared[index].err; err != nil {
+			result.Status = ImportStatusInvalid
+			result.Reason = err.Error()
+			report.Invalid++
+			report.Results = append(report.Results, result)
+
+			continue
+		}
+
+		var existing int64
+		if err := importTx.GetContext(ctx, &existing, "SELECT count(*) FROM users WHERE email == $1", r.lookupEmail(user.Email)); err != nil {
+			return nil, fmt.Errorf("could not import %s: %w", user.Email, err)
+		}
+
+		stored, err := r.sealUser(user)
+		if err != nil {
+			return nil, fmt.Errorf("could not import %s: %w", user.Email, err)
+		}
+
+		switch {
+		case existing > 0 && options.Conflict == ConflictFail:
+			result.Status = ImportStatusConflict
+			conflicts++
+		case existing > 0 && options.Conflict == ConflictOverwrite:
+			result.Status = ImportStatusOverwritten
+			report.Overwritten++
+			_, err = importTx.ExecContext(ctx, "UPDATE users SET name = $1, picture = $2, password = $3, created_at = $4, profile_updated_at = $5, password_updated_at = $6, last_seen_at = $7, disabled_at = $8, inactivity_warned_at = $9, expires_at = $10, attributes = $11, attribute_values = $12 WHERE email == $13",
+				stored.Name, stored.Picture, stored.PasswordHash, stored.CreatedAt, stored.ProfileUpdatedAt, stored.PasswordUpdatedAt, stored.LastSeenAt, stored.DisabledAt, stored.InactivityWarnedAt, stored.ExpiresAt, stored.StoredAttributes, stored.StoredAttributeValues, stored.Email)
+		case existing > 0:
+			result.Status = ImportStatusSkipped
+			report.Skipped++
+		default:
+			result.Status = ImportStatusCreated
+			report.Created++
+
+			// Passwords generated during a dry run are never stored, they must not be handed out
+			if !options.DryRun {
+				result.Password = generatedPassword
+			}
+
+			_, err = importTx.ExecContext(ctx, "INSERT INTO users (email, name, picture, password, created_at, profile_updated_at, password_updated_at, last_seen_at, disabled_at, inactivity_warned_at, expires_at, sealed_email, attributes, attribute_values) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)",
+				stored.Email, stored.Name, stored.Picture, stored.PasswordHash, stored.CreatedAt, stored.ProfileUpdatedAt, stored.PasswordUpdatedAt, stored.LastSeenAt, stored.DisabledAt, stored.InactivityWarnedAt, stored.ExpiresAt, stored.SealedEmail, stored.StoredAttributes, stored.StoredAttributeValues)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("could not import %s: %w", user.Email, err)
+		}
+
+		report.Results = append(report.Results, result)
+	}
+
+	if conflicts > 0 {
+		// Nothing is stored, generated passwords are meaningless
+		for index := range report.Results {
+			report.Results[index].Password = ""
+		}
+
+		return &report, fmt.Errorf("%w: %d user(s) already exist", ErrImportConflict, conflicts)
+	}
+
+	if options.DryRun {
+		return &report, nil
+	}
+
+	if err := importTx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not import users: %w", err)
+	}
+
+	return &report, nil
+}
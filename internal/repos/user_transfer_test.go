@@ -0,0 +1,309 @@
+package repos_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p-l/fringe/internal/mocks"
+	"github.com/p-l/fringe/internal/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+// importedPasswordHash is an argon2id hash of "imported-password" used for users imported by the tests.
+const importedPasswordHash = "$argon2id$v=19$m=65536,t=1,p=2$5GpJksUpTq8g0CIIlTb0bw$CWKRX4ZIKoP5L1qzmEimxoeqEGxhUIdScqZSNE5S/6I"
+
+func TestParseConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Defaults to skip", func(t *testing.T) {
+		t.Parallel()
+
+		policy, err := repos.ParseConflictPolicy("")
+		assert.NoError(t, err)
+		assert.Equal(t, repos.ConflictSkip, policy)
+	})
+
+	t.Run("Refuses unknown policies", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := repos.ParseConflictPolicy("merge")
+		assert.ErrorIs(t, err, repos.ErrInvalidConflict)
+	})
+}
+
+func TestUserRepository_ExportUsers(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{repos.TransferFormatJSON, repos.TransferFormatCSV} {
+		format := format
+
+		t.Run("Round trips every field with "+format, func(t *testing.T) {
+			t.Parallel()
+
+			source := mocks.NewMockUserRepository(t)
+			_, err := source.Create(context.Background(), "user@test.com", "User Name", "https://picture/url", "a-password")
+			assert.NoError(t, err)
+			assert.NoError(t, source.SetAttributes(context.Background(), "user@test.com", map[string]string{"department": "Sales, \"West\""}))
+
+			var exported bytes.Buffer
+			assert.NoError(t, source.ExportUsers(context.Background(), &exported, format))
+
+			records, err := repos.DecodeUserImport(&exported, format)
+			assert.NoError(t, err)
+			assert.Len(t, records, 2)
+
+			destination, err := repos.NewUserRepository(mocks.NewMockDB(t))
+			assert.NoError(t, err)
+
+			report, err := destination.ImportUsers(context.Background(), records, repos.ImportOptions{Conflict: repos.ConflictFail})
+			assert.NoError(t, err)
+			assert.Equal(t, 2, report.Created)
+
+			original, err := source.FindByEmail(context.Background(), "user@test.com")
+			assert.NoError(t, err)
+			imported, err := destination.FindByEmail(context.Background(), "user@test.com")
+			assert.NoError(t, err)
+			assert.Equal(t, original, imported)
+
+			// The hash is preserved so the password keeps working
+			authenticated, err := destination.Authenticate(context.Background(), "user@test.com", "a-password")
+			assert.NoError(t, err)
+			assert.True(t, authenticated)
+		})
+
+		t.Run("Round trips account expiry with "+format, func(t *testing.T) {
+			t.Parallel()
+
+			source := mocks.NewMockUserRepository(t)
+			_, err := source.CreateExpiring(context.Background(), "user@test.com", "User Name", "", "a-password", 4102444800)
+			assert.NoError(t, err)
+
+			var exported bytes.Buffer
+			assert.NoError(t, source.ExportUsers(context.Background(), &exported, format))
+
+			records, err := repos.DecodeUserImport(&exported, format)
+			assert.NoError(t, err)
+
+			destination, err := repos.NewUserRepository(mocks.NewMockDB(t))
+			assert.NoError(t, err)
+
+			_, err = destination.ImportUsers(context.Background(), records, repos.ImportOptions{Conflict: repos.ConflictFail})
+			assert.NoError(t, err)
+
+			imported, err := destination.FindByEmail(context.Background(), "user@test.com")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(4102444800), imported.ExpiresAt)
+		})
+	}
+
+	t.Run("Refuses unknown formats", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+
+		err := userRepo.ExportUsers(context.Background(), &bytes.Buffer{}, "xml")
+		assert.ErrorIs(t, err, repos.ErrUnsupportedFormat)
+	})
+}
+
+func TestUserRepository_ImportUsers(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Generates passwords for new users without one", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		records, err := repos.DecodeUserImport(strings.NewReader("email,name\nnew@test.com,New User\n"), repos.TransferFormatCSV)
+		assert.NoError(t, err)
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Created)
+		assert.NotEmpty(t, report.Results[0].Password)
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "new@test.com", report.Results[0].Password)
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+	})
+
+	t.Run("Hashes passwords outside the write timeout", func(t *testing.T) {
+		t.Parallel()
+
+		records := make([]repos.UserImportRecord, 0, 8)
+		for _, email := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			records = append(records, repos.UserImportRecord{User: repos.User{Email: email + "@test.com"}})
+		}
+
+		userRepo := mocks.NewMockUserRepository(t)
+		userRepo.SetTimeouts(repos.Timeouts{Write: time.Millisecond * 250})
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, len(records), report.Created)
+	})
+
+	t.Run("Hashes clear text passwords", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		records, err := repos.DecodeUserImport(strings.NewReader(`[{"email": "new@test.com", "password": "from-radius"}]`), repos.TransferFormatJSON)
+		assert.NoError(t, err)
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, report.Results[0].Password)
+
+		user, err := userRepo.FindByEmail(context.Background(), "new@test.com")
+		assert.NoError(t, err)
+		assert.NotEqual(t, "from-radius", user.PasswordHash)
+		assert.True(t, user.PasswordMatch("from-radius"))
+	})
+
+	t.Run("Dry run does not write", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		records := []repos.UserImportRecord{{User: repos.User{Email: "new@test.com"}}}
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{DryRun: true})
+		assert.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.Equal(t, 1, report.Created)
+		assert.Empty(t, report.Results[0].Password)
+		assert.False(t, userRepo.Exists(context.Background(), "new@test.com"))
+	})
+
+	t.Run("Applies conflict policies", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.Create(context.Background(), "existing@test.com", "Old Name", "", "a-password")
+		assert.NoError(t, err)
+
+		records := []repos.UserImportRecord{
+			{User: repos.User{Email: "existing@test.com", Name: "New Name"}},
+			{User: repos.User{Email: "new@test.com"}},
+		}
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{Conflict: repos.ConflictSkip})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Skipped)
+		assert.Equal(t, 1, report.Created)
+
+		user, _ := userRepo.FindByEmail(context.Background(), "existing@test.com")
+		assert.Equal(t, "Old Name", user.Name)
+
+		report, err = userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{Conflict: repos.ConflictOverwrite})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Overwritten)
+
+		user, _ = userRepo.FindByEmail(context.Background(), "existing@test.com")
+		assert.Equal(t, "New Name", user.Name)
+	})
+
+	t.Run("Fail policy aborts the whole import", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		_, err := userRepo.Create(context.Background(), "existing@test.com", "Old Name", "", "a-password")
+		assert.NoError(t, err)
+
+		records := []repos.UserImportRecord{
+			{User: repos.User{Email: "new@test.com"}},
+			{User: repos.User{Email: "existing@test.com"}},
+		}
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{Conflict: repos.ConflictFail})
+		assert.ErrorIs(t, err, repos.ErrImportConflict)
+		assert.NotNil(t, report)
+		assert.Equal(t, repos.ImportStatusConflict, report.Results[1].Status)
+		assert.Empty(t, report.Results[0].Password)
+		assert.False(t, userRepo.Exists(context.Background(), "new@test.com"))
+	})
+
+	t.Run("Reports invalid records", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		records := []repos.UserImportRecord{
+			{User: repos.User{Email: "not-an-email"}},
+			{User: repos.User{Email: "short@test.com"}, Password: "123"},
+		}
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Invalid)
+		assert.Equal(t, repos.ImportStatusInvalid, report.Results[0].Status)
+		assert.NotEmpty(t, report.Results[0].Reason)
+	})
+
+	t.Run("Keeps argon2id password hashes", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		records := []repos.UserImportRecord{{User: repos.User{Email: "hashed@test.com", PasswordHash: importedPasswordHash}}}
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Created)
+
+		authenticated, err := userRepo.Authenticate(context.Background(), "hashed@test.com", "imported-password")
+		assert.NoError(t, err)
+		assert.True(t, authenticated)
+	})
+
+	t.Run("Refuses password hashes that are not argon2id", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		records := []repos.UserImportRecord{
+			{User: repos.User{Email: "plain@test.com", PasswordHash: "not-a-real-hash"}},
+			{User: repos.User{Email: "bcrypt@test.com", PasswordHash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"}},
+			{User: repos.User{Email: "weak@test.com", PasswordHash: "$argon2id$v=19$m=1,t=1,p=2$5GpJksUpTq8g0CIIlTb0bw$CWKRX4ZIKoP5L1qzmEimxoeqEGxhUIdScqZSNE5S/6I"}},
+		}
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, report.Invalid)
+		assert.False(t, userRepo.Exists(context.Background(), "plain@test.com"))
+	})
+
+	t.Run("Refuses emails outside the allowed domain", func(t *testing.T) {
+		t.Parallel()
+
+		userRepo := mocks.NewMockUserRepository(t)
+		records := []repos.UserImportRecord{
+			{User: repos.User{Email: "inside@test.com"}},
+			{User: repos.User{Email: "outside@other.com"}},
+			{User: repos.User{Email: "lookalike@eviltest.com"}},
+		}
+
+		report, err := userRepo.ImportUsers(context.Background(), records, repos.ImportOptions{AllowedDomain: "Test.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 2, report.Invalid)
+		assert.Equal(t, repos.ImportStatusInvalid, report.Results[1].Status)
+		assert.False(t, userRepo.Exists(context.Background(), "outside@other.com"))
+	})
+}
+
+func TestDecodeUserImport(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Requires an email column in csv", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := repos.DecodeUserImport(strings.NewReader("name\nNo Email\n"), repos.TransferFormatCSV)
+		assert.ErrorIs(t, err, repos.ErrInvalidRecord)
+	})
+
+	t.Run("Refuses unknown formats", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := repos.DecodeUserImport(strings.NewReader(""), "ldif")
+		assert.ErrorIs(t, err, repos.ErrUnsupportedFormat)
+	})
+}
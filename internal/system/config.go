@@ -4,31 +4,120 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+// PasswordHashConfig holds the argon2id parameters, memory is in KiB.
+type PasswordHashConfig struct {
+	Memory      uint32 `mapstructure:"memory"`
+	Iterations  uint32 `mapstructure:"iterations"`
+	Parallelism uint8  `mapstructure:"parallelism"`
+}
+
+// Defaults match argon2id.DefaultParams so existing hashes are not upgraded needlessly.
+const (
+	defaultHashMemory      = 64 * 1024
+	defaultHashIterations  = 1
+	defaultHashParallelism = 2
+)
+
+const (
+	defaultStorageReadTimeout  = 5 * time.Second
+	defaultStorageWriteTimeout = 10 * time.Second
+)
+
+const (
+	defaultReaperInactiveAfter   = 90 * 24 * time.Hour
+	defaultReaperWarnBefore      = 14 * 24 * time.Hour
+	defaultReaperInterval        = 24 * time.Hour
+	defaultSnapshotsInterval     = 24 * time.Hour
+	defaultSnapshotsRetention    = 7
+	defaultIPPoolLease           = 24 * time.Hour
+	defaultMaxUserLeases         = 4
+	defaultLeaseExpiryInterval   = 5 * time.Minute
+	defaultGroupsSyncInterval    = time.Hour
+	defaultDirectorySyncInterval = time.Hour
+	defaultSessionLifetime       = 7 * 24 * time.Hour
+	defaultKeyRotationInterval   = 30 * 24 * time.Hour
+	defaultKeyRotationOverlap    = 24 * time.Hour
+)
+
+// SecurityConfig restricts who may log in. Refresh tokens issued at login stay valid for SessionLifetime.
 type SecurityConfig struct {
-	AllowedDomain         string   `mapstructure:"allowed-domain"`
-	AuthorizedAdminEmails []string `mapstructure:"admin-emails"` //nolint:tagliatelle
+	AllowedDomain         string             `mapstructure:"allowed-domain"`
+	AuthorizedAdminEmails []string           `mapstructure:"admin-emails"`    //nolint:tagliatelle
+	HelpdeskEmails        []string           `mapstructure:"helpdesk-emails"` //nolint:tagliatelle
+	AuditorEmails         []string           `mapstructure:"auditor-emails"`  //nolint:tagliatelle
+	PasswordHash          PasswordHashConfig `mapstructure:"password-hash"`
+	SessionLifetime       time.Duration      `mapstructure:"session-lifetime"`
+	Signing               SigningConfig      `mapstructure:"signing"`
+}
+
+// SigningConfig selects how access tokens are signed. ES256 and EdDSA keys are replaced every RotationInterval,
+// the previous key keeps verifying tokens for Overlap. HS256 signs with the JWT secret and never rotates.
+type SigningConfig struct {
+	Algorithm        string        `mapstructure:"algorithm"`
+	RotationInterval time.Duration `mapstructure:"rotation-interval"`
+	Overlap          time.Duration `mapstructure:"overlap"`
 }
 
+// WebConfig describes the web server. CookieSessions keeps the tokens of browsers in HttpOnly cookies instead
+// of local storage, state-changing requests authenticated by cookie must then send the CSRF token.
 type WebConfig struct {
 	Domain         string   `mapstructure:"domain"`
 	UseLetsEncrypt bool     `mapstructure:"lets-encrypt"` //nolint:tagliatelle
 	AllowOrigins   []string `mapstructure:"allow-origins"`
 	ReverseProxy   string   `mapstructure:"reverse-proxy"`
+	CookieSessions bool     `mapstructure:"cookie-sessions"`
 }
 
+// StorageConfig locates the data files. Database operations taking longer than ReadTimeout or WriteTimeout fail.
+// Encryption encrypts user columns with a master key read from MasterKeyFile, or the storage secret when empty.
 type StorageConfig struct {
-	UserDatabaseFile string `mapstructure:"user-database"` //nolint:tagliatelle
-	SecretsFile      string `mapstructure:"secrets-file"`
+	UserDatabaseFile string        `mapstructure:"user-database"` //nolint:tagliatelle
+	SecretsFile      string        `mapstructure:"secrets-file"`
+	ReadTimeout      time.Duration `mapstructure:"read-timeout"`
+	WriteTimeout     time.Duration `mapstructure:"write-timeout"`
+	Encryption       bool          `mapstructure:"encryption"`
+	MasterKeyFile    string        `mapstructure:"master-key-file"`
+}
+
+// IPPoolConfig is a named CIDR range leased to users for Lease.
+type IPPoolConfig struct {
+	Name  string        `mapstructure:"name"`
+	CIDR  string        `mapstructure:"cidr"`
+	Lease time.Duration `mapstructure:"lease"`
+}
+
+// NASClientConfig selects the OTPMode, "concatenated" or "challenge", of the NAS at Address, an IP or a CIDR network.
+type NASClientConfig struct {
+	Name    string `mapstructure:"name"`
+	Address string `mapstructure:"address"`
+	OTPMode string `mapstructure:"otp-mode"`
+}
+
+// RadiusConfig maps RADIUS reply attribute names, such as Class or Filter-Id, to the user attribute copied in Access-Accept.
+// Users are leased an address from the pool named by their PoolAttribute, or from DefaultPool.
+// Users enrolled in TOTP send their code as selected by OTPMode, unless their NAS matches one of NASClients.
+type RadiusConfig struct {
+	ReplyAttributes     map[string]string `mapstructure:"reply-attributes"`
+	IPPools             []IPPoolConfig    `mapstructure:"ip-pools"`
+	DefaultPool         string            `mapstructure:"default-ip-pool"`
+	PoolAttribute       string            `mapstructure:"ip-pool-attribute"`
+	LeaseExpiryInterval time.Duration     `mapstructure:"lease-expiry-interval"`
+	MaxUserLeases       int               `mapstructure:"max-leases-per-user"`
+	TOTPIssuer          string            `mapstructure:"totp-issuer"`
+	OTPMode             string            `mapstructure:"otp-mode"`
+	NASClients          []NASClientConfig `mapstructure:"nas-clients"`
 }
 
 type ServicesConfig struct {
-	HTTPBindAddress   string `mapstructure:"http-bind-address"`
-	HTTPSBindAddress  string `mapstructure:"https-bind-address"`
-	RadiusBindAddress string `mapstructure:"radius-bind-address"`
+	HTTPBindAddress             string `mapstructure:"http-bind-address"`
+	HTTPSBindAddress            string `mapstructure:"https-bind-address"`
+	RadiusBindAddress           string `mapstructure:"radius-bind-address"`
+	RadiusAccountingBindAddress string `mapstructure:"radius-accounting-bind-address"`
 }
 
 type GoogleConfig struct {
@@ -36,16 +125,115 @@ type GoogleConfig struct {
 	ClientSecret string `mapstructure:"client-secret"`
 }
 
+// OIDCClaimsConfig names the ID token claims holding the user email, name, picture and groups.
+type OIDCClaimsConfig struct {
+	Email   string `mapstructure:"email"`
+	Name    string `mapstructure:"name"`
+	Picture string `mapstructure:"picture"`
+	Groups  string `mapstructure:"groups"`
+}
+
+// OIDCProviderConfig is an OpenID Connect provider, such as Keycloak, Okta or Entra ID, discovered from Issuer.
+// Name identifies the provider in its callback URL, https://<web.domain>/auth/<name>/callback.
+// AllowedDomain replaces security.allowed-domain for the users of the provider when set.
+type OIDCProviderConfig struct {
+	Name                      string           `mapstructure:"name"`
+	DisplayName               string           `mapstructure:"display-name"`
+	Issuer                    string           `mapstructure:"issuer"`
+	ClientID                  string           `mapstructure:"client-id"`
+	ClientSecret              string           `mapstructure:"client-secret"`
+	Scopes                    []string         `mapstructure:"scopes"`
+	Claims                    OIDCClaimsConfig `mapstructure:"claims"`
+	AllowedDomain             string           `mapstructure:"allowed-domain"`
+	AllowMissingEmailVerified bool             `mapstructure:"allow-missing-email-verified"`
+}
+
 type OAuthConfig struct {
-	Google GoogleConfig `mapstructure:"google"`
+	Google    GoogleConfig         `mapstructure:"google"`
+	Providers []OIDCProviderConfig `mapstructure:"providers"`
+}
+
+// SAMLAttributesConfig names the assertion attributes holding the user email, name, role and groups.
+// The email is read from the NameID when Email is empty, a Role naming a fringe role applies to users without one.
+type SAMLAttributesConfig struct {
+	Email  string `mapstructure:"email"`
+	Name   string `mapstructure:"name"`
+	Role   string `mapstructure:"role"`
+	Groups string `mapstructure:"groups"`
+}
+
+// SAMLConfig registers fringe as a SAML 2.0 service provider of the identity provider at IdPSSOURL.
+// Assertions must be signed with a certificate of the PEM file IdPCertificateFile.
+// EntityID defaults to the metadata URL, https://<web.domain>/auth/saml/metadata.
+type SAMLConfig struct {
+	Enabled            bool                 `mapstructure:"enabled"`
+	DisplayName        string               `mapstructure:"display-name"`
+	EntityID           string               `mapstructure:"entity-id"`
+	IdPEntityID        string               `mapstructure:"idp-entity-id"`
+	IdPSSOURL          string               `mapstructure:"idp-sso-url"`
+	IdPCertificateFile string               `mapstructure:"idp-certificate-file"`
+	Attributes         SAMLAttributesConfig `mapstructure:"attributes"`
+}
+
+// DirectoryConfig reads the Google Workspace directory with the service account key of ServiceAccountFile.
+// The service account is granted domain-wide delegation and acts as AdminEmail, a Workspace administrator.
+// With Sync, users of the allowed domain suspended or missing from the directory are disabled every SyncInterval,
+// except those listed in Exclude, and their name and picture follow the directory.
+type DirectoryConfig struct {
+	ServiceAccountFile string        `mapstructure:"service-account-file"`
+	AdminEmail         string        `mapstructure:"admin-email"`
+	Sync               bool          `mapstructure:"sync"`
+	SyncInterval       time.Duration `mapstructure:"sync-interval"`
+	Exclude            []string      `mapstructure:"exclude"`
+}
+
+// GroupMappingConfig grants members of Group, a group email or name, the fringe Role and the RADIUS group RadiusGroup.
+type GroupMappingConfig struct {
+	Group       string `mapstructure:"group"`
+	Role        string `mapstructure:"role"`
+	RadiusGroup string `mapstructure:"radius-group"`
+}
+
+// GroupsConfig restricts login to members of the Mappings groups. RADIUS groups are stored in the user attribute
+// RadiusAttribute. With a directory, groups are synced every SyncInterval and users who left them are disabled.
+type GroupsConfig struct {
+	Mappings        []GroupMappingConfig `mapstructure:"mappings"`
+	RadiusAttribute string               `mapstructure:"radius-attribute"`
+	SyncInterval    time.Duration        `mapstructure:"sync-interval"`
+}
+
+// ReaperConfig controls the job disabling or deleting users inactive for InactiveAfter.
+// Users are warned WarnBefore the action and accounts listed in Exclude are never reaped.
+type ReaperConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	InactiveAfter time.Duration `mapstructure:"inactive-after"`
+	WarnBefore    time.Duration `mapstructure:"warn-before"`
+	Action        string        `mapstructure:"action"`
+	Interval      time.Duration `mapstructure:"interval"`
+	Exclude       []string      `mapstructure:"exclude"`
+}
+
+// SnapshotsConfig controls the job writing a database snapshot to Directory every Interval.
+// Only the Retention most recent snapshots are kept.
+type SnapshotsConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Directory string        `mapstructure:"directory"`
+	Interval  time.Duration `mapstructure:"interval"`
+	Retention int           `mapstructure:"retention"`
 }
 
 type Config struct {
-	OAuth    OAuthConfig    `mapstructure:"oauth"` //nolint:tagliatelle
-	Security SecurityConfig `mapstructure:"security"`
-	Services ServicesConfig `mapstructure:"services"`
-	Storage  StorageConfig  `mapstructure:"storage"`
-	Web      WebConfig      `mapstructure:"web"`
+	Directory DirectoryConfig `mapstructure:"directory"`
+	Groups    GroupsConfig    `mapstructure:"groups"`
+	OAuth     OAuthConfig     `mapstructure:"oauth"` //nolint:tagliatelle
+	Radius    RadiusConfig    `mapstructure:"radius"`
+	Reaper    ReaperConfig    `mapstructure:"reaper"`
+	SAML      SAMLConfig      `mapstructure:"saml"`
+	Security  SecurityConfig  `mapstructure:"security"`
+	Services  ServicesConfig  `mapstructure:"services"`
+	Snapshots SnapshotsConfig `mapstructure:"snapshots"`
+	Storage   StorageConfig   `mapstructure:"storage"`
+	Web       WebConfig       `mapstructure:"web"`
 }
 
 func LoadConfig(viperConf *viper.Viper) Config {
@@ -53,12 +241,44 @@ func LoadConfig(viperConf *viper.Viper) Config {
 	viperConf.SetDefault("services.https-bind-address", ":443")
 	viperConf.SetDefault("services.http-bind-address", ":80")
 	viperConf.SetDefault("services.radius-bind-address", ":1812")
+	viperConf.SetDefault("services.radius-accounting-bind-address", ":1813")
 
 	localIP := FirstLocalIP(AllLocalIPAddresses()).String()
 	viperConf.SetDefault("web.domain", localIP)
 	viperConf.SetDefault("web.lets-encrypt", true)
+	viperConf.SetDefault("web.cookie-sessions", false)
 	viperConf.SetDefault("storage.user-database", "/var/lib/fringe/users.repos")
 	viperConf.SetDefault("storage.secrets-file", "/var/lib/fringe/secrets.json")
+	viperConf.SetDefault("storage.read-timeout", defaultStorageReadTimeout)
+	viperConf.SetDefault("storage.write-timeout", defaultStorageWriteTimeout)
+	viperConf.SetDefault("storage.encryption", false)
+	viperConf.SetDefault("storage.master-key-file", "")
+	viperConf.SetDefault("security.password-hash.memory", defaultHashMemory)
+	viperConf.SetDefault("security.password-hash.iterations", defaultHashIterations)
+	viperConf.SetDefault("security.password-hash.parallelism", defaultHashParallelism)
+	viperConf.SetDefault("security.session-lifetime", defaultSessionLifetime)
+	viperConf.SetDefault("security.signing.algorithm", "ES256")
+	viperConf.SetDefault("security.signing.rotation-interval", defaultKeyRotationInterval)
+	viperConf.SetDefault("security.signing.overlap", defaultKeyRotationOverlap)
+	viperConf.SetDefault("reaper.enabled", false)
+	viperConf.SetDefault("reaper.inactive-after", defaultReaperInactiveAfter)
+	viperConf.SetDefault("reaper.warn-before", defaultReaperWarnBefore)
+	viperConf.SetDefault("reaper.action", "disable")
+	viperConf.SetDefault("reaper.interval", defaultReaperInterval)
+	viperConf.SetDefault("snapshots.enabled", false)
+	viperConf.SetDefault("snapshots.directory", "/var/lib/fringe/snapshots")
+	viperConf.SetDefault("snapshots.interval", defaultSnapshotsInterval)
+	viperConf.SetDefault("snapshots.retention", defaultSnapshotsRetention)
+	viperConf.SetDefault("radius.lease-expiry-interval", defaultLeaseExpiryInterval)
+	viperConf.SetDefault("radius.max-leases-per-user", defaultMaxUserLeases)
+	viperConf.SetDefault("radius.totp-issuer", "Fringe")
+	viperConf.SetDefault("radius.otp-mode", "concatenated")
+	viperConf.SetDefault("saml.enabled", false)
+	viperConf.SetDefault("saml.display-name", "SAML")
+	viperConf.SetDefault("groups.radius-attribute", "radius-group")
+	viperConf.SetDefault("groups.sync-interval", defaultGroupsSyncInterval)
+	viperConf.SetDefault("directory.sync", false)
+	viperConf.SetDefault("directory.sync-interval", defaultDirectorySyncInterval)
 
 	// Read the configuration
 	if err := viperConf.ReadInConfig(); err != nil {
@@ -82,5 +302,39 @@ func LoadConfig(viperConf *viper.Viper) Config {
 		log.Panicf("could not parse configuration: %v", err)
 	}
 
+	// Defaults cannot be set on array tables
+	for i := range config.Radius.IPPools {
+		if config.Radius.IPPools[i].Lease == 0 {
+			config.Radius.IPPools[i].Lease = defaultIPPoolLease
+		}
+	}
+
+	for i := range config.OAuth.Providers {
+		setOIDCProviderDefaults(&config.OAuth.Providers[i])
+	}
+
 	return config
 }
+
+func setOIDCProviderDefaults(provider *OIDCProviderConfig) {
+	if len(provider.DisplayName) == 0 {
+		provider.DisplayName = provider.Name
+	}
+
+	if len(provider.Scopes) == 0 {
+		provider.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	defaults := map[*string]string{
+		&provider.Claims.Email:   "email",
+		&provider.Claims.Name:    "name",
+		&provider.Claims.Picture: "picture",
+		&provider.Claims.Groups:  "groups",
+	}
+
+	for claim, name := range defaults {
+		if len(*claim) == 0 {
+			*claim = name
+		}
+	}
+}
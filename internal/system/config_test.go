@@ -3,6 +3,7 @@ package system_test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/p-l/fringe/internal/system"
 	"github.com/spf13/viper"
@@ -51,5 +52,241 @@ func TestLoadConfig(t *testing.T) {
 		assert.NotEmpty(t, config.Services.HTTPBindAddress)
 		assert.NotEmpty(t, config.Services.HTTPSBindAddress)
 		assert.NotEmpty(t, config.Services.RadiusBindAddress)
+		assert.Equal(t, uint32(64*1024), config.Security.PasswordHash.Memory)
+		assert.Equal(t, uint32(1), config.Security.PasswordHash.Iterations)
+		assert.Equal(t, uint8(2), config.Security.PasswordHash.Parallelism)
+		assert.False(t, config.Reaper.Enabled)
+		assert.Equal(t, "disable", config.Reaper.Action)
+		assert.Equal(t, 90*24*time.Hour, config.Reaper.InactiveAfter)
+		assert.Equal(t, 5*time.Second, config.Storage.ReadTimeout)
+		assert.Equal(t, 10*time.Second, config.Storage.WriteTimeout)
+		assert.False(t, config.Storage.Encryption)
+		assert.False(t, config.Snapshots.Enabled)
+		assert.Equal(t, 24*time.Hour, config.Snapshots.Interval)
+		assert.Equal(t, 7, config.Snapshots.Retention)
+		assert.Empty(t, config.Storage.MasterKeyFile)
+	})
+
+	t.Run("Parses reaper durations", func(t *testing.T) {
+		t.Parallel()
+
+		viperConf := newMockViperConfig(t)
+		viperConf.Set("reaper.warn-before", "72h")
+		config := system.LoadConfig(viperConf)
+
+		assert.Equal(t, 72*time.Hour, config.Reaper.WarnBefore)
+	})
+
+	t.Run("Parses storage timeouts", func(t *testing.T) {
+		t.Parallel()
+
+		viperConf := newMockViperConfig(t)
+		viperConf.Set("storage.write-timeout", "1m")
+		config := system.LoadConfig(viperConf)
+
+		assert.Equal(t, time.Minute, config.Storage.WriteTimeout)
+	})
+
+	t.Run("Parses radius reply attributes", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		viperConf := viper.New()
+		viperConf.SetConfigName("config")
+		viperConf.SetConfigType("toml")
+		viperConf.AddConfigPath(tempDir)
+
+		content := "[radius.reply-attributes]\nClass = \"vpn-class\"\nFilter-Id = \"vpn-filter\"\n"
+		assert.NoError(t, os.WriteFile(tempDir+"/config.toml", []byte(content), 0o600))
+
+		config := system.LoadConfig(viperConf)
+
+		// Viper keys are case insensitive, names are matched without case
+		assert.Equal(t, map[string]string{"class": "vpn-class", "filter-id": "vpn-filter"}, config.Radius.ReplyAttributes)
+	})
+
+	t.Run("Parses radius ip pools", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		viperConf := viper.New()
+		viperConf.SetConfigName("config")
+		viperConf.SetConfigType("toml")
+		viperConf.AddConfigPath(tempDir)
+
+		content := "[radius]\ndefault-ip-pool = \"office\"\n" +
+			"[[radius.ip-pools]]\nname = \"office\"\ncidr = \"10.8.0.0/24\"\nlease = \"1h\"\n" +
+			"[[radius.ip-pools]]\nname = \"lab\"\ncidr = \"10.9.0.0/24\"\n"
+		assert.NoError(t, os.WriteFile(tempDir+"/config.toml", []byte(content), 0o600))
+
+		config := system.LoadConfig(viperConf)
+
+		assert.Equal(t, "office", config.Radius.DefaultPool)
+		assert.Equal(t, []system.IPPoolConfig{
+			{Name: "office", CIDR: "10.8.0.0/24", Lease: time.Hour},
+			{Name: "lab", CIDR: "10.9.0.0/24", Lease: 24 * time.Hour},
+		}, config.Radius.IPPools)
+		assert.Equal(t, ":1813", config.Services.RadiusAccountingBindAddress)
+	})
+
+	t.Run("Parses radius nas clients", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		viperConf := viper.New()
+		viperConf.SetConfigName("config")
+		viperConf.SetConfigType("toml")
+		viperConf.AddConfigPath(tempDir)
+
+		content := "[radius]\n" +
+			"[[radius.nas-clients]]\nname = \"vpn\"\naddress = \"10.0.0.1\"\notp-mode = \"challenge\"\n"
+		assert.NoError(t, os.WriteFile(tempDir+"/config.toml", []byte(content), 0o600))
+
+		config := system.LoadConfig(viperConf)
+
+		assert.Equal(t, "concatenated", config.Radius.OTPMode)
+		assert.Equal(t, "Fringe", config.Radius.TOTPIssuer)
+		assert.Equal(t, []system.NASClientConfig{{Name: "vpn", Address: "10.0.0.1", OTPMode: "challenge"}}, config.Radius.NASClients)
+	})
+	t.Run("Parses oidc providers with default scopes and claims", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		viperConf := viper.New()
+		viperConf.SetConfigName("config")
+		viperConf.SetConfigType("toml")
+		viperConf.AddConfigPath(tempDir)
+
+		content := "[oauth]\n" +
+			"[[oauth.providers]]\nname = \"keycloak\"\nissuer = \"https://sso.test.com/realms/main\"\nclient-id = \"fringe\"\n" +
+			"[[oauth.providers]]\nname = \"entra\"\ndisplay-name = \"Entra ID\"\nscopes = [\"openid\", \"email\"]\n" +
+			"[oauth.providers.claims]\nemail = \"preferred_username\"\n"
+		assert.NoError(t, os.WriteFile(tempDir+"/config.toml", []byte(content), 0o600))
+
+		config := system.LoadConfig(viperConf)
+
+		assert.Len(t, config.OAuth.Providers, 2)
+		keycloak := config.OAuth.Providers[0]
+		assert.Equal(t, "keycloak", keycloak.DisplayName)
+		assert.Equal(t, "https://sso.test.com/realms/main", keycloak.Issuer)
+		assert.Equal(t, []string{"openid", "email", "profile"}, keycloak.Scopes)
+		assert.Equal(t, system.OIDCClaimsConfig{Email: "email", Name: "name", Picture: "picture", Groups: "groups"}, keycloak.Claims)
+
+		entra := config.OAuth.Providers[1]
+		assert.Equal(t, "Entra ID", entra.DisplayName)
+		assert.Equal(t, []string{"openid", "email"}, entra.Scopes)
+		assert.Equal(t, "preferred_username", entra.Claims.Email)
+		assert.Equal(t, "groups", entra.Claims.Groups)
+	})
+	t.Run("Parses saml settings with defaults", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		viperConf := viper.New()
+		viperConf.SetConfigName("config")
+		viperConf.SetConfigType("toml")
+		viperConf.AddConfigPath(tempDir)
+
+		content := "[saml]\nenabled = true\nidp-entity-id = \"https://idp.test.com/saml\"\n" +
+			"idp-sso-url = \"https://idp.test.com/saml/sso\"\nidp-certificate-file = \"/etc/fringe/idp.pem\"\n" +
+			"[saml.attributes]\nemail = \"mail\"\nrole = \"fringeRole\"\n"
+		assert.NoError(t, os.WriteFile(tempDir+"/config.toml", []byte(content), 0o600))
+
+		config := system.LoadConfig(viperConf)
+
+		assert.True(t, config.SAML.Enabled)
+		assert.Equal(t, "SAML", config.SAML.DisplayName)
+		assert.Empty(t, config.SAML.EntityID)
+		assert.Equal(t, "https://idp.test.com/saml", config.SAML.IdPEntityID)
+		assert.Equal(t, "https://idp.test.com/saml/sso", config.SAML.IdPSSOURL)
+		assert.Equal(t, "/etc/fringe/idp.pem", config.SAML.IdPCertificateFile)
+		assert.Equal(t, system.SAMLAttributesConfig{Email: "mail", Role: "fringeRole"}, config.SAML.Attributes)
+	})
+	t.Run("Parses group mappings and directory", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		viperConf := viper.New()
+		viperConf.SetConfigName("config")
+		viperConf.SetConfigType("toml")
+		viperConf.AddConfigPath(tempDir)
+
+		content := "[directory]\nservice-account-file = \"/etc/fringe/service-account.json\"\nadmin-email = \"admin@test.com\"\n" +
+			"[groups]\n" +
+			"[[groups.mappings]]\ngroup = \"admins@test.com\"\nrole = \"admin\"\nradius-group = \"admins\"\n" +
+			"[[groups.mappings]]\ngroup = \"staff@test.com\"\n"
+		assert.NoError(t, os.WriteFile(tempDir+"/config.toml", []byte(content), 0o600))
+
+		config := system.LoadConfig(viperConf)
+
+		assert.Equal(t, system.DirectoryConfig{ServiceAccountFile: "/etc/fringe/service-account.json", AdminEmail: "admin@test.com", SyncInterval: time.Hour}, config.Directory)
+		assert.Equal(t, []system.GroupMappingConfig{
+			{Group: "admins@test.com", Role: "admin", RadiusGroup: "admins"},
+			{Group: "staff@test.com"},
+		}, config.Groups.Mappings)
+		assert.Equal(t, "radius-group", config.Groups.RadiusAttribute)
+		assert.Equal(t, time.Hour, config.Groups.SyncInterval)
+	})
+	t.Run("Parses directory sync", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		viperConf := viper.New()
+		viperConf.SetConfigName("config")
+		viperConf.SetConfigType("toml")
+		viperConf.AddConfigPath(tempDir)
+
+		content := "[directory]\nservice-account-file = \"/etc/fringe/service-account.json\"\nadmin-email = \"admin@test.com\"\n" +
+			"sync = true\nsync-interval = \"15m\"\nexclude = [\"radius-test@test.com\"]\n"
+		assert.NoError(t, os.WriteFile(tempDir+"/config.toml", []byte(content), 0o600))
+
+		config := system.LoadConfig(viperConf)
+
+		assert.True(t, config.Directory.Sync)
+		assert.Equal(t, 15*time.Minute, config.Directory.SyncInterval)
+		assert.Equal(t, []string{"radius-test@test.com"}, config.Directory.Exclude)
+	})
+	t.Run("Parses session lifetime", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		viperConf := viper.New()
+		viperConf.SetConfigName("config")
+		viperConf.SetConfigType("toml")
+		viperConf.AddConfigPath(tempDir)
+
+		content := "[security]\nsession-lifetime = \"12h\"\n"
+		assert.NoError(t, os.WriteFile(tempDir+"/config.toml", []byte(content), 0o600))
+
+		config := system.LoadConfig(viperConf)
+
+		assert.Equal(t, 12*time.Hour, config.Security.SessionLifetime)
+		assert.Equal(t, system.SigningConfig{Algorithm: "ES256", RotationInterval: 30 * 24 * time.Hour, Overlap: 24 * time.Hour}, config.Security.Signing)
+	})
+
+	t.Run("Parses token signing", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		viperConf := viper.New()
+		viperConf.SetConfigName("config")
+		viperConf.SetConfigType("toml")
+		viperConf.AddConfigPath(tempDir)
+
+		content := "[security.signing]\nalgorithm = \"EdDSA\"\nrotation-interval = \"168h\"\noverlap = \"6h\"\n"
+		assert.NoError(t, os.WriteFile(tempDir+"/config.toml", []byte(content), 0o600))
+
+		config := system.LoadConfig(viperConf)
+
+		assert.Equal(t, system.SigningConfig{Algorithm: "EdDSA", RotationInterval: 168 * time.Hour, Overlap: 6 * time.Hour}, config.Security.Signing)
+	})
+	t.Run("Parses cookie sessions", func(t *testing.T) {
+		t.Parallel()
+
+		viperConf := newMockViperConfig(t)
+		assert.False(t, system.LoadConfig(viperConf).Web.CookieSessions)
+
+		viperConf.Set("web.cookie-sessions", true)
+		assert.True(t, system.LoadConfig(viperConf).Web.CookieSessions)
 	})
 }
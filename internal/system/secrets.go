@@ -7,18 +7,27 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/sethvargo/go-password/password"
 )
 
+// Secrets are generated on first start. Storage is the master key of the user database encryption,
+// it is only used when no master key file is configured. Audit keys the hashes of the audit log,
+// AuditCreated is set when it was generated while loading: entries written before are not keyed yet.
 type Secrets struct {
-	Radius string `json:"radius"`
-	JWT    string `json:"jwt"`
+	Radius       string `json:"radius"`
+	JWT          string `json:"jwt"`
+	Storage      string `json:"storage"`
+	Audit        string `json:"audit"`
+	AuditCreated bool   `json:"-"`
 }
 
 const (
 	radiusSecretLen       = 64
 	jwtSecretLen          = 128
+	storageSecretLen      = 64
+	auditSecretLen        = 64
 	secretNumDigit        = 2
 	secretNumSymbols      = 2
 	secretsFilePermission = 0o600
@@ -39,8 +48,11 @@ func LoadSecretsFromFile(file string) Secrets {
 	// If file not exist create it
 	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
 		secrets = Secrets{
-			Radius: generateSecret(radiusSecretLen),
-			JWT:    generateSecret(jwtSecretLen),
+			Radius:       generateSecret(radiusSecretLen),
+			JWT:          generateSecret(jwtSecretLen),
+			Storage:      generateSecret(storageSecretLen),
+			Audit:        generateSecret(auditSecretLen),
+			AuditCreated: true,
 		}
 		SaveSecretsToFile(secrets, file)
 
@@ -68,6 +80,17 @@ func LoadSecretsFromFile(file string) Secrets {
 		SaveSecretsToFile(secrets, file)
 	}
 
+	if len(secrets.Storage) == 0 {
+		secrets.Storage = generateSecret(storageSecretLen)
+		SaveSecretsToFile(secrets, file)
+	}
+
+	if len(secrets.Audit) == 0 {
+		secrets.Audit = generateSecret(auditSecretLen)
+		secrets.AuditCreated = true
+		SaveSecretsToFile(secrets, file)
+	}
+
 	return secrets
 }
 
@@ -82,3 +105,14 @@ func SaveSecretsToFile(secrets Secrets, file string) {
 		log.Fatalf("Unable to create JSON to write scecret file: %v", err)
 	}
 }
+
+// LoadMasterKeyFromFile reads the user database master key, surrounding white spaces are ignored.
+// Unlike secrets the key is never generated, losing it makes the user database unreadable.
+func LoadMasterKeyFromFile(file string) string {
+	bytes, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		log.Panicf("could not read master key file %s: %v", file, err)
+	}
+
+	return strings.TrimSpace(string(bytes))
+}
@@ -1,6 +1,7 @@
 package system_test
 
 import (
+	"os"
 	"testing"
 
 	"github.com/p-l/fringe/internal/system"
@@ -19,6 +20,23 @@ func TestLoadSecretsFromFile(t *testing.T) {
 		assert.FileExists(t, filename)
 		assert.NotZero(t, len(secrets.JWT))
 		assert.NotZero(t, len(secrets.Radius))
+		assert.NotZero(t, len(secrets.Storage))
+		assert.NotZero(t, len(secrets.Audit))
+	})
+
+	t.Run("Fills missing Audit secrets on loading and reports it", func(t *testing.T) {
+		t.Parallel()
+
+		filename := t.TempDir() + "/secrets.json"
+		system.SaveSecretsToFile(system.Secrets{Radius: "radius", JWT: "jwt", Storage: "storage"}, filename)
+
+		loadedSecrets := system.LoadSecretsFromFile(filename)
+		assert.NotZero(t, len(loadedSecrets.Audit))
+		assert.True(t, loadedSecrets.AuditCreated)
+
+		reloadedSecrets := system.LoadSecretsFromFile(filename)
+		assert.Equal(t, loadedSecrets.Audit, reloadedSecrets.Audit)
+		assert.False(t, reloadedSecrets.AuditCreated)
 	})
 
 	t.Run("Fills missing Radius secrets on loading", func(t *testing.T) {
@@ -68,4 +86,37 @@ func TestLoadSecretsFromFile(t *testing.T) {
 		assert.Equal(t, "radius", loadedSecrets.Radius)
 		assert.Equal(t, "jwt", loadedSecrets.JWT)
 	})
+
+	t.Run("Fills missing Storage secrets on loading", func(t *testing.T) {
+		t.Parallel()
+
+		filename := t.TempDir() + "/secrets.json"
+		system.SaveSecretsToFile(system.Secrets{Radius: "radius", JWT: "jwt"}, filename)
+
+		loadedSecrets := system.LoadSecretsFromFile(filename)
+		assert.Equal(t, "radius", loadedSecrets.Radius)
+		assert.NotZero(t, len(loadedSecrets.Storage))
+
+		// The generated secret is saved, it must stay the same to read the encrypted database
+		assert.Equal(t, loadedSecrets.Storage, system.LoadSecretsFromFile(filename).Storage)
+	})
+}
+
+func TestLoadMasterKeyFromFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Ignores surrounding white spaces", func(t *testing.T) {
+		t.Parallel()
+
+		filename := t.TempDir() + "/master.key"
+		assert.NoError(t, os.WriteFile(filename, []byte("  a-master-key\n"), 0o600))
+
+		assert.Equal(t, "a-master-key", system.LoadMasterKeyFromFile(filename))
+	})
+
+	t.Run("Panics when the file is missing", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Panics(t, func() { system.LoadMasterKeyFromFile(t.TempDir() + "/missing.key") })
+	})
 }
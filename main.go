@@ -13,6 +13,9 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/p-l/fringe/client"
 	"github.com/p-l/fringe/internal/httpd"
+	"github.com/p-l/fringe/internal/httpd/helpers"
+	"github.com/p-l/fringe/internal/httpd/services"
+	"github.com/p-l/fringe/internal/jobs"
 	"github.com/p-l/fringe/internal/radiusd"
 	"github.com/p-l/fringe/internal/repos"
 	"github.com/p-l/fringe/internal/system"
@@ -22,7 +25,12 @@ import (
 	"modernc.org/ql"
 )
 
-const terminationWait = time.Second * 5
+const (
+	terminationWait      = time.Second * 5
+	exportFilePermission = 0o600
+	// keyRotationCheckInterval bounds how late a signing key may be replaced.
+	keyRotationCheckInterval = 10 * time.Minute
+)
 
 func openDB(databaseFile string) *sqlx.DB {
 	// Initialize Database connexion
@@ -36,22 +44,324 @@ func openDB(databaseFile string) *sqlx.DB {
 	return db
 }
 
-func openUserRepo(connexion *sqlx.DB) *repos.UserRepository {
+func storageTimeouts(config system.Config) repos.Timeouts {
+	return repos.Timeouts{Read: config.Storage.ReadTimeout, Write: config.Storage.WriteTimeout}
+}
+
+func openUserRepo(connexion *sqlx.DB, config system.Config, secrets system.Secrets) *repos.UserRepository {
 	userRepo, err := repos.NewUserRepository(connexion)
 	if err != nil {
 		log.Panicf("could not initate user repository: %v", err)
 	}
 
+	hashConfig := config.Security.PasswordHash
+
+	hashParams, err := repos.NewHashParams(hashConfig.Memory, hashConfig.Iterations, hashConfig.Parallelism)
+	if err != nil {
+		log.Panicf("invalid password-hash configuration: %v", err)
+	}
+
+	userRepo.SetHashParams(hashParams)
+	userRepo.SetTimeouts(storageTimeouts(config))
+	enableEncryption(connexion, config, secrets, userRepo)
+
 	return userRepo
 }
 
-func newWebServers(config system.Config, userRepo *repos.UserRepository, jwtSecret string) (*http.Server, *http.Server) {
+// enableEncryption encrypts users when configured to, an encrypted database cannot be used without encryption.
+func enableEncryption(connexion *sqlx.DB, config system.Config, secrets system.Secrets, userRepo *repos.UserRepository) {
+	if !config.Storage.Encryption {
+		encrypted, err := userRepo.IsEncrypted(context.Background())
+		if err != nil {
+			log.Panicf("could not check user database encryption: %v", err)
+		}
+
+		if encrypted {
+			log.Panicf("user database is encrypted, storage.encryption must be enabled")
+		}
+
+		return
+	}
+
+	masterKey := secrets.Storage
+	if len(config.Storage.MasterKeyFile) > 0 {
+		masterKey = system.LoadMasterKeyFromFile(config.Storage.MasterKeyFile)
+	}
+
+	cipher, err := repos.NewFieldCipher(connexion, masterKey)
+	if err != nil {
+		log.Panicf("could not initiate user database encryption: %v", err)
+	}
+
+	if err := userRepo.SetCipher(context.Background(), cipher); err != nil {
+		log.Panicf("could not encrypt user database: %v", err)
+	}
+}
+
+func openAuditRepo(connexion *sqlx.DB, config system.Config, secrets system.Secrets) *repos.AuditRepository {
+	auditRepo, err := repos.NewAuditRepository(connexion)
+	if err != nil {
+		log.Panicf("could not initate audit repository: %v", err)
+	}
+
+	// Entries written before the audit secret existed are hashed again with it
+	if secrets.AuditCreated {
+		rekeyed, err := auditRepo.Rekey(context.Background(), secrets.Audit)
+		if err != nil {
+			log.Panicf("could not key audit log hashes: %v", err)
+		}
+
+		log.Printf("Audit: %d entries hashed with the new audit secret", rekeyed)
+	} else {
+		auditRepo.SetKey(secrets.Audit)
+	}
+
+	auditRepo.SetTimeouts(storageTimeouts(config))
+
+	return auditRepo
+}
+
+// openSessionRepo returns the session repository, sessions are encrypted along with users.
+func openSessionRepo(connexion *sqlx.DB, config system.Config, userRepo *repos.UserRepository) *repos.SessionRepository {
+	sessionRepo, err := repos.NewSessionRepository(connexion)
+	if err != nil {
+		log.Panicf("could not initate session repository: %v", err)
+	}
+
+	sessionRepo.SetTimeouts(storageTimeouts(config))
+	sessionRepo.SetLifetime(config.Security.SessionLifetime)
+
+	if cipher := userRepo.Cipher(); cipher != nil {
+		if err := sessionRepo.SetCipher(context.Background(), cipher); err != nil {
+			log.Panicf("could not encrypt sessions: %v", err)
+		}
+	}
+
+	return sessionRepo
+}
+
+func openAPITokenRepo(connexion *sqlx.DB, config system.Config) *repos.APITokenRepository {
+	tokenRepo, err := repos.NewAPITokenRepository(connexion)
+	if err != nil {
+		log.Panicf("could not initate api token repository: %v", err)
+	}
+
+	tokenRepo.SetTimeouts(storageTimeouts(config))
+
+	return tokenRepo
+}
+
+// newKeyRotation returns the key set signing access tokens with its rotation, or nil for both with HS256.
+// The overlap must outlast the tokens signed by the previous key, including the time the rotation may be late.
+func newKeyRotation(config system.Config, connexion *sqlx.DB, jwtSecret string) (*helpers.KeySet, *jobs.KeyRotation) {
+	signing := config.Security.Signing
+	if signing.Algorithm == helpers.SigningAlgorithmHS256 {
+		return nil, nil
+	}
+
+	if !helpers.IsAsymmetricAlgorithm(signing.Algorithm) {
+		log.Panicf("invalid security.signing algorithm %s, expected ES256, EdDSA or HS256", signing.Algorithm)
+	}
+
+	// The previous key must verify the tokens it signed until the new key, published JWKSMaxAge before, replaces it
+	minOverlap := helpers.AuthClaimsDuration + keyRotationCheckInterval + helpers.JWKSMaxAge
+	if signing.RotationInterval <= 0 || signing.Overlap < minOverlap {
+		log.Panicf("invalid security.signing: rotation-interval must be positive and overlap at least %v", minOverlap)
+	}
+
+	keyRepo, err := repos.NewSigningKeyRepository(connexion, jwtSecret)
+	if err != nil {
+		log.Panicf("could not initate signing key repository: %v", err)
+	}
+
+	keyRepo.SetTimeouts(storageTimeouts(config))
+
+	keys := helpers.NewKeySet()
+
+	return keys, jobs.NewKeyRotation(keyRepo, keys, signing.Algorithm, signing.RotationInterval, signing.Overlap)
+}
+
+// newReaper returns the reaper applying the configured policy, or nil when it is disabled.
+// The policy is only validated when the reaper is enabled.
+func newReaper(config system.Config, userRepo *repos.UserRepository, auditRepo *repos.AuditRepository) *jobs.Reaper {
+	if !config.Reaper.Enabled {
+		return nil
+	}
+
+	policy := jobs.ReaperPolicy{
+		InactiveAfter: config.Reaper.InactiveAfter,
+		WarnBefore:    config.Reaper.WarnBefore,
+		Action:        jobs.ReapAction(config.Reaper.Action),
+		Exclude:       config.Reaper.Exclude,
+	}
+
+	reaper, err := jobs.NewReaper(userRepo, auditRepo, policy, nil)
+	if err != nil {
+		log.Panicf("invalid reaper configuration: %v", err)
+	}
+
+	return reaper
+}
+
+func newSnapshotter(config system.Config, snapshotRepo *repos.SnapshotRepository) *jobs.Snapshotter {
+	policy := jobs.SnapshotPolicy{
+		Directory: config.Snapshots.Directory,
+		Retention: config.Snapshots.Retention,
+	}
+
+	snapshotter, err := jobs.NewSnapshotter(snapshotRepo, policy)
+	if err != nil {
+		log.Panicf("invalid snapshots configuration: %v", err)
+	}
+
+	return snapshotter
+}
+
+func newGroupMapping(config system.Config) *helpers.GroupMapping {
+	rules := make([]helpers.GroupRule, 0, len(config.Groups.Mappings))
+	for _, mapping := range config.Groups.Mappings {
+		rules = append(rules, helpers.GroupRule{Group: mapping.Group, Role: mapping.Role, RadiusGroup: mapping.RadiusGroup})
+	}
+
+	groupMapping, err := helpers.NewGroupMapping(config.Groups.RadiusAttribute, rules)
+	if err != nil {
+		log.Panicf("invalid groups configuration: %v", err)
+	}
+
+	return groupMapping
+}
+
+// newWorkspaceDirectory returns the Google Workspace directory, or nil when no service account is configured.
+func newWorkspaceDirectory(config system.Config) *services.WorkspaceDirectory {
+	if len(config.Directory.ServiceAccountFile) == 0 {
+		return nil
+	}
+
+	serviceAccountJSON, err := os.ReadFile(config.Directory.ServiceAccountFile)
+	if err != nil {
+		log.Panicf("could not read directory service account: %v", err)
+	}
+
+	directory, err := services.NewWorkspaceDirectory(http.DefaultClient, serviceAccountJSON, config.Directory.AdminEmail)
+	if err != nil {
+		log.Panicf("invalid directory configuration: %v", err)
+	}
+
+	return directory
+}
+
+// newDirectorySync returns the directory sync of the allowed domain, or nil without a directory to sync with.
+func newDirectorySync(config system.Config, userRepo *repos.UserRepository, auditRepo *repos.AuditRepository, directory *services.WorkspaceDirectory) *jobs.DirectorySync {
+	if directory == nil || len(config.Security.AllowedDomain) == 0 {
+		if config.Directory.Sync {
+			log.Panicf("invalid directory configuration: sync requires a service-account-file and security.allowed-domain")
+		}
+
+		return nil
+	}
+
+	return jobs.NewDirectorySync(userRepo, auditRepo, directory, config.Security.AllowedDomain, config.Directory.Exclude)
+}
+
+// configuredRoleEmails lists the users granted a role by the configuration, they do not need an allowed group.
+func configuredRoleEmails(config system.Config) []string {
+	emails := append([]string{}, config.Security.AuthorizedAdminEmails...)
+	emails = append(emails, config.Security.HelpdeskEmails...)
+
+	return append(emails, config.Security.AuditorEmails...)
+}
+
+func newReplyAttributes(config system.Config) radiusd.ReplyAttributes {
+	replyAttributes, err := radiusd.NewReplyAttributes(config.Radius.ReplyAttributes)
+	if err != nil {
+		log.Panicf("invalid radius configuration: %v", err)
+	}
+
+	return replyAttributes
+}
+
+// openIPPoolRepo returns the ip pool repository, the emails of leases and reservations are encrypted along with users.
+func openIPPoolRepo(connexion *sqlx.DB, config system.Config, userRepo *repos.UserRepository) *repos.IPPoolRepository {
+	pools := make([]*repos.IPPool, 0, len(config.Radius.IPPools))
+
+	for _, poolConfig := range config.Radius.IPPools {
+		pool, err := repos.NewIPPool(poolConfig.Name, poolConfig.CIDR, poolConfig.Lease)
+		if err != nil {
+			log.Panicf("invalid radius configuration: %v", err)
+		}
+
+		pools = append(pools, pool)
+	}
+
+	poolRepo, err := repos.NewIPPoolRepository(connexion, pools)
+	if err != nil {
+		log.Panicf("could not initate ip pool repository: %v", err)
+	}
+
+	poolRepo.SetTimeouts(storageTimeouts(config))
+	poolRepo.SetMaxUserLeases(config.Radius.MaxUserLeases)
+
+	if cipher := userRepo.Cipher(); cipher != nil {
+		if err := poolRepo.SetCipher(context.Background(), cipher); err != nil {
+			log.Panicf("could not encrypt ip leases: %v", err)
+		}
+	}
+
+	return poolRepo
+}
+
+// newAddressAssigner returns nil when no pool is configured, radius replies then carry no address.
+func newAddressAssigner(config system.Config, poolRepo *repos.IPPoolRepository) *radiusd.AddressAssigner {
+	if len(config.Radius.IPPools) == 0 {
+		return nil
+	}
+
+	addresses, err := radiusd.NewAddressAssigner(poolRepo, config.Radius.DefaultPool, config.Radius.PoolAttribute)
+	if err != nil {
+		log.Panicf("invalid radius configuration: %v", err)
+	}
+
+	return addresses
+}
+
+func newOTPPolicy(config system.Config) *radiusd.OTPPolicy {
+	defaultMode, err := radiusd.ParseOTPMode(config.Radius.OTPMode)
+	if err != nil {
+		log.Panicf("invalid radius configuration: %v", err)
+	}
+
+	clients := make([]radiusd.NASClient, 0, len(config.Radius.NASClients))
+
+	for _, clientConfig := range config.Radius.NASClients {
+		client, err := radiusd.NewNASClient(clientConfig.Name, clientConfig.Address, clientConfig.OTPMode)
+		if err != nil {
+			log.Panicf("invalid radius configuration: %v", err)
+		}
+
+		clients = append(clients, *client)
+	}
+
+	return radiusd.NewOTPPolicy(defaultMode, clients)
+}
+
+func newWebServers(config system.Config, userRepo *repos.UserRepository, auditRepo *repos.AuditRepository, sessionRepo *repos.SessionRepository, revocations *helpers.RevocationList, tokenRepo *repos.APITokenRepository, reaper *jobs.Reaper, snapshotRepo *repos.SnapshotRepository, poolRepo *repos.IPPoolRepository, groups *helpers.GroupMapping, directory *services.WorkspaceDirectory, directorySync *jobs.DirectorySync, keys *helpers.KeySet, jwtSecret string) (*http.Server, *http.Server) {
 	clientAssets := client.Files()
 
 	// HTTPS
 	httpsSrv := httpd.NewHTTPServer(
 		config,
 		userRepo,
+		auditRepo,
+		sessionRepo,
+		revocations,
+		tokenRepo,
+		reaper,
+		snapshotRepo,
+		poolRepo,
+		groups,
+		directory,
+		directorySync,
+		keys,
 		clientAssets,
 		jwtSecret)
 
@@ -86,25 +396,96 @@ func newWebServers(config system.Config, userRepo *repos.UserRepository, jwtSecr
 	return httpsSrv, redirectSrv
 }
 
-func main() {
-	// Load and validate configuration
+func loadConfig() system.Config {
 	viperConf := viper.New()
 	viperConf.SetConfigName("config")       // name of config file (without extension)
 	viperConf.SetConfigType("toml")         // REQUIRED if the config file does not have the extension in the name
 	viperConf.AddConfigPath("/etc/fringe/") // path to look for the config file in
 	viperConf.AddConfigPath(".")            // optionally look for config in the working directory
-	config := system.LoadConfig(viperConf)
+
+	return system.LoadConfig(viperConf)
+}
+
+func main() {
+	// Administration commands run instead of the servers
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// Load and validate configuration
+	config := loadConfig()
 
 	// Get the Secrets
 	secrets := system.LoadSecretsFromFile(config.Storage.SecretsFile)
 
 	// Get User Repository
 	db := openDB(config.Storage.UserDatabaseFile)
-	userRepo := openUserRepo(db)
+	userRepo := openUserRepo(db, config, secrets)
+	auditRepo := openAuditRepo(db, config, secrets)
+	sessionRepo := openSessionRepo(db, config, userRepo)
+	tokenRepo := openAPITokenRepo(db, config)
+
+	// Users disabled or deleted by jobs lose their sessions
+	revocations := httpd.NewRevocationList(sessionRepo)
+	userSessions := jobs.NewUserSessions(sessionRepo, revocations)
+
+	// Background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	reaper := newReaper(config, userRepo, auditRepo)
+	if reaper != nil {
+		reaper.SetSessions(userSessions)
+
+		go reaper.Schedule(jobsCtx, config.Reaper.Interval)
+	}
+
+	snapshotRepo := repos.NewSnapshotRepository(db)
+
+	if config.Snapshots.Enabled {
+		go newSnapshotter(config, snapshotRepo).Schedule(jobsCtx, config.Snapshots.Interval)
+	}
+
+	poolRepo := openIPPoolRepo(db, config, userRepo)
+	addresses := newAddressAssigner(config, poolRepo)
+
+	if addresses != nil {
+		go jobs.NewLeaseExpirer(poolRepo).Schedule(jobsCtx, config.Radius.LeaseExpiryInterval)
+	}
+
+	groups := newGroupMapping(config)
+	directory := newWorkspaceDirectory(config)
+
+	if groups.Enabled() && directory != nil {
+		groupSync := jobs.NewGroupSync(userRepo, auditRepo, directory, groups, configuredRoleEmails(config))
+		groupSync.SetSessions(userSessions)
+
+		go groupSync.Schedule(jobsCtx, config.Groups.SyncInterval)
+	} else if groups.Enabled() {
+		log.Printf("Groups: no directory configured, Google accounts have no groups and groups are only refreshed at login")
+	}
+
+	directorySync := newDirectorySync(config, userRepo, auditRepo, directory)
+	if directorySync != nil {
+		directorySync.SetSessions(userSessions)
+	}
+
+	if config.Directory.Sync {
+		go directorySync.Schedule(jobsCtx, config.Directory.SyncInterval)
+	}
+
+	// Tokens cannot be signed before the keys are loaded
+	keys, keyRotation := newKeyRotation(config, db, secrets.JWT)
+
+	if keyRotation != nil {
+		if _, err := keyRotation.Run(context.Background(), time.Now()); err != nil {
+			log.Panicf("could not load signing keys: %v", err)
+		}
+
+		go keyRotation.Schedule(jobsCtx, keyRotationCheckInterval)
+	}
 
 	// Servers
-	radiusSrv := radiusd.NewRadiusServer(userRepo, secrets.Radius, config.Services.RadiusBindAddress)
-	httpsSrv, redirectSrv := newWebServers(config, userRepo, secrets.JWT)
+	radiusSrv := radiusd.NewRadiusServer(userRepo, secrets.Radius, config.Services.RadiusBindAddress, newReplyAttributes(config), addresses, newOTPPolicy(config))
+	httpsSrv, redirectSrv := newWebServers(config, userRepo, auditRepo, sessionRepo, revocations, tokenRepo, reaper, snapshotRepo, poolRepo, groups, directory, directorySync, keys, secrets.JWT)
 
 	// Start Radius
 	go func() {
@@ -113,6 +494,19 @@ func main() {
 		}
 	}()
 
+	// Start Radius accounting, only needed to release leased addresses
+	var accountingSrv *radius.PacketServer
+
+	if addresses != nil {
+		accountingSrv = radiusd.NewAccountingServer(addresses, secrets.Radius, config.Services.RadiusAccountingBindAddress)
+
+		go func() {
+			if err := accountingSrv.ListenAndServe(); err != nil {
+				log.Panicf("radius accounting server died with error: %v", err)
+			}
+		}()
+	}
+
 	// Start HTTPS
 	go func() {
 		if err := httpsSrv.ListenAndServeTLS("", ""); err != nil {
@@ -127,10 +521,10 @@ func main() {
 		}
 	}()
 
-	waitOn(httpsSrv, redirectSrv, radiusSrv, db)
+	waitOn(httpsSrv, redirectSrv, []*radius.PacketServer{radiusSrv, accountingSrv}, db, stopJobs)
 }
 
-func waitOn(httpSrv *http.Server, redirectSrv *http.Server, radiusSrv *radius.PacketServer, connexion *sqlx.DB) {
+func waitOn(httpSrv *http.Server, redirectSrv *http.Server, radiusSrvs []*radius.PacketServer, connexion *sqlx.DB, stopJobs context.CancelFunc) {
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT or SIGTERM
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -144,8 +538,13 @@ func waitOn(httpSrv *http.Server, redirectSrv *http.Server, radiusSrv *radius.Pa
 	defer cancel()
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
+	stopJobs()
 	_ = httpSrv.Shutdown(ctx)
-	_ = radiusSrv.Shutdown(ctx)
+	for _, radiusSrv := range radiusSrvs {
+		if radiusSrv != nil {
+			_ = radiusSrv.Shutdown(ctx)
+		}
+	}
 	_ = redirectSrv.Shutdown(ctx)
 	_ = connexion.Close()
 